@@ -0,0 +1,410 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// abuseRecentTitles is the number of titles remembered per user to spot the repeated
+// ones.
+const abuseRecentTitles = 20
+
+// maxReportedAbusers is the number of users listed to the operators.
+const maxReportedAbusers = 50
+
+// abuseRecord is the write pattern of a user. The score decays over time, halving
+// every half-life, and grows with the burst creations and the repeated titles.
+type abuseRecord struct {
+	score   float64
+	updated time.Time
+	// writes holds the times of the writes within the burst window.
+	writes []time.Time
+	// titles holds the hashes of the latest titles, oldest first.
+	titles []uint64
+	// stepUp is set once the score reached the step-up threshold, until the user
+	// verifies again or the score decays below the threshold.
+	stepUp      bool
+	frozenUntil time.Time
+	lastWrite   time.Time
+}
+
+// decay brings the score down to the given time.
+func (rec *abuseRecord) decay(now time.Time, halfLife time.Duration) {
+	if !rec.updated.IsZero() && halfLife > 0 {
+		rec.score *= math.Exp2(-now.Sub(rec.updated).Seconds() / halfLife.Seconds())
+	}
+	rec.updated = now
+}
+
+// settle decays the score of the record to the given time, and lifts its step-up once
+// the score is back below the threshold.
+func (s *abuseScorer) settle(rec *abuseRecord, now time.Time) {
+	rec.decay(now, s.halfLife)
+	if rec.stepUp && rec.score < s.stepUpScore {
+		rec.stepUp = false
+	}
+}
+
+// abuseVerdict is the outcome of a write checked by the abuse scorer.
+type abuseVerdict int
+
+const (
+	abuseAllowed abuseVerdict = iota
+	abuseStepUp
+	abuseFrozen
+)
+
+// abuseScorer scores the write patterns of the users to catch the scripted abuse the
+// daily quotas let through: bursts of creations and identical titles created over and
+// over. Users reaching the step-up score must verify their password again before
+// writing, users reaching the freeze score cannot write for a while. Like the rate
+// limiter, the scores are kept in memory, per instance.
+type abuseScorer struct {
+	burstWindow     time.Duration
+	burstLimit      int
+	burstPoints     float64
+	duplicatePoints float64
+	halfLife        time.Duration
+	stepUpScore     float64
+	freezeScore     float64
+	freezeDuration  time.Duration
+
+	mu       sync.Mutex
+	users    map[uuid.UUID]*abuseRecord
+	stepUps  int64
+	freezes  int64
+	rejected int64
+}
+
+func newAbuseScorer(cfg config) *abuseScorer {
+	return &abuseScorer{
+		burstWindow:     cfg.abuse.burstWindow,
+		burstLimit:      cfg.abuse.burstLimit,
+		burstPoints:     cfg.abuse.burstPoints,
+		duplicatePoints: cfg.abuse.duplicatePoints,
+		halfLife:        cfg.abuse.halfLife,
+		stepUpScore:     cfg.abuse.stepUpScore,
+		freezeScore:     cfg.abuse.freezeScore,
+		freezeDuration:  cfg.abuse.freezeDuration,
+		users:           make(map[uuid.UUID]*abuseRecord),
+	}
+}
+
+// titleHash returns the hash of the title, ignoring the case and the spacing, zero for
+// an empty title.
+func titleHash(title string) uint64 {
+	title = strings.Join(strings.Fields(strings.ToLower(title)), " ")
+	if title == "" {
+		return 0
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(title))
+	return h.Sum64()
+}
+
+// record scores a write of the user creating a record with the given title, which may
+// be empty, and returns whether the write is allowed. Rejected writes are scored too,
+// so that a script retrying ends up frozen. The returned duration is how long the
+// writes stay frozen.
+func (s *abuseScorer) record(
+	user uuid.UUID,
+	title string,
+	now time.Time,
+) (abuseVerdict, time.Duration, float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.users[user]
+	if !ok {
+		rec = &abuseRecord{}
+		s.users[user] = rec
+	}
+	s.settle(rec, now)
+	rec.lastWrite = now
+
+	if now.Before(rec.frozenUntil) {
+		s.rejected++
+		return abuseFrozen, rec.frozenUntil.Sub(now), rec.score
+	}
+
+	rec.writes = slices.DeleteFunc(rec.writes, func(t time.Time) bool {
+		return now.Sub(t) >= s.burstWindow
+	})
+	rec.writes = append(rec.writes, now)
+	if len(rec.writes) > s.burstLimit {
+		rec.score += s.burstPoints
+	}
+
+	if hash := titleHash(title); hash != 0 {
+		if slices.Contains(rec.titles, hash) {
+			rec.score += s.duplicatePoints
+		}
+		rec.titles = append(rec.titles, hash)
+		if len(rec.titles) > abuseRecentTitles {
+			rec.titles = rec.titles[len(rec.titles)-abuseRecentTitles:]
+		}
+	}
+
+	switch {
+	case s.freezeScore > 0 && rec.score >= s.freezeScore:
+		rec.frozenUntil = now.Add(s.freezeDuration)
+		rec.stepUp = false
+		s.freezes++
+		s.rejected++
+		return abuseFrozen, s.freezeDuration, rec.score
+	case s.stepUpScore > 0 && rec.score >= s.stepUpScore && !rec.stepUp:
+		rec.stepUp = true
+		s.stepUps++
+	}
+
+	if rec.stepUp {
+		s.rejected++
+		return abuseStepUp, 0, rec.score
+	}
+
+	return abuseAllowed, 0, rec.score
+}
+
+// verify clears the step-up of the user, who verified their password again. The score
+// is reset, the repeated titles and bursts having been made by the user.
+func (s *abuseScorer) verify(user uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec, ok := s.users[user]; ok {
+		rec.stepUp = false
+		rec.score = 0
+	}
+}
+
+// reset forgets the write pattern of the user, lifting a freeze.
+func (s *abuseScorer) reset(user uuid.UUID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.users[user]
+	delete(s.users, user)
+	return ok
+}
+
+// cleanup forgets the users who are not frozen, and whose score has decayed away since
+// their last write, lifting their step-up along the way.
+func (s *abuseScorer) cleanup() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for user, rec := range s.users {
+		s.settle(rec, now)
+		decayed := rec.score < 1 && now.Sub(rec.lastWrite) > s.burstWindow
+		if decayed && now.After(rec.frozenUntil) && !rec.stepUp {
+			delete(s.users, user)
+		}
+	}
+}
+
+// abuser is a scored user reported to the operators.
+type abuser struct {
+	UserUUID    uuid.UUID `json:"user_uuid"`
+	Score       float64   `json:"score"`
+	StepUp      bool      `json:"step_up"`
+	FrozenUntil time.Time `json:"frozen_until,omitzero"`
+	LastWrite   time.Time `json:"last_write"`
+}
+
+// abusers returns the users with a score, the highest first.
+func (s *abuseScorer) abusers() []abuser {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	abusers := []abuser{}
+	for user, rec := range s.users {
+		s.settle(rec, now)
+		frozen := now.Before(rec.frozenUntil)
+		if rec.score < 1 && !rec.stepUp && !frozen {
+			continue
+		}
+
+		a := abuser{
+			UserUUID:  user,
+			Score:     math.Round(rec.score*10) / 10,
+			StepUp:    rec.stepUp,
+			LastWrite: rec.lastWrite,
+		}
+		if frozen {
+			a.FrozenUntil = rec.frozenUntil
+		}
+		abusers = append(abusers, a)
+	}
+	slices.SortFunc(abusers, func(a, b abuser) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	if len(abusers) > maxReportedAbusers {
+		abusers = abusers[:maxReportedAbusers]
+	}
+
+	return abusers
+}
+
+// stats returns the scorer statistics in a form suitable for publishing through
+// expvar.
+func (s *abuseScorer) stats() any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return map[string]any{
+		"tracked_users": len(s.users),
+		"step_ups":      s.stepUps,
+		"freezes":       s.freezes,
+		"rejected":      s.rejected,
+	}
+}
+
+// checkAbuse scores the creation of a record with the given title by the user and
+// reports whether it may go on, otherwise the rejection has been sent. Users exempted
+// from the quotas are not scored.
+func (app *application) checkAbuse(
+	w http.ResponseWriter,
+	r *http.Request,
+	user *data.User,
+	resource, title string,
+) bool {
+	if app.abuse == nil || app.quotaExempt(r, user) {
+		return true
+	}
+
+	verdict, frozenFor, score := app.abuse.record(user.UUID, title, time.Now())
+	switch verdict {
+	case abuseStepUp:
+		app.securityEvent(
+			r,
+			eventAbuseStepUp,
+			outcomeFailure,
+			user.UUID,
+			"resource_type", resource,
+			"score", score,
+		)
+		app.verificationRequiredResponse(w, r)
+		return false
+	case abuseFrozen:
+		app.securityEvent(
+			r,
+			eventAbuseFreeze,
+			outcomeFailure,
+			user.UUID,
+			"resource_type", resource,
+			"score", score,
+			"frozen_for", frozenFor.String(),
+		)
+		app.writesFrozenResponse(w, r, frozenFor)
+		return false
+	}
+
+	return true
+}
+
+// verifyUserHandler lets a user asked for a step-up verification confirm their
+// password, or sign in again with an identity provider linked to their account, after
+// which they may write again. Users without a password verify with the identity provider.
+func (app *application) verifyUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+		Provider string `json:"provider"`
+		oauthCode
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	switch input.Provider {
+	case "":
+		data.ValidatePasswordPlaintext(v, input.Password)
+	case data.ProviderGoogle:
+		v.Struct(&input.oauthCode)
+	default:
+		v.AddFieldError("provider", validator.NotPermitted("must be google"))
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	var match bool
+	switch input.Provider {
+	case data.ProviderGoogle:
+		google := app.config.oauth.google
+		if google.clientID == "" || google.redirectURL == "" {
+			app.notFoundResponse(w, r)
+			return
+		}
+		match, err = app.verifyGoogleIdentity(r.Context(), user, input.Code, input.CodeVerifier)
+		if errors.Is(err, errOAuthRejected) {
+			match, err = false, nil
+		}
+	default:
+		match, err = user.Password.Matches(input.Password, app.config.pepper)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		app.securityEvent(r, eventAbuseVerify, outcomeFailure, user.UUID)
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	app.abuse.verify(user.UUID)
+	app.securityEvent(r, eventAbuseVerify, outcomeSuccess, user.UUID)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "verification completed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listAbusersHandler lists the users with an abuse score to the operators.
+func (app *application) listAbusersHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"abusers": app.abuse.abusers()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// resetAbuserHandler lets an operator clear the score of a user, lifting their step-up
+// verification or their freeze.
+func (app *application) resetAbuserHandler(w http.ResponseWriter, r *http.Request) {
+	userUUID := app.contextGetUUIDParam(r)
+	if !app.abuse.reset(userUUID) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	operator := app.contextGetUser(r)
+	app.securityEvent(
+		r,
+		eventAbuseReset,
+		outcomeSuccess,
+		operator.UUID,
+		"target_user_uuid", userUUID,
+	)
+
+	w.WriteHeader(http.StatusNoContent)
+}
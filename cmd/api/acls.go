@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// listACLHandler returns the handler listing every user with access to the record of
+// the given resource type, with their effective role and whether it is inherited from
+// the target or action the record belongs to.
+func (app *application) listACLHandler(resource string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := app.contextGetUUIDParam(r)
+		user := app.contextGetUser(r)
+
+		acls, err := app.models.ACLs.GetAllForResource(r.Context(), resource, id, user.UUID)
+		if err != nil {
+			app.dataErrorResponse(w, r, err)
+			return
+		}
+
+		// The last views of the collaborators are shown to the owners only.
+		owner := slices.ContainsFunc(acls, func(acl *data.ResourceACL) bool {
+			return acl.UserUUID == user.UUID && acl.Role == "owner"
+		})
+		if !owner || !app.config.sharing.viewReceipts {
+			for _, acl := range acls {
+				acl.LastViewedAt = data.NullTime{}
+			}
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"acl": acls}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+// listACLHistoryHandler lists the latest changes to the ACLs of the target and of its
+// actions and sessions to the owners of the target.
+func (app *application) listACLHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+	user := app.contextGetUser(r)
+
+	changes, err := app.models.ACLs.GetHistory(r.Context(), id, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"acl_history": changes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// propagateACLHandler grants the role of a collaborator on the target to them on the
+// listed actions and sessions of the target, as explicit grants which stand on their own
+// should the grant on the target be changed. Another role can be given to override the
+// one inherited from the target. With dry_run=true, the grants which would be made are
+// returned instead. Only the owners of the target can propagate its grants.
+func (app *application) propagateACLHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		UserUUID uuid.UUID   `json:"user_uuid"`
+		Role     string      `json:"role"`
+		Actions  []uuid.UUID `json:"actions"`
+		Sessions []uuid.UUID `json:"sessions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	propagation := data.ACLPropagation{
+		TargetUUID:  app.contextGetUUIDParam(r),
+		GranteeUUID: input.UserUUID,
+		Role:        input.Role,
+		Actions:     input.Actions,
+		Sessions:    input.Sessions,
+	}
+
+	v := validator.New()
+	dryRun := app.readBool(r.URL.Query(), "dry_run", v)
+	if data.ValidateACLPropagation(v, &propagation); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+	preview := dryRun != nil && *dryRun
+
+	user := app.contextGetUser(r)
+	grants, err := app.models.PropagateACL(r.Context(), &propagation, user.UUID, preview)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNotShared):
+			v.AddFieldError("user_uuid", validator.Invalid("must have a role on the target"))
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.dataErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if preview {
+		env := envelope{"preview": envelope{"count": len(grants), "grants": grants}}
+		err := app.writeJSON(w, http.StatusOK, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	for _, grant := range grants {
+		event := eventACLGrant
+		if grant.OldRole != "" {
+			event = eventACLChange
+		}
+		app.securityEvent(
+			r,
+			event,
+			outcomeSuccess,
+			user.UUID,
+			"resource_type", grant.ResourceType,
+			"resource_uuid", grant.ResourceUUID,
+			"grantee_uuid", propagation.GranteeUUID,
+			"old_role", grant.OldRole,
+			"role", grant.NewRole,
+			"propagated_from", propagation.TargetUUID,
+		)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"grants": grants}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// permissionsHandler returns the effective role of the user on the record named by the
+// resource_type and uuid query parameters, with what the role lets them do, so that
+// clients know which controls to offer without trying and failing.
+func (app *application) permissionsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	resource := qs.Get("resource_type")
+
+	v := validator.New()
+	v.CheckField(resource != "", "resource_type", validator.Required())
+	v.CheckField(
+		validator.PermittedValue(resource, data.PermissionsResourceSafelist...),
+		"resource_type",
+		validator.NotPermitted("must be one of target, action, session"),
+	)
+	id, err := uuid.FromString(qs.Get("uuid"))
+	if err != nil || id.Version() != uuid.V7 {
+		v.AddFieldError("uuid", validator.InvalidFormat("must be a valid record UUID"))
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	permissions, err := app.models.ACLs.GetPermissions(r.Context(), resource, id, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
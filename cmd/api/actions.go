@@ -1,9 +1,8 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"errors"
-	"fmt"
 	"net/http"
 	"strings"
 	"time"
@@ -16,12 +15,15 @@ import (
 
 func (app *application) createActionHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		TargetUUID  uuid.UUID      `json:"target_uuid"`
-		DueDate     data.InputDate `json:"due_date"`
-		Title       string         `json:"title"`
-		Description string         `json:"description"`
-		Notes       string         `json:"notes"`
-		Status      data.Status    `json:"status"`
+		TargetUUID      uuid.UUID      `json:"target_uuid"`
+		DueDate         data.InputDate `json:"due_date"`
+		DueTimezone     string         `json:"due_timezone"`
+		Title           string         `json:"title"`
+		Description     string         `json:"description"`
+		Notes           string         `json:"notes"`
+		Status          data.Status    `json:"status"`
+		Tags            []string       `json:"tags"`
+		EstimateMinutes int            `json:"estimate_minutes"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -31,105 +33,160 @@ func (app *application) createActionHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	action := data.Action{
-		TargetUUID:  input.TargetUUID,
-		DueDate:     sql.NullTime(input.DueDate),
-		Title:       strings.TrimSpace(input.Title),
-		Description: strings.TrimSpace(input.Description),
-		Notes:       input.Notes,
-		Status:      input.Status,
+		TargetUUID:      input.TargetUUID,
+		DueDate:         input.DueDate.In(input.DueTimezone).NullTime(),
+		DueTimezone:     input.DueTimezone,
+		Title:           strings.TrimSpace(input.Title),
+		Description:     strings.TrimSpace(input.Description),
+		Notes:           input.Notes,
+		Status:          input.Status,
+		Tags:            data.NormalizeTags(input.Tags),
+		EstimateMinutes: input.EstimateMinutes,
 	}
 
 	v := validator.New()
 	if data.ValidateAction(v, &action, "create"); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
+	if !app.createAction(w, r, &action) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", app.apiPath(r, "/actions/%s", action.UUID))
+
+	env := envelope{"action": newActionResponse(&action, true)}
+	err = app.writeJSON(w, http.StatusCreated, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createAction creates the validated action for the user, after the checks of its
+// creation: the daily quota, the access to its target and the abuse score. It reports
+// whether the action was created, the response has been sent otherwise.
+func (app *application) createAction(
+	w http.ResponseWriter,
+	r *http.Request,
+	action *data.Action,
+) bool {
 	user := app.contextGetUser(r)
 
 	quota := data.DailyQuota{
 		UsageDate: time.Now().UTC(),
 		Resource:  "action",
 		Limit:     app.config.user.dailyActionsCreationLimit,
+		Exempt:    app.quotaExempt(r, user),
+	}
+	access := func(ctx context.Context) error {
+		return app.models.ACLs.Check(ctx, "target", action.TargetUUID, user.UUID, "editor")
+	}
+	if app.validateOnly(w, r, &quota, access) {
+		return false
+	}
+	if !app.checkAbuse(w, r, user, "action", action.Title) {
+		return false
 	}
 
-	err = app.models.CreateAction(&action, &quota, user.UUID)
+	err := app.models.CreateAction(r.Context(), action, &quota, user.UUID)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		case errors.Is(err, data.ErrQuotaExceeded):
-			msg := fmt.Sprintf(
-				"action creation quota reached (%d per day, renew on midnight UTC)",
-				quota.Limit,
-			)
-			app.quotaExceededResponse(w, r, msg)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
-		return
+		app.dataErrorResponse(w, r, err)
+		return false
 	}
 
-	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/actions/%s", action.UUID))
+	app.securityEvent(
+		r,
+		eventACLGrant,
+		outcomeSuccess,
+		user.UUID,
+		"resource_type", "action",
+		"resource_uuid", action.UUID,
+		"role", "owner",
+	)
+
+	app.applyDerivedStatus(r, action, user.UUID)
+
+	return true
+}
+
+// applyDerivedStatus moves the target of a started action to in progress for the users
+// who opted in to applying the derived status. Failing to do so does not fail the
+// request, the action itself has been saved.
+func (app *application) applyDerivedStatus(
+	r *http.Request,
+	action *data.Action,
+	userUUID uuid.UUID,
+) {
+	if action.Status != data.StatusInProgress && action.Status != data.StatusComplete {
+		return
+	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"action": action}, headers)
+	preferences, err := app.models.UserPreferences.Get(r.Context(), userUUID)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			app.logError(r, err)
+		}
+		return
+	}
+	if !preferences.Status.AutoApplyDerived {
+		return
+	}
+
+	if _, err := app.models.Targets.Start(r.Context(), action.TargetUUID, userUUID); err != nil {
+		app.logError(r, err)
 	}
 }
 
 func (app *application) showActionHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readUUIDParam(r)
+	id := app.contextGetUUIDParam(r)
+
+	user := app.contextGetUser(r)
+	action, err := app.models.Actions.Get(r.Context(), id, user.UUID, "viewer")
 	if err != nil {
-		app.notFoundResponse(w, r)
+		app.dataErrorResponse(w, r, err)
 		return
 	}
 
-	user := app.contextGetUser(r)
-	action, err := app.models.Actions.Get(id, user.UUID, "viewer")
+	res := newActionResponse(action, true)
+	cv, err := newResourceValidators(res, action.UpdatedAt)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if cv.notModified(r) {
+		app.notModifiedResponse(w, cv)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"action": action}, nil)
+	env := envelope{"action": res}
+	err = app.writeJSON(w, http.StatusOK, env, cv.headers())
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) updateActionHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readUUIDParam(r)
-	if err != nil {
-		app.notFoundResponse(w, r)
-		return
-	}
+	id := app.contextGetUUIDParam(r)
 
 	user := app.contextGetUser(r)
-	action, err := app.models.Actions.Get(id, user.UUID, "editor")
+	action, err := app.models.Actions.Get(r.Context(), id, user.UUID, "editor")
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.dataErrorResponse(w, r, err)
 		return
 	}
 
 	var input struct {
-		Title       *string         `json:"title"`
-		Description *string         `json:"description"`
-		Notes       *string         `json:"notes"`
-		DueDate     *data.InputDate `json:"due_date"`
-		Status      *data.Status    `json:"status"`
-		TargetUUID  *uuid.UUID      `json:"target_uuid"`
+		Title           *string         `json:"title"`
+		Description     *string         `json:"description"`
+		Notes           *string         `json:"notes"`
+		DueDate         *data.InputDate `json:"due_date"`
+		DueTimezone     *string         `json:"due_timezone"`
+		Status          *data.Status    `json:"status"`
+		TargetUUID      *uuid.UUID      `json:"target_uuid"`
+		Tags            *[]string       `json:"tags"`
+		EstimateMinutes *int            `json:"estimate_minutes"`
 	}
 	err = app.readJSON(w, r, &input)
 	if err != nil {
@@ -146,8 +203,11 @@ func (app *application) updateActionHandler(w http.ResponseWriter, r *http.Reque
 	if input.Notes != nil {
 		action.Notes = *input.Notes
 	}
+	if input.DueTimezone != nil {
+		action.DueTimezone = *input.DueTimezone
+	}
 	if input.DueDate != nil {
-		action.DueDate = sql.NullTime(*input.DueDate)
+		action.DueDate = input.DueDate.In(action.DueTimezone).NullTime()
 	}
 	if input.Status != nil {
 		action.Status = *input.Status
@@ -155,57 +215,67 @@ func (app *application) updateActionHandler(w http.ResponseWriter, r *http.Reque
 	if input.TargetUUID != nil {
 		action.TargetUUID = *input.TargetUUID
 	}
+	if input.Tags != nil {
+		action.Tags = data.NormalizeTags(*input.Tags)
+	}
+	if input.EstimateMinutes != nil {
+		action.EstimateMinutes = *input.EstimateMinutes
+	}
 
 	v := validator.New()
 	if data.ValidateAction(v, action, "update"); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+	// Moving the action requires editing the target it is moved to.
+	access := func(ctx context.Context) error {
+		if input.TargetUUID == nil {
+			return nil
+		}
+		_, err := app.models.Targets.Get(ctx, *input.TargetUUID, user.UUID, "editor")
+		return err
+	}
+	if app.validateOnly(w, r, nil, access) {
 		return
 	}
 
 	fts := data.GenFTS(
 		action.Title,
 		action.Description,
-		action.Notes,
-		app.models.Actions.Jieba,
+		app.models.Actions.Cipher.Searchable(action.Notes),
+		app.models.Actions.Segmenter,
 	)
 
-	err = app.models.Actions.Update(action, fts, user.UUID)
+	err = app.models.Actions.Update(r.Context(), action, fts, user.UUID)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.dataErrorResponse(w, r, err)
 		return
 	}
+	app.applyDerivedStatus(r, action, user.UUID)
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"action": action}, nil)
+	env := envelope{"action": newActionResponse(action, true)}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) deleteActionHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readUUIDParam(r)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
-		return
-	}
+	id := app.contextGetUUIDParam(r)
 
 	user := app.contextGetUser(r)
-	err = app.models.Actions.Delete(id, user.UUID)
+	undo := app.undoOperation("action")
+	err := app.models.DeleteAction(r.Context(), id, user.UUID, app.quotaRefund("action"), undo)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.dataErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "action successfully deleted"}, nil)
+	env := envelope{"message": "action successfully deleted"}
+	if undo != nil {
+		env["undo"] = undo
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -220,25 +290,51 @@ func (app *application) listActionsHandler(w http.ResponseWriter, r *http.Reques
 	v := validator.New()
 
 	qs := r.URL.Query()
-	statuses := app.readCSV(qs, "status", []string{})
+	statuses := app.readCSV(qs, "status", []string{}, v)
 
 	input.search = app.readString(qs, "search", "")
 	input.Filters.Status = data.StringSliceToStatusSlice(statuses)
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Filters.Sort = app.readString(qs, "sort", "-last_active")
+	input.Filters.Sort = app.readString(qs, "sort", defaultRecordSort(input.search))
+	input.Filters.DueFrom = app.readDate(qs, "due_from", v)
+	input.Filters.DueTo = app.readDate(qs, "due_to", v)
+	input.Filters.HasNotes = app.readBool(qs, "has_notes", v)
+	input.Filters.Role = app.readRoles(qs, v)
+	if includeArchived := app.readBool(qs, "include_archived", v); includeArchived != nil {
+		input.Filters.IncludeArchived = *includeArchived
+	}
 	input.Filters.SortSafelist = data.SortSafelist
 	input.Filters.StatusSafelist = data.StatusFilterSafelist
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	t := tokenizer.New(input.search, app.models.Actions.Jieba)
+	t := tokenizer.New(input.search, app.models.Actions.Segmenter)
 
 	user := app.contextGetUser(r)
+	fp, err := app.models.Actions.Fingerprint(
+		r.Context(),
+		*t,
+		input.Filters,
+		uuid.NullUUID{Valid: false},
+		user.UUID,
+	)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	cv := newCollectionValidators(r, fp)
+	if cv.notModified(r) {
+		app.notModifiedResponse(w, cv)
+		return
+	}
+
 	actions, metadata, err := app.models.Actions.GetAll(
+		r.Context(),
 		*t,
 		input.Filters,
 		uuid.NullUUID{Valid: false},
@@ -249,11 +345,15 @@ func (app *application) listActionsHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	metadata.Filters.Search = input.search
+	metadata.Links = app.pageLinks(r, metadata)
+	setLinkHeader(w, metadata.Links)
+
 	err = app.writeJSON(
 		w,
 		http.StatusOK,
-		envelope{"actions": actions, "metadata": metadata},
-		nil,
+		envelope{"actions": newActionsResponse(actions), "metadata": metadata},
+		cv.headers(),
 	)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -262,11 +362,7 @@ func (app *application) listActionsHandler(w http.ResponseWriter, r *http.Reques
 }
 
 func (app *application) listActionSessionsHandler(w http.ResponseWriter, r *http.Request) {
-	actionUUID, err := app.readUUIDParam(r)
-	if err != nil {
-		app.notFoundResponse(w, r)
-		return
-	}
+	actionUUID := app.contextGetUUIDParam(r)
 
 	var input struct {
 		search  string
@@ -276,29 +372,52 @@ func (app *application) listActionSessionsHandler(w http.ResponseWriter, r *http
 	v := validator.New()
 
 	qs := r.URL.Query()
-	statuses := app.readCSV(qs, "status", []string{})
+	statuses := app.readCSV(qs, "status", []string{}, v)
 
 	input.search = app.readString(qs, "search", "")
 	input.Filters.Status = data.StringSliceToStatusSlice(statuses)
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "-updated_at")
+	input.Filters.Source = app.readCSV(qs, "source", []string{}, v)
+	input.Filters.Role = app.readRoles(qs, v)
 
 	input.Filters.SortSafelist = data.SessionSortSafelist
 	input.Filters.Status = data.SessionStatusSafelist
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	t := tokenizer.New(input.search, app.models.Sessions.Jieba)
+	t := tokenizer.New(input.search, app.models.Sessions.Segmenter)
 
 	user := app.contextGetUser(r)
+	fp, err := app.models.Sessions.Fingerprint(
+		r.Context(),
+		*t,
+		input.Filters,
+		uuid.NullUUID{Valid: true, UUID: actionUUID},
+		uuid.NullUUID{Valid: false},
+		user.UUID,
+	)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	cv := newCollectionValidators(r, fp)
+	if cv.notModified(r) {
+		app.notModifiedResponse(w, cv)
+		return
+	}
+
 	sessions, metadata, err := app.models.Sessions.GetAll(
+		r.Context(),
 		*t,
 		input.Filters,
 		uuid.NullUUID{Valid: true, UUID: actionUUID},
+		uuid.NullUUID{Valid: false},
 		user.UUID,
 	)
 	if err != nil {
@@ -306,14 +425,69 @@ func (app *application) listActionSessionsHandler(w http.ResponseWriter, r *http
 		return
 	}
 
+	metadata.Filters.Search = input.search
+	metadata.Links = app.pageLinks(r, metadata)
+	setLinkHeader(w, metadata.Links)
+
 	err = app.writeJSON(
 		w,
 		http.StatusOK,
-		envelope{"sessions": sessions, "metadata": metadata},
-		nil,
+		envelope{"sessions": newSessionsResponse(sessions), "metadata": metadata},
+		cv.headers(),
 	)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 }
+
+// reassignActionSessionsHandler moves every session of an action to another action.
+func (app *application) reassignActionSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+
+	var input struct {
+		ActionUUID uuid.UUID `json:"action_uuid"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.CheckField(input.ActionUUID != uuid.Nil, "action_uuid", validator.Required())
+	v.CheckField(input.ActionUUID != id, "action_uuid", validator.Invalid("must be another action"))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	moved, err := app.models.Sessions.Reassign(r.Context(), id, input.ActionUUID, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	app.securityEvent(
+		r,
+		eventACLChange,
+		outcomeSuccess,
+		user.UUID,
+		"resource_type", "session",
+		"from_action_uuid", id,
+		"to_action_uuid", input.ActionUUID,
+		"count", moved,
+	)
+
+	err = app.writeJSON(
+		w,
+		http.StatusOK,
+		envelope{"reassigned": moved, "action_uuid": input.ActionUUID},
+		nil,
+	)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/liuminhaw/yatijapp/internal/mailer"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// startArchiveRoutine periodically evaluates the archive rules of every user. Owners
+// are warned by email when their records enter the notice period of a rule, and the
+// records are archived once it is over.
+func (app *application) startArchiveRoutine() {
+	app.logger.Info("Archive routine started")
+
+	ticker := time.NewTicker(app.config.archive.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		app.logger.Info("Archive routine triggered")
+		app.background(func() {
+			app.runScheduled(jobArchive, app.config.archive.interval, func() {
+				app.sendArchiveNotices(context.Background())
+
+				archived, err := app.models.ArchiveRules.Apply(context.Background())
+				if err != nil {
+					app.logger.Error("Error applying archive rules: " + err.Error())
+					return
+				}
+				app.logger.Info("Archive rules applied", slog.Int64("archived", archived))
+			})
+		})
+	}
+}
+
+// sendArchiveNotices sends a single email per user listing their records about to be
+// archived. Notices are only recorded once the email has been sent, so that a failed
+// delivery is retried on the next run.
+func (app *application) sendArchiveNotices(ctx context.Context) {
+	notices, err := app.models.ArchiveRules.PendingNotices(ctx)
+	if err != nil {
+		app.logger.Error("Error listing archive notices: " + err.Error())
+		return
+	}
+
+	var messages []mailer.BatchMessage
+	var batches [][]data.ArchiveNotice
+	for start := 0; start < len(notices); {
+		end := start + 1
+		for end < len(notices) && notices[end].UserUUID == notices[start].UserUUID {
+			end++
+		}
+		batch := notices[start:end]
+		start = end
+
+		prefs, err := app.reportPreferences(ctx, batch[0].UserUUID)
+		if err != nil {
+			app.logger.Error("Error loading report preferences: " + err.Error())
+		}
+
+		records := make([]map[string]string, len(batch))
+		for i, notice := range batch {
+			records[i] = map[string]string{
+				"type":       notice.ResourceType,
+				"title":      notice.Title,
+				"archivesAt": prefs.FormatDate(notice.ArchivesAt.UTC()),
+			}
+		}
+
+		messages = append(messages, mailer.BatchMessage{
+			Recipient:    batch[0].Email,
+			TemplateFile: "archive_notice.tmpl",
+			Data: map[string]any{
+				"username": batch[0].Name,
+				"records":  records,
+			},
+		})
+		batches = append(batches, batch)
+	}
+
+	errs := app.mailer.SendBatch(ctx, messages, app.config.smtp.batchConcurrency)
+	for i, batch := range batches {
+		if errs[i] != nil {
+			app.logger.Error(errs[i].Error())
+			continue
+		}
+
+		err := app.models.ArchiveRules.MarkNotified(ctx, batch)
+		if err != nil {
+			app.logger.Error("Error recording archive notices: " + err.Error())
+		}
+	}
+}
+
+func (app *application) listArchiveRulesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	rules, err := app.models.ArchiveRules.GetAllForUser(r.Context(), user.UUID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"archive_rules": rules}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createArchiveRuleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ResourceType string      `json:"resource_type"`
+		Status       data.Status `json:"status"`
+		InactiveDays int         `json:"inactive_days"`
+		NoticeDays   *int        `json:"notice_days"`
+		Enabled      *bool       `json:"enabled"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	rule := data.ArchiveRule{
+		ResourceType: input.ResourceType,
+		Status:       input.Status,
+		InactiveDays: input.InactiveDays,
+		NoticeDays:   3,
+		Enabled:      true,
+	}
+	if input.NoticeDays != nil {
+		rule.NoticeDays = *input.NoticeDays
+	}
+	if input.Enabled != nil {
+		rule.Enabled = *input.Enabled
+	}
+
+	v := validator.New()
+	if data.ValidateArchiveRule(v, &rule); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	err = app.models.ArchiveRules.Insert(r.Context(), &rule, user.UUID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", app.apiPath(r, "/users/archive-rules/%s", rule.UUID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"archive_rule": rule}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateArchiveRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+
+	user := app.contextGetUser(r)
+	rule, err := app.models.ArchiveRules.Get(r.Context(), id, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		ResourceType *string      `json:"resource_type"`
+		Status       *data.Status `json:"status"`
+		InactiveDays *int         `json:"inactive_days"`
+		NoticeDays   *int         `json:"notice_days"`
+		Enabled      *bool        `json:"enabled"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.ResourceType != nil {
+		rule.ResourceType = *input.ResourceType
+	}
+	if input.Status != nil {
+		rule.Status = *input.Status
+	}
+	if input.InactiveDays != nil {
+		rule.InactiveDays = *input.InactiveDays
+	}
+	if input.NoticeDays != nil {
+		rule.NoticeDays = *input.NoticeDays
+	}
+	if input.Enabled != nil {
+		rule.Enabled = *input.Enabled
+	}
+
+	v := validator.New()
+	if data.ValidateArchiveRule(v, rule); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.models.ArchiveRules.Update(r.Context(), rule, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"archive_rule": rule}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteArchiveRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+
+	user := app.contextGetUser(r)
+	err := app.models.ArchiveRules.Delete(r.Context(), id, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(
+		w,
+		http.StatusOK,
+		envelope{"message": "archive rule successfully deleted"},
+		nil,
+	)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
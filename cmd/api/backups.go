@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/liuminhaw/yatijapp/internal/platform"
+)
+
+// backupRun is the outcome of a database backup.
+type backupRun struct {
+	Key       string        `json:"key"`
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration"`
+	Size      int64         `json:"size"`
+	Error     string        `json:"error,omitzero"`
+}
+
+// backups runs the database backups, one at a time, and keeps their outcome for the
+// metrics.
+type backups struct {
+	mu          sync.Mutex
+	running     bool
+	succeeded   int64
+	failed      int64
+	last        *backupRun
+	lastSuccess time.Time
+}
+
+// start reports whether a backup may start, none being already running.
+func (b *backups) start() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.running {
+		return false
+	}
+	b.running = true
+	return true
+}
+
+func (b *backups) finish(run backupRun) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.running = false
+	b.last = &run
+	if run.Error != "" {
+		b.failed++
+		return
+	}
+	b.succeeded++
+	b.lastSuccess = run.StartedAt
+}
+
+func (b *backups) lastRun() *backupRun {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.last
+}
+
+func (b *backups) stats() any {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return map[string]any{
+		"running":      b.running,
+		"succeeded":    b.succeeded,
+		"failed":       b.failed,
+		"last":         b.last,
+		"last_success": b.lastSuccess,
+	}
+}
+
+// createBackupHandler starts a backup of the database to the S3 bucket, in the
+// background, and responds with the key the dump is uploaded to.
+func (app *application) createBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.backups.start() {
+		app.errorResponse(w, r, http.StatusConflict, "a backup is already running")
+		return
+	}
+
+	startedAt := time.Now().UTC()
+	key := app.config.backups.prefix + "yatijapp-" + startedAt.Format("20060102T150405Z") + ".dump"
+	app.background(func() {
+		app.runBackup(key, startedAt)
+	})
+
+	err := app.writeJSON(
+		w,
+		http.StatusAccepted,
+		envelope{"backup": backupRun{Key: key, StartedAt: startedAt}},
+		nil,
+	)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listBackupsHandler lists the backups available in the S3 bucket, along with the
+// outcome of the last backup run by this instance.
+func (app *application) listBackupsHandler(w http.ResponseWriter, r *http.Request) {
+	objects, err := platform.ListS3Objects(
+		r.Context(),
+		app.config.backups.bucket,
+		app.config.backups.prefix,
+	)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(
+		w,
+		http.StatusOK,
+		envelope{"backups": objects, "last_run": app.backups.lastRun()},
+		nil,
+	)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runBackup dumps the database and uploads the dump under the key, then records the
+// outcome. Failures are emailed to the alert recipients.
+func (app *application) runBackup(key string, startedAt time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), app.config.backups.timeout)
+	defer cancel()
+
+	size, err := app.dumpDatabase(ctx, key)
+	run := backupRun{
+		Key:       key,
+		StartedAt: startedAt,
+		Duration:  time.Since(startedAt),
+		Size:      size,
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	app.backups.finish(run)
+
+	if err != nil {
+		app.logger.Error("database backup failed", "key", key, "error", err.Error())
+		app.alertBackupFailure(run)
+		return
+	}
+	app.logger.Info("database backup uploaded", "key", key, "size", size, "duration", run.Duration)
+}
+
+// dumpDatabase runs pg_dump into a temporary file, in the custom format expected by
+// pg_restore, and uploads it under the key. It returns the size of the dump.
+func (app *application) dumpDatabase(ctx context.Context, key string) (int64, error) {
+	file, err := os.CreateTemp("", "yatijapp-backup-*.dump")
+	if err != nil {
+		return 0, err
+	}
+	path := file.Name()
+	file.Close()
+	defer os.Remove(path)
+
+	dbname, env := pgDumpTarget(app.config.db.dsn)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(
+		ctx,
+		app.config.backups.pgDump,
+		"--format=custom",
+		"--no-owner",
+		"--file="+path,
+		"--dbname="+dbname,
+	)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("pg_dump: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	file, err = os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	err = platform.UploadS3Object(ctx, app.config.backups.bucket, key, file)
+	if err != nil {
+		return 0, fmt.Errorf("upload: %w", err)
+	}
+
+	return info.Size(), nil
+}
+
+// pgDumpTarget returns the database pg_dump connects to and the environment it needs.
+// The password of a URL DSN is moved to the environment, so that it does not show in
+// the process list.
+func pgDumpTarget(dsn string) (string, []string) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") || u.User == nil {
+		return dsn, nil
+	}
+
+	password, ok := u.User.Password()
+	if !ok {
+		return dsn, nil
+	}
+	u.User = url.User(u.User.Username())
+
+	return u.String(), []string{"PGPASSWORD=" + password}
+}
+
+// alertBackupFailure emails the failed backup to the alert recipients.
+func (app *application) alertBackupFailure(run backupRun) {
+	for _, recipient := range app.config.backups.alertEmails {
+		err := app.mailer.Send(recipient, "backup_failed.tmpl", map[string]any{
+			"key":       run.Key,
+			"startedAt": run.StartedAt.Format(time.RFC3339),
+			"error":     run.Error,
+		})
+		if err != nil {
+			app.logger.Error(
+				"Error sending the backup failure alert",
+				"recipient", recipient,
+				"error", err.Error(),
+			)
+		}
+	}
+}
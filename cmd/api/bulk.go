@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/liuminhaw/yatijapp/internal/tokenizer"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// bulkDeleteHandler returns the handler deleting records of the resource type in bulk,
+// either the ones listed in the uuids of the request body or the ones of the user
+// matching the filters of the query string. With dry_run=true, the records which would
+// be deleted are returned instead. The records are deleted in a single transaction, all
+// of them or none when one cannot be deleted by the user, the dry run being rejected
+// alike.
+func (app *application) bulkDeleteHandler(resource string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			UUIDs []uuid.UUID `json:"uuids"`
+		}
+
+		// The body is optional, records may be selected with filters only.
+		if r.ContentLength != 0 {
+			err := app.readJSON(w, r, &input)
+			if err != nil {
+				app.badRequestResponse(w, r, err)
+				return
+			}
+		}
+
+		v := validator.New()
+
+		qs := r.URL.Query()
+		dryRun := app.readBool(qs, "dry_run", v)
+		search, filters, filtered := app.readBulkDeleteFilters(qs, resource, v)
+
+		v.CheckField(
+			len(input.UUIDs) > 0 || filtered,
+			"uuids",
+			validator.Invalid("must be provided unless filters are set"),
+		)
+		v.CheckField(
+			len(input.UUIDs) == 0 || !filtered,
+			"uuids",
+			validator.Invalid("must not be provided along with filters"),
+		)
+		if data.ValidateBulkDelete(v, input.UUIDs); !v.Valid() {
+			app.failedValidationResponse(w, r, v)
+			return
+		}
+
+		user := app.contextGetUser(r)
+
+		uuids := input.UUIDs
+		switch {
+		case filtered:
+			var total int
+			var err error
+			uuids, total, err = app.bulkDeleteMatches(r.Context(), resource, search, filters, user)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			if total > data.MaxBulkDelete {
+				message := fmt.Sprintf(
+					"match more than %d records, narrow them down",
+					data.MaxBulkDelete,
+				)
+				v.AddFieldError("filters", validator.Invalid(message))
+				app.failedValidationResponse(w, r, v)
+				return
+			}
+		case dryRun != nil && *dryRun:
+			owned, err := app.bulkDeleteOwned(r.Context(), resource, uuids, user)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			// The deletion is all or none, one record the user cannot delete rejects
+			// the whole of it.
+			if len(owned) < len(uuids) {
+				app.dataErrorResponse(w, r, data.ErrRecordNotFound)
+				return
+			}
+		}
+
+		if dryRun != nil && *dryRun {
+			env := envelope{"preview": envelope{"count": len(uuids), "uuids": uuids}}
+			err := app.writeJSON(w, http.StatusOK, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		ops, err := app.models.BulkDelete(
+			r.Context(),
+			resource,
+			uuids,
+			user.UUID,
+			app.quotaRefund(resource),
+			app.undoOperation(resource),
+		)
+		if err != nil {
+			app.dataErrorResponse(w, r, err)
+			return
+		}
+
+		env := envelope{
+			"message": fmt.Sprintf("%d %s(s) successfully deleted", len(uuids), resource),
+			"deleted": len(uuids),
+		}
+		if ops != nil {
+			env["undo"] = ops
+		}
+		err = app.writeJSON(w, http.StatusOK, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+// readBulkDeleteFilters reads the filters selecting the records of a bulk deletion from
+// the query string, and reports whether any of them narrows the selection down. Filters
+// given without a value, and include_archived which only widens it, do not count, for
+// them not to select every record of the user. Only the records the user owns are
+// matched, a single page of MaxBulkDelete of them is listed.
+func (app *application) readBulkDeleteFilters(
+	qs url.Values,
+	resource string,
+	v *validator.Validator,
+) (string, data.Filters, bool) {
+	statuses := app.readCSV(qs, "status", []string{}, v)
+
+	filters := data.Filters{
+		Status:   data.StringSliceToStatusSlice(statuses),
+		Role:     []string{"owner"},
+		Page:     1,
+		PageSize: data.MaxBulkDelete,
+	}
+	switch resource {
+	case "session":
+		filters.Sort = "-starts_at"
+		filters.SortSafelist = data.SessionSortSafelist
+		filters.StatusSafelist = data.SessionStatusSafelist
+		filters.Source = app.readCSV(qs, "source", []string{}, v)
+		if unclassified := app.readBool(qs, "unclassified", v); unclassified != nil {
+			filters.Unclassified = *unclassified
+		}
+	default:
+		filters.Sort = "-last_active"
+		filters.SortSafelist = data.SortSafelist
+		filters.StatusSafelist = data.StatusFilterSafelist
+		filters.DueFrom = app.readDate(qs, "due_from", v)
+		filters.DueTo = app.readDate(qs, "due_to", v)
+		filters.HasNotes = app.readBool(qs, "has_notes", v)
+		if includeArchived := app.readBool(qs, "include_archived", v); includeArchived != nil {
+			filters.IncludeArchived = *includeArchived
+		}
+	}
+	data.ValidateFilters(v, filters)
+
+	search := strings.TrimSpace(app.readString(qs, "search", ""))
+	filtered := search != "" ||
+		len(filters.Status) > 0 ||
+		len(filters.Source) > 0 ||
+		filters.Unclassified ||
+		filters.DueFrom.Valid ||
+		filters.DueTo.Valid ||
+		filters.HasNotes != nil
+
+	return search, filters, filtered
+}
+
+// bulkDeleteMatches returns the records matching the filters, which are restricted to
+// the ones the user owns and can delete, along with their total number.
+func (app *application) bulkDeleteMatches(
+	ctx context.Context,
+	resource, search string,
+	filters data.Filters,
+	user *data.User,
+) ([]uuid.UUID, int, error) {
+	t := tokenizer.New(search, app.models.Targets.Segmenter)
+
+	uuids := []uuid.UUID{}
+	var metadata data.Metadata
+	switch resource {
+	case "target":
+		targets, md, err := app.models.Targets.GetAllForUser(ctx, *t, filters, user.UUID)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, target := range targets {
+			uuids = append(uuids, target.UUID)
+		}
+		metadata = md
+	case "action":
+		actions, md, err := app.models.Actions.GetAll(
+			ctx,
+			*t,
+			filters,
+			uuid.NullUUID{Valid: false},
+			user.UUID,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, action := range actions {
+			uuids = append(uuids, action.UUID)
+		}
+		metadata = md
+	case "session":
+		sessions, md, err := app.models.Sessions.GetAll(
+			ctx,
+			*t,
+			filters,
+			uuid.NullUUID{Valid: false},
+			uuid.NullUUID{Valid: false},
+			user.UUID,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, session := range sessions {
+			uuids = append(uuids, session.UUID)
+		}
+		metadata = md
+	}
+
+	return uuids, metadata.TotalRecords, nil
+}
+
+// bulkDeleteOwned returns the records among uuids which the user owns.
+func (app *application) bulkDeleteOwned(
+	ctx context.Context,
+	resource string,
+	uuids []uuid.UUID,
+	user *data.User,
+) ([]uuid.UUID, error) {
+	owned := []uuid.UUID{}
+	for _, id := range uuids {
+		var err error
+		switch resource {
+		case "target":
+			_, err = app.models.Targets.Get(ctx, id, user.UUID, "owner")
+		case "action":
+			_, err = app.models.Actions.Get(ctx, id, user.UUID, "owner")
+		case "session":
+			_, err = app.models.Sessions.Get(ctx, id, user.UUID, "owner")
+		}
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			continue
+		case err != nil:
+			return nil, err
+		}
+		owned = append(owned, id)
+	}
+
+	return owned, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+func TestReadBulkDeleteFilters(t *testing.T) {
+	tests := []struct {
+		resource string
+		query    string
+		filtered bool
+	}{
+		{resource: "target", query: "", filtered: false},
+		{resource: "target", query: "include_archived=true", filtered: false},
+		{resource: "target", query: "search=", filtered: false},
+		{resource: "target", query: "search=%20%20&status=", filtered: false},
+		{resource: "target", query: "search=report", filtered: true},
+		{resource: "action", query: "status=completed", filtered: true},
+		{resource: "action", query: "due_from=2026-01-01", filtered: true},
+		{resource: "action", query: "has_notes=false&include_archived=true", filtered: true},
+		{resource: "session", query: "unclassified=false", filtered: false},
+		{resource: "session", query: "unclassified=true", filtered: true},
+		{resource: "session", query: "source=manual", filtered: true},
+	}
+
+	var app application
+	for _, tt := range tests {
+		t.Run(tt.resource+"?"+tt.query, func(t *testing.T) {
+			qs, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			v := validator.New()
+			_, _, filtered := app.readBulkDeleteFilters(qs, tt.resource, v)
+			if !v.Valid() {
+				t.Fatalf("invalid filters: %v", v.Codes())
+			}
+			if filtered != tt.filtered {
+				t.Errorf("got filtered %t, want %t", filtered, tt.filtered)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/liuminhaw/yatijapp/internal/data"
+)
+
+// cacheValidators holds the values used by clients to revalidate a cached response.
+type cacheValidators struct {
+	ETag         string
+	LastModified time.Time
+}
+
+// newResourceValidators builds the cache validators of a single resource from its
+// response and its updated_at timestamp. The ETag is a hash of the serialized response,
+// so it changes with the fields derived from other records, such as the status, the
+// counts and the role of the user, which change without the version of the resource.
+// Last-Modified is taken from updated_at, which does not cover these fields: clients
+// sending If-None-Match get the exact comparison.
+func newResourceValidators(resource any, updatedAt time.Time) (cacheValidators, error) {
+	js, err := json.Marshal(resource)
+	if err != nil {
+		return cacheValidators{}, err
+	}
+	sum := sha256.Sum256(js)
+
+	return cacheValidators{
+		ETag:         fmt.Sprintf(`W/"%x"`, sum[:16]),
+		LastModified: updatedAt.UTC().Truncate(time.Second),
+	}, nil
+}
+
+// newCollectionValidators builds the cache validators of a list response. The ETag
+// is a hash of the request path, the normalized query string and the fingerprint of
+// the filtered collection, so it changes whenever the listed records change.
+func newCollectionValidators(r *http.Request, fp data.Fingerprint) cacheValidators {
+	h := sha256.New()
+	fmt.Fprintf(
+		h,
+		"%s?%s|%d|%d|%d",
+		r.URL.Path,
+		r.URL.Query().Encode(),
+		fp.TotalRecords,
+		fp.LastUpdated.UnixNano(),
+		fp.ChildCount,
+	)
+
+	return cacheValidators{ETag: fmt.Sprintf(`W/"%x"`, h.Sum(nil)[:16])}
+}
+
+// headers returns the ETag, Last-Modified and Cache-Control headers for the response.
+func (cv cacheValidators) headers() http.Header {
+	headers := make(http.Header)
+	if cv.ETag != "" {
+		headers.Set("ETag", cv.ETag)
+	}
+	if !cv.LastModified.IsZero() {
+		headers.Set("Last-Modified", cv.LastModified.Format(http.TimeFormat))
+	}
+	// Responses depend on the authenticated user, so only private caches may store
+	// them and they must always be revalidated.
+	headers.Set("Cache-Control", "private, no-cache")
+
+	return headers
+}
+
+// notModified reports whether the request preconditions match the validators, in
+// which case a 304 Not Modified response should be sent. If-None-Match takes
+// precedence over If-Modified-Since as described in RFC 9110.
+func (cv cacheValidators) notModified(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if cv.ETag == "" {
+			return false
+		}
+		for tag := range strings.SplitSeq(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || weakETagMatch(tag, cv.ETag) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !cv.LastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		return !cv.LastModified.After(t)
+	}
+
+	return false
+}
+
+// weakETagMatch compares two entity tags using the weak comparison function.
+func weakETagMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// notModifiedResponse sends a 304 Not Modified response with the cache validators.
+func (app *application) notModifiedResponse(w http.ResponseWriter, cv cacheValidators) {
+	maps.Copy(w.Header(), cv.headers())
+	w.WriteHeader(http.StatusNotModified)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotModified(t *testing.T) {
+	updatedAt := time.Date(2026, 10, 17, 9, 30, 15, 500, time.UTC)
+	cv, err := newResourceValidators(map[string]string{"title": "Report"}, updatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lastModified := updatedAt.Truncate(time.Second).Format(http.TimeFormat)
+	earlier := updatedAt.Add(-time.Hour).Format(http.TimeFormat)
+
+	if got := cv.headers().Get("Last-Modified"); got != lastModified {
+		t.Fatalf("Last-Modified: got %q, want %q", got, lastModified)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    bool
+	}{
+		{name: "no precondition", method: http.MethodGet, want: false},
+		{
+			name:    "etag match",
+			method:  http.MethodGet,
+			headers: map[string]string{"If-None-Match": `"other", ` + cv.ETag},
+			want:    true,
+		},
+		{
+			name:    "etag mismatch",
+			method:  http.MethodGet,
+			headers: map[string]string{"If-None-Match": `W/"other"`},
+			want:    false,
+		},
+		{
+			name:    "not modified since",
+			method:  http.MethodGet,
+			headers: map[string]string{"If-Modified-Since": lastModified},
+			want:    true,
+		},
+		{
+			name:    "modified since",
+			method:  http.MethodHead,
+			headers: map[string]string{"If-Modified-Since": earlier},
+			want:    false,
+		},
+		{
+			name:    "invalid date",
+			method:  http.MethodGet,
+			headers: map[string]string{"If-Modified-Since": "yesterday"},
+			want:    false,
+		},
+		{
+			name:   "etag takes precedence",
+			method: http.MethodGet,
+			headers: map[string]string{
+				"If-None-Match":     `W/"other"`,
+				"If-Modified-Since": lastModified,
+			},
+			want: false,
+		},
+		{
+			name:    "unsafe method",
+			method:  http.MethodPatch,
+			headers: map[string]string{"If-None-Match": cv.ETag},
+			want:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/v1/targets/1", nil)
+			for key, value := range tt.headers {
+				r.Header.Set(key, value)
+			}
+
+			if got := cv.notModified(r); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
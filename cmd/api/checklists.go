@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// listChecklistHandler lists the checklist items of an action, in order.
+func (app *application) listChecklistHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+
+	user := app.contextGetUser(r)
+	err := app.models.ACLs.Check(r.Context(), "action", id, user.UUID, "viewer")
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	items, err := app.models.ChecklistItems.GetAllForAction(r.Context(), id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"checklist": items}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createChecklistItemHandler adds an item to the checklist of an action, after the last
+// item unless a position is given.
+func (app *application) createChecklistItemHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+
+	var input struct {
+		Title    string `json:"title"`
+		Done     bool   `json:"done"`
+		Position *int   `json:"position"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	item := data.ChecklistItem{
+		ActionUUID: id,
+		Title:      strings.TrimSpace(input.Title),
+		Done:       input.Done,
+	}
+	if input.Position != nil {
+		item.Position = *input.Position
+	}
+
+	v := validator.New()
+	if data.ValidateChecklistItem(v, &item); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	if err := app.models.ACLs.Check(r.Context(), "action", id, user.UUID, "editor"); err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.ChecklistItems.Insert(r.Context(), &item, input.Position == nil)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"checklist_item": item}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateChecklistItemHandler renames, checks off or moves a checklist item.
+func (app *application) updateChecklistItemHandler(w http.ResponseWriter, r *http.Request) {
+	item, ok := app.editableChecklistItem(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Title    *string `json:"title"`
+		Done     *bool   `json:"done"`
+		Position *int    `json:"position"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Title != nil {
+		item.Title = strings.TrimSpace(*input.Title)
+	}
+	if input.Done != nil {
+		item.Done = *input.Done
+	}
+	if input.Position != nil {
+		item.Position = *input.Position
+	}
+
+	v := validator.New()
+	if data.ValidateChecklistItem(v, item); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.models.ChecklistItems.Update(r.Context(), item)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"checklist_item": item}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteChecklistItemHandler(w http.ResponseWriter, r *http.Request) {
+	item, ok := app.editableChecklistItem(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.ChecklistItems.Delete(r.Context(), item)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// editableChecklistItem returns the checklist item of the request when the user can edit
+// its action, and sends the not found response otherwise. The caller must return when it
+// reports false.
+func (app *application) editableChecklistItem(
+	w http.ResponseWriter,
+	r *http.Request,
+) (*data.ChecklistItem, bool) {
+	id := app.contextGetUUIDParam(r)
+
+	item, err := app.models.ChecklistItems.Get(r.Context(), id)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	user := app.contextGetUser(r)
+	err = app.models.ACLs.Check(r.Context(), "action", item.ActionUUID, user.UUID, "editor")
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	return item, true
+}
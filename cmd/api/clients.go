@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// clientIDHeader carries the ID of the registered client app sending the request.
+const clientIDHeader = "X-Client-Id"
+
+// clientAppsRefreshInterval is how often the registered client apps are reloaded, to
+// pick up the ones registered on other instances.
+const clientAppsRefreshInterval = time.Minute
+
+// Requests without a client ID, or with an unregistered one, are counted apart.
+const (
+	clientNone    = "none"
+	clientUnknown = "unknown"
+)
+
+type clientStats struct {
+	Name      string           `json:"name,omitempty"`
+	Requests  int64            `json:"requests"`
+	Versions  map[string]int64 `json:"versions"`
+	Errors4xx int64            `json:"errors_4xx"`
+	Errors5xx int64            `json:"errors_5xx"`
+}
+
+// clientApps keeps the registered client apps in memory, so that requests are
+// attributed to their client without a database lookup, along with the statistics of
+// every client.
+type clientApps struct {
+	mu    sync.RWMutex
+	names map[uuid.UUID]string
+	stats map[string]*clientStats
+}
+
+func newClientApps() *clientApps {
+	return &clientApps{
+		names: make(map[uuid.UUID]string),
+		stats: make(map[string]*clientStats),
+	}
+}
+
+func (c *clientApps) register(id uuid.UUID, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.names[id] = name
+}
+
+func (c *clientApps) load(names map[uuid.UUID]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.names = names
+}
+
+// record counts a request, sent with the given client ID header value, served by the
+// API version with the response status.
+func (c *clientApps) record(header, version string, status int) {
+	key, name := clientNone, ""
+	if header != "" {
+		key = clientUnknown
+		if id, err := uuid.FromString(header); err == nil {
+			c.mu.RLock()
+			registered, ok := c.names[id]
+			c.mu.RUnlock()
+			if ok {
+				key, name = id.String(), registered
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.stats[key]
+	if !ok {
+		s = &clientStats{Versions: make(map[string]int64)}
+		c.stats[key] = s
+	}
+	s.Name = name
+	s.Requests++
+	if version != "" {
+		s.Versions[version]++
+	}
+	switch {
+	case status >= 500:
+		s.Errors5xx++
+	case status >= 400:
+		s.Errors4xx++
+	}
+}
+
+// snapshot returns a copy of the statistics of every client.
+func (c *clientApps) snapshot() map[string]clientStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stats := make(map[string]clientStats, len(c.stats))
+	for key, s := range c.stats {
+		copied := *s
+		copied.Versions = make(map[string]int64, len(s.Versions))
+		for version, n := range s.Versions {
+			copied.Versions[version] = n
+		}
+		stats[key] = copied
+	}
+
+	return stats
+}
+
+// showClientStatsHandler shows the requests of every client to the operators. The
+// statistics name the registered client apps, they are not published on /debug/vars.
+func (app *application) showClientStatsHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"clients": app.clients.snapshot()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// startClientAppsRoutine periodically reloads the registered client apps.
+func (app *application) startClientAppsRoutine() {
+	app.loadClientApps()
+
+	ticker := time.NewTicker(clientAppsRefreshInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		app.loadClientApps()
+	}
+}
+
+func (app *application) loadClientApps() {
+	names, err := app.models.ClientApps.Names(context.Background())
+	if err != nil {
+		app.logger.Error("Error loading client apps: " + err.Error())
+		return
+	}
+	app.clients.load(names)
+}
+
+func (app *application) listClientAppsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	clients, err := app.models.ClientApps.GetAllForUser(r.Context(), user.UUID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"clients": clients}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) registerClientAppHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	client := data.ClientApp{Name: input.Name}
+
+	v := validator.New()
+	if data.ValidateClientApp(v, &client); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	err = app.models.ClientApps.Insert(r.Context(), &client, user.UUID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.clients.register(client.UUID, client.Name)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"client": client}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
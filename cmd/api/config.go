@@ -1,10 +1,12 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/liuminhaw/yatijapp/internal/mailer"
 	flag "github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
@@ -13,24 +15,73 @@ var defaultConfigFile = "yatijapp.toml"
 
 type config struct {
 	port   int
+	listen []string
 	env    string
 	pepper string
-	db     struct {
-		dsn          string
-		maxOpenConns int
-		maxIdleConns int
-		maxIdleTime  time.Duration
+	// operators lists the UUIDs of the users shown the details of the healthcheck.
+	operators []string
+	db        struct {
+		dsn                 string
+		maxOpenConns        int
+		maxIdleConns        int
+		maxIdleTime         time.Duration
+		poolMonitorInterval time.Duration
+		saturationThreshold time.Duration
+		breaker             struct {
+			enabled   bool
+			threshold int
+			cooldown  time.Duration
+		}
 	}
 	limiter struct {
-		rps     float64
-		burst   int
+		rps        float64
+		burst      int
+		enabled    bool
+		maxClients int
+	}
+	ipFilter struct {
+		// allow restricts the service to these ranges, when set.
+		allow []string
+		// deny rejects these ranges everywhere, over the allowed ones.
+		deny []string
+		// adminAllow restricts the admin and debug routes to these ranges, when set.
+		adminAllow []string
+		// trustProxyHeaders reads the client address from the X-Forwarded-For and
+		// X-Real-IP headers, only safe behind a proxy overwriting them.
+		trustProxyHeaders bool
+	}
+	abuse struct {
 		enabled bool
+		// Creations past burstLimit within burstWindow score burstPoints each, and
+		// titles repeating one of the latest score duplicatePoints.
+		burstWindow     time.Duration
+		burstLimit      int
+		burstPoints     float64
+		duplicatePoints float64
+		// halfLife is the time it takes for a score to halve.
+		halfLife time.Duration
+		// Users reaching stepUpScore must verify their password again before writing,
+		// users reaching freezeScore cannot write for freezeDuration. 0 disables either.
+		stepUpScore    float64
+		freezeScore    float64
+		freezeDuration time.Duration
 	}
 	tokens struct {
 		activationTokenTTL    time.Duration
 		passwordResetTokenTTL time.Duration
 		accessTokenTTL        time.Duration
 		refreshTokenTTL       time.Duration
+		// sessionRevocationTokenTTL is the lifetime of the revocation links sent on
+		// sign-ins from a new device.
+		sessionRevocationTokenTTL time.Duration
+		// invitationTokenTTL is how long the invitations to share a record stay valid.
+		invitationTokenTTL time.Duration
+		// emailLimit is how many activation or password reset tokens, each sent by
+		// email, a user can request within emailWindow. Zero disables the limit.
+		emailLimit  int
+		emailWindow time.Duration
+		// activationCooldown is the minimum delay between two activation emails.
+		activationCooldown time.Duration
 	}
 	smtp struct {
 		host     string
@@ -38,42 +89,358 @@ type config struct {
 		username string
 		password string
 		sender   string
+		// checkDNS logs guidance on the SPF, DMARC and DKIM records of the sender
+		// domain at startup.
+		checkDNS bool
+		// maxAttachmentsSize is the maximum total size, in bytes, of the files attached
+		// to an email.
+		maxAttachmentsSize int
+		// batchConcurrency is the number of SMTP connections batches of emails, such
+		// as notices and reminders, are sent over.
+		batchConcurrency int
+		// verify checks the SMTP server at startup, where a failure is either fatal or
+		// reported by the health check, then every interval.
+		verify struct {
+			enabled  bool
+			fatal    bool
+			interval time.Duration
+		}
+	}
+	dkim struct {
+		enabled bool
+		// domain defaults to the domain of the sender address.
+		domain         string
+		selector       string
+		privateKeyFile string
 	}
 	cleanup struct {
 		interval time.Duration
 	}
+	undo struct {
+		// ttl is how long a deletion can be undone, zero disables undoing deletions.
+		ttl time.Duration
+	}
+	starter struct {
+		// enabled creates a guided target, with example actions and a sample session,
+		// for the users activating their account.
+		enabled bool
+	}
+	sharing struct {
+		// viewReceipts records when collaborators last viewed the targets shared with
+		// them, shown to the owners. Users may opt out in their preferences.
+		viewReceipts bool
+	}
+	archive struct {
+		enabled  bool
+		interval time.Duration
+	}
+	unactivated struct {
+		enabled  bool
+		interval time.Duration
+		// Users are reminded to activate their account remindAfter their registration,
+		// the account is deleted if still not activated deleteAfter the registration.
+		remindAfter time.Duration
+		deleteAfter time.Duration
+	}
+	retention struct {
+		enabled   bool
+		interval  time.Duration
+		batchSize int
+		// The records of each resource are purged after this many days, 0 keeps them.
+		sessionsDays        int
+		archivedTargetsDays int
+		archivedActionsDays int
+		devicesDays         int
+		plansDays           int
+		aclAuditDays        int
+	}
+	reminders struct {
+		enabled  bool
+		interval time.Duration
+	}
+	savedSearches struct {
+		enabled  bool
+		interval time.Duration
+		// maxPerUser is the number of saved searches a user can keep.
+		maxPerUser int
+	}
+	reportSchedules struct {
+		enabled  bool
+		interval time.Duration
+		// maxPerUser is the number of report schedules a user can keep.
+		maxPerUser int
+	}
+	partitions struct {
+		enabled  bool
+		interval time.Duration
+		// ahead is the number of months the session partitions are created in advance.
+		ahead int
+	}
 	cors struct {
-		trustedOrigins []string
+		trustedOrigins   []string
+		allowedMethods   []string
+		allowedHeaders   []string
+		maxAge           time.Duration
+		allowCredentials bool
+	}
+	exemptions struct {
+		users   []string
+		apiKeys []string
+		// synthetic are the name=key pairs of the synthetic traffic let through the
+		// rate limiter.
+		synthetic []string
+	}
+	cookies struct {
+		enabled  bool
+		domain   string
+		secure   bool
+		sameSite string
+	}
+	timeouts struct {
+		request time.Duration
+		auth    time.Duration
+		export  time.Duration
+		// slowRequest is the duration past which requests are logged with the breakdown
+		// of their time, zero disables the tracing.
+		slowRequest time.Duration
+	}
+	faults struct {
+		// enabled injects the latency and the errors below, in development only.
+		enabled         bool
+		dbLatency       time.Duration
+		dbErrorRate     float64
+		mailerLatency   time.Duration
+		mailerErrorRate float64
+	}
+	notesEncryption struct {
+		enabled bool
+		// dataKey is the base64 data key of the deployment, encrypted by AWS KMS.
+		dataKey string
+		// searchable keeps indexing the encrypted notes for the full text search.
+		searchable bool
+	}
+	backups struct {
+		enabled bool
+		bucket  string
+		prefix  string
+		pgDump  string
+		timeout time.Duration
+		// alertEmails are emailed the failed backups.
+		alertEmails []string
+	}
+	search struct {
+		segmenter struct {
+			enabled  bool
+			lazy     bool
+			poolSize int
+		}
+		// weights of the title and the description in the search vectors of the targets
+		// and actions, applied once the records are reindexed.
+		weights struct {
+			title       string
+			description string
+			// reindex reindexes the records at startup when the weights changed.
+			reindex bool
+		}
+		// ranking weighs the matching lexemes by their weight, and normalizes the rank
+		// with the ts_rank bit mask.
+		ranking struct {
+			weightA       float64
+			weightB       float64
+			weightC       float64
+			weightD       float64
+			normalization int
+		}
+		// boost is added to the rank of the targets and actions sorted by relevance: up
+		// to recency for the recently active ones, halving every halfLife, and the boost
+		// of their status.
+		boost struct {
+			recency    float64
+			halfLife   time.Duration
+			inProgress float64
+			queued     float64
+			completed  float64
+			canceled   float64
+			archived   float64
+		}
+	}
+	security struct {
+		webhook struct {
+			url     string
+			timeout time.Duration
+		}
+		newSignIn struct {
+			enabled       bool
+			countryHeader string
+			revokeURL     string
+		}
+	}
+	oauth struct {
+		google struct {
+			clientID     string
+			clientSecret string
+			// redirectURL is the redirect URI the authorization codes are issued for,
+			// Google sign-in is disabled unless it is set along with the client.
+			redirectURL string
+		}
 	}
 	user struct {
 		dailyTargetsCreationLimit  int
 		dailyActionsCreationLimit  int
 		dailySessionsCreationLimit int
+		refundQuotaOnDelete        bool
+	}
+	logs struct {
+		// redact masks the personal data and the secrets in the logs.
+		redact bool
+		// redactKeys are the keys of the attributes redacted along with the default ones.
+		redactKeys []string
 	}
 }
 
 func configSetup(conf *viper.Viper, config_file string) (config, error) {
 	conf.SetDefault("server.port", 8080)
+	conf.SetDefault("server.listen", []string{})
 	conf.SetDefault("server.env", "development")
 	conf.SetDefault("server.pepper", "")
+	conf.SetDefault("server.operators", []string{})
 	conf.SetDefault("server.corsTrustedOrigins", []string{})
+	conf.SetDefault("server.cors.allowedMethods", []string{"OPTIONS", "PUT", "PATCH", "DELETE"})
+	conf.SetDefault("server.cors.allowedHeaders", []string{"Authorization", "Content-Type"})
+	conf.SetDefault("server.cors.maxAge", time.Duration(0))
+	conf.SetDefault("server.cors.allowCredentials", false)
+	conf.SetDefault("server.exemptions.users", []string{})
+	conf.SetDefault("server.exemptions.apiKeys", []string{})
+	conf.SetDefault("server.exemptions.synthetic", []string{})
+	conf.SetDefault("server.cookies.enabled", false)
+	conf.SetDefault("server.cookies.domain", "")
+	conf.SetDefault("server.cookies.secure", true)
+	conf.SetDefault("server.cookies.sameSite", "strict")
 	conf.SetDefault("server.limiter.rps", 2.0)
 	conf.SetDefault("server.limiter.burst", 4)
 	conf.SetDefault("server.limiter.enabled", true)
+	conf.SetDefault("server.limiter.maxClients", 10000)
+	conf.SetDefault("server.ipFilter.allow", []string{})
+	conf.SetDefault("server.ipFilter.deny", []string{})
+	conf.SetDefault("server.ipFilter.adminAllow", []string{})
+	conf.SetDefault("server.ipFilter.trustProxyHeaders", false)
+	conf.SetDefault("server.abuse.enabled", false)
+	conf.SetDefault("server.abuse.burstWindow", 1*time.Minute)
+	conf.SetDefault("server.abuse.burstLimit", 10)
+	conf.SetDefault("server.abuse.burstPoints", 10.0)
+	conf.SetDefault("server.abuse.duplicatePoints", 5.0)
+	conf.SetDefault("server.abuse.halfLife", 10*time.Minute)
+	conf.SetDefault("server.abuse.stepUpScore", 50.0)
+	conf.SetDefault("server.abuse.freezeScore", 100.0)
+	conf.SetDefault("server.abuse.freezeDuration", 15*time.Minute)
 	conf.SetDefault("server.tokens.activationTokenTTL", 10*time.Minute)
 	conf.SetDefault("server.tokens.passwordResetTokenTTL", 10*time.Minute)
 	conf.SetDefault("server.tokens.accessTokenTTL", 1*time.Hour)
 	conf.SetDefault("server.tokens.refreshTokenTTL", 24*time.Hour)
+	conf.SetDefault("server.tokens.sessionRevocationTokenTTL", 7*24*time.Hour)
+	conf.SetDefault("server.tokens.invitationTokenTTL", 7*24*time.Hour)
+	conf.SetDefault("server.tokens.emailLimit", 3)
+	conf.SetDefault("server.tokens.emailWindow", 1*time.Hour)
+	conf.SetDefault("server.tokens.activationCooldown", 1*time.Minute)
 	conf.SetDefault("server.cleanup.interval", 1*time.Hour)
+	conf.SetDefault("server.undo.ttl", 10*time.Minute)
+	conf.SetDefault("server.sharing.viewReceipts", true)
+	conf.SetDefault("server.starter.enabled", false)
+	conf.SetDefault("server.archive.enabled", true)
+	conf.SetDefault("server.archive.interval", 1*time.Hour)
+	conf.SetDefault("server.reminders.enabled", true)
+	conf.SetDefault("server.reminders.interval", 1*time.Minute)
+	conf.SetDefault("server.savedSearches.enabled", true)
+	conf.SetDefault("server.savedSearches.interval", 1*time.Hour)
+	conf.SetDefault("server.savedSearches.maxPerUser", 20)
+	conf.SetDefault("server.reportSchedules.enabled", true)
+	conf.SetDefault("server.reportSchedules.interval", 15*time.Minute)
+	conf.SetDefault("server.reportSchedules.maxPerUser", 5)
+	conf.SetDefault("server.partitions.enabled", true)
+	conf.SetDefault("server.partitions.interval", 24*time.Hour)
+	conf.SetDefault("server.partitions.ahead", 3)
+	conf.SetDefault("server.unactivated.enabled", true)
+	conf.SetDefault("server.unactivated.interval", 1*time.Hour)
+	conf.SetDefault("server.unactivated.remindAfter", 24*time.Hour)
+	conf.SetDefault("server.unactivated.deleteAfter", 7*24*time.Hour)
+	conf.SetDefault("server.retention.enabled", false)
+	conf.SetDefault("server.retention.interval", 24*time.Hour)
+	conf.SetDefault("server.retention.batchSize", 1000)
+	conf.SetDefault("server.retention.sessionsDays", 0)
+	conf.SetDefault("server.retention.archivedTargetsDays", 0)
+	conf.SetDefault("server.retention.archivedActionsDays", 0)
+	conf.SetDefault("server.retention.devicesDays", 0)
+	conf.SetDefault("server.retention.plansDays", 0)
+	conf.SetDefault("server.retention.aclAuditDays", 0)
+	conf.SetDefault("server.timeouts.request", 5*time.Second)
+	conf.SetDefault("server.timeouts.auth", 3*time.Second)
+	conf.SetDefault("server.timeouts.export", 30*time.Second)
+	conf.SetDefault("server.timeouts.slowRequest", 2*time.Second)
+	conf.SetDefault("server.notesEncryption.enabled", false)
+	conf.SetDefault("server.notesEncryption.searchable", false)
+	conf.SetDefault("server.backups.enabled", false)
+	conf.SetDefault("server.backups.prefix", "backups/")
+	conf.SetDefault("server.backups.pgDump", "pg_dump")
+	conf.SetDefault("server.backups.timeout", 30*time.Minute)
+	conf.SetDefault("server.backups.alertEmails", []string{})
+	conf.SetDefault("server.faults.enabled", false)
+	conf.SetDefault("server.faults.dbLatency", time.Duration(0))
+	conf.SetDefault("server.faults.dbErrorRate", 0.0)
+	conf.SetDefault("server.faults.mailerLatency", time.Duration(0))
+	conf.SetDefault("server.faults.mailerErrorRate", 0.0)
 	conf.SetDefault("database.maxOpenConns", 25)
 	conf.SetDefault("database.maxIdleConns", 25)
 	conf.SetDefault("database.maxIdleTimeInMinutes", 15)
+	conf.SetDefault("database.poolMonitorInterval", 5*time.Second)
+	conf.SetDefault("database.saturationThreshold", 30*time.Second)
+	conf.SetDefault("database.breaker.enabled", true)
+	conf.SetDefault("database.breaker.threshold", 5)
+	conf.SetDefault("database.breaker.cooldown", 30*time.Second)
+	conf.SetDefault("logs.redact", true)
+	conf.SetDefault("logs.redactKeys", []string{})
+	conf.SetDefault("search.segmenter.enabled", true)
+	conf.SetDefault("search.segmenter.lazy", true)
+	conf.SetDefault("search.segmenter.poolSize", 1)
+	conf.SetDefault("search.weights.title", "A")
+	conf.SetDefault("search.weights.description", "B")
+	conf.SetDefault("search.weights.reindex", false)
+	conf.SetDefault("search.ranking.weightA", 1.0)
+	conf.SetDefault("search.ranking.weightB", 0.4)
+	conf.SetDefault("search.ranking.weightC", 0.2)
+	conf.SetDefault("search.ranking.weightD", 0.1)
+	conf.SetDefault("search.ranking.normalization", 0)
+	conf.SetDefault("search.boost.recency", 0.5)
+	conf.SetDefault("search.boost.halfLife", 30*24*time.Hour)
+	conf.SetDefault("search.boost.inProgress", 0.3)
+	conf.SetDefault("search.boost.queued", 0)
+	conf.SetDefault("search.boost.completed", -0.1)
+	conf.SetDefault("search.boost.canceled", -0.2)
+	conf.SetDefault("search.boost.archived", -0.5)
+	conf.SetDefault("security.webhook.url", "")
+	conf.SetDefault("security.webhook.timeout", 5*time.Second)
+	conf.SetDefault("security.newSignIn.enabled", true)
+	conf.SetDefault("security.newSignIn.countryHeader", "")
+	conf.SetDefault("security.newSignIn.revokeURL", "")
+	conf.SetDefault("oauth.google.clientID", "")
+	conf.SetDefault("oauth.google.clientSecret", "")
+	conf.SetDefault("oauth.google.redirectURL", "")
 	conf.SetDefault("smtp.host", "sandbox.smtp.mailtrap.io")
 	conf.SetDefault("smtp.port", 25)
 	conf.SetDefault("smtp.sender", "Yatijapp <no-reply>@yatijapp.fakemail.com")
+	conf.SetDefault("mailer.checkDNS", false)
+	conf.SetDefault("mailer.maxAttachmentsSize", mailer.DefaultMaxAttachmentsSize)
+	conf.SetDefault("mailer.batchConcurrency", 4)
+	conf.SetDefault("mailer.verify.enabled", true)
+	conf.SetDefault("mailer.verify.fatal", false)
+	conf.SetDefault("mailer.verify.interval", 5*time.Minute)
+	conf.SetDefault("mailer.dkim.enabled", false)
+	conf.SetDefault("mailer.dkim.domain", "")
+	conf.SetDefault("mailer.dkim.selector", "")
+	conf.SetDefault("mailer.dkim.privateKeyFile", "")
 	conf.SetDefault("user.dailyTargetsCreationLimit", 10)
 	conf.SetDefault("user.dailyActionsCreationLimit", 20)
 	conf.SetDefault("user.dailySessionsCreationLimit", 50)
+	conf.SetDefault("user.quota.refundOnDelete", true)
 
 	if config_file != "" {
 		conf.SetConfigFile(config_file)
@@ -90,99 +457,683 @@ func configSetup(conf *viper.Viper, config_file string) (config, error) {
 	}
 
 	conf.BindPFlag("server.port", flag.Lookup("port"))
+	conf.BindPFlag("server.listen", flag.Lookup("listen"))
 	conf.BindPFlag("server.env", flag.Lookup("env"))
+	conf.BindPFlag("server.operators", flag.Lookup("operators"))
 	conf.BindPFlag("server.corsTrustedOrigins", flag.Lookup("cors-trusted-origins"))
+	conf.BindPFlag("server.cors.allowedMethods", flag.Lookup("cors-allowed-methods"))
+	conf.BindPFlag("server.cors.allowedHeaders", flag.Lookup("cors-allowed-headers"))
+	conf.BindPFlag("server.cors.maxAge", flag.Lookup("cors-max-age"))
+	conf.BindPFlag("server.cors.allowCredentials", flag.Lookup("cors-allow-credentials"))
+	conf.BindPFlag("server.exemptions.users", flag.Lookup("exempt-users"))
+	conf.BindPFlag("server.exemptions.apiKeys", flag.Lookup("exempt-api-keys"))
+	conf.BindPFlag("server.exemptions.synthetic", flag.Lookup("exempt-synthetic"))
+	conf.BindPFlag("server.cookies.enabled", flag.Lookup("cookie-auth-enabled"))
+	conf.BindPFlag("server.cookies.domain", flag.Lookup("cookie-domain"))
+	conf.BindPFlag("server.cookies.secure", flag.Lookup("cookie-secure"))
+	conf.BindPFlag("server.cookies.sameSite", flag.Lookup("cookie-same-site"))
 	conf.BindPFlag("server.limiter.rps", flag.Lookup("limiter-rps"))
 	conf.BindPFlag("server.limiter.burst", flag.Lookup("limiter-burst"))
 	conf.BindPFlag("server.limiter.enabled", flag.Lookup("limiter-enabled"))
+	conf.BindPFlag("server.limiter.maxClients", flag.Lookup("limiter-max-clients"))
+	conf.BindPFlag("server.ipFilter.allow", flag.Lookup("ip-allow"))
+	conf.BindPFlag("server.ipFilter.deny", flag.Lookup("ip-deny"))
+	conf.BindPFlag("server.ipFilter.adminAllow", flag.Lookup("ip-admin-allow"))
+	conf.BindPFlag(
+		"server.ipFilter.trustProxyHeaders",
+		flag.Lookup("ip-trust-proxy-headers"),
+	)
+	conf.BindPFlag("server.abuse.enabled", flag.Lookup("abuse-enabled"))
+	conf.BindPFlag("server.abuse.burstWindow", flag.Lookup("abuse-burst-window"))
+	conf.BindPFlag("server.abuse.burstLimit", flag.Lookup("abuse-burst-limit"))
+	conf.BindPFlag("server.abuse.burstPoints", flag.Lookup("abuse-burst-points"))
+	conf.BindPFlag("server.abuse.duplicatePoints", flag.Lookup("abuse-duplicate-points"))
+	conf.BindPFlag("server.abuse.halfLife", flag.Lookup("abuse-half-life"))
+	conf.BindPFlag("server.abuse.stepUpScore", flag.Lookup("abuse-step-up-score"))
+	conf.BindPFlag("server.abuse.freezeScore", flag.Lookup("abuse-freeze-score"))
+	conf.BindPFlag("server.abuse.freezeDuration", flag.Lookup("abuse-freeze-duration"))
 	conf.BindPFlag("server.tokens.activationTokenTTL", flag.Lookup("ttl-activation-token"))
 	conf.BindPFlag("server.tokens.passwordResetTokenTTL", flag.Lookup("ttl-password-reset-token"))
 	conf.BindPFlag("server.tokens.accessTokenTTL", flag.Lookup("ttl-access-token"))
 	conf.BindPFlag("server.tokens.refreshTokenTTL", flag.Lookup("ttl-refresh-token"))
+	conf.BindPFlag(
+		"server.tokens.sessionRevocationTokenTTL",
+		flag.Lookup("ttl-session-revocation-token"),
+	)
+	conf.BindPFlag("server.tokens.invitationTokenTTL", flag.Lookup("ttl-invitation-token"))
+	conf.BindPFlag("server.tokens.emailLimit", flag.Lookup("token-email-limit"))
+	conf.BindPFlag("server.tokens.emailWindow", flag.Lookup("token-email-window"))
+	conf.BindPFlag(
+		"server.tokens.activationCooldown",
+		flag.Lookup("token-activation-cooldown"),
+	)
 	conf.BindPFlag("server.cleanup.interval", flag.Lookup("cleanup-interval"))
+	conf.BindPFlag("server.undo.ttl", flag.Lookup("undo-ttl"))
+	conf.BindPFlag("server.sharing.viewReceipts", flag.Lookup("sharing-view-receipts"))
+	conf.BindPFlag("server.starter.enabled", flag.Lookup("starter-enabled"))
+	conf.BindPFlag("server.archive.enabled", flag.Lookup("archive-enabled"))
+	conf.BindPFlag("server.archive.interval", flag.Lookup("archive-interval"))
+	conf.BindPFlag("server.reminders.enabled", flag.Lookup("reminders-enabled"))
+	conf.BindPFlag("server.reminders.interval", flag.Lookup("reminders-interval"))
+	conf.BindPFlag("server.savedSearches.enabled", flag.Lookup("saved-searches-enabled"))
+	conf.BindPFlag("server.savedSearches.interval", flag.Lookup("saved-searches-interval"))
+	conf.BindPFlag(
+		"server.savedSearches.maxPerUser",
+		flag.Lookup("saved-searches-max-per-user"),
+	)
+	conf.BindPFlag("server.reportSchedules.enabled", flag.Lookup("report-schedules-enabled"))
+	conf.BindPFlag("server.reportSchedules.interval", flag.Lookup("report-schedules-interval"))
+	conf.BindPFlag(
+		"server.reportSchedules.maxPerUser",
+		flag.Lookup("report-schedules-max-per-user"),
+	)
+	conf.BindPFlag("server.partitions.enabled", flag.Lookup("partitions-enabled"))
+	conf.BindPFlag("server.partitions.interval", flag.Lookup("partitions-interval"))
+	conf.BindPFlag("server.partitions.ahead", flag.Lookup("partitions-ahead"))
+	conf.BindPFlag("server.unactivated.enabled", flag.Lookup("unactivated-enabled"))
+	conf.BindPFlag("server.unactivated.interval", flag.Lookup("unactivated-interval"))
+	conf.BindPFlag("server.unactivated.remindAfter", flag.Lookup("unactivated-remind-after"))
+	conf.BindPFlag("server.unactivated.deleteAfter", flag.Lookup("unactivated-delete-after"))
+	conf.BindPFlag("server.retention.enabled", flag.Lookup("retention-enabled"))
+	conf.BindPFlag("server.retention.interval", flag.Lookup("retention-interval"))
+	conf.BindPFlag("server.retention.batchSize", flag.Lookup("retention-batch-size"))
+	conf.BindPFlag("server.retention.sessionsDays", flag.Lookup("retention-sessions-days"))
+	conf.BindPFlag(
+		"server.retention.archivedTargetsDays",
+		flag.Lookup("retention-archived-targets-days"),
+	)
+	conf.BindPFlag(
+		"server.retention.archivedActionsDays",
+		flag.Lookup("retention-archived-actions-days"),
+	)
+	conf.BindPFlag("server.retention.devicesDays", flag.Lookup("retention-devices-days"))
+	conf.BindPFlag("server.retention.plansDays", flag.Lookup("retention-plans-days"))
+	conf.BindPFlag("server.retention.aclAuditDays", flag.Lookup("retention-acl-audit-days"))
+	conf.BindPFlag("server.timeouts.request", flag.Lookup("timeout-request"))
+	conf.BindPFlag("server.timeouts.auth", flag.Lookup("timeout-auth"))
+	conf.BindPFlag("server.timeouts.export", flag.Lookup("timeout-export"))
+	conf.BindPFlag("server.timeouts.slowRequest", flag.Lookup("slow-request-threshold"))
+	conf.BindPFlag("server.notesEncryption.enabled", flag.Lookup("notes-encryption-enabled"))
+	conf.BindPFlag("server.notesEncryption.dataKey", flag.Lookup("notes-encryption-data-key"))
+	conf.BindPFlag(
+		"server.notesEncryption.searchable",
+		flag.Lookup("notes-encryption-searchable"),
+	)
+	conf.BindPFlag("server.backups.enabled", flag.Lookup("backups-enabled"))
+	conf.BindPFlag("server.backups.bucket", flag.Lookup("backups-bucket"))
+	conf.BindPFlag("server.backups.prefix", flag.Lookup("backups-prefix"))
+	conf.BindPFlag("server.backups.pgDump", flag.Lookup("backups-pg-dump"))
+	conf.BindPFlag("server.backups.timeout", flag.Lookup("backups-timeout"))
+	conf.BindPFlag("server.backups.alertEmails", flag.Lookup("backups-alert-emails"))
+	conf.BindPFlag("server.faults.enabled", flag.Lookup("faults-enabled"))
+	conf.BindPFlag("server.faults.dbLatency", flag.Lookup("faults-db-latency"))
+	conf.BindPFlag("server.faults.dbErrorRate", flag.Lookup("faults-db-error-rate"))
+	conf.BindPFlag("server.faults.mailerLatency", flag.Lookup("faults-mailer-latency"))
+	conf.BindPFlag("server.faults.mailerErrorRate", flag.Lookup("faults-mailer-error-rate"))
 	conf.BindPFlag("database.dsn", flag.Lookup("db-dsn"))
 	conf.BindPFlag("database.maxOpenConns", flag.Lookup("db-max-open-conns"))
 	conf.BindPFlag("database.maxIdleConns", flag.Lookup("db-max-idle-conns"))
 	conf.BindPFlag("database.maxIdleTime", flag.Lookup("db-max-idle-time"))
+	conf.BindPFlag("database.poolMonitorInterval", flag.Lookup("db-pool-monitor-interval"))
+	conf.BindPFlag("database.saturationThreshold", flag.Lookup("db-saturation-threshold"))
+	conf.BindPFlag("database.breaker.enabled", flag.Lookup("db-breaker-enabled"))
+	conf.BindPFlag("database.breaker.threshold", flag.Lookup("db-breaker-threshold"))
+	conf.BindPFlag("database.breaker.cooldown", flag.Lookup("db-breaker-cooldown"))
+	conf.BindPFlag("logs.redact", flag.Lookup("log-redact"))
+	conf.BindPFlag("logs.redactKeys", flag.Lookup("log-redact-keys"))
+	conf.BindPFlag("search.segmenter.enabled", flag.Lookup("search-segmenter-enabled"))
+	conf.BindPFlag("search.segmenter.lazy", flag.Lookup("search-segmenter-lazy"))
+	conf.BindPFlag("search.segmenter.poolSize", flag.Lookup("search-segmenter-pool-size"))
+	conf.BindPFlag("search.weights.title", flag.Lookup("search-title-weight"))
+	conf.BindPFlag("search.weights.description", flag.Lookup("search-description-weight"))
+	conf.BindPFlag("search.weights.reindex", flag.Lookup("search-reindex"))
+	conf.BindPFlag("search.ranking.weightA", flag.Lookup("search-rank-weight-a"))
+	conf.BindPFlag("search.ranking.weightB", flag.Lookup("search-rank-weight-b"))
+	conf.BindPFlag("search.ranking.weightC", flag.Lookup("search-rank-weight-c"))
+	conf.BindPFlag("search.ranking.weightD", flag.Lookup("search-rank-weight-d"))
+	conf.BindPFlag("search.ranking.normalization", flag.Lookup("search-rank-normalization"))
+	conf.BindPFlag("search.boost.recency", flag.Lookup("search-boost-recency"))
+	conf.BindPFlag("search.boost.halfLife", flag.Lookup("search-boost-half-life"))
+	conf.BindPFlag("search.boost.inProgress", flag.Lookup("search-boost-in-progress"))
+	conf.BindPFlag("search.boost.queued", flag.Lookup("search-boost-queued"))
+	conf.BindPFlag("search.boost.completed", flag.Lookup("search-boost-completed"))
+	conf.BindPFlag("search.boost.canceled", flag.Lookup("search-boost-canceled"))
+	conf.BindPFlag("search.boost.archived", flag.Lookup("search-boost-archived"))
+	conf.BindPFlag("security.webhook.url", flag.Lookup("security-webhook-url"))
+	conf.BindPFlag("security.webhook.timeout", flag.Lookup("security-webhook-timeout"))
+	conf.BindPFlag("security.newSignIn.enabled", flag.Lookup("new-sign-in-enabled"))
+	conf.BindPFlag("security.newSignIn.countryHeader", flag.Lookup("new-sign-in-country-header"))
+	conf.BindPFlag("security.newSignIn.revokeURL", flag.Lookup("new-sign-in-revoke-url"))
+	conf.BindPFlag("oauth.google.clientID", flag.Lookup("oauth-google-client-id"))
+	conf.BindPFlag("oauth.google.clientSecret", flag.Lookup("oauth-google-client-secret"))
+	conf.BindPFlag("oauth.google.redirectURL", flag.Lookup("oauth-google-redirect-url"))
 	conf.BindPFlag("mailer.sender", flag.Lookup("smtp-sender"))
 	conf.BindPFlag("mailer.smtp.host", flag.Lookup("smtp-host"))
 	conf.BindPFlag("mailer.smtp.port", flag.Lookup("smtp-port"))
 	conf.BindPFlag("mailer.smtp.username", flag.Lookup("smtp-username"))
 	conf.BindPFlag("mailer.smtp.password", flag.Lookup("smtp-password"))
+	conf.BindPFlag("mailer.checkDNS", flag.Lookup("smtp-check-dns"))
+	conf.BindPFlag("mailer.maxAttachmentsSize", flag.Lookup("smtp-max-attachments-size"))
+	conf.BindPFlag("mailer.batchConcurrency", flag.Lookup("smtp-batch-concurrency"))
+	conf.BindPFlag("mailer.verify.enabled", flag.Lookup("smtp-verify"))
+	conf.BindPFlag("mailer.verify.fatal", flag.Lookup("smtp-verify-fatal"))
+	conf.BindPFlag("mailer.verify.interval", flag.Lookup("smtp-verify-interval"))
+	conf.BindPFlag("mailer.dkim.enabled", flag.Lookup("dkim-enabled"))
+	conf.BindPFlag("mailer.dkim.domain", flag.Lookup("dkim-domain"))
+	conf.BindPFlag("mailer.dkim.selector", flag.Lookup("dkim-selector"))
+	conf.BindPFlag("mailer.dkim.privateKeyFile", flag.Lookup("dkim-private-key-file"))
 	conf.BindPFlag("user.dailyTargetsCreationLimit", flag.Lookup("daily-targets-creation-limit"))
 	conf.BindPFlag("user.dailyActionsCreationLimit", flag.Lookup("daily-actions-creation-limit"))
 	conf.BindPFlag("user.dailySessionsCreationLimit", flag.Lookup("daily-sessions-creation-limit"))
+	conf.BindPFlag("user.quota.refundOnDelete", flag.Lookup("refund-quota-on-delete"))
 
 	return config{
-		port:   conf.GetInt("server.port"),
-		env:    conf.GetString("server.env"),
-		pepper: conf.GetString("server.pepper"),
+		port:      conf.GetInt("server.port"),
+		listen:    conf.GetStringSlice("server.listen"),
+		env:       conf.GetString("server.env"),
+		pepper:    conf.GetString("server.pepper"),
+		operators: conf.GetStringSlice("server.operators"),
 		db: struct {
-			dsn          string
-			maxOpenConns int
-			maxIdleConns int
-			maxIdleTime  time.Duration
+			dsn                 string
+			maxOpenConns        int
+			maxIdleConns        int
+			maxIdleTime         time.Duration
+			poolMonitorInterval time.Duration
+			saturationThreshold time.Duration
+			breaker             struct {
+				enabled   bool
+				threshold int
+				cooldown  time.Duration
+			}
 		}{
-			dsn:          conf.GetString("database.dsn"),
-			maxOpenConns: conf.GetInt("database.maxOpenConns"),
-			maxIdleConns: conf.GetInt("database.maxIdleConns"),
-			maxIdleTime:  conf.GetDuration("database.maxIdleTime"),
+			dsn:                 conf.GetString("database.dsn"),
+			maxOpenConns:        conf.GetInt("database.maxOpenConns"),
+			maxIdleConns:        conf.GetInt("database.maxIdleConns"),
+			maxIdleTime:         conf.GetDuration("database.maxIdleTime"),
+			poolMonitorInterval: conf.GetDuration("database.poolMonitorInterval"),
+			saturationThreshold: conf.GetDuration("database.saturationThreshold"),
+			breaker: struct {
+				enabled   bool
+				threshold int
+				cooldown  time.Duration
+			}{
+				enabled:   conf.GetBool("database.breaker.enabled"),
+				threshold: conf.GetInt("database.breaker.threshold"),
+				cooldown:  conf.GetDuration("database.breaker.cooldown"),
+			},
 		},
 		limiter: struct {
-			rps     float64
-			burst   int
-			enabled bool
+			rps        float64
+			burst      int
+			enabled    bool
+			maxClients int
+		}{
+			rps:        conf.GetFloat64("server.limiter.rps"),
+			burst:      conf.GetInt("server.limiter.burst"),
+			enabled:    conf.GetBool("server.limiter.enabled"),
+			maxClients: conf.GetInt("server.limiter.maxClients"),
+		},
+		ipFilter: struct {
+			allow             []string
+			deny              []string
+			adminAllow        []string
+			trustProxyHeaders bool
 		}{
-			rps:     conf.GetFloat64("server.limiter.rps"),
-			burst:   conf.GetInt("server.limiter.burst"),
-			enabled: conf.GetBool("server.limiter.enabled"),
+			allow:             conf.GetStringSlice("server.ipFilter.allow"),
+			deny:              conf.GetStringSlice("server.ipFilter.deny"),
+			adminAllow:        conf.GetStringSlice("server.ipFilter.adminAllow"),
+			trustProxyHeaders: conf.GetBool("server.ipFilter.trustProxyHeaders"),
+		},
+		abuse: struct {
+			enabled         bool
+			burstWindow     time.Duration
+			burstLimit      int
+			burstPoints     float64
+			duplicatePoints float64
+			halfLife        time.Duration
+			stepUpScore     float64
+			freezeScore     float64
+			freezeDuration  time.Duration
+		}{
+			enabled:         conf.GetBool("server.abuse.enabled"),
+			burstWindow:     conf.GetDuration("server.abuse.burstWindow"),
+			burstLimit:      conf.GetInt("server.abuse.burstLimit"),
+			burstPoints:     conf.GetFloat64("server.abuse.burstPoints"),
+			duplicatePoints: conf.GetFloat64("server.abuse.duplicatePoints"),
+			halfLife:        conf.GetDuration("server.abuse.halfLife"),
+			stepUpScore:     conf.GetFloat64("server.abuse.stepUpScore"),
+			freezeScore:     conf.GetFloat64("server.abuse.freezeScore"),
+			freezeDuration:  conf.GetDuration("server.abuse.freezeDuration"),
 		},
 		tokens: struct {
-			activationTokenTTL    time.Duration
-			passwordResetTokenTTL time.Duration
-			accessTokenTTL        time.Duration
-			refreshTokenTTL       time.Duration
+			activationTokenTTL        time.Duration
+			passwordResetTokenTTL     time.Duration
+			accessTokenTTL            time.Duration
+			refreshTokenTTL           time.Duration
+			sessionRevocationTokenTTL time.Duration
+			invitationTokenTTL        time.Duration
+			emailLimit                int
+			emailWindow               time.Duration
+			activationCooldown        time.Duration
 		}{
 			activationTokenTTL:    conf.GetDuration("server.tokens.activationTokenTTL"),
 			passwordResetTokenTTL: conf.GetDuration("server.tokens.passwordResetTokenTTL"),
 			accessTokenTTL:        conf.GetDuration("server.tokens.accessTokenTTL"),
 			refreshTokenTTL:       conf.GetDuration("server.tokens.refreshTokenTTL"),
+			sessionRevocationTokenTTL: conf.GetDuration(
+				"server.tokens.sessionRevocationTokenTTL",
+			),
+			invitationTokenTTL: conf.GetDuration("server.tokens.invitationTokenTTL"),
+			emailLimit:         conf.GetInt("server.tokens.emailLimit"),
+			emailWindow:        conf.GetDuration("server.tokens.emailWindow"),
+			activationCooldown: conf.GetDuration("server.tokens.activationCooldown"),
 		},
 		smtp: struct {
-			host     string
-			port     int
-			username string
-			password string
-			sender   string
-		}{
-			host:     conf.GetString("mailer.smtp.host"),
-			port:     conf.GetInt("mailer.smtp.port"),
-			username: conf.GetString("mailer.smtp.username"),
-			password: conf.GetString("mailer.smtp.password"),
-			sender:   conf.GetString("mailer.sender"),
+			host               string
+			port               int
+			username           string
+			password           string
+			sender             string
+			checkDNS           bool
+			maxAttachmentsSize int
+			batchConcurrency   int
+			verify             struct {
+				enabled  bool
+				fatal    bool
+				interval time.Duration
+			}
+		}{
+			host:               conf.GetString("mailer.smtp.host"),
+			port:               conf.GetInt("mailer.smtp.port"),
+			username:           conf.GetString("mailer.smtp.username"),
+			password:           conf.GetString("mailer.smtp.password"),
+			sender:             conf.GetString("mailer.sender"),
+			checkDNS:           conf.GetBool("mailer.checkDNS"),
+			maxAttachmentsSize: conf.GetInt("mailer.maxAttachmentsSize"),
+			batchConcurrency:   conf.GetInt("mailer.batchConcurrency"),
+			verify: struct {
+				enabled  bool
+				fatal    bool
+				interval time.Duration
+			}{
+				enabled:  conf.GetBool("mailer.verify.enabled"),
+				fatal:    conf.GetBool("mailer.verify.fatal"),
+				interval: conf.GetDuration("mailer.verify.interval"),
+			},
+		},
+		dkim: struct {
+			enabled        bool
+			domain         string
+			selector       string
+			privateKeyFile string
+		}{
+			enabled:        conf.GetBool("mailer.dkim.enabled"),
+			domain:         conf.GetString("mailer.dkim.domain"),
+			selector:       conf.GetString("mailer.dkim.selector"),
+			privateKeyFile: conf.GetString("mailer.dkim.privateKeyFile"),
 		},
 		cleanup: struct {
 			interval time.Duration
 		}{
 			interval: conf.GetDuration("server.cleanup.interval"),
 		},
+		undo: struct {
+			ttl time.Duration
+		}{
+			ttl: conf.GetDuration("server.undo.ttl"),
+		},
+		starter: struct {
+			enabled bool
+		}{
+			enabled: conf.GetBool("server.starter.enabled"),
+		},
+		sharing: struct {
+			viewReceipts bool
+		}{
+			viewReceipts: conf.GetBool("server.sharing.viewReceipts"),
+		},
+		archive: struct {
+			enabled  bool
+			interval time.Duration
+		}{
+			enabled:  conf.GetBool("server.archive.enabled"),
+			interval: conf.GetDuration("server.archive.interval"),
+		},
+		unactivated: struct {
+			enabled     bool
+			interval    time.Duration
+			remindAfter time.Duration
+			deleteAfter time.Duration
+		}{
+			enabled:     conf.GetBool("server.unactivated.enabled"),
+			interval:    conf.GetDuration("server.unactivated.interval"),
+			remindAfter: conf.GetDuration("server.unactivated.remindAfter"),
+			deleteAfter: conf.GetDuration("server.unactivated.deleteAfter"),
+		},
+		retention: struct {
+			enabled             bool
+			interval            time.Duration
+			batchSize           int
+			sessionsDays        int
+			archivedTargetsDays int
+			archivedActionsDays int
+			devicesDays         int
+			plansDays           int
+			aclAuditDays        int
+		}{
+			enabled:             conf.GetBool("server.retention.enabled"),
+			interval:            conf.GetDuration("server.retention.interval"),
+			batchSize:           conf.GetInt("server.retention.batchSize"),
+			sessionsDays:        conf.GetInt("server.retention.sessionsDays"),
+			archivedTargetsDays: conf.GetInt("server.retention.archivedTargetsDays"),
+			archivedActionsDays: conf.GetInt("server.retention.archivedActionsDays"),
+			devicesDays:         conf.GetInt("server.retention.devicesDays"),
+			plansDays:           conf.GetInt("server.retention.plansDays"),
+			aclAuditDays:        conf.GetInt("server.retention.aclAuditDays"),
+		},
+		reminders: struct {
+			enabled  bool
+			interval time.Duration
+		}{
+			enabled:  conf.GetBool("server.reminders.enabled"),
+			interval: conf.GetDuration("server.reminders.interval"),
+		},
+		savedSearches: struct {
+			enabled    bool
+			interval   time.Duration
+			maxPerUser int
+		}{
+			enabled:    conf.GetBool("server.savedSearches.enabled"),
+			interval:   conf.GetDuration("server.savedSearches.interval"),
+			maxPerUser: conf.GetInt("server.savedSearches.maxPerUser"),
+		},
+		reportSchedules: struct {
+			enabled    bool
+			interval   time.Duration
+			maxPerUser int
+		}{
+			enabled:    conf.GetBool("server.reportSchedules.enabled"),
+			interval:   conf.GetDuration("server.reportSchedules.interval"),
+			maxPerUser: conf.GetInt("server.reportSchedules.maxPerUser"),
+		},
+		partitions: struct {
+			enabled  bool
+			interval time.Duration
+			ahead    int
+		}{
+			enabled:  conf.GetBool("server.partitions.enabled"),
+			interval: conf.GetDuration("server.partitions.interval"),
+			ahead:    conf.GetInt("server.partitions.ahead"),
+		},
 		cors: struct {
-			trustedOrigins []string
+			trustedOrigins   []string
+			allowedMethods   []string
+			allowedHeaders   []string
+			maxAge           time.Duration
+			allowCredentials bool
+		}{
+			trustedOrigins:   conf.GetStringSlice("server.corsTrustedOrigins"),
+			allowedMethods:   conf.GetStringSlice("server.cors.allowedMethods"),
+			allowedHeaders:   conf.GetStringSlice("server.cors.allowedHeaders"),
+			maxAge:           conf.GetDuration("server.cors.maxAge"),
+			allowCredentials: conf.GetBool("server.cors.allowCredentials"),
+		},
+		exemptions: struct {
+			users   []string
+			apiKeys []string
+			// synthetic are the name=key pairs of the synthetic traffic let through the
+			// rate limiter.
+			synthetic []string
+		}{
+			users:     conf.GetStringSlice("server.exemptions.users"),
+			apiKeys:   conf.GetStringSlice("server.exemptions.apiKeys"),
+			synthetic: conf.GetStringSlice("server.exemptions.synthetic"),
+		},
+		cookies: struct {
+			enabled  bool
+			domain   string
+			secure   bool
+			sameSite string
+		}{
+			enabled:  conf.GetBool("server.cookies.enabled"),
+			domain:   conf.GetString("server.cookies.domain"),
+			secure:   conf.GetBool("server.cookies.secure"),
+			sameSite: conf.GetString("server.cookies.sameSite"),
+		},
+		timeouts: struct {
+			request time.Duration
+			auth    time.Duration
+			export  time.Duration
+			// slowRequest is the duration past which requests are logged with the breakdown
+			// of their time, zero disables the tracing.
+			slowRequest time.Duration
+		}{
+			request:     conf.GetDuration("server.timeouts.request"),
+			auth:        conf.GetDuration("server.timeouts.auth"),
+			export:      conf.GetDuration("server.timeouts.export"),
+			slowRequest: conf.GetDuration("server.timeouts.slowRequest"),
+		},
+		faults: struct {
+			// enabled injects the latency and the errors below, in development only.
+			enabled         bool
+			dbLatency       time.Duration
+			dbErrorRate     float64
+			mailerLatency   time.Duration
+			mailerErrorRate float64
+		}{
+			enabled:         conf.GetBool("server.faults.enabled"),
+			dbLatency:       conf.GetDuration("server.faults.dbLatency"),
+			dbErrorRate:     conf.GetFloat64("server.faults.dbErrorRate"),
+			mailerLatency:   conf.GetDuration("server.faults.mailerLatency"),
+			mailerErrorRate: conf.GetFloat64("server.faults.mailerErrorRate"),
+		},
+		notesEncryption: struct {
+			enabled bool
+			// dataKey is the base64 data key of the deployment, encrypted by AWS KMS.
+			dataKey string
+			// searchable keeps indexing the encrypted notes for the full text search.
+			searchable bool
+		}{
+			enabled:    conf.GetBool("server.notesEncryption.enabled"),
+			dataKey:    conf.GetString("server.notesEncryption.dataKey"),
+			searchable: conf.GetBool("server.notesEncryption.searchable"),
+		},
+		backups: struct {
+			enabled bool
+			bucket  string
+			prefix  string
+			pgDump  string
+			timeout time.Duration
+			// alertEmails are emailed the failed backups.
+			alertEmails []string
+		}{
+			enabled:     conf.GetBool("server.backups.enabled"),
+			bucket:      conf.GetString("server.backups.bucket"),
+			prefix:      conf.GetString("server.backups.prefix"),
+			pgDump:      conf.GetString("server.backups.pgDump"),
+			timeout:     conf.GetDuration("server.backups.timeout"),
+			alertEmails: conf.GetStringSlice("server.backups.alertEmails"),
+		},
+		search: struct {
+			segmenter struct {
+				enabled  bool
+				lazy     bool
+				poolSize int
+			}
+			weights struct {
+				title       string
+				description string
+				reindex     bool
+			}
+			ranking struct {
+				weightA       float64
+				weightB       float64
+				weightC       float64
+				weightD       float64
+				normalization int
+			}
+			boost struct {
+				recency    float64
+				halfLife   time.Duration
+				inProgress float64
+				queued     float64
+				completed  float64
+				canceled   float64
+				archived   float64
+			}
+		}{
+			segmenter: struct {
+				enabled  bool
+				lazy     bool
+				poolSize int
+			}{
+				enabled:  conf.GetBool("search.segmenter.enabled"),
+				lazy:     conf.GetBool("search.segmenter.lazy"),
+				poolSize: conf.GetInt("search.segmenter.poolSize"),
+			},
+			weights: struct {
+				title       string
+				description string
+				reindex     bool
+			}{
+				title:       conf.GetString("search.weights.title"),
+				description: conf.GetString("search.weights.description"),
+				reindex:     conf.GetBool("search.weights.reindex"),
+			},
+			ranking: struct {
+				weightA       float64
+				weightB       float64
+				weightC       float64
+				weightD       float64
+				normalization int
+			}{
+				weightA:       conf.GetFloat64("search.ranking.weightA"),
+				weightB:       conf.GetFloat64("search.ranking.weightB"),
+				weightC:       conf.GetFloat64("search.ranking.weightC"),
+				weightD:       conf.GetFloat64("search.ranking.weightD"),
+				normalization: conf.GetInt("search.ranking.normalization"),
+			},
+			boost: struct {
+				recency    float64
+				halfLife   time.Duration
+				inProgress float64
+				queued     float64
+				completed  float64
+				canceled   float64
+				archived   float64
+			}{
+				recency:    conf.GetFloat64("search.boost.recency"),
+				halfLife:   conf.GetDuration("search.boost.halfLife"),
+				inProgress: conf.GetFloat64("search.boost.inProgress"),
+				queued:     conf.GetFloat64("search.boost.queued"),
+				completed:  conf.GetFloat64("search.boost.completed"),
+				canceled:   conf.GetFloat64("search.boost.canceled"),
+				archived:   conf.GetFloat64("search.boost.archived"),
+			},
+		},
+		security: struct {
+			webhook struct {
+				url     string
+				timeout time.Duration
+			}
+			newSignIn struct {
+				enabled       bool
+				countryHeader string
+				revokeURL     string
+			}
+		}{
+			webhook: struct {
+				url     string
+				timeout time.Duration
+			}{
+				url:     conf.GetString("security.webhook.url"),
+				timeout: conf.GetDuration("security.webhook.timeout"),
+			},
+			newSignIn: struct {
+				enabled       bool
+				countryHeader string
+				revokeURL     string
+			}{
+				enabled:       conf.GetBool("security.newSignIn.enabled"),
+				countryHeader: conf.GetString("security.newSignIn.countryHeader"),
+				revokeURL:     conf.GetString("security.newSignIn.revokeURL"),
+			},
+		},
+		oauth: struct {
+			google struct {
+				clientID     string
+				clientSecret string
+				redirectURL  string
+			}
 		}{
-			trustedOrigins: conf.GetStringSlice("server.corsTrustedOrigins"),
+			google: struct {
+				clientID     string
+				clientSecret string
+				redirectURL  string
+			}{
+				clientID:     conf.GetString("oauth.google.clientID"),
+				clientSecret: conf.GetString("oauth.google.clientSecret"),
+				redirectURL:  conf.GetString("oauth.google.redirectURL"),
+			},
 		},
 		user: struct {
 			dailyTargetsCreationLimit  int
 			dailyActionsCreationLimit  int
 			dailySessionsCreationLimit int
+			refundQuotaOnDelete        bool
 		}{
 			dailyTargetsCreationLimit:  conf.GetInt("user.dailyTargetsCreationLimit"),
 			dailyActionsCreationLimit:  conf.GetInt("user.dailyActionsCreationLimit"),
 			dailySessionsCreationLimit: conf.GetInt("user.dailySessionsCreationLimit"),
+			refundQuotaOnDelete:        conf.GetBool("user.quota.refundOnDelete"),
+		},
+		logs: struct {
+			redact     bool
+			redactKeys []string
+		}{
+			redact:     conf.GetBool("logs.redact"),
+			redactKeys: conf.GetStringSlice("logs.redactKeys"),
 		},
 	}, nil
 }
 
+// checkIntervals reports the intervals of the enabled background routines which are not
+// positive, as time.NewTicker panics on them. The database pool monitor is disabled by
+// a zero interval instead.
+func (cfg config) checkIntervals() error {
+	intervals := []struct {
+		key      string
+		enabled  bool
+		interval time.Duration
+	}{
+		{"server.cleanup.interval", true, cfg.cleanup.interval},
+		{"mailer.verify.interval", cfg.smtp.verify.enabled, cfg.smtp.verify.interval},
+		{"server.archive.interval", cfg.archive.enabled, cfg.archive.interval},
+		{"server.unactivated.interval", cfg.unactivated.enabled, cfg.unactivated.interval},
+		{"server.retention.interval", cfg.retention.enabled, cfg.retention.interval},
+		{"server.reminders.interval", cfg.reminders.enabled, cfg.reminders.interval},
+		{
+			"server.savedSearches.interval",
+			cfg.savedSearches.enabled,
+			cfg.savedSearches.interval,
+		},
+		{
+			"server.reportSchedules.interval",
+			cfg.reportSchedules.enabled,
+			cfg.reportSchedules.interval,
+		},
+		{"server.partitions.interval", cfg.partitions.enabled, cfg.partitions.interval},
+	}
+
+	for _, i := range intervals {
+		if i.enabled && i.interval <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", i.key, i.interval)
+		}
+	}
+
+	return nil
+}
+
 func writeConfigFile(content, config_file string) error {
 	if config_file == "" {
 		config_file = defaultConfigFile
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckIntervals(t *testing.T) {
+	var cfg config
+	cfg.cleanup.interval = time.Minute
+
+	if err := cfg.checkIntervals(); err != nil {
+		t.Fatalf("disabled routines without interval: %v", err)
+	}
+
+	cfg.reminders.enabled = true
+	err := cfg.checkIntervals()
+	if err == nil || !strings.Contains(err.Error(), "server.reminders.interval") {
+		t.Errorf("enabled routine without interval: got %v", err)
+	}
+
+	cfg.reminders.interval = time.Minute
+	cfg.cleanup.interval = -time.Second
+	err = cfg.checkIntervals()
+	if err == nil || !strings.Contains(err.Error(), "server.cleanup.interval") {
+		t.Errorf("negative cleanup interval: got %v", err)
+	}
+}
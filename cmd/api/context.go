@@ -4,12 +4,18 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/gofrs/uuid/v5"
 	"github.com/liuminhaw/yatijapp/internal/data"
 )
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey       = contextKey("user")
+	uuidParamContextKey  = contextKey("uuidParam")
+	apiVersionContextKey = contextKey("apiVersion")
+	validateContextKey   = contextKey("validateOnly")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -24,3 +30,47 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+func (app *application) contextSetUUIDParam(r *http.Request, id uuid.UUID) *http.Request {
+	ctx := context.WithValue(r.Context(), uuidParamContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetUUIDParam returns the UUID route parameter validated by the
+// requireUUIDParam middleware.
+func (app *application) contextGetUUIDParam(r *http.Request) uuid.UUID {
+	id, ok := r.Context().Value(uuidParamContextKey).(uuid.UUID)
+	if !ok {
+		panic("missing uuid parameter value in request context")
+	}
+
+	return id
+}
+
+func (app *application) contextSetAPIVersion(r *http.Request, version apiVersion) *http.Request {
+	ctx := context.WithValue(r.Context(), apiVersionContextKey, version)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIVersion returns the API version serving the request, requests outside
+// of the versioned API are reported as v1.
+func (app *application) contextGetAPIVersion(r *http.Request) apiVersion {
+	version, ok := r.Context().Value(apiVersionContextKey).(apiVersion)
+	if !ok {
+		return apiV1
+	}
+
+	return version
+}
+
+// contextSetValidateOnly marks the request as validating its payload only, the handlers
+// stop before writing anything.
+func (app *application) contextSetValidateOnly(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), validateContextKey, true)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextValidateOnly(r *http.Request) bool {
+	validateOnly, _ := r.Context().Value(validateContextKey).(bool)
+	return validateOnly
+}
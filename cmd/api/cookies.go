@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// authModeHeader is sent by browser clients wanting the tokens as cookies.
+	authModeHeader = "X-Auth-Mode"
+	// csrfHeader must echo the CSRF cookie on unsafe requests authenticated by cookie.
+	csrfHeader = "X-CSRF-Token"
+
+	accessTokenCookie  = "access_token"
+	refreshTokenCookie = "refresh_token"
+	csrfTokenCookie    = "csrf_token"
+)
+
+// cookieAuth holds the attributes of the cookies set for browser clients, which get
+// their tokens as HttpOnly cookies instead of keeping them in localStorage. Cookies are
+// sent automatically by browsers, so requests authenticated by cookie are protected
+// from CSRF with a double-submit token.
+type cookieAuth struct {
+	domain   string
+	secure   bool
+	sameSite http.SameSite
+}
+
+// newCookieAuth returns nil when the cookie mode is disabled.
+func newCookieAuth(
+	enabled bool,
+	domain string,
+	secure bool,
+	sameSite string,
+) (*cookieAuth, error) {
+	if !enabled {
+		return nil, nil
+	}
+
+	c := &cookieAuth{domain: domain, secure: secure}
+	switch strings.ToLower(sameSite) {
+	case "strict":
+		c.sameSite = http.SameSiteStrictMode
+	case "lax":
+		c.sameSite = http.SameSiteLaxMode
+	case "none":
+		// Browsers reject SameSite=None cookies which are not secure.
+		if !secure {
+			return nil, fmt.Errorf("cookies with SameSite=None must be secure")
+		}
+		c.sameSite = http.SameSiteNoneMode
+	default:
+		return nil, fmt.Errorf("invalid cookie SameSite attribute %q", sameSite)
+	}
+
+	return c, nil
+}
+
+func (c *cookieAuth) cookie(name, value, path string, ttl time.Duration) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   c.domain,
+		MaxAge:   int(ttl.Seconds()),
+		Secure:   c.secure,
+		HttpOnly: true,
+		SameSite: c.sameSite,
+	}
+}
+
+// cookieMode reports whether the tokens issued for the request are to be set as
+// cookies.
+func (app *application) cookieMode(r *http.Request) bool {
+	return app.cookies != nil && strings.EqualFold(r.Header.Get(authModeHeader), "cookie")
+}
+
+// setAuthCookies sets the authentication tokens as HttpOnly cookies along with a new
+// CSRF token, which is returned so that it can also be sent in the response body. The
+// refresh token cookie is only sent back to the token endpoints.
+func (app *application) setAuthCookies(
+	w http.ResponseWriter,
+	r *http.Request,
+	token AuthenticationToken,
+) string {
+	csrf := rand.Text()
+
+	access := app.cookies.cookie(
+		accessTokenCookie, token.AccessToken, "/", app.config.tokens.accessTokenTTL,
+	)
+	refresh := app.cookies.cookie(
+		refreshTokenCookie,
+		token.RefreshToken,
+		app.apiPath(r, "/tokens"),
+		app.config.tokens.refreshTokenTTL,
+	)
+	// The CSRF token is read by the frontend script to echo it in the request headers.
+	csrfCookie := app.cookies.cookie(
+		csrfTokenCookie, csrf, "/", app.config.tokens.refreshTokenTTL,
+	)
+	csrfCookie.HttpOnly = false
+
+	http.SetCookie(w, access)
+	http.SetCookie(w, refresh)
+	http.SetCookie(w, csrfCookie)
+
+	return csrf
+}
+
+// clearAuthCookies expires the authentication cookies of a signed out session.
+func (app *application) clearAuthCookies(w http.ResponseWriter, r *http.Request) {
+	for _, c := range []*http.Cookie{
+		app.cookies.cookie(accessTokenCookie, "", "/", 0),
+		app.cookies.cookie(refreshTokenCookie, "", app.apiPath(r, "/tokens"), 0),
+		app.cookies.cookie(csrfTokenCookie, "", "/", 0),
+	} {
+		c.MaxAge = -1
+		http.SetCookie(w, c)
+	}
+}
+
+// validCSRF reports whether a request authenticated by cookie is safe from CSRF. Unsafe
+// requests must carry the value of the CSRF cookie in the X-CSRF-Token header, which a
+// cross-site page is unable to read.
+func validCSRF(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	csrf, err := r.Cookie(csrfTokenCookie)
+	if err != nil || csrf.Value == "" {
+		return false
+	}
+	header := r.Header.Get(csrfHeader)
+	return subtle.ConstantTimeCompare([]byte(header), []byte(csrf.Value)) == 1
+}
+
+// writeAuthenticationToken sends the issued tokens, either in the response body or as
+// cookies for browser clients using the cookie mode.
+func (app *application) writeAuthenticationToken(
+	w http.ResponseWriter,
+	r *http.Request,
+	token AuthenticationToken,
+) error {
+	if !app.cookieMode(r) {
+		return app.writeJSON(w, http.StatusCreated, envelope{
+			"authentication_token": token,
+		}, nil)
+	}
+
+	csrf := app.setAuthCookies(w, r, token)
+	return app.writeJSON(w, http.StatusCreated, envelope{
+		"authentication_token": envelope{
+			"session_id": token.SessionUUID,
+			"csrf_token": csrf,
+		},
+	}, nil)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsPolicy is the policy applied to cross-origin requests, built once at startup from
+// the configuration.
+type corsPolicy struct {
+	origins map[string]struct{}
+	// anyOrigin trusts every origin, which is only allowed in development.
+	anyOrigin   bool
+	methods     string
+	headers     []string
+	maxAge      string
+	credentials bool
+}
+
+func newCORSPolicy(
+	env string,
+	origins, methods, headers []string,
+	maxAge time.Duration,
+	credentials bool,
+) (*corsPolicy, error) {
+	c := &corsPolicy{
+		origins:     make(map[string]struct{}, len(origins)),
+		credentials: credentials,
+	}
+
+	for _, origin := range origins {
+		origin = strings.TrimSpace(origin)
+		switch origin {
+		case "":
+			continue
+		case "*":
+			if env != "development" {
+				return nil, errors.New("the \"*\" CORS origin is only allowed in development")
+			}
+			c.anyOrigin = true
+		default:
+			c.origins[origin] = struct{}{}
+		}
+	}
+
+	for i := range methods {
+		methods[i] = strings.ToUpper(strings.TrimSpace(methods[i]))
+	}
+	methods = slices.DeleteFunc(methods, func(m string) bool { return m == "" })
+	c.methods = strings.Join(methods, ", ")
+
+	c.allowHeaders(headers...)
+
+	if maxAge > 0 {
+		c.maxAge = strconv.Itoa(int(maxAge.Seconds()))
+	}
+
+	return c, nil
+}
+
+// allowHeaders adds headers allowed in preflight requests.
+func (c *corsPolicy) allowHeaders(headers ...string) {
+	for _, header := range headers {
+		header = http.CanonicalHeaderKey(strings.TrimSpace(header))
+		if header != "" && !slices.Contains(c.headers, header) {
+			c.headers = append(c.headers, header)
+		}
+	}
+}
+
+// trusted reports whether cross-origin requests from the origin are allowed.
+func (c *corsPolicy) trusted(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+	_, ok := c.origins[origin]
+	return ok
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// maxDBPoolSamples is the number of pool samples kept in memory, at the default
+// monitor interval this covers the last 5 minutes.
+const maxDBPoolSamples = 60
+
+// dbPoolSample holds the connection pool usage observed during one monitor interval.
+type dbPoolSample struct {
+	Timestamp      int64 `json:"timestamp"`
+	OpenConns      int   `json:"open_connections"`
+	InUse          int   `json:"in_use"`
+	Idle           int   `json:"idle"`
+	WaitCount      int64 `json:"wait_count"`
+	WaitDurationMs int64 `json:"wait_duration_ms"`
+	Saturated      bool  `json:"saturated"`
+}
+
+// dbPoolMonitor keeps a short history of the connection pool statistics and tracks
+// how long the pool has been saturated.
+type dbPoolMonitor struct {
+	mu               sync.Mutex
+	samples          []dbPoolSample
+	last             sql.DBStats
+	saturatedSince   time.Time
+	saturationEvents int64
+	warned           bool
+}
+
+// record stores a new sample calculated from the difference between the given
+// statistics and the previous ones. It returns the sample, how long the pool has
+// been saturated, and whether the saturation just crossed the given threshold.
+func (m *dbPoolMonitor) record(
+	stats sql.DBStats,
+	now time.Time,
+	threshold time.Duration,
+) (dbPoolSample, time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sample := dbPoolSample{
+		Timestamp:      now.Unix(),
+		OpenConns:      stats.OpenConnections,
+		InUse:          stats.InUse,
+		Idle:           stats.Idle,
+		WaitCount:      stats.WaitCount - m.last.WaitCount,
+		WaitDurationMs: (stats.WaitDuration - m.last.WaitDuration).Milliseconds(),
+	}
+	// The pool is considered saturated when every allowed connection is in use or
+	// when requests had to wait for a connection during the last interval.
+	sample.Saturated = (stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections) ||
+		sample.WaitCount > 0
+	m.last = stats
+
+	m.samples = append(m.samples, sample)
+	if len(m.samples) > maxDBPoolSamples {
+		m.samples = m.samples[len(m.samples)-maxDBPoolSamples:]
+	}
+
+	if !sample.Saturated {
+		m.saturatedSince = time.Time{}
+		m.warned = false
+		return sample, 0, false
+	}
+
+	if m.saturatedSince.IsZero() {
+		m.saturatedSince = now
+	}
+	saturatedFor := now.Sub(m.saturatedSince)
+
+	// Only warn once per saturation period to avoid flooding the logs.
+	warn := saturatedFor >= threshold && !m.warned
+	if warn {
+		m.warned = true
+		m.saturationEvents++
+	}
+
+	return sample, saturatedFor, warn
+}
+
+// snapshot returns the pool history in a form suitable for publishing through expvar.
+func (m *dbPoolMonitor) snapshot() any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var saturatedFor float64
+	if !m.saturatedSince.IsZero() {
+		saturatedFor = time.Since(m.saturatedSince).Seconds()
+	}
+
+	return map[string]any{
+		"samples":               append([]dbPoolSample(nil), m.samples...),
+		"saturated_for_seconds": saturatedFor,
+		"saturation_events":     m.saturationEvents,
+	}
+}
+
+// startDBPoolMonitor periodically samples the database connection pool statistics,
+// publishes the wait counts and durations over time via expvar, and logs a warning
+// when the pool stays saturated for longer than the configured threshold.
+func (app *application) startDBPoolMonitor(db *sql.DB) {
+	monitor := &dbPoolMonitor{last: db.Stats()}
+	expvar.Publish("database_pool", expvar.Func(monitor.snapshot))
+
+	app.logger.Info("Database pool monitor started")
+
+	ticker := time.NewTicker(app.config.db.poolMonitorInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		stats := db.Stats()
+		sample, saturatedFor, warn := monitor.record(
+			stats,
+			now,
+			app.config.db.saturationThreshold,
+		)
+		if warn {
+			app.logger.Warn(
+				"database connection pool saturated, consider tuning maxOpenConns",
+				"saturated_for", saturatedFor.String(),
+				"max_open_connections", stats.MaxOpenConnections,
+				"in_use", stats.InUse,
+				"wait_count", sample.WaitCount,
+				"wait_duration_ms", sample.WaitDurationMs,
+			)
+		}
+	}
+}
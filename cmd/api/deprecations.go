@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tomasen/realip"
+)
+
+// deprecation marks a route of an API version as deprecated. Responses of the route
+// carry the Deprecation header, with the Sunset header once a removal date is set.
+type deprecation struct {
+	version apiVersion
+	method  string
+	path    string
+	// since is the date the route was deprecated.
+	since time.Time
+	// sunset is the date the route is going to be removed, if decided.
+	sunset time.Time
+	// link documents the deprecation or points to the replacing route.
+	link string
+}
+
+// deprecatedRoutes is the registry of deprecated routes, with their path relative to
+// the version prefix as registered in the route sets. Entries are removed along with
+// the route once its sunset date is over and no client uses it anymore.
+var deprecatedRoutes = []deprecation{}
+
+// deprecatedFields maps the deprecated fields of the request bodies to the fields
+// replacing them. Bodies using a deprecated field are decoded as if they used the
+// replacing one, so that clients keep working while they migrate. Fields are renamed
+// here rather than by accepting both names in the handlers, and entries are removed once
+// no client uses them anymore.
+var deprecatedFields = map[string]string{
+	"target_id": "target_uuid",
+	"action_id": "action_uuid",
+}
+
+// deprecationLog counts the use of deprecated routes and fields per client so that
+// maintainers know when they are safe to remove. The first use of a route, or field, by a
+// client on a given day is logged, later uses are only counted.
+type deprecationLog struct {
+	logger *slog.Logger
+	mu     sync.Mutex
+	day    string
+	seen   map[string]struct{}
+	calls  map[string]int64
+}
+
+func newDeprecationLog(logger *slog.Logger) *deprecationLog {
+	return &deprecationLog{
+		logger: logger,
+		seen:   make(map[string]struct{}),
+		calls:  make(map[string]int64),
+	}
+}
+
+func (l *deprecationLog) record(route, client, userAgent string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.calls[route]++
+
+	// Forget the clients seen on the previous days.
+	if day := time.Now().UTC().Format(time.DateOnly); day != l.day {
+		l.day = day
+		clear(l.seen)
+	}
+	key := route + " " + client
+	if _, ok := l.seen[key]; ok {
+		return
+	}
+	l.seen[key] = struct{}{}
+
+	l.logger.Warn(
+		"deprecated API used",
+		slog.String("route", route),
+		slog.String("client", client),
+		slog.String("user_agent", userAgent),
+	)
+}
+
+// stats returns the calls per deprecated route in a form suitable for publishing
+// through expvar.
+func (l *deprecationLog) stats() any {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	calls := make(map[string]int64, len(l.calls))
+	for route, n := range l.calls {
+		calls[route] = n
+	}
+
+	return map[string]any{
+		"calls":         calls,
+		"clients_today": len(l.seen),
+	}
+}
+
+// markDeprecated wraps the routes of the set registered as deprecated for the version.
+func (app *application) markDeprecated(routes *routeSet, version apiVersion) {
+	for _, d := range deprecatedRoutes {
+		if d.version != version {
+			continue
+		}
+
+		i := routes.index(d.method, d.path)
+		routes.routes[i].handler = app.deprecated(d, routes.routes[i].handler)
+	}
+}
+
+// deprecated is a middleware attaching the deprecation headers of a route to its
+// responses and recording which clients still use it.
+func (app *application) deprecated(d deprecation, next http.Handler) http.Handler {
+	route := d.method + " /" + string(d.version) + d.path
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "@"+strconv.FormatInt(d.since.Unix(), 10))
+		if !d.sunset.IsZero() {
+			w.Header().Set("Sunset", d.sunset.UTC().Format(http.TimeFormat))
+		}
+		if d.link != "" {
+			w.Header().Add("Link", "<"+d.link+`>; rel="deprecation"`)
+		}
+
+		app.deprecations.record(route, app.deprecationClient(r), r.UserAgent())
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// deprecationClient identifies the client of a request in the deprecation log, by user
+// when authenticated, by IP address otherwise.
+func (app *application) deprecationClient(r *http.Request) string {
+	if user := app.contextGetUser(r); !user.IsAnonymous() {
+		return "user:" + user.UUID.String()
+	}
+
+	return "ip:" + realip.FromRequest(r)
+}
+
+// renameDeprecatedFields returns the JSON body with its deprecated fields renamed to the
+// fields replacing them. Each use is announced in a Warning header of the response and
+// recorded in the deprecation log. Bodies which are not JSON objects are returned as is,
+// for the decoder to report them.
+func (app *application) renameDeprecatedFields(
+	w http.ResponseWriter,
+	r *http.Request,
+	body []byte,
+) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return body, nil
+	}
+
+	renamed := false
+	for deprecated, field := range deprecatedFields {
+		value, ok := fields[deprecated]
+		if !ok {
+			continue
+		}
+		if _, ok := fields[field]; ok {
+			return nil, fmt.Errorf("body must not contain both %q and %q", deprecated, field)
+		}
+		delete(fields, deprecated)
+		fields[field] = value
+		renamed = true
+
+		w.Header().Add(
+			"Warning",
+			fmt.Sprintf(`299 - "the %s field is deprecated, use %s instead"`, deprecated, field),
+		)
+		app.deprecations.record("field "+deprecated, app.deprecationClient(r), r.UserAgent())
+	}
+	if !renamed {
+		return body, nil
+	}
+
+	return json.Marshal(fields)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/liuminhaw/yatijapp/internal/platform"
+)
+
+// newNotesCipher returns the cipher of the notes at rest, nil when their encryption is
+// disabled. The data key of the deployment, encrypted by AWS KMS, is decrypted once at
+// startup and only kept in memory.
+func newNotesCipher(cfg config) (*data.NotesCipher, error) {
+	if !cfg.notesEncryption.enabled {
+		return nil, nil
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(cfg.notesEncryption.dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("notes encryption data key: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	key, err := platform.DecryptKMSDataKey(ctx, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("notes encryption data key: %w", err)
+	}
+
+	return data.NewNotesCipher(key, cfg.notesEncryption.searchable)
+}
@@ -1,8 +1,15 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/liuminhaw/yatijapp/internal/validator"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -20,6 +27,10 @@ func (app *application) errorResponse(
 	status int,
 	message any,
 ) {
+	// Messages are sent in the language requested by the client when translated.
+	if text, ok := message.(string); ok {
+		message = localize(app.negotiateLanguage(w, r), text)
+	}
 	env := envelope{"error": message}
 
 	err := app.writeJSON(w, status, env, nil)
@@ -29,20 +40,178 @@ func (app *application) errorResponse(
 	}
 }
 
+// dataErrorResponses maps the errors returned by the data models to the response sent
+// to the client. Errors matching none of them are reported as server errors.
+var dataErrorResponses = []struct {
+	err     error
+	status  int
+	code    string
+	message string
+}{
+	{
+		err:     data.ErrRecordNotFound,
+		status:  http.StatusNotFound,
+		code:    "not_found",
+		message: "the requested resource could not be found",
+	},
+	{
+		err:     data.ErrEditConflict,
+		status:  http.StatusConflict,
+		code:    "edit_conflict",
+		message: "unable to update the record due to an edit conflict, please try again",
+	},
+	{
+		err:     data.ErrQuotaExceeded,
+		status:  http.StatusTooManyRequests,
+		code:    "quota_exceeded",
+		message: "creation quota reached, renew on midnight UTC",
+	},
+	{
+		err:     data.ErrLimitReached,
+		status:  http.StatusConflict,
+		code:    "limit_reached",
+		message: "limit reached, delete some of the records before creating new ones",
+	},
+}
+
+// dataErrorResponse sends the response matching an error returned by the data models.
+// Typed errors carrying details, such as the reached quota, are reported with their
+// own message.
+func (app *application) dataErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	for _, m := range dataErrorResponses {
+		if !errors.Is(err, m.err) {
+			continue
+		}
+
+		lang := app.negotiateLanguage(w, r)
+		message := localize(lang, m.message)
+		var quotaErr *data.QuotaError
+		if errors.As(err, &quotaErr) {
+			message = localize(
+				lang,
+				"{resource} creation quota reached ({limit} per day, renew on midnight UTC)",
+				"resource", quotaErr.Resource,
+				"limit", strconv.Itoa(quotaErr.Limit),
+			)
+			kpiQuotaRejections.Add(quotaErr.Resource, 1)
+		}
+
+		env := envelope{"error": message, "error_code": m.code}
+		if err := app.writeJSON(w, m.status, env, nil); err != nil {
+			app.logError(r, err)
+			w.WriteHeader(500)
+		}
+		return
+	}
+
+	app.serverErrorResponse(w, r, err)
+}
+
 func (app *application) serverErrorResponse(w http.ResponseWriter, f *http.Request, err error) {
+	// Errors caused by the request budget being exceeded are reported as timeouts.
+	if errors.Is(f.Context().Err(), context.DeadlineExceeded) {
+		app.gatewayTimeoutResponse(w, f, err)
+		return
+	}
+
 	app.logError(f, err)
 
 	message := "The server encountered a problem and could not process your request"
 	app.errorResponse(w, f, http.StatusInternalServerError, message)
 }
 
+func (app *application) gatewayTimeoutResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warn(
+		"request time budget exceeded",
+		"method", r.Method,
+		"url", r.URL.RequestURI(),
+		"budget", app.routeBudget(r).String(),
+		"error", err.Error(),
+	)
+
+	message := localize(
+		app.negotiateLanguage(w, r),
+		"the request could not be completed within the {budget} time budget, please try again",
+		"budget", app.routeBudget(r).String(),
+	)
+	app.errorResponse(w, r, http.StatusGatewayTimeout, message)
+}
+
+func (app *application) databaseUnavailableResponse(
+	w http.ResponseWriter,
+	r *http.Request,
+	retryAfter time.Duration,
+) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "the service is temporarily unavailable, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
+func (app *application) tokenThrottledResponse(
+	w http.ResponseWriter,
+	r *http.Request,
+	retryAfter time.Duration,
+) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	message := localize(
+		app.negotiateLanguage(w, r),
+		"too many emails requested for this account, please try again later",
+	)
+	env := envelope{"error": message, "retry_after": seconds}
+	if err := app.writeJSON(w, http.StatusTooManyRequests, env, nil); err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+
+// verificationRequiredResponse asks a user whose writes look scripted to verify their
+// password again before writing.
+func (app *application) verificationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := localize(
+		app.negotiateLanguage(w, r),
+		"unusual activity detected, please verify your password to continue",
+	)
+	env := envelope{"error": message, "error_code": "verification_required"}
+	if err := app.writeJSON(w, http.StatusForbidden, env, nil); err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+
+// writesFrozenResponse rejects the writes of a user frozen by the abuse scoring.
+func (app *application) writesFrozenResponse(
+	w http.ResponseWriter,
+	r *http.Request,
+	retryAfter time.Duration,
+) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	message := localize(
+		app.negotiateLanguage(w, r),
+		"writes are temporarily disabled for this account, please try again later",
+	)
+	env := envelope{"error": message, "error_code": "writes_frozen", "retry_after": seconds}
+	if err := app.writeJSON(w, http.StatusTooManyRequests, env, nil); err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "the requested resource could not be found"
 	app.errorResponse(w, r, http.StatusNotFound, message)
 }
 
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
-	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
+	message := localize(
+		app.negotiateLanguage(w, r),
+		"the {method} method is not supported for this resource",
+		"method", r.Method,
+	)
 	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
 }
 
@@ -53,14 +222,18 @@ func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Reques
 func (app *application) failedValidationResponse(
 	w http.ResponseWriter,
 	r *http.Request,
-	errors map[string]string,
+	v *validator.Validator,
 ) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
-}
+	// The messages are rendered in the language requested by the client, the codes
+	// stay the same whatever the language so that clients can rely on them.
+	lang := app.negotiateLanguage(w, r)
+	env := envelope{"error": v.Localized(lang), "error_codes": v.Codes()}
 
-func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
-	message := "unable to update the target due to a edit conflict, please try again"
-	app.errorResponse(w, r, http.StatusConflict, message)
+	err := app.writeJSON(w, http.StatusUnprocessableEntity, env, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
 }
 
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
@@ -68,10 +241,6 @@ func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
 
-func (app *application) quotaExceededResponse(w http.ResponseWriter, r *http.Request, msg string) {
-	app.errorResponse(w, r, http.StatusTooManyRequests, msg)
-}
-
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -85,6 +254,25 @@ func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+func (app *application) invalidCSRFTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "missing or invalid CSRF token"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// ipDeniedResponse rejects a request from an IP address refused by the IP filter. The
+// matched rule is left out of the response, not to tell the client about the ranges.
+func (app *application) ipDeniedResponse(w http.ResponseWriter, r *http.Request) {
+	message := localize(
+		app.negotiateLanguage(w, r),
+		"access from your network address is not allowed",
+	)
+	env := envelope{"error": message, "error_code": "ip_denied"}
+	if err := app.writeJSON(w, http.StatusForbidden, env, nil); err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
+
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "you must be authenticated to access this resource"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+	"github.com/tomasen/realip"
+)
+
+// apiKeyHeader carries the key of the internal tools exempted from the limits.
+const apiKeyHeader = "X-Api-Key"
+
+// The rate limiter exemption of a bearer token is cached for tokenExemptionTTL, so that
+// throttled clients do not cost a user lookup on every request. At most
+// maxTokenExemptions tokens are cached.
+const (
+	tokenExemptionTTL  = time.Minute
+	maxTokenExemptions = 10_000
+)
+
+// tokenExemption is the cached rate limiter exemption of a bearer token.
+type tokenExemption struct {
+	exempt  bool
+	expires time.Time
+}
+
+// exemptions lists the users and API keys which are neither subject to the daily
+// creation quotas nor to the request rate limiter, such as internal tooling and
+// automated importers, along with the keys of the synthetic traffic.
+type exemptions struct {
+	logger *slog.Logger
+	users  map[uuid.UUID]struct{}
+	// keys holds the SHA-256 hashes of the exempted API keys.
+	keys [][sha256.Size]byte
+	// synthetic holds the SHA-256 hashes of the API keys of the synthetic traffic, such
+	// as uptime checks and load tests, keyed by name. The synthetic traffic is let
+	// through the rate limiter without consuming the budget of its IP address, which
+	// real users may share behind a NAT, but is still held to the quotas.
+	synthetic map[string][sha256.Size]byte
+
+	mu sync.Mutex
+	// tokens caches the exemptions of the bearer tokens by SHA-256 hash.
+	tokens map[[sha256.Size]byte]tokenExemption
+	// The synthetic requests are counted per name, the first one of each name on a
+	// given day is logged.
+	day   string
+	seen  map[string]struct{}
+	calls map[string]int64
+}
+
+// newExemptions returns the exemptions of the users and API keys, and of the keys of
+// the synthetic traffic given as name=key.
+func newExemptions(logger *slog.Logger, users, keys, synthetic []string) (*exemptions, error) {
+	e := &exemptions{
+		logger:    logger,
+		users:     make(map[uuid.UUID]struct{}, len(users)),
+		synthetic: make(map[string][sha256.Size]byte, len(synthetic)),
+		tokens:    make(map[[sha256.Size]byte]tokenExemption),
+		seen:      make(map[string]struct{}),
+		calls:     make(map[string]int64),
+	}
+
+	for _, user := range users {
+		id, err := uuid.FromString(strings.TrimSpace(user))
+		if err != nil {
+			return nil, fmt.Errorf("invalid exempted user %q: %w", user, err)
+		}
+		e.users[id] = struct{}{}
+	}
+
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		e.keys = append(e.keys, sha256.Sum256([]byte(key)))
+	}
+
+	for _, entry := range synthetic {
+		name, key, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" || key == "" {
+			// The key itself is left out of the error, which ends up in the logs.
+			return nil, errors.New("invalid synthetic traffic key, expected name=key")
+		}
+		e.synthetic[name] = sha256.Sum256([]byte(key))
+	}
+
+	return e, nil
+}
+
+// user reports whether the user with the given UUID is exempted.
+func (e *exemptions) user(id uuid.UUID) bool {
+	_, ok := e.users[id]
+	return ok
+}
+
+// apiKey reports whether the request carries an exempted API key. Every key is
+// compared in constant time so that the response time does not leak the keys.
+func (e *exemptions) apiKey(r *http.Request) bool {
+	key := r.Header.Get(apiKeyHeader)
+	if key == "" || len(e.keys) == 0 {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(key))
+	found := 0
+	for _, k := range e.keys {
+		found |= subtle.ConstantTimeCompare(hash[:], k[:])
+	}
+
+	return found == 1
+}
+
+// syntheticKey returns the name of the synthetic traffic key carried by the request, if
+// any. Every key is compared in constant time so that the response time does not leak
+// the keys.
+func (e *exemptions) syntheticKey(r *http.Request) (string, bool) {
+	key := r.Header.Get(apiKeyHeader)
+	if key == "" || len(e.synthetic) == 0 {
+		return "", false
+	}
+
+	hash := sha256.Sum256([]byte(key))
+	matched := ""
+	for name, k := range e.synthetic {
+		if subtle.ConstantTimeCompare(hash[:], k[:]) == 1 {
+			matched = name
+		}
+	}
+
+	return matched, matched != ""
+}
+
+// recordSynthetic counts a request of the named synthetic traffic.
+func (e *exemptions) recordSynthetic(name string, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.calls[name]++
+
+	if day := time.Now().UTC().Format(time.DateOnly); day != e.day {
+		e.day = day
+		clear(e.seen)
+	}
+	if _, ok := e.seen[name]; ok {
+		return
+	}
+	e.seen[name] = struct{}{}
+
+	e.logger.Info(
+		"synthetic traffic let through the rate limiter",
+		slog.String("name", name),
+		slog.String("ip", realip.FromRequest(r)),
+		slog.String("user_agent", r.UserAgent()),
+	)
+}
+
+// stats returns the synthetic requests per name in a form suitable for publishing
+// through expvar.
+func (e *exemptions) stats() any {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return map[string]any{"synthetic_calls": maps.Clone(e.calls)}
+}
+
+// quotaExempt reports whether the request, made by the given user, is exempted from
+// the daily creation quotas.
+func (app *application) quotaExempt(r *http.Request, user *data.User) bool {
+	return app.exemptions.user(user.UUID) || app.exemptions.apiKey(r)
+}
+
+// limiterExempt reports whether the request is exempted from the rate limiter. The
+// user is looked up from the bearer token, the lookup is only made when there are
+// exempted users and the request is about to be rejected anyway. The result is cached
+// by token, so that a throttled client does not turn into a flood of lookups.
+func (app *application) limiterExempt(r *http.Request) bool {
+	if app.exemptions.apiKey(r) {
+		return true
+	}
+	if len(app.exemptions.users) == 0 {
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(token))
+	if exempt, ok := app.exemptions.cachedToken(hash); ok {
+		return exempt
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		// Lookup failures are not cached, the token is looked up again next time.
+		return false
+	}
+
+	exempt := err == nil && app.exemptions.user(user.UUID)
+	app.exemptions.cacheToken(hash, exempt)
+
+	return exempt
+}
+
+// cachedToken returns the cached exemption of the token with the given hash, ok being
+// false when it is not cached or has expired.
+func (e *exemptions) cachedToken(hash [sha256.Size]byte) (exempt, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	entry, found := e.tokens[hash]
+	if !found || time.Now().After(entry.expires) {
+		return false, false
+	}
+
+	return entry.exempt, true
+}
+
+// cacheToken caches the exemption of the token with the given hash. Expired entries are
+// dropped once the cache is full, and the whole cache when none has expired.
+func (e *exemptions) cacheToken(hash [sha256.Size]byte, exempt bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	if len(e.tokens) >= maxTokenExemptions {
+		maps.DeleteFunc(e.tokens, func(_ [sha256.Size]byte, entry tokenExemption) bool {
+			return now.After(entry.expires)
+		})
+		if len(e.tokens) >= maxTokenExemptions {
+			clear(e.tokens)
+		}
+	}
+
+	e.tokens[hash] = tokenExemption{exempt: exempt, expires: now.Add(tokenExemptionTTL)}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/liuminhaw/yatijapp/internal/faults"
+)
+
+// newFaults returns the injectors of the faults into the database calls and the mail
+// deliveries, nil ones when the injection is disabled. The injection slows down and
+// breaks the service on purpose, it is refused outside of the development environment.
+func newFaults(cfg config) (db, mail *faults.Injector, err error) {
+	if !cfg.faults.enabled {
+		return nil, nil, nil
+	}
+	if cfg.env != "development" {
+		return nil, nil, errors.New("fault injection is only allowed in development")
+	}
+
+	db, err = faults.New(cfg.faults.dbLatency, cfg.faults.dbErrorRate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("database %w", err)
+	}
+	mail, err = faults.New(cfg.faults.mailerLatency, cfg.faults.mailerErrorRate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mailer %w", err)
+	}
+
+	return db, mail, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/liuminhaw/yatijapp/internal/data"
+)
+
+// fixtureVolumes are the maximum records generated per fixture user, on the heavy side
+// of the real users, so that the list and search queries are benchmarked at scale.
+var fixtureVolumes = data.FixtureVolumes{
+	Targets:           40,
+	ActionsPerTarget:  8,
+	SessionsPerAction: 6,
+}
+
+// fixturePassword is the password of the fixture users, to sign in as them when
+// benchmarking.
+const fixturePassword = "fixtures-password"
+
+// generateFixtures inserts n synthetic users, each with realistic volumes of targets,
+// actions and sessions mixing Chinese and English texts, to benchmark the full text
+// search and ACL list queries before releases. The users are activated and share the
+// fixturePassword, their emails are fixtures+<run>-<i>@example.com.
+func (app *application) generateFixtures(n int) error {
+	if app.config.env == "production" {
+		return errors.New("fixtures generation is not allowed in the production environment")
+	}
+
+	// Hash the password once, bcrypt is too slow to hash it for every user.
+	template := &data.User{}
+	if err := template.Password.Set(fixturePassword, app.config.pepper); err != nil {
+		return err
+	}
+
+	run := time.Now().Unix()
+	rng := rand.New(rand.NewPCG(uint64(run), uint64(n)))
+	start := time.Now()
+
+	var total data.FixtureCounts
+	for i := range n {
+		user := &data.User{
+			Name:      fmt.Sprintf("Fixture %d-%d", run, i),
+			Email:     fmt.Sprintf("fixtures+%d-%d@example.com", run, i),
+			Password:  template.Password,
+			Activated: true,
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		counts, err := app.models.InsertFixtureUser(ctx, user, fixtureVolumes, rng)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("generating fixture user %s: %w", user.Email, err)
+		}
+		total.Add(counts)
+
+		app.logger.Info(
+			"fixture user generated",
+			"user_uuid", user.UUID,
+			"user", user.Name,
+			"targets", counts.Targets,
+			"actions", counts.Actions,
+			"sessions", counts.Sessions,
+		)
+	}
+
+	app.logger.Info(
+		"fixtures generated",
+		"users", total.Users,
+		"targets", total.Targets,
+		"actions", total.Actions,
+		"sessions", total.Sessions,
+		"duration", time.Since(start),
+	)
+
+	return nil
+}
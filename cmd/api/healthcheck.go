@@ -1,16 +1,52 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"time"
+
+	"github.com/liuminhaw/yatijapp/internal/breaker"
+	"github.com/liuminhaw/yatijapp/internal/tokenizer"
 )
 
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
+	status := "available"
+	systemInfo := map[string]string{
+		"environment": app.config.env,
+		"version":     version,
+		"tokenizer":   tokenizer.Engine,
+	}
+	if !app.config.search.segmenter.enabled {
+		systemInfo["tokenizer"] = "simple"
+	}
+
+	if app.breaker != nil {
+		state := app.breaker.State()
+		systemInfo["database_breaker"] = state.String()
+		if state != breaker.Closed {
+			status = "degraded"
+		}
+	}
+
+	if app.mailerHealth != nil {
+		state, _, checkedAt := app.mailerHealth.snapshot()
+		systemInfo["mailer"] = state
+		if !checkedAt.IsZero() {
+			systemInfo["mailer_checked_at"] = checkedAt.Format(time.RFC3339)
+		}
+		if state == mailerUnreachable {
+			status = "degraded"
+		}
+	}
+
 	data := envelope{
-		"status": "available",
-		"system_info": map[string]string{
-			"environment": app.config.env,
-			"version":     version,
-		},
+		"status":      status,
+		"system_info": systemInfo,
+	}
+	// The schema version, the enabled features and the mailer error are internal details,
+	// only shown to the operators.
+	if app.operators.user(app.contextGetUser(r)) {
+		data["details"] = app.healthcheckDetails(r.Context())
 	}
 
 	err := app.writeJSON(w, http.StatusOK, data, nil)
@@ -18,3 +54,45 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// healthcheckDetails returns the applied schema version, a snapshot of the features
+// enabled by the configuration and the last error of an unreachable mailer.
+func (app *application) healthcheckDetails(ctx context.Context) envelope {
+	details := envelope{
+		"features": map[string]bool{
+			"limiter":          app.config.limiter.enabled,
+			"database_breaker": app.config.db.breaker.enabled,
+			"cookie_auth":      app.cookies != nil,
+			"smtp_verify":      app.config.smtp.verify.enabled,
+			"dkim":             app.config.dkim.enabled,
+			"new_sign_in":      app.config.security.newSignIn.enabled,
+			"segmenter":        app.config.search.segmenter.enabled,
+			"archive":          app.config.archive.enabled,
+			"unactivated":      app.config.unactivated.enabled,
+			"reminders":        app.config.reminders.enabled,
+			"partitions":       app.config.partitions.enabled,
+			"backups":          app.config.backups.enabled,
+			"notes_encryption": app.config.notesEncryption.enabled,
+			"retention":        app.config.retention.enabled,
+			"ip_filter":        app.ipFilter.enabled(),
+			"abuse_scoring":    app.config.abuse.enabled,
+		},
+	}
+
+	if app.mailerHealth != nil {
+		if state, lastErr, _ := app.mailerHealth.snapshot(); state == mailerUnreachable {
+			details["mailer_error"] = lastErr
+		}
+	}
+
+	version, dirty, err := app.models.SchemaVersion(ctx)
+	if err != nil {
+		details["schema_error"] = err.Error()
+		return details
+	}
+	details["schema_version"] = version
+	details["schema_dirty"] = dirty
+	details["fts_reindex_required"] = app.ftsReindexRequired
+
+	return details
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,15 +12,23 @@ import (
 	"maps"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/julienschmidt/httprouter"
+	"github.com/liuminhaw/yatijapp/internal/data"
 	"github.com/liuminhaw/yatijapp/internal/validator"
 )
 
+// maxBodyBytes is the maximum size of the JSON request bodies.
+const maxBodyBytes = 1_048_576 // 1 MB
+
+// readUUIDParam() reads the "uuid" route parameter. Resource identifiers are
+// generated as version 7 UUIDs, any other value cannot identify a resource and is
+// rejected.
 func (app *application) readUUIDParam(r *http.Request) (uuid.UUID, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
@@ -25,6 +36,9 @@ func (app *application) readUUIDParam(r *http.Request) (uuid.UUID, error) {
 	if err != nil {
 		return uuid.Nil, errors.New("invalid UUID parameter")
 	}
+	if id.Version() != uuid.V7 {
+		return uuid.Nil, errors.New("unsupported UUID parameter version")
+	}
 
 	return id, nil
 }
@@ -37,11 +51,13 @@ func (app *application) writeJSON(
 	data envelope,
 	headers http.Header,
 ) error {
+	start := time.Now()
 	js, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
 	js = append(js, '\n')
+	traceOf(w).Record("serialization", "json", start)
 
 	maps.Copy(w.Header(), headers)
 
@@ -52,19 +68,36 @@ func (app *application) writeJSON(
 	return nil
 }
 
+// errEmptyBody is returned by readJSON for a request without a body, so that handlers
+// accepting the input from elsewhere can tell it apart.
+var errEmptyBody = errors.New("body must not be empty")
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
-	// Limit the size of the request body to 1 MB
-	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576) // 1 MB limit
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		}
+		return err
+	}
 
-	dec := json.NewDecoder(r.Body)
+	// Deprecated fields are decoded as the fields replacing them.
+	body, err = app.renameDeprecatedFields(w, r, body)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
 	dec.DisallowUnknownFields()
 
-	err := dec.Decode(dst)
+	err = dec.Decode(dst)
 	if err != nil {
 		var syntaxError *json.SyntaxError
 		var unmarshalTypeError *json.UnmarshalTypeError
 		var invalidUnmarshalError *json.InvalidUnmarshalError
-		var maxBytesError *http.MaxBytesError
 
 		switch {
 		case errors.As(err, &syntaxError):
@@ -88,12 +121,10 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 				"body contains incorrectly formatted time value, expected RFC3339 format",
 			)
 		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
+			return errEmptyBody
 		case strings.HasPrefix(err.Error(), "json: unknown field "):
 			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
 			return fmt.Errorf("body contains unknown key %s", fieldName)
-		case errors.As(err, &maxBytesError):
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
 		// A json.InvalidUnmarshalError error will be returned if we pass something
 		// that is not a non-nil pointer as the target destination to Decode().
 		case errors.As(err, &invalidUnmarshalError):
@@ -112,6 +143,15 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	return nil
 }
 
+// defaultRecordSort returns the sort of the targets and actions listed without one,
+// searches being sorted by relevance.
+func defaultRecordSort(search string) string {
+	if search != "" {
+		return data.SortRelevance
+	}
+	return "-last_active"
+}
+
 // readString() returns a string value from the query string, or the provided
 // default value if no matching key is found.
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
@@ -140,22 +180,188 @@ func (app *application) readInt(
 
 	i, err := strconv.Atoi(s)
 	if err != nil {
-		v.AddError(key, "must be an integer")
+		v.AddFieldError(key, validator.NotInteger())
 		return defaultValue
 	}
 
 	return i
 }
 
+// maxCSVItems is the maximum number of values accepted in a comma separated query
+// string parameter.
+const maxCSVItems = 20
+
 // readCSV() reads a string value from the query string, splits if into a slice using
-// comma character. If no matching key is found, it returns the provided default value.
-func (app *application) readCSV(qs url.Values, key string, defaultValue []string) []string {
+// comma character. Values are trimmed, empty and duplicated ones are dropped. If no
+// matching key is found, it returns the provided default value. If there are more
+// than maxCSVItems values, we record an error message to the provided
+// validator.Validator instance.
+func (app *application) readCSV(
+	qs url.Values,
+	key string,
+	defaultValue []string,
+	v *validator.Validator,
+) []string {
 	csv := qs.Get(key)
 	if csv == "" {
 		return defaultValue
 	}
 
-	return strings.Split(csv, ",")
+	values := []string{}
+	for value := range strings.SplitSeq(csv, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" || slices.Contains(values, value) {
+			continue
+		}
+		values = append(values, value)
+	}
+
+	if len(values) > maxCSVItems {
+		v.AddFieldError(key, validator.TooLong(maxCSVItems, validator.UnitItems))
+		return defaultValue
+	}
+
+	return values
+}
+
+// readBool() reads a boolean value from the query string. Returns nil if no matching
+// key is found. If the conversion fails, we record an error message to the provided
+// validator.Validator instance.
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddFieldError(key, validator.InvalidFormat("must be a boolean value"))
+		return nil
+	}
+
+	return &b
+}
+
+// readRoles() reads the roles filter from the query string: the role parameter lists the
+// roles, while shared=true stands for the records shared with the user, and shared=false
+// for the records they own. Both parameters cannot be used together.
+func (app *application) readRoles(qs url.Values, v *validator.Validator) []string {
+	roles := app.readCSV(qs, "role", []string{}, v)
+
+	shared := app.readBool(qs, "shared", v)
+	switch {
+	case shared == nil:
+		return roles
+	case len(roles) > 0:
+		v.AddFieldError("shared", validator.NotPermitted("cannot be used along with role"))
+		return roles
+	case *shared:
+		return []string{"editor", "commenter", "viewer"}
+	default:
+		return []string{"owner"}
+	}
+}
+
+// readDate() reads a date in the YYYY-mm-dd format from the query string. Returns an
+// invalid sql.NullTime if no matching key is found. If the conversion fails, we record
+// an error message to the provided validator.Validator instance.
+func (app *application) readDate(qs url.Values, key string, v *validator.Validator) sql.NullTime {
+	s := qs.Get(key)
+	if s == "" {
+		return sql.NullTime{}
+	}
+
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		v.AddFieldError(key, validator.InvalidFormat("must be a date in the YYYY-mm-dd format"))
+		return sql.NullTime{}
+	}
+
+	return sql.NullTime{Time: t, Valid: true}
+}
+
+// pageLinks() builds the links to the pages around the current one from the request
+// URL, so that clients can follow them instead of rebuilding the query string. The
+// applied page size and sort order are written to every link. Pages beyond the
+// deepest reachable one are not linked.
+func (app *application) pageLinks(r *http.Request, metadata data.Metadata) data.PageLinks {
+	pageURL := func(page int) string {
+		qs := r.URL.Query()
+		qs.Set("page", strconv.Itoa(page))
+		qs.Set("page_size", strconv.Itoa(metadata.Filters.PageSize))
+		if metadata.Sort.Field != "" {
+			sort := metadata.Sort.Field
+			if metadata.Sort.Direction == "desc" {
+				sort = "-" + sort
+			}
+			qs.Set("sort", sort)
+		}
+
+		return (&url.URL{Path: r.URL.Path, RawQuery: qs.Encode()}).String()
+	}
+
+	links := data.PageLinks{Self: pageURL(metadata.Filters.Page)}
+	if metadata.TotalRecords == 0 {
+		return links
+	}
+
+	maxPage := data.MaxPage(metadata.Filters.PageSize)
+
+	links.First = pageURL(metadata.FirstPage)
+	if metadata.LastPage <= maxPage {
+		links.Last = pageURL(metadata.LastPage)
+	}
+	if metadata.CurrentPage < min(metadata.LastPage, maxPage) {
+		links.Next = pageURL(metadata.CurrentPage + 1)
+	}
+	if metadata.CurrentPage > metadata.FirstPage {
+		links.Prev = pageURL(min(metadata.CurrentPage-1, metadata.LastPage))
+	}
+
+	return links
+}
+
+// setLinkHeader() adds the links to the pages around the current one to the Link header
+// of the response (RFC 5988), so that generic HTTP clients can paginate without reading
+// the metadata of the body.
+func setLinkHeader(w http.ResponseWriter, links data.PageLinks) {
+	relations := []struct{ rel, url string }{
+		{"first", links.First},
+		{"prev", links.Prev},
+		{"next", links.Next},
+		{"last", links.Last},
+	}
+	for _, relation := range relations {
+		if relation.url != "" {
+			w.Header().Add("Link", "<"+relation.url+`>; rel="`+relation.rel+`"`)
+		}
+	}
+}
+
+// quotaRefund returns the daily quota to give back when a resource is deleted on the
+// day it was created, or nil when refunds are disabled.
+func (app *application) quotaRefund(resource string) *data.DailyQuota {
+	if !app.config.user.refundQuotaOnDelete {
+		return nil
+	}
+
+	return &data.DailyQuota{
+		UsageDate: time.Now().UTC(),
+		Resource:  resource,
+	}
+}
+
+// undoOperation returns the operation recording a deletion of the resource so that it
+// can be undone, or nil when undoing deletions is disabled.
+func (app *application) undoOperation(resource string) *data.UndoOperation {
+	if app.config.undo.ttl <= 0 {
+		return nil
+	}
+
+	return &data.UndoOperation{
+		ResourceType: resource,
+		TTL:          app.config.undo.ttl,
+	}
 }
 
 // background() runs the provided function in a separate goroutine, allowing it to
@@ -190,15 +396,57 @@ func (app *application) startCleanupRoutine() {
 	for range ticker.C {
 		app.logger.Info("Cleanup routine triggered")
 		app.background(func() {
-			rows, err := app.models.Tokens.DeleteAllExpired()
-			if err != nil {
-				app.logger.Error("Error during cleanup: " + err.Error())
-			} else {
-				app.logger.Info(
-					"Expired tokens cleaned up successfully",
-					slog.Int64("rows affected", rows),
-				)
-			}
+			app.runScheduled(jobCleanup, app.config.cleanup.interval, func() {
+				rows, err := app.models.Tokens.DeleteAllExpired(context.Background())
+				if err != nil {
+					app.logger.Error("Error during cleanup: " + err.Error())
+				} else {
+					app.logger.Info(
+						"Expired tokens cleaned up successfully",
+						slog.Int64("rows affected", rows),
+					)
+				}
+
+				rows, err = app.models.Tokens.DeleteAllExpiredIssues(context.Background())
+				if err != nil {
+					app.logger.Error("Error during cleanup: " + err.Error())
+				} else {
+					app.logger.Info(
+						"Expired token issues cleaned up successfully",
+						slog.Int64("rows affected", rows),
+					)
+				}
+
+				rows, err = app.models.UndoOperations.DeleteAllExpired(context.Background())
+				if err != nil {
+					app.logger.Error("Error during cleanup: " + err.Error())
+				} else {
+					app.logger.Info(
+						"Expired undo operations cleaned up successfully",
+						slog.Int64("rows affected", rows),
+					)
+				}
+
+				rows, err = app.models.Invitations.DeleteAllExpired(context.Background())
+				if err != nil {
+					app.logger.Error("Error during cleanup: " + err.Error())
+				} else {
+					app.logger.Info(
+						"Expired invitations cleaned up successfully",
+						slog.Int64("rows affected", rows),
+					)
+				}
+
+				rows, err = app.models.ACLs.DeleteAllOrphanedAudit(context.Background())
+				if err != nil {
+					app.logger.Error("Error during cleanup: " + err.Error())
+				} else {
+					app.logger.Info(
+						"Orphaned ACL audit entries cleaned up successfully",
+						slog.Int64("rows affected", rows),
+					)
+				}
+			})
 		})
 	}
 }
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// createInvitationHandler lets the owner of a target or action invite someone to share it,
+// by email. The invitee is emailed the invitation token, to accept or decline it. People
+// without an account are told to register with the invited email, the invitation is then
+// accepted once their account is activated.
+func (app *application) createInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ResourceType string    `json:"resource_type"`
+		ResourceUUID uuid.UUID `json:"resource_uuid"`
+		Email        string    `json:"email"`
+		Role         string    `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	invitation := data.Invitation{
+		ResourceType: input.ResourceType,
+		ResourceUUID: input.ResourceUUID,
+		Email:        strings.TrimSpace(input.Email),
+		Role:         input.Role,
+	}
+
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+	data.ValidateInvitation(v, &invitation)
+	v.CheckField(
+		!strings.EqualFold(invitation.Email, user.Email),
+		"email",
+		validator.Invalid("must not be your own email address"),
+	)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.models.Invitations.Insert(
+		r.Context(),
+		&invitation,
+		user.UUID,
+		app.config.tokens.invitationTokenTTL,
+	)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+	invitation.InviterName = user.Name
+
+	app.securityEvent(
+		r,
+		eventACLInvite,
+		outcomeSuccess,
+		user.UUID,
+		"resource_type", invitation.ResourceType,
+		"resource_uuid", invitation.ResourceUUID,
+		"role", invitation.Role,
+		"invitation_uuid", invitation.UUID,
+	)
+
+	app.background(func() {
+		_, err := app.models.Users.GetByEmail(context.Background(), invitation.Email)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.logger.Error(err.Error())
+			return
+		}
+
+		tmplData := map[string]any{
+			"inviter":         user.Name,
+			"type":            invitation.ResourceType,
+			"title":           invitation.Title,
+			"role":            invitation.Role,
+			"registered":      err == nil,
+			"invitationToken": invitation.Token,
+			"expiresAt":       invitation.ExpiresAt.UTC().Format("2006-01-02 15:04 MST"),
+		}
+
+		err = app.mailer.Send(invitation.Email, "invitation.tmpl", tmplData)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"invitation": invitation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listInvitationsHandler lists the invitations sent by the user, and the pending ones
+// sent to their email.
+func (app *application) listInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	sent, err := app.models.Invitations.GetAllSent(r.Context(), user.UUID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	received, err := app.models.Invitations.GetAllPending(r.Context(), user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"invitations": envelope{"sent": sent, "received": received}}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// acceptInvitationHandler accepts the invitation with the given token, sent to the email
+// of the user, who is granted its role on the shared record.
+func (app *application) acceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	token, ok := app.readInvitationToken(w, r)
+	if !ok {
+		return
+	}
+
+	user := app.contextGetUser(r)
+	invitation, err := app.models.Invitations.Accept(r.Context(), token, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidInvitationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.securityEvent(
+		r,
+		eventACLGrant,
+		outcomeSuccess,
+		user.UUID,
+		"resource_type", invitation.ResourceType,
+		"resource_uuid", invitation.ResourceUUID,
+		"role", invitation.Role,
+		"invitation_uuid", invitation.UUID,
+	)
+
+	app.notifyShared(r, user, invitation)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"invitation": invitation}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// declineInvitationHandler declines the invitation with the given token. No account is
+// needed, the token sent by email is enough.
+func (app *application) declineInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	token, ok := app.readInvitationToken(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Invitations.Decline(r.Context(), token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidInvitationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "invitation declined"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteInvitationHandler revokes a pending invitation sent by the user.
+func (app *application) deleteInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+	user := app.contextGetUser(r)
+
+	err := app.models.Invitations.Delete(r.Context(), id, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// acceptPendingInvitations grants the user the roles of the pending invitations sent to
+// their email, once their account is activated and the email proven to be theirs.
+func (app *application) acceptPendingInvitations(r *http.Request, user *data.User) {
+	invitations, err := app.models.Invitations.AcceptAllPending(r.Context(), user)
+	if err != nil {
+		app.logger.Error("Error accepting pending invitations: " + err.Error())
+		return
+	}
+
+	for _, invitation := range invitations {
+		app.securityEvent(
+			r,
+			eventACLGrant,
+			outcomeSuccess,
+			user.UUID,
+			"resource_type", invitation.ResourceType,
+			"resource_uuid", invitation.ResourceUUID,
+			"role", invitation.Role,
+			"invitation_uuid", invitation.UUID,
+		)
+	}
+
+	app.notifyShared(r, user, invitations...)
+}
+
+// notifyShared emails the user which records were shared with them by the accepted
+// invitations, by whom and with which role, unless they muted these emails. Invitations
+// which did not grant their role, the user having a higher one already, are left out.
+func (app *application) notifyShared(
+	r *http.Request,
+	user *data.User,
+	invitations ...*data.Invitation,
+) {
+	invitations = slices.DeleteFunc(invitations, func(invitation *data.Invitation) bool {
+		return !invitation.Granted
+	})
+	if len(invitations) == 0 {
+		return
+	}
+
+	preferences, err := app.models.UserPreferences.Get(r.Context(), user.UUID)
+	switch {
+	case err == nil && preferences.Notifications.MuteShared:
+		return
+	case err != nil && !errors.Is(err, data.ErrRecordNotFound):
+		app.logError(r, err)
+		return
+	}
+
+	app.background(func() {
+		for _, invitation := range invitations {
+			tmplData := map[string]any{
+				"username": user.Name,
+				"sharer":   invitation.InviterName,
+				"type":     invitation.ResourceType,
+				"title":    invitation.Title,
+				"role":     invitation.Role,
+			}
+			if err := app.mailer.Send(user.Email, "shared.tmpl", tmplData); err != nil {
+				app.logger.Error(err.Error())
+			}
+		}
+	})
+}
+
+func (app *application) readInvitationToken(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return "", false
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return "", false
+	}
+
+	return input.TokenPlaintext, true
+}
+
+func (app *application) invalidInvitationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	v.AddFieldError(
+		"token",
+		validator.NewFieldError(validator.CodeInvalidToken, "invalid or expired invitation token"),
+	)
+	app.failedValidationResponse(w, r, v)
+}
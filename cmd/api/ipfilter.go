@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/tomasen/realip"
+)
+
+// ipRule is a named range of IP addresses of the IP filter.
+type ipRule struct {
+	name   string
+	prefix netip.Prefix
+}
+
+// ipFilter rejects the requests by the IP address they come from, before they are
+// authenticated. The denied ranges are rejected everywhere. When ranges are allowed,
+// only the requests from them are served, and the admin and debug routes may be
+// restricted further to their own ranges. The requests are counted per matched rule.
+type ipFilter struct {
+	logger *slog.Logger
+	allow  []ipRule
+	deny   []ipRule
+	admin  []ipRule
+	// trustProxy reads the client address from the proxy headers, such as
+	// X-Forwarded-For, which the clients can forge when not behind a proxy.
+	trustProxy bool
+
+	mu       sync.Mutex
+	matched  map[string]int64
+	rejected map[string]int64
+}
+
+// newIPFilter returns the filter of the given rules, each a CIDR range or a single IP
+// address, optionally named as name=range. Unnamed rules are named after their range.
+func newIPFilter(
+	logger *slog.Logger,
+	allow, deny, admin []string,
+	trustProxy bool,
+) (*ipFilter, error) {
+	f := &ipFilter{
+		logger:     logger,
+		trustProxy: trustProxy,
+		matched:    make(map[string]int64),
+		rejected:   make(map[string]int64),
+	}
+
+	var err error
+	if f.allow, err = parseIPRules(allow); err != nil {
+		return nil, err
+	}
+	if f.deny, err = parseIPRules(deny); err != nil {
+		return nil, err
+	}
+	if f.admin, err = parseIPRules(admin); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func parseIPRules(rules []string) ([]ipRule, error) {
+	parsed := make([]ipRule, 0, len(rules))
+
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(rule, "=")
+		if !ok {
+			name, value = rule, rule
+		}
+
+		var prefix netip.Prefix
+		if addr, err := netip.ParseAddr(value); err == nil {
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		} else if prefix, err = netip.ParsePrefix(value); err != nil {
+			return nil, fmt.Errorf("invalid IP filter rule %q: %w", rule, err)
+		}
+
+		parsed = append(parsed, ipRule{name: name, prefix: prefix.Masked()})
+	}
+
+	return parsed, nil
+}
+
+// enabled reports whether the filter has any rule.
+func (f *ipFilter) enabled() bool {
+	return len(f.allow) > 0 || len(f.deny) > 0 || len(f.admin) > 0
+}
+
+// clientAddr returns the IP address the request comes from, the zero address when it
+// cannot be parsed.
+func (f *ipFilter) clientAddr(r *http.Request) netip.Addr {
+	ip := r.RemoteAddr
+	if f.trustProxy {
+		ip = realip.FromRequest(r)
+	} else if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return addr.Unmap()
+}
+
+// check returns the name of the rule matching the request from addr to the path, if
+// any, and whether the request is allowed. The deny rules are checked first, then the
+// admin rules for the admin and debug routes, then the allow rules. An invalid address
+// matches no rule, so it is only allowed where no range is.
+func (f *ipFilter) check(addr netip.Addr, path string) (string, bool) {
+	if rule, found := matchIPRule(f.deny, addr); found {
+		return rule, false
+	}
+
+	rules, fallback := f.allow, "not_allowed"
+	if len(f.admin) > 0 && (strings.HasPrefix(path, "/admin/") ||
+		strings.HasPrefix(path, "/debug/")) {
+		rules, fallback = f.admin, "not_allowed_admin"
+	}
+	if len(rules) == 0 {
+		return "", true
+	}
+	if rule, found := matchIPRule(rules, addr); found {
+		return rule, true
+	}
+
+	return fallback, false
+}
+
+func matchIPRule(rules []ipRule, addr netip.Addr) (string, bool) {
+	for _, rule := range rules {
+		if rule.prefix.Contains(addr) {
+			return rule.name, true
+		}
+	}
+	return "", false
+}
+
+// record counts a request matched by the named rule.
+func (f *ipFilter) record(rule string, allowed bool) {
+	if rule == "" {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if allowed {
+		f.matched[rule]++
+	} else {
+		f.rejected[rule]++
+	}
+}
+
+// stats returns the requests allowed and rejected per rule in a form suitable for
+// publishing through expvar.
+func (f *ipFilter) stats() any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	matched := make(map[string]int64, len(f.matched))
+	for rule, n := range f.matched {
+		matched[rule] = n
+	}
+	rejected := make(map[string]int64, len(f.rejected))
+	for rule, n := range f.rejected {
+		rejected[rule] = n
+	}
+
+	return map[string]any{"allowed": matched, "rejected": rejected}
+}
+
+// filterIPs rejects the requests refused by the IP filter with a 403 response, before
+// they reach the rate limiter and the authentication.
+func (app *application) filterIPs(next http.Handler) http.Handler {
+	if app.ipFilter == nil || !app.ipFilter.enabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, path := splitVersion(r.URL.Path)
+		addr := app.ipFilter.clientAddr(r)
+
+		rule, allowed := app.ipFilter.check(addr, path)
+		app.ipFilter.record(rule, allowed)
+		if !allowed {
+			app.ipFilter.logger.Warn(
+				"request rejected by the IP filter",
+				slog.String("rule", rule),
+				slog.String("ip", addr.String()),
+				slog.String("method", r.Method),
+				slog.String("url", r.URL.RequestURI()),
+			)
+			app.ipDeniedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Business KPIs, counted by the handlers. They are published through expvar along with
+// the other metrics, and in the OpenMetrics text format for the product dashboards.
+var (
+	kpiRegistrations   = expvar.NewInt("kpi_registrations")
+	kpiActivations     = expvar.NewInt("kpi_activations")
+	kpiSessionsStarted = expvar.NewInt("kpi_sessions_started")
+	// kpiQuotaRejections is keyed by the resource whose creation quota was reached.
+	kpiQuotaRejections = expvar.NewMap("kpi_quota_rejections")
+)
+
+type kpiMetric struct {
+	name string
+	help string
+	// label names the map keys of a labelled counter.
+	label string
+	value expvar.Var
+}
+
+var kpiMetrics = []kpiMetric{
+	{name: "yatijapp_registrations", help: "Users registered.", value: kpiRegistrations},
+	{name: "yatijapp_activations", help: "User accounts activated.", value: kpiActivations},
+	{name: "yatijapp_sessions_started", help: "Sessions started.", value: kpiSessionsStarted},
+	{
+		name:  "yatijapp_quota_rejections",
+		help:  "Creations rejected by the daily quotas.",
+		label: "resource",
+		value: kpiQuotaRejections,
+	},
+}
+
+// openMetricsHandler exposes the business KPIs in the OpenMetrics text format.
+func (app *application) openMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+
+	for _, m := range kpiMetrics {
+		fmt.Fprintf(&b, "# TYPE %s counter\n", m.name)
+		fmt.Fprintf(&b, "# HELP %s %s\n", m.name, m.help)
+
+		switch v := m.value.(type) {
+		case *expvar.Int:
+			fmt.Fprintf(&b, "%s_total %d\n", m.name, v.Value())
+		case *expvar.Map:
+			var keys []string
+			v.Do(func(kv expvar.KeyValue) { keys = append(keys, kv.Key) })
+			slices.Sort(keys)
+			for _, key := range keys {
+				fmt.Fprintf(&b, "%s_total{%s=%q} %s\n", m.name, m.label, key, v.Get(key))
+			}
+		}
+	}
+	b.WriteString("# EOF\n")
+
+	w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	if _, err := w.Write([]byte(b.String())); err != nil {
+		app.logError(r, err)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"cmp"
+	"container/list"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// maxReportedOffenders is the number of clients listed to the operators.
+const maxReportedOffenders = 10
+
+// limiterOffender is a client whose requests were rejected by the rate limiter.
+type limiterOffender struct {
+	IP       string    `json:"ip"`
+	Rejected int64     `json:"rejected"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// limiterClient holds the rate limiter state of a single client IP.
+type limiterClient struct {
+	ip       string
+	limiter  *rate.Limiter
+	lastSeen time.Time
+	rejected int64
+}
+
+// clientLimiter tracks a rate limiter per client IP. The number of tracked clients
+// is bounded, when the limit is reached the least recently seen client is evicted.
+type clientLimiter struct {
+	mu         sync.Mutex
+	rps        rate.Limit
+	burst      int
+	maxClients int
+	clients    map[string]*list.Element
+	// lru keeps the clients ordered by last seen time, most recent at the front.
+	lru        *list.List
+	evictions  int64
+	expired    int64
+	rejections int64
+}
+
+func newClientLimiter(rps float64, burst, maxClients int) *clientLimiter {
+	return &clientLimiter{
+		rps:        rate.Limit(rps),
+		burst:      burst,
+		maxClients: maxClients,
+		clients:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// allow reports whether a request from the given IP may proceed.
+func (cl *clientLimiter) allow(ip string) bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	var client *limiterClient
+	if elem, found := cl.clients[ip]; found {
+		client = elem.Value.(*limiterClient)
+		cl.lru.MoveToFront(elem)
+	} else {
+		if cl.maxClients > 0 && cl.lru.Len() >= cl.maxClients {
+			cl.remove(cl.lru.Back())
+			cl.evictions++
+		}
+
+		client = &limiterClient{ip: ip, limiter: rate.NewLimiter(cl.rps, cl.burst)}
+		cl.clients[ip] = cl.lru.PushFront(client)
+	}
+	client.lastSeen = time.Now()
+
+	if !client.limiter.Allow() {
+		client.rejected++
+		cl.rejections++
+		return false
+	}
+
+	return true
+}
+
+// cleanup removes the clients which have not been seen for longer than maxIdle.
+func (cl *clientLimiter) cleanup(maxIdle time.Duration) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	for elem := cl.lru.Back(); elem != nil; elem = cl.lru.Back() {
+		if time.Since(elem.Value.(*limiterClient).lastSeen) <= maxIdle {
+			break
+		}
+		cl.remove(elem)
+		cl.expired++
+	}
+}
+
+func (cl *clientLimiter) remove(elem *list.Element) {
+	client := cl.lru.Remove(elem).(*limiterClient)
+	delete(cl.clients, client.ip)
+}
+
+// stats returns the limiter statistics in a form suitable for publishing through
+// expvar. The clients are left out, see offenders.
+func (cl *clientLimiter) stats() any {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	return map[string]any{
+		"tracked_clients": cl.lru.Len(),
+		"max_clients":     cl.maxClients,
+		"evictions":       cl.evictions,
+		"expired":         cl.expired,
+		"rejections":      cl.rejections,
+	}
+}
+
+// offenders returns the tracked clients with the most rejected requests.
+func (cl *clientLimiter) offenders() []limiterOffender {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	offenders := []limiterOffender{}
+	for elem := cl.lru.Front(); elem != nil; elem = elem.Next() {
+		client := elem.Value.(*limiterClient)
+		if client.rejected > 0 {
+			offenders = append(offenders, limiterOffender{
+				IP:       client.ip,
+				Rejected: client.rejected,
+				LastSeen: client.lastSeen,
+			})
+		}
+	}
+	slices.SortFunc(offenders, func(a, b limiterOffender) int {
+		return cmp.Compare(b.Rejected, a.Rejected)
+	})
+	if len(offenders) > maxReportedOffenders {
+		offenders = offenders[:maxReportedOffenders]
+	}
+
+	return offenders
+}
+
+// startCleanupRoutine periodically forgets the clients not seen for a while.
+func (cl *clientLimiter) startCleanupRoutine() {
+	for {
+		time.Sleep(1 * time.Minute)
+		cl.cleanup(3 * time.Minute)
+	}
+}
+
+// listLimiterOffendersHandler lists the IP addresses with the most requests rejected by
+// the rate limiter to the operators.
+func (app *application) listLimiterOffendersHandler(w http.ResponseWriter, r *http.Request) {
+	env := envelope{"offenders": app.limiter.offenders()}
+	err := app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+)
+
+// listenAddrs returns the addresses the server should listen on. When no listen
+// address is configured the server listens on the configured port on all interfaces.
+func (app *application) listenAddrs() []string {
+	if len(app.config.listen) == 0 {
+		return []string{fmt.Sprintf(":%d", app.config.port)}
+	}
+	return app.config.listen
+}
+
+// openListeners opens a listener for each of the given addresses. An address either
+// takes the form "unix:<path>" for a unix domain socket, or "[tcp://]host:port" for
+// a TCP socket. If any of the listeners fails to open, the ones already opened are
+// closed before returning the error.
+func openListeners(addrs []string) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		l, err := openListener(addr)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("listen on %q: %w", addr, err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+func openListener(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		path = strings.TrimPrefix(path, "//")
+		if path == "" {
+			return nil, errors.New("missing unix socket path")
+		}
+
+		// Remove a socket left behind by a previous process which did not shut down
+		// cleanly, otherwise binding the address fails.
+		if info, err := os.Stat(path); err == nil && info.Mode().Type() == fs.ModeSocket {
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+		}
+
+		return net.Listen("unix", path)
+	}
+
+	return net.Listen("tcp", strings.TrimPrefix(addr, "tcp://"))
+}
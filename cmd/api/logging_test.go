@@ -0,0 +1,84 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// leakyFields are the fields holding secrets or personal data, which must not be passed
+// to the logger. The redacting handler masks them by key and pattern, the scan catches
+// the ones logged under another key or in a shape it does not recognize.
+var leakyFields = map[string]bool{
+	"Plaintext":    true,
+	"RefreshToken": true,
+	"Password":     true,
+	"Notes":        true,
+	"Email":        true,
+}
+
+// TestNoLeakyLogCalls scans the log calls of the packages for fields holding secrets or
+// personal data.
+func TestNoLeakyLogCalls(t *testing.T) {
+	var files []string
+	for _, pattern := range []string{"../../cmd/*/*.go", "../../internal/*/*.go"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, matches...)
+	}
+
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || !isLogCall(call) {
+				return true
+			}
+			for _, arg := range call.Args {
+				ast.Inspect(arg, func(n ast.Node) bool {
+					if sel, ok := n.(*ast.SelectorExpr); ok && leakyFields[sel.Sel.Name] {
+						t.Errorf("%s: %s logged", fset.Position(sel.Pos()), sel.Sel.Name)
+					}
+					return true
+				})
+			}
+			return true
+		})
+	}
+}
+
+// isLogCall reports whether the call is a logger.Debug, Info, Warn or Error call.
+func isLogCall(call *ast.CallExpr) bool {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	switch sel.Sel.Name {
+	case "Debug", "Info", "Warn", "Error":
+	default:
+		return false
+	}
+
+	switch recv := sel.X.(type) {
+	case *ast.Ident:
+		return recv.Name == "logger" || recv.Name == "slog"
+	case *ast.SelectorExpr:
+		return recv.Sel.Name == "logger"
+	}
+
+	return false
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/liuminhaw/yatijapp/internal/mailer"
+)
+
+// newMailDKIM returns the DKIM signer of the sent emails, or nil when DKIM is disabled.
+func newMailDKIM(cfg config) (*mailer.DKIM, error) {
+	if !cfg.dkim.enabled {
+		return nil, nil
+	}
+
+	domain := cfg.dkim.domain
+	if domain == "" {
+		var err error
+		domain, err = mailer.SenderDomain(cfg.smtp.sender)
+		if err != nil {
+			return nil, fmt.Errorf("dkim domain: %w", err)
+		}
+	}
+
+	key, err := os.ReadFile(cfg.dkim.privateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("dkim private key: %w", err)
+	}
+
+	return mailer.NewDKIM(domain, cfg.dkim.selector, key)
+}
+
+// checkMailDNS logs guidance on the DNS records the sender domain is missing for its
+// emails to be delivered.
+func checkMailDNS(logger *slog.Logger, sender string, dkim *mailer.DKIM) {
+	domain, err := mailer.SenderDomain(sender)
+	if err != nil {
+		logger.Error("Error checking the sender DNS records: " + err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	warnings := mailer.CheckDNS(ctx, domain, dkim)
+	for _, warning := range warnings {
+		logger.Warn(warning, "domain", domain)
+	}
+	if len(warnings) == 0 {
+		logger.Info("Sender DNS records found", "domain", domain)
+	}
+}
+
+// Mailer states reported by the health check.
+const (
+	mailerUnchecked   = "unchecked"
+	mailerAvailable   = "available"
+	mailerUnreachable = "unreachable"
+)
+
+// mailerHealth is the outcome of the last verification of the SMTP server.
+type mailerHealth struct {
+	mu        sync.RWMutex
+	state     string
+	err       string
+	checkedAt time.Time
+}
+
+func newMailerHealth() *mailerHealth {
+	return &mailerHealth{state: mailerUnchecked}
+}
+
+func (h *mailerHealth) record(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.state, h.err = mailerAvailable, ""
+	if err != nil {
+		h.state, h.err = mailerUnreachable, err.Error()
+	}
+	h.checkedAt = time.Now().UTC()
+}
+
+// snapshot returns the state of the mailer, the error of the last check if it failed,
+// and when it was checked.
+func (h *mailerHealth) snapshot() (string, string, time.Time) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.state, h.err, h.checkedAt
+}
+
+// verifyMailer checks the connection and credentials to the SMTP server, and records
+// the outcome for the health check.
+func (app *application) verifyMailer() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := app.mailer.Verify(ctx)
+	app.mailerHealth.record(err)
+
+	return err
+}
+
+// startMailerHealthRoutine periodically verifies the SMTP server, so that the health
+// check follows credentials being revoked or the server going down.
+func (app *application) startMailerHealthRoutine() {
+	ticker := time.NewTicker(app.config.smtp.verify.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := app.verifyMailer(); err != nil {
+			app.logger.Warn("SMTP server verification failed", "error", err.Error())
+		}
+	}
+}
+
+// mailPreviewSamples holds the sample data each mail template is rendered with in the
+// previews. Templates missing here are rendered without data.
+var mailPreviewSamples = map[string]map[string]any{
+	"activation_reminder.tmpl": {
+		"username":  "Jane Doe",
+		"deletesAt": "2025-01-08",
+	},
+	"archive_notice.tmpl": {
+		"username": "Jane Doe",
+		"records": []map[string]string{
+			{"type": "target", "title": "Learn Go", "archivesAt": "2025-01-08"},
+			{"type": "action", "title": "Read the spec", "archivesAt": "2025-01-09"},
+		},
+	},
+	"backup_failed.tmpl": {
+		"key":       "backups/yatijapp-20250101T120000Z.dump",
+		"startedAt": "2025-01-01T12:00:00Z",
+		"error":     "pg_dump: exit status 1: connection to server failed",
+	},
+	"invitation.tmpl": {
+		"inviter":         "John Roe",
+		"type":            "target",
+		"title":           "Learn Go",
+		"role":            "editor",
+		"registered":      false,
+		"invitationToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+		"expiresAt":       "2025-01-08 12:00 UTC",
+	},
+	"new_sign_in.tmpl": {
+		"username":    "Jane Doe",
+		"time":        "2025-01-01 12:00:00 UTC",
+		"ip":          "203.0.113.7",
+		"country":     "TW",
+		"userAgent":   "yatijapp-tui/1.0",
+		"revokeURL":   "",
+		"revokeToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+	},
+	"reminder.tmpl": {
+		"username": "Jane Doe",
+		"type":     "action",
+		"title":    "Read the spec",
+		"remindAt": "2025-01-08 09:30 CST",
+	},
+	"review_request.tmpl": {
+		"username":   "Jane Doe",
+		"title":      "Learn Go",
+		"targetUUID": "0199a1b2-c3d4-7e5f-8a9b-0c1d2e3f4a5b",
+	},
+	"saved_search.tmpl": {
+		"username": "Jane Doe",
+		"name":     "Due this week",
+		"type":     "action",
+		"records": []map[string]string{
+			{"title": "Read the spec", "status": "queued", "dueDate": "2025-01-08"},
+			{"title": "Write the report", "status": "in progress", "dueDate": ""},
+		},
+	},
+	"scheduled_report.tmpl": {
+		"username":  "Jane Doe",
+		"frequency": "monthly",
+		"from":      "2025-01-01",
+		"to":        "2025-02-01",
+		"sessions":  48,
+		"hours":     "37.5",
+		"rounding":  "",
+		"amounts":   []string{"3,400.00 EUR"},
+		"sources":   []string{"manual: 6.0", "timer: 31.5"},
+		"reviews": map[string]any{
+			"reviewed": 5,
+			"rating":   "4.2",
+			"pending":  0,
+		},
+	},
+	"shared.tmpl": {
+		"username": "Jane Doe",
+		"sharer":   "John Roe",
+		"type":     "target",
+		"title":    "Learn Go",
+		"role":     "editor",
+	},
+	"token_activation.tmpl": {
+		"username":        "Jane Doe",
+		"activationToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+	},
+	"token_password_reset.tmpl": {
+		"username":   "Jane Doe",
+		"resetToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+	},
+	"weekly_report.tmpl": {
+		"username": "Jane Doe",
+		"from":     "2025-01-01",
+		"to":       "2025-01-08",
+		"sessions": 12,
+		"hours":    "9.5",
+		"rounding": "15 minutes, up",
+		"amounts":  []string{"850.00 EUR", "12,000 JPY"},
+		"sources":  []string{"manual: 2.0", "timer: 7.5"},
+		"reviews": map[string]any{
+			"reviewed": 2,
+			"rating":   "4.5",
+			"pending":  1,
+		},
+	},
+	"user_welcome.tmpl": {
+		"username":        "Jane Doe",
+		"activationToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+	},
+}
+
+// listMailTemplatesHandler lists the mail templates which can be previewed.
+func (app *application) listMailTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	templates, err := mailer.Templates()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"templates": templates}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showMailPreviewHandler renders a mail template with its sample data, so that template
+// changes can be reviewed without sending any email. It is only routed in development.
+func (app *application) showMailPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("template")
+
+	templates, err := mailer.Templates()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !slices.Contains(templates, name) {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// The translated templates share the sample data of their English template.
+	base, _, _ := strings.Cut(name, ".")
+	message, err := mailer.Render(name, mailPreviewSamples[base+".tmpl"])
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The HTML body is served as is when asked for, to review it in a browser.
+	if r.URL.Query().Get("format") == "html" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if _, err := w.Write([]byte(message.HTMLBody)); err != nil {
+			app.logError(r, err)
+		}
+		return
+	}
+
+	env := envelope{"template": name, "preview": message}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
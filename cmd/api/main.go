@@ -8,28 +8,61 @@ import (
 	"log/slog"
 	"os"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
 	_ "github.com/lib/pq"
+	"github.com/liuminhaw/yatijapp/internal/breaker"
 	"github.com/liuminhaw/yatijapp/internal/data"
 	"github.com/liuminhaw/yatijapp/internal/mailer"
 	"github.com/liuminhaw/yatijapp/internal/platform"
+	"github.com/liuminhaw/yatijapp/internal/redact"
 	"github.com/liuminhaw/yatijapp/internal/tokenizer"
+	"github.com/liuminhaw/yatijapp/internal/validator"
 	"github.com/liuminhaw/yatijapp/internal/vcs"
 	flag "github.com/spf13/pflag"
 	"github.com/spf13/viper"
-	"github.com/yanyiwu/gojieba"
 )
 
 var version = vcs.Version()
 
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
-	mailer *mailer.Mailer
-	wg     sync.WaitGroup
+	config  config
+	logger  *slog.Logger
+	models  data.Models
+	mailer  *mailer.Mailer
+	breaker *breaker.Breaker
+	// security logs the security events on their own channel.
+	security *securityLog
+	// limiter rate limits the requests per client IP address, nil when it is disabled.
+	limiter *clientLimiter
+	// exemptions lists the users and API keys not subject to quotas and rate limiting.
+	exemptions *exemptions
+	// ipFilter rejects the requests by the IP address they come from.
+	ipFilter *ipFilter
+	// abuse scores the writes of the users, nil when it is disabled.
+	abuse *abuseScorer
+	// operators lists the users shown the healthcheck details and the admin routes.
+	operators *operators
+	// backups runs the database backups, nil when they are disabled.
+	backups *backups
+	// retention keeps the reports of the retention runs, nil when it is disabled.
+	retention *retentionLog
+	// cookies is nil unless browser clients may authenticate with cookies.
+	cookies *cookieAuth
+	// cors is the policy applied to cross-origin requests.
+	cors *corsPolicy
+	// deprecations records the use of deprecated routes.
+	deprecations *deprecationLog
+	// clients holds the registered client apps and their request statistics.
+	clients *clientApps
+	// mailerHealth is nil unless the SMTP server is verified.
+	mailerHealth *mailerHealth
+	// ftsReindexRequired is set when the configured search weights wait for the records
+	// to be reindexed.
+	ftsReindexRequired bool
+	wg                 sync.WaitGroup
 }
 
 func main() {
@@ -38,7 +71,17 @@ func main() {
 	vConf := viper.New()
 
 	flag.Int("port", 8080, "API server port")
+	flag.StringSlice(
+		"listen",
+		[]string{},
+		"Addresses to listen on, as host:port or unix:<path> (comma separated, overrides port)",
+	)
 	flag.String("env", "development", "Environment (development|staging|production)")
+	flag.StringSlice(
+		"operators",
+		[]string{},
+		"User UUIDs shown the details of the healthcheck (comma separated)",
+	)
 	flag.String(
 		"db-dsn",
 		"",
@@ -59,10 +102,121 @@ func main() {
 		15*time.Minute,
 		"Maximum amount of time a connection may be idle",
 	)
+	flag.Duration(
+		"db-pool-monitor-interval",
+		5*time.Second,
+		"Interval between database connection pool samples, 0 disables the monitor",
+	)
+	flag.Duration(
+		"db-saturation-threshold",
+		30*time.Second,
+		"Duration the connection pool may stay saturated before logging a warning",
+	)
+	flag.Bool("db-breaker-enabled", true, "Enable the database circuit breaker")
+	flag.Int(
+		"db-breaker-threshold",
+		5,
+		"Consecutive database failures before the circuit breaker opens",
+	)
+	flag.Duration(
+		"db-breaker-cooldown",
+		30*time.Second,
+		"Duration the database circuit breaker stays open",
+	)
 	flag.Float64("limiter-rps", 2, "Max requests per second limit")
 	flag.Int("limiter-burst", 4, "Max burst size for rate limiter")
 	flag.Bool("limiter-enabled", true, "Enable rate limiting")
+	flag.Int("limiter-max-clients", 10000, "Max number of client IPs tracked by the rate limiter")
+	flag.StringSlice(
+		"ip-allow",
+		[]string{},
+		"IP ranges allowed to use the service, as CIDR or name=CIDR (comma separated)",
+	)
+	flag.StringSlice(
+		"ip-deny",
+		[]string{},
+		"IP ranges rejected, as CIDR or name=CIDR (comma separated)",
+	)
+	flag.StringSlice(
+		"ip-admin-allow",
+		[]string{},
+		"IP ranges allowed on the admin and debug routes, as CIDR or name=CIDR (comma separated)",
+	)
+	flag.Bool(
+		"ip-trust-proxy-headers",
+		false,
+		"Read the client IP address of the IP filter from the proxy headers",
+	)
+	flag.Bool("abuse-enabled", false, "Score the write patterns of the users to catch abuse")
+	flag.Duration("abuse-burst-window", 1*time.Minute, "Window the burst creations are counted in")
+	flag.Int("abuse-burst-limit", 10, "Creations within the burst window before scoring")
+	flag.Float64("abuse-burst-points", 10, "Abuse points of each creation past the burst limit")
+	flag.Float64("abuse-duplicate-points", 5, "Abuse points of each repeated title")
+	flag.Duration("abuse-half-life", 10*time.Minute, "Time it takes for an abuse score to halve")
+	flag.Float64("abuse-step-up-score", 50, "Abuse score requiring a password verification")
+	flag.Float64("abuse-freeze-score", 100, "Abuse score freezing the writes of a user")
+	flag.Duration("abuse-freeze-duration", 15*time.Minute, "Duration the writes stay frozen")
 
+	flag.Bool("log-redact", true, "Mask the emails, tokens and notes in the logs")
+	flag.StringSlice(
+		"log-redact-keys",
+		[]string{},
+		"Keys of the log attributes redacted along with the default ones (comma separated)",
+	)
+	flag.Bool(
+		"search-segmenter-enabled",
+		true,
+		"Segment Chinese text with the compiled-in engine instead of single characters",
+	)
+	flag.Bool(
+		"search-segmenter-lazy",
+		true,
+		"Load the segmenter dictionaries on first use instead of at startup",
+	)
+	flag.Int("search-segmenter-pool-size", 1, "Maximum number of concurrent segmenters")
+	flag.String("search-title-weight", "A", "Weight of the titles in the search vectors (A-D)")
+	flag.String(
+		"search-description-weight",
+		"B",
+		"Weight of the descriptions in the search vectors (A-D)",
+	)
+	flag.Bool("search-reindex", false, "Reindex the search vectors at startup if weights changed")
+	flag.Float64("search-rank-weight-a", 1.0, "Rank weight of the A weighted lexemes")
+	flag.Float64("search-rank-weight-b", 0.4, "Rank weight of the B weighted lexemes")
+	flag.Float64("search-rank-weight-c", 0.2, "Rank weight of the C weighted lexemes")
+	flag.Float64("search-rank-weight-d", 0.1, "Rank weight of the D weighted lexemes")
+	flag.Int("search-rank-normalization", 0, "ts_rank normalization bit mask of the search rank")
+	flag.Float64("search-boost-recency", 0.5, "Relevance boost of the records active right now")
+	flag.Duration(
+		"search-boost-half-life",
+		30*24*time.Hour,
+		"Inactivity halving the recency boost of the relevance (0 to disable)",
+	)
+	flag.Float64("search-boost-in-progress", 0.3, "Relevance boost of the in progress records")
+	flag.Float64("search-boost-queued", 0, "Relevance boost of the queued records")
+	flag.Float64("search-boost-completed", -0.1, "Relevance boost of the completed records")
+	flag.Float64("search-boost-canceled", -0.2, "Relevance boost of the canceled records")
+	flag.Float64("search-boost-archived", -0.5, "Relevance boost of the archived records")
+	flag.String("security-webhook-url", "", "Webhook receiving the security events (e.g. a SIEM)")
+	flag.Duration("security-webhook-timeout", 5*time.Second, "Security events webhook timeout")
+	flag.Bool("new-sign-in-enabled", true, "Email users signing in from a new device")
+	flag.String(
+		"new-sign-in-country-header",
+		"",
+		"Request header holding the client country set by a proxy (e.g. CF-IPCountry)",
+	)
+	flag.String(
+		"new-sign-in-revoke-url",
+		"",
+		"Frontend page revoking a session, the revocation token is added as the token parameter",
+	)
+	flag.String("oauth-google-client-id", "", "Google OAuth client ID")
+	flag.String("oauth-google-client-secret", "", "Google OAuth client secret")
+	flag.String(
+		"oauth-google-redirect-url",
+		"",
+		"Redirect URI of the Google sign-in, which is disabled unless set",
+	)
 	flag.String("smtp-host", "sandbox.smtp.mailtrap.io", "SMTP server host")
 	flag.Int("smtp-port", 25, "SMTP server port")
 	flag.String("smtp-username", "", "SMTP server username")
@@ -72,15 +226,181 @@ func main() {
 		"Yatijapp <no-reply@yatijapp.fakemail.com>",
 		"Sender email address",
 	)
+	flag.Bool("smtp-check-dns", false, "Check the sender SPF, DMARC and DKIM records at startup")
+	flag.Int(
+		"smtp-max-attachments-size",
+		mailer.DefaultMaxAttachmentsSize,
+		"Maximum total size in bytes of the files attached to an email",
+	)
+	flag.Int("smtp-batch-concurrency", 4, "SMTP connections used to send batches of emails")
+	flag.Bool("smtp-verify", true, "Verify the SMTP server connection and credentials")
+	flag.Bool("smtp-verify-fatal", false, "Exit at startup when the SMTP server verification fails")
+	flag.Duration("smtp-verify-interval", 5*time.Minute, "SMTP server verification interval")
+	flag.Bool("dkim-enabled", false, "Sign the sent emails with DKIM")
+	flag.String("dkim-domain", "", "DKIM signing domain, defaults to the sender domain")
+	flag.String("dkim-selector", "", "Selector the DKIM public key is published under")
+	flag.String("dkim-private-key-file", "", "PEM file of the DKIM private key (RSA or Ed25519)")
 	flag.Duration("ttl-activation-token", 10*time.Minute, "Activation token lifetime")
 	flag.Duration("ttl-password-reset-token", 10*time.Minute, "Password reset token lifetime")
 	flag.Duration("ttl-access-token", 1*time.Hour, "Access token lifetime")
 	flag.Duration("ttl-refresh-token", 24*time.Hour, "Refresh token lifetime")
+	flag.Duration(
+		"ttl-session-revocation-token",
+		7*24*time.Hour,
+		"Lifetime of the session revocation links sent on new sign-ins",
+	)
+	flag.Duration("ttl-invitation-token", 7*24*time.Hour, "Lifetime of the invitations to share")
+	flag.Int(
+		"token-email-limit",
+		3,
+		"Activation or password reset emails a user can request per window (0 to disable)",
+	)
+	flag.Duration("token-email-window", 1*time.Hour, "Window of the token email limit")
+	flag.Duration(
+		"token-activation-cooldown",
+		1*time.Minute,
+		"Minimum delay between two activation emails (0 to disable)",
+	)
 	flag.Duration("cleanup-interval", 1*time.Hour, "Background cleanup interval")
+	flag.Duration("undo-ttl", 10*time.Minute, "How long deletions can be undone (0 to disable)")
+	flag.Bool("starter-enabled", false, "Create a getting started target on account activation")
+	flag.Bool(
+		"sharing-view-receipts",
+		true,
+		"Show owners when collaborators last viewed their shared targets",
+	)
+	flag.Bool("archive-enabled", true, "Evaluate the users archive rules in background")
+	flag.Duration("archive-interval", 1*time.Hour, "Archive rules evaluation interval")
+	flag.Bool("unactivated-enabled", true, "Remind and delete unactivated accounts in background")
+	flag.Duration("unactivated-interval", 1*time.Hour, "Unactivated accounts check interval")
+	flag.Duration(
+		"unactivated-remind-after",
+		24*time.Hour,
+		"Delay after registration before reminding users to activate their account",
+	)
+	flag.Duration(
+		"unactivated-delete-after",
+		7*24*time.Hour,
+		"Delay after registration before deleting accounts not activated",
+	)
+	flag.Bool("retention-enabled", false, "Purge the records past their retention in background")
+	flag.Duration("retention-interval", 24*time.Hour, "Retention policies enforcement interval")
+	flag.Int("retention-batch-size", 1000, "Records deleted per statement by the retention")
+	flag.Int("retention-sessions-days", 0, "Days the sessions are kept, 0 keeps them")
+	flag.Int(
+		"retention-archived-targets-days",
+		0,
+		"Days the archived targets are kept after their last update, 0 keeps them",
+	)
+	flag.Int(
+		"retention-archived-actions-days",
+		0,
+		"Days the archived actions are kept after their last update, 0 keeps them",
+	)
+	flag.Int("retention-devices-days", 0, "Days the unseen sign-in devices are kept, 0 keeps them")
+	flag.Int("retention-plans-days", 0, "Days the daily plans are kept, 0 keeps them")
+	flag.Int("retention-acl-audit-days", 0, "Days the audited ACL changes are kept, 0 keeps them")
+	flag.Bool("reminders-enabled", true, "Send the due reminders in background")
+	flag.Duration("reminders-interval", 1*time.Minute, "Due reminders check interval")
+	flag.Bool("saved-searches-enabled", true, "Send the new results of the saved searches")
+	flag.Duration("saved-searches-interval", 1*time.Hour, "Saved searches evaluation interval")
+	flag.Int("saved-searches-max-per-user", 20, "Maximum number of saved searches per user")
+	flag.Bool("report-schedules-enabled", true, "Send the scheduled report exports")
+	flag.Duration("report-schedules-interval", 15*time.Minute, "Report schedules check interval")
+	flag.Int("report-schedules-max-per-user", 5, "Maximum number of report schedules per user")
+	flag.Bool("partitions-enabled", true, "Create the session partitions in background")
+	flag.Duration("partitions-interval", 24*time.Hour, "Session partitions check interval")
+	flag.Int("partitions-ahead", 3, "Months the session partitions are created in advance")
+	flag.Duration("timeout-request", 5*time.Second, "Default request time budget")
+	flag.Duration("timeout-auth", 3*time.Second, "Request time budget for auth endpoints")
+	flag.Duration("timeout-export", 30*time.Second, "Request time budget for export endpoints")
+	flag.Duration(
+		"slow-request-threshold",
+		2*time.Second,
+		"Log the requests slower than this with the breakdown of their time, 0 disables",
+	)
+	flag.Bool("notes-encryption-enabled", false, "Encrypt the notes at rest with a KMS data key")
+	flag.String(
+		"notes-encryption-data-key",
+		"",
+		"Base64 data key encrypting the notes, itself encrypted by AWS KMS",
+	)
+	flag.Bool(
+		"notes-encryption-searchable",
+		false,
+		"Index the encrypted notes for the full text search",
+	)
+	flag.Bool("backups-enabled", false, "Enable the database backups to S3 through the admin API")
+	flag.String("backups-bucket", "", "S3 bucket the database backups are uploaded to")
+	flag.String("backups-prefix", "backups/", "Key prefix of the database backups in the bucket")
+	flag.String("backups-pg-dump", "pg_dump", "Path to the pg_dump binary")
+	flag.Duration("backups-timeout", 30*time.Minute, "Time budget of a database backup")
+	flag.StringSlice(
+		"backups-alert-emails",
+		[]string{},
+		"Emails alerted of the failed database backups (comma separated)",
+	)
+	flag.Bool("faults-enabled", false, "Inject latency and errors, development only")
+	flag.Duration("faults-db-latency", 0, "Max latency injected into the database calls")
+	flag.Float64("faults-db-error-rate", 0, "Rate of database calls failed on purpose (0-1)")
+	flag.Duration("faults-mailer-latency", 0, "Max latency injected into the mail deliveries")
+	flag.Float64("faults-mailer-error-rate", 0, "Rate of mail deliveries failed on purpose (0-1)")
 	flag.Int("daily-targets-creation-limit", 10, "Daily targets creation limit per user")
 	flag.Int("daily-actions-creation-limit", 20, "Daily actions creation limit per user")
 	flag.Int("daily-sessions-creation-limit", 50, "Daily sessions creation limit per user")
-	flag.StringSlice("cors-trusted-origins", []string{}, "Trusted CORS origins (comma separated)")
+	flag.Bool(
+		"refund-quota-on-delete",
+		true,
+		"Give back the daily quota of resources deleted on the day they were created",
+	)
+	flag.StringSlice(
+		"cors-trusted-origins",
+		[]string{},
+		"Trusted CORS origins (comma separated), \"*\" trusts any origin in development",
+	)
+	flag.StringSlice(
+		"cors-allowed-methods",
+		[]string{"OPTIONS", "PUT", "PATCH", "DELETE"},
+		"Methods allowed in CORS preflight requests (comma separated)",
+	)
+	flag.StringSlice(
+		"cors-allowed-headers",
+		[]string{"Authorization", "Content-Type"},
+		"Headers allowed in CORS preflight requests (comma separated)",
+	)
+	flag.Duration("cors-max-age", 0, "How long browsers may cache CORS preflight responses")
+	flag.Bool(
+		"cors-allow-credentials",
+		false,
+		"Allow trusted origins to send credentials, always on in the cookie auth mode",
+	)
+	flag.Bool(
+		"cookie-auth-enabled",
+		false,
+		"Allow browser clients to receive the tokens as HttpOnly cookies",
+	)
+	flag.String("cookie-domain", "", "Domain attribute of the authentication cookies")
+	flag.Bool("cookie-secure", true, "Only send the authentication cookies over HTTPS")
+	flag.String(
+		"cookie-same-site",
+		"strict",
+		"SameSite attribute of the authentication cookies (strict|lax|none)",
+	)
+	flag.StringSlice(
+		"exempt-users",
+		[]string{},
+		"User UUIDs exempted from the quotas and the rate limiter (comma separated)",
+	)
+	flag.StringSlice(
+		"exempt-api-keys",
+		[]string{},
+		"API keys exempted from the quotas and the rate limiter (comma separated)",
+	)
+	flag.StringSlice(
+		"exempt-synthetic",
+		[]string{},
+		"Synthetic traffic API keys let through the rate limiter, as name=key (comma separated)",
+	)
 
 	external_config_src := flag.String(
 		"external-config-source",
@@ -96,6 +416,11 @@ func main() {
 	config_file := flag.String("config-file", "", "Path to configuration file")
 
 	displayVersion := flag.Bool("version", false, "Display version and exit")
+	fixtureUsers := flag.Int(
+		"generate-fixtures",
+		0,
+		"Generate this many synthetic users with their targets, actions and sessions, and exit",
+	)
 	flag.Parse()
 
 	if *displayVersion {
@@ -137,6 +462,13 @@ func main() {
 		logger.Error("Error loading configuration", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
+	if err := cfg.checkIntervals(); err != nil {
+		logger.Error("Error loading configuration", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	if cfg.logs.redact {
+		logger = slog.New(redact.NewHandler(logger.Handler(), cfg.logs.redactKeys...))
+	}
 
 	db, err := openDB(cfg)
 	if err != nil {
@@ -146,15 +478,82 @@ func main() {
 	defer db.Close()
 	logger.Info("database connection pool established")
 
-	// Initialize the Jieba text segmentation library for Chinese text processing
-	dictFiles, cleanup, err := tokenizer.WriteJiebaDictFiles()
+	if err := checkMigrations(db); err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	checkIndexes(db, logger)
+
+	ftsConfig, ftsReindexRequired, err := setupFTS(db, cfg, logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// The roles only change with the migrations, their ranks are read once to check the
+	// access to the records without querying them again.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	roles, err := data.LoadRoles(ctx, db)
+	cancel()
+	if err != nil {
+		logger.Error("Error loading the roles: " + err.Error())
+		os.Exit(1)
+	}
+
+	// Initialize the text segmentation engine for Chinese text processing, Jieba
+	// unless the binary is built with the nojieba tag. Loading the dictionaries is
+	// deferred to the first use unless configured otherwise.
+	segmenter := tokenizer.Simple()
+	if cfg.search.segmenter.enabled {
+		pool := tokenizer.NewPool(
+			tokenizer.NewSegmenter,
+			cfg.search.segmenter.poolSize,
+			logger,
+		)
+		defer pool.Close()
+		if !cfg.search.segmenter.lazy {
+			if err := pool.Warm(); err != nil {
+				logger.Error(
+					"Error initializing text segmenter",
+					slog.String("engine", tokenizer.Engine),
+					slog.String("error", err.Error()),
+				)
+				os.Exit(1)
+			}
+		}
+		expvar.Publish("tokenizer", expvar.Func(pool.Stats))
+		segmenter = pool
+	}
+
+	notesCipher, err := newNotesCipher(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	dbFaults, mailerFaults, err := newFaults(cfg)
 	if err != nil {
-		logger.Error("Error creating Jieba dictionary files", slog.String("error", err.Error()))
+		logger.Error(err.Error())
 		os.Exit(1)
 	}
-	jieba := gojieba.NewJieba(dictFiles...)
-	defer jieba.Free()
-	defer cleanup()
+	if cfg.faults.enabled {
+		logger.Warn(
+			"injecting faults",
+			"db_latency", cfg.faults.dbLatency,
+			"db_error_rate", cfg.faults.dbErrorRate,
+			"mailer_latency", cfg.faults.mailerLatency,
+			"mailer_error_rate", cfg.faults.mailerErrorRate,
+		)
+	}
+
+	dkim, err := newMailDKIM(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	if cfg.smtp.checkDNS {
+		go checkMailDNS(logger, cfg.smtp.sender, dkim)
+	}
 
 	// Initialize a new Mailer instance for sending emails
 	mailer, err := mailer.New(
@@ -168,6 +567,13 @@ func main() {
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
+	mailer.LimitAttachments(cfg.smtp.maxAttachmentsSize)
+	if dkim != nil {
+		mailer.UseDKIM(dkim)
+	}
+	if mailerFaults != nil {
+		mailer.UseFaults(mailerFaults)
+	}
 
 	expvar.NewString("version").Set(version)
 	// Publish the number of active goroutines
@@ -182,15 +588,211 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	// Trip a circuit breaker when the database keeps failing, so requests fail fast
+	// instead of holding a connection slot until they time out.
+	var dbBreaker *breaker.Breaker
+	if cfg.db.breaker.enabled {
+		dbBreaker = breaker.New(cfg.db.breaker.threshold, cfg.db.breaker.cooldown)
+		expvar.Publish("database_breaker", expvar.Func(dbBreaker.Stats))
+	}
+
+	exemptions, err := newExemptions(
+		logger,
+		cfg.exemptions.users,
+		cfg.exemptions.apiKeys,
+		cfg.exemptions.synthetic,
+	)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	expvar.Publish("exemptions", expvar.Func(exemptions.stats))
+
+	ipFilter, err := newIPFilter(
+		logger,
+		cfg.ipFilter.allow,
+		cfg.ipFilter.deny,
+		cfg.ipFilter.adminAllow,
+		cfg.ipFilter.trustProxyHeaders,
+	)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	expvar.Publish("ip_filter", expvar.Func(ipFilter.stats))
+
+	var limiter *clientLimiter
+	if cfg.limiter.enabled {
+		limiter = newClientLimiter(cfg.limiter.rps, cfg.limiter.burst, cfg.limiter.maxClients)
+		// The counters are public, the offending IP addresses are only listed to the
+		// operators.
+		expvar.Publish("rate_limiter", expvar.Func(limiter.stats))
+		go limiter.startCleanupRoutine()
+	}
+
+	operators, err := newOperators(cfg.operators)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	cookies, err := newCookieAuth(
+		cfg.cookies.enabled,
+		cfg.cookies.domain,
+		cfg.cookies.secure,
+		cfg.cookies.sameSite,
+	)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	cors, err := newCORSPolicy(
+		cfg.env,
+		cfg.cors.trustedOrigins,
+		cfg.cors.allowedMethods,
+		cfg.cors.allowedHeaders,
+		cfg.cors.maxAge,
+		cfg.cors.allowCredentials || cookies != nil,
+	)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	// Browser clients using the cookie mode need their own headers to be allowed.
+	if cookies != nil {
+		cors.allowHeaders(authModeHeader, csrfHeader)
+	}
+
+	// Log the security events apart from the application logs, shipping them to the
+	// configured webhook if any.
+	security := newSecurityLog(logger, cfg.security.webhook.url, cfg.security.webhook.timeout)
+	expvar.Publish("security_log", expvar.Func(security.stats))
+	go security.ship()
+
+	// Let browser clients identify themselves too.
+	cors.allowHeaders(clientIDHeader)
+
+	clients := newClientApps()
+
+	var dbBackups *backups
+	if cfg.backups.enabled {
+		if cfg.backups.bucket == "" {
+			logger.Error("backups enabled without a bucket")
+			os.Exit(1)
+		}
+		dbBackups = &backups{}
+		expvar.Publish("backups", expvar.Func(dbBackups.stats))
+	}
+
+	var abuse *abuseScorer
+	if cfg.abuse.enabled {
+		if cfg.abuse.burstWindow <= 0 || cfg.abuse.burstLimit <= 0 || cfg.abuse.halfLife <= 0 {
+			logger.Error("abuse scoring needs a positive burst window, burst limit and half-life")
+			os.Exit(1)
+		}
+		abuse = newAbuseScorer(cfg)
+		expvar.Publish("abuse", expvar.Func(abuse.stats))
+		// Forget the users whose score decayed away
+		go func() {
+			for {
+				time.Sleep(1 * time.Minute)
+				abuse.cleanup()
+			}
+		}()
+	}
+
+	deprecations := newDeprecationLog(logger)
+	expvar.Publish("deprecated_routes", expvar.Func(deprecations.stats))
+
 	app := application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db, jieba, logger),
-		mailer: mailer,
+		config:       cfg,
+		logger:       logger,
+		models:       data.NewModels(db, segmenter, logger, dbBreaker, dbFaults),
+		mailer:       mailer,
+		breaker:      dbBreaker,
+		security:     security,
+		limiter:      limiter,
+		exemptions:   exemptions,
+		ipFilter:     ipFilter,
+		abuse:        abuse,
+		operators:    operators,
+		cookies:      cookies,
+		cors:         cors,
+		deprecations: deprecations,
+		clients:      clients,
+		backups:      dbBackups,
+
+		ftsReindexRequired: ftsReindexRequired,
+	}
+	app.models.UseNotesCipher(notesCipher)
+	app.models.UseFTSConfig(ftsConfig)
+	app.models.UseRoles(roles)
+
+	// Fill the database with synthetic users to benchmark the queries, instead of serving
+	if *fixtureUsers > 0 {
+		if err := app.generateFixtures(*fixtureUsers); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Catch misconfigured SMTP servers and credentials before the first email is sent
+	if cfg.smtp.verify.enabled {
+		app.mailerHealth = newMailerHealth()
+		if err := app.verifyMailer(); err != nil {
+			if cfg.smtp.verify.fatal {
+				logger.Error("SMTP server verification failed: " + err.Error())
+				os.Exit(1)
+			}
+			logger.Warn("SMTP server verification failed, running degraded", "error", err.Error())
+		}
+		go app.startMailerHealthRoutine()
 	}
 
 	// Running cleanup routine in background
 	go app.startCleanupRoutine()
+	// Archiving inactive records according to the users archive rules
+	if cfg.archive.enabled {
+		go app.startArchiveRoutine()
+	}
+	// Reminding and deleting the accounts not activated
+	if cfg.unactivated.enabled {
+		go app.startUnactivatedRoutine()
+	}
+	// Purging the records past their retention period
+	if cfg.retention.enabled {
+		if cfg.retention.batchSize <= 0 {
+			logger.Error("retention batch size must be positive")
+			os.Exit(1)
+		}
+		app.retention = newRetentionLog()
+		expvar.Publish("retention", expvar.Func(app.retention.stats))
+		go app.startRetentionRoutine()
+	}
+	// Emailing the reminders once due
+	if cfg.reminders.enabled {
+		go app.startReminderRoutine()
+	}
+	// Sending the new results of the saved searches
+	if cfg.savedSearches.enabled {
+		go app.startSavedSearchRoutine()
+	}
+	// Sending the scheduled report exports
+	if cfg.reportSchedules.enabled {
+		go app.startReportScheduleRoutine()
+	}
+	// Creating the monthly partitions of the sessions ahead of time
+	if cfg.partitions.enabled {
+		go app.startPartitionRoutine()
+	}
+	// Keep the registered client apps in memory to attribute the requests
+	go app.startClientAppsRoutine()
+	// Monitor the database connection pool for saturation
+	if cfg.db.poolMonitorInterval > 0 {
+		go app.startDBPoolMonitor(db)
+	}
 
 	err = app.serve()
 	if err != nil {
@@ -224,3 +826,118 @@ func openDB(cfg config) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// checkIndexes warns about the expected indexes missing from the database. The server
+// still starts, the queries are only slower without them.
+func checkIndexes(db *sql.DB, logger *slog.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	missing, err := data.MissingIndexes(ctx, db)
+	if err != nil {
+		logger.Warn("checking database indexes failed", "error", err.Error())
+		return
+	}
+	if len(missing) > 0 {
+		logger.Warn(
+			"database indexes missing, run the migrations",
+			"indexes",
+			strings.Join(missing, ","),
+		)
+	}
+}
+
+// setupFTS returns the full text search configuration, validated. The configured
+// weights apply once the records are reindexed with them, which is done here when
+// configured. Until then, the records keep being indexed with the weights they were
+// indexed with, and the returned flag reports that a reindex is required.
+func setupFTS(db *sql.DB, cfg config, logger *slog.Logger) (*data.FTSConfig, bool, error) {
+	fts := &data.FTSConfig{
+		TitleWeight:       cfg.search.weights.title,
+		DescriptionWeight: cfg.search.weights.description,
+		RankWeights: [4]float64{
+			cfg.search.ranking.weightD,
+			cfg.search.ranking.weightC,
+			cfg.search.ranking.weightB,
+			cfg.search.ranking.weightA,
+		},
+		Normalization: cfg.search.ranking.normalization,
+		Boost: data.FTSBoost{
+			Recency:  cfg.search.boost.recency,
+			HalfLife: cfg.search.boost.halfLife,
+			Status: map[data.Status]float64{
+				data.StatusInProgress: cfg.search.boost.inProgress,
+				data.StatusQueued:     cfg.search.boost.queued,
+				data.StatusComplete:   cfg.search.boost.completed,
+				data.StatusCanceled:   cfg.search.boost.canceled,
+				data.StatusArchived:   cfg.search.boost.archived,
+			},
+		},
+	}
+
+	v := validator.New()
+	if data.ValidateFTSConfig(v, fts); !v.Valid() {
+		return nil, false, fmt.Errorf("invalid search configuration: %v", v.Errors)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	title, description, err := data.IndexedFTSWeights(ctx, db)
+	if err != nil {
+		return nil, false, fmt.Errorf("checking search weights: %w", err)
+	}
+	if title == fts.TitleWeight && description == fts.DescriptionWeight {
+		return fts, false, nil
+	}
+
+	if !cfg.search.weights.reindex {
+		logger.Warn(
+			"search weights changed, reindex the records for them to apply",
+			"indexed", title+"/"+description,
+			"configured", fts.TitleWeight+"/"+fts.DescriptionWeight,
+		)
+		fts.TitleWeight, fts.DescriptionWeight = title, description
+		return fts, true, nil
+	}
+
+	// Reindexing takes as long as the number of records requires.
+	start := time.Now()
+	err = data.ReweightFTS(context.Background(), db, title, description, fts)
+	if err != nil {
+		return nil, false, fmt.Errorf("reindexing search vectors: %w", err)
+	}
+	logger.Info(
+		"search vectors reindexed",
+		"weights", fts.TitleWeight+"/"+fts.DescriptionWeight,
+		"duration", time.Since(start).String(),
+	)
+
+	return fts, false, nil
+}
+
+// checkMigrations verifies that the database migrations have been applied and that
+// the last one completed successfully, so the server never reports itself ready on
+// top of a half migrated schema.
+func checkMigrations(db *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var version int64
+	var dirty bool
+	err := db.QueryRowContext(
+		ctx,
+		"SELECT version, dirty FROM schema_migrations LIMIT 1",
+	).Scan(&version, &dirty)
+	if err != nil {
+		return fmt.Errorf("checking database migrations: %w", err)
+	}
+	if dirty {
+		return fmt.Errorf(
+			"database migration %d is dirty, fix it before starting the server",
+			version,
+		)
+	}
+
+	return nil
+}
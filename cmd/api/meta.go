@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/liuminhaw/yatijapp/internal/data"
+)
+
+// quotaLimit is the usage of a daily creation quota of the user, reset at midnight UTC.
+type quotaLimit struct {
+	Resource  string    `json:"resource"`
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	ResetsAt  time.Time `json:"resets_at"`
+}
+
+// countLimit is the number of records of a kind the user keeps, out of the most they can.
+type countLimit struct {
+	Limit int `json:"limit"`
+	Used  int `json:"used"`
+}
+
+// showLimitsHandler describes the limits applying to the user: the rate limit of their
+// IP address, their daily creation quotas, the records they can keep and the maximum
+// sizes of the payloads. Exempted users are neither rate limited nor held to the quotas,
+// the usage of which is still counted.
+func (app *application) showLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	now := time.Now().UTC()
+	usage, err := app.models.DailyQuota.GetAllForDay(r.Context(), user.UUID, now)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	savedSearches, err := app.models.SavedSearches.GetAllForUser(r.Context(), user.UUID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	schedules, err := app.models.ReportSchedules.GetAllForUser(r.Context(), user.UUID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	resetsAt := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).
+		AddDate(0, 0, 1)
+	quotas := []quotaLimit{
+		{Resource: "target", Limit: app.config.user.dailyTargetsCreationLimit},
+		{Resource: "action", Limit: app.config.user.dailyActionsCreationLimit},
+		{Resource: "session", Limit: app.config.user.dailySessionsCreationLimit},
+	}
+	for i := range quotas {
+		quotas[i].Used = usage[quotas[i].Resource]
+		quotas[i].Remaining = max(quotas[i].Limit-quotas[i].Used, 0)
+		quotas[i].ResetsAt = resetsAt
+	}
+
+	limits := envelope{
+		"exempt": app.quotaExempt(r, user),
+		"rate": envelope{
+			"enabled":             app.config.limiter.enabled,
+			"requests_per_second": app.config.limiter.rps,
+			"burst":               app.config.limiter.burst,
+		},
+		"quotas": quotas,
+		"saved_searches": countLimit{
+			Limit: app.config.savedSearches.maxPerUser,
+			Used:  len(savedSearches),
+		},
+		"report_schedules": countLimit{
+			Limit: app.config.reportSchedules.maxPerUser,
+			Used:  len(schedules),
+		},
+		"payloads": envelope{
+			"max_body_bytes":      maxBodyBytes,
+			"max_page_size":       data.MaxPageSize,
+			"max_offset":          data.MaxOffset,
+			"max_bulk_delete":     data.MaxBulkDelete,
+			"max_checklist_items": data.MaxChecklistItems,
+			"max_action_tags":     data.MaxActionTags,
+			"max_acl_propagation": data.MaxACLPropagation,
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"limits": limits}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
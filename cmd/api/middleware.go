@@ -1,20 +1,19 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"expvar"
 	"fmt"
 	"net/http"
-	"slices"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/gofrs/uuid/v5"
 	"github.com/liuminhaw/yatijapp/internal/data"
 	"github.com/liuminhaw/yatijapp/internal/validator"
 	"github.com/tomasen/realip"
-	"golang.org/x/time/rate"
 )
 
 func (app *application) recoverPanic(next http.Handler) http.Handler {
@@ -33,80 +32,139 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 
 func (app *application) rateLimit(next http.Handler) http.Handler {
 	// Code here will run only once, when wrapping something with the middleware.
-	if !app.config.limiter.enabled {
+	if app.limiter == nil {
 		return next
 	}
 
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Synthetic traffic is let through before being counted against its IP address.
+		if name, ok := app.exemptions.syntheticKey(r); ok {
+			app.exemptions.recordSynthetic(name, r)
+			next.ServeHTTP(w, r)
+			return
+		}
 
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
+		ip := realip.FromRequest(r)
 
-	// Background goroutine to clean up old clients
-	go func() {
-		for {
-			time.Sleep(1 * time.Minute)
-			mu.Lock()
+		if !app.limiter.allow(ip) && !app.limiterExempt(r) {
+			app.rateLimitExceededResponse(w, r)
+			return
+		}
 
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
-			}
+		next.ServeHTTP(w, r)
+	})
+}
 
-			mu.Unlock()
-		}
-	}()
+// requestTimeout applies an overall deadline to every request based on the route
+// budget. The context passed downstream is canceled once the budget is exceeded so
+// that running model queries are aborted instead of holding a connection.
+func (app *application) requestTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), app.routeBudget(r))
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
+// memoizeACLs memoizes the access checks made while serving the request, so that the
+// role of the user on a record is only looked up once per request.
+func (app *application) memoizeACLs(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := realip.FromRequest(r)
+		next.ServeHTTP(w, r.WithContext(data.WithACLMemo(r.Context())))
+	})
+}
 
-		mu.Lock()
-		if _, found := clients[ip]; !found {
-			clients[ip] = &client{
-				limiter: rate.NewLimiter(
-					rate.Limit(app.config.limiter.rps),
-					app.config.limiter.burst,
-				),
-			}
+// routeBudget returns the time budget of the route matching the request. Auth
+// endpoints get a shorter budget while exports are allowed to run longer.
+func (app *application) routeBudget(r *http.Request) time.Duration {
+	_, path := splitVersion(r.URL.Path)
+
+	switch {
+	case strings.HasPrefix(path, "/tokens/"):
+		return app.config.timeouts.auth
+	case strings.HasSuffix(path, "/export") || strings.Contains(path, "/exports"):
+		return app.config.timeouts.export
+	default:
+		return app.config.timeouts.request
+	}
+}
+
+// databaseBreaker rejects requests with a fast 503 while the database circuit breaker
+// is open. The healthcheck and debug endpoints are always served so that operators
+// can observe the breaker state.
+func (app *application) databaseBreaker(next http.Handler) http.Handler {
+	if app.breaker == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, path := splitVersion(r.URL.Path)
+		if path == "/healthcheck" || strings.HasPrefix(r.URL.Path, "/debug/") {
+			next.ServeHTTP(w, r)
+			return
 		}
-		clients[ip].lastSeen = time.Now()
 
-		if !clients[ip].limiter.Allow() {
-			mu.Unlock()
-			app.rateLimitExceededResponse(w, r)
+		if !app.breaker.Allow() {
+			app.databaseUnavailableResponse(w, r, app.breaker.RetryAfter())
 			return
 		}
-		mu.Unlock()
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// requireUUIDParam validates the "uuid" route parameter before calling the next
+// handler, which reads the parsed value with contextGetUUIDParam. A malformed UUID
+// cannot identify any resource, so it is reported as not found.
+func (app *application) requireUUIDParam(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readUUIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, app.contextSetUUIDParam(r, id))
+	}
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// This indicates to any caches that the response may vary based on the
 		// value of the Authorization header in the request.
 		w.Header().Add("Vary", "Authorization")
 
+		var token string
 		authorizationHeader := r.Header.Get("Authorization")
-		if authorizationHeader == "" {
-			r = app.contextSetUser(r, data.AnonymousUser)
-			next.ServeHTTP(w, r)
-			return
+		switch {
+		case authorizationHeader != "":
+			headerParts := strings.Split(authorizationHeader, " ")
+			if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+			token = headerParts[1]
+		case app.cookies != nil:
+			// Browser clients using the cookie mode send the access token as a cookie.
+			w.Header().Add("Vary", "Cookie")
+			cookie, err := r.Cookie(accessTokenCookie)
+			if err != nil || cookie.Value == "" {
+				break
+			}
+			if !validCSRF(r) {
+				app.securityEvent(r, eventAccessDenied, outcomeFailure, uuid.Nil, "reason", "csrf")
+				app.invalidCSRFTokenResponse(w, r)
+				return
+			}
+			token = cookie.Value
 		}
 
-		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			app.invalidAuthenticationTokenResponse(w, r)
+		if token == "" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
 			return
 		}
-		token := headerParts[1]
 
 		v := validator.New()
 		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
@@ -114,10 +172,11 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
+				app.securityEvent(r, eventInvalidToken, outcomeFailure, uuid.Nil)
 				app.invalidAuthenticationTokenResponse(w, r)
 			default:
 				app.serverErrorResponse(w, r, err)
@@ -136,6 +195,7 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 		user := app.contextGetUser(r)
 
 		if user.IsAnonymous() {
+			app.securityEvent(r, eventAccessDenied, outcomeFailure, uuid.Nil, "reason", "anonymous")
 			app.authenticationRequiredResponse(w, r)
 			return
 		}
@@ -150,6 +210,7 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 		user := app.contextGetUser(r)
 
 		if !user.Activated {
+			app.securityEvent(r, eventAccessDenied, outcomeFailure, user.UUID, "reason", "inactive")
 			app.inactiveAccountResponse(w, r)
 			return
 		}
@@ -167,14 +228,23 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 
 		origin := r.Header.Get("Origin")
 		if origin != "" {
-			if slices.Contains(app.config.cors.trustedOrigins, origin) {
+			if app.cors.trusted(origin) {
+				// The origin is echoed even when any origin is trusted, as browsers
+				// refuse a wildcard along with credentials.
 				w.Header().Set("Access-Control-Allow-Origin", origin)
+				if app.cors.credentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
 
 				// Preflight request, we need to handle it and return
 				if r.Method == http.MethodOptions &&
 					r.Header.Get("Access-Control-Request-Method") != "" {
-					w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-					w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+					w.Header().Set("Access-Control-Allow-Methods", app.cors.methods)
+					headers := strings.Join(app.cors.headers, ", ")
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+					if app.cors.maxAge != "" {
+						w.Header().Set("Access-Control-Max-Age", app.cors.maxAge)
+					}
 
 					w.WriteHeader(http.StatusOK)
 					return
@@ -237,6 +307,11 @@ func (app *application) metrics(next http.Handler) http.Handler {
 
 		totalResponsesSent.Add(1)
 		totalResponsesSentByStatus.Add(strconv.Itoa(mrw.statusCode), 1)
+		app.clients.record(
+			r.Header.Get(clientIDHeader),
+			mrw.Header().Get(apiVersionHeader),
+			mrw.statusCode,
+		)
 
 		duration := time.Since(start).Microseconds()
 		totalProcessingTimeMicroseconds.Add(duration)
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// appendNotesHandler returns the handler adding a note to the notes of the record of the
+// given resource type. Commenters can add notes without being able to edit the record
+// otherwise, the note is signed with the name of its author. The users mentioned in the
+// note by their email address, as in @jane@example.com, must have access to the record,
+// and are told by email that they were mentioned.
+func (app *application) appendNotesHandler(resource string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Note string `json:"note" validate:"required,max=2000"`
+		}
+
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		input.Note = strings.TrimSpace(input.Note)
+
+		mentions := data.ParseMentions(input.Note)
+
+		v := validator.New()
+		v.Struct(&input)
+		v.CheckField(
+			len(mentions) <= data.MaxMentions,
+			"note",
+			validator.TooLarge(
+				data.MaxMentions,
+				fmt.Sprintf("must not mention more than %d users", data.MaxMentions),
+			),
+		)
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v)
+			return
+		}
+
+		id := app.contextGetUUIDParam(r)
+		user := app.contextGetUser(r)
+
+		mentioned, err := app.resolveMentions(r.Context(), resource, id, user, mentions)
+		switch {
+		case errors.Is(err, errUnknownMention):
+			v.AddFieldError(
+				"note",
+				validator.NotPermitted("can only mention the users with access to the record"),
+			)
+			app.failedValidationResponse(w, r, v)
+			return
+		case err != nil:
+			app.dataErrorResponse(w, r, err)
+			return
+		}
+
+		entry, err := app.models.AppendNotes(
+			r.Context(), resource, id, user.Name, input.Note, user.UUID,
+		)
+		if err != nil {
+			app.dataErrorResponse(w, r, err)
+			return
+		}
+
+		app.notifyMentioned(user, entry, mentioned)
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"notes": entry}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
+
+// errUnknownMention is returned when a note mentions an email address which is not that
+// of a user with access to the record. Unknown users and users without access are not
+// told apart, for mentions not to reveal who has an account.
+var errUnknownMention = errors.New("mentioned user has no access to the record")
+
+// resolveMentions returns the users with access to the record matching the mentioned
+// email addresses, leaving out the author of the note.
+func (app *application) resolveMentions(
+	ctx context.Context,
+	resource string,
+	id uuid.UUID,
+	author *data.User,
+	mentions []string,
+) ([]*data.ResourceACL, error) {
+	if len(mentions) == 0 {
+		return nil, nil
+	}
+
+	acls, err := app.models.ACLs.GetAllForResource(ctx, resource, id, author.UUID)
+	if err != nil {
+		return nil, err
+	}
+
+	mentioned := make([]*data.ResourceACL, 0, len(mentions))
+	for _, email := range mentions {
+		i := slices.IndexFunc(acls, func(acl *data.ResourceACL) bool {
+			return strings.EqualFold(acl.Email, email)
+		})
+		if i < 0 {
+			return nil, errUnknownMention
+		}
+		if acls[i].UserUUID != author.UUID {
+			mentioned = append(mentioned, acls[i])
+		}
+	}
+
+	return mentioned, nil
+}
+
+// notifyMentioned emails the users mentioned in a note that they were mentioned, by whom
+// and on which record, unless they muted these emails.
+func (app *application) notifyMentioned(
+	author *data.User,
+	entry *data.NotesEntry,
+	mentioned []*data.ResourceACL,
+) {
+	if len(mentioned) == 0 {
+		return
+	}
+
+	app.background(func() {
+		for _, acl := range mentioned {
+			ctx := context.Background()
+			preferences, err := app.models.UserPreferences.Get(ctx, acl.UserUUID)
+			switch {
+			case err == nil && preferences.Notifications.MuteMentions:
+				continue
+			case err != nil && !errors.Is(err, data.ErrRecordNotFound):
+				app.logger.Error(err.Error())
+				continue
+			}
+
+			tmplData := map[string]any{
+				"username": acl.Name,
+				"author":   author.Name,
+				"type":     entry.ResourceType,
+				"title":    entry.Title,
+			}
+			if err := app.mailer.Send(acl.Email, "mention.tmpl", tmplData); err != nil {
+				app.logger.Error(err.Error())
+			}
+		}
+	})
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+	"github.com/tomasen/realip"
+)
+
+// Google OAuth endpoints, exchanging an authorization code for an access token, then the
+// access token for the OpenID Connect claims of the account.
+const (
+	googleTokenURL    = "https://oauth2.googleapis.com/token"
+	googleUserInfoURL = "https://openidconnect.googleapis.com/v1/userinfo"
+)
+
+// errOAuthRejected is returned when the provider rejects the authorization code.
+var errOAuthRejected = errors.New("authorization code rejected by the provider")
+
+// oauthClient calls the identity providers.
+var oauthClient = &http.Client{Timeout: 10 * time.Second}
+
+// googleAccount holds the OpenID Connect claims of a Google account.
+type googleAccount struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// fetchGoogleAccount exchanges the authorization code, along with its PKCE verifier when the
+// client used one, for the claims of the Google account it was issued for.
+func (app *application) fetchGoogleAccount(
+	ctx context.Context,
+	code, codeVerifier string,
+) (*googleAccount, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {app.config.oauth.google.clientID},
+		"client_secret": {app.config.oauth.google.clientSecret},
+		"redirect_uri":  {app.config.oauth.google.redirectURL},
+	}
+	if codeVerifier != "" {
+		form.Set("code_verifier", codeVerifier)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		googleTokenURL,
+		strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := oauthClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusBadRequest || res.StatusCode == http.StatusUnauthorized:
+		return nil, errOAuthRejected
+	case res.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("google token endpoint responded with status %d", res.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, googleUserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	res, err = oauthClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("google userinfo endpoint responded with status %d", res.StatusCode)
+	}
+
+	var account googleAccount
+	if err := json.NewDecoder(res.Body).Decode(&account); err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+// oauthCode is the authorization code given to the client by an identity provider, along
+// with its PKCE verifier when the client used one.
+type oauthCode struct {
+	Code         string `json:"code" validate:"required,max=2048"`
+	CodeVerifier string `json:"code_verifier" validate:"max=128"`
+}
+
+// createGoogleAuthenticationTokenHandler signs in with the Google account the
+// authorization code was issued for, and issues the authentication tokens. The account
+// is linked to the user with the same verified email on the first sign-in, a user being
+// registered, activated, when there is none. Users who have not activated their account
+// must do so before their account is linked.
+func (app *application) createGoogleAuthenticationTokenHandler(
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	google := app.config.oauth.google
+	if google.clientID == "" || google.redirectURL == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input oauthCode
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Struct(&input); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	account, err := app.fetchGoogleAccount(r.Context(), input.Code, input.CodeVerifier)
+	if err != nil {
+		switch {
+		case errors.Is(err, errOAuthRejected):
+			app.securityEvent(
+				r,
+				eventLogin,
+				outcomeFailure,
+				uuid.Nil,
+				"provider", data.ProviderGoogle,
+				"reason", "rejected code",
+			)
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if account.Subject == "" || !account.EmailVerified {
+		app.securityEvent(
+			r,
+			eventLogin,
+			outcomeFailure,
+			uuid.Nil,
+			"provider", data.ProviderGoogle,
+			"reason", "unverified email",
+		)
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	identity := data.Identity{
+		Provider: data.ProviderGoogle,
+		Subject:  account.Subject,
+		Email:    account.Email,
+	}
+	user, err := app.models.Identities.GetUser(r.Context(), &identity)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		user, err = app.linkIdentity(w, r, &identity, account)
+		switch {
+		case errors.Is(err, data.ErrActivationRequired):
+			app.securityEvent(
+				r,
+				eventLogin,
+				outcomeFailure,
+				uuid.Nil,
+				"provider", data.ProviderGoogle,
+				"reason", "unactivated account",
+			)
+			app.inactiveAccountResponse(w, r)
+			return
+		case err != nil:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sessionUUID, err := uuid.NewV7()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	token, err := app.generateAuthenticationToken(
+		r.Context(),
+		user.UUID,
+		sessionUUID,
+		app.config.tokens.accessTokenTTL,
+		app.config.tokens.refreshTokenTTL,
+	)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.securityEvent(
+		r,
+		eventLogin,
+		outcomeSuccess,
+		user.UUID,
+		"session_uuid", sessionUUID,
+		"provider", data.ProviderGoogle,
+	)
+
+	if app.config.security.newSignIn.enabled {
+		device := data.Device{
+			UserUUID:  user.UUID,
+			IP:        realip.FromRequest(r),
+			UserAgent: r.UserAgent(),
+		}
+		if header := app.config.security.newSignIn.countryHeader; header != "" {
+			device.Country = r.Header.Get(header)
+		}
+		app.background(func() {
+			app.notifyNewSignIn(device, user, sessionUUID)
+		})
+	}
+
+	err = app.writeAuthenticationToken(w, r, token)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// linkIdentity links the identity to the user with the email of the account, registering
+// the user when there is none. The users it registers have no password, they sign in with
+// the identity until they set one with a password reset, and are set up as on their
+// activation.
+func (app *application) linkIdentity(
+	w http.ResponseWriter,
+	r *http.Request,
+	identity *data.Identity,
+	account *googleAccount,
+) (*data.User, error) {
+	// Users are named after their account, or else their email, within the 30
+	// characters of the names.
+	name := account.Name
+	if name == "" {
+		name, _, _ = strings.Cut(account.Email, "@")
+	}
+	if runes := []rune(name); len(runes) > 30 {
+		name = string(runes[:30])
+	}
+	newUser := &data.User{Name: name, Email: account.Email}
+
+	var starter *data.StarterContent
+	if app.config.starter.enabled {
+		starter = starterContent(app.negotiateLanguage(w, r))
+	}
+
+	user, link, err := app.models.LinkIdentity(r.Context(), identity, newUser, starter)
+	if err != nil {
+		return nil, err
+	}
+
+	app.securityEvent(
+		r,
+		eventIdentityLink,
+		outcomeSuccess,
+		user.UUID,
+		"provider", identity.Provider,
+		"registered", link == data.IdentityRegistered,
+	)
+
+	if link == data.IdentityLinked {
+		return user, nil
+	}
+	kpiRegistrations.Add(1)
+	kpiActivations.Add(1)
+
+	app.acceptPendingInvitations(r, user)
+
+	return user, nil
+}
+
+// verifyGoogleIdentity reports whether the Google account the authorization code was
+// issued for is linked to the user, who verifies their identity with it instead of their
+// password. errOAuthRejected is returned when Google rejects the code.
+func (app *application) verifyGoogleIdentity(
+	ctx context.Context,
+	user *data.User,
+	code, codeVerifier string,
+) (bool, error) {
+	account, err := app.fetchGoogleAccount(ctx, code, codeVerifier)
+	if err != nil {
+		return false, err
+	}
+	if account.Subject == "" {
+		return false, nil
+	}
+
+	identity := data.Identity{
+		Provider: data.ProviderGoogle,
+		Subject:  account.Subject,
+		Email:    account.Email,
+	}
+	linked, err := app.models.Identities.GetUser(ctx, &identity)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	return linked.UUID == user.UUID, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/data"
+)
+
+// operators lists the users running the service, who are shown its internal state.
+type operators struct {
+	users map[uuid.UUID]struct{}
+}
+
+func newOperators(users []string) (*operators, error) {
+	o := &operators{users: make(map[uuid.UUID]struct{}, len(users))}
+
+	for _, user := range users {
+		id, err := uuid.FromString(strings.TrimSpace(user))
+		if err != nil {
+			return nil, fmt.Errorf("invalid operator %q: %w", user, err)
+		}
+		o.users[id] = struct{}{}
+	}
+
+	return o, nil
+}
+
+// user reports whether the user is an authenticated and activated operator.
+func (o *operators) user(user *data.User) bool {
+	if user == nil || user.IsAnonymous() || !user.Activated {
+		return false
+	}
+	_, ok := o.users[user.UUID]
+	return ok
+}
+
+// requireOperator is a middleware that serves the route to the operators only, the
+// other users are told it does not exist.
+func (app *application) requireOperator(next http.HandlerFunc) http.HandlerFunc {
+	return app.requireActivatedUser(func(w http.ResponseWriter, r *http.Request) {
+		if !app.operators.user(app.contextGetUser(r)) {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// startPartitionRoutine creates the monthly partitions of the sessions ahead of time, so
+// that new sessions never fall into the default partition. The partitions are checked
+// on start, then on every tick.
+func (app *application) startPartitionRoutine() {
+	app.logger.Info("Partition routine started")
+
+	app.runScheduled(jobPartitions, app.config.partitions.interval, func() {
+		app.createSessionPartitions(context.Background())
+	})
+
+	ticker := time.NewTicker(app.config.partitions.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		app.background(func() {
+			app.runScheduled(jobPartitions, app.config.partitions.interval, func() {
+				app.createSessionPartitions(context.Background())
+			})
+		})
+	}
+}
+
+func (app *application) createSessionPartitions(ctx context.Context) {
+	created, err := app.models.CreateSessionPartitions(
+		ctx,
+		time.Now(),
+		app.config.partitions.ahead,
+	)
+	if err != nil {
+		app.logger.Error("Error creating session partitions: " + err.Error())
+	}
+	if created > 0 {
+		app.logger.Info("Session partitions created", slog.Int("created", created))
+	}
+}
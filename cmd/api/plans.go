@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// planToday returns the current day in the timezone given in the timezone query string
+// parameter, an IANA name, or in UTC. Days roll over at midnight in that timezone.
+func (app *application) planToday(r *http.Request, v *validator.Validator) data.PlanDay {
+	timezone := app.readString(r.URL.Query(), "timezone", "UTC")
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		v.AddFieldError(
+			"timezone",
+			validator.NotPermitted("must be an IANA timezone name, such as 'Asia/Taipei'"),
+		)
+		return data.PlanDay{}
+	}
+
+	now := time.Now().In(loc)
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	return data.PlanDay{
+		Date: time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC),
+		From: from,
+		To:   from.AddDate(0, 0, 1),
+	}
+}
+
+// showPlanTodayHandler returns the actions the user planned for the day, with their
+// status and the time tracked on them today. The unfinished actions of the latest plan
+// are rolled forward when the plan of a new day is first shown.
+func (app *application) showPlanTodayHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	day := app.planToday(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	plan, err := app.models.PlanToday(r.Context(), day, user.UUID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"plan": plan}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updatePlanTodayHandler sets the actions the user plans to focus on for the day, in
+// order, replacing the current plan.
+func (app *application) updatePlanTodayHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Actions []uuid.UUID `json:"actions"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	day := app.planToday(r, v)
+	if data.ValidatePlan(v, input.Actions); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	plan, err := app.models.ReplacePlan(r.Context(), day, input.Actions, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"plan": plan}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
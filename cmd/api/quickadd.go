@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// quickAddTarget is a target a quick add reference resolved to.
+type quickAddTarget struct {
+	UUID  uuid.UUID `json:"uuid"`
+	Title string    `json:"title"`
+}
+
+// quickAddInterpretation is how a quick add text was understood, sent back for the user
+// to confirm. Target is null until the text, or the target_uuid of the request, names a
+// single target, the targets matching the reference are listed as candidates then.
+type quickAddInterpretation struct {
+	data.QuickAdd
+	DueDate     data.NullTime    `json:"due_date"`
+	DueTimezone string           `json:"due_timezone"`
+	Target      *quickAddTarget  `json:"target"`
+	Candidates  []quickAddTarget `json:"candidates,omitzero"`
+}
+
+// quickAddHandler captures an action from a single line of text, such as "Write report
+// +client-x #writing @tomorrow 2h est". The interpretation of the text is sent back
+// unless confirm is set, in which case the action is created, queued, in the target the
+// text names, or in the one of target_uuid.
+func (app *application) quickAddHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Text       string    `json:"text"`
+		Timezone   string    `json:"timezone"`
+		TargetUUID uuid.UUID `json:"target_uuid"`
+		Confirm    bool      `json:"confirm"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	qa := data.ParseQuickAdd(input.Text)
+
+	v := validator.New()
+	data.ValidateQuickAdd(v, input.Text, &qa)
+	v.CheckField(
+		data.ValidTimezone(input.Timezone),
+		"timezone",
+		validator.NotPermitted("must be an IANA timezone name, such as 'Asia/Taipei'"),
+	)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	interpretation := quickAddInterpretation{
+		QuickAdd:    qa,
+		DueDate:     qa.DueDate.In(input.Timezone).NullTime(),
+		DueTimezone: input.Timezone,
+	}
+
+	user := app.contextGetUser(r)
+	switch {
+	case input.TargetUUID != uuid.Nil:
+		target, err := app.models.Targets.Get(r.Context(), input.TargetUUID, user.UUID, "editor")
+		if err != nil {
+			app.dataErrorResponse(w, r, err)
+			return
+		}
+		interpretation.Target = &quickAddTarget{UUID: target.UUID, Title: target.Title}
+	case qa.Reference != "":
+		targets, err := app.models.Targets.FindByReference(r.Context(), qa.Reference, user.UUID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		for _, target := range targets {
+			candidate := quickAddTarget{UUID: target.UUID, Title: target.Title}
+			interpretation.Candidates = append(interpretation.Candidates, candidate)
+		}
+		if len(targets) == 1 {
+			interpretation.Target = &interpretation.Candidates[0]
+			interpretation.Candidates = nil
+		}
+	}
+
+	if !input.Confirm {
+		err = app.writeJSON(w, http.StatusOK, envelope{"quick_add": interpretation}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if interpretation.Target == nil {
+		switch {
+		case len(interpretation.Candidates) > 1:
+			v.AddFieldError(
+				"target_uuid",
+				validator.Invalid("+"+qa.Reference+" matches several targets, pick one"),
+			)
+		case qa.Reference != "":
+			v.AddFieldError(
+				"target_uuid",
+				validator.Invalid("+"+qa.Reference+" matches none of the targets you can edit"),
+			)
+		default:
+			v.AddFieldError("target_uuid", validator.Required())
+		}
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	action := data.Action{
+		TargetUUID:      interpretation.Target.UUID,
+		DueDate:         interpretation.DueDate,
+		DueTimezone:     input.Timezone,
+		Title:           qa.Title,
+		Status:          data.StatusQueued,
+		Tags:            qa.Tags,
+		EstimateMinutes: qa.EstimateMinutes,
+	}
+
+	if data.ValidateAction(v, &action, "create"); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	if !app.createAction(w, r, &action) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", app.apiPath(r, "/actions/%s", action.UUID))
+
+	env := envelope{
+		"action":    newActionResponse(&action, true),
+		"quick_add": interpretation,
+	}
+	err = app.writeJSON(w, http.StatusCreated, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
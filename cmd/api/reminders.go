@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/liuminhaw/yatijapp/internal/mailer"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// Reminders are snoozed for defaultSnooze unless the client asks for another duration, up
+// to maxSnooze.
+const (
+	defaultSnooze = 1 * time.Hour
+	maxSnooze     = 7 * 24 * time.Hour
+)
+
+// startReminderRoutine periodically emails the reminders which are due.
+func (app *application) startReminderRoutine() {
+	app.logger.Info("Reminder routine started")
+
+	ticker := time.NewTicker(app.config.reminders.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		app.background(func() {
+			app.runScheduled(jobReminders, app.config.reminders.interval, func() {
+				app.sendDueReminders(context.Background())
+			})
+		})
+	}
+}
+
+// sendDueReminders emails the reminders whose time, or snooze, has come. Reminders are
+// only recorded as sent once the email has been sent, so that a failed delivery is
+// retried on the next run.
+func (app *application) sendDueReminders(ctx context.Context) {
+	notices, err := app.models.Reminders.Due(ctx)
+	if err != nil {
+		app.logger.Error("Error listing due reminders: " + err.Error())
+		return
+	}
+	if len(notices) == 0 {
+		return
+	}
+
+	messages := make([]mailer.BatchMessage, len(notices))
+	for i, notice := range notices {
+		prefs, err := app.reportPreferences(ctx, notice.UserUUID)
+		if err != nil {
+			app.logger.Error("Error loading report preferences: " + err.Error())
+		}
+
+		remindAt := notice.RemindAt
+		messages[i] = mailer.BatchMessage{
+			Recipient:    notice.Email,
+			TemplateFile: "reminder.tmpl",
+			Data: map[string]any{
+				"username": notice.Name,
+				"type":     notice.ResourceType,
+				"title":    notice.Title,
+				"remindAt": prefs.FormatDate(remindAt) + " " + remindAt.Format("15:04 MST"),
+			},
+		}
+	}
+
+	sent := 0
+	errs := app.mailer.SendBatch(ctx, messages, app.config.smtp.batchConcurrency)
+	for i, notice := range notices {
+		if errs[i] != nil {
+			app.logger.Error(errs[i].Error())
+			continue
+		}
+
+		err := app.models.Reminders.MarkSent(ctx, &notice.Reminder)
+		if err != nil {
+			app.logger.Error("Error recording sent reminder: " + err.Error())
+			continue
+		}
+		sent++
+	}
+	app.logger.Info("Reminders sent", slog.Int("sent", sent), slog.Int("due", len(notices)))
+}
+
+// listRemindersHandler lists the reminders of the user along with their state, pending,
+// snoozed or sent.
+func (app *application) listRemindersHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	reminders, err := app.models.Reminders.GetAllForUser(r.Context(), user.UUID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"reminders": reminders}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createReminderHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ResourceType string         `json:"resource_type"`
+		ResourceUUID uuid.UUID      `json:"resource_uuid"`
+		RemindAt     data.InputDate `json:"remind_at"`
+		Timezone     string         `json:"timezone"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	reminder := data.Reminder{
+		ResourceType: input.ResourceType,
+		ResourceUUID: input.ResourceUUID,
+		RemindAt:     input.RemindAt.In(input.Timezone).GetTime(),
+		Timezone:     input.Timezone,
+	}
+
+	v := validator.New()
+	if data.ValidateReminder(v, &reminder); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	err = app.models.Reminders.Insert(r.Context(), &reminder, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	created, err := app.models.Reminders.Get(r.Context(), reminder.UUID, user.UUID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", app.apiPath(r, "/reminders/%s", reminder.UUID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"reminder": created}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// snoozeReminderHandler postpones a reminder by the duration given in the for query
+// string parameter, such as 30m or 2h. A reminder already sent fires again once snoozed.
+func (app *application) snoozeReminderHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+
+	v := validator.New()
+	snooze := defaultSnooze
+	if s := r.URL.Query().Get("for"); s != "" {
+		d, err := time.ParseDuration(s)
+		switch {
+		case err != nil:
+			v.AddFieldError("for", validator.InvalidFormat("must be a duration, such as 30m or 2h"))
+		case d < time.Minute || d > maxSnooze:
+			v.AddFieldError("for", validator.Invalid("must be between 1m and "+maxSnooze.String()))
+		}
+		snooze = d
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	reminder, err := app.models.Reminders.Get(r.Context(), id, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	reminder.Snooze(snooze)
+	err = app.models.Reminders.Update(r.Context(), reminder, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"reminder": reminder}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// rescheduleReminderHandler moves a reminder to a new time, dropping its snooze. A
+// reminder already sent fires again at the new time.
+func (app *application) rescheduleReminderHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+
+	user := app.contextGetUser(r)
+	reminder, err := app.models.Reminders.Get(r.Context(), id, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		RemindAt data.InputDate `json:"remind_at"`
+		Timezone *string        `json:"timezone"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	timezone := reminder.Timezone
+	if input.Timezone != nil {
+		timezone = *input.Timezone
+	}
+	reminder.Reschedule(input.RemindAt.In(timezone).GetTime(), timezone)
+
+	v := validator.New()
+	if data.ValidateReminder(v, reminder); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.models.Reminders.Update(r.Context(), reminder, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"reminder": reminder}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteReminderHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+
+	user := app.contextGetUser(r)
+	err := app.models.Reminders.Delete(r.Context(), id, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "reminder successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
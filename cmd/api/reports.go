@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/liuminhaw/yatijapp/internal/mailer"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// reportPreferences returns the report preferences of the user, the defaults when the
+// user has not saved any preferences.
+func (app *application) reportPreferences(
+	ctx context.Context,
+	userUUID uuid.UUID,
+) (data.ReportPreferences, error) {
+	preferences, err := app.models.UserPreferences.Get(ctx, userUUID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return data.ReportPreferences{}, nil
+		}
+		return data.ReportPreferences{}, err
+	}
+
+	return preferences.Reports, nil
+}
+
+// weeklyReport is the CSV file of a weekly report along with its totals.
+type weeklyReport struct {
+	csv   []byte
+	total time.Duration
+	// amounts sums the cost of the sessions spent on billable targets, in minor units,
+	// by currency.
+	amounts map[string]int64
+	// sources sums the duration of the sessions by the source they were created from.
+	sources map[string]time.Duration
+}
+
+// formattedAmounts returns the amounts of the report formatted with their currency,
+// sorted by currency.
+func (r weeklyReport) formattedAmounts() []string {
+	amounts := make([]string, 0, len(r.amounts))
+	for _, currency := range slices.Sorted(maps.Keys(r.amounts)) {
+		amounts = append(amounts, data.FormatAmount(r.amounts[currency], currency))
+	}
+
+	return amounts
+}
+
+// formattedSources returns the hours spent by source, such as "timer: 3.5", sorted by
+// source.
+func (r weeklyReport) formattedSources() []string {
+	sources := make([]string, 0, len(r.sources))
+	for _, source := range slices.Sorted(maps.Keys(r.sources)) {
+		sources = append(sources, fmt.Sprintf("%s: %.1f", source, r.sources[source].Hours()))
+	}
+
+	return sources
+}
+
+// weeklyReportCSV returns the sessions of a report as a CSV file, one session per row
+// with its date in the preferred format. Sessions still running are counted up to now,
+// and the duration of every session is rounded according to the rounding preferences.
+// The sessions of billable targets are costed at the rate of their target, and the source
+// of every session is listed so that automated integrations can be audited.
+func weeklyReportCSV(
+	sessions []*data.Session,
+	rates map[uuid.UUID]data.TargetRate,
+	prefs data.ReportPreferences,
+	now time.Time,
+) (weeklyReport, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	report := weeklyReport{
+		amounts: make(map[string]int64),
+		sources: make(map[string]time.Duration),
+	}
+
+	err := w.Write([]string{
+		"date", "starts_at", "ends_at", "duration_minutes", "target", "action",
+		"amount", "currency", "source",
+	})
+	if err != nil {
+		return weeklyReport{}, err
+	}
+
+	for _, s := range sessions {
+		end := now
+		endsAt := ""
+		if s.EndsAt.Valid {
+			end = s.EndsAt.Time
+			endsAt = s.EndsAt.Time.UTC().Format(time.RFC3339)
+		}
+		duration := prefs.Rounding.Round(end.Sub(s.StartsAt))
+		report.total += duration
+		report.sources[s.Source] += duration
+
+		amount, currency := "", ""
+		if rate, ok := rates[s.TargetUUID.UUID]; ok && s.TargetUUID.Valid {
+			cost := rate.Cost(duration)
+			report.amounts[rate.Currency] += cost
+			amount, currency = data.DecimalAmount(cost, rate.Currency), rate.Currency
+		}
+
+		err := w.Write([]string{
+			prefs.FormatDate(s.StartsAt.UTC()),
+			s.StartsAt.UTC().Format(time.RFC3339),
+			endsAt,
+			strconv.FormatFloat(duration.Minutes(), 'f', 0, 64),
+			s.TargetTitle,
+			s.ActionTitle,
+			amount,
+			currency,
+			s.Source,
+		})
+		if err != nil {
+			return weeklyReport{}, err
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return weeklyReport{}, err
+	}
+
+	report.csv = buf.Bytes()
+	return report, nil
+}
+
+// readRounding reads a rounding policy from the rounding and rounding_mode query string
+// parameters. Returns nil when the request does not set one.
+func (app *application) readRounding(r *http.Request, v *validator.Validator) *data.Rounding {
+	qs := r.URL.Query()
+	if !qs.Has("rounding") && !qs.Has("rounding_mode") {
+		return nil
+	}
+
+	rounding := data.Rounding{
+		Minutes: app.readInt(qs, "rounding", 0, v),
+		Mode:    app.readString(qs, "rounding_mode", ""),
+	}
+	data.ValidateRounding(v, rounding, "rounding")
+
+	return &rounding
+}
+
+// roundingLabel describes a rounding policy for the reports, such as "15 minutes, up".
+// Returns an empty string when durations are not rounded.
+func roundingLabel(rounding data.Rounding) string {
+	if rounding.Minutes == 0 {
+		return ""
+	}
+
+	mode := rounding.Mode
+	if mode == "" {
+		mode = "nearest"
+	}
+	return fmt.Sprintf("%d minutes, %s", rounding.Minutes, mode)
+}
+
+// sessionsReport is the report of the sessions of a user over a period, along with the
+// stats of their target reviews.
+type sessionsReport struct {
+	weeklyReport
+	from     time.Time
+	to       time.Time
+	sessions int
+	reviews  data.ReviewStats
+}
+
+// buildSessionsReport builds the report of the sessions the user started within the
+// period, their durations rounded as set in the preferences.
+func (app *application) buildSessionsReport(
+	ctx context.Context,
+	userUUID uuid.UUID,
+	prefs data.ReportPreferences,
+	from, to time.Time,
+) (sessionsReport, error) {
+	sessions, err := app.models.Sessions.GetAllStartedBetween(ctx, from, to, userUUID)
+	if err != nil {
+		return sessionsReport{}, err
+	}
+
+	var targetUUIDs []uuid.UUID
+	for _, s := range sessions {
+		if s.TargetUUID.Valid {
+			targetUUIDs = append(targetUUIDs, s.TargetUUID.UUID)
+		}
+	}
+	rates, err := app.models.Targets.GetRates(ctx, targetUUIDs)
+	if err != nil {
+		return sessionsReport{}, err
+	}
+
+	weekly, err := weeklyReportCSV(sessions, rates, prefs, to)
+	if err != nil {
+		return sessionsReport{}, err
+	}
+
+	reviews, err := app.models.Targets.ReviewStats(ctx, from, to, userUUID)
+	if err != nil {
+		return sessionsReport{}, err
+	}
+
+	report := sessionsReport{
+		weeklyReport: weekly,
+		from:         from,
+		to:           to,
+		sessions:     len(sessions),
+		reviews:      reviews,
+	}
+	return report, nil
+}
+
+// mailData returns the data the report is emailed with.
+func (r sessionsReport) mailData(username string, prefs data.ReportPreferences) map[string]any {
+	return map[string]any{
+		"username": username,
+		"from":     prefs.FormatDate(r.from),
+		"to":       prefs.FormatDate(r.to),
+		"sessions": r.sessions,
+		"hours":    fmt.Sprintf("%.1f", r.total.Hours()),
+		"amounts":  r.formattedAmounts(),
+		"sources":  r.formattedSources(),
+		"rounding": roundingLabel(prefs.Rounding),
+		"reviews": map[string]any{
+			"reviewed": r.reviews.Reviewed,
+			"rating":   fmt.Sprintf("%.1f", r.reviews.AverageRating),
+			"pending":  r.reviews.Pending,
+		},
+	}
+}
+
+// sendWeeklyReportHandler emails the user a report of their sessions of the current
+// week so far, attached as a CSV file, along with the stats of their target reviews. The
+// week starts on the day the user prefers. Durations are rounded as set in the request,
+// with the rounding and rounding_mode query string parameters, or else as the user
+// prefers.
+func (app *application) sendWeeklyReportHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	rounding := app.readRounding(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	// The report is written in the language requested by the client when translated.
+	lang := validator.MatchLanguage(r.Header.Get("Accept-Language"))
+	templateFile := mailer.Localized("weekly_report.tmpl", lang.String())
+
+	app.background(func() {
+		prefs, err := app.reportPreferences(context.Background(), user.UUID)
+		if err != nil {
+			app.logger.Error("Error building weekly report: " + err.Error())
+			return
+		}
+		if rounding != nil {
+			prefs.Rounding = *rounding
+		}
+
+		now := time.Now().UTC()
+		from := prefs.WeekStartOf(now)
+
+		report, err := app.buildSessionsReport(context.Background(), user.UUID, prefs, from, now)
+		if err != nil {
+			app.logger.Error("Error building weekly report: " + err.Error())
+			return
+		}
+
+		data := report.mailData(user.Name, prefs)
+		attachment := mailer.Attachment{
+			Name:        "yatijapp-weekly-report-" + now.Format(time.DateOnly) + ".csv",
+			ContentType: "text/csv",
+			Data:        report.csv,
+		}
+		err = app.mailer.Send(user.Email, templateFile, data, attachment)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to you containing your weekly report"}
+	err := app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// Trends compare the current period with up to maxTrendPeriods previous ones.
+const (
+	defaultTrendPeriods = 4
+	maxTrendPeriods     = 12
+)
+
+// showTrendsHandler returns the time tracked and the actions completed in the current
+// week or month so far, and in the previous ones, with the change from one period to the
+// next in percent. The period query string parameter is week, the default, or month, and
+// periods is the number of previous periods. Weeks start on the day the user prefers.
+func (app *application) showTrendsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	v := validator.New()
+	period := app.readString(qs, "period", "week")
+	v.CheckField(
+		validator.PermittedValue(period, "week", "month"),
+		"period",
+		validator.NotPermitted("must be one of week, month"),
+	)
+	previous := app.readInt(qs, "periods", defaultTrendPeriods, v)
+	v.CheckField(
+		previous >= 1 && previous <= maxTrendPeriods,
+		"periods",
+		validator.Invalid(fmt.Sprintf("must be between 1 and %d", maxTrendPeriods)),
+	)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	prefs, err := app.reportPreferences(r.Context(), user.UUID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	from := prefs.WeekStartOf(now)
+	step := func(t time.Time) time.Time { return t.AddDate(0, 0, -7) }
+	if period == "month" {
+		from = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		step = func(t time.Time) time.Time { return t.AddDate(0, -1, 0) }
+	}
+
+	periods := []*data.TrendPeriod{{From: from, To: now}}
+	for range previous {
+		to := from
+		from = step(from)
+		periods = append(periods, &data.TrendPeriod{From: from, To: to})
+	}
+
+	err = app.models.Trends.Fill(r.Context(), periods, user.UUID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"trends": envelope{"period": period, "periods": periods}}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
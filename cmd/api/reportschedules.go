@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/liuminhaw/yatijapp/internal/mailer"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// reportScheduleBatchSize is the number of due report schedules run on each run, the ones
+// left are run on the next runs.
+const reportScheduleBatchSize = 200
+
+// startReportScheduleRoutine periodically sends the reports of the schedules due.
+func (app *application) startReportScheduleRoutine() {
+	app.logger.Info("Report schedule routine started")
+
+	ticker := time.NewTicker(app.config.reportSchedules.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		app.background(func() {
+			app.runScheduled(jobReports, app.config.reportSchedules.interval, func() {
+				app.runReportSchedules(context.Background())
+			})
+		})
+	}
+}
+
+// runReportSchedules sends the reports of the next batch of schedules due, by email or
+// to the webhooks. A schedule only moves to its next run once its report is sent, so that
+// a failed delivery is retried on the next run.
+func (app *application) runReportSchedules(ctx context.Context) {
+	runs, err := app.models.ReportSchedules.Due(ctx, reportScheduleBatchSize)
+	if err != nil {
+		app.logger.Error("Error listing due report schedules: " + err.Error())
+		return
+	}
+	if len(runs) == 0 {
+		return
+	}
+
+	sent := 0
+	for i := range runs {
+		if err := app.sendScheduledReport(ctx, &runs[i]); err != nil {
+			app.logger.Error("Error sending scheduled report: "+err.Error(), "uuid", runs[i].UUID)
+			continue
+		}
+		sent++
+	}
+
+	app.logger.Info("Report schedules run", slog.Int("due", len(runs)), slog.Int("sent", sent))
+}
+
+// sendScheduledReport sends the report of the period ending on the run of the schedule,
+// then schedules the next run from now, skipping the periods missed in between.
+func (app *application) sendScheduledReport(
+	ctx context.Context,
+	run *data.ReportScheduleRun,
+) error {
+	prefs, err := app.reportPreferences(ctx, run.UserUUID)
+	if err != nil {
+		return err
+	}
+
+	from, to := run.Period()
+	report, err := app.buildSessionsReport(ctx, run.UserUUID, prefs, from, to)
+	if err != nil {
+		return err
+	}
+
+	switch run.Delivery {
+	case data.DeliveryWebhook:
+		err = app.postScheduledReport(ctx, run, report)
+	default:
+		mailData := report.mailData(run.Username, prefs)
+		mailData["frequency"] = run.Frequency
+		attachment := mailer.Attachment{
+			Name: fmt.Sprintf(
+				"yatijapp-%s-report-%s.csv",
+				run.Frequency,
+				from.Format(time.DateOnly),
+			),
+			ContentType: "text/csv",
+			Data:        report.csv,
+		}
+		err = app.mailer.Send(run.Email, "scheduled_report.tmpl", mailData, attachment)
+	}
+	if err != nil {
+		return err
+	}
+
+	run.Schedule(prefs, time.Now())
+	return app.models.ReportSchedules.Ran(ctx, &run.ReportSchedule)
+}
+
+// postScheduledReport posts the report of a schedule to its webhook as JSON, the sessions
+// listed in CSV.
+func (app *application) postScheduledReport(
+	ctx context.Context,
+	run *data.ReportScheduleRun,
+	report sessionsReport,
+) error {
+	sources := make(map[string]int64, len(report.sources))
+	for source, duration := range report.sources {
+		sources[source] = int64(duration.Seconds())
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"report_schedule": map[string]any{
+			"uuid":      run.UUID,
+			"frequency": run.Frequency,
+		},
+		"from":            report.from,
+		"to":              report.to,
+		"sessions":        report.sessions,
+		"tracked_seconds": int64(report.total.Seconds()),
+		"sources":         sources,
+		"amounts":         report.formattedAmounts(),
+		"csv":             string(report.csv),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		run.WebhookURL,
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "yatijapp-report-schedule/"+version)
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+func (app *application) listReportSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	schedules, err := app.models.ReportSchedules.GetAllForUser(r.Context(), user.UUID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"report_schedules": schedules}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createReportScheduleHandler schedules the weekly or monthly report of the sessions of
+// the user, sent by email unless a webhook is asked for. The first report is sent at the
+// end of the current period.
+func (app *application) createReportScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Frequency  string `json:"frequency"`
+		Delivery   string `json:"delivery"`
+		WebhookURL string `json:"webhook_url"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	schedule := data.ReportSchedule{
+		Frequency:  input.Frequency,
+		Delivery:   input.Delivery,
+		WebhookURL: input.WebhookURL,
+	}
+	if schedule.Delivery == "" {
+		schedule.Delivery = data.DeliveryEmail
+	}
+
+	v := validator.New()
+	if data.ValidateReportSchedule(v, &schedule); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	prefs, err := app.reportPreferences(r.Context(), user.UUID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	schedule.Schedule(prefs, time.Now())
+
+	err = app.models.ReportSchedules.Insert(
+		r.Context(),
+		&schedule,
+		user.UUID,
+		app.config.reportSchedules.maxPerUser,
+	)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", app.apiPath(r, "/reports/schedules/%s", schedule.UUID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"report_schedule": schedule}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showReportScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+
+	user := app.contextGetUser(r)
+	schedule, err := app.models.ReportSchedules.Get(r.Context(), id, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"report_schedule": schedule}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateReportScheduleHandler changes the frequency or the delivery of a report
+// schedule. A new frequency takes effect at the end of the current period.
+func (app *application) updateReportScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+
+	user := app.contextGetUser(r)
+	schedule, err := app.models.ReportSchedules.Get(r.Context(), id, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Frequency  *string `json:"frequency"`
+		Delivery   *string `json:"delivery"`
+		WebhookURL *string `json:"webhook_url"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	reschedule := input.Frequency != nil && *input.Frequency != schedule.Frequency
+	if input.Frequency != nil {
+		schedule.Frequency = *input.Frequency
+	}
+	if input.Delivery != nil {
+		schedule.Delivery = *input.Delivery
+		if schedule.Delivery == data.DeliveryEmail && input.WebhookURL == nil {
+			schedule.WebhookURL = ""
+		}
+	}
+	if input.WebhookURL != nil {
+		schedule.WebhookURL = *input.WebhookURL
+	}
+
+	v := validator.New()
+	if data.ValidateReportSchedule(v, schedule); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	if reschedule {
+		prefs, err := app.reportPreferences(r.Context(), user.UUID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		schedule.Schedule(prefs, time.Now())
+	}
+
+	err = app.models.ReportSchedules.Update(r.Context(), schedule, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"report_schedule": schedule}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteReportScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+
+	user := app.contextGetUser(r)
+	err := app.models.ReportSchedules.Delete(r.Context(), id, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(
+		w,
+		http.StatusOK,
+		envelope{"message": "report schedule successfully deleted"},
+		nil,
+	)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
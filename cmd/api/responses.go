@@ -0,0 +1,201 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/data"
+)
+
+// The response types below are the payloads sent to the clients, kept apart from the
+// data structs so that the contract does not change along with the storage. Every field
+// is always present, even when empty, except the notes which are only loaded, and so
+// only present, in the responses of a single record. List responses tell whether a
+// record has notes with has_notes instead. The review and rate of a target are likewise
+// only present in the responses of a single target, once they have been set.
+
+type targetResponse struct {
+	UUID          uuid.UUID     `json:"uuid"`
+	CreatedAt     time.Time     `json:"created_at"`
+	DueDate       data.NullTime `json:"due_date"`
+	DueTimezone   string        `json:"due_timezone"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+	LastActive    time.Time     `json:"last_active"`
+	Title         string        `json:"title"`
+	Description   string        `json:"description"`
+	Notes         *string       `json:"notes,omitempty"`
+	Version       int32         `json:"version"`
+	Status        data.Status   `json:"status"`
+	HasNotes      bool          `json:"has_notes"`
+	ActionsCount  int64         `json:"actions_count"`
+	DerivedStatus *data.Status  `json:"derived_status"` // null without actions to derive it from
+	Role          string        `json:"role"`
+	// Review is the retrospective of a completed target, once it has been reviewed.
+	Review *data.TargetReview `json:"review,omitempty"`
+	// Rate is the default hourly rate of a billable target.
+	Rate *targetRateResponse `json:"rate,omitempty"`
+}
+
+// targetRateResponse is an hourly rate, in minor units of the currency, along with its
+// formatted amount.
+type targetRateResponse struct {
+	Amount    int64  `json:"amount"`
+	Currency  string `json:"currency"`
+	Formatted string `json:"formatted"`
+}
+
+func newTargetResponse(t *data.Target, withNotes bool) targetResponse {
+	res := targetResponse{
+		UUID:         t.UUID,
+		CreatedAt:    t.CreatedAt,
+		DueDate:      t.DueDate.In(t.DueTimezone),
+		DueTimezone:  t.DueTimezone,
+		UpdatedAt:    t.UpdatedAt,
+		LastActive:   t.LastActive,
+		Title:        t.Title,
+		Description:  t.Description,
+		Version:      t.Version,
+		Status:       t.Status,
+		HasNotes:     t.HasNotes,
+		ActionsCount: t.ActionsCount,
+		Role:         t.Role,
+	}
+	if withNotes {
+		res.Notes = &t.Notes
+		res.Review = t.Review
+		if t.Rate != nil {
+			res.Rate = &targetRateResponse{
+				Amount:    t.Rate.Amount,
+				Currency:  t.Rate.Currency,
+				Formatted: t.Rate.Format(),
+			}
+		}
+	}
+	if t.DerivedStatus != "" {
+		res.DerivedStatus = &t.DerivedStatus
+	}
+
+	return res
+}
+
+func newTargetsResponse(targets []*data.Target) []targetResponse {
+	res := make([]targetResponse, len(targets))
+	for i, t := range targets {
+		res[i] = newTargetResponse(t, false)
+	}
+
+	return res
+}
+
+type actionResponse struct {
+	UUID            uuid.UUID     `json:"uuid"`
+	CreatedAt       time.Time     `json:"created_at"`
+	DueDate         data.NullTime `json:"due_date"`
+	DueTimezone     string        `json:"due_timezone"`
+	UpdatedAt       time.Time     `json:"updated_at"`
+	LastActive      time.Time     `json:"last_active"`
+	Title           string        `json:"title"`
+	Description     string        `json:"description"`
+	Notes           *string       `json:"notes,omitempty"`
+	Version         int32         `json:"version"`
+	Status          data.Status   `json:"status"`
+	TargetUUID      uuid.UUID     `json:"target_uuid"`
+	TargetTitle     string        `json:"target_title"`
+	HasNotes        bool          `json:"has_notes"`
+	SessionsCount   int64         `json:"sessions_count"`
+	ChecklistCount  int64         `json:"checklist_count"`
+	ChecklistDone   int64         `json:"checklist_done"`
+	Tags            []string      `json:"tags"`
+	EstimateMinutes int           `json:"estimate_minutes"`
+	Role            string        `json:"role"`
+}
+
+func newActionResponse(a *data.Action, withNotes bool) actionResponse {
+	res := actionResponse{
+		UUID:            a.UUID,
+		CreatedAt:       a.CreatedAt,
+		DueDate:         a.DueDate.In(a.DueTimezone),
+		DueTimezone:     a.DueTimezone,
+		UpdatedAt:       a.UpdatedAt,
+		LastActive:      a.LastActive,
+		Title:           a.Title,
+		Description:     a.Description,
+		Version:         a.Version,
+		Status:          a.Status,
+		TargetUUID:      a.TargetUUID,
+		TargetTitle:     a.TargetTitle,
+		HasNotes:        a.HasNotes,
+		SessionsCount:   a.SessionsCount,
+		ChecklistCount:  a.ChecklistCount,
+		ChecklistDone:   a.ChecklistDone,
+		Tags:            a.Tags,
+		EstimateMinutes: a.EstimateMinutes,
+		Role:            a.Role,
+	}
+	if res.Tags == nil {
+		res.Tags = []string{}
+	}
+	if withNotes {
+		res.Notes = &a.Notes
+	}
+
+	return res
+}
+
+func newActionsResponse(actions []*data.Action) []actionResponse {
+	res := make([]actionResponse, len(actions))
+	for i, a := range actions {
+		res[i] = newActionResponse(a, false)
+	}
+
+	return res
+}
+
+type sessionResponse struct {
+	UUID        uuid.UUID     `json:"uuid"`
+	StartsAt    time.Time     `json:"starts_at"`
+	EndsAt      data.NullTime `json:"ends_at"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+	Notes       *string       `json:"notes,omitempty"`
+	Version     int32         `json:"version"`
+	ActionUUID  uuid.NullUUID `json:"action_uuid"`
+	ActionTitle string        `json:"action_title"`
+	TargetUUID  uuid.NullUUID `json:"target_uuid"`
+	TargetTitle string        `json:"target_title"`
+	HasNotes    bool          `json:"has_notes"`
+	Source      string        `json:"source"`
+	Role        string        `json:"role"`
+}
+
+func newSessionResponse(s *data.Session, withNotes bool) sessionResponse {
+	res := sessionResponse{
+		UUID:        s.UUID,
+		StartsAt:    s.StartsAt,
+		EndsAt:      s.EndsAt,
+		CreatedAt:   s.CreatedAt,
+		UpdatedAt:   s.UpdatedAt,
+		Version:     s.Version,
+		ActionUUID:  s.ActionUUID,
+		ActionTitle: s.ActionTitle,
+		TargetUUID:  s.TargetUUID,
+		TargetTitle: s.TargetTitle,
+		HasNotes:    s.HasNotes,
+		Source:      s.Source,
+		Role:        s.Role,
+	}
+	if withNotes {
+		res.Notes = &s.Notes
+	}
+
+	return res
+}
+
+func newSessionsResponse(sessions []*data.Session) []sessionResponse {
+	res := make([]sessionResponse, len(sessions))
+	for i, s := range sessions {
+		res[i] = newSessionResponse(s, false)
+	}
+
+	return res
+}
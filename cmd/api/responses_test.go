@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/data"
+)
+
+// fields marshals the response and decodes it back as a JSON object.
+func fields(t *testing.T, response any) map[string]any {
+	t.Helper()
+
+	js, err := json.Marshal(response)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	var decoded map[string]any
+	if err := json.Unmarshal(js, &decoded); err != nil {
+		t.Fatalf("unmarshal %s: %v", js, err)
+	}
+
+	return decoded
+}
+
+func TestResponseFields(t *testing.T) {
+	now := time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC)
+	id := uuid.Must(uuid.NewV7())
+
+	emptyTarget := &data.Target{UUID: id, CreatedAt: now, UpdatedAt: now, LastActive: now}
+	fullTarget := &data.Target{
+		UUID:          id,
+		CreatedAt:     now,
+		DueDate:       data.NewNullTime(now),
+		DueTimezone:   "Asia/Taipei",
+		UpdatedAt:     now,
+		LastActive:    now,
+		Title:         "Thesis",
+		Description:   "Write it",
+		Notes:         "Chapter 2 first",
+		Status:        data.Status("in progress"),
+		DerivedStatus: data.Status("completed"),
+		Rate:          &data.TargetRate{Amount: 8550, Currency: "EUR"},
+		Role:          "owner",
+	}
+	emptyAction := &data.Action{UUID: id, CreatedAt: now, UpdatedAt: now, LastActive: now}
+	emptySession := &data.Session{UUID: id, StartsAt: now, CreatedAt: now, UpdatedAt: now}
+
+	tests := []struct {
+		name     string
+		response any
+		// values lists the fields which must be present, with their JSON value, nil
+		// standing for null.
+		values map[string]any
+		// absent lists the fields which must be left out.
+		absent []string
+	}{
+		{
+			name:     "target with empty fields",
+			response: newTargetResponse(emptyTarget, false),
+			values: map[string]any{
+				"title":          "",
+				"description":    "",
+				"status":         "",
+				"due_date":       nil,
+				"due_timezone":   "",
+				"derived_status": nil,
+				"has_notes":      false,
+				"actions_count":  float64(0),
+				"role":           "",
+			},
+			absent: []string{"notes", "review", "rate"},
+		},
+		{
+			name:     "target with notes",
+			response: newTargetResponse(emptyTarget, true),
+			values:   map[string]any{"notes": ""},
+			absent:   []string{"review", "rate"},
+		},
+		{
+			name:     "target with every field",
+			response: newTargetResponse(fullTarget, true),
+			values: map[string]any{
+				"title":          "Thesis",
+				"description":    "Write it",
+				"notes":          "Chapter 2 first",
+				"status":         "in progress",
+				"derived_status": "completed",
+				"due_date":       "2026-10-17T17:30:00+08:00",
+				"due_timezone":   "Asia/Taipei",
+				"rate": map[string]any{
+					"amount":    float64(8550),
+					"currency":  "EUR",
+					"formatted": fullTarget.Rate.Format(),
+				},
+			},
+		},
+		{
+			name:     "listed target",
+			response: newTargetsResponse([]*data.Target{fullTarget})[0],
+			values:   map[string]any{"description": "Write it", "derived_status": "completed"},
+			absent:   []string{"notes", "rate"},
+		},
+		{
+			name:     "action with empty fields",
+			response: newActionResponse(emptyAction, false),
+			values: map[string]any{
+				"title":            "",
+				"description":      "",
+				"status":           "",
+				"due_date":         nil,
+				"target_title":     "",
+				"has_notes":        false,
+				"sessions_count":   float64(0),
+				"checklist_count":  float64(0),
+				"checklist_done":   float64(0),
+				"tags":             []any{},
+				"estimate_minutes": float64(0),
+				"role":             "",
+			},
+			absent: []string{"notes"},
+		},
+		{
+			name:     "action with notes",
+			response: newActionResponse(emptyAction, true),
+			values:   map[string]any{"notes": ""},
+		},
+		{
+			name:     "session with empty fields",
+			response: newSessionResponse(emptySession, false),
+			values: map[string]any{
+				"ends_at":      nil,
+				"action_uuid":  nil,
+				"action_title": "",
+				"target_uuid":  nil,
+				"target_title": "",
+				"has_notes":    false,
+				"source":       "",
+				"role":         "",
+			},
+			absent: []string{"notes"},
+		},
+		{
+			name:     "session with notes",
+			response: newSessionResponse(emptySession, true),
+			values:   map[string]any{"notes": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fields(t, tt.response)
+
+			for field, want := range tt.values {
+				value, ok := got[field]
+				if !ok {
+					t.Errorf("%s: missing", field)
+					continue
+				}
+				if !jsonEqual(value, want) {
+					t.Errorf("%s: got %#v, want %#v", field, value, want)
+				}
+			}
+			for _, field := range tt.absent {
+				if value, ok := got[field]; ok {
+					t.Errorf("%s: got %#v, want it left out", field, value)
+				}
+			}
+		})
+	}
+}
+
+// jsonEqual compares two decoded JSON values.
+func jsonEqual(a, b any) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/liuminhaw/yatijapp/internal/data"
+)
+
+// retentionReport is the outcome of a retention run, the number of records purged and
+// the error met for each resource.
+type retentionReport struct {
+	StartedAt time.Time         `json:"started_at"`
+	Duration  time.Duration     `json:"duration"`
+	Purged    map[string]int64  `json:"purged"`
+	Errors    map[string]string `json:"errors,omitzero"`
+}
+
+// retentionLog keeps the report of the last retention run.
+type retentionLog struct {
+	mu     sync.Mutex
+	runs   int64
+	failed int64
+	last   *retentionReport
+}
+
+func newRetentionLog() *retentionLog {
+	return &retentionLog{}
+}
+
+func (l *retentionLog) record(report retentionReport) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.runs++
+	if len(report.Errors) > 0 {
+		l.failed++
+	}
+	l.last = &report
+}
+
+func (l *retentionLog) stats() any {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return map[string]any{
+		"runs":   l.runs,
+		"failed": l.failed,
+		"last":   l.last,
+	}
+}
+
+// retentionDays returns the days the records of each resource are kept, the resources
+// kept forever being left out.
+func (app *application) retentionDays() map[string]int {
+	days := map[string]int{
+		data.RetentionSessions:        app.config.retention.sessionsDays,
+		data.RetentionArchivedTargets: app.config.retention.archivedTargetsDays,
+		data.RetentionArchivedActions: app.config.retention.archivedActionsDays,
+		data.RetentionDevices:         app.config.retention.devicesDays,
+		data.RetentionPlans:           app.config.retention.plansDays,
+		data.RetentionACLAudit:        app.config.retention.aclAuditDays,
+	}
+	for resource, n := range days {
+		if n <= 0 {
+			delete(days, resource)
+		}
+	}
+
+	return days
+}
+
+// startRetentionRoutine periodically purges the records kept past their retention
+// period, for the operators with storage or compliance constraints.
+func (app *application) startRetentionRoutine() {
+	app.logger.Info("Retention routine started")
+
+	ticker := time.NewTicker(app.config.retention.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		app.background(func() {
+			app.runScheduled(jobRetention, app.config.retention.interval, func() {
+				app.applyRetention(context.Background())
+			})
+		})
+	}
+}
+
+// applyRetention purges the records of every resource past its retention period, then
+// logs and records the report of the run. A failing resource does not stop the others.
+func (app *application) applyRetention(ctx context.Context) {
+	report := retentionReport{
+		StartedAt: time.Now().UTC(),
+		Purged:    make(map[string]int64),
+		Errors:    make(map[string]string),
+	}
+
+	for resource, days := range app.retentionDays() {
+		cutoff := report.StartedAt.AddDate(0, 0, -days)
+		purged, err := app.models.Retention.Purge(
+			ctx,
+			resource,
+			cutoff,
+			app.config.retention.batchSize,
+		)
+		report.Purged[resource] = purged
+		if err != nil {
+			report.Errors[resource] = err.Error()
+			app.logger.Error(
+				"Error purging records past retention",
+				"resource", resource,
+				"error", err.Error(),
+			)
+		}
+	}
+	report.Duration = time.Since(report.StartedAt)
+	app.retention.record(report)
+
+	attrs := []any{slog.Duration("duration", report.Duration)}
+	for resource, purged := range report.Purged {
+		attrs = append(attrs, slog.Int64(resource, purged))
+	}
+	app.logger.Info("Retention run completed", attrs...)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// promptTargetReview asks the user by email to review the target they just completed,
+// when they opted in to review prompts. Failing to do so does not fail the request, the
+// target itself has been saved.
+func (app *application) promptTargetReview(r *http.Request, target *data.Target, user *data.User) {
+	preferences, err := app.models.UserPreferences.Get(r.Context(), user.UUID)
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			app.logError(r, err)
+		}
+		return
+	}
+	if !preferences.Reviews.Prompt {
+		return
+	}
+
+	app.background(func() {
+		data := map[string]any{
+			"username":   user.Name,
+			"title":      target.Title,
+			"targetUUID": target.UUID.String(),
+		}
+		if err := app.mailer.Send(user.Email, "review_request.tmpl", data); err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+}
+
+// updateTargetReviewHandler saves the review, a rating from 1 to 5 and a note, of a
+// completed target.
+func (app *application) updateTargetReviewHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+
+	var input struct {
+		Rating int    `json:"rating"`
+		Note   string `json:"note"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	review := data.TargetReview{Rating: input.Rating, Note: input.Note}
+
+	v := validator.New()
+	if data.ValidateTargetReview(v, &review); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	target, err := app.models.Targets.Get(r.Context(), id, user.UUID, "editor")
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	v.CheckField(
+		target.Status == data.StatusComplete,
+		"status",
+		validator.NotPermitted("only completed targets can be reviewed"),
+	)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.models.Targets.UpdateReview(r.Context(), target.UUID, &review, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+	target.Review = &review
+
+	env := envelope{"target": newTargetResponse(target, true)}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
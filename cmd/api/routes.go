@@ -13,147 +13,583 @@ func (app *application) routes() http.Handler {
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
+	// Each API version is mounted under its own path prefix, side by side.
+	v1 := app.v1Routes()
+	v2 := app.v2Routes(v1)
+	app.markDeprecated(v1, apiV1)
+	app.markDeprecated(v2, apiV2)
+	v1.mount(router, apiV1)
+	v2.mount(router, apiV2)
+
+	// For expvar handler
+	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+	// Business KPIs in the OpenMetrics format
+	router.HandlerFunc(http.MethodGet, "/debug/metrics", app.openMetricsHandler)
+	// Mail templates previews, for operators reviewing template changes
+	if app.config.env == "development" {
+		router.HandlerFunc(http.MethodGet, "/debug/mail", app.listMailTemplatesHandler)
+		router.HandlerFunc(http.MethodGet, "/debug/mail/:template", app.showMailPreviewHandler)
+	}
+
+	return app.metrics(
+		app.recoverPanic(
+			app.traceRequests(
+				app.negotiateVersion(
+					app.enableCORS(
+						app.filterIPs(
+							app.rateLimit(
+								app.databaseBreaker(
+									app.requestTimeout(
+										app.authenticate(
+											app.memoizeACLs(app.traceHandler(router)),
+										),
+									),
+								),
+							),
+						),
+					),
+				),
+			),
+		),
+	)
+}
+
+// v1Routes returns the routes of the first version of the API, relative to the /v1
+// prefix.
+func (app *application) v1Routes() *routeSet {
+	v1 := &routeSet{}
+
 	// Healthcheck
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	v1.HandlerFunc(http.MethodGet, "/healthcheck", app.healthcheckHandler)
+
+	// Meta routes
+	v1.HandlerFunc(
+		http.MethodGet,
+		"/meta/limits",
+		app.requireActivatedUser(app.showLimitsHandler),
+	)
 
 	// Targets routes
-	router.HandlerFunc(
+	v1.HandlerFunc(
 		http.MethodGet,
-		"/v1/targets",
+		"/targets",
 		app.requireActivatedUser(app.listTargetsHandler),
 	)
-	router.HandlerFunc(
+	v1.HandlerFunc(
 		http.MethodPost,
-		"/v1/targets",
+		"/targets",
 		app.requireActivatedUser(app.createTargetHandler),
 	)
-	router.HandlerFunc(
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/targets/:uuid",
+		app.requireActivatedUser(app.segmentRoute(map[string]http.HandlerFunc{
+			"bulk-delete": app.bulkDeleteHandler("target"),
+			"validate":    app.validateHandler(app.createTargetHandler, app.updateTargetHandler),
+		})),
+	)
+	v1.HandlerFunc(
 		http.MethodGet,
-		"/v1/targets/:uuid",
-		app.requireActivatedUser(app.showTargetHandler),
+		"/targets/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.showTargetHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodHead,
+		"/targets/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.showTargetHandler)),
 	)
-	router.HandlerFunc(
+	v1.HandlerFunc(
 		http.MethodPatch,
-		"/v1/targets/:uuid",
-		app.requireActivatedUser(app.updateTargetHandler),
+		"/targets/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.updateTargetHandler)),
 	)
-	router.HandlerFunc(
+	v1.HandlerFunc(
 		http.MethodDelete,
-		"/v1/targets/:uuid",
-		app.requireActivatedUser(app.deleteTargetHandler),
+		"/targets/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.deleteTargetHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodGet,
+		"/targets/:uuid/forecast",
+		app.requireActivatedUser(app.requireUUIDParam(app.showTargetForecastHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodPut,
+		"/targets/:uuid/rate",
+		app.requireActivatedUser(app.requireUUIDParam(app.updateTargetRateHandler)),
 	)
-	router.HandlerFunc(
+	v1.HandlerFunc(
+		http.MethodPut,
+		"/targets/:uuid/review",
+		app.requireActivatedUser(app.requireUUIDParam(app.updateTargetReviewHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodGet,
+		"/targets/:uuid/acl",
+		app.requireActivatedUser(app.requireUUIDParam(app.listACLHandler("target"))),
+	)
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/targets/:uuid/notes",
+		app.requireActivatedUser(app.requireUUIDParam(app.appendNotesHandler("target"))),
+	)
+	v1.HandlerFunc(
+		http.MethodGet,
+		"/targets/:uuid/acl/history",
+		app.requireActivatedUser(app.requireUUIDParam(app.listACLHistoryHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/targets/:uuid/acl/propagate",
+		app.requireActivatedUser(app.requireUUIDParam(app.propagateACLHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodGet,
+		"/targets/:uuid/actions",
+		app.requireActivatedUser(app.requireUUIDParam(app.listTargetActionsHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/targets/:uuid/actions/reassign",
+		app.requireActivatedUser(app.requireUUIDParam(app.reassignTargetActionsHandler)),
+	)
+	v1.HandlerFunc(
 		http.MethodGet,
-		"/v1/targets/:uuid/actions",
-		app.requireActivatedUser(app.listTargetActionsHandler),
+		"/targets/:uuid/sessions",
+		app.requireActivatedUser(app.requireUUIDParam(app.listTargetSessionsHandler)),
 	)
 
 	// Actions routes
-	router.HandlerFunc(
+	v1.HandlerFunc(
 		http.MethodGet,
-		"/v1/actions",
+		"/actions",
 		app.requireActivatedUser(app.listActionsHandler),
 	)
-	router.HandlerFunc(
+	v1.HandlerFunc(
 		http.MethodPost,
-		"/v1/actions",
+		"/actions",
 		app.requireActivatedUser(app.createActionHandler),
 	)
-	router.HandlerFunc(
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/actions/:uuid",
+		app.requireActivatedUser(app.segmentRoute(map[string]http.HandlerFunc{
+			"bulk-delete": app.bulkDeleteHandler("action"),
+			"validate":    app.validateHandler(app.createActionHandler, app.updateActionHandler),
+		})),
+	)
+	v1.HandlerFunc(
 		http.MethodGet,
-		"/v1/actions/:uuid",
-		app.requireActivatedUser(app.showActionHandler),
+		"/actions/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.showActionHandler)),
 	)
-	router.HandlerFunc(
+	v1.HandlerFunc(
+		http.MethodHead,
+		"/actions/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.showActionHandler)),
+	)
+	v1.HandlerFunc(
 		http.MethodPatch,
-		"/v1/actions/:uuid",
-		app.requireActivatedUser(app.updateActionHandler),
+		"/actions/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.updateActionHandler)),
 	)
-	router.HandlerFunc(
+	v1.HandlerFunc(
 		http.MethodDelete,
-		"/v1/actions/:uuid",
-		app.requireActivatedUser(app.deleteActionHandler),
+		"/actions/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.deleteActionHandler)),
 	)
-	router.HandlerFunc(
+	v1.HandlerFunc(
 		http.MethodGet,
-		"/v1/actions/:uuid/sessions",
-		app.requireActivatedUser(app.listActionSessionsHandler),
+		"/actions/:uuid/acl",
+		app.requireActivatedUser(app.requireUUIDParam(app.listACLHandler("action"))),
+	)
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/actions/:uuid/notes",
+		app.requireActivatedUser(app.requireUUIDParam(app.appendNotesHandler("action"))),
+	)
+	v1.HandlerFunc(
+		http.MethodGet,
+		"/actions/:uuid/checklist",
+		app.requireActivatedUser(app.requireUUIDParam(app.listChecklistHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/actions/:uuid/checklist",
+		app.requireActivatedUser(app.requireUUIDParam(app.createChecklistItemHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodPatch,
+		"/checklist-items/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.updateChecklistItemHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodDelete,
+		"/checklist-items/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.deleteChecklistItemHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodGet,
+		"/actions/:uuid/sessions",
+		app.requireActivatedUser(app.requireUUIDParam(app.listActionSessionsHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/actions/:uuid/sessions/reassign",
+		app.requireActivatedUser(app.requireUUIDParam(app.reassignActionSessionsHandler)),
 	)
 
 	// Sessions routes
-	router.HandlerFunc(
+	v1.HandlerFunc(
 		http.MethodGet,
-		"/v1/sessions",
+		"/sessions",
 		app.requireActivatedUser(app.listSessionsHandler),
 	)
-	router.HandlerFunc(
+	v1.HandlerFunc(
 		http.MethodPost,
-		"/v1/sessions",
+		"/sessions",
 		app.requireActivatedUser(app.createSessionHandler),
 	)
-	router.HandlerFunc(
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/sessions/:uuid",
+		app.requireActivatedUser(app.segmentRoute(map[string]http.HandlerFunc{
+			"bulk-delete": app.bulkDeleteHandler("session"),
+			"validate":    app.validateHandler(app.createSessionHandler, app.updateSessionHandler),
+		})),
+	)
+	v1.HandlerFunc(
 		http.MethodGet,
-		"/v1/sessions/:uuid",
-		app.requireActivatedUser(app.showSessionHandler),
+		"/sessions/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.showSessionHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodHead,
+		"/sessions/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.showSessionHandler)),
 	)
-	router.HandlerFunc(
+	v1.HandlerFunc(
 		http.MethodPatch,
-		"/v1/sessions/:uuid",
-		app.requireActivatedUser(app.updateSessionHandler),
+		"/sessions/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.updateSessionHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodDelete,
+		"/sessions/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.deleteSessionHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodGet,
+		"/sessions/:uuid/acl",
+		app.requireActivatedUser(app.requireUUIDParam(app.listACLHandler("session"))),
+	)
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/sessions/:uuid/notes",
+		app.requireActivatedUser(app.requireUUIDParam(app.appendNotesHandler("session"))),
+	)
+
+	// Undo routes
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/undo/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.undoOperationHandler)),
+	)
+
+	// Permissions routes
+	v1.HandlerFunc(
+		http.MethodGet,
+		"/permissions",
+		app.requireActivatedUser(app.permissionsHandler),
+	)
+
+	// Invitations routes
+	v1.HandlerFunc(
+		http.MethodGet,
+		"/invitations",
+		app.requireActivatedUser(app.listInvitationsHandler),
+	)
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/invitations",
+		app.requireActivatedUser(app.createInvitationHandler),
+	)
+	v1.HandlerFunc(
+		http.MethodPut,
+		"/invitations/accepted",
+		app.requireActivatedUser(app.acceptInvitationHandler),
 	)
-	router.HandlerFunc(
+	// Declining only takes the token sent by email, invitees may have no account
+	v1.HandlerFunc(http.MethodPut, "/invitations/declined", app.declineInvitationHandler)
+	v1.HandlerFunc(
 		http.MethodDelete,
-		"/v1/sessions/:uuid",
-		app.requireActivatedUser(app.deleteSessionHandler),
+		"/invitations/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.deleteInvitationHandler)),
+	)
+
+	// Client apps routes
+	v1.HandlerFunc(http.MethodGet, "/clients", app.requireActivatedUser(app.listClientAppsHandler))
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/clients",
+		app.requireActivatedUser(app.registerClientAppHandler),
 	)
 
 	// Users routes
-	router.HandlerFunc(
+	v1.HandlerFunc(
 		http.MethodGet,
-		"/v1/users/me",
+		"/users/me",
 		app.requireActivatedUser(app.showCurrentUserHandler),
 	)
-	router.HandlerFunc(
+	v1.HandlerFunc(
+		http.MethodGet,
+		"/users/me/sharing-report",
+		app.requireActivatedUser(app.showSharingReportHandler),
+	)
+	v1.HandlerFunc(
 		http.MethodGet,
-		"/v1/users/preferences",
+		"/users/preferences",
 		app.requireActivatedUser(app.showUserPreferencesHandler),
 	)
-	router.HandlerFunc(
+	v1.HandlerFunc(
 		http.MethodPut,
-		"/v1/users/preferences",
+		"/users/preferences",
 		app.requireActivatedUser(app.updateUserPreferencesHandler),
 	)
+	v1.HandlerFunc(
+		http.MethodGet,
+		"/users/archive-rules",
+		app.requireActivatedUser(app.listArchiveRulesHandler),
+	)
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/users/archive-rules",
+		app.requireActivatedUser(app.createArchiveRuleHandler),
+	)
+	v1.HandlerFunc(
+		http.MethodPatch,
+		"/users/archive-rules/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.updateArchiveRuleHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodDelete,
+		"/users/archive-rules/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.deleteArchiveRuleHandler)),
+	)
+
+	v1.HandlerFunc(
+		http.MethodGet,
+		"/plan/today",
+		app.requireActivatedUser(app.showPlanTodayHandler),
+	)
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/plan/today",
+		app.requireActivatedUser(app.updatePlanTodayHandler),
+	)
+	v1.HandlerFunc(
+		http.MethodGet,
+		"/reminders",
+		app.requireActivatedUser(app.listRemindersHandler),
+	)
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/reminders",
+		app.requireActivatedUser(app.createReminderHandler),
+	)
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/reminders/:uuid/snooze",
+		app.requireActivatedUser(app.requireUUIDParam(app.snoozeReminderHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/reminders/:uuid/reschedule",
+		app.requireActivatedUser(app.requireUUIDParam(app.rescheduleReminderHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodDelete,
+		"/reminders/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.deleteReminderHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/quick-add",
+		app.requireActivatedUser(app.quickAddHandler),
+	)
+	v1.HandlerFunc(
+		http.MethodGet,
+		"/saved-searches",
+		app.requireActivatedUser(app.listSavedSearchesHandler),
+	)
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/saved-searches",
+		app.requireActivatedUser(app.createSavedSearchHandler),
+	)
+	v1.HandlerFunc(
+		http.MethodGet,
+		"/saved-searches/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.showSavedSearchHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodPatch,
+		"/saved-searches/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.updateSavedSearchHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodDelete,
+		"/saved-searches/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.deleteSavedSearchHandler)),
+	)
 
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+	v1.HandlerFunc(http.MethodPost, "/users", app.registerUserHandler)
 	// Activate a user account
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	v1.HandlerFunc(http.MethodPut, "/users/activated", app.activateUserHandler)
+	v1.HandlerFunc(http.MethodGet, "/users/activation-status", app.showActivationStatusHandler)
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/users/reports/weekly",
+		app.requireActivatedUser(app.sendWeeklyReportHandler),
+	)
+	v1.HandlerFunc(
+		http.MethodGet,
+		"/reports/trends",
+		app.requireActivatedUser(app.showTrendsHandler),
+	)
+	v1.HandlerFunc(
+		http.MethodGet,
+		"/reports/schedules",
+		app.requireActivatedUser(app.listReportSchedulesHandler),
+	)
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/reports/schedules",
+		app.requireActivatedUser(app.createReportScheduleHandler),
+	)
+	v1.HandlerFunc(
+		http.MethodGet,
+		"/reports/schedules/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.showReportScheduleHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodPatch,
+		"/reports/schedules/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.updateReportScheduleHandler)),
+	)
+	v1.HandlerFunc(
+		http.MethodDelete,
+		"/reports/schedules/:uuid",
+		app.requireActivatedUser(app.requireUUIDParam(app.deleteReportScheduleHandler)),
+	)
+	v1.HandlerFunc(http.MethodPut, "/users/password", app.updateUserPasswordHandler)
 
 	// Tokens routes
 	// Generate a new activation token for a user
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	v1.HandlerFunc(http.MethodPost, "/tokens/activation", app.createActivationTokenHandler)
 	// Generate a new authentication token (access token & refresh token) for a user
-	router.HandlerFunc(
+	v1.HandlerFunc(
 		http.MethodPost,
-		"/v1/tokens/authentication",
+		"/tokens/authentication",
 		app.createAuthenticationTokenHandler,
 	)
+	// Generate a new authentication token for the user of a Google account
+	v1.HandlerFunc(
+		http.MethodPost,
+		"/tokens/oauth/google",
+		app.createGoogleAuthenticationTokenHandler,
+	)
 	// Generate a new pair of authentication tokens for a user by using a valid refresh token
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
-	router.HandlerFunc(
+	v1.HandlerFunc(http.MethodPost, "/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	v1.HandlerFunc(
 		http.MethodPost,
-		"/v1/tokens/password-reset",
+		"/tokens/password-reset",
 		app.createPasswordResetTokenHandler,
 	)
 
-	router.HandlerFunc(
+	// Revoke a session with the token sent on sign-ins from a new device
+	v1.HandlerFunc(
+		http.MethodPut,
+		"/tokens/sessions/revoked",
+		app.revokeTokenSessionHandler,
+	)
+	v1.HandlerFunc(
 		http.MethodDelete,
-		"/v1/tokens/sessions/:uuid",
-		app.requireAuthenticatedUser(app.deleteTokenSessionHandler),
+		"/tokens/sessions/:uuid",
+		app.requireAuthenticatedUser(app.requireUUIDParam(app.deleteTokenSessionHandler)),
 	)
 
-	// For expvar handler
-	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+	// Clients rejected by the rate limiter, for the operators
+	if app.limiter != nil {
+		v1.HandlerFunc(
+			http.MethodGet,
+			"/admin/limiter/offenders",
+			app.requireOperator(app.listLimiterOffendersHandler),
+		)
+	}
+
+	// Requests per client app, for the operators
+	v1.HandlerFunc(
+		http.MethodGet,
+		"/admin/clients/stats",
+		app.requireOperator(app.showClientStatsHandler),
+	)
+
+	// Abuse scoring of the writes: step-up verification, and review by the operators
+	if app.config.abuse.enabled {
+		v1.HandlerFunc(
+			http.MethodPost,
+			"/users/verification",
+			app.requireActivatedUser(app.verifyUserHandler),
+		)
+		v1.HandlerFunc(
+			http.MethodGet,
+			"/admin/abuse",
+			app.requireOperator(app.listAbusersHandler),
+		)
+		v1.HandlerFunc(
+			http.MethodDelete,
+			"/admin/abuse/:uuid",
+			app.requireOperator(app.requireUUIDParam(app.resetAbuserHandler)),
+		)
+	}
+
+	// Database backups to S3, for the operators
+	if app.config.backups.enabled {
+		v1.HandlerFunc(
+			http.MethodGet,
+			"/admin/backups",
+			app.requireOperator(app.listBackupsHandler),
+		)
+		v1.HandlerFunc(
+			http.MethodPost,
+			"/admin/backups",
+			app.requireOperator(app.createBackupHandler),
+		)
+	}
+
+	return v1
+}
+
+// segmentRoute returns the handler of a POST /{resource}/:uuid route, serving the routes
+// keyed by their last path segment: httprouter does not allow a static path segment next
+// to the :uuid wildcard of the other routes of the resource.
+func (app *application) segmentRoute(routes map[string]http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next, ok := routes[httprouter.ParamsFromContext(r.Context()).ByName("uuid")]
+		if !ok {
+			app.methodNotAllowedResponse(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// v2Routes returns the routes of the second version of the API. It inherits every v1
+// route, breaking changes are made by replacing or removing the affected routes here so
+// that v1 clients keep working unchanged.
+func (app *application) v2Routes(v1 *routeSet) *routeSet {
+	v2 := v1.clone()
 
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	return v2
 }
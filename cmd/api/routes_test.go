@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+// TestRoutes builds the routes with every optional route enabled, httprouter panicking
+// on conflicting routes.
+func TestRoutes(t *testing.T) {
+	defer func() {
+		if err := recover(); err != nil {
+			t.Fatalf("routes: %v", err)
+		}
+	}()
+
+	var app application
+	app.config.env = "development"
+	app.config.abuse.enabled = true
+	app.config.backups.enabled = true
+	app.limiter = &clientLimiter{}
+	app.routes()
+}
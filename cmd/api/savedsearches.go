@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/liuminhaw/yatijapp/internal/mailer"
+	"github.com/liuminhaw/yatijapp/internal/tokenizer"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// savedSearchBatchSize is the number of saved searches evaluated on each run, the ones
+// left are evaluated on the next runs.
+const savedSearchBatchSize = 200
+
+// errPrivateAddress is returned when a webhook resolves to an address of the internal
+// network.
+var errPrivateAddress = errors.New("webhook address is not public")
+
+// webhookClient posts the new results of the saved searches to the webhooks set by the
+// users. It only connects to public addresses, so that the webhooks cannot reach the
+// internal network of the deployment, and does not follow redirects.
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				addrPort, err := netip.ParseAddrPort(address)
+				if err != nil {
+					return err
+				}
+				addr := addrPort.Addr().Unmap()
+				if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+					return errPrivateAddress
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// savedSearchResult is a record found by a saved search, as sent to its user.
+type savedSearchResult struct {
+	UUID    uuid.UUID     `json:"uuid"`
+	Title   string        `json:"title"`
+	Status  data.Status   `json:"status"`
+	DueDate data.NullTime `json:"due_date"`
+}
+
+// savedSearchDelivery holds the new results of a saved search to send to its user, along
+// with all the results recorded once they are sent.
+type savedSearchDelivery struct {
+	run     data.SavedSearchRun
+	results []uuid.UUID
+	fresh   []savedSearchResult
+}
+
+// startSavedSearchRoutine periodically evaluates the saved searches and sends their new
+// results.
+func (app *application) startSavedSearchRoutine() {
+	app.logger.Info("Saved search routine started")
+
+	ticker := time.NewTicker(app.config.savedSearches.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		app.background(func() {
+			app.runScheduled(jobSavedSearches, app.config.savedSearches.interval, func() {
+				app.evaluateSavedSearches(context.Background())
+			})
+		})
+	}
+}
+
+// evaluateSavedSearches evaluates the next batch of saved searches not evaluated within
+// the interval, and sends the new results by email or to the webhooks. The results are
+// only recorded once sent, so that a failed delivery is retried on the next run. The first
+// evaluation of a search records its results without sending them.
+func (app *application) evaluateSavedSearches(ctx context.Context) {
+	runs, err := app.models.SavedSearches.Due(
+		ctx,
+		app.config.savedSearches.interval,
+		savedSearchBatchSize,
+	)
+	if err != nil {
+		app.logger.Error("Error listing due saved searches: " + err.Error())
+		return
+	}
+	if len(runs) == 0 {
+		return
+	}
+
+	now := time.Now()
+	emails := []savedSearchDelivery{}
+	delivered := 0
+	for _, run := range runs {
+		found, err := app.savedSearchResults(ctx, &run, now)
+		if err != nil {
+			app.logger.Error("Error evaluating saved search: "+err.Error(), "uuid", run.UUID)
+			continue
+		}
+
+		results := make([]uuid.UUID, len(found))
+		for i, result := range found {
+			results[i] = result.UUID
+		}
+		fresh, err := app.models.SavedSearches.NewResults(ctx, &run.SavedSearch, results)
+		if err != nil {
+			app.logger.Error("Error comparing saved search results: " + err.Error())
+			continue
+		}
+
+		delivery := savedSearchDelivery{run: run, results: results}
+		if run.EvaluatedAt.Valid {
+			isFresh := make(map[uuid.UUID]bool, len(fresh))
+			for _, id := range fresh {
+				isFresh[id] = true
+			}
+			for _, result := range found {
+				if isFresh[result.UUID] {
+					delivery.fresh = append(delivery.fresh, result)
+				}
+			}
+		}
+
+		switch {
+		case len(delivery.fresh) == 0:
+			app.savedSearchEvaluated(ctx, delivery)
+		case run.Delivery == data.DeliveryWebhook:
+			if err := app.postSavedSearchResults(ctx, delivery, now); err != nil {
+				app.logger.Error(
+					"Error posting saved search results: "+err.Error(),
+					"uuid", run.UUID,
+				)
+				continue
+			}
+			app.savedSearchEvaluated(ctx, delivery)
+			delivered++
+		default:
+			emails = append(emails, delivery)
+		}
+	}
+
+	messages := make([]mailer.BatchMessage, len(emails))
+	for i, delivery := range emails {
+		messages[i] = app.savedSearchMessage(ctx, delivery)
+	}
+	errs := app.mailer.SendBatch(ctx, messages, app.config.smtp.batchConcurrency)
+	for i, delivery := range emails {
+		if errs[i] != nil {
+			app.logger.Error(errs[i].Error())
+			continue
+		}
+		app.savedSearchEvaluated(ctx, delivery)
+		delivered++
+	}
+
+	app.logger.Info(
+		"Saved searches evaluated",
+		slog.Int("evaluated", len(runs)),
+		slog.Int("delivered", delivered),
+	)
+}
+
+// savedSearchResults runs the saved search for its user, the most relevant results
+// first.
+func (app *application) savedSearchResults(
+	ctx context.Context,
+	run *data.SavedSearchRun,
+	now time.Time,
+) ([]savedSearchResult, error) {
+	filters := run.Filters(now)
+	results := []savedSearchResult{}
+
+	switch run.ResourceType {
+	case "target":
+		t := tokenizer.New(run.Search, app.models.Targets.Segmenter)
+		targets, _, err := app.models.Targets.GetAllForUser(ctx, *t, filters, run.UserUUID)
+		if err != nil {
+			return nil, err
+		}
+		for _, target := range targets {
+			results = append(results, savedSearchResult{
+				UUID:    target.UUID,
+				Title:   target.Title,
+				Status:  target.Status,
+				DueDate: target.DueDate.In(target.DueTimezone),
+			})
+		}
+	case "action":
+		t := tokenizer.New(run.Search, app.models.Actions.Segmenter)
+		actions, _, err := app.models.Actions.GetAll(
+			ctx,
+			*t,
+			filters,
+			uuid.NullUUID{},
+			run.UserUUID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for _, action := range actions {
+			results = append(results, savedSearchResult{
+				UUID:    action.UUID,
+				Title:   action.Title,
+				Status:  action.Status,
+				DueDate: action.DueDate.In(action.DueTimezone),
+			})
+		}
+	default:
+		return nil, fmt.Errorf("saved search: unknown resource type %q", run.ResourceType)
+	}
+
+	return results, nil
+}
+
+// savedSearchEvaluated records the results of the evaluation of a saved search.
+func (app *application) savedSearchEvaluated(ctx context.Context, delivery savedSearchDelivery) {
+	err := app.models.SavedSearches.Evaluated(
+		ctx,
+		&delivery.run.SavedSearch,
+		delivery.results,
+		len(delivery.fresh) > 0,
+	)
+	if err != nil {
+		app.logger.Error("Error recording saved search results: " + err.Error())
+	}
+}
+
+// savedSearchMessage returns the email digest of the new results of a saved search.
+func (app *application) savedSearchMessage(
+	ctx context.Context,
+	delivery savedSearchDelivery,
+) mailer.BatchMessage {
+	prefs, err := app.reportPreferences(ctx, delivery.run.UserUUID)
+	if err != nil {
+		app.logger.Error("Error loading report preferences: " + err.Error())
+	}
+
+	records := make([]map[string]string, len(delivery.fresh))
+	for i, result := range delivery.fresh {
+		records[i] = map[string]string{
+			"title":  result.Title,
+			"status": string(result.Status),
+		}
+		if result.DueDate.Valid {
+			records[i]["dueDate"] = prefs.FormatDate(result.DueDate.Time)
+		}
+	}
+
+	return mailer.BatchMessage{
+		Recipient:    delivery.run.Email,
+		TemplateFile: "saved_search.tmpl",
+		Data: map[string]any{
+			"username": delivery.run.Username,
+			"name":     delivery.run.Name,
+			"type":     delivery.run.ResourceType,
+			"records":  records,
+		},
+	}
+}
+
+// postSavedSearchResults posts the new results of a saved search to its webhook as JSON.
+func (app *application) postSavedSearchResults(
+	ctx context.Context,
+	delivery savedSearchDelivery,
+	now time.Time,
+) error {
+	body, err := json.Marshal(map[string]any{
+		"saved_search": map[string]any{
+			"uuid": delivery.run.UUID,
+			"name": delivery.run.Name,
+		},
+		"resource_type": delivery.run.ResourceType,
+		"results":       delivery.fresh,
+		"evaluated_at":  now.UTC().Truncate(time.Second),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		delivery.run.WebhookURL,
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "yatijapp-saved-search/"+version)
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+func (app *application) listSavedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	searches, err := app.models.SavedSearches.GetAllForUser(r.Context(), user.UUID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"saved_searches": searches}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createSavedSearchHandler saves a search the user is notified of the new results of,
+// by email unless a webhook is asked for.
+func (app *application) createSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name          string        `json:"name"`
+		ResourceType  string        `json:"resource_type"`
+		Search        string        `json:"search"`
+		Status        []data.Status `json:"status"`
+		Role          []string      `json:"role"`
+		HasNotes      *bool         `json:"has_notes"`
+		DueWithinDays *int          `json:"due_within_days"`
+		Delivery      string        `json:"delivery"`
+		WebhookURL    string        `json:"webhook_url"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	search := data.SavedSearch{
+		Name:          input.Name,
+		ResourceType:  input.ResourceType,
+		Search:        input.Search,
+		Status:        input.Status,
+		Role:          input.Role,
+		HasNotes:      input.HasNotes,
+		DueWithinDays: input.DueWithinDays,
+		Delivery:      input.Delivery,
+		WebhookURL:    input.WebhookURL,
+	}
+	if search.Status == nil {
+		search.Status = []data.Status{}
+	}
+	if search.Role == nil {
+		search.Role = []string{}
+	}
+	if search.Delivery == "" {
+		search.Delivery = data.DeliveryEmail
+	}
+
+	v := validator.New()
+	if data.ValidateSavedSearch(v, &search); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	err = app.models.SavedSearches.Insert(
+		r.Context(),
+		&search,
+		user.UUID,
+		app.config.savedSearches.maxPerUser,
+	)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", app.apiPath(r, "/saved-searches/%s", search.UUID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"saved_search": search}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+
+	user := app.contextGetUser(r)
+	search, err := app.models.SavedSearches.Get(r.Context(), id, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"saved_search": search}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateSavedSearchHandler renames a saved search or changes its delivery. Its criteria
+// cannot change, a new saved search is created instead.
+func (app *application) updateSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+
+	user := app.contextGetUser(r)
+	search, err := app.models.SavedSearches.Get(r.Context(), id, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Name       *string `json:"name"`
+		Delivery   *string `json:"delivery"`
+		WebhookURL *string `json:"webhook_url"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		search.Name = *input.Name
+	}
+	if input.Delivery != nil {
+		search.Delivery = *input.Delivery
+		if search.Delivery == data.DeliveryEmail && input.WebhookURL == nil {
+			search.WebhookURL = ""
+		}
+	}
+	if input.WebhookURL != nil {
+		search.WebhookURL = *input.WebhookURL
+	}
+
+	v := validator.New()
+	if data.ValidateSavedSearch(v, search); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.models.SavedSearches.Update(r.Context(), search, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"saved_search": search}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+
+	user := app.contextGetUser(r)
+	err := app.models.SavedSearches.Delete(r.Context(), id, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(
+		w,
+		http.StatusOK,
+		envelope{"message": "saved search successfully deleted"},
+		nil,
+	)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Scheduled jobs, run once per interval by a single instance of the cluster.
+const (
+	jobCleanup       = "cleanup"
+	jobArchive       = "archive"
+	jobUnactivated   = "unactivated"
+	jobRetention     = "retention"
+	jobReminders     = "reminders"
+	jobPartitions    = "partitions"
+	jobSavedSearches = "saved_searches"
+	jobReports       = "report_schedules"
+)
+
+// instanceName identifies this instance in the claims of the scheduled jobs.
+var instanceName = func() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s/%d", host, os.Getpid())
+}()
+
+// runScheduled runs the job of a scheduler routine, unless another instance already ran
+// it within the interval or is still running it, so that the routines of the instances
+// do not fire the same work twice.
+func (app *application) runScheduled(job string, interval time.Duration, fn func()) {
+	ran, err := app.models.RunJob(context.Background(), job, interval, instanceName, fn)
+	if err != nil {
+		app.logger.Error("Error running scheduled job", "job", job, "error", err.Error())
+		return
+	}
+	if !ran {
+		app.logger.Debug("Scheduled job skipped, run by another instance", "job", job)
+	}
+}
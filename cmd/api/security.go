@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/tomasen/realip"
+)
+
+// Security events, logged on the security channel.
+const (
+	eventLogin          = "auth.login"
+	eventTokenRefresh   = "auth.token_refresh"
+	eventInvalidToken   = "auth.invalid_token"
+	eventPasswordChange = "user.password_change"
+	eventAccessDenied   = "access.denied"
+	eventACLGrant       = "acl.grant"
+	eventACLChange      = "acl.change"
+	eventACLInvite      = "acl.invite"
+	eventNewSignIn      = "auth.new_sign_in"
+	eventIdentityLink   = "auth.identity_link"
+	eventSessionRevoke  = "auth.session_revoke"
+	eventTokenThrottled = "auth.token_throttled"
+	eventAbuseStepUp    = "abuse.step_up"
+	eventAbuseFreeze    = "abuse.freeze"
+	eventAbuseVerify    = "abuse.verify"
+	eventAbuseReset     = "abuse.reset"
+)
+
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+)
+
+// securityEventQueueSize is the number of events waiting to be shipped to the
+// webhook, events are dropped once the queue is full.
+const securityEventQueueSize = 1024
+
+type securityEvent struct {
+	Time     time.Time      `json:"time"`
+	Event    string         `json:"event"`
+	Outcome  string         `json:"outcome"`
+	UserUUID uuid.NullUUID  `json:"user_uuid"`
+	IP       string         `json:"ip"`
+	Method   string         `json:"method"`
+	Path     string         `json:"path"`
+	Details  map[string]any `json:"details,omitzero"`
+}
+
+// securityLog writes security events to a dedicated log channel, so that they can be
+// queried apart from the application logs, and optionally ships them to a SIEM
+// through a webhook.
+type securityLog struct {
+	logger  *slog.Logger
+	webhook string
+	client  *http.Client
+	queue   chan securityEvent
+	dropped atomic.Int64
+	failed  atomic.Int64
+}
+
+func newSecurityLog(logger *slog.Logger, webhook string, timeout time.Duration) *securityLog {
+	s := &securityLog{
+		logger:  logger.With(slog.String("channel", "security")),
+		webhook: webhook,
+	}
+	if webhook != "" {
+		s.client = &http.Client{Timeout: timeout}
+		s.queue = make(chan securityEvent, securityEventQueueSize)
+	}
+
+	return s
+}
+
+func (s *securityLog) record(e securityEvent) {
+	attrs := []any{
+		slog.String("event", e.Event),
+		slog.String("outcome", e.Outcome),
+		slog.String("ip", e.IP),
+		slog.String("method", e.Method),
+		slog.String("path", e.Path),
+	}
+	if e.UserUUID.Valid {
+		attrs = append(attrs, slog.String("user_uuid", e.UserUUID.UUID.String()))
+	}
+	if len(e.Details) > 0 {
+		attrs = append(attrs, slog.Any("details", e.Details))
+	}
+	s.logger.Info("security event", attrs...)
+
+	if s.queue == nil {
+		return
+	}
+	select {
+	case s.queue <- e:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// ship posts the queued events to the webhook until the queue is closed.
+func (s *securityLog) ship() {
+	if s.queue == nil {
+		return
+	}
+
+	for e := range s.queue {
+		if err := s.post(e); err != nil {
+			s.failed.Add(1)
+			s.logger.Error("security event not shipped", slog.String("error", err.Error()))
+		}
+	}
+}
+
+func (s *securityLog) post(e securityEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.client.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// stats returns the webhook statistics in a form suitable for publishing through
+// expvar.
+func (s *securityLog) stats() any {
+	return map[string]any{
+		"webhook": s.webhook != "",
+		"queued":  len(s.queue),
+		"dropped": s.dropped.Load(),
+		"failed":  s.failed.Load(),
+	}
+}
+
+// securityEvent records a security event caused by the request. The user UUID may be
+// uuid.Nil when the user is unknown, details are given as key value pairs.
+func (app *application) securityEvent(
+	r *http.Request,
+	event, outcome string,
+	userUUID uuid.UUID,
+	details ...any,
+) {
+	e := securityEvent{
+		Time:     time.Now().UTC(),
+		Event:    event,
+		Outcome:  outcome,
+		UserUUID: uuid.NullUUID{UUID: userUUID, Valid: userUUID != uuid.Nil},
+		IP:       realip.FromRequest(r),
+		Method:   r.Method,
+		Path:     r.URL.Path,
+	}
+
+	if len(details) > 0 {
+		e.Details = make(map[string]any, len(details)/2)
+		for i := 0; i+1 < len(details); i += 2 {
+			e.Details[fmt.Sprint(details[i])] = details[i+1]
+		}
+	}
+
+	app.security.record(e)
+}
+
+// notifyNewSignIn records the device a user signed in from and, when it was never seen
+// before, emails the user a link revoking the new session. It is meant to run in the
+// background once the authentication tokens have been issued.
+func (app *application) notifyNewSignIn(
+	device data.Device,
+	user *data.User,
+	sessionUUID uuid.UUID,
+) {
+	ctx := context.Background()
+
+	isNew, err := app.models.Devices.Record(ctx, &device)
+	if err != nil {
+		app.logger.Error("Error recording sign-in device: " + err.Error())
+		return
+	}
+	if !isNew {
+		return
+	}
+
+	token, err := app.models.Tokens.New(
+		ctx,
+		user.UUID,
+		sessionUUID,
+		app.config.tokens.sessionRevocationTokenTTL,
+		data.ScopeSessionRevocation,
+	)
+	if err != nil {
+		app.logger.Error("Error creating session revocation token: " + err.Error())
+		return
+	}
+
+	country := device.Country
+	if country == "" {
+		country = "unknown"
+	}
+	mailData := map[string]any{
+		"username":    user.Name,
+		"time":        device.LastSeen.UTC().Format(time.RFC1123),
+		"ip":          device.IP,
+		"country":     country,
+		"userAgent":   device.UserAgent,
+		"revokeToken": token.Plaintext,
+	}
+	if base := app.config.security.newSignIn.revokeURL; base != "" {
+		mailData["revokeURL"] = base + "?token=" + url.QueryEscape(token.Plaintext)
+	}
+
+	err = app.mailer.Send(user.Email, "new_sign_in.tmpl", mailData)
+	if err != nil {
+		app.logger.Error(err.Error())
+	}
+}
@@ -3,23 +3,44 @@ package main
 import (
 	"context"
 	"errors"
-	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+
+	"github.com/liuminhaw/yatijapp/internal/systemd"
 )
 
 // serve initialize and starts the HTTP server.
 func (app *application) serve() error {
+	// Leave room for the longest route budget to complete and write its response.
+	writeTimeout := max(10*time.Second, app.config.timeouts.export+5*time.Second)
+
+	// Prefer the sockets inherited from systemd, which keeps accepting connections
+	// on behalf of the service while it restarts.
+	listeners, err := systemd.Listeners()
+	if err != nil {
+		return err
+	}
+	addrs := make([]string, 0, len(listeners))
+	for _, l := range listeners {
+		addrs = append(addrs, l.Addr().String())
+	}
+	if len(listeners) == 0 {
+		addrs = app.listenAddrs()
+		listeners, err = openListeners(addrs)
+		if err != nil {
+			return err
+		}
+	}
+
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", app.config.port),
 		Handler:      app.routes(),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		WriteTimeout: writeTimeout,
 		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
 	}
 
@@ -31,6 +52,7 @@ func (app *application) serve() error {
 		// Read signal from the quit channel. This code will block until a signal is received.
 		s := <-quit
 		app.logger.Info("shutting down server", "signal", s.String())
+		app.notifyServiceManager("STOPPING=1")
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
@@ -40,18 +62,35 @@ func (app *application) serve() error {
 			shutdownError <- err
 		}
 
-		app.logger.Info("completing background tasks", "addr", srv.Addr)
+		app.logger.Info("completing background tasks", "addrs", addrs)
 		app.wg.Wait()
 		shutdownError <- nil
 	}()
 
-	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env, "version", version)
+	app.logger.Info("starting server", "addrs", addrs, "env", app.config.env, "version", version)
 
-	err := srv.ListenAndServe()
-	// Calling Shutdown() on the server will cause ListenAndServe() to return to
-	// return a http.ErrServerClosed error, which we can check for.
-	if !errors.Is(err, http.ErrServerClosed) {
-		return err
+	// Serve every listener with the same server, so that a single Shutdown() call
+	// stops all of them.
+	serveError := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			serveError <- srv.Serve(l)
+		}()
+	}
+
+	// The database has been reached and every listener is open, the service manager
+	// can start routing traffic to this process.
+	app.notifyServiceManager("READY=1")
+
+	// Calling Shutdown() on the server will cause Serve() to return a
+	// http.ErrServerClosed error, which we can check for. Any other error means one
+	// of the listeners failed, in which case the whole server is closed.
+	for range listeners {
+		err := <-serveError
+		if !errors.Is(err, http.ErrServerClosed) {
+			srv.Close()
+			return err
+		}
 	}
 
 	err = <-shutdownError
@@ -59,7 +98,20 @@ func (app *application) serve() error {
 		return err
 	}
 
-	app.logger.Info("stopped server", "addr", srv.Addr)
+	app.logger.Info("stopped server", "addrs", addrs)
 
 	return nil
 }
+
+// notifyServiceManager sends a state notification to systemd when the server is run
+// as a notify type service.
+func (app *application) notifyServiceManager(state string) {
+	sent, err := systemd.Notify(state)
+	if err != nil {
+		app.logger.Warn("failed to notify service manager", "state", state, "error", err.Error())
+		return
+	}
+	if sent {
+		app.logger.Info("notified service manager", "state", state)
+	}
+}
@@ -1,9 +1,7 @@
 package main
 
 import (
-	"database/sql"
-	"errors"
-	"fmt"
+	"context"
 	"net/http"
 	"time"
 
@@ -15,10 +13,12 @@ import (
 
 func (app *application) createSessionHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		StartsAt   time.Time    `json:"starts_at"`
-		EndsAt     sql.NullTime `json:"ends_at"`
-		Notes      string       `json:"notes"`
-		ActionUUID uuid.UUID    `json:"action_uuid"`
+		StartsAt   time.Time     `json:"starts_at"`
+		EndsAt     data.NullTime `json:"ends_at"`
+		Notes      string        `json:"notes"`
+		ActionUUID uuid.NullUUID `json:"action_uuid"`
+		TargetUUID uuid.NullUUID `json:"target_uuid"`
+		Source     string        `json:"source"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -32,11 +32,32 @@ func (app *application) createSessionHandler(w http.ResponseWriter, r *http.Requ
 		EndsAt:     input.EndsAt,
 		Notes:      input.Notes,
 		ActionUUID: input.ActionUUID,
+		TargetUUID: input.TargetUUID,
+		Source:     input.Source,
 	}
 
 	v := validator.New()
+	v.CheckField(
+		!input.ActionUUID.Valid || !input.TargetUUID.Valid,
+		"target_uuid",
+		validator.Invalid("must not be provided along with action_uuid"),
+	)
+
+	// The sessions created with an API key are recorded as such whatever the source the
+	// client claims, so that automated integrations can be told apart from tracked time.
+	// Otherwise a session left running is started by a timer.
+	switch {
+	case app.exemptions.apiKey(r):
+		session.Source = data.SourceAPIKey
+	case input.Source == data.SourceAPIKey:
+		v.AddFieldError("source", validator.NotPermitted("requires an API key"))
+	case input.Source == "" && !input.EndsAt.Valid:
+		session.Source = data.SourceTimer
+	case input.Source == "":
+		session.Source = data.SourceManual
+	}
 	if data.ValidateSession(v, &session); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
@@ -46,83 +67,104 @@ func (app *application) createSessionHandler(w http.ResponseWriter, r *http.Requ
 		UsageDate: time.Now().UTC(),
 		Resource:  "session",
 		Limit:     app.config.user.dailySessionsCreationLimit,
+		Exempt:    app.quotaExempt(r, user),
+	}
+	if app.validateOnly(w, r, &quota, app.sessionParentAccess(&session, user)) {
+		return
+	}
+	if !app.checkAbuse(w, r, user, "session", "") {
+		return
 	}
 
-	err = app.models.CreateSession(&session, &quota, user.UUID)
+	err = app.models.CreateSession(r.Context(), &session, &quota, user.UUID)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		case errors.Is(err, data.ErrQuotaExceeded):
-			msg := fmt.Sprintf(
-				"session creation quota reached (%d per day, renew on midnight UTC)",
-				quota.Limit,
-			)
-			app.quotaExceededResponse(w, r, msg)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.dataErrorResponse(w, r, err)
 		return
 	}
+	kpiSessionsStarted.Add(1)
+
+	app.securityEvent(
+		r,
+		eventACLGrant,
+		outcomeSuccess,
+		user.UUID,
+		"resource_type", "session",
+		"resource_uuid", session.UUID,
+		"role", "owner",
+	)
 
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/sessions/%s", session.UUID))
+	headers.Set("Location", app.apiPath(r, "/sessions/%s", session.UUID))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"session": session}, headers)
+	env := envelope{"session": newSessionResponse(&session, true)}
+	err = app.writeJSON(w, http.StatusCreated, env, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// sessionParentAccess returns the check that the user may edit the action, or else the
+// target, the session belongs to. Sessions without a parent need no access.
+func (app *application) sessionParentAccess(
+	session *data.Session,
+	user *data.User,
+) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		var err error
+		switch {
+		case session.ActionUUID.Valid:
+			_, err = app.models.Actions.Get(ctx, session.ActionUUID.UUID, user.UUID, "editor")
+		case session.TargetUUID.Valid:
+			_, err = app.models.Targets.Get(ctx, session.TargetUUID.UUID, user.UUID, "editor")
+		}
+		return err
+	}
+}
+
 func (app *application) showSessionHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readUUIDParam(r)
+	id := app.contextGetUUIDParam(r)
+
+	user := app.contextGetUser(r)
+	session, err := app.models.Sessions.Get(r.Context(), id, user.UUID, "viewer")
 	if err != nil {
-		app.notFoundResponse(w, r)
+		app.dataErrorResponse(w, r, err)
 		return
 	}
 
-	user := app.contextGetUser(r)
-	session, err := app.models.Sessions.Get(id, user.UUID, "viewer")
+	res := newSessionResponse(session, true)
+	cv, err := newResourceValidators(res, session.UpdatedAt)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if cv.notModified(r) {
+		app.notModifiedResponse(w, cv)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"session": session}, nil)
+	env := envelope{"session": res}
+	err = app.writeJSON(w, http.StatusOK, env, cv.headers())
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) updateSessionHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readUUIDParam(r)
-	if err != nil {
-		app.notFoundResponse(w, r)
-		return
-	}
+	id := app.contextGetUUIDParam(r)
 
 	user := app.contextGetUser(r)
-	session, err := app.models.Sessions.Get(id, user.UUID, "editor")
+	session, err := app.models.Sessions.Get(r.Context(), id, user.UUID, "editor")
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.dataErrorResponse(w, r, err)
 		return
 	}
 
 	var input struct {
-		StartsAt   *time.Time    `json:"starts_at"`
-		EndsAt     *sql.NullTime `json:"ends_at"`
-		Notes      *string       `json:"notes"`
-		ActionUUID *uuid.UUID    `json:"action_uuid,omitzero"`
+		StartsAt   *time.Time     `json:"starts_at"`
+		EndsAt     *data.NullTime `json:"ends_at"`
+		Notes      *string        `json:"notes"`
+		ActionUUID *uuid.UUID     `json:"action_uuid,omitzero"`
+		TargetUUID *uuid.UUID     `json:"target_uuid,omitzero"`
 	}
 	err = app.readJSON(w, r, &input)
 	if err != nil {
@@ -139,55 +181,69 @@ func (app *application) updateSessionHandler(w http.ResponseWriter, r *http.Requ
 	if input.Notes != nil {
 		session.Notes = *input.Notes
 	}
+	// Moving a session to an action, or to a target, detaches it from its previous
+	// parent, which is how quick track sessions get classified.
+	if input.ActionUUID != nil || input.TargetUUID != nil {
+		session.ActionUUID = uuid.NullUUID{}
+		session.TargetUUID = uuid.NullUUID{}
+	}
 	if input.ActionUUID != nil {
-		session.ActionUUID = *input.ActionUUID
+		session.ActionUUID = uuid.NullUUID{UUID: *input.ActionUUID, Valid: true}
+	}
+	if input.TargetUUID != nil {
+		session.TargetUUID = uuid.NullUUID{UUID: *input.TargetUUID, Valid: true}
 	}
 
 	v := validator.New()
+	v.CheckField(
+		input.ActionUUID == nil || input.TargetUUID == nil,
+		"target_uuid",
+		validator.Invalid("must not be provided along with action_uuid"),
+	)
 	if data.ValidateSession(v, session); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+	if app.validateOnly(w, r, nil, app.sessionParentAccess(session, user)) {
 		return
 	}
 
-	fts := data.GenFTS("", "", session.Notes, app.models.Sessions.Jieba)
+	fts := data.GenFTS(
+		"",
+		"",
+		app.models.Sessions.Cipher.Searchable(session.Notes),
+		app.models.Sessions.Segmenter,
+	)
 
-	err = app.models.Sessions.Update(session, fts, user.UUID)
+	err = app.models.Sessions.Update(r.Context(), session, fts, user.UUID)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.dataErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"session": session}, nil)
+	env := envelope{"session": newSessionResponse(session, true)}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readUUIDParam(r)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
-		return
-	}
+	id := app.contextGetUUIDParam(r)
 
 	user := app.contextGetUser(r)
-	err = app.models.Sessions.Delete(id, user.UUID)
+	undo := app.undoOperation("session")
+	err := app.models.DeleteSession(r.Context(), id, user.UUID, app.quotaRefund("session"), undo)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.dataErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session successfully deleted"}, nil)
+	env := envelope{"message": "session successfully deleted"}
+	if undo != nil {
+		env["undo"] = undo
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -202,29 +258,55 @@ func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Reque
 	v := validator.New()
 
 	qs := r.URL.Query()
-	statuses := app.readCSV(qs, "status", []string{})
+	statuses := app.readCSV(qs, "status", []string{}, v)
 
 	input.search = app.readString(qs, "search", "")
 	input.Filters.Status = data.StringSliceToStatusSlice(statuses)
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "-starts_at")
+	input.Filters.Source = app.readCSV(qs, "source", []string{}, v)
+	input.Filters.Role = app.readRoles(qs, v)
+	if unclassified := app.readBool(qs, "unclassified", v); unclassified != nil {
+		input.Filters.Unclassified = *unclassified
+	}
 
 	input.Filters.SortSafelist = data.SessionSortSafelist
 	input.Filters.StatusSafelist = data.SessionStatusSafelist
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	t := tokenizer.New(input.search, app.models.Sessions.Jieba)
+	t := tokenizer.New(input.search, app.models.Sessions.Segmenter)
 
 	user := app.contextGetUser(r)
+	fp, err := app.models.Sessions.Fingerprint(
+		r.Context(),
+		*t,
+		input.Filters,
+		uuid.NullUUID{Valid: false},
+		uuid.NullUUID{Valid: false},
+		user.UUID,
+	)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	cv := newCollectionValidators(r, fp)
+	if cv.notModified(r) {
+		app.notModifiedResponse(w, cv)
+		return
+	}
+
 	sessions, metadata, err := app.models.Sessions.GetAll(
+		r.Context(),
 		*t,
 		input.Filters,
 		uuid.NullUUID{Valid: false},
+		uuid.NullUUID{Valid: false},
 		user.UUID,
 	)
 	if err != nil {
@@ -233,8 +315,15 @@ func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	metadata.Filters.Search = input.search
+	metadata.Links = app.pageLinks(r, metadata)
+	setLinkHeader(w, metadata.Links)
+
 	err = app.writeJSON(
-		w, http.StatusOK, envelope{"sessions": sessions, "metadata": metadata}, nil,
+		w,
+		http.StatusOK,
+		envelope{"sessions": newSessionsResponse(sessions), "metadata": metadata},
+		cv.headers(),
 	)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
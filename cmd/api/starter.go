@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"golang.org/x/text/language"
+)
+
+// starterContent returns the guided target created for the users activating their
+// account, in their language.
+func starterContent(lang language.Tag) *data.StarterContent {
+	return &data.StarterContent{
+		Target: data.Target{
+			Title: localize(lang, "Getting started"),
+			Description: localize(
+				lang,
+				"A tour of targets, actions and sessions, complete or delete it when done",
+			),
+			Notes: localize(
+				lang,
+				"Targets are the goals you work towards. Break them down into actions, and "+
+					"track the time spent on each action with sessions.",
+			),
+			Status: data.StatusInProgress,
+		},
+		Actions: []data.Action{
+			{
+				Title:       localize(lang, "Explore this target"),
+				Description: localize(lang, "Open the actions and sessions of this target"),
+				Status:      data.StatusInProgress,
+			},
+			{
+				Title: localize(lang, "Create your first target"),
+				Description: localize(
+					lang,
+					"Add a goal of your own, with a due date if it has one",
+				),
+				Status: data.StatusQueued,
+			},
+			{
+				Title: localize(lang, "Track a session"),
+				Description: localize(
+					lang,
+					"Start a session when you work on an action, stop it when done",
+				),
+				Status: data.StatusQueued,
+			},
+		},
+		Session: data.Session{
+			Notes: localize(
+				lang,
+				"A sample session. Sessions record the time spent on an action, with your notes.",
+			),
+		},
+	}
+}
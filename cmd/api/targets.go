@@ -1,8 +1,6 @@
 package main
 
 import (
-	"database/sql"
-	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -17,6 +15,7 @@ import (
 func (app *application) createTargetHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		DueDate     data.InputDate `json:"due_date"`
+		DueTimezone string         `json:"due_timezone"`
 		Title       string         `json:"title"`
 		Description string         `json:"description"`
 		Notes       string         `json:"notes"`
@@ -30,7 +29,8 @@ func (app *application) createTargetHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	target := data.Target{
-		DueDate:     sql.NullTime(input.DueDate),
+		DueDate:     input.DueDate.In(input.DueTimezone).NullTime(),
+		DueTimezone: input.DueTimezone,
 		Title:       strings.TrimSpace(input.Title),
 		Description: strings.TrimSpace(input.Description),
 		Notes:       input.Notes,
@@ -40,7 +40,7 @@ func (app *application) createTargetHandler(w http.ResponseWriter, r *http.Reque
 	// Input validation
 	v := validator.New()
 	if data.ValidateTarget(v, &target, "create"); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
@@ -50,72 +50,123 @@ func (app *application) createTargetHandler(w http.ResponseWriter, r *http.Reque
 		UsageDate: time.Now().UTC(),
 		Resource:  "target",
 		Limit:     app.config.user.dailyTargetsCreationLimit,
+		Exempt:    app.quotaExempt(r, user),
+	}
+	if app.validateOnly(w, r, &quota, nil) {
+		return
+	}
+	if !app.checkAbuse(w, r, user, "target", target.Title) {
+		return
 	}
 
-	err = app.models.CreateTarget(&target, &quota, user.UUID)
+	err = app.models.CreateTarget(r.Context(), &target, &quota, user.UUID)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrQuotaExceeded):
-			msg := fmt.Sprintf(
-				"target creation quota reached (%d per day, renew on midnight UTC)",
-				quota.Limit,
-			)
-			app.quotaExceededResponse(w, r, msg)
-		}
-		app.serverErrorResponse(w, r, err)
+		app.dataErrorResponse(w, r, err)
 		return
 	}
 
+	app.securityEvent(
+		r,
+		eventACLGrant,
+		outcomeSuccess,
+		user.UUID,
+		"resource_type", "target",
+		"resource_uuid", target.UUID,
+		"role", "owner",
+	)
+
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/targets/%s", target.UUID))
+	headers.Set("Location", app.apiPath(r, "/targets/%s", target.UUID))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"target": target}, headers)
+	env := envelope{"target": newTargetResponse(&target, true)}
+	err = app.writeJSON(w, http.StatusCreated, env, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) showTargetHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readUUIDParam(r)
+	id := app.contextGetUUIDParam(r)
+
+	user := app.contextGetUser(r)
+	target, err := app.models.Targets.Get(r.Context(), id, user.UUID, "viewer")
 	if err != nil {
-		app.notFoundResponse(w, r)
+		app.dataErrorResponse(w, r, err)
 		return
 	}
 
-	user := app.contextGetUser(r)
-	target, err := app.models.Targets.Get(id, user.UUID, "viewer")
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
+	// Checking that a target can be opened, with a HEAD request, is not viewing it.
+	if app.config.sharing.viewReceipts && r.Method != http.MethodHead {
+		err = app.models.ACLs.RecordTargetView(r.Context(), target.UUID, user.UUID)
+		if err != nil {
+			app.logger.Error("Error recording target view: " + err.Error())
 		}
+	}
+
+	res := newTargetResponse(target, true)
+	cv, err := newResourceValidators(res, target.UpdatedAt)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if cv.notModified(r) {
+		app.notModifiedResponse(w, cv)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"target": target}, nil)
+	env := envelope{"target": res}
+	err = app.writeJSON(w, http.StatusOK, env, cv.headers())
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) updateTargetHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readUUIDParam(r)
+// Forecasts take the velocity over the last defaultForecastWeeks weeks, up to
+// maxForecastWeeks.
+const (
+	defaultForecastWeeks = 4
+	maxForecastWeeks     = 12
+)
+
+// showTargetForecastHandler returns the estimated time left on the open actions of a
+// target, and when they would be completed at the pace actions of the target were
+// completed lately. The weeks query string parameter sets how many weeks back the pace
+// is taken over.
+func (app *application) showTargetForecastHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	weeks := app.readInt(r.URL.Query(), "weeks", defaultForecastWeeks, v)
+	v.CheckField(
+		weeks >= 1 && weeks <= maxForecastWeeks,
+		"weeks",
+		validator.Invalid(fmt.Sprintf("must be between 1 and %d", maxForecastWeeks)),
+	)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	id := app.contextGetUUIDParam(r)
+	user := app.contextGetUser(r)
+
+	forecast, err := app.models.Targets.Forecast(r.Context(), id, user.UUID, weeks)
 	if err != nil {
-		app.notFoundResponse(w, r)
+		app.dataErrorResponse(w, r, err)
 		return
 	}
 
+	err = app.writeJSON(w, http.StatusOK, envelope{"forecast": forecast}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateTargetHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+
 	user := app.contextGetUser(r)
-	target, err := app.models.Targets.Get(id, user.UUID, "editor")
+	target, err := app.models.Targets.Get(r.Context(), id, user.UUID, "editor")
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.dataErrorResponse(w, r, err)
 		return
 	}
 
@@ -124,6 +175,7 @@ func (app *application) updateTargetHandler(w http.ResponseWriter, r *http.Reque
 		Description *string         `json:"description"`
 		Notes       *string         `json:"notes"`
 		DueDate     *data.InputDate `json:"due_date"`
+		DueTimezone *string         `json:"due_timezone"`
 		Status      *data.Status    `json:"status"`
 	}
 	err = app.readJSON(w, r, &input)
@@ -141,63 +193,66 @@ func (app *application) updateTargetHandler(w http.ResponseWriter, r *http.Reque
 	if input.Notes != nil {
 		target.Notes = *input.Notes
 	}
+	if input.DueTimezone != nil {
+		target.DueTimezone = *input.DueTimezone
+	}
 	if input.DueDate != nil {
-		target.DueDate = sql.NullTime(*input.DueDate)
+		target.DueDate = input.DueDate.In(target.DueTimezone).NullTime()
 	}
+	completing := false
 	if input.Status != nil {
+		completing = target.Status != data.StatusComplete && *input.Status == data.StatusComplete
 		target.Status = *input.Status
 	}
 
 	v := validator.New()
 	if data.ValidateTarget(v, target, "update"); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+	if app.validateOnly(w, r, nil, nil) {
 		return
 	}
 
 	fts := data.GenFTS(
 		target.Title,
 		target.Description,
-		target.Notes,
-		app.models.Targets.Jieba,
+		app.models.Targets.Cipher.Searchable(target.Notes),
+		app.models.Targets.Segmenter,
 	)
 
-	err = app.models.Targets.Update(target, fts, user.UUID)
+	err = app.models.Targets.Update(r.Context(), target, fts, user.UUID)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.dataErrorResponse(w, r, err)
 		return
 	}
+	if completing {
+		app.promptTargetReview(r, target, user)
+	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"target": target}, nil)
+	env := envelope{"target": newTargetResponse(target, true)}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) deleteTargetHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readUUIDParam(r)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
-		return
-	}
+	id := app.contextGetUUIDParam(r)
 
 	user := app.contextGetUser(r)
-	err = app.models.Targets.Delete(id, user.UUID)
+	undo := app.undoOperation("target")
+	err := app.models.DeleteTarget(r.Context(), id, user.UUID, app.quotaRefund("target"), undo)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.dataErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "target successfully deleted"}, nil)
+	env := envelope{"message": "target successfully deleted"}
+	if undo != nil {
+		env["undo"] = undo
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -212,44 +267,73 @@ func (app *application) listTargetsHandler(w http.ResponseWriter, r *http.Reques
 	v := validator.New()
 
 	qs := r.URL.Query()
-	statuses := app.readCSV(qs, "status", []string{})
+	statuses := app.readCSV(qs, "status", []string{}, v)
 
 	input.Search = app.readString(qs, "search", "")
 	input.Filters.Status = data.StringSliceToStatusSlice(statuses)
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Filters.Sort = app.readString(qs, "sort", "-last_active")
+	input.Filters.Sort = app.readString(qs, "sort", defaultRecordSort(input.Search))
+	input.Filters.DueFrom = app.readDate(qs, "due_from", v)
+	input.Filters.DueTo = app.readDate(qs, "due_to", v)
+	input.Filters.HasNotes = app.readBool(qs, "has_notes", v)
+	input.Filters.Role = app.readRoles(qs, v)
+	if includeArchived := app.readBool(qs, "include_archived", v); includeArchived != nil {
+		input.Filters.IncludeArchived = *includeArchived
+	}
 
 	input.Filters.SortSafelist = data.SortSafelist
 	input.Filters.StatusSafelist = data.StatusFilterSafelist
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	t := tokenizer.New(input.Search, app.models.Targets.Jieba)
+	t := tokenizer.New(input.Search, app.models.Targets.Segmenter)
 
 	user := app.contextGetUser(r)
-	targets, metadata, err := app.models.Targets.GetAllForUser(*t, input.Filters, user.UUID)
+	fp, err := app.models.Targets.Fingerprint(r.Context(), *t, input.Filters, user.UUID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"targets": targets, "metadata": metadata}, nil)
+	cv := newCollectionValidators(r, fp)
+	if cv.notModified(r) {
+		app.notModifiedResponse(w, cv)
+		return
+	}
+
+	targets, metadata, err := app.models.Targets.GetAllForUser(
+		r.Context(),
+		*t,
+		input.Filters,
+		user.UUID,
+	)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-}
 
-func (app *application) listTargetActionsHandler(w http.ResponseWriter, r *http.Request) {
-	targetUUID, err := app.readUUIDParam(r)
+	metadata.Filters.Search = input.Search
+	metadata.Links = app.pageLinks(r, metadata)
+	setLinkHeader(w, metadata.Links)
+
+	err = app.writeJSON(
+		w,
+		http.StatusOK,
+		envelope{"targets": newTargetsResponse(targets), "metadata": metadata},
+		cv.headers(),
+	)
 	if err != nil {
-		app.notFoundResponse(w, r)
+		app.serverErrorResponse(w, r, err)
 		return
 	}
+}
+
+func (app *application) listTargetActionsHandler(w http.ResponseWriter, r *http.Request) {
+	targetUUID := app.contextGetUUIDParam(r)
 
 	var input struct {
 		search  string
@@ -259,27 +343,134 @@ func (app *application) listTargetActionsHandler(w http.ResponseWriter, r *http.
 	v := validator.New()
 
 	qs := r.URL.Query()
-	statuses := app.readCSV(qs, "status", []string{})
+	statuses := app.readCSV(qs, "status", []string{}, v)
 
 	input.search = app.readString(r.URL.Query(), "search", "")
 	input.Filters.Status = data.StringSliceToStatusSlice(statuses)
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Filters.Sort = app.readString(qs, "sort", "-last_active")
+	input.Filters.Sort = app.readString(qs, "sort", defaultRecordSort(input.search))
+	input.Filters.DueFrom = app.readDate(qs, "due_from", v)
+	input.Filters.DueTo = app.readDate(qs, "due_to", v)
+	input.Filters.HasNotes = app.readBool(qs, "has_notes", v)
+	input.Filters.Role = app.readRoles(qs, v)
+	if includeArchived := app.readBool(qs, "include_archived", v); includeArchived != nil {
+		input.Filters.IncludeArchived = *includeArchived
+	}
 	input.Filters.SortSafelist = data.SortSafelist
 	input.Filters.StatusSafelist = data.StatusFilterSafelist
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	t := tokenizer.New(input.search, app.models.Actions.Jieba)
+	t := tokenizer.New(input.search, app.models.Actions.Segmenter)
 
 	user := app.contextGetUser(r)
+	fp, err := app.models.Actions.Fingerprint(
+		r.Context(),
+		*t,
+		input.Filters,
+		uuid.NullUUID{Valid: true, UUID: targetUUID},
+		user.UUID,
+	)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	cv := newCollectionValidators(r, fp)
+	if cv.notModified(r) {
+		app.notModifiedResponse(w, cv)
+		return
+	}
+
 	actions, metadata, err := app.models.Actions.GetAll(
+		r.Context(),
+		*t,
+		input.Filters,
+		uuid.NullUUID{Valid: true, UUID: targetUUID},
+		user.UUID,
+	)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	metadata.Filters.Search = input.search
+	metadata.Links = app.pageLinks(r, metadata)
+	setLinkHeader(w, metadata.Links)
+
+	err = app.writeJSON(
+		w,
+		http.StatusOK,
+		envelope{"actions": newActionsResponse(actions), "metadata": metadata},
+		cv.headers(),
+	)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// listTargetSessionsHandler lists the sessions logged under every action of a target.
+func (app *application) listTargetSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	targetUUID := app.contextGetUUIDParam(r)
+
+	var input struct {
+		search  string
+		Filters data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+	statuses := app.readCSV(qs, "status", []string{}, v)
+
+	input.search = app.readString(qs, "search", "")
+	input.Filters.Status = data.StringSliceToStatusSlice(statuses)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-starts_at")
+	input.Filters.Source = app.readCSV(qs, "source", []string{}, v)
+	input.Filters.Role = app.readRoles(qs, v)
+
+	input.Filters.SortSafelist = data.SessionSortSafelist
+	input.Filters.StatusSafelist = data.SessionStatusSafelist
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	t := tokenizer.New(input.search, app.models.Sessions.Segmenter)
+
+	user := app.contextGetUser(r)
+	fp, err := app.models.Sessions.Fingerprint(
+		r.Context(),
+		*t,
+		input.Filters,
+		uuid.NullUUID{Valid: false},
+		uuid.NullUUID{Valid: true, UUID: targetUUID},
+		user.UUID,
+	)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	cv := newCollectionValidators(r, fp)
+	if cv.notModified(r) {
+		app.notModifiedResponse(w, cv)
+		return
+	}
+
+	sessions, metadata, err := app.models.Sessions.GetAll(
+		r.Context(),
 		*t,
 		input.Filters,
+		uuid.NullUUID{Valid: false},
 		uuid.NullUUID{Valid: true, UUID: targetUUID},
 		user.UUID,
 	)
@@ -288,14 +479,124 @@ func (app *application) listTargetActionsHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
+	metadata.Filters.Search = input.search
+	metadata.Links = app.pageLinks(r, metadata)
+	setLinkHeader(w, metadata.Links)
+
+	err = app.writeJSON(
+		w,
+		http.StatusOK,
+		envelope{"sessions": newSessionsResponse(sessions), "metadata": metadata},
+		cv.headers(),
+	)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// reassignTargetActionsHandler moves every action of a target to another target.
+func (app *application) reassignTargetActionsHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+
+	var input struct {
+		TargetUUID uuid.UUID `json:"target_uuid"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.CheckField(input.TargetUUID != uuid.Nil, "target_uuid", validator.Required())
+	v.CheckField(input.TargetUUID != id, "target_uuid", validator.Invalid("must be another target"))
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	moved, err := app.models.Actions.Reassign(r.Context(), id, input.TargetUUID, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	app.securityEvent(
+		r,
+		eventACLChange,
+		outcomeSuccess,
+		user.UUID,
+		"resource_type", "action",
+		"from_target_uuid", id,
+		"to_target_uuid", input.TargetUUID,
+		"count", moved,
+	)
+
 	err = app.writeJSON(
 		w,
 		http.StatusOK,
-		envelope{"actions": actions, "metadata": metadata},
+		envelope{"reassigned": moved, "target_uuid": input.TargetUUID},
 		nil,
 	)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateTargetRateHandler sets the default hourly rate of a target, a decimal amount of
+// an ISO 4217 currency such as {"rate": "85.50", "currency": "EUR"}. A null rate makes
+// the target not billable.
+func (app *application) updateTargetRateHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+
+	var input struct {
+		Rate     *string `json:"rate"`
+		Currency string  `json:"currency"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
 		return
 	}
+
+	var rate *data.TargetRate
+	v := validator.New()
+	if input.Rate != nil {
+		rate = &data.TargetRate{Currency: input.Currency}
+		if data.ValidateTargetRate(v, rate); v.Valid() {
+			rate.Amount, err = data.ParseAmount(*input.Rate, input.Currency)
+			v.CheckField(
+				err == nil,
+				"rate",
+				validator.InvalidFormat("must be a positive decimal amount of the currency"),
+			)
+		}
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	err = app.models.Targets.UpdateRate(r.Context(), id, rate, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	target, err := app.models.Targets.Get(r.Context(), id, user.UUID, "editor")
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"target": newTargetResponse(target, true)}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
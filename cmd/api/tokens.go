@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"time"
@@ -8,19 +9,23 @@ import (
 	"github.com/gofrs/uuid/v5"
 	"github.com/liuminhaw/yatijapp/internal/data"
 	"github.com/liuminhaw/yatijapp/internal/validator"
+	"github.com/tomasen/realip"
 )
 
 type AuthenticationToken struct {
 	AccessToken  string    `json:"access_token"`
 	RefreshToken string    `json:"refresh_token"`
 	SessionUUID  uuid.UUID `json:"session_id"`
+	UserUUID     uuid.UUID `json:"-"`
 }
 
 func (app *application) generateAuthenticationToken(
+	ctx context.Context,
 	userUUID, sessionUUID uuid.UUID,
 	accessTokenTTL, refreshTokenTTL time.Duration,
 ) (AuthenticationToken, error) {
 	accessToken, err := app.models.Tokens.New(
+		ctx,
 		userUUID,
 		sessionUUID,
 		accessTokenTTL,
@@ -30,6 +35,7 @@ func (app *application) generateAuthenticationToken(
 		return AuthenticationToken{}, err
 	}
 	refreshToken, err := app.models.Tokens.New(
+		ctx,
 		userUUID,
 		sessionUUID,
 		refreshTokenTTL,
@@ -43,20 +49,23 @@ func (app *application) generateAuthenticationToken(
 		AccessToken:  accessToken.Plaintext,
 		RefreshToken: refreshToken.Plaintext,
 		SessionUUID:  sessionUUID,
+		UserUUID:     userUUID,
 	}, nil
 }
 
 func (app *application) renewAuthenticationToken(
+	ctx context.Context,
 	token string,
 	accessTokenTTL, refreshTokenTTL time.Duration,
 ) (AuthenticationToken, error) {
-	currToken, err := app.models.Tokens.Get(token, data.ScopeRefresh)
+	currToken, err := app.models.Tokens.Get(ctx, token, data.ScopeRefresh)
 	if err != nil {
 		return AuthenticationToken{}, err
 	}
 
 	sessionUUID := currToken.SessionUUID
 	return app.generateAuthenticationToken(
+		ctx,
 		currToken.UserUUID,
 		sessionUUID,
 		accessTokenTTL,
@@ -79,16 +88,19 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 
 	v := validator.New()
 	if data.ValidateEmail(v, input.Email); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("email", "no matching email address found")
-			app.failedValidationResponse(w, r, v.Errors)
+			v.AddFieldError(
+				"email",
+				validator.NewFieldError(validator.CodeNotFound, "no matching email address found"),
+			)
+			app.failedValidationResponse(w, r, v)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -96,12 +108,20 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 	}
 
 	if user.Activated {
-		v.AddError("email", "user is already activated")
-		app.failedValidationResponse(w, r, v.Errors)
+		v.AddFieldError(
+			"email",
+			validator.NewFieldError(validator.CodeAlreadyActivated, "user is already activated"),
+		)
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	if !app.allowTokenEmail(w, r, user, data.ScopeActivation) {
 		return
 	}
 
 	token, err := app.models.Tokens.New(
+		r.Context(),
 		user.UUID,
 		uuid.Nil,
 		app.config.tokens.activationTokenTTL,
@@ -147,14 +167,15 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	data.ValidateEmail(v, input.Email)
 	data.ValidatePasswordPlaintext(v, input.Password)
 	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			app.securityEvent(r, eventLogin, outcomeFailure, uuid.Nil, "reason", "unknown email")
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -168,6 +189,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 	if !match {
+		app.securityEvent(r, eventLogin, outcomeFailure, user.UUID, "reason", "wrong password")
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
@@ -179,6 +201,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 	token, err := app.generateAuthenticationToken(
+		r.Context(),
 		user.UUID,
 		sessionUUID,
 		app.config.tokens.accessTokenTTL,
@@ -189,9 +212,23 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{
-		"authentication_token": token,
-	}, nil)
+	app.securityEvent(r, eventLogin, outcomeSuccess, user.UUID, "session_uuid", sessionUUID)
+
+	if app.config.security.newSignIn.enabled {
+		device := data.Device{
+			UserUUID:  user.UUID,
+			IP:        realip.FromRequest(r),
+			UserAgent: r.UserAgent(),
+		}
+		if header := app.config.security.newSignIn.countryHeader; header != "" {
+			device.Country = r.Header.Get(header)
+		}
+		app.background(func() {
+			app.notifyNewSignIn(device, user, sessionUUID)
+		})
+	}
+
+	err = app.writeAuthenticationToken(w, r, token)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -201,19 +238,34 @@ func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter,
 	var input struct {
 		RefreshToken string `json:"refresh_token"`
 	}
+
+	// Browser clients using the cookie mode send the refresh token as a cookie, and may
+	// send no body at all.
+	cookie, cookieErr := r.Cookie(refreshTokenCookie)
+	withCookie := cookieErr == nil && app.cookieMode(r)
+
 	err := app.readJSON(w, r, &input)
-	if err != nil {
+	if err != nil && !(errors.Is(err, errEmptyBody) && withCookie) {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
+	if input.RefreshToken == "" && withCookie {
+		if !validCSRF(r) {
+			app.invalidCSRFTokenResponse(w, r)
+			return
+		}
+		input.RefreshToken = cookie.Value
+	}
+
 	v := validator.New()
 	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
 	token, err := app.renewAuthenticationToken(
+		r.Context(),
 		input.RefreshToken,
 		app.config.tokens.accessTokenTTL,
 		app.config.tokens.refreshTokenTTL,
@@ -221,9 +273,17 @@ func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter,
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.logger.Info("refresh token not found: " + input.RefreshToken)
-			v.AddError("token", "invalid or expired refresh token")
-			app.failedValidationResponse(w, r, v.Errors)
+			app.securityEvent(
+				r, eventTokenRefresh, outcomeFailure, uuid.Nil, "reason", "unknown token",
+			)
+			v.AddFieldError(
+				"token",
+				validator.NewFieldError(
+					validator.CodeInvalidToken,
+					"invalid or expired refresh token",
+				),
+			)
+			app.failedValidationResponse(w, r, v)
 		default:
 			app.logger.Error("failed to renew authentication token: " + err.Error())
 			app.serverErrorResponse(w, r, err)
@@ -231,17 +291,70 @@ func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	if err := app.models.Tokens.Delete(input.RefreshToken, data.ScopeRefresh); err != nil {
+	if err := app.models.Tokens.Delete(
+		r.Context(),
+		input.RefreshToken,
+		data.ScopeRefresh,
+	); err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{
-		"authentication_token": token,
-	}, nil)
+	app.securityEvent(
+		r,
+		eventTokenRefresh,
+		outcomeSuccess,
+		token.UserUUID,
+		"session_uuid",
+		token.SessionUUID,
+	)
+
+	err = app.writeAuthenticationToken(w, r, token)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// tokenLimits returns the limits on the tokens of the scope sent by email. The limits
+// apply per account, on top of the IP rate limiter, so that a victim's inbox cannot be
+// flooded from many addresses.
+func (app *application) tokenLimits(scope string) data.TokenLimits {
+	limits := data.TokenLimits{
+		Limit:  app.config.tokens.emailLimit,
+		Window: app.config.tokens.emailWindow,
+	}
+	if scope == data.ScopeActivation {
+		limits.Cooldown = app.config.tokens.activationCooldown
+	}
+
+	return limits
+}
+
+// allowTokenEmail records a token of the scope being emailed to the user, or sends a
+// too many requests response with the remaining wait when the limits are reached.
+func (app *application) allowTokenEmail(
+	w http.ResponseWriter,
+	r *http.Request,
+	user *data.User,
+	scope string,
+) bool {
+	retryAfter, err := app.models.Tokens.Throttle(
+		r.Context(),
+		user.UUID,
+		scope,
+		app.tokenLimits(scope),
+	)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return false
 	}
+	if retryAfter > 0 {
+		app.securityEvent(r, eventTokenThrottled, outcomeFailure, user.UUID, "scope", scope)
+		app.tokenThrottledResponse(w, r, retryAfter)
+		return false
+	}
+
+	return true
 }
 
 // createPasswordResetTokenHandler generates a new password reset token for a user and
@@ -258,16 +371,19 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 
 	v := validator.New()
 	if data.ValidateEmail(v, input.Email); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("email", "no matching email address found")
-			app.failedValidationResponse(w, r, v.Errors)
+			v.AddFieldError(
+				"email",
+				validator.NewFieldError(validator.CodeNotFound, "no matching email address found"),
+			)
+			app.failedValidationResponse(w, r, v)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -275,12 +391,20 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 	}
 
 	if !user.Activated {
-		v.AddError("email", "user account must be activated")
-		app.failedValidationResponse(w, r, v.Errors)
+		v.AddFieldError(
+			"email",
+			validator.NewFieldError(validator.CodeNotActivated, "user account must be activated"),
+		)
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	if !app.allowTokenEmail(w, r, user, data.ScopePasswordReset) {
 		return
 	}
 
 	token, err := app.models.Tokens.New(
+		r.Context(),
 		user.UUID,
 		uuid.Nil,
 		app.config.tokens.passwordResetTokenTTL,
@@ -313,20 +437,86 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 }
 
 func (app *application) deleteTokenSessionHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readUUIDParam(r)
+	id := app.contextGetUUIDParam(r)
+	user := app.contextGetUser(r)
+
+	err := app.models.Tokens.DeleteAllForUserSession(r.Context(), user.UUID, id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Signing out the session of a browser client also clears its cookies.
+	if app.cookies != nil {
+		if _, err := r.Cookie(accessTokenCookie); err == nil {
+			app.clearAuthCookies(w, r)
+		}
+	}
+
+	env := envelope{"message": "session data successfully deleted"}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeTokenSessionHandler signs out the session a revocation token was sent for,
+// allowing users to revoke a sign-in from a new device straight from the notification
+// email without being authenticated.
+func (app *application) revokeTokenSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token string `json:"token"`
+	}
+	err := app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
-	user := app.contextGetUser(r)
 
-	err = app.models.Tokens.DeleteAllForUserSession(user.UUID, id)
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.Token); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	token, err := app.models.Tokens.Get(r.Context(), input.Token, data.ScopeSessionRevocation)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.securityEvent(
+				r, eventSessionRevoke, outcomeFailure, uuid.Nil, "reason", "unknown token",
+			)
+			v.AddFieldError(
+				"token",
+				validator.NewFieldError(
+					validator.CodeInvalidToken,
+					"invalid or expired session revocation token",
+				),
+			)
+			app.failedValidationResponse(w, r, v)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The revocation token belongs to the session, it is deleted along with it.
+	err = app.models.Tokens.DeleteAllForUserSession(r.Context(), token.UserUUID, token.SessionUUID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	env := envelope{"message": "session data successfully deleted"}
+	app.securityEvent(
+		r,
+		eventSessionRevoke,
+		outcomeSuccess,
+		token.UserUUID,
+		"session_uuid",
+		token.SessionUUID,
+	)
+
+	env := envelope{"message": "session successfully revoked"}
 	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
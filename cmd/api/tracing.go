@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/trace"
+)
+
+// requestIDHeader carries the identifier of a request, echoed in the response and the
+// logs so that a client report can be matched with the logs.
+const requestIDHeader = "X-Request-Id"
+
+// rxRequestID matches the request identifiers accepted from the clients or proxies.
+var rxRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// tracedResponseWriter gives the handlers access to the trace of the request, to record
+// the time spent serializing the response.
+type tracedResponseWriter struct {
+	http.ResponseWriter
+	trace *trace.Trace
+}
+
+func (tw *tracedResponseWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// traceOf returns the trace of the request the response is written for, nil when the
+// request is not traced.
+func traceOf(w http.ResponseWriter) *trace.Trace {
+	for {
+		switch rw := w.(type) {
+		case *tracedResponseWriter:
+			return rw.trace
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// traceRequests identifies every request and, when the slow request threshold is set,
+// times its steps: the database queries, the handler and the serialization of the
+// response. Requests slower than the threshold are logged with the breakdown of their
+// time, the rest of the time being spent in the middlewares.
+func (app *application) traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !rxRequestID.MatchString(id) {
+			id = uuid.Must(uuid.NewV7()).String()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		threshold := app.config.timeouts.slowRequest
+		if threshold <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		t := trace.New(id)
+		tw := &tracedResponseWriter{ResponseWriter: w, trace: t}
+		next.ServeHTTP(tw, r.WithContext(trace.NewContext(r.Context(), t)))
+
+		if elapsed := time.Since(t.Start); elapsed >= threshold {
+			app.logSlowRequest(r, t, elapsed)
+		}
+	})
+}
+
+// traceHandler times the handler of the route, once past the middlewares.
+func (app *application) traceHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		trace.FromContext(r.Context()).Record("handler", r.Method+" "+r.URL.Path, start)
+	})
+}
+
+// logSlowRequest logs the breakdown of the time of a slow request at the WARN level,
+// each database query with its offset from the start of the request.
+func (app *application) logSlowRequest(r *http.Request, t *trace.Trace, elapsed time.Duration) {
+	handler := t.Total("handler")
+
+	attrs := []any{
+		slog.String("request_id", t.ID),
+		slog.String("method", r.Method),
+		slog.String("url", r.URL.RequestURI()),
+		slog.Duration("duration", elapsed),
+		slog.Duration("middleware", elapsed-handler),
+		slog.Duration("handler", handler),
+		slog.Duration("serialization", t.Total("serialization")),
+		slog.Duration("db", t.Total("db")),
+		slog.Int("db_queries", t.Count("db")),
+	}
+
+	n := 0
+	for _, span := range t.Spans() {
+		if span.Kind != "db" {
+			continue
+		}
+		attrs = append(attrs, slog.Group(
+			fmt.Sprintf("query_%d", n),
+			slog.Duration("offset", span.Offset),
+			slog.Duration("duration", span.Duration),
+			slog.String("sql", span.Name),
+		))
+		n++
+	}
+
+	app.logger.Warn("slow request", attrs...)
+}
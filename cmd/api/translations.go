@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/liuminhaw/yatijapp/internal/validator"
+	"golang.org/x/text/language"
+)
+
+// translations registers the translations of the human-readable messages of the
+// responses, keyed by their English template. Templates refer to their params as {name},
+// and resource names are translated through the "resource.<name>" entries. Messages
+// missing here, or missing a language, are sent in English. The languages are the ones
+// of the validation messages.
+var translations = map[string]map[language.Tag]string{
+	"the requested resource could not be found": {
+		language.TraditionalChinese: "找不到要求的資源",
+		language.SimplifiedChinese:  "找不到请求的资源",
+	},
+	"unable to update the record due to an edit conflict, please try again": {
+		language.TraditionalChinese: "資料已被其他人修改，無法更新，請再試一次",
+		language.SimplifiedChinese:  "数据已被其他人修改，无法更新，请再试一次",
+	},
+	"creation quota reached, renew on midnight UTC": {
+		language.TraditionalChinese: "已達建立數量上限，於 UTC 午夜重置",
+		language.SimplifiedChinese:  "已达创建数量上限，于 UTC 午夜重置",
+	},
+	"limit reached, delete some of the records before creating new ones": {
+		language.TraditionalChinese: "已達數量上限，請先刪除部分資料再建立新的",
+		language.SimplifiedChinese:  "已达数量上限，请先删除部分数据再创建新的",
+	},
+	"{resource} creation quota reached ({limit} per day, renew on midnight UTC)": {
+		language.TraditionalChinese: "已達{resource}建立數量上限（每日 {limit} 個，於 UTC 午夜重置）",
+		language.SimplifiedChinese:  "已达{resource}创建数量上限（每日 {limit} 个，于 UTC 午夜重置）",
+	},
+	"the request could not be completed within the {budget} time budget, please try again": {
+		language.TraditionalChinese: "請求無法在 {budget} 的時間內完成，請再試一次",
+		language.SimplifiedChinese:  "请求无法在 {budget} 的时间内完成，请再试一次",
+	},
+	"the service is temporarily unavailable, please try again later": {
+		language.TraditionalChinese: "服務暫時無法使用，請稍後再試",
+		language.SimplifiedChinese:  "服务暂时无法使用，请稍后再试",
+	},
+	"too many emails requested for this account, please try again later": {
+		language.TraditionalChinese: "此帳號要求寄送的郵件過多，請稍後再試",
+		language.SimplifiedChinese:  "此账号请求发送的邮件过多，请稍后再试",
+	},
+	"the {method} method is not supported for this resource": {
+		language.TraditionalChinese: "此資源不支援 {method} 方法",
+		language.SimplifiedChinese:  "此资源不支持 {method} 方法",
+	},
+	"rate limit exceeded": {
+		language.TraditionalChinese: "請求過於頻繁",
+		language.SimplifiedChinese:  "请求过于频繁",
+	},
+	"access from your network address is not allowed": {
+		language.TraditionalChinese: "不允許從您的網路位址存取",
+		language.SimplifiedChinese:  "不允许从您的网络地址访问",
+	},
+	"unusual activity detected, please verify your password to continue": {
+		language.TraditionalChinese: "偵測到異常活動，請驗證密碼後繼續",
+		language.SimplifiedChinese:  "检测到异常活动，请验证密码后继续",
+	},
+	"writes are temporarily disabled for this account, please try again later": {
+		language.TraditionalChinese: "此帳號暫時無法寫入，請稍後再試",
+		language.SimplifiedChinese:  "此账号暂时无法写入，请稍后再试",
+	},
+	"invalid authentication credentials": {
+		language.TraditionalChinese: "無效的驗證憑證",
+		language.SimplifiedChinese:  "无效的验证凭据",
+	},
+	"invalid or missing authentication token": {
+		language.TraditionalChinese: "無效或缺少驗證憑證",
+		language.SimplifiedChinese:  "无效或缺少验证令牌",
+	},
+	"missing or invalid CSRF token": {
+		language.TraditionalChinese: "缺少或無效的 CSRF 憑證",
+		language.SimplifiedChinese:  "缺少或无效的 CSRF 令牌",
+	},
+	"you must be authenticated to access this resource": {
+		language.TraditionalChinese: "必須先登入才能存取此資源",
+		language.SimplifiedChinese:  "必须先登录才能访问此资源",
+	},
+	"your user account must be activated to access this resource": {
+		language.TraditionalChinese: "使用者帳號必須先啟用才能存取此資源",
+		language.SimplifiedChinese:  "用户账号必须先激活才能访问此资源",
+	},
+	"The server encountered a problem and could not process your request": {
+		language.TraditionalChinese: "伺服器發生問題，無法處理您的請求",
+		language.SimplifiedChinese:  "服务器发生问题，无法处理您的请求",
+	},
+	"Getting started": {
+		language.TraditionalChinese: "開始使用",
+		language.SimplifiedChinese:  "开始使用",
+	},
+	"A tour of targets, actions and sessions, complete or delete it when done": {
+		language.TraditionalChinese: "目標、行動與時段的導覽，看完後可將其完成或刪除",
+		language.SimplifiedChinese:  "目标、行动与时段的导览，看完后可将其完成或删除",
+	},
+	"Targets are the goals you work towards. Break them down into actions, and " +
+		"track the time spent on each action with sessions.": {
+		language.TraditionalChinese: "目標是你努力達成的事。將目標拆分成行動，並以時段記錄每個行動所花的時間。",
+		language.SimplifiedChinese:  "目标是你努力达成的事。将目标拆分成行动，并以时段记录每个行动所花的时间。",
+	},
+	"Explore this target": {
+		language.TraditionalChinese: "瀏覽這個目標",
+		language.SimplifiedChinese:  "浏览这个目标",
+	},
+	"Open the actions and sessions of this target": {
+		language.TraditionalChinese: "查看這個目標的行動與時段",
+		language.SimplifiedChinese:  "查看这个目标的行动与时段",
+	},
+	"Create your first target": {
+		language.TraditionalChinese: "建立你的第一個目標",
+		language.SimplifiedChinese:  "创建你的第一个目标",
+	},
+	"Add a goal of your own, with a due date if it has one": {
+		language.TraditionalChinese: "加入你自己的目標，如有期限可一併設定",
+		language.SimplifiedChinese:  "加入你自己的目标，如有期限可一并设置",
+	},
+	"Track a session": {
+		language.TraditionalChinese: "記錄一個時段",
+		language.SimplifiedChinese:  "记录一个时段",
+	},
+	"Start a session when you work on an action, stop it when done": {
+		language.TraditionalChinese: "進行行動時開始一個時段，完成後將其停止",
+		language.SimplifiedChinese:  "进行行动时开始一个时段，完成后将其停止",
+	},
+	"A sample session. Sessions record the time spent on an action, with your notes.": {
+		language.TraditionalChinese: "這是一個範例時段。時段會記錄行動所花的時間與你的筆記。",
+		language.SimplifiedChinese:  "这是一个示例时段。时段会记录行动所花的时间与你的笔记。",
+	},
+	"resource.target": {
+		language.TraditionalChinese: "目標",
+		language.SimplifiedChinese:  "目标",
+	},
+	"resource.action": {
+		language.TraditionalChinese: "行動",
+		language.SimplifiedChinese:  "行动",
+	},
+	"resource.session": {
+		language.TraditionalChinese: "時段",
+		language.SimplifiedChinese:  "时段",
+	},
+}
+
+// negotiateLanguage returns the language of the messages of the response, the supported
+// one best matching the Accept-Language header of the request, and announces it in the
+// Content-Language header.
+func (app *application) negotiateLanguage(w http.ResponseWriter, r *http.Request) language.Tag {
+	lang := validator.MatchLanguage(r.Header.Get("Accept-Language"))
+
+	if w.Header().Get("Content-Language") == "" {
+		w.Header().Add("Vary", "Accept-Language")
+	}
+	w.Header().Set("Content-Language", lang.String())
+
+	return lang
+}
+
+// localize renders the English message template in the given language, with its params
+// given as name and value pairs. The "resource" param is translated as well.
+func localize(lang language.Tag, template string, params ...string) string {
+	if translated, ok := translations[template][lang]; ok {
+		template = translated
+	}
+	if len(params) == 0 {
+		return template
+	}
+
+	oldnew := make([]string, 0, len(params))
+	for i := 0; i+1 < len(params); i += 2 {
+		name, value := params[i], params[i+1]
+		if name == "resource" {
+			if resource, ok := translations["resource."+value][lang]; ok {
+				value = resource
+			}
+		}
+		oldnew = append(oldnew, "{"+name+"}", value)
+	}
+
+	return strings.NewReplacer(oldnew...).Replace(template)
+}
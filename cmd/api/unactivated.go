@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/liuminhaw/yatijapp/internal/mailer"
+)
+
+// startUnactivatedRoutine periodically reminds the users who have not activated their
+// account, and deletes the accounts still not activated at the end of the activation
+// window so that their email address can be registered again.
+func (app *application) startUnactivatedRoutine() {
+	app.logger.Info("Unactivated accounts routine started")
+
+	ticker := time.NewTicker(app.config.unactivated.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		app.logger.Info("Unactivated accounts routine triggered")
+		app.background(func() {
+			app.runScheduled(jobUnactivated, app.config.unactivated.interval, func() {
+				app.sendActivationReminders(context.Background())
+
+				deleted, err := app.models.Users.DeleteAllUnactivated(
+					context.Background(),
+					app.config.unactivated.deleteAfter,
+				)
+				if err != nil {
+					app.logger.Error("Error deleting unactivated accounts: " + err.Error())
+					return
+				}
+				app.logger.Info("Unactivated accounts deleted", slog.Int64("deleted", deleted))
+			})
+		})
+	}
+}
+
+// sendActivationReminders emails the users who registered more than remindAfter ago
+// without activating their account. Reminders are only recorded once the email has been
+// sent, so that a failed delivery is retried on the next run.
+func (app *application) sendActivationReminders(ctx context.Context) {
+	users, err := app.models.Users.PendingActivationReminders(
+		ctx,
+		app.config.unactivated.remindAfter,
+	)
+	if err != nil {
+		app.logger.Error("Error listing activation reminders: " + err.Error())
+		return
+	}
+
+	messages := make([]mailer.BatchMessage, len(users))
+	for i, user := range users {
+		deletesAt := user.CreatedAt.Add(app.config.unactivated.deleteAfter)
+		messages[i] = mailer.BatchMessage{
+			Recipient:    user.Email,
+			TemplateFile: "activation_reminder.tmpl",
+			Data: map[string]any{
+				"username":  user.Name,
+				"deletesAt": deletesAt.UTC().Format(time.DateOnly),
+			},
+		}
+	}
+
+	errs := app.mailer.SendBatch(ctx, messages, app.config.smtp.batchConcurrency)
+	for i, user := range users {
+		if errs[i] != nil {
+			app.logger.Error(errs[i].Error())
+			continue
+		}
+
+		err = app.models.Users.MarkActivationReminded(ctx, user.UUID)
+		if err != nil {
+			app.logger.Error("Error recording activation reminder: " + err.Error())
+		}
+	}
+}
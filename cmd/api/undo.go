@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+)
+
+// undoOperationHandler restores the records removed by a recent deletion of the user.
+// The operation can only be undone once, before it expires.
+func (app *application) undoOperationHandler(w http.ResponseWriter, r *http.Request) {
+	id := app.contextGetUUIDParam(r)
+
+	user := app.contextGetUser(r)
+	op, err := app.models.Undo(r.Context(), id, user.UUID)
+	if err != nil {
+		app.dataErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{
+		"message": op.ResourceType + " successfully restored",
+		"undo":    op,
+	}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
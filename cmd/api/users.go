@@ -3,7 +3,9 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"math"
 	"net/http"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/liuminhaw/yatijapp/internal/data"
@@ -19,6 +21,28 @@ func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Re
 	}
 }
 
+// showSharingReportHandler lists what the user shared with others and what others shared
+// with the user, with the roles and the last activity, for the user to review the access.
+func (app *application) showSharingReportHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	report, err := app.models.ACLs.SharingReport(r.Context(), user.UUID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !app.config.sharing.viewReceipts {
+		for _, record := range report.SharedByMe {
+			record.LastViewedAt = data.NullTime{}
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sharing_report": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Name     string `json:"name"`
@@ -45,23 +69,43 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	v := validator.New()
 	if data.ValidateUser(v, user); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	err = app.models.Users.Insert(r.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
-			v.AddError("email", "a user with this email address already exists")
-			app.failedValidationResponse(w, r, v.Errors)
+			v.AddFieldError(
+				"email",
+				validator.NewFieldError(
+					validator.CodeDuplicate,
+					"a user with this email address already exists",
+				),
+			)
+			app.failedValidationResponse(w, r, v)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
+	kpiRegistrations.Add(1)
+
+	// The welcome email counts as the first activation email, starting the cooldown.
+	_, err = app.models.Tokens.Throttle(
+		r.Context(),
+		user.UUID,
+		data.ScopeActivation,
+		app.tokenLimits(data.ScopeActivation),
+	)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
 	token, err := app.models.Tokens.New(
+		r.Context(),
 		user.UUID,
 		uuid.Nil,
 		app.config.tokens.activationTokenTTL,
@@ -90,6 +134,49 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+// showActivationStatusHandler tells when an activation email can be sent again to the
+// given address. Unknown and already activated addresses get the same answer as an
+// account allowed to resend right away, so that the endpoint does not disclose which
+// addresses are registered.
+func (app *application) showActivationStatusHandler(w http.ResponseWriter, r *http.Request) {
+	email := app.readString(r.URL.Query(), "email", "")
+
+	v := validator.New()
+	if data.ValidateEmail(v, email); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	var wait time.Duration
+	user, err := app.models.Users.GetByEmail(r.Context(), email)
+	switch {
+	case err == nil && !user.Activated:
+		wait, err = app.models.Tokens.Wait(
+			r.Context(),
+			user.UUID,
+			data.ScopeActivation,
+			app.tokenLimits(data.ScopeActivation),
+		)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	case err != nil && !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	env := envelope{"activation": map[string]any{
+		"can_resend":  seconds == 0,
+		"retry_after": seconds,
+	}}
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		TokenPlaintext string `json:"token"`
@@ -103,35 +190,50 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 
 	v := validator.New()
 	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.TokenPlaintext)
+	user, err := app.models.Users.GetForToken(
+		r.Context(),
+		data.ScopeActivation,
+		input.TokenPlaintext,
+	)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("token", "invalid or expired activation token")
-			app.failedValidationResponse(w, r, v.Errors)
+			v.AddFieldError(
+				"token",
+				validator.NewFieldError(
+					validator.CodeInvalidToken,
+					"invalid or expired activation token",
+				),
+			)
+			app.failedValidationResponse(w, r, v)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	user.Activated = true
-	err = app.models.Users.Update(user)
+	var starter *data.StarterContent
+	if app.config.starter.enabled {
+		starter = starterContent(app.negotiateLanguage(w, r))
+	}
+
+	err = app.models.ActivateUser(r.Context(), user, starter)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.dataErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.UUID)
+	kpiActivations.Add(1)
+
+	// Invitations sent to the email before the account existed are accepted now that
+	// the email is proven to belong to the user.
+	app.acceptPendingInvitations(r, user)
+
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopeActivation, user.UUID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -160,16 +262,29 @@ func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http
 	data.ValidatePasswordPlaintext(v, input.Password)
 	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
 	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	user, err := app.models.Users.GetForToken(
+		r.Context(),
+		data.ScopePasswordReset,
+		input.TokenPlaintext,
+	)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("token", "invalid or expired password reset token")
-			app.failedValidationResponse(w, r, v.Errors)
+			app.securityEvent(
+				r, eventPasswordChange, outcomeFailure, uuid.Nil, "reason", "invalid token",
+			)
+			v.AddFieldError(
+				"token",
+				validator.NewFieldError(
+					validator.CodeInvalidToken,
+					"invalid or expired password reset token",
+				),
+			)
+			app.failedValidationResponse(w, r, v)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -182,23 +297,20 @@ func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http
 		return
 	}
 
-	err = app.models.Users.Update(user)
+	err = app.models.Users.Update(r.Context(), user)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.dataErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.UUID)
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), data.ScopePasswordReset, user.UUID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	app.securityEvent(r, eventPasswordChange, outcomeSuccess, user.UUID)
+
 	env := envelope{"message": "your password was successfully updated"}
 	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
@@ -208,14 +320,9 @@ func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http
 
 func (app *application) showUserPreferencesHandler(w http.ResponseWriter, r *http.Request) {
 	user := app.contextGetUser(r)
-	preferences, err := app.models.UserPreferences.Get(user.UUID)
+	preferences, err := app.models.UserPreferences.Get(r.Context(), user.UUID)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.dataErrorResponse(w, r, err)
 		return
 	}
 
@@ -237,7 +344,7 @@ func (app *application) updateUserPreferencesHandler(w http.ResponseWriter, r *h
 
 	v := validator.New()
 	if data.ValidatePreferences(v, &input); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
@@ -247,14 +354,9 @@ func (app *application) updateUserPreferencesHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	err = app.models.UserPreferences.Put(user.UUID, inputBytes)
+	err = app.models.UserPreferences.Put(r.Context(), user.UUID, inputBytes)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.dataErrorResponse(w, r, err)
 		return
 	}
 
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/data"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// validateHandler returns the handler of POST /{resource}/validate, which runs the
+// create handler of the resource, or its update handler when the uuid query parameter
+// names a record, without writing anything. The payload gets the same validation errors
+// as on the real endpoint, the quota and access checks included, so that clients can
+// validate long forms before submitting them.
+func (app *application) validateHandler(create, update http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r = app.contextSetValidateOnly(r)
+
+		qs := r.URL.Query()
+		if !qs.Has("uuid") {
+			create(w, r)
+			return
+		}
+
+		id, err := uuid.FromString(qs.Get("uuid"))
+		if err != nil || id.Version() != uuid.V7 {
+			v := validator.New()
+			v.AddFieldError("uuid", validator.InvalidFormat("must be a valid record UUID"))
+			app.failedValidationResponse(w, r, v)
+			return
+		}
+		update(w, app.contextSetUUIDParam(r, id))
+	}
+}
+
+// validateOnly reports whether the request only validates its payload, in which case
+// the remaining checks of the request are run and their outcome is sent: the quota, when
+// not nil, then the access check. The caller must return when it reports true.
+func (app *application) validateOnly(
+	w http.ResponseWriter,
+	r *http.Request,
+	quota *data.DailyQuota,
+	access func(ctx context.Context) error,
+) bool {
+	if !app.contextValidateOnly(r) {
+		return false
+	}
+
+	user := app.contextGetUser(r)
+	if quota != nil {
+		if err := app.models.DailyQuota.Check(r.Context(), quota, user.UUID); err != nil {
+			app.dataErrorResponse(w, r, err)
+			return true
+		}
+	}
+	if access != nil {
+		if err := access(r.Context()); err != nil {
+			app.dataErrorResponse(w, r, err)
+			return true
+		}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"valid": true}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+	return true
+}
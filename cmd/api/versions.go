@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// apiVersionHeader lets clients with a fixed /v1 base URL opt in to another version of
+// the API. It is also set on responses to report the version which served the request.
+const apiVersionHeader = "Api-Version"
+
+type apiVersion string
+
+const (
+	apiV1 apiVersion = "v1"
+	apiV2 apiVersion = "v2"
+)
+
+// apiVersions lists the mounted versions, oldest first.
+var apiVersions = []apiVersion{apiV1, apiV2}
+
+type route struct {
+	method  string
+	path    string
+	handler http.Handler
+}
+
+// routeSet collects the routes of an API version, relative to its path prefix, so that
+// a version can be derived from the previous one before being mounted.
+type routeSet struct {
+	routes []route
+}
+
+func (s *routeSet) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	s.Handler(method, path, handler)
+}
+
+func (s *routeSet) Handler(method, path string, handler http.Handler) {
+	s.routes = append(s.routes, route{method: method, path: path, handler: handler})
+}
+
+func (s *routeSet) clone() *routeSet {
+	return &routeSet{routes: slices.Clone(s.routes)}
+}
+
+func (s *routeSet) index(method, path string) int {
+	i := slices.IndexFunc(s.routes, func(rt route) bool {
+		return rt.method == method && rt.path == path
+	})
+	if i < 0 {
+		// Routes are set up at startup, a missing one is a programming error.
+		panic(fmt.Sprintf("no %s %s route to change", method, path))
+	}
+
+	return i
+}
+
+// replace changes the handler of an existing route.
+func (s *routeSet) replace(method, path string, handler http.HandlerFunc) {
+	s.routes[s.index(method, path)].handler = handler
+}
+
+// remove drops an existing route.
+func (s *routeSet) remove(method, path string) {
+	i := s.index(method, path)
+	s.routes = slices.Delete(s.routes, i, i+1)
+}
+
+// mount registers the routes on the router under the version path prefix.
+func (s *routeSet) mount(router *httprouter.Router, version apiVersion) {
+	for _, rt := range s.routes {
+		path := "/" + string(version) + rt.path
+		if rt.path == "/" {
+			path = "/" + string(version)
+		}
+		router.Handler(rt.method, path, rt.handler)
+	}
+}
+
+// splitVersion splits the version prefix off a request path. The version is empty
+// when the path is not versioned.
+func splitVersion(path string) (apiVersion, string) {
+	for _, version := range apiVersions {
+		prefix := "/" + string(version)
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return version, strings.TrimPrefix(path, prefix)
+		}
+	}
+
+	return "", path
+}
+
+// negotiateVersion stores the API version of the request in its context. The version
+// is given by the path prefix, unless a request to a v1 path asks for another version
+// through the Api-Version header, in which case it is routed to that version.
+func (app *application) negotiateVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version, rest := splitVersion(r.URL.Path)
+
+		if header := r.Header.Get(apiVersionHeader); header != "" && version == apiV1 {
+			requested := apiVersion(strings.ToLower(strings.TrimSpace(header)))
+			if !strings.HasPrefix(string(requested), "v") {
+				requested = "v" + requested
+			}
+			if !slices.Contains(apiVersions, requested) {
+				app.badRequestResponse(w, r, fmt.Errorf("unsupported API version %q", header))
+				return
+			}
+
+			if requested != version {
+				version = requested
+				r = r.Clone(r.Context())
+				r.URL.Path = "/" + string(version) + rest
+				r.URL.RawPath = ""
+			}
+		}
+
+		if version != "" {
+			w.Header().Set(apiVersionHeader, string(version))
+			w.Header().Add("Vary", apiVersionHeader)
+			r = app.contextSetAPIVersion(r, version)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiPath returns the path of a resource in the API version serving the request, e.g.
+// for Location headers.
+func (app *application) apiPath(r *http.Request, format string, args ...any) string {
+	return "/" + string(app.contextGetAPIVersion(r)) + fmt.Sprintf(format, args...)
+}
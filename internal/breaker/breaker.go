@@ -0,0 +1,127 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	// Closed lets every call through while counting consecutive failures.
+	Closed State = iota
+	// Open rejects every call until the cool-down period has elapsed.
+	Open
+	// HalfOpen lets calls through to probe whether the dependency recovered, the
+	// first outcome decides whether the breaker closes or opens again.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Breaker is a consecutive-failures circuit breaker safe for concurrent use.
+type Breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     State
+	failures  int
+	openedAt  time.Time
+	trips     int64
+}
+
+// New returns a closed Breaker which opens after threshold consecutive failures and
+// stays open for the cooldown duration.
+func New(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		threshold: max(threshold, 1),
+		cooldown:  cooldown,
+	}
+}
+
+// Allow reports whether a call may proceed. An open breaker switches to half-open
+// once the cool-down period has elapsed.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && time.Since(b.openedAt) >= b.cooldown {
+		b.state = HalfOpen
+	}
+
+	return b.state != Open
+}
+
+// Success records a successful call and closes the breaker.
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.state = Closed
+}
+
+// Failure records a failed call. The breaker opens when the number of consecutive
+// failures reaches the threshold, or immediately when a half-open probe fails.
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == HalfOpen || (b.state == Closed && b.failures >= b.threshold) {
+		b.state = Open
+		b.openedAt = time.Now()
+		b.trips++
+	}
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && time.Since(b.openedAt) >= b.cooldown {
+		return HalfOpen
+	}
+
+	return b.state
+}
+
+// RetryAfter returns the remaining cool-down time of an open breaker.
+func (b *Breaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != Open {
+		return 0
+	}
+
+	return max(b.cooldown-time.Since(b.openedAt), 0)
+}
+
+// Stats returns the breaker statistics in a form suitable for publishing through
+// expvar.
+func (b *Breaker) Stats() any {
+	state := b.State()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return map[string]any{
+		"state":                state.String(),
+		"consecutive_failures": b.failures,
+		"trips":                b.trips,
+	}
+}
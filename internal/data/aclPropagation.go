@@ -0,0 +1,241 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/lib/pq"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// MaxACLPropagation is the number of actions, and of sessions, a single propagation can
+// grant access to.
+const MaxACLPropagation = 100
+
+// ACLPropagation materializes the role of a user on a target as grants on some of the
+// actions and sessions of the target, so that they keep their access to these records
+// whatever becomes of the grant on the target. The role is the one of the user on the
+// target unless another one is given.
+type ACLPropagation struct {
+	TargetUUID  uuid.UUID
+	GranteeUUID uuid.UUID
+	Role        string
+	Actions     []uuid.UUID
+	Sessions    []uuid.UUID
+}
+
+func ValidateACLPropagation(v *validator.Validator, p *ACLPropagation) {
+	v.CheckField(p.GranteeUUID != uuid.Nil, "user_uuid", validator.Required())
+	v.CheckField(
+		p.Role == "" || validator.PermittedValue(p.Role, InvitationRoleSafelist...),
+		"role",
+		validator.NotPermitted("must be one of editor, commenter, viewer"),
+	)
+	v.CheckField(
+		len(p.Actions) > 0 || len(p.Sessions) > 0,
+		"actions",
+		validator.Invalid("must be provided unless sessions are"),
+	)
+
+	records := map[string][]uuid.UUID{"actions": p.Actions, "sessions": p.Sessions}
+	for field, uuids := range records {
+		v.CheckField(
+			len(uuids) <= MaxACLPropagation,
+			field,
+			validator.TooLong(MaxACLPropagation, validator.UnitItems),
+		)
+		v.CheckField(
+			validator.Unique(uuids),
+			field,
+			validator.Invalid("must not contain duplicates"),
+		)
+		v.CheckField(
+			!validator.PermittedValue(uuid.Nil, uuids...),
+			field,
+			validator.Invalid("must only contain valid uuids"),
+		)
+	}
+}
+
+// PropagatedGrant is a role granted on a record by a propagation, replacing the old role
+// of the user on the record when they had one.
+type PropagatedGrant struct {
+	ResourceType string    `json:"resource_type"`
+	ResourceUUID uuid.UUID `json:"resource_uuid"`
+	Title        string    `json:"title"`
+	OldRole      string    `json:"old_role,omitzero"`
+	NewRole      string    `json:"new_role"`
+}
+
+// PropagateACL grants the role of the propagation on each of its records to its user,
+// and audits the grants as made by the user userUUID, who must own the target. Records
+// already granted the role, and the ones the user owns, are left as they are. With
+// dryRun, the grants are returned without being made.
+//
+// ErrRecordNotFound is returned when the target is not owned by the user userUUID, or
+// when one of the records does not belong to the target, and ErrNotShared when the
+// target is not shared with the user of the propagation.
+func (m Models) PropagateACL(
+	ctx context.Context,
+	p *ACLPropagation,
+	userUUID uuid.UUID,
+	dryRun bool,
+) ([]*PropagatedGrant, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var grants []*PropagatedGrant
+	fn := func(tx *sql.Tx) error {
+		m.ACLs.DB = m.observed(tx)
+		if err := m.ACLs.Check(ctx, "target", p.TargetUUID, userUUID, "owner"); err != nil {
+			return err
+		}
+
+		var shared string
+		err := tx.QueryRowContext(ctx, `
+			SELECT role_code FROM acls
+			WHERE user_uuid = $1 AND resource_type = 'target' AND resource_uuid = $2
+				AND role_code <> 'owner'`, p.GranteeUUID, p.TargetUUID).Scan(&shared)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNotShared
+		case err != nil:
+			return err
+		}
+
+		role := p.Role
+		if role == "" {
+			role = shared
+		}
+
+		grants, err = m.ACLs.planPropagation(ctx, p, role)
+		if err != nil || dryRun || len(grants) == 0 {
+			return err
+		}
+
+		return m.ACLs.propagate(ctx, p.GranteeUUID, userUUID, grants)
+	}
+
+	if err := m.WithTx(ctx, nil, fn); err != nil {
+		return nil, err
+	}
+
+	return grants, nil
+}
+
+// planPropagation returns the grants of the role on the records of the propagation, to
+// its user. ErrRecordNotFound is returned when one of the records does not belong to the
+// target of the propagation.
+func (m ACLModel) planPropagation(
+	ctx context.Context,
+	p *ACLPropagation,
+	role string,
+) ([]*PropagatedGrant, error) {
+	query := `
+		WITH listed (resource_type, resource_uuid, title) AS (
+			SELECT 'action'::resource_types, a.uuid, a.title
+			FROM actions a
+			WHERE a.uuid = ANY($3) AND a.target_uuid = $1
+			UNION ALL
+			SELECT 'session'::resource_types, s.uuid, COALESCE(sa.title, st.title, '')
+			FROM sessions s
+			LEFT JOIN actions sa ON sa.uuid = s.action_uuid
+			LEFT JOIN targets st ON st.uuid = s.target_uuid
+			WHERE s.uuid = ANY($4) AND COALESCE(sa.target_uuid, s.target_uuid) = $1
+		)
+		SELECT l.resource_type, l.resource_uuid, l.title, COALESCE(ac.role_code, '')
+		FROM listed l
+		LEFT JOIN acls ac ON ac.user_uuid = $2 AND ac.resource_type = l.resource_type
+			AND ac.resource_uuid = l.resource_uuid
+		ORDER BY l.resource_type, l.resource_uuid
+	`
+
+	args := []any{p.TargetUUID, p.GranteeUUID, pq.Array(p.Actions), pq.Array(p.Sessions)}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	listed := 0
+	grants := []*PropagatedGrant{}
+	for rows.Next() {
+		grant := PropagatedGrant{NewRole: role}
+		err := rows.Scan(&grant.ResourceType, &grant.ResourceUUID, &grant.Title, &grant.OldRole)
+		if err != nil {
+			return nil, err
+		}
+		listed++
+		if grant.OldRole != role && grant.OldRole != "owner" {
+			grants = append(grants, &grant)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if listed != len(p.Actions)+len(p.Sessions) {
+		return nil, ErrRecordNotFound
+	}
+
+	return grants, nil
+}
+
+// propagate makes the grants to the grantee, replacing their roles on the records, and
+// audits them as made by the actor.
+func (m ACLModel) propagate(
+	ctx context.Context,
+	granteeUUID, actorUUID uuid.UUID,
+	grants []*PropagatedGrant,
+) error {
+	query := `
+		WITH planned (resource_type, resource_uuid, old_role, new_role) AS (
+			SELECT * FROM unnest($3::resource_types[], $4::uuid[], $5::text[], $6::text[])
+		),
+		granted AS (
+			INSERT INTO acls (user_uuid, resource_type, resource_uuid, role_code)
+			SELECT $1, resource_type, resource_uuid, new_role FROM planned
+			ON CONFLICT (user_uuid, resource_type, resource_uuid) DO UPDATE
+			SET role_code = EXCLUDED.role_code
+		)
+		INSERT INTO acl_audit (
+			resource_type, resource_uuid, actor_uuid, grantee_uuid, change, old_role, new_role
+		)
+		SELECT
+			resource_type,
+			resource_uuid,
+			$2,
+			$1,
+			CASE WHEN old_role = '' THEN 'grant' ELSE 'change' END,
+			NULLIF(old_role, ''),
+			new_role
+		FROM planned
+	`
+
+	types := make([]string, len(grants))
+	uuids := make([]uuid.UUID, len(grants))
+	oldRoles := make([]string, len(grants))
+	newRoles := make([]string, len(grants))
+	for i, g := range grants {
+		types[i], uuids[i] = g.ResourceType, g.ResourceUUID
+		oldRoles[i], newRoles[i] = g.OldRole, g.NewRole
+	}
+
+	forgetACLs(ctx)
+
+	_, err := m.DB.ExecContext(
+		ctx,
+		query,
+		granteeUUID,
+		actorUUID,
+		pq.Array(types),
+		pq.Array(uuids),
+		pq.Array(oldRoles),
+		pq.Array(newRoles),
+	)
+	return err
+}
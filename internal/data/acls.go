@@ -0,0 +1,495 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// Collaborator is a user sharing a record with another user.
+type Collaborator struct {
+	UUID  uuid.UUID `json:"uuid"`
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+	// LastSeen is when the user last signed in, as recorded by the new sign-in
+	// notices, null when it was never recorded.
+	LastSeen NullTime `json:"last_seen"`
+}
+
+// SharedRecord is a record shared between the user and a collaborator: for the records
+// the user shared, the collaborator is the one it was shared with and the role is theirs;
+// for the records shared with the user, the collaborator is the owner and the role is
+// the one of the user.
+type SharedRecord struct {
+	ResourceType string       `json:"resource_type"`
+	ResourceUUID uuid.UUID    `json:"resource_uuid"`
+	Title        string       `json:"title"`
+	Role         string       `json:"role"`
+	Collaborator Collaborator `json:"collaborator"`
+	// LastActive is the last activity on the record, by any of its users.
+	LastActive time.Time `json:"last_active"`
+	// LastViewedAt is when the collaborator last viewed the target shared by the user,
+	// null for the records shared with the user.
+	LastViewedAt NullTime `json:"last_viewed_at"`
+}
+
+// SharingReport lists what a user shared with others, and what others shared with the
+// user, for the user to review and prune the access.
+type SharingReport struct {
+	SharedByMe   []*SharedRecord `json:"shared_by_me"`
+	SharedWithMe []*SharedRecord `json:"shared_with_me"`
+}
+
+type ACLModel struct {
+	DB    DBTX
+	Roles Roles
+}
+
+// sharedRecords joins the ACL of the user, aliased mine, with the ACL of the other users
+// of the same record, aliased other, and with the record, the other user and their
+// preferences. Sessions are titled after the target or action they belong to.
+const sharedRecords = `
+	FROM acls mine
+	JOIN acls other ON other.resource_type = mine.resource_type
+		AND other.resource_uuid = mine.resource_uuid
+		AND other.user_uuid <> mine.user_uuid
+	JOIN users u ON u.uuid = other.user_uuid
+	LEFT JOIN targets t ON mine.resource_type = 'target' AND t.uuid = mine.resource_uuid
+	LEFT JOIN actions a ON mine.resource_type = 'action' AND a.uuid = mine.resource_uuid
+	LEFT JOIN sessions s ON mine.resource_type = 'session' AND s.uuid = mine.resource_uuid
+	LEFT JOIN targets st ON st.uuid = s.target_uuid
+	LEFT JOIN actions sa ON sa.uuid = s.action_uuid
+	LEFT JOIN LATERAL (
+		SELECT MAX(last_seen) AS last_seen FROM login_devices WHERE user_uuid = u.uuid
+	) d ON true
+	LEFT JOIN preferences p ON p.user_uuid = other.user_uuid`
+
+const sharedRecordColumns = `
+	mine.resource_type,
+	mine.resource_uuid,
+	COALESCE(t.title, a.title, st.title, sa.title, ''),
+	%s,
+	u.uuid,
+	u.name,
+	u.email,
+	d.last_seen,
+	COALESCE(t.last_active, a.last_active, s.updated_at),
+	%s`
+
+// SharingReport returns the records the user owns and shared with others, with the role
+// of each of them, and the records others shared with the user, with their owner.
+func (m ACLModel) SharingReport(ctx context.Context, userUUID uuid.UUID) (*SharingReport, error) {
+	byMeColumns := fmt.Sprintf(sharedRecordColumns, "other.role_code", lastViewedAt("other"))
+	sharedByMe := `
+		SELECT` + byMeColumns + sharedRecords + `
+		WHERE mine.user_uuid = $1 AND mine.role_code = 'owner'
+		ORDER BY mine.resource_type, mine.resource_uuid, other.role_code, u.name
+	`
+	sharedWithMe := `
+		SELECT` + fmt.Sprintf(sharedRecordColumns, "mine.role_code", "NULL") + sharedRecords + `
+		WHERE mine.user_uuid = $1 AND mine.role_code <> 'owner'
+			AND other.role_code = 'owner'
+		ORDER BY mine.resource_type, mine.resource_uuid, u.name
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var report SharingReport
+	var err error
+	if report.SharedByMe, err = m.sharedRecords(ctx, sharedByMe, userUUID); err != nil {
+		return nil, err
+	}
+	if report.SharedWithMe, err = m.sharedRecords(ctx, sharedWithMe, userUUID); err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+func (m ACLModel) sharedRecords(
+	ctx context.Context,
+	query string,
+	userUUID uuid.UUID,
+) ([]*SharedRecord, error) {
+	rows, err := m.DB.QueryContext(ctx, query, userUUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []*SharedRecord{}
+	for rows.Next() {
+		var record SharedRecord
+		err := rows.Scan(
+			&record.ResourceType,
+			&record.ResourceUUID,
+			&record.Title,
+			&record.Role,
+			&record.Collaborator.UUID,
+			&record.Collaborator.Name,
+			&record.Collaborator.Email,
+			&record.Collaborator.LastSeen,
+			&record.LastActive,
+			&record.LastViewedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, &record)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// ResourceACL is the effective role of a user on a record. The role is granted either
+// on the record itself, or inherited from the target or action the record belongs to.
+type ResourceACL struct {
+	UserUUID  uuid.UUID `json:"user_uuid"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	Inherited bool      `json:"inherited"`
+	// GrantedOnType and GrantedOnUUID are the record the role is granted on, the
+	// record itself unless the role is inherited.
+	GrantedOnType string    `json:"granted_on_type"`
+	GrantedOnUUID uuid.UUID `json:"granted_on_uuid"`
+	// LastViewedAt is when the user last viewed the record the role is granted on, only
+	// recorded for the targets shared with them.
+	LastViewedAt NullTime `json:"last_viewed_at"`
+}
+
+// resourceChain selects the record of type $1 and UUID $2 along with the action and the
+// target it belongs to, the records whose ACLs grant access to it.
+const resourceChain = `
+	SELECT 'target'::resource_types, t.uuid
+	FROM targets t
+	WHERE $1::resource_types = 'target' AND t.uuid = $2
+	UNION ALL
+	SELECT v.resource_type, v.resource_uuid
+	FROM actions a, LATERAL (VALUES
+		('action'::resource_types, a.uuid),
+		('target'::resource_types, a.target_uuid)
+	) v (resource_type, resource_uuid)
+	WHERE $1::resource_types = 'action' AND a.uuid = $2
+	UNION ALL
+	SELECT v.resource_type, v.resource_uuid
+	FROM sessions s
+	LEFT JOIN actions a ON a.uuid = s.action_uuid, LATERAL (VALUES
+		('session'::resource_types, s.uuid),
+		('action'::resource_types, a.uuid),
+		('target'::resource_types, COALESCE(a.target_uuid, s.target_uuid))
+	) v (resource_type, resource_uuid)
+	WHERE $1::resource_types = 'session' AND s.uuid = $2
+		AND v.resource_uuid IS NOT NULL
+`
+
+// GetAllForResource returns every user with access to the given record, with their
+// effective role: the highest of the roles granted on the record and on its parent
+// target and action, a direct grant winning over an inherited one of the same role.
+// ErrRecordNotFound is returned when the record does not exist or the user has no
+// access to it.
+func (m ACLModel) GetAllForResource(
+	ctx context.Context,
+	resourceType string,
+	resourceUUID, userUUID uuid.UUID,
+) ([]*ResourceACL, error) {
+	query := `
+		WITH chain (resource_type, resource_uuid) AS (` + resourceChain + `),
+		effective AS (
+			SELECT DISTINCT ON (ac.user_uuid)
+				ac.user_uuid,
+				ac.role_code,
+				r.rank,
+				ac.resource_type,
+				ac.resource_uuid,
+				` + lastViewedAt("ac") + ` AS last_viewed_at
+			FROM acls ac
+			JOIN chain c ON c.resource_type = ac.resource_type
+				AND c.resource_uuid = ac.resource_uuid
+			JOIN roles r ON r.code = ac.role_code
+			LEFT JOIN preferences p ON p.user_uuid = ac.user_uuid
+			ORDER BY ac.user_uuid, r.rank, ac.resource_type = $1::resource_types DESC
+		)
+		SELECT
+			e.user_uuid,
+			u.name,
+			u.email,
+			e.role_code,
+			e.resource_type,
+			e.resource_uuid,
+			e.last_viewed_at
+		FROM effective e
+		JOIN users u ON u.uuid = e.user_uuid
+		WHERE EXISTS (SELECT 1 FROM effective WHERE user_uuid = $3)
+		ORDER BY e.rank, u.name
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, resourceType, resourceUUID, userUUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	acls := []*ResourceACL{}
+	for rows.Next() {
+		var acl ResourceACL
+		err := rows.Scan(
+			&acl.UserUUID,
+			&acl.Name,
+			&acl.Email,
+			&acl.Role,
+			&acl.GrantedOnType,
+			&acl.GrantedOnUUID,
+			&acl.LastViewedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		acl.Inherited = acl.GrantedOnType != resourceType || acl.GrantedOnUUID != resourceUUID
+		acls = append(acls, &acl)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The user is listed whenever they have access to the record.
+	if len(acls) == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	return acls, nil
+}
+
+// PermissionsResourceSafelist holds the resource types whose permissions can be looked up.
+var PermissionsResourceSafelist = []string{"target", "action", "session"}
+
+// Permissions is the effective role of a user on a record, the highest of the roles
+// granted on the record and on its parent target and action, and what the role lets
+// them do with the record.
+type Permissions struct {
+	ResourceType string    `json:"resource_type"`
+	ResourceUUID uuid.UUID `json:"resource_uuid"`
+	Role         string    `json:"role"`
+	Inherited    bool      `json:"inherited"`
+	CanComment   bool      `json:"can_comment"`
+	CanEdit      bool      `json:"can_edit"`
+	CanDelete    bool      `json:"can_delete"`
+	// CanShare is set for the owners of the targets and actions, sessions are shared
+	// along with them.
+	CanShare bool `json:"can_share"`
+}
+
+// GetPermissions returns the permissions of the user on the given record.
+// ErrRecordNotFound is returned when the record does not exist or the user has no
+// access to it.
+func (m ACLModel) GetPermissions(
+	ctx context.Context,
+	resourceType string,
+	resourceUUID, userUUID uuid.UUID,
+) (*Permissions, error) {
+	role, err := m.effectiveRole(ctx, resourceType, resourceUUID, userUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := m.Roles.orDefault()
+	permissions := Permissions{
+		ResourceType: resourceType,
+		ResourceUUID: resourceUUID,
+		Role:         role.code,
+		Inherited:    role.grantedOnType != resourceType || role.grantedOnUUID != resourceUUID,
+		CanComment:   roles.Allows(role.code, "commenter"),
+		CanEdit:      roles.Allows(role.code, "editor"),
+		CanDelete:    roles.Allows(role.code, "owner"),
+	}
+	permissions.CanShare = permissions.CanDelete &&
+		slices.Contains(InvitationResourceSafelist, resourceType)
+
+	return &permissions, nil
+}
+
+// lastViewedAt returns the column of the last view recorded in the ACL aliased acl, null
+// when its user, whose preferences are aliased p, opted out of the view receipts.
+func lastViewedAt(acl string) string {
+	return fmt.Sprintf(
+		"CASE WHEN COALESCE((p.preference->'privacy'->>'hideLastViewed')::boolean, false) "+
+			"THEN NULL ELSE %s.last_viewed_at END",
+		acl,
+	)
+}
+
+// RecordTargetView records that the user viewed the target, when it is shared with
+// them and they did not opt out of the view receipts. Views are recorded at most once a
+// minute.
+func (m ACLModel) RecordTargetView(ctx context.Context, targetUUID, userUUID uuid.UUID) error {
+	query := `
+		UPDATE acls
+		SET last_viewed_at = NOW()
+		WHERE user_uuid = $1 AND resource_type = 'target' AND resource_uuid = $2
+			AND role_code <> 'owner'
+			AND (last_viewed_at IS NULL OR last_viewed_at < NOW() - INTERVAL '1 minute')
+			AND NOT COALESCE((
+				SELECT (preference->'privacy'->>'hideLastViewed')::boolean
+				FROM preferences
+				WHERE user_uuid = $1
+			), false)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userUUID, targetUUID)
+	return err
+}
+
+// aclHistoryLimit is the number of latest ACL changes listed in the history of a target.
+const aclHistoryLimit = 200
+
+// ACLChange is an audited change to the ACL of a record: a role granted to a user,
+// revoked from them, or changed. The users are null once deleted.
+type ACLChange struct {
+	ResourceType string        `json:"resource_type"`
+	ResourceUUID uuid.UUID     `json:"resource_uuid"`
+	Change       string        `json:"change"`
+	OldRole      string        `json:"old_role,omitzero"`
+	NewRole      string        `json:"new_role,omitzero"`
+	ActorUUID    uuid.NullUUID `json:"actor_uuid"`
+	ActorName    string        `json:"actor_name"`
+	GranteeUUID  uuid.NullUUID `json:"grantee_uuid"`
+	GranteeName  string        `json:"grantee_name"`
+	GranteeEmail string        `json:"grantee_email"`
+	CreatedAt    time.Time     `json:"created_at"`
+}
+
+// GetHistory returns the latest changes to the ACLs of the target and of its actions
+// and sessions, the newest first. ErrRecordNotFound is returned unless the user owns the target.
+func (m ACLModel) GetHistory(
+	ctx context.Context,
+	targetUUID, userUUID uuid.UUID,
+) ([]*ACLChange, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var owner bool
+	err := m.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM acls
+			WHERE user_uuid = $2 AND resource_type = 'target' AND resource_uuid = $1
+				AND role_code = 'owner'
+		)`, targetUUID, userUUID).Scan(&owner)
+	if err != nil {
+		return nil, err
+	}
+	if !owner {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT
+			au.resource_type,
+			au.resource_uuid,
+			au.change,
+			COALESCE(au.old_role, ''),
+			COALESCE(au.new_role, ''),
+			au.actor_uuid,
+			COALESCE(actor.name, ''),
+			au.grantee_uuid,
+			COALESCE(grantee.name, ''),
+			COALESCE(grantee.email, ''),
+			au.created_at
+		FROM acl_audit au
+		LEFT JOIN users actor ON actor.uuid = au.actor_uuid
+		LEFT JOIN users grantee ON grantee.uuid = au.grantee_uuid
+		WHERE (au.resource_type = 'target' AND au.resource_uuid = $1)
+			OR (au.resource_type = 'action' AND au.resource_uuid IN (
+				SELECT uuid FROM actions WHERE target_uuid = $1
+			))
+			OR (au.resource_type = 'session' AND au.resource_uuid IN (
+				SELECT s.uuid
+				FROM sessions s
+				LEFT JOIN actions a ON a.uuid = s.action_uuid
+				WHERE COALESCE(a.target_uuid, s.target_uuid) = $1
+			))
+		ORDER BY au.created_at DESC, au.id DESC
+		LIMIT $2
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, targetUUID, aclHistoryLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []*ACLChange{}
+	for rows.Next() {
+		var change ACLChange
+		err := rows.Scan(
+			&change.ResourceType,
+			&change.ResourceUUID,
+			&change.Change,
+			&change.OldRole,
+			&change.NewRole,
+			&change.ActorUUID,
+			&change.ActorName,
+			&change.GranteeUUID,
+			&change.GranteeName,
+			&change.GranteeEmail,
+			&change.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, &change)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// DeleteAllOrphanedAudit deletes the audited ACL changes of the deleted records. The
+// changes of the records whose deletion can still be undone are kept, to be found again
+// once the records are restored.
+func (m ACLModel) DeleteAllOrphanedAudit(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM acl_audit au
+		WHERE CASE au.resource_type
+			WHEN 'target' THEN NOT EXISTS (SELECT 1 FROM targets WHERE uuid = au.resource_uuid)
+			WHEN 'action' THEN NOT EXISTS (SELECT 1 FROM actions WHERE uuid = au.resource_uuid)
+			WHEN 'session' THEN NOT EXISTS (
+				SELECT 1 FROM sessions WHERE uuid = au.resource_uuid
+			)
+			ELSE false
+		END
+		AND NOT EXISTS (
+			SELECT 1 FROM undo_operations u
+			WHERE u.expires_at > NOW()
+				AND u.snapshot -> (au.resource_type::text || 's')
+					@> jsonb_build_array(jsonb_build_object('uuid', au.resource_uuid))
+		)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 	"time"
 	"unicode/utf8"
 
@@ -13,70 +15,156 @@ import (
 	"github.com/lib/pq"
 	"github.com/liuminhaw/yatijapp/internal/tokenizer"
 	"github.com/liuminhaw/yatijapp/internal/validator"
-	"github.com/yanyiwu/gojieba"
 )
 
 type Action struct {
-	UUID          uuid.UUID    `json:"uuid"`
-	CreatedAt     time.Time    `json:"created_at"`
-	DueDate       sql.NullTime `json:"due_date,omitzero"`
-	UpdatedAt     time.Time    `json:"updated_at"`
-	LastActive    time.Time    `json:"last_active"`
-	Title         string       `json:"title"`
-	Description   string       `json:"description,omitzero"`
-	Notes         string       `json:"notes,omitzero"`
-	Version       int32        `json:"version"`
-	Status        Status       `json:"status,omitzero"` // e.g., "queued", "in progress", "complete", "canceled"
-	SerialID      int64        `json:"-"`               // Optional field for serial ID, not used in all contexts
-	TargetUUID    uuid.UUID    `json:"target_uuid"`
-	TargetTitle   string       `json:"target_title"`
-	HasNotes      bool         `json:"has_notes"`
-	SessionsCount int64        `json:"sessions_count"`
-	Role          string       `json:"role"` // The user's role for this action, e.g., "owner", "editor", "viewer"
+	UUID          uuid.UUID `json:"uuid"`
+	CreatedAt     time.Time `json:"created_at"`
+	DueDate       NullTime  `json:"due_date"`
+	DueTimezone   string    `json:"due_timezone"` // IANA timezone of the due date, UTC when empty
+	UpdatedAt     time.Time `json:"updated_at"`
+	LastActive    time.Time `json:"last_active"`
+	Title         string    `json:"title"`
+	Description   string    `json:"description,omitzero"`
+	Notes         string    `json:"notes,omitzero"`
+	Version       int32     `json:"version"`
+	Status        Status    `json:"status,omitzero"` // e.g., "queued", "in progress", "complete", "canceled"
+	SerialID      int64     `json:"-"`               // Optional field for serial ID, not used in all contexts
+	TargetUUID    uuid.UUID `json:"target_uuid"`
+	TargetTitle   string    `json:"target_title"`
+	HasNotes      bool      `json:"has_notes"`
+	SessionsCount int64     `json:"sessions_count"`
+	Role          string    `json:"role"` // The user's role for this action, e.g., "owner", "editor", "viewer"
+	// ChecklistCount and ChecklistDone are the number of checklist items of the action,
+	// and of those done.
+	ChecklistCount int64 `json:"checklist_count"`
+	ChecklistDone  int64 `json:"checklist_done"`
+	// Tags label the action, EstimateMinutes is how long it is expected to take, 0 when
+	// it is not estimated.
+	Tags            []string `json:"tags"`
+	EstimateMinutes int      `json:"estimate_minutes"`
+}
+
+// Limits of the tags and estimates of the actions.
+const (
+	MaxActionTags      = 10
+	maxActionTagLength = 30
+	// MaxEstimateMinutes is a week of work.
+	MaxEstimateMinutes = 7 * 24 * 60
+)
+
+// NormalizeTags returns the tags trimmed and in lower case, without the leading # and
+// the empty and duplicate ones.
+func NormalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+		if tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+
+	return normalized
 }
 
 func ValidateAction(v *validator.Validator, action *Action, on string) {
-	v.Check(action.TargetUUID != uuid.Nil, "target_uuid", "must be provided")
-	v.Check(action.Title != "", "title", "must be provided")
-	v.Check(
+	v.CheckField(action.TargetUUID != uuid.Nil, "target_uuid", validator.Required())
+	v.CheckField(action.Title != "", "title", validator.Required())
+	v.CheckField(
 		utf8.RuneCountInString(action.Title) <= 80,
 		"title",
-		"must not be more than 80 characters long",
+		validator.TooLong(80, validator.UnitCharacters),
 	)
-	v.Check(
+	v.CheckField(
 		utf8.RuneCountInString(action.Description) <= 200,
 		"description",
-		"must not be more than 200 characters long",
+		validator.TooLong(200, validator.UnitCharacters),
 	)
-	v.Check(action.Status != "", "status", "must be provided")
-	v.Check(
+	v.CheckField(action.Status != "", "status", validator.Required())
+	v.CheckField(
 		validator.PermittedValue(action.Status, StatusSafelist...),
 		"status",
-		"must be one of 'queued', 'in progress', 'complete', 'canceled', or 'archived'",
+		validator.NotPermitted(
+			"must be one of 'queued', 'in progress', 'complete', 'canceled', or 'archived'",
+		),
+	)
+	v.CheckField(
+		ValidTimezone(action.DueTimezone),
+		"due_timezone",
+		validator.NotPermitted("must be an IANA timezone name, such as 'Asia/Taipei'"),
+	)
+	v.CheckField(
+		len(action.Tags) <= MaxActionTags,
+		"tags",
+		validator.TooLong(MaxActionTags, validator.UnitItems),
+	)
+	for _, tag := range action.Tags {
+		v.CheckField(
+			utf8.RuneCountInString(tag) <= maxActionTagLength,
+			"tags",
+			validator.Invalid(fmt.Sprintf(
+				"must each be at most %d characters long",
+				maxActionTagLength,
+			)),
+		)
+	}
+	v.CheckField(
+		action.EstimateMinutes >= 0,
+		"estimate_minutes",
+		validator.TooSmall(0, "must not be negative"),
+	)
+	v.CheckField(
+		action.EstimateMinutes <= MaxEstimateMinutes,
+		"estimate_minutes",
+		validator.TooLarge(
+			MaxEstimateMinutes,
+			fmt.Sprintf("must be a maximum of %d", MaxEstimateMinutes),
+		),
 	)
 	if on == "create" && action.DueDate.Valid {
-		v.Check(
+		v.CheckField(
 			action.DueDate.Time.After(time.Now().AddDate(0, 0, -1)),
 			"due_date",
-			"must be in the future",
+			validator.NewFieldError(validator.CodeNotInFuture, "must be in the future"),
 		)
 	}
 }
 
-// ActionModel struct type wraps a sql.DB connection pool and a Jieba instance.
+// ActionModel struct type wraps a sql.DB connection pool and a text segmenter.
 type ActionModel struct {
-	DB     DBTX
-	Jieba  *gojieba.Jieba
-	logger *slog.Logger
+	DB        DBTX
+	Segmenter tokenizer.Segmenter
+	Cipher    *NotesCipher
+	FTS       *FTSConfig
+	logger    *slog.Logger
 }
 
 func (m ActionModel) Insert(ctx context.Context, action *Action, userUUID uuid.UUID) error {
-	fts := GenFTS(action.Title, action.Description, action.Notes, m.Jieba)
+	fts := GenFTS(
+		action.Title,
+		action.Description,
+		m.Cipher.Searchable(action.Notes),
+		m.Segmenter,
+	)
+	notes, err := m.Cipher.Seal(action.Notes)
+	if err != nil {
+		return err
+	}
 
-	query := `
+	query := m.FTS.weighted(`
 	WITH new_action AS (
-		INSERT INTO actions (target_uuid, title, description, notes, due_date, status)
-		SELECT t.uuid, $2, $3, $4, $5, $6
+		INSERT INTO actions (
+			target_uuid,
+			title,
+			description,
+			notes,
+			due_date,
+			due_timezone,
+			status,
+			tags,
+			estimate_minutes
+		)
+		SELECT t.uuid, $2, $3, $4, $5, $14, $6, $15, $16
         FROM targets t
 	    WHERE t.uuid = $1 AND EXISTS (
 			SELECT 1
@@ -91,33 +179,38 @@ func (m ActionModel) Insert(ctx context.Context, action *Action, userUUID uuid.U
 	), grant_acl AS (
 		INSERT INTO acls (user_uuid, resource_type, resource_uuid, role_code)
 		SELECT $7, 'action', uuid, 'owner' FROM new_action
+	), audit_acl AS (
+		INSERT INTO acl_audit (
+			resource_type, resource_uuid, actor_uuid, grantee_uuid, change, new_role
+		)
+		SELECT 'action', uuid, $7, $7, 'grant', 'owner' FROM new_action
 	), new_fts AS (
 		INSERT INTO actions_fts (
 			action_uuid,
 			fts_chinese_tsv,
 			fts_english_tsv,
 			fts_chinese_notes_tsv,
-			fts_english_notes_tsv
+			fts_english_notes_tsv,
+			archived
 		) SELECT
 			uuid,
-			setweight(to_tsvector('simple', $8), 'A') ||
-			setweight(to_tsvector('simple', $9), 'B'),
-			setweight(to_tsvector('english', $11), 'A') ||
-			setweight(to_tsvector('english', $12), 'B'),
+			setweight(to_tsvector('simple', $8), '%[1]s') ||
+			setweight(to_tsvector('simple', $9), '%[2]s'),
+			setweight(to_tsvector('english', $11), '%[1]s') ||
+			setweight(to_tsvector('english', $12), '%[2]s'),
 			to_tsvector('simple', $10),
-			to_tsvector('english', $13)
+			to_tsvector('english', $13),
+			$6 = 'archived'
 		FROM new_action
 	)
 	SELECT uuid, created_at, updated_at, version FROM new_action;
-	`
-	// Consider adding index on acls_targets
-	// CREATE INDEX ON acls_target (resource_uuid, user_uuid, role_code);
+	`)
 
 	args := []any{
 		action.TargetUUID,
 		action.Title,
 		action.Description,
-		action.Notes,
+		notes,
 		action.DueDate,
 		action.Status,
 		userUUID,
@@ -127,9 +220,12 @@ func (m ActionModel) Insert(ctx context.Context, action *Action, userUUID uuid.U
 		fts.TitleToken.English,
 		fts.DescriptionToken.English,
 		fts.NotesToken.English,
+		action.DueTimezone,
+		pq.Array(action.Tags),
+		action.EstimateMinutes,
 	}
 
-	err := m.DB.QueryRowContext(ctx, query, args...).
+	err = m.DB.QueryRowContext(ctx, query, args...).
 		Scan(&action.UUID, &action.CreatedAt, &action.UpdatedAt, &action.Version)
 	if err != nil {
 		switch {
@@ -143,7 +239,11 @@ func (m ActionModel) Insert(ctx context.Context, action *Action, userUUID uuid.U
 	return nil
 }
 
-func (m ActionModel) Get(uuid, userUUID uuid.UUID, minRole string) (*Action, error) {
+func (m ActionModel) Get(
+	ctx context.Context,
+	uuid, userUUID uuid.UUID,
+	minRole string,
+) (*Action, error) {
 	query := `
 		WITH cutoff AS (
 			SELECT rank AS cutoff
@@ -154,6 +254,7 @@ func (m ActionModel) Get(uuid, userUUID uuid.UUID, minRole string) (*Action, err
 			a.uuid,
 			a.created_at,
 			a.due_date,
+			a.due_timezone,
 			a.updated_at,
 			a.last_active,
 			a.title,
@@ -162,7 +263,11 @@ func (m ActionModel) Get(uuid, userUUID uuid.UUID, minRole string) (*Action, err
 			a.status,
 			a.version,
 			a.target_uuid,
-			t.title
+			t.title,
+			(SELECT COUNT(*) FROM checklist_items WHERE action_uuid = a.uuid),
+			(SELECT COUNT(*) FROM checklist_items WHERE action_uuid = a.uuid AND done),
+			a.tags,
+			a.estimate_minutes
 		FROM actions a 
 		JOIN targets t ON a.target_uuid = t.uuid
 		WHERE a.uuid = $1
@@ -182,13 +287,14 @@ func (m ActionModel) Get(uuid, userUUID uuid.UUID, minRole string) (*Action, err
 
 	var action Action
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
 		&action.UUID,
 		&action.CreatedAt,
 		&action.DueDate,
+		&action.DueTimezone,
 		&action.UpdatedAt,
 		&action.LastActive,
 		&action.Title,
@@ -198,6 +304,10 @@ func (m ActionModel) Get(uuid, userUUID uuid.UUID, minRole string) (*Action, err
 		&action.Version,
 		&action.TargetUUID,
 		&action.TargetTitle,
+		&action.ChecklistCount,
+		&action.ChecklistDone,
+		pq.Array(&action.Tags),
+		&action.EstimateMinutes,
 	)
 	if err != nil {
 		switch {
@@ -208,12 +318,24 @@ func (m ActionModel) Get(uuid, userUUID uuid.UUID, minRole string) (*Action, err
 		}
 	}
 
+	action.Notes, err = m.Cipher.Open(action.Notes)
+	if err != nil {
+		return nil, err
+	}
+
 	return &action, nil
 }
 
-func (m ActionModel) Update(action *Action, fts FTS, userUUID uuid.UUID) error {
+func (m ActionModel) Update(
+	ctx context.Context,
+	action *Action,
+	fts FTS,
+	userUUID uuid.UUID,
+) error {
+	forgetACLs(ctx)
+
 	// TODO: Need to perform permission tests if collaboration is ever introduced
-	query := `
+	query := m.FTS.weighted(`
 		WITH editor_cutoff AS (
 			SELECT rank AS cutoff FROM roles WHERE code = 'editor'
 		),
@@ -226,7 +348,10 @@ func (m ActionModel) Update(action *Action, fts FTS, userUUID uuid.UUID) error {
 				description = $2,
 				notes = $3,
 				due_date = $4,
+				due_timezone = $16,
 				status = $5,
+				tags = $17,
+				estimate_minutes = $18,
 				version = version + 1,
 				updated_at = NOW(),
 				last_active = NOW(),
@@ -279,22 +404,28 @@ func (m ActionModel) Update(action *Action, fts FTS, userUUID uuid.UUID) error {
 			RETURNING a.uuid, a.created_at, a.updated_at, a.last_active, a.version
 		), update_fts AS (
 			UPDATE actions_fts AS fts
-			SET fts_chinese_tsv = setweight(to_tsvector('simple', $10), 'A') ||
-					setweight(to_tsvector('simple', $11), 'B'),
-				fts_english_tsv = setweight(to_tsvector('english', $13), 'A') ||
-					setweight(to_tsvector('english', $14), 'B'),
+			SET fts_chinese_tsv = setweight(to_tsvector('simple', $10), '%[1]s') ||
+					setweight(to_tsvector('simple', $11), '%[2]s'),
+				fts_english_tsv = setweight(to_tsvector('english', $13), '%[1]s') ||
+					setweight(to_tsvector('english', $14), '%[2]s'),
 				fts_chinese_notes_tsv = to_tsvector('simple', $12),
-				fts_english_notes_tsv = to_tsvector('english', $15)
+				fts_english_notes_tsv = to_tsvector('english', $15),
+				archived = $5 = 'archived'
 			FROM update_action ua
 			WHERE fts.action_uuid = ua.uuid
 		)
 		SELECT a.created_at, a.updated_at, a.last_active, a.version FROM update_action a;
-	`
+	`)
+
+	notes, err := m.Cipher.Seal(action.Notes)
+	if err != nil {
+		return err
+	}
 
 	args := []any{
 		action.Title,
 		action.Description,
-		action.Notes,
+		notes,
 		action.DueDate,
 		action.Status,
 		action.UUID,
@@ -307,12 +438,15 @@ func (m ActionModel) Update(action *Action, fts FTS, userUUID uuid.UUID) error {
 		fts.TitleToken.English,
 		fts.DescriptionToken.English,
 		fts.NotesToken.English,
+		action.DueTimezone,
+		pq.Array(action.Tags),
+		action.EstimateMinutes,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).
+	err = m.DB.QueryRowContext(ctx, query, args...).
 		Scan(&action.CreatedAt, &action.UpdatedAt, &action.LastActive, &action.Version)
 	if err != nil {
 		switch {
@@ -326,7 +460,9 @@ func (m ActionModel) Update(action *Action, fts FTS, userUUID uuid.UUID) error {
 	return nil
 }
 
-func (m ActionModel) Delete(uuid, userUUID uuid.UUID) error {
+func (m ActionModel) Delete(ctx context.Context, uuid, userUUID uuid.UUID) error {
+	forgetACLs(ctx)
+
 	query := `
 		WITH cutoff AS (
 			SELECT rank AS cutoff
@@ -356,7 +492,7 @@ func (m ActionModel) Delete(uuid, userUUID uuid.UUID) error {
 			)
 		)`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	result, err := m.DB.ExecContext(ctx, query, uuid, userUUID)
@@ -376,7 +512,60 @@ func (m ActionModel) Delete(uuid, userUUID uuid.UUID) error {
 	return nil
 }
 
+// Reassign moves every action of the target fromUUID to the target toUUID, in a single
+// statement, and returns the number of actions moved. The user must be an owner of both
+// targets, otherwise ErrRecordNotFound is returned.
+func (m ActionModel) Reassign(
+	ctx context.Context,
+	fromUUID, toUUID, userUUID uuid.UUID,
+) (int64, error) {
+	forgetACLs(ctx)
+
+	query := `
+		WITH owner_cutoff AS (
+			SELECT rank AS cutoff FROM roles WHERE code = 'owner'
+		),
+		allowed AS (
+			SELECT (
+				SELECT COUNT(DISTINCT ac.resource_uuid)
+				FROM acls ac
+				JOIN roles r ON ac.role_code = r.code
+				JOIN owner_cutoff oc ON r.rank <= oc.cutoff
+				WHERE ac.resource_type = 'target'
+					AND ac.resource_uuid IN ($1, $2)
+					AND ac.user_uuid = $3
+			) = 2 AS ok
+		),
+		moved AS (
+			UPDATE actions AS a
+			SET target_uuid = $2,
+				updated_at = NOW(),
+				version = version + 1
+			FROM allowed
+			WHERE allowed.ok AND a.target_uuid = $1
+			RETURNING a.uuid
+		)
+		SELECT ok, (SELECT COUNT(*) FROM moved) FROM allowed;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var ok bool
+	var moved int64
+	err := m.DB.QueryRowContext(ctx, query, fromUUID, toUUID, userUUID).Scan(&ok, &moved)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrRecordNotFound
+	}
+
+	return moved, nil
+}
+
 func (m ActionModel) GetAll(
+	ctx context.Context,
 	token tokenizer.Tokenizer,
 	filters Filters,
 	targetUUID uuid.NullUUID,
@@ -392,6 +581,8 @@ func (m ActionModel) GetAll(
 				AND ($2 = '' OR fts.fts_english_tsv @@ plainto_tsquery('english', $2))
 				AND ($3 = '{}' OR a.status = ANY ($3::statuses[]))
 				AND ($4::uuid IS NULL OR a.target_uuid = $4::uuid)
+				AND %s
+				AND %s
 				AND EXISTS (
 					SELECT 1
 					FROM acls ac
@@ -412,6 +603,7 @@ func (m ActionModel) GetAll(
 				a.uuid,
 				a.created_at,
 				a.due_date,
+				a.due_timezone,
 				a.updated_at,
 				a.last_active,
 				a.title,
@@ -422,12 +614,16 @@ func (m ActionModel) GetAll(
 				a.target_uuid,
 				t.title as target_title,
 				COALESCE(ss.sessions_count, 0) AS sessions_count,
+				COALESCE(cl.items, 0) AS checklist_count,
+				COALESCE(cl.done, 0) AS checklist_done,
 				(btrim(COALESCE(a.notes, '')) <> '') AS has_notes,
+				a.tags,
+				a.estimate_minutes,
 				(CASE WHEN $1 <> '' THEN
-					ts_rank(fts.fts_chinese_tsv, plainto_tsquery('simple', $1))
+					%s
 				ELSE 0 END) + (CASE WHEN $2 <> '' THEN
-					ts_rank(fts.fts_english_tsv, plainto_tsquery('english', $2))
-				ELSE 0 END) AS rank
+					%s
+				ELSE 0 END)%s AS rank
 			FROM filtered f
 			JOIN actions a ON f.uuid = a.uuid
 			JOIN actions_fts fts ON fts.action_uuid = a.uuid
@@ -438,7 +634,16 @@ func (m ActionModel) GetAll(
 				JOIN filtered fl ON fl.uuid = s.action_uuid
 				GROUP BY s.action_uuid
 			) ss ON ss.action_uuid = a.uuid
-			ORDER BY a.%s %s, rank DESC, a.serial_id DESC
+			LEFT JOIN (
+				SELECT
+					c.action_uuid,
+					COUNT(*) AS items,
+					COUNT(*) FILTER (WHERE c.done) AS done
+				FROM checklist_items c
+				JOIN filtered fl ON fl.uuid = c.action_uuid
+				GROUP BY c.action_uuid
+			) cl ON cl.action_uuid = a.uuid
+			ORDER BY %s rank DESC, a.serial_id DESC
 			LIMIT $6 OFFSET $7
 		)
 		SELECT 
@@ -446,6 +651,7 @@ func (m ActionModel) GetAll(
 			p.uuid,
 			p.created_at,
 			p.due_date,
+			p.due_timezone,
 			p.updated_at,
 			p.last_active,
 			p.title,
@@ -456,7 +662,11 @@ func (m ActionModel) GetAll(
 			p.target_uuid,
 			p.target_title,
 			p.sessions_count,
+			p.checklist_count,
+			p.checklist_done,
 			p.has_notes,
+			p.tags,
+			p.estimate_minutes,
 			ur.role_code,
 			p.rank
 		FROM paged p
@@ -473,10 +683,18 @@ func (m ActionModel) GetAll(
 			LIMIT 1
 		) AS ur ON TRUE
 		CROSS JOIN total
-		ORDER BY p.%s %s, p.rank DESC, p.serial_id DESC
-	`, filters.sortColumn(), filters.sortDirection(), filters.sortColumn(), filters.sortDirection())
+		ORDER BY %s p.rank DESC, p.serial_id DESC
+	`,
+		filters.recordFilterClause("a", 8),
+		roleFilterClause(5, 11, "('action', a.uuid)", "('target', t.uuid)"),
+		m.FTS.tsRank("fts.fts_chinese_tsv", "plainto_tsquery('simple', $1)"),
+		m.FTS.tsRank("fts.fts_english_tsv", "plainto_tsquery('english', $2)"),
+		m.FTS.boost("a", filters),
+		filters.orderBy("a"),
+		filters.orderBy("p"),
+	)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	args := []any{
@@ -488,6 +706,8 @@ func (m ActionModel) GetAll(
 		filters.limit(),
 		filters.offset(),
 	}
+	args = append(args, filters.recordFilterArgs()...)
+	args = append(args, pq.Array(filters.Role))
 
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -506,6 +726,7 @@ func (m ActionModel) GetAll(
 			&action.UUID,
 			&action.CreatedAt,
 			&action.DueDate,
+			&action.DueTimezone,
 			&action.UpdatedAt,
 			&action.LastActive,
 			&action.Title,
@@ -516,7 +737,11 @@ func (m ActionModel) GetAll(
 			&action.TargetUUID,
 			&action.TargetTitle,
 			&action.SessionsCount,
+			&action.ChecklistCount,
+			&action.ChecklistDone,
 			&action.HasNotes,
+			pq.Array(&action.Tags),
+			&action.EstimateMinutes,
 			&action.Role,
 			&ignored,
 		)
@@ -531,7 +756,77 @@ func (m ActionModel) GetAll(
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	metadata := calculateMetadata(totalRecords, filters)
 
 	return actions, metadata, nil
 }
+
+// Fingerprint returns the fingerprint of the actions matching the given search
+// tokens and filters, without fetching the records themselves.
+func (m ActionModel) Fingerprint(
+	ctx context.Context,
+	token tokenizer.Tokenizer,
+	filters Filters,
+	targetUUID uuid.NullUUID,
+	userUUID uuid.UUID,
+) (Fingerprint, error) {
+	query := fmt.Sprintf(`
+		WITH filtered AS MATERIALIZED (
+			SELECT a.uuid, a.target_uuid
+			FROM actions a
+			JOIN actions_fts fts ON fts.action_uuid = a.uuid
+			JOIN targets t ON a.target_uuid = t.uuid
+			WHERE ($1 = '' OR fts.fts_chinese_tsv @@ plainto_tsquery('simple', $1))
+				AND ($2 = '' OR fts.fts_english_tsv @@ plainto_tsquery('english', $2))
+				AND ($3 = '{}' OR a.status = ANY ($3::statuses[]))
+				AND ($4::uuid IS NULL OR a.target_uuid = $4::uuid)
+				AND %s
+				AND %s
+				AND EXISTS (
+					SELECT 1
+					FROM acls ac
+					JOIN roles r ON ac.role_code = r.code
+					WHERE ac.user_uuid = $5
+					AND r.rank <= (SELECT rank FROM roles WHERE code = 'viewer')
+					AND (ac.resource_type, ac.resource_uuid) IN (
+						('action', a.uuid),
+						('target', t.uuid)
+					)
+				)
+		)
+		SELECT
+			(SELECT COUNT(*) FROM filtered),
+			COALESCE((
+				SELECT GREATEST(MAX(a.updated_at), MAX(t.updated_at))
+				FROM filtered f
+				JOIN actions a ON a.uuid = f.uuid
+				JOIN targets t ON t.uuid = f.target_uuid
+			), to_timestamp(0)),
+			(SELECT COUNT(*) FROM sessions s JOIN filtered f ON f.uuid = s.action_uuid)
+	`,
+		filters.recordFilterClause("a", 6),
+		roleFilterClause(5, 9, "('action', a.uuid)", "('target', t.uuid)"),
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	args := []any{
+		token.Chinese,
+		token.English,
+		pq.Array(filters.Status),
+		targetUUID,
+		userUUID,
+	}
+	args = append(args, filters.recordFilterArgs()...)
+	args = append(args, pq.Array(filters.Role))
+
+	var fp Fingerprint
+	err := m.DB.QueryRowContext(ctx, query, args...).
+		Scan(&fp.TotalRecords, &fp.LastUpdated, &fp.ChildCount)
+	if err != nil {
+		return Fingerprint{}, err
+	}
+
+	return fp, nil
+}
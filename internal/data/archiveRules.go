@@ -0,0 +1,445 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// ArchiveRule automatically archives the targets, or actions, a user owns once they
+// have stayed in Status without any activity for InactiveDays. The owner is warned
+// NoticeDays before the record is archived, a NoticeDays of zero archives it silently.
+type ArchiveRule struct {
+	UUID         uuid.UUID `json:"uuid"`
+	ResourceType string    `json:"resource_type"`
+	Status       Status    `json:"status"`
+	InactiveDays int       `json:"inactive_days"`
+	NoticeDays   int       `json:"notice_days"`
+	Enabled      bool      `json:"enabled"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Version      int32     `json:"version"`
+}
+
+var ArchiveRuleResourceSafelist = []string{"target", "action"}
+
+var ArchiveRuleStatusSafelist = []Status{StatusComplete, StatusCanceled}
+
+func ValidateArchiveRule(v *validator.Validator, rule *ArchiveRule) {
+	v.CheckField(rule.ResourceType != "", "resource_type", validator.Required())
+	v.CheckField(
+		validator.PermittedValue(rule.ResourceType, ArchiveRuleResourceSafelist...),
+		"resource_type",
+		validator.NotPermitted("must be one of target, action"),
+	)
+	v.CheckField(rule.Status != "", "status", validator.Required())
+	v.CheckField(
+		validator.PermittedValue(rule.Status, ArchiveRuleStatusSafelist...),
+		"status",
+		validator.NotPermitted("must be one of completed, canceled"),
+	)
+	v.CheckField(
+		rule.InactiveDays > 0,
+		"inactive_days",
+		validator.TooSmall(1, "must be greater than zero"),
+	)
+	v.CheckField(
+		rule.InactiveDays <= 3650,
+		"inactive_days",
+		validator.TooLarge(3650, "must be a maximum of 3650"),
+	)
+	v.CheckField(rule.NoticeDays >= 0, "notice_days", validator.TooSmall(0, "must not be negative"))
+	v.CheckField(
+		rule.NoticeDays < rule.InactiveDays,
+		"notice_days",
+		validator.Invalid("must be less than inactive_days"),
+	)
+}
+
+// ArchiveNotice is a record about to be archived by a rule, the owner is told about it
+// by email before ArchivesAt.
+type ArchiveNotice struct {
+	RuleUUID     uuid.UUID
+	UserUUID     uuid.UUID
+	Email        string
+	Name         string
+	ResourceType string
+	ResourceUUID uuid.UUID
+	Title        string
+	ArchivesAt   time.Time
+}
+
+type ArchiveRuleModel struct {
+	DB DBTX
+}
+
+func (m ArchiveRuleModel) Insert(ctx context.Context, rule *ArchiveRule, userUUID uuid.UUID) error {
+	query := `
+		INSERT INTO archive_rules (
+			user_uuid, resource_type, status, inactive_days, notice_days, enabled
+		)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING uuid, created_at, updated_at, version
+	`
+
+	args := []any{
+		userUUID,
+		rule.ResourceType,
+		rule.Status,
+		rule.InactiveDays,
+		rule.NoticeDays,
+		rule.Enabled,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).
+		Scan(&rule.UUID, &rule.CreatedAt, &rule.UpdatedAt, &rule.Version)
+}
+
+func (m ArchiveRuleModel) Get(ctx context.Context, uuid, userUUID uuid.UUID) (*ArchiveRule, error) {
+	query := `
+		SELECT uuid, resource_type, status, inactive_days, notice_days, enabled,
+			created_at, updated_at, version
+		FROM archive_rules
+		WHERE uuid = $1 AND user_uuid = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var rule ArchiveRule
+	err := m.DB.QueryRowContext(ctx, query, uuid, userUUID).Scan(
+		&rule.UUID,
+		&rule.ResourceType,
+		&rule.Status,
+		&rule.InactiveDays,
+		&rule.NoticeDays,
+		&rule.Enabled,
+		&rule.CreatedAt,
+		&rule.UpdatedAt,
+		&rule.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &rule, nil
+}
+
+func (m ArchiveRuleModel) GetAllForUser(
+	ctx context.Context,
+	userUUID uuid.UUID,
+) ([]*ArchiveRule, error) {
+	query := `
+		SELECT uuid, resource_type, status, inactive_days, notice_days, enabled,
+			created_at, updated_at, version
+		FROM archive_rules
+		WHERE user_uuid = $1
+		ORDER BY created_at, uuid
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userUUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []*ArchiveRule{}
+	for rows.Next() {
+		var rule ArchiveRule
+		err := rows.Scan(
+			&rule.UUID,
+			&rule.ResourceType,
+			&rule.Status,
+			&rule.InactiveDays,
+			&rule.NoticeDays,
+			&rule.Enabled,
+			&rule.CreatedAt,
+			&rule.UpdatedAt,
+			&rule.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, &rule)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (m ArchiveRuleModel) Update(ctx context.Context, rule *ArchiveRule, userUUID uuid.UUID) error {
+	query := `
+		UPDATE archive_rules
+		SET resource_type = $1,
+			status = $2,
+			inactive_days = $3,
+			notice_days = $4,
+			enabled = $5,
+			updated_at = NOW(),
+			version = version + 1
+		WHERE uuid = $6 AND user_uuid = $7 AND version = $8
+		RETURNING updated_at, version
+	`
+
+	args := []any{
+		rule.ResourceType,
+		rule.Status,
+		rule.InactiveDays,
+		rule.NoticeDays,
+		rule.Enabled,
+		rule.UUID,
+		userUUID,
+		rule.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&rule.UpdatedAt, &rule.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m ArchiveRuleModel) Delete(ctx context.Context, uuid, userUUID uuid.UUID) error {
+	query := `
+		DELETE FROM archive_rules
+		WHERE uuid = $1 AND user_uuid = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, uuid, userUUID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// archiveCandidates selects, for every enabled rule, the records its user owns which
+// are in the status of the rule, along with when they were last active.
+const archiveCandidates = `
+	owner_cutoff AS (
+		SELECT rank AS cutoff FROM roles WHERE code = 'owner'
+	),
+	candidates AS (
+		SELECT ar.uuid AS rule_uuid, ar.user_uuid, ar.resource_type, ar.inactive_days,
+			ar.notice_days, t.uuid AS resource_uuid, t.title, t.last_active
+		FROM archive_rules ar
+		JOIN targets t ON t.status = ar.status
+		WHERE ar.enabled AND ar.resource_type = 'target' AND EXISTS (
+			SELECT 1
+			FROM acls ac
+			JOIN roles r ON ac.role_code = r.code
+			JOIN owner_cutoff oc ON r.rank <= oc.cutoff
+			WHERE ac.user_uuid = ar.user_uuid
+				AND ac.resource_type = 'target'
+				AND ac.resource_uuid = t.uuid
+		)
+		UNION ALL
+		SELECT ar.uuid, ar.user_uuid, ar.resource_type, ar.inactive_days,
+			ar.notice_days, a.uuid, a.title, a.last_active
+		FROM archive_rules ar
+		JOIN actions a ON a.status = ar.status
+		WHERE ar.enabled AND ar.resource_type = 'action' AND EXISTS (
+			SELECT 1
+			FROM acls ac
+			JOIN roles r ON ac.role_code = r.code
+			JOIN owner_cutoff oc ON r.rank <= oc.cutoff
+			WHERE ac.user_uuid = ar.user_uuid
+				AND (ac.resource_type, ac.resource_uuid) IN (
+					('action', a.uuid),
+					('target', a.target_uuid)
+				)
+		)
+	)`
+
+// PendingNotices returns the records entering the notice period of their rule, whose
+// owners have not been warned yet for the current period of inactivity. The notices are
+// ordered by user so that they can be grouped into a single email.
+func (m ArchiveRuleModel) PendingNotices(ctx context.Context) ([]ArchiveNotice, error) {
+	query := `
+		WITH` + archiveCandidates + `
+		SELECT c.rule_uuid, c.user_uuid, u.email, u.name, c.resource_type, c.resource_uuid,
+			c.title, c.last_active + make_interval(days => c.inactive_days)
+		FROM candidates c
+		JOIN users u ON u.uuid = c.user_uuid
+		WHERE c.notice_days > 0
+			AND c.last_active <= NOW() - make_interval(days => c.inactive_days - c.notice_days)
+			AND NOT EXISTS (
+				SELECT 1
+				FROM archive_notices n
+				WHERE n.rule_uuid = c.rule_uuid
+					AND n.resource_uuid = c.resource_uuid
+					AND n.notified_at >= c.last_active
+			)
+		ORDER BY c.user_uuid, c.resource_type, c.title
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notices := []ArchiveNotice{}
+	for rows.Next() {
+		var notice ArchiveNotice
+		err := rows.Scan(
+			&notice.RuleUUID,
+			&notice.UserUUID,
+			&notice.Email,
+			&notice.Name,
+			&notice.ResourceType,
+			&notice.ResourceUUID,
+			&notice.Title,
+			&notice.ArchivesAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		notices = append(notices, notice)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return notices, nil
+}
+
+// MarkNotified records that the owners have been warned about the given notices, the
+// records are then archived once the notice period of their rule is over.
+func (m ArchiveRuleModel) MarkNotified(ctx context.Context, notices []ArchiveNotice) error {
+	query := `
+		INSERT INTO archive_notices (rule_uuid, resource_uuid)
+		VALUES ($1, $2)
+		ON CONFLICT (rule_uuid, resource_uuid) DO UPDATE
+		SET notified_at = NOW()
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	for _, notice := range notices {
+		_, err := m.DB.ExecContext(ctx, query, notice.RuleUUID, notice.ResourceUUID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Apply archives the records which have been inactive for as long as their rule allows,
+// provided their owners were warned at least the notice period of the rule ago. It
+// returns the number of records archived.
+func (m ArchiveRuleModel) Apply(ctx context.Context) (int64, error) {
+	query := `
+		WITH` + archiveCandidates + `,
+		due AS (
+			SELECT DISTINCT c.resource_type, c.resource_uuid
+			FROM candidates c
+			WHERE c.last_active <= NOW() - make_interval(days => c.inactive_days)
+				AND (c.notice_days = 0 OR EXISTS (
+					SELECT 1
+					FROM archive_notices n
+					WHERE n.rule_uuid = c.rule_uuid
+						AND n.resource_uuid = c.resource_uuid
+						AND n.notified_at >= c.last_active
+						AND n.notified_at <= NOW() - make_interval(days => c.notice_days)
+				))
+		),
+		archived_targets AS (
+			UPDATE targets AS t
+			SET status = 'archived',
+				updated_at = NOW(),
+				version = version + 1
+			FROM due d
+			WHERE d.resource_type = 'target' AND t.uuid = d.resource_uuid
+			RETURNING t.uuid
+		),
+		archived_actions AS (
+			UPDATE actions AS a
+			SET status = 'archived',
+				updated_at = NOW(),
+				version = version + 1
+			FROM due d
+			WHERE d.resource_type = 'action' AND a.uuid = d.resource_uuid
+			RETURNING a.uuid
+		),
+		archived_targets_fts AS (
+			UPDATE targets_fts AS fts
+			SET archived = TRUE
+			FROM archived_targets t
+			WHERE fts.target_uuid = t.uuid
+		),
+		archived_actions_fts AS (
+			UPDATE actions_fts AS fts
+			SET archived = TRUE
+			FROM archived_actions a
+			WHERE fts.action_uuid = a.uuid
+		),
+		cleared AS (
+			DELETE FROM archive_notices n
+			WHERE n.resource_uuid IN (
+				SELECT uuid FROM archived_targets
+				UNION ALL
+				SELECT uuid FROM archived_actions
+			)
+		)
+		SELECT (SELECT COUNT(*) FROM archived_targets) + (SELECT COUNT(*) FROM archived_actions);
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var archived int64
+	err := m.DB.QueryRowContext(ctx, query).Scan(&archived)
+	if err != nil {
+		return 0, err
+	}
+
+	return archived, nil
+}
@@ -0,0 +1,88 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// MaxBulkDelete is the maximum number of records deleted by a single bulk deletion.
+const MaxBulkDelete = 100
+
+func ValidateBulkDelete(v *validator.Validator, uuids []uuid.UUID) {
+	v.CheckField(
+		len(uuids) <= MaxBulkDelete,
+		"uuids",
+		validator.TooLong(MaxBulkDelete, validator.UnitItems),
+	)
+	v.CheckField(
+		validator.Unique(uuids),
+		"uuids",
+		validator.Invalid("must not contain duplicates"),
+	)
+	v.CheckField(
+		!validator.PermittedValue(uuid.Nil, uuids...),
+		"uuids",
+		validator.Invalid("must only contain valid uuids"),
+	)
+}
+
+// BulkDelete deletes resources of the given type owned by the user in a single
+// transaction: either every resource is deleted or, when one of them cannot be, none
+// is. Each deletion gives back its quota and can be undone on its own, as if the
+// resources had been deleted one by one. The operations recording the deletions are
+// returned, nil when undo is nil.
+func (m Models) BulkDelete(
+	ctx context.Context,
+	resourceType string,
+	uuids []uuid.UUID,
+	userUUID uuid.UUID,
+	refund *DailyQuota,
+	undo *UndoOperation,
+) ([]*UndoOperation, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var ops []*UndoOperation
+	fn := func(tx *sql.Tx) error {
+		ops = nil
+		for _, id := range uuids {
+			remove := func(ctx context.Context, tx *sql.Tx) error {
+				switch resourceType {
+				case "target":
+					m.Targets.DB = m.observed(tx)
+					return m.Targets.Delete(ctx, id, userUUID)
+				case "action":
+					m.Actions.DB = m.observed(tx)
+					return m.Actions.Delete(ctx, id, userUUID)
+				case "session":
+					m.Sessions.DB = m.observed(tx)
+					return m.Sessions.Delete(ctx, id, userUUID)
+				}
+				return fmt.Errorf("bulk delete: unknown resource type %q", resourceType)
+			}
+
+			var op *UndoOperation
+			if undo != nil {
+				op = &UndoOperation{ResourceType: undo.ResourceType, TTL: undo.TTL}
+			}
+			if err := m.refundAndRemove(ctx, tx, refund, op, id, userUUID, remove); err != nil {
+				return err
+			}
+			if op != nil {
+				ops = append(ops, op)
+			}
+		}
+		return nil
+	}
+
+	if err := m.WithTxRetry(ctx, nil, 3, fn); err != nil {
+		return nil, err
+	}
+
+	return ops, nil
+}
@@ -0,0 +1,235 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// MaxChecklistItems is the number of checklist items an action can have at most.
+const MaxChecklistItems = 100
+
+// ChecklistItem is a step of an action, checked off once done. The items of an action
+// are listed by position, then by creation.
+type ChecklistItem struct {
+	UUID       uuid.UUID `json:"uuid"`
+	ActionUUID uuid.UUID `json:"action_uuid"`
+	Title      string    `json:"title"`
+	Done       bool      `json:"done"`
+	Position   int       `json:"position"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Version    int32     `json:"version"`
+}
+
+func ValidateChecklistItem(v *validator.Validator, item *ChecklistItem) {
+	v.CheckField(item.Title != "", "title", validator.Required())
+	v.CheckField(
+		utf8.RuneCountInString(item.Title) <= 200,
+		"title",
+		validator.TooLong(200, validator.UnitCharacters),
+	)
+	v.CheckField(item.Position >= 0, "position", validator.Invalid("must not be negative"))
+}
+
+type ChecklistItemModel struct {
+	DB DBTX
+}
+
+// checklistTouch bumps the version of the action $1 the checklist items of the changed
+// CTE belong to, so that its cached representations, checklist counts included, are
+// refreshed.
+const checklistTouch = `
+	touched AS (
+		UPDATE actions
+		SET updated_at = NOW(), last_active = NOW(), version = version + 1
+		WHERE uuid = $1 AND EXISTS (SELECT 1 FROM changed)
+	)`
+
+const checklistItemColumns = `
+	uuid, action_uuid, title, done, position, created_at, updated_at, version`
+
+func (i *ChecklistItem) scanArgs() []any {
+	return []any{
+		&i.UUID,
+		&i.ActionUUID,
+		&i.Title,
+		&i.Done,
+		&i.Position,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Version,
+	}
+}
+
+// Insert adds the item to its action, after the last item unless a position is given.
+// ErrLimitReached is returned when the action has MaxChecklistItems items already.
+func (m ChecklistItemModel) Insert(ctx context.Context, item *ChecklistItem, atEnd bool) error {
+	query := `
+		WITH counted AS (
+			SELECT COUNT(*) AS items, COALESCE(MAX(position) + 1, 0) AS next
+			FROM checklist_items
+			WHERE action_uuid = $1
+		),
+		changed AS (
+			INSERT INTO checklist_items (action_uuid, title, done, position)
+			SELECT $1, $2, $3, CASE WHEN $4 THEN next ELSE $5 END
+			FROM counted
+			WHERE items < $6
+			RETURNING` + checklistItemColumns + `
+		),` + checklistTouch + `
+		SELECT` + checklistItemColumns + ` FROM changed
+	`
+
+	args := []any{
+		item.ActionUUID,
+		item.Title,
+		item.Done,
+		atEnd,
+		item.Position,
+		MaxChecklistItems,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(item.scanArgs()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrLimitReached
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get returns the checklist item, whose action the caller must check the access to.
+func (m ChecklistItemModel) Get(ctx context.Context, id uuid.UUID) (*ChecklistItem, error) {
+	query := `SELECT` + checklistItemColumns + ` FROM checklist_items WHERE uuid = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var item ChecklistItem
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(item.scanArgs()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &item, nil
+}
+
+// GetAllForAction returns the checklist items of the action, in order.
+func (m ChecklistItemModel) GetAllForAction(
+	ctx context.Context,
+	actionUUID uuid.UUID,
+) ([]*ChecklistItem, error) {
+	query := `
+		SELECT` + checklistItemColumns + `
+		FROM checklist_items
+		WHERE action_uuid = $1
+		ORDER BY position, uuid
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, actionUUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*ChecklistItem{}
+	for rows.Next() {
+		var item ChecklistItem
+		if err := rows.Scan(item.scanArgs()...); err != nil {
+			return nil, err
+		}
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// Update saves the title, done flag and position of the item. ErrEditConflict is
+// returned when the item was changed or deleted since it was read.
+func (m ChecklistItemModel) Update(ctx context.Context, item *ChecklistItem) error {
+	query := `
+		WITH changed AS (
+			UPDATE checklist_items
+			SET title = $2, done = $3, position = $4, updated_at = NOW(), version = version + 1
+			WHERE action_uuid = $1 AND uuid = $5 AND version = $6
+			RETURNING updated_at, version
+		),` + checklistTouch + `
+		SELECT updated_at, version FROM changed
+	`
+
+	args := []any{
+		item.ActionUUID,
+		item.Title,
+		item.Done,
+		item.Position,
+		item.UUID,
+		item.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&item.UpdatedAt, &item.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes the item from its action.
+func (m ChecklistItemModel) Delete(ctx context.Context, item *ChecklistItem) error {
+	query := `
+		WITH changed AS (
+			DELETE FROM checklist_items
+			WHERE action_uuid = $1 AND uuid = $2
+			RETURNING uuid
+		),` + checklistTouch + `
+		SELECT COUNT(*) FROM changed
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var deleted int
+	err := m.DB.QueryRowContext(ctx, query, item.ActionUUID, item.UUID).Scan(&deleted)
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
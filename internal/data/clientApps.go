@@ -0,0 +1,109 @@
+package data
+
+import (
+	"context"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// ClientApp is an API consumer registered by a user. Its UUID is sent by the client in
+// the X-Client-Id header to attribute its requests in the per-client statistics.
+type ClientApp struct {
+	UUID      uuid.UUID `json:"client_id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func ValidateClientApp(v *validator.Validator, client *ClientApp) {
+	v.CheckField(client.Name != "", "name", validator.Required())
+	v.CheckField(
+		utf8.RuneCountInString(client.Name) <= 80,
+		"name",
+		validator.TooLong(80, validator.UnitCharacters),
+	)
+}
+
+type ClientAppModel struct {
+	DB DBTX
+}
+
+func (m ClientAppModel) Insert(ctx context.Context, client *ClientApp, userUUID uuid.UUID) error {
+	query := `
+		INSERT INTO client_apps (user_uuid, name)
+		VALUES ($1, $2)
+		RETURNING uuid, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, userUUID, client.Name).
+		Scan(&client.UUID, &client.CreatedAt)
+}
+
+func (m ClientAppModel) GetAllForUser(
+	ctx context.Context,
+	userUUID uuid.UUID,
+) ([]*ClientApp, error) {
+	query := `
+		SELECT uuid, name, created_at
+		FROM client_apps
+		WHERE user_uuid = $1
+		ORDER BY created_at, uuid
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userUUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*ClientApp{}
+	for rows.Next() {
+		var client ClientApp
+		if err := rows.Scan(&client.UUID, &client.Name, &client.CreatedAt); err != nil {
+			return nil, err
+		}
+		clients = append(clients, &client)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+// Names returns the name of every registered client, keyed by client UUID.
+func (m ClientAppModel) Names(ctx context.Context) (map[uuid.UUID]string, error) {
+	query := `SELECT uuid, name FROM client_apps`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[uuid.UUID]string)
+	for rows.Next() {
+		var id uuid.UUID
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
@@ -2,6 +2,7 @@ package data
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/gofrs/uuid/v5"
@@ -12,13 +13,15 @@ type DailyQuota struct {
 	Resource  string
 	Usage     int
 	Limit     int
+	// Exempt quotas count the usage without enforcing the limit.
+	Exempt bool
 }
 
 type DailyQuotaModel struct {
 	DB DBTX
 }
 
-func (m DailyQuotaModel) Insert(quota *DailyQuota, userUUID uuid.UUID) error {
+func (m DailyQuotaModel) Insert(ctx context.Context, quota *DailyQuota, userUUID uuid.UUID) error {
 	query := `
 		INSERT INTO daily_quota (user_id, usage_date, resource)
 		VALUES ($1, $2, $3)
@@ -27,7 +30,7 @@ func (m DailyQuotaModel) Insert(quota *DailyQuota, userUUID uuid.UUID) error {
 
 	args := []any{userUUID, quota.UsageDate, quota.Resource}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, args...)
@@ -35,7 +38,11 @@ func (m DailyQuotaModel) Insert(quota *DailyQuota, userUUID uuid.UUID) error {
 	return err
 }
 
-func (m DailyQuotaModel) UpdateLock(quota *DailyQuota, userUUID uuid.UUID) error {
+func (m DailyQuotaModel) UpdateLock(
+	ctx context.Context,
+	quota *DailyQuota,
+	userUUID uuid.UUID,
+) error {
 	query := `
 		SELECT quota_used
 		FROM daily_quota
@@ -44,13 +51,18 @@ func (m DailyQuotaModel) UpdateLock(quota *DailyQuota, userUUID uuid.UUID) error
 	`
 	args := []any{userUUID, quota.UsageDate, quota.Resource}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&quota.Usage)
 }
 
-func (m DailyQuotaModel) Increment(quota *DailyQuota, userUUID uuid.UUID, increment int) error {
+func (m DailyQuotaModel) Increment(
+	ctx context.Context,
+	quota *DailyQuota,
+	userUUID uuid.UUID,
+	increment int,
+) error {
 	query := `
 		UPDATE daily_quota
 		SET quota_used = quota_used + $1
@@ -58,7 +70,144 @@ func (m DailyQuotaModel) Increment(quota *DailyQuota, userUUID uuid.UUID, increm
 	`
 	args := []any{increment, userUUID, quota.UsageDate, quota.Resource}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Check reports a QuotaError when the user already reached the limit of the quota,
+// without counting any usage.
+func (m DailyQuotaModel) Check(ctx context.Context, quota *DailyQuota, userUUID uuid.UUID) error {
+	query := `
+		SELECT COALESCE((
+			SELECT quota_used
+			FROM daily_quota
+			WHERE user_id = $1 AND usage_date = $2 AND resource = $3
+		), 0)
+	`
+	args := []any{userUUID, quota.UsageDate, quota.Resource}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if err := m.DB.QueryRowContext(ctx, query, args...).Scan(&quota.Usage); err != nil {
+		return err
+	}
+	if !quota.Exempt && quota.Usage >= quota.Limit {
+		return &QuotaError{Resource: quota.Resource, Limit: quota.Limit}
+	}
+
+	return nil
+}
+
+// GetAllForDay returns the usage of the quotas of the user on the day of usageDate, by
+// resource. Resources the user did not create that day are left out.
+func (m DailyQuotaModel) GetAllForDay(
+	ctx context.Context,
+	userUUID uuid.UUID,
+	usageDate time.Time,
+) (map[string]int, error) {
+	query := `
+		SELECT resource, quota_used
+		FROM daily_quota
+		WHERE user_id = $1 AND usage_date = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userUUID, usageDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := make(map[string]int)
+	for rows.Next() {
+		var resource string
+		var used int
+		if err := rows.Scan(&resource, &used); err != nil {
+			return nil, err
+		}
+		usage[resource] = used
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
+
+// quotaResourceTables maps the resources counted by the daily quota to their tables.
+var quotaResourceTables = map[string]string{
+	"target":  "targets",
+	"action":  "actions",
+	"session": "sessions",
+}
+
+// Refundable reports whether the resource was created by the user within the usage
+// window of the quota, in which case deleting it gives back the quota it consumed. The
+// creator is the user holding the owner role granted on the resource itself.
+func (m DailyQuotaModel) Refundable(
+	ctx context.Context,
+	quota *DailyQuota,
+	resourceUUID, userUUID uuid.UUID,
+) (bool, error) {
+	table, ok := quotaResourceTables[quota.Resource]
+	if !ok {
+		return false, fmt.Errorf("unknown quota resource %q", quota.Resource)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT EXISTS (
+			SELECT 1
+			FROM %s r
+			JOIN acls ac ON ac.resource_uuid = r.uuid AND ac.resource_type = $2::resource_types
+			WHERE r.uuid = $1
+				AND ac.user_uuid = $3
+				AND ac.role_code = 'owner'
+				AND r.created_at >= $4 AND r.created_at < $5
+		)
+	`, table)
+
+	usageDate := quota.UsageDate.UTC()
+	windowStart := time.Date(
+		usageDate.Year(), usageDate.Month(), usageDate.Day(), 0, 0, 0, 0, time.UTC,
+	)
+	args := []any{
+		resourceUUID,
+		quota.Resource,
+		userUUID,
+		windowStart,
+		windowStart.AddDate(0, 0, 1),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var refundable bool
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&refundable)
+	return refundable, err
+}
+
+// Decrement gives back quota usage, the usage never goes below zero.
+func (m DailyQuotaModel) Decrement(
+	ctx context.Context,
+	quota *DailyQuota,
+	userUUID uuid.UUID,
+	decrement int,
+) error {
+	query := `
+		UPDATE daily_quota
+		SET quota_used = GREATEST(quota_used - $1, 0)
+		WHERE user_id = $2 AND usage_date = $3 AND resource = $4
+	`
+	args := []any{decrement, userUUID, quota.UsageDate, quota.Resource}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, args...)
@@ -0,0 +1,68 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// Device is a combination of IP address, country and user agent a user signed in from.
+type Device struct {
+	UserUUID  uuid.UUID
+	IP        string
+	Country   string
+	UserAgent string
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+func (d Device) fingerprint() []byte {
+	hash := sha256.Sum256([]byte(d.IP + "\x00" + d.Country + "\x00" + d.UserAgent))
+	return hash[:]
+}
+
+type DeviceModel struct {
+	DB DBTX
+}
+
+// Record stores the device a user signed in from, or updates when it was last seen. It
+// reports whether the device is new for a user who had already signed in from other
+// devices, the first device of a user is never reported as new.
+func (m DeviceModel) Record(ctx context.Context, device *Device) (bool, error) {
+	query := `
+		WITH known AS (
+			SELECT COUNT(*) AS devices FROM login_devices WHERE user_uuid = $1
+		),
+		upsert AS (
+			INSERT INTO login_devices (user_uuid, fingerprint, ip, country, user_agent)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (user_uuid, fingerprint) DO UPDATE
+			SET last_seen = NOW()
+			RETURNING first_seen, last_seen, (xmax = 0) AS inserted
+		)
+		SELECT u.first_seen, u.last_seen, u.inserted AND k.devices > 0
+		FROM upsert u, known k
+	`
+
+	args := []any{
+		device.UserUUID,
+		device.fingerprint(),
+		device.IP,
+		device.Country,
+		device.UserAgent,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var isNew bool
+	err := m.DB.QueryRowContext(ctx, query, args...).
+		Scan(&device.FirstSeen, &device.LastSeen, &isNew)
+	if err != nil {
+		return false, err
+	}
+
+	return isNew, nil
+}
@@ -1,8 +1,11 @@
 package data
 
 import (
+	"database/sql"
+	"fmt"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/liuminhaw/yatijapp/internal/validator"
 )
@@ -14,8 +17,30 @@ type Filters struct {
 	SortSafelist   []string
 	Status         []Status
 	StatusSafelist []Status
+	// DueFrom and DueTo bound the due date of the listed records, both inclusive.
+	DueFrom sql.NullTime
+	DueTo   sql.NullTime
+	// HasNotes restricts the listed records to the ones with, or without, notes.
+	HasNotes *bool
+	// Unclassified restricts the listed sessions to the ones attached to neither an
+	// action nor a target.
+	Unclassified bool
+	// Source restricts the listed sessions to the ones created from one of the sources.
+	Source []string
+	// Role restricts the listed records to the ones on which the user has one of the
+	// roles, either granted on the records themselves or inherited from their parents.
+	Role []string
+	// IncludeArchived lists the archived targets and actions along with the other ones.
+	IncludeArchived bool
 }
 
+// RoleFilterSafelist holds the roles the listed records can be filtered on.
+var RoleFilterSafelist = []string{"owner", "editor", "commenter", "viewer"}
+
+// SortRelevance sorts the targets and actions by relevance: their search rank, boosted
+// by their recency and status, the most relevant first.
+const SortRelevance = "relevance"
+
 func (f Filters) sortColumn() string {
 	if slices.Contains(f.SortSafelist, f.Sort) {
 		return strings.TrimPrefix(f.Sort, "-")
@@ -25,12 +50,81 @@ func (f Filters) sortColumn() string {
 }
 
 func (f Filters) sortDirection() string {
-	if strings.HasPrefix(f.Sort, "-") {
+	if strings.HasPrefix(f.Sort, "-") || f.Sort == SortRelevance {
 		return "DESC"
 	}
 	return "ASC"
 }
 
+// orderBy returns the leading terms, each followed by a comma, of the ORDER BY clause on
+// the records aliased as alias. There are none when sorting by relevance, the rank then
+// ordering the records.
+func (f Filters) orderBy(alias string) string {
+	column := f.sortColumn()
+	if f.Sort == SortRelevance {
+		return ""
+	}
+
+	return fmt.Sprintf("%s.%s %s,", alias, column, f.sortDirection())
+}
+
+// recordFilterClause returns the SQL conditions on the due date and notes of the
+// records aliased as alias, with the filter values bound starting at the argument
+// number first. The values are returned by recordFilterArgs in the same order. Due
+// dates are compared on the day they fall on in their own timezone.
+//
+// Archived records are left out unless included, or explicitly filtered on. The
+// condition is written out on the search index of the records, aliased as fts, so that
+// the planner picks the partial indexes covering the records which are not archived.
+func (f Filters) recordFilterClause(alias string, first int) string {
+	clause := fmt.Sprintf(`($%[2]d::date IS NULL OR %[5]s >= $%[2]d::date)
+				AND ($%[3]d::date IS NULL OR %[5]s <= $%[3]d::date)
+				AND ($%[4]d::boolean IS NULL OR (btrim(COALESCE(%[1]s.notes, '')) <> '') = $%[4]d)`,
+		alias, first, first+1, first+2, dueDay(alias),
+	)
+	if !f.IncludeArchived && !slices.Contains(f.Status, StatusArchived) {
+		clause += "\n\t\t\t\tAND NOT fts.archived"
+	}
+
+	return clause
+}
+
+// dueDay returns the SQL expression of the day the due date of the records aliased as
+// alias falls on, in the timezone of the due date.
+func dueDay(alias string) string {
+	return fmt.Sprintf(
+		"(%[1]s.due_date AT TIME ZONE COALESCE(NULLIF(%[1]s.due_timezone, ''), 'UTC'))::date",
+		alias,
+	)
+}
+
+func (f Filters) recordFilterArgs() []any {
+	hasNotes := sql.NullBool{}
+	if f.HasNotes != nil {
+		hasNotes = sql.NullBool{Bool: *f.HasNotes, Valid: true}
+	}
+
+	return []any{f.DueFrom, f.DueTo, hasNotes}
+}
+
+// roleFilterClause returns the SQL condition keeping the records on which the role of
+// the user, bound at the argument number user, is one of the roles bound at the argument
+// number role. The role of the user is the highest one granted on the resources, listed
+// as (resource_type, resource_uuid) rows: the records and their parents.
+func roleFilterClause(user, role int, resources ...string) string {
+	return fmt.Sprintf(`(COALESCE(cardinality($%[2]d::text[]), 0) = 0 OR (
+					SELECT ac.role_code
+					FROM acls ac
+					JOIN roles r ON ac.role_code = r.code
+					WHERE ac.user_uuid = $%[1]d
+					AND (ac.resource_type, ac.resource_uuid) IN (%[3]s)
+					ORDER BY r.rank ASC
+					LIMIT 1
+				) = ANY($%[2]d::text[]))`,
+		user, role, strings.Join(resources, ", "),
+	)
+}
+
 func (f Filters) limit() int {
 	return f.PageSize
 }
@@ -39,41 +133,168 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// MaxOffset is the maximum number of records skipped to reach a page. Deeper pages make
+// the paged queries sort every record they skip, clients are expected to narrow down
+// their filters, or to reverse the sort order, instead.
+const MaxOffset = 10_000
+
+// MaxPageSize is the maximum number of records listed on a page.
+const MaxPageSize = 100
+
+// MaxPage returns the deepest page reachable with the given page size.
+func MaxPage(pageSize int) int {
+	return MaxOffset/max(pageSize, 1) + 1
+}
+
 func ValidateFilters(v *validator.Validator, f Filters) {
-	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
-	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+	v.CheckField(f.Page > 0, "page", validator.TooSmall(1, "must be greater than zero"))
+	v.CheckField(
+		f.Page <= 10_000_000,
+		"page",
+		validator.TooLarge(10_000_000, "must be a maximum of 10 million"),
+	)
+	v.CheckField(f.PageSize > 0, "page_size", validator.TooSmall(1, "must be greater than zero"))
+	v.CheckField(
+		f.PageSize <= MaxPageSize,
+		"page_size",
+		validator.TooLarge(MaxPageSize, fmt.Sprintf("must be a maximum of %d", MaxPageSize)),
+	)
+	if f.PageSize > 0 {
+		maxPage := MaxPage(f.PageSize)
+		v.CheckField(
+			f.Page <= maxPage,
+			"page",
+			validator.TooLarge(maxPage, fmt.Sprintf(
+				"must be a maximum of %d for this page size, narrow down the filters or "+
+					"reverse the sort order to reach further records",
+				maxPage,
+			)),
+		)
+	}
 
-	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	v.CheckField(
+		validator.PermittedValue(f.Sort, f.SortSafelist...),
+		"sort",
+		validator.NotPermitted("invalid sort value"),
+	)
+	if f.DueFrom.Valid && f.DueTo.Valid {
+		v.CheckField(
+			!f.DueTo.Time.Before(f.DueFrom.Time),
+			"due_to",
+			validator.NewFieldError(validator.CodeNotAfter, "must not be before due_from"),
+		)
+	}
 	for _, status := range f.Status {
-		v.Check(
+		v.CheckField(
 			validator.PermittedValue(status, f.StatusSafelist...),
 			"status",
-			"invalid status value",
+			validator.NotPermitted("invalid status value"),
+		)
+	}
+	for _, source := range f.Source {
+		v.CheckField(
+			validator.PermittedValue(source, SessionSourceSafelist...),
+			"source",
+			validator.NotPermitted("invalid source value"),
+		)
+	}
+	for _, role := range f.Role {
+		v.CheckField(
+			validator.PermittedValue(role, RoleFilterSafelist...),
+			"role",
+			validator.NotPermitted("invalid role value"),
 		)
 	}
 }
 
-// Metadata struct holds pagination information
+// Metadata struct holds pagination information, along with the sort order and the
+// filters applied to build the response.
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitzero"`
-	PageSize     int `json:"page_size,omitzero"`
-	FirstPage    int `json:"first_page,omitzero"`
-	LastPage     int `json:"last_page,omitzero"`
-	TotalRecords int `json:"total_records,omitzero"`
+	CurrentPage  int            `json:"current_page,omitzero"`
+	PageSize     int            `json:"page_size,omitzero"`
+	FirstPage    int            `json:"first_page,omitzero"`
+	LastPage     int            `json:"last_page,omitzero"`
+	TotalRecords int            `json:"total_records,omitzero"`
+	Sort         SortInfo       `json:"sort,omitzero"`
+	Filters      AppliedFilters `json:"filters,omitzero"`
+	Links        PageLinks      `json:"links,omitzero"`
 }
 
-func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+// SortInfo describes the order of the records in a list response.
+type SortInfo struct {
+	Field     string `json:"field"`
+	Direction string `json:"direction"` // "asc" or "desc"
+}
+
+// AppliedFilters holds the filters a list response was built with, after defaults
+// have been applied. An empty status list means records of any status are listed.
+type AppliedFilters struct {
+	Search       string   `json:"search"`
+	Status       []Status `json:"status"`
+	DueFrom      string   `json:"due_from,omitzero"`
+	DueTo        string   `json:"due_to,omitzero"`
+	HasNotes     *bool    `json:"has_notes,omitzero"`
+	Unclassified bool     `json:"unclassified,omitzero"`
+	Source       []string `json:"source,omitzero"`
+	Role         []string `json:"role,omitzero"`
+	Page         int      `json:"page"`
+	PageSize     int      `json:"page_size"`
+	// IncludeArchived is set when archived records are listed along with the other ones.
+	IncludeArchived bool `json:"include_archived,omitzero"`
+}
+
+// PageLinks holds the URLs of the pages around the current one, relative to the API
+// host. Next and Prev are omitted on the last and first pages, Last and Next when they are
+// beyond the deepest reachable page.
+type PageLinks struct {
+	Self  string `json:"self"`
+	First string `json:"first,omitzero"`
+	Last  string `json:"last,omitzero"`
+	Next  string `json:"next,omitzero"`
+	Prev  string `json:"prev,omitzero"`
+}
+
+func calculateMetadata(totalRecords int, filters Filters) Metadata {
+	metadata := Metadata{
+		Sort: SortInfo{
+			Field:     strings.TrimPrefix(filters.Sort, "-"),
+			Direction: strings.ToLower(filters.sortDirection()),
+		},
+		Filters: AppliedFilters{
+			Status:          append([]Status{}, filters.Status...),
+			HasNotes:        filters.HasNotes,
+			Unclassified:    filters.Unclassified,
+			Source:          filters.Source,
+			Role:            filters.Role,
+			IncludeArchived: filters.IncludeArchived,
+			Page:            filters.Page,
+			PageSize:        filters.PageSize,
+		},
+	}
+	if filters.DueFrom.Valid {
+		metadata.Filters.DueFrom = filters.DueFrom.Time.Format(time.DateOnly)
+	}
+	if filters.DueTo.Valid {
+		metadata.Filters.DueTo = filters.DueTo.Time.Format(time.DateOnly)
+	}
 	if totalRecords == 0 {
-		return Metadata{}
+		return metadata
 	}
 
-	return Metadata{
-		CurrentPage:  page,
-		PageSize:     pageSize,
-		FirstPage:    1,
-		LastPage:     (totalRecords + pageSize - 1) / pageSize, // Ceiling division
-		TotalRecords: totalRecords,
-	}
+	metadata.CurrentPage = filters.Page
+	metadata.PageSize = filters.PageSize
+	metadata.FirstPage = 1
+	metadata.LastPage = (totalRecords + filters.PageSize - 1) / filters.PageSize // Ceiling division
+	metadata.TotalRecords = totalRecords
+
+	return metadata
+}
+
+// Fingerprint summarizes a filtered collection so that clients polling a list can
+// cheaply revalidate their cached response. Any insert, update or delete within the
+// filter set changes at least one of the fields.
+type Fingerprint struct {
+	TotalRecords int
+	LastUpdated  time.Time
+	ChildCount   int
 }
@@ -0,0 +1,136 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"math/rand/v2"
+	"strings"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// FixtureVolumes sets the maximum number of records generated for a fixture user, each
+// user is given a random number of them, from one to the maximum.
+type FixtureVolumes struct {
+	Targets           int
+	ActionsPerTarget  int
+	SessionsPerAction int
+}
+
+// FixtureCounts counts the records generated for the fixture users.
+type FixtureCounts struct {
+	Users    int
+	Targets  int
+	Actions  int
+	Sessions int
+}
+
+func (c *FixtureCounts) Add(other FixtureCounts) {
+	c.Users += other.Users
+	c.Targets += other.Targets
+	c.Actions += other.Actions
+	c.Sessions += other.Sessions
+}
+
+// fixtureWords are mixed in the texts of the fixtures, so that both the Chinese and the
+// English search vectors are filled like they are for real users.
+var fixtureWords = [][]string{
+	{
+		"準備", "季度", "報告", "整理", "文件", "會議", "學習", "日語", "健身", "計畫",
+		"閱讀", "書籍", "旅行", "預算", "重構", "程式", "測試", "部署", "設計", "訪談",
+		"准备", "学习", "计划", "阅读", "预算", "测试", "设计", "整理", "家務", "採購",
+	},
+	{
+		"prepare", "quarterly", "report", "review", "documents", "meeting", "learn",
+		"japanese", "workout", "plan", "read", "books", "travel", "budget", "refactor",
+		"code", "tests", "deploy", "design", "interview", "garden", "groceries",
+		"invoice", "release", "migration", "notes", "draft", "proposal", "backlog", "sprint",
+	},
+}
+
+// fixtureText returns a random text of the given number of words, mixing Chinese and
+// English. Chinese words are mostly written without spaces, to be segmented.
+func fixtureText(rng *rand.Rand, words int) string {
+	var b strings.Builder
+	for i := range words {
+		lang := rng.IntN(len(fixtureWords))
+		if i > 0 && (lang != 0 || rng.IntN(3) == 0) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(fixtureWords[lang][rng.IntN(len(fixtureWords[lang]))])
+	}
+	return b.String()
+}
+
+// InsertFixtureUser inserts the user, with its password already set, and a random
+// number of targets, actions and sessions up to the volumes, in a single transaction.
+// The records are inserted like the ones of real users, with their ACLs and their full
+// text search vectors, but without consuming any quota.
+func (m Models) InsertFixtureUser(
+	ctx context.Context,
+	user *User,
+	volumes FixtureVolumes,
+	rng *rand.Rand,
+) (FixtureCounts, error) {
+	var counts FixtureCounts
+
+	err := m.WithTx(ctx, nil, func(tx *sql.Tx) error {
+		counts = FixtureCounts{}
+		m.Users.DB = m.observed(tx)
+		m.Targets.DB = m.observed(tx)
+		m.Actions.DB = m.observed(tx)
+		m.Sessions.DB = m.observed(tx)
+
+		if err := m.Users.Insert(ctx, user); err != nil {
+			return err
+		}
+		counts.Users++
+
+		for range 1 + rng.IntN(max(1, volumes.Targets)) {
+			target := &Target{
+				Title:       fixtureText(rng, 2+rng.IntN(4)),
+				Description: fixtureText(rng, rng.IntN(12)),
+				Notes:       fixtureText(rng, rng.IntN(60)),
+				Status:      StatusSafelist[rng.IntN(len(StatusSafelist))],
+			}
+			if err := m.Targets.Insert(ctx, target, user.UUID); err != nil {
+				return err
+			}
+			counts.Targets++
+
+			for range 1 + rng.IntN(max(1, volumes.ActionsPerTarget)) {
+				action := &Action{
+					TargetUUID:  target.UUID,
+					Title:       fixtureText(rng, 2+rng.IntN(4)),
+					Description: fixtureText(rng, rng.IntN(12)),
+					Notes:       fixtureText(rng, rng.IntN(40)),
+					Status:      StatusSafelist[rng.IntN(len(StatusSafelist))],
+				}
+				if err := m.Actions.Insert(ctx, action, user.UUID); err != nil {
+					return err
+				}
+				counts.Actions++
+
+				for range 1 + rng.IntN(max(1, volumes.SessionsPerAction)) {
+					session := &Session{
+						ActionUUID: uuid.NullUUID{UUID: action.UUID, Valid: true},
+						TargetUUID: uuid.NullUUID{UUID: target.UUID, Valid: true},
+						Notes:      fixtureText(rng, rng.IntN(30)),
+						Source:     SourceImport,
+					}
+					if err := m.Sessions.Insert(ctx, session, user.UUID); err != nil {
+						return err
+					}
+					counts.Sessions++
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return FixtureCounts{}, err
+	}
+
+	return counts, nil
+}
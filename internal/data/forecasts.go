@@ -0,0 +1,116 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// TargetForecast rolls up the estimates of the actions of a target still to be done,
+// queued or in progress, and forecasts when they will be completed.
+//
+// RemainingSeconds sums, over the open actions with an estimate, the estimate less the
+// time tracked in their sessions, an action tracked past its estimate counting as none
+// left. Velocity is the number of actions of the target completed per week over the
+// last Weeks weeks. CompletesAt is when the open actions would be completed at that
+// velocity, null when there is none left or nothing was completed lately.
+type TargetForecast struct {
+	OpenActions      int64      `json:"open_actions"`
+	EstimatedActions int64      `json:"estimated_actions"`
+	RemainingSeconds int64      `json:"remaining_seconds"`
+	Weeks            int        `json:"weeks"`
+	CompletedActions int64      `json:"completed_actions"`
+	Velocity         float64    `json:"velocity"`
+	CompletesAt      *time.Time `json:"completes_at"`
+}
+
+// Forecast returns the forecast of a target the user can view, with the velocity taken
+// over the last weeks weeks. Sessions still running are counted up to now.
+func (t TargetModel) Forecast(
+	ctx context.Context,
+	uuid, userUUID uuid.UUID,
+	weeks int,
+) (*TargetForecast, error) {
+	query := `
+		WITH target AS (
+			SELECT t.uuid
+			FROM targets t
+			JOIN acls a ON a.resource_type = 'target' AND a.resource_uuid = t.uuid
+			JOIN roles r ON a.role_code = r.code
+			WHERE t.uuid = $1
+				AND a.user_uuid = $2
+				AND r.rank <= (SELECT rank FROM roles WHERE code = 'viewer')
+		), open AS (
+			SELECT a.uuid, a.estimate_minutes
+			FROM actions a
+			JOIN target t ON a.target_uuid = t.uuid
+			WHERE a.status IN ('queued', 'in progress')
+		)
+		SELECT
+			(SELECT COUNT(*) FROM open),
+			(SELECT COUNT(*) FROM open WHERE estimate_minutes > 0),
+			COALESCE((
+				SELECT SUM(GREATEST(o.estimate_minutes * 60 - COALESCE(s.tracked, 0), 0))
+				FROM open o
+				LEFT JOIN LATERAL (
+					SELECT SUM(EXTRACT(EPOCH FROM COALESCE(s.ends_at, NOW()) - s.starts_at))
+						AS tracked
+					FROM sessions s
+					WHERE s.action_uuid = o.uuid
+				) s ON true
+				WHERE o.estimate_minutes > 0
+			), 0)::bigint,
+			(
+				SELECT COUNT(*)
+				FROM actions a
+				JOIN target t ON a.target_uuid = t.uuid
+				WHERE a.completed_at >= NOW() - make_interval(weeks => $3)
+			)
+		FROM target
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	forecast := TargetForecast{Weeks: weeks}
+	err := t.DB.QueryRowContext(ctx, query, uuid, userUUID, weeks).Scan(
+		&forecast.OpenActions,
+		&forecast.EstimatedActions,
+		&forecast.RemainingSeconds,
+		&forecast.CompletedActions,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	forecast.project(time.Now().UTC())
+
+	return &forecast, nil
+}
+
+// project sets the velocity from the actions completed over the weeks, and the date the
+// open actions would be completed from now at that velocity.
+func (f *TargetForecast) project(now time.Time) {
+	if f.Weeks <= 0 {
+		return
+	}
+
+	velocity := float64(f.CompletedActions) / float64(f.Weeks)
+	f.Velocity = math.Round(velocity*10) / 10
+	if f.OpenActions == 0 || velocity == 0 {
+		return
+	}
+
+	weeks := float64(f.OpenActions) / velocity
+	completesAt := now.Add(time.Duration(weeks * float64(7*24*time.Hour))).Truncate(time.Second)
+	f.CompletesAt = &completesAt
+}
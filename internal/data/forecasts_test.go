@@ -0,0 +1,58 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTargetForecastProject(t *testing.T) {
+	now := time.Date(2026, 10, 5, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		forecast    TargetForecast
+		velocity    float64
+		completesAt time.Time
+	}{
+		{
+			name:        "at velocity",
+			forecast:    TargetForecast{OpenActions: 3, CompletedActions: 8, Weeks: 4},
+			velocity:    2,
+			completesAt: now.Add(36 * 7 * time.Hour),
+		},
+		{
+			name:        "rounded velocity",
+			forecast:    TargetForecast{OpenActions: 1, CompletedActions: 1, Weeks: 3},
+			velocity:    0.3,
+			completesAt: now.Add(3 * 7 * 24 * time.Hour),
+		},
+		{
+			name:     "nothing completed",
+			forecast: TargetForecast{OpenActions: 3, Weeks: 4},
+		},
+		{
+			name:     "nothing left",
+			forecast: TargetForecast{CompletedActions: 2, Weeks: 4},
+			velocity: 0.5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.forecast
+			f.project(now)
+
+			if f.Velocity != tt.velocity {
+				t.Errorf("velocity: got %v, want %v", f.Velocity, tt.velocity)
+			}
+			switch {
+			case tt.completesAt.IsZero() && f.CompletesAt != nil:
+				t.Errorf("completes at: got %v, want none", *f.CompletesAt)
+			case !tt.completesAt.IsZero() && f.CompletesAt == nil:
+				t.Errorf("completes at: got none, want %v", tt.completesAt)
+			case f.CompletesAt != nil && !f.CompletesAt.Equal(tt.completesAt):
+				t.Errorf("completes at: got %v, want %v", *f.CompletesAt, tt.completesAt)
+			}
+		})
+	}
+}
@@ -1,8 +1,16 @@
 package data
 
 import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/liuminhaw/yatijapp/internal/tokenizer"
-	"github.com/yanyiwu/gojieba"
+	"github.com/liuminhaw/yatijapp/internal/validator"
 )
 
 // Full Text Search (FTS) struct type
@@ -17,10 +25,10 @@ type FTS struct {
 	// DescriptionEnglishTSVector string `json:"description_english_tsv"`
 }
 
-func GenFTS(title, description, notes string, jieba *gojieba.Jieba) FTS {
-	titleTokenizer := tokenizer.New(title, jieba)
-	descriptionTokenizer := tokenizer.New(description, jieba)
-	notesTokenizer := tokenizer.New(notes, jieba)
+func GenFTS(title, description, notes string, segmenter tokenizer.Segmenter) FTS {
+	titleTokenizer := tokenizer.New(title, segmenter)
+	descriptionTokenizer := tokenizer.New(description, segmenter)
+	notesTokenizer := tokenizer.New(notes, segmenter)
 
 	return FTS{
 		TitleToken:       titleTokenizer,
@@ -28,3 +36,190 @@ func GenFTS(title, description, notes string, jieba *gojieba.Jieba) FTS {
 		NotesToken:       notesTokenizer,
 	}
 }
+
+// FTSWeightSafelist lists the weights of the lexemes of the search vectors, from the
+// highest to the lowest.
+var FTSWeightSafelist = []string{"A", "B", "C", "D"}
+
+// FTSConfig tunes the full text search of the records. The title and the description of
+// the targets and actions are indexed with their own weights, so changing them requires
+// to reindex the records. The rank weighs the matching lexemes by their weight, with
+// RankWeights given for D, C, B then A, and is normalized by the ts_rank Normalization
+// bit mask. Sorting by relevance blends the rank with the Boost.
+type FTSConfig struct {
+	TitleWeight       string
+	DescriptionWeight string
+	RankWeights       [4]float64
+	Normalization     int
+	Boost             FTSBoost
+}
+
+// FTSBoost is added to the rank of the targets and actions sorted by relevance. Records
+// are boosted by up to Recency the more recently active they are, the boost halving
+// every HalfLife of inactivity, and by the boost of their status.
+type FTSBoost struct {
+	Recency  float64
+	HalfLife time.Duration
+	Status   map[Status]float64
+}
+
+// DefaultFTSConfig is the configuration of the records indexed before it was
+// configurable, with the PostgreSQL default rank weights.
+var DefaultFTSConfig = FTSConfig{
+	TitleWeight:       "A",
+	DescriptionWeight: "B",
+	RankWeights:       [4]float64{0.1, 0.2, 0.4, 1.0},
+	Boost: FTSBoost{
+		Recency:  0.5,
+		HalfLife: 30 * 24 * time.Hour,
+		Status: map[Status]float64{
+			StatusInProgress: 0.3,
+			StatusQueued:     0,
+			StatusComplete:   -0.1,
+			StatusCanceled:   -0.2,
+			StatusArchived:   -0.5,
+		},
+	},
+}
+
+func ValidateFTSConfig(v *validator.Validator, c *FTSConfig) {
+	v.CheckField(
+		validator.PermittedValue(c.TitleWeight, FTSWeightSafelist...),
+		"titleWeight",
+		validator.NotPermitted("must be one of 'A', 'B', 'C' or 'D'"),
+	)
+	v.CheckField(
+		validator.PermittedValue(c.DescriptionWeight, FTSWeightSafelist...),
+		"descriptionWeight",
+		validator.NotPermitted("must be one of 'A', 'B', 'C' or 'D'"),
+	)
+	// The title and description lexemes are told apart by their weight when reindexing.
+	v.CheckField(
+		c.TitleWeight != c.DescriptionWeight,
+		"descriptionWeight",
+		validator.Invalid("must be different from the title weight"),
+	)
+	for _, w := range c.RankWeights {
+		v.CheckField(w >= 0 && w <= 1, "rankWeights", validator.Invalid("must be between 0 and 1"))
+	}
+	v.CheckField(
+		c.Normalization >= 0 && c.Normalization <= 63,
+		"normalization",
+		validator.Invalid("must be a ts_rank bit mask, between 0 and 63"),
+	)
+	v.CheckField(c.Boost.HalfLife >= 0, "boost.halfLife", validator.Invalid("must not be negative"))
+}
+
+func (c *FTSConfig) orDefault() *FTSConfig {
+	if c == nil {
+		return &DefaultFTSConfig
+	}
+	return c
+}
+
+// weighted formats the query indexing the search vectors, where the title and the
+// description weights are referred to as %[1]s and %[2]s.
+func (c *FTSConfig) weighted(query string) string {
+	c = c.orDefault()
+	return fmt.Sprintf(query, c.TitleWeight, c.DescriptionWeight)
+}
+
+// tsRank returns the expression ranking the search vector against the query.
+func (c *FTSConfig) tsRank(vector, query string) string {
+	c = c.orDefault()
+
+	weights := make([]string, len(c.RankWeights))
+	for i, w := range c.RankWeights {
+		weights[i] = strconv.FormatFloat(w, 'g', -1, 32)
+	}
+
+	return fmt.Sprintf(
+		"ts_rank('{%s}', %s, %s, %d)",
+		strings.Join(weights, ","),
+		vector,
+		query,
+		c.Normalization,
+	)
+}
+
+// boost returns the expression, starting with an operator, adding the boost of the
+// records aliased as alias to their rank when they are sorted by relevance.
+func (c *FTSConfig) boost(alias string, filters Filters) string {
+	if filters.Sort != SortRelevance {
+		return ""
+	}
+	b := c.orDefault().Boost
+
+	var expr strings.Builder
+	if b.Recency != 0 && b.HalfLife > 0 {
+		fmt.Fprintf(
+			&expr,
+			" + %g * power(0.5, EXTRACT(EPOCH FROM NOW() - %s.last_active) / %g)",
+			b.Recency,
+			alias,
+			b.HalfLife.Seconds(),
+		)
+	}
+
+	statuses := slices.Sorted(maps.Keys(b.Status))
+	cases := ""
+	for _, status := range statuses {
+		if boost := b.Status[status]; boost != 0 {
+			cases += fmt.Sprintf(" WHEN '%s' THEN %g", status, boost)
+		}
+	}
+	if cases != "" {
+		fmt.Fprintf(&expr, " + (CASE %s.status%s ELSE 0 END)", alias, cases)
+	}
+
+	return expr.String()
+}
+
+// IndexedFTSWeights returns the title and description weights the search vectors of the
+// targets and actions are indexed with.
+func IndexedFTSWeights(ctx context.Context, db DBTX) (title, description string, err error) {
+	query := `SELECT title_weight, description_weight FROM fts_settings`
+
+	err = db.QueryRowContext(ctx, query).Scan(&title, &description)
+	return title, description, err
+}
+
+// ReweightFTS reindexes the search vectors of the targets and actions, indexed with the
+// fromTitle and fromDescription weights, with the weights of the configuration. The
+// title and description lexemes are told apart by their weight. Everything is updated
+// in a single statement, the records written meanwhile wait for it to complete.
+func ReweightFTS(
+	ctx context.Context,
+	db DBTX,
+	fromTitle, fromDescription string,
+	to *FTSConfig,
+) error {
+	reweight := func(vector string) string {
+		return fmt.Sprintf(
+			"setweight(ts_filter(%[1]s, '{%[2]s}'), '%[4]s') || "+
+				"setweight(ts_filter(%[1]s, '{%[3]s}'), '%[5]s')",
+			vector,
+			fromTitle,
+			fromDescription,
+			to.TitleWeight,
+			to.DescriptionWeight,
+		)
+	}
+
+	query := fmt.Sprintf(`
+		WITH reweight_targets AS (
+			UPDATE targets_fts
+			SET fts_chinese_tsv = %[1]s,
+				fts_english_tsv = %[2]s
+		), reweight_actions AS (
+			UPDATE actions_fts
+			SET fts_chinese_tsv = %[1]s,
+				fts_english_tsv = %[2]s
+		)
+		UPDATE fts_settings
+		SET title_weight = $1, description_weight = $2, updated_at = NOW()
+	`, reweight("fts_chinese_tsv"), reweight("fts_english_tsv"))
+
+	_, err := db.ExecContext(ctx, query, to.TitleWeight, to.DescriptionWeight)
+	return err
+}
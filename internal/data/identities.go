@@ -0,0 +1,163 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// Identity providers the users can sign in with.
+const ProviderGoogle = "google"
+
+// ErrActivationRequired is returned when an identity would be linked to a user who has
+// not activated their account yet.
+var ErrActivationRequired = errors.New("account activation required")
+
+// Identity is an account of an identity provider linked to a user, who signs in with it
+// instead of their password. Only accounts with a verified email are linked.
+type Identity struct {
+	Provider   string
+	Subject    string
+	UserUUID   uuid.UUID
+	Email      string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
+
+// IdentityLink is how an identity was linked to a user.
+type IdentityLink int
+
+const (
+	// IdentityLinked links the identity to an activated user.
+	IdentityLinked IdentityLink = iota
+	// IdentityRegistered links the identity to a new user, registered activated.
+	IdentityRegistered
+)
+
+type IdentityModel struct {
+	DB DBTX
+}
+
+// GetUser returns the user the identity is linked to, and records its use along with its
+// current email. ErrRecordNotFound is returned when the identity is not linked yet.
+func (m IdentityModel) GetUser(ctx context.Context, identity *Identity) (*User, error) {
+	query := `
+		WITH used AS (
+			UPDATE identities
+			SET email = $3, last_used_at = NOW()
+			WHERE provider = $1 AND subject = $2
+			RETURNING user_uuid
+		)
+		SELECT u.uuid, u.created_at, u.updated_at, u.name, u.email, u.password_hash,
+			u.activated, u.version
+		FROM users u
+		JOIN used ON used.user_uuid = u.uuid
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	args := []any{identity.Provider, identity.Subject, identity.Email}
+
+	var user User
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.UUID,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	identity.UserUUID = user.UUID
+
+	return &user, nil
+}
+
+// LinkIdentity links the identity to the user with the same email, in a single
+// transaction. Without a user, newUser is registered, activated, with the starter content
+// when given. The user and how the identity was linked are returned.
+//
+// ErrActivationRequired is returned when the user has not activated their account. The
+// identity is not linked to them, as it would let whoever registered the email, with a
+// password of their choice, into the account of the owner of the email.
+func (m Models) LinkIdentity(
+	ctx context.Context,
+	identity *Identity,
+	newUser *User,
+	starter *StarterContent,
+) (*User, IdentityLink, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var user *User
+	var link IdentityLink
+	fn := func(tx *sql.Tx) error {
+		m.Users.DB = m.observed(tx)
+		m.Identities.DB = m.observed(tx)
+
+		var err error
+		user, err = m.Users.GetByEmail(ctx, identity.Email)
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			user, link = newUser, IdentityRegistered
+			user.Activated = false
+			if err := m.Users.Insert(ctx, user); err != nil {
+				return err
+			}
+			if err := m.activateUser(ctx, tx, user, starter); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case !user.Activated:
+			return ErrActivationRequired
+		default:
+			link = IdentityLinked
+		}
+
+		identity.UserUUID = user.UUID
+		return m.Identities.Insert(ctx, identity)
+	}
+
+	if err := m.WithTx(ctx, nil, fn); err != nil {
+		return nil, 0, err
+	}
+
+	return user, link, nil
+}
+
+// Insert links the identity to its user. An identity linked meanwhile is left as it is.
+func (m IdentityModel) Insert(ctx context.Context, identity *Identity) error {
+	query := `
+		INSERT INTO identities (provider, subject, user_uuid, email)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (provider, subject) DO NOTHING
+		RETURNING created_at, last_used_at
+	`
+
+	args := []any{identity.Provider, identity.Subject, identity.UserUUID, identity.Email}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).
+		Scan(&identity.CreatedAt, &identity.LastUsedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	return nil
+}
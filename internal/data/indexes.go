@@ -0,0 +1,93 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// ExpectedIndexes lists, by table, the indexes the queries rely on. A missing one does
+// not break the queries, it turns the lookups into scans of the whole table.
+var ExpectedIndexes = map[string][]string{
+	"acls_targets": {
+		"acls_targets_user_uuid_idx",
+		"acls_targets_resource_uuid_idx",
+		"acls_targets_resource_user_role_idx",
+	},
+	"acls_actions": {
+		"acls_actions_user_uuid_idx",
+		"acls_actions_resource_uuid_idx",
+		"acls_actions_resource_user_role_idx",
+	},
+	"acls_sessions": {
+		"acls_sessions_user_uuid_idx",
+		"acls_sessions_resource_uuid_idx",
+		"acls_sessions_resource_user_role_idx",
+	},
+	"targets_fts": {
+		"targets_fts_chinese_tsv_idx",
+		"targets_fts_english_tsv_idx",
+		"targets_fts_chinese_notes_tsv_idx",
+		"targets_fts_english_notes_tsv_idx",
+	},
+	"actions_fts": {
+		"actions_fts_chinese_tsv_idx",
+		"actions_fts_english_tsv_idx",
+		"actions_fts_chinese_notes_tsv_idx",
+		"actions_fts_english_notes_tsv_idx",
+	},
+	"sessions_fts": {
+		"sessions_fts_chinese_notes_tsv_idx",
+		"sessions_fts_english_notes_tsv_idx",
+	},
+	"actions": {
+		"actions_target_uuid_idx",
+	},
+	"sessions": {
+		"sessions_actions_uuid_idx",
+		"sessions_target_uuid_idx",
+		"sessions_source_idx",
+		"sessions_starts_at_idx",
+	},
+}
+
+// MissingIndexes returns the expected indexes which are not found on their table, as
+// table.index names sorted alphabetically. An index created on another table than the
+// expected one is reported missing.
+func MissingIndexes(ctx context.Context, db DBTX) ([]string, error) {
+	query := `
+	SELECT tablename, indexname
+	FROM pg_indexes
+	WHERE schemaname = current_schema()`
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := make(map[string]bool)
+	for rows.Next() {
+		var table, index string
+		if err := rows.Scan(&table, &index); err != nil {
+			return nil, err
+		}
+		found[table+"."+index] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	missing := []string{}
+	for table, indexes := range ExpectedIndexes {
+		for _, index := range indexes {
+			name := fmt.Sprintf("%s.%s", table, index)
+			if !found[name] {
+				missing = append(missing, name)
+			}
+		}
+	}
+	slices.Sort(missing)
+
+	return missing, nil
+}
@@ -0,0 +1,463 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// Invitation states, as listed to the users. Pending invitations past their expiry are
+// listed as expired.
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+	InvitationExpired  = "expired"
+)
+
+var (
+	InvitationResourceSafelist = []string{"target", "action"}
+	InvitationRoleSafelist     = []string{"editor", "commenter", "viewer"}
+)
+
+// Invitation shares a target or action with someone, by email, with the given role once
+// accepted. The plaintext token is only known when the invitation is created, to be
+// emailed to the invitee.
+type Invitation struct {
+	UUID         uuid.UUID `json:"uuid"`
+	Email        string    `json:"email"`
+	ResourceType string    `json:"resource_type"`
+	ResourceUUID uuid.UUID `json:"resource_uuid"`
+	Title        string    `json:"title"`
+	Role         string    `json:"role"`
+	State        string    `json:"state"`
+	InviterName  string    `json:"inviter_name"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	RespondedAt  NullTime  `json:"responded_at"`
+	CreatedAt    time.Time `json:"created_at"`
+	Token        string    `json:"-"`
+	// Granted is set once the invitation is accepted when its role was granted to the
+	// invitee, who may have had a higher role on the record already.
+	Granted bool `json:"-"`
+}
+
+func ValidateInvitation(v *validator.Validator, invitation *Invitation) {
+	ValidateEmail(v, invitation.Email)
+	v.CheckField(invitation.ResourceType != "", "resource_type", validator.Required())
+	v.CheckField(
+		validator.PermittedValue(invitation.ResourceType, InvitationResourceSafelist...),
+		"resource_type",
+		validator.NotPermitted("must be one of target, action"),
+	)
+	v.CheckField(invitation.ResourceUUID != uuid.Nil, "resource_uuid", validator.Required())
+	v.CheckField(invitation.Role != "", "role", validator.Required())
+	v.CheckField(
+		validator.PermittedValue(invitation.Role, InvitationRoleSafelist...),
+		"role",
+		validator.NotPermitted("must be one of editor, commenter, viewer"),
+	)
+}
+
+// resolve marks the pending invitations past their expiry as expired.
+func (i *Invitation) resolve() {
+	if i.State == InvitationPending && !i.ExpiresAt.After(time.Now()) {
+		i.State = InvitationExpired
+	}
+}
+
+type InvitationModel struct {
+	DB DBTX
+}
+
+// invitationResources joins the invitations, aliased i, with their inviter and the
+// record they share, leaving out the invitations of records which no longer exist.
+const invitationResources = `
+	JOIN users u ON u.uuid = i.inviter_uuid
+	LEFT JOIN targets t ON i.resource_type = 'target' AND t.uuid = i.resource_uuid
+	LEFT JOIN actions a ON i.resource_type = 'action' AND a.uuid = i.resource_uuid
+	WHERE (t.uuid IS NOT NULL OR a.uuid IS NOT NULL)`
+
+const invitationColumns = `
+	i.uuid, i.email, i.resource_type, i.resource_uuid, COALESCE(t.title, a.title),
+	i.role_code, i.state, u.name, i.expires_at, i.responded_at, i.created_at`
+
+func (i *Invitation) scanArgs() []any {
+	return []any{
+		&i.UUID,
+		&i.Email,
+		&i.ResourceType,
+		&i.ResourceUUID,
+		&i.Title,
+		&i.Role,
+		&i.State,
+		&i.InviterName,
+		&i.ExpiresAt,
+		&i.RespondedAt,
+		&i.CreatedAt,
+	}
+}
+
+// invitationAccept grants the role of the invitations in the accepted CTE to the user
+// $2. An existing role is only ever upgraded, so that accepting an invitation never
+// lowers the access of the invitee. The grants are audited as made by the inviters. The
+// accepted invitations are returned with their inviter, title and whether they granted
+// their role, as scanned by acceptedScanArgs.
+const invitationAccept = `
+	previous_acl AS (
+		SELECT ac.resource_type, ac.resource_uuid, ac.role_code
+		FROM acls ac
+		JOIN accepted a ON a.resource_type = ac.resource_type
+			AND a.resource_uuid = ac.resource_uuid
+		WHERE ac.user_uuid = $2
+	),
+	grant_acl AS (
+		INSERT INTO acls (user_uuid, resource_type, resource_uuid, role_code)
+		SELECT $2, resource_type, resource_uuid, role_code FROM accepted
+		ON CONFLICT (user_uuid, resource_type, resource_uuid) DO UPDATE
+		SET role_code = EXCLUDED.role_code
+		WHERE (SELECT rank FROM roles WHERE code = EXCLUDED.role_code) <
+			(SELECT rank FROM roles WHERE code = acls.role_code)
+		RETURNING resource_type, resource_uuid, role_code
+	),
+	audit_acl AS (
+		INSERT INTO acl_audit (
+			resource_type, resource_uuid, actor_uuid, grantee_uuid, change, old_role, new_role
+		)
+		SELECT
+			g.resource_type,
+			g.resource_uuid,
+			a.inviter_uuid,
+			$2,
+			CASE WHEN p.role_code IS NULL THEN 'grant' ELSE 'change' END,
+			p.role_code,
+			g.role_code
+		FROM grant_acl g
+		JOIN accepted a ON a.resource_type = g.resource_type
+			AND a.resource_uuid = g.resource_uuid
+		LEFT JOIN previous_acl p ON p.resource_type = g.resource_type
+			AND p.resource_uuid = g.resource_uuid
+	)
+	SELECT
+		a.uuid,
+		a.resource_type,
+		a.resource_uuid,
+		a.role_code,
+		COALESCE(u.name, ''),
+		COALESCE(t.title, ac.title, ''),
+		EXISTS (
+			SELECT 1 FROM grant_acl g
+			WHERE g.resource_type = a.resource_type AND g.resource_uuid = a.resource_uuid
+		)
+	FROM accepted a
+	LEFT JOIN users u ON u.uuid = a.inviter_uuid
+	LEFT JOIN targets t ON a.resource_type = 'target' AND t.uuid = a.resource_uuid
+	LEFT JOIN actions ac ON a.resource_type = 'action' AND ac.uuid = a.resource_uuid`
+
+func (i *Invitation) acceptedScanArgs() []any {
+	return []any{
+		&i.UUID,
+		&i.ResourceType,
+		&i.ResourceUUID,
+		&i.Role,
+		&i.InviterName,
+		&i.Title,
+		&i.Granted,
+	}
+}
+
+// invitationRespondable matches the pending invitations, aliased i, which have not
+// expired and whose record still exists.
+const invitationRespondable = `
+	i.state = 'pending' AND i.expires_at > NOW() AND CASE i.resource_type
+		WHEN 'target' THEN EXISTS (SELECT 1 FROM targets WHERE uuid = i.resource_uuid)
+		WHEN 'action' THEN EXISTS (SELECT 1 FROM actions WHERE uuid = i.resource_uuid)
+		ELSE false
+	END`
+
+// Insert creates the invitation from the user, valid for ttl, and sets its plaintext
+// token. ErrRecordNotFound is returned when the record does not exist or the user does
+// not own it. A pending invitation of the same email to the same record is replaced.
+func (m InvitationModel) Insert(
+	ctx context.Context,
+	invitation *Invitation,
+	inviterUUID uuid.UUID,
+	ttl time.Duration,
+) error {
+	invitation.Token = rand.Text()
+	hash := sha256.Sum256([]byte(invitation.Token))
+	invitation.ExpiresAt = time.Now().Add(ttl).Truncate(time.Second)
+
+	query := `
+		WITH replaced AS (
+			DELETE FROM invitations
+			WHERE inviter_uuid = $1 AND email = $2 AND resource_type = $3
+				AND resource_uuid = $4 AND state = 'pending'
+		)
+		INSERT INTO invitations (
+			inviter_uuid, email, resource_type, resource_uuid, role_code, token_hash, expires_at
+		)
+		SELECT $1, $2, $3, $4, $5, $6, $7
+		WHERE EXISTS (
+			SELECT 1
+			FROM acls ac
+			JOIN roles r ON ac.role_code = r.code
+			WHERE ac.user_uuid = $1
+				AND r.rank <= (SELECT rank FROM roles WHERE code = 'owner')
+				AND (ac.resource_type, ac.resource_uuid) IN (
+					($3::resource_types, $4),
+					(
+						'target',
+						(SELECT target_uuid FROM actions WHERE $3 = 'action' AND uuid = $4)
+					)
+				)
+		)
+		RETURNING uuid, state, created_at, COALESCE(
+			(SELECT title FROM targets WHERE $3 = 'target' AND uuid = $4),
+			(SELECT title FROM actions WHERE $3 = 'action' AND uuid = $4)
+		)
+	`
+
+	args := []any{
+		inviterUUID,
+		invitation.Email,
+		invitation.ResourceType,
+		invitation.ResourceUUID,
+		invitation.Role,
+		hash[:],
+		invitation.ExpiresAt,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&invitation.UUID,
+		&invitation.State,
+		&invitation.CreatedAt,
+		&invitation.Title,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAllSent returns the invitations sent by the user, the latest first.
+func (m InvitationModel) GetAllSent(
+	ctx context.Context,
+	inviterUUID uuid.UUID,
+) ([]*Invitation, error) {
+	query := `
+		SELECT` + invitationColumns + `
+		FROM invitations i` + invitationResources + `
+		AND i.inviter_uuid = $1
+		ORDER BY i.created_at DESC, i.uuid
+	`
+
+	return m.getAll(ctx, query, inviterUUID)
+}
+
+// GetAllPending returns the pending invitations sent to the email, the latest first.
+func (m InvitationModel) GetAllPending(ctx context.Context, email string) ([]*Invitation, error) {
+	query := `
+		SELECT` + invitationColumns + `
+		FROM invitations i` + invitationResources + `
+		AND i.email = $1 AND i.state = 'pending' AND i.expires_at > NOW()
+		ORDER BY i.created_at DESC, i.uuid
+	`
+
+	return m.getAll(ctx, query, email)
+}
+
+func (m InvitationModel) getAll(ctx context.Context, query string, arg any) ([]*Invitation, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+	for rows.Next() {
+		var invitation Invitation
+		if err := rows.Scan(invitation.scanArgs()...); err != nil {
+			return nil, err
+		}
+
+		invitation.resolve()
+		invitations = append(invitations, &invitation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// Accept accepts the pending invitation with the token, sent to the email of the user,
+// and grants its role to the user. ErrRecordNotFound is returned when there is no such
+// invitation, or it was sent to another email.
+func (m InvitationModel) Accept(
+	ctx context.Context,
+	tokenPlaintext string,
+	user *User,
+) (*Invitation, error) {
+	forgetACLs(ctx)
+
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		WITH accepted AS (
+			UPDATE invitations i
+			SET state = 'accepted', invitee_uuid = $2, responded_at = NOW()
+			WHERE i.token_hash = $1 AND i.email = $3 AND` + invitationRespondable + `
+			RETURNING i.uuid, i.resource_type, i.resource_uuid, i.role_code, i.inviter_uuid
+		), ` + invitationAccept
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var invitation Invitation
+	err := m.DB.QueryRowContext(ctx, query, hash[:], user.UUID, user.Email).
+		Scan(invitation.acceptedScanArgs()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	invitation.State = InvitationAccepted
+	return &invitation, nil
+}
+
+// AcceptAllPending accepts every pending invitation sent to the email of the user, who
+// has just proven to own it, and grants their roles to the user.
+func (m InvitationModel) AcceptAllPending(ctx context.Context, user *User) ([]*Invitation, error) {
+	forgetACLs(ctx)
+
+	query := `
+		WITH accepted AS (
+			UPDATE invitations i
+			SET state = 'accepted', invitee_uuid = $2, responded_at = NOW()
+			WHERE i.email = $1 AND` + invitationRespondable + `
+			RETURNING i.uuid, i.resource_type, i.resource_uuid, i.role_code, i.inviter_uuid
+		), ` + invitationAccept
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, user.Email, user.UUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+	for rows.Next() {
+		invitation := Invitation{State: InvitationAccepted}
+		if err := rows.Scan(invitation.acceptedScanArgs()...); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, &invitation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// Decline declines the pending invitation with the token. The token alone is enough, so
+// that people without an account can decline too.
+func (m InvitationModel) Decline(ctx context.Context, tokenPlaintext string) error {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		UPDATE invitations i
+		SET state = 'declined', responded_at = NOW()
+		WHERE i.token_hash = $1 AND` + invitationRespondable
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, hash[:])
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Delete revokes a pending invitation sent by the user.
+func (m InvitationModel) Delete(ctx context.Context, uuid, inviterUUID uuid.UUID) error {
+	query := `
+		DELETE FROM invitations
+		WHERE uuid = $1 AND inviter_uuid = $2 AND state = 'pending'
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, uuid, inviterUUID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteAllExpired deletes the pending invitations past their expiry, the answered ones
+// are kept for the inviters to review.
+func (m InvitationModel) DeleteAllExpired(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM invitations
+		WHERE state = 'pending' AND expires_at < NOW()
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,69 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"hash/fnv"
+	"time"
+)
+
+// jobLockKey returns the key of the advisory lock held while the job runs.
+func jobLockKey(job string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("yatijapp.job." + job))
+	return int64(h.Sum64())
+}
+
+// RunJob runs the scheduled job unless another instance is running it or ran it within
+// the interval, and reports whether it ran. The job is claimed under its advisory lock,
+// held on a dedicated connection until the job returns. A tenth of the interval is
+// tolerated on the claims, for the tickers of the instances drifting apart.
+func (m Models) RunJob(
+	ctx context.Context,
+	job string,
+	interval time.Duration,
+	instance string,
+	fn func(),
+) (bool, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		observe(ctx, m.breaker, err)
+		return false, err
+	}
+	defer conn.Close()
+	db := observedDB{DBTX: conn, breaker: m.breaker, faults: m.faults}
+
+	key := jobLockKey(job)
+	var locked bool
+	err = db.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked)
+	if err != nil || !locked {
+		return false, err
+	}
+	defer db.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+
+	query := `
+		INSERT INTO scheduled_jobs (name, claimed_at, claimed_by)
+		VALUES ($1, NOW(), $3)
+		ON CONFLICT (name) DO UPDATE SET claimed_at = NOW(), claimed_by = $3
+		WHERE scheduled_jobs.claimed_at <= NOW() - make_interval(secs => $2)
+		RETURNING name`
+
+	elapsed := interval - interval/10
+	err = db.QueryRowContext(ctx, query, job, elapsed.Seconds(), instance).Scan(new(string))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	fn()
+
+	_, err = db.ExecContext(
+		context.Background(),
+		"UPDATE scheduled_jobs SET finished_at = NOW() WHERE name = $1",
+		job,
+	)
+	return true, err
+}
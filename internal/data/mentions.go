@@ -0,0 +1,31 @@
+package data
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// MaxMentions is the number of users a single note can mention.
+const MaxMentions = 10
+
+// ParseMentions returns the email addresses mentioned in a note, as in "thanks
+// @jane@example.com", lowercased and without duplicates. A mention starts a word and
+// ends at the next space, the punctuation closing a sentence being left out.
+func ParseMentions(note string) []string {
+	var mentions []string
+	for field := range strings.FieldsSeq(note) {
+		if len(field) < 2 || field[0] != '@' {
+			continue
+		}
+
+		email := strings.ToLower(strings.TrimRight(field[1:], ".,;:!?)]}\"'"))
+		if !validator.Matches(email, validator.EmailRX) || slices.Contains(mentions, email) {
+			continue
+		}
+		mentions = append(mentions, email)
+	}
+
+	return mentions
+}
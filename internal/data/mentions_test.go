@@ -0,0 +1,33 @@
+package data
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		note string
+		want []string
+	}{
+		{
+			note: "Thanks @jane@example.com, @Bob@Example.com and @jane@example.com!",
+			want: []string{"jane@example.com", "bob@example.com"},
+		},
+		{
+			note: "(cc @jane@example.com)",
+			want: []string{"jane@example.com"},
+		},
+		{
+			note: "mail jane@example.com @home @ @not-an-email",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.note, func(t *testing.T) {
+			if got := ParseMentions(tt.note); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
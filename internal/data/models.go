@@ -3,14 +3,19 @@ package data
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/lib/pq"
-	"github.com/yanyiwu/gojieba"
+	"github.com/liuminhaw/yatijapp/internal/breaker"
+	"github.com/liuminhaw/yatijapp/internal/faults"
+	"github.com/liuminhaw/yatijapp/internal/tokenizer"
+	"github.com/liuminhaw/yatijapp/internal/trace"
 )
 
 // ErrRecordNotFound will be returned when a record is not found in the database.
@@ -18,17 +23,133 @@ var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
 	ErrQuotaExceeded  = errors.New("quota exceeded")
+	// ErrLimitReached is returned when a user already has as many records of a kind,
+	// such as saved searches, as they are allowed to keep.
+	ErrLimitReached = errors.New("limit reached")
+	// ErrNotShared is returned when a record is expected to be shared with a user who
+	// has no role on it.
+	ErrNotShared = errors.New("not shared")
 )
 
+// QuotaError is returned when a daily creation quota is reached, it matches
+// ErrQuotaExceeded.
+type QuotaError struct {
+	Resource string
+	Limit    int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf(
+		"%s creation quota reached (%d per day, renew on midnight UTC)",
+		e.Resource,
+		e.Limit,
+	)
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
 type DBTX interface {
 	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
 	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
 	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
+// observedDB wraps a DBTX and reports the outcome of every statement to the
+// database circuit breaker, and its duration to the trace of the request if any.
+type observedDB struct {
+	DBTX
+	breaker *breaker.Breaker
+	// faults delays and fails the statements on purpose when not nil, in development.
+	faults *faults.Injector
+}
+
+// inject runs the fault injector before a statement. A statement to fail is given an
+// expired context, failing it like a timed out one, the only way to fail a
+// QueryRowContext.
+func (o observedDB) inject(ctx context.Context) (context.Context, context.CancelFunc) {
+	if err := o.faults.Inject(ctx); errors.Is(err, faults.ErrInjected) {
+		return context.WithDeadline(ctx, time.Time{})
+	}
+	return ctx, func() {}
+}
+
+func (o observedDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	start := time.Now()
+	injected, cancel := o.inject(ctx)
+	defer cancel()
+	result, err := o.DBTX.ExecContext(injected, query, args...)
+	trace.FromContext(ctx).Record("db", query, start)
+	observe(ctx, o.breaker, err)
+	return result, err
+}
+
+func (o observedDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	start := time.Now()
+	injected, cancel := o.inject(ctx)
+	defer cancel()
+	rows, err := o.DBTX.QueryContext(injected, query, args...)
+	trace.FromContext(ctx).Record("db", query, start)
+	observe(ctx, o.breaker, err)
+	return rows, err
+}
+
+func (o observedDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	start := time.Now()
+	injected, cancel := o.inject(ctx)
+	defer cancel()
+	row := o.DBTX.QueryRowContext(injected, query, args...)
+	trace.FromContext(ctx).Record("db", query, start)
+	observe(ctx, o.breaker, row.Err())
+	return row
+}
+
+// observe records the outcome of a database call on the breaker. Only errors
+// pointing to an unreachable or overloaded database count as failures, any other
+// outcome proves the database is responsive.
+func observe(ctx context.Context, b *breaker.Breaker, err error) {
+	if b == nil || errors.Is(ctx.Err(), context.Canceled) {
+		// The client went away, this says nothing about the database health.
+		return
+	}
+
+	if err != nil && (errors.Is(ctx.Err(), context.DeadlineExceeded) || isOutageError(err)) {
+		b.Failure()
+		return
+	}
+	b.Success()
+}
+
+// isOutageError reports whether the error is caused by the database being
+// unreachable, overloaded or shutting down.
+func isOutageError(err error) bool {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, sql.ErrConnDone):
+		return true
+	}
+
+	var pqe *pq.Error
+	if errors.As(err, &pqe) {
+		switch pqe.Code.Class() {
+		// connection_exception, insufficient_resources, operator_intervention and
+		// system_error classes.
+		case "08", "53", "57", "58":
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
 func (m Models) WithTx(ctx context.Context, opts *sql.TxOptions, fn func(*sql.Tx) error) error {
 	tx, err := m.db.BeginTx(ctx, opts)
 	if err != nil {
+		observe(ctx, m.breaker, err)
 		return err
 	}
 	defer func() {
@@ -53,6 +174,10 @@ func (m Models) WithTxRetry(
 		if err == nil {
 			return nil
 		}
+		// The caller gave up on the request, retrying would only fail again.
+		if ctx.Err() != nil {
+			return err
+		}
 		var pqe *pq.Error
 		switch {
 		case errors.As(err, &pqe):
@@ -79,64 +204,216 @@ func (m Models) WithTxRetry(
 type Models struct {
 	Targets         TargetModel
 	Actions         ActionModel
+	ChecklistItems  ChecklistItemModel
 	Sessions        SessionModel
 	Tokens          TokenModel
 	Users           UserModel
+	Identities      IdentityModel
 	UserPreferences UserPreferencesModel
 	DailyQuota      DailyQuotaModel
+	ArchiveRules    ArchiveRuleModel
+	Devices         DeviceModel
+	ClientApps      ClientAppModel
+	UndoOperations  UndoModel
+	Reminders       ReminderModel
+	SavedSearches   SavedSearchModel
+	ReportSchedules ReportScheduleModel
+	Trends          TrendModel
+	Plans           PlanModel
+	Retention       RetentionModel
+	Invitations     InvitationModel
+	ACLs            ACLModel
 	db              *sql.DB
 	logger          *slog.Logger
+	breaker         *breaker.Breaker
+	faults          *faults.Injector
 }
 
-// NewModels returns a Models struct containing the initialized TargetModel.
-func NewModels(db *sql.DB, jieba *gojieba.Jieba, logger *slog.Logger) Models {
+// NewModels returns a Models struct containing the initialized TargetModel. The
+// outcome of every database call is reported to the given breaker, which may be nil.
+// Faults are injected into the database calls by the given injector, nil outside of
+// development.
+func NewModels(
+	db *sql.DB,
+	segmenter tokenizer.Segmenter,
+	logger *slog.Logger,
+	dbBreaker *breaker.Breaker,
+	dbFaults *faults.Injector,
+) Models {
+	dbtx := observedDB{DBTX: db, breaker: dbBreaker, faults: dbFaults}
+
 	return Models{
-		Targets:         TargetModel{DB: db, Jieba: jieba, logger: logger},
-		Actions:         ActionModel{DB: db, Jieba: jieba, logger: logger},
-		Sessions:        SessionModel{DB: db, Jieba: jieba},
-		Tokens:          TokenModel{DB: db},
-		Users:           UserModel{DB: db},
-		UserPreferences: UserPreferencesModel{DB: db},
-		DailyQuota:      DailyQuotaModel{DB: db},
+		Targets:         TargetModel{DB: dbtx, Segmenter: segmenter, logger: logger},
+		Actions:         ActionModel{DB: dbtx, Segmenter: segmenter, logger: logger},
+		ChecklistItems:  ChecklistItemModel{DB: dbtx},
+		Sessions:        SessionModel{DB: dbtx, Segmenter: segmenter},
+		Tokens:          TokenModel{DB: dbtx},
+		Users:           UserModel{DB: dbtx},
+		Identities:      IdentityModel{DB: dbtx},
+		UserPreferences: UserPreferencesModel{DB: dbtx},
+		DailyQuota:      DailyQuotaModel{DB: dbtx},
+		ArchiveRules:    ArchiveRuleModel{DB: dbtx},
+		Devices:         DeviceModel{DB: dbtx},
+		ClientApps:      ClientAppModel{DB: dbtx},
+		UndoOperations:  UndoModel{DB: dbtx},
+		Reminders:       ReminderModel{DB: dbtx},
+		SavedSearches:   SavedSearchModel{DB: dbtx},
+		ReportSchedules: ReportScheduleModel{DB: dbtx},
+		Trends:          TrendModel{DB: dbtx},
+		Plans:           PlanModel{DB: dbtx},
+		Retention:       RetentionModel{DB: dbtx},
+		Invitations:     InvitationModel{DB: dbtx},
+		ACLs:            ACLModel{DB: dbtx},
+
+		db:      db,
+		logger:  logger,
+		breaker: dbBreaker,
+		faults:  dbFaults,
+	}
+}
+
+// UseNotesCipher encrypts the notes of the targets, actions and sessions written from now
+// on, and decrypts the encrypted ones on read.
+func (m *Models) UseNotesCipher(cipher *NotesCipher) {
+	m.Targets.Cipher = cipher
+	m.Actions.Cipher = cipher
+	m.Sessions.Cipher = cipher
+}
 
-		db:     db,
-		logger: logger,
+// UseRoles checks the access to the records against the loaded roles, instead of the
+// default ones.
+func (m *Models) UseRoles(roles Roles) {
+	m.ACLs.Roles = roles
+}
+
+// UseFTSConfig indexes and ranks the targets, actions and sessions written and searched
+// from now on with the full text search configuration.
+func (m *Models) UseFTSConfig(fts *FTSConfig) {
+	m.Targets.FTS = fts
+	m.Actions.FTS = fts
+	m.Sessions.FTS = fts
+}
+
+// SchemaVersion returns the version of the last applied database migration and whether
+// it failed halfway, leaving the schema dirty.
+func (m Models) SchemaVersion(ctx context.Context) (int64, bool, error) {
+	var version int64
+	var dirty bool
+	err := m.db.QueryRowContext(
+		ctx,
+		"SELECT version, dirty FROM schema_migrations LIMIT 1",
+	).Scan(&version, &dirty)
+	if err != nil {
+		return 0, false, err
 	}
+
+	return version, dirty, nil
+}
+
+// observed wraps a transaction so that its statements are reported to the breaker.
+func (m Models) observed(tx *sql.Tx) DBTX {
+	return observedDB{DBTX: tx, breaker: m.breaker, faults: m.faults}
 }
 
-func (m Models) CreateTarget(target *Target, quota *DailyQuota, userUUID uuid.UUID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m Models) CreateTarget(
+	ctx context.Context,
+	target *Target,
+	quota *DailyQuota,
+	userUUID uuid.UUID,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	return m.withQuotaTx(ctx, quota, userUUID, func(ctx context.Context, tx *sql.Tx) error {
-		m.Targets.DB = tx
+		m.Targets.DB = m.observed(tx)
 		m.logger.Info("Insert target")
 		return m.Targets.Insert(ctx, target, userUUID)
 	})
 }
 
-func (m Models) CreateAction(action *Action, quota *DailyQuota, userUUID uuid.UUID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m Models) CreateAction(
+	ctx context.Context,
+	action *Action,
+	quota *DailyQuota,
+	userUUID uuid.UUID,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	return m.withQuotaTx(ctx, quota, userUUID, func(ctx context.Context, tx *sql.Tx) error {
-		m.Actions.DB = tx
+		m.Actions.DB = m.observed(tx)
 		m.logger.Info("Insert action")
 		return m.Actions.Insert(ctx, action, userUUID)
 	})
 }
 
-func (m Models) CreateSession(session *Session, quota *DailyQuota, userUUID uuid.UUID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m Models) CreateSession(
+	ctx context.Context,
+	session *Session,
+	quota *DailyQuota,
+	userUUID uuid.UUID,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	return m.withQuotaTx(ctx, quota, userUUID, func(ctx context.Context, tx *sql.Tx) error {
-		m.Sessions.DB = tx
+		m.Sessions.DB = m.observed(tx)
 		m.logger.Info("Insert session")
 		return m.Sessions.Insert(ctx, session, userUUID)
 	})
 }
 
+func (m Models) DeleteTarget(
+	ctx context.Context,
+	uuid, userUUID uuid.UUID,
+	refund *DailyQuota,
+	undo *UndoOperation,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	remove := func(ctx context.Context, tx *sql.Tx) error {
+		m.Targets.DB = m.observed(tx)
+		return m.Targets.Delete(ctx, uuid, userUUID)
+	}
+
+	return m.withQuotaRefundTx(ctx, refund, undo, uuid, userUUID, remove)
+}
+
+func (m Models) DeleteAction(
+	ctx context.Context,
+	uuid, userUUID uuid.UUID,
+	refund *DailyQuota,
+	undo *UndoOperation,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	remove := func(ctx context.Context, tx *sql.Tx) error {
+		m.Actions.DB = m.observed(tx)
+		return m.Actions.Delete(ctx, uuid, userUUID)
+	}
+
+	return m.withQuotaRefundTx(ctx, refund, undo, uuid, userUUID, remove)
+}
+
+func (m Models) DeleteSession(
+	ctx context.Context,
+	uuid, userUUID uuid.UUID,
+	refund *DailyQuota,
+	undo *UndoOperation,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	remove := func(ctx context.Context, tx *sql.Tx) error {
+		m.Sessions.DB = m.observed(tx)
+		return m.Sessions.Delete(ctx, uuid, userUUID)
+	}
+
+	return m.withQuotaRefundTx(ctx, refund, undo, uuid, userUUID, remove)
+}
+
 func (m Models) withQuotaTx(
 	ctx context.Context,
 	quota *DailyQuota,
@@ -144,26 +421,26 @@ func (m Models) withQuotaTx(
 	insert func(ctx context.Context, tx *sql.Tx) error,
 ) error {
 	fn := func(tx *sql.Tx) error {
-		m.DailyQuota.DB = tx
+		m.DailyQuota.DB = m.observed(tx)
 
 		limit := quota.Limit
 
-		if err := m.DailyQuota.Insert(quota, userUUID); err != nil {
+		if err := m.DailyQuota.Insert(ctx, quota, userUUID); err != nil {
 			return err
 		}
 
-		if err := m.DailyQuota.UpdateLock(quota, userUUID); err != nil {
+		if err := m.DailyQuota.UpdateLock(ctx, quota, userUUID); err != nil {
 			return err
 		}
-		if quota.Usage >= limit {
-			return ErrQuotaExceeded
+		if !quota.Exempt && quota.Usage >= limit {
+			return &QuotaError{Resource: quota.Resource, Limit: limit}
 		}
 
 		if err := insert(ctx, tx); err != nil {
 			return err
 		}
 
-		if err := m.DailyQuota.Increment(quota, userUUID, 1); err != nil {
+		if err := m.DailyQuota.Increment(ctx, quota, userUUID, 1); err != nil {
 			return err
 		}
 
@@ -176,3 +453,264 @@ func (m Models) withQuotaTx(
 
 	return nil
 }
+
+// withQuotaRefundTx deletes a resource and, when refund is not nil and the user created
+// the resource within the usage window of refund, gives back the quota it consumed in
+// the same transaction. When undo is not nil, the deleted rows are saved first so that
+// the deletion can be undone.
+func (m Models) withQuotaRefundTx(
+	ctx context.Context,
+	refund *DailyQuota,
+	undo *UndoOperation,
+	resourceUUID, userUUID uuid.UUID,
+	remove func(ctx context.Context, tx *sql.Tx) error,
+) error {
+	fn := func(tx *sql.Tx) error {
+		return m.refundAndRemove(ctx, tx, refund, undo, resourceUUID, userUUID, remove)
+	}
+
+	return m.WithTxRetry(ctx, nil, 3, fn)
+}
+
+// refundAndRemove is the body of withQuotaRefundTx, run within the transaction tx.
+func (m Models) refundAndRemove(
+	ctx context.Context,
+	tx *sql.Tx,
+	refund *DailyQuota,
+	undo *UndoOperation,
+	resourceUUID, userUUID uuid.UUID,
+	remove func(ctx context.Context, tx *sql.Tx) error,
+) error {
+	m.DailyQuota.DB = m.observed(tx)
+
+	refundable := false
+	if refund != nil {
+		var err error
+		refundable, err = m.DailyQuota.Refundable(ctx, refund, resourceUUID, userUUID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if undo != nil {
+		m.UndoOperations.DB = m.observed(tx)
+		undo.ResourceUUID = resourceUUID
+		undo.refundDate = sql.NullTime{}
+		if refundable {
+			undo.refundDate = sql.NullTime{Time: refund.UsageDate, Valid: true}
+		}
+		if err := m.UndoOperations.Record(ctx, undo, userUUID); err != nil {
+			return err
+		}
+	}
+
+	if err := remove(ctx, tx); err != nil {
+		return err
+	}
+
+	if refundable {
+		return m.DailyQuota.Decrement(ctx, refund, userUUID, 1)
+	}
+
+	return nil
+}
+
+// PlanToday returns the plan of the day of the user, rolling the unfinished actions of
+// their latest plan forward when the plan of the day is opened for the first time.
+func (m Models) PlanToday(ctx context.Context, day PlanDay, userUUID uuid.UUID) (*Plan, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if err := m.Plans.Rollover(ctx, day, userUUID); err != nil {
+		return nil, err
+	}
+
+	return m.Plans.Get(ctx, day, userUUID)
+}
+
+// ReplacePlan sets the actions of the plan of the day of the user, leaving the plan
+// untouched when one of the actions cannot be planned.
+func (m Models) ReplacePlan(
+	ctx context.Context,
+	day PlanDay,
+	actionUUIDs []uuid.UUID,
+	userUUID uuid.UUID,
+) (*Plan, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	fn := func(tx *sql.Tx) error {
+		m.Plans.DB = m.observed(tx)
+		return m.Plans.Replace(ctx, day, actionUUIDs, userUUID)
+	}
+
+	if err := m.WithTx(ctx, nil, fn); err != nil {
+		return nil, err
+	}
+
+	return m.Plans.Get(ctx, day, userUUID)
+}
+
+// NotesEntry is a note added to the notes of a record by a user allowed to comment on
+// it, the notes are returned as they read once it is added.
+type NotesEntry struct {
+	ResourceType string    `json:"resource_type"`
+	ResourceUUID uuid.UUID `json:"resource_uuid"`
+	Notes        string    `json:"notes"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Version      int32     `json:"version"`
+	// Title is the title of the record, that of its action for sessions, for the
+	// mentioned users to be told which record the note was added to.
+	Title string `json:"-"`
+}
+
+// notesTables names the table of the records of each resource type, its search index
+// and the column of the index referencing the records.
+var notesTables = map[string][3]string{
+	"target":  {"targets", "targets_fts", "target_uuid"},
+	"action":  {"actions", "actions_fts", "action_uuid"},
+	"session": {"sessions", "sessions_fts", "session_uuid"},
+}
+
+// AppendNotes adds the note, headed by the author and the time, to the end of the notes
+// of the record, for the users who are at least commenters on the record or on one of
+// its parents. Only the notes, and their search index, are changed.
+func (m Models) AppendNotes(
+	ctx context.Context,
+	resourceType string,
+	id uuid.UUID,
+	author string,
+	note string,
+	userUUID uuid.UUID,
+) (*NotesEntry, error) {
+	tables, ok := notesTables[resourceType]
+	if !ok {
+		return nil, fmt.Errorf("append notes: unknown resource type %q", resourceType)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	entry := NotesEntry{ResourceType: resourceType, ResourceUUID: id}
+	fn := func(tx *sql.Tx) error {
+		var (
+			notes     string
+			version   int32
+			cipher    *NotesCipher
+			segmenter tokenizer.Segmenter
+		)
+		switch resourceType {
+		case "target":
+			m.Targets.DB = m.observed(tx)
+			target, err := m.Targets.Get(ctx, id, userUUID, "commenter")
+			if err != nil {
+				return err
+			}
+			notes, version = target.Notes, target.Version
+			entry.Title = target.Title
+			cipher, segmenter = m.Targets.Cipher, m.Targets.Segmenter
+		case "action":
+			m.Actions.DB = m.observed(tx)
+			action, err := m.Actions.Get(ctx, id, userUUID, "commenter")
+			if err != nil {
+				return err
+			}
+			notes, version = action.Notes, action.Version
+			entry.Title = action.Title
+			cipher, segmenter = m.Actions.Cipher, m.Actions.Segmenter
+		case "session":
+			m.Sessions.DB = m.observed(tx)
+			session, err := m.Sessions.Get(ctx, id, userUUID, "commenter")
+			if err != nil {
+				return err
+			}
+			notes, version = session.Notes, session.Version
+			entry.Title = session.ActionTitle
+			cipher, segmenter = m.Sessions.Cipher, m.Sessions.Segmenter
+		}
+
+		heading := fmt.Sprintf("%s, %s:", author, time.Now().UTC().Format("2006-01-02 15:04 MST"))
+		if notes != "" {
+			notes += "\n\n"
+		}
+		entry.Notes = notes + heading + "\n" + note
+
+		sealed, err := cipher.Seal(entry.Notes)
+		if err != nil {
+			return err
+		}
+		token := tokenizer.New(cipher.Searchable(entry.Notes), segmenter)
+
+		query := fmt.Sprintf(`
+			WITH updated AS (
+				UPDATE %[1]s
+				SET notes = $1, updated_at = NOW(), version = version + 1
+				WHERE uuid = $2 AND version = $3
+				RETURNING uuid, updated_at, version
+			), update_fts AS (
+				UPDATE %[2]s AS fts
+				SET fts_chinese_notes_tsv = to_tsvector('simple', $4),
+					fts_english_notes_tsv = to_tsvector('english', $5)
+				FROM updated u
+				WHERE fts.%[3]s = u.uuid
+			)
+			SELECT updated_at, version FROM updated
+		`, tables[0], tables[1], tables[2])
+
+		args := []any{sealed, id, version, token.Chinese, token.English}
+		err = m.observed(tx).QueryRowContext(ctx, query, args...).
+			Scan(&entry.UpdatedAt, &entry.Version)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrEditConflict
+		}
+		return err
+	}
+
+	if err := m.WithTx(ctx, nil, fn); err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// Undo restores the records deleted by an operation of the user which has not expired
+// yet. The daily quota given back by the deletion is consumed again.
+func (m Models) Undo(ctx context.Context, id, userUUID uuid.UUID) (*UndoOperation, error) {
+	forgetACLs(ctx)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var op *UndoOperation
+	fn := func(tx *sql.Tx) error {
+		m.UndoOperations.DB = m.observed(tx)
+		m.DailyQuota.DB = m.observed(tx)
+
+		var snapshot []byte
+		var err error
+		op, snapshot, err = m.UndoOperations.take(ctx, id, userUUID)
+		if err != nil {
+			return err
+		}
+
+		if err := m.UndoOperations.restore(ctx, snapshot); err != nil {
+			return err
+		}
+
+		if op.refundDate.Valid {
+			quota := &DailyQuota{UsageDate: op.refundDate.Time, Resource: op.ResourceType}
+			if err := m.DailyQuota.Insert(ctx, quota, userUUID); err != nil {
+				return err
+			}
+			return m.DailyQuota.Increment(ctx, quota, userUUID, 1)
+		}
+
+		return nil
+	}
+
+	if err := m.WithTx(ctx, nil, fn); err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
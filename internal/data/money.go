@@ -0,0 +1,92 @@
+package data
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/currency"
+)
+
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// ValidCurrency reports whether code is an uppercase ISO 4217 currency code.
+func ValidCurrency(code string) bool {
+	unit, err := currency.ParseISO(code)
+	return err == nil && unit.String() == code
+}
+
+// currencyScale returns the number of decimals of the minor unit of the currency.
+func currencyScale(code string) int {
+	unit, err := currency.ParseISO(code)
+	if err != nil {
+		return 2
+	}
+
+	scale, _ := currency.Standard.Rounding(unit)
+	return scale
+}
+
+// ParseAmount parses a decimal amount of the currency, such as "85.50", into minor
+// units. ErrInvalidAmount is returned for negative amounts and amounts with more
+// decimals than the currency has.
+func ParseAmount(value, code string) (int64, error) {
+	whole, fraction, _ := strings.Cut(strings.TrimSpace(value), ".")
+	scale := currencyScale(code)
+	if whole == "" || len(fraction) > scale || strings.HasPrefix(whole, "-") {
+		return 0, ErrInvalidAmount
+	}
+
+	minor, err := strconv.ParseInt(whole+fraction+strings.Repeat("0", scale-len(fraction)), 10, 64)
+	if err != nil || minor < 0 {
+		return 0, ErrInvalidAmount
+	}
+
+	return minor, nil
+}
+
+// DecimalAmount formats an amount in minor units of the currency as a plain decimal
+// number, such as "1234.50".
+func DecimalAmount(minor int64, code string) string {
+	whole, fraction, sign := splitAmount(minor, code)
+	if fraction == "" {
+		return sign + whole
+	}
+	return sign + whole + "." + fraction
+}
+
+// FormatAmount formats an amount in minor units of the currency, with its thousands
+// separated, such as "1,234.50 EUR".
+func FormatAmount(minor int64, code string) string {
+	whole, fraction, sign := splitAmount(minor, code)
+
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+	if fraction != "" {
+		b.WriteString("." + fraction)
+	}
+
+	return b.String() + " " + code
+}
+
+// splitAmount returns the whole and fractional digits of an amount in minor units of the
+// currency, along with its sign.
+func splitAmount(minor int64, code string) (whole, fraction, sign string) {
+	scale := currencyScale(code)
+
+	if minor < 0 {
+		sign, minor = "-", -minor
+	}
+	digits := strconv.FormatInt(minor, 10)
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+
+	return digits[:len(digits)-scale], digits[len(digits)-scale:], sign
+}
@@ -0,0 +1,93 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// encryptedNotesPrefix marks the notes stored encrypted, the notes written before the
+// encryption was enabled are stored, and read, as is.
+const encryptedNotesPrefix = "enc:v1:"
+
+// NotesCipher encrypts the notes of the targets, actions and sessions at rest with
+// AES-256-GCM. A nil NotesCipher stores the notes in plain text.
+type NotesCipher struct {
+	aead cipher.AEAD
+	// searchable keeps indexing the notes for the full text search, leaking their words
+	// to the search vectors.
+	searchable bool
+}
+
+// NewNotesCipher returns a NotesCipher encrypting with the 32 bytes key.
+func NewNotesCipher(key []byte, searchable bool) (*NotesCipher, error) {
+	if len(key) != 32 {
+		return nil, errors.New("notes encryption key must be 32 bytes long")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NotesCipher{aead: aead, searchable: searchable}, nil
+}
+
+// Seal returns the notes as stored, encrypted unless empty so that the records without
+// notes are still told apart.
+func (c *NotesCipher) Seal(notes string) (string, error) {
+	if c == nil || notes == "" {
+		return notes, nil
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(notes), nil)
+
+	return encryptedNotesPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open returns the plain text of the stored notes.
+func (c *NotesCipher) Open(stored string) (string, error) {
+	encoded, ok := strings.CutPrefix(stored, encryptedNotesPrefix)
+	if !ok {
+		return stored, nil
+	}
+	if c == nil {
+		return "", errors.New("encrypted notes found without a notes encryption key")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < c.aead.NonceSize() {
+		return "", errors.New("encrypted notes too short")
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+
+	notes, err := c.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(notes), nil
+}
+
+// Searchable returns the notes to index for the full text search, none when they are
+// encrypted unless the deployment opted in to index them.
+func (c *NotesCipher) Searchable(notes string) string {
+	if c == nil || c.searchable {
+		return notes
+	}
+	return ""
+}
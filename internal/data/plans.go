@@ -0,0 +1,239 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/lib/pq"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// MaxPlanItems is the number of actions a user can plan for a single day.
+const MaxPlanItems = 10
+
+// Plan is the list of actions a user focuses on during a day, along with the time they
+// tracked on them that day.
+type Plan struct {
+	Date    string     `json:"date"`
+	Items   []PlanItem `json:"items"`
+	Tracked int64      `json:"tracked_seconds"`
+}
+
+// PlanItem is an action of a plan. RolledOver tells that the action was left unfinished
+// in the previous plan and carried over.
+type PlanItem struct {
+	ActionUUID  uuid.UUID `json:"action_uuid"`
+	Title       string    `json:"title"`
+	Status      Status    `json:"status"`
+	TargetUUID  uuid.UUID `json:"target_uuid"`
+	TargetTitle string    `json:"target_title"`
+	RolledOver  bool      `json:"rolled_over"`
+	Tracked     int64     `json:"tracked_seconds"`
+}
+
+// PlanDay is the day of a plan, the tracked time is summed over [From, To).
+type PlanDay struct {
+	Date     time.Time
+	From, To time.Time
+}
+
+func ValidatePlan(v *validator.Validator, actionUUIDs []uuid.UUID) {
+	v.CheckField(
+		len(actionUUIDs) <= MaxPlanItems,
+		"actions",
+		validator.TooLong(MaxPlanItems, validator.UnitItems),
+	)
+	v.CheckField(
+		validator.Unique(actionUUIDs),
+		"actions",
+		validator.Invalid("must not contain duplicates"),
+	)
+	v.CheckField(
+		!validator.PermittedValue(uuid.Nil, actionUUIDs...),
+		"actions",
+		validator.Invalid("must only contain action uuids"),
+	)
+}
+
+type PlanModel struct {
+	DB DBTX
+}
+
+// Rollover opens the plan of the day for the user, if not opened yet, with the actions
+// left queued or in progress in their latest plan.
+func (m PlanModel) Rollover(ctx context.Context, day PlanDay, userUUID uuid.UUID) error {
+	query := `
+		WITH new_plan AS (
+			INSERT INTO daily_plans (user_uuid, plan_date)
+			VALUES ($1, $2)
+			ON CONFLICT (user_uuid, plan_date) DO NOTHING
+			RETURNING plan_date
+		), previous AS (
+			SELECT MAX(plan_date) AS plan_date
+			FROM daily_plans
+			WHERE user_uuid = $1 AND plan_date < $2
+		)
+		INSERT INTO daily_plan_items (user_uuid, plan_date, action_uuid, position, rolled_over)
+		SELECT $1, np.plan_date, i.action_uuid, ROW_NUMBER() OVER (ORDER BY i.position), true
+		FROM new_plan np
+		JOIN daily_plan_items i ON i.user_uuid = $1
+			AND i.plan_date = (SELECT plan_date FROM previous)
+		JOIN actions a ON a.uuid = i.action_uuid
+		WHERE a.status IN ('queued', 'in progress')
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userUUID, day.Date)
+	return err
+}
+
+// Replace sets the actions of the plan of the day, in order. The actions kept from the
+// previous version of the plan keep their rolled over flag. ErrRecordNotFound is returned
+// when the user cannot view one of the actions, the plan must then be rolled back.
+func (m PlanModel) Replace(
+	ctx context.Context,
+	day PlanDay,
+	actionUUIDs []uuid.UUID,
+	userUUID uuid.UUID,
+) error {
+	query := `
+		INSERT INTO daily_plans (user_uuid, plan_date)
+		VALUES ($1, $2)
+		ON CONFLICT (user_uuid, plan_date) DO UPDATE SET updated_at = NOW()
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userUUID, day.Date)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		DELETE FROM daily_plan_items
+		WHERE user_uuid = $1 AND plan_date = $2 AND NOT action_uuid = ANY($3::uuid[])
+	`
+
+	_, err = m.DB.ExecContext(ctx, query, userUUID, day.Date, pq.Array(actionUUIDs))
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO daily_plan_items (user_uuid, plan_date, action_uuid, position)
+		SELECT $1, $2, ids.uuid, ids.position
+		FROM unnest($3::uuid[]) WITH ORDINALITY AS ids(uuid, position)
+		JOIN actions a ON a.uuid = ids.uuid
+		WHERE EXISTS (
+			SELECT 1
+			FROM acls ac
+			JOIN roles r ON ac.role_code = r.code
+			WHERE ac.user_uuid = $1
+			AND r.rank <= (SELECT rank FROM roles WHERE code = 'viewer')
+			AND (ac.resource_type, ac.resource_uuid) IN (
+				('action', a.uuid),
+				('target', a.target_uuid)
+			)
+		)
+		ON CONFLICT (user_uuid, plan_date, action_uuid)
+		DO UPDATE SET position = EXCLUDED.position
+	`
+
+	result, err := m.DB.ExecContext(ctx, query, userUUID, day.Date, pq.Array(actionUUIDs))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != int64(len(actionUUIDs)) {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Get returns the plan of the day of the user, with the actions they can still view.
+// The tracked time counts the sessions the user owns on the planned actions, running
+// ones up to now.
+func (m PlanModel) Get(ctx context.Context, day PlanDay, userUUID uuid.UUID) (*Plan, error) {
+	query := `
+		SELECT
+			a.uuid,
+			a.title,
+			a.status,
+			t.uuid,
+			t.title,
+			i.rolled_over,
+			COALESCE((
+				SELECT SUM(EXTRACT(EPOCH FROM
+					LEAST(COALESCE(s.ends_at, NOW()), $4) - GREATEST(s.starts_at, $3)
+				))::bigint
+				FROM sessions s
+				JOIN acls ac ON ac.resource_type = 'session'
+					AND ac.resource_uuid = s.uuid
+					AND ac.user_uuid = $1
+					AND ac.role_code = 'owner'
+				WHERE s.action_uuid = a.uuid
+					AND s.starts_at < $4
+					AND COALESCE(s.ends_at, NOW()) > $3
+			), 0)
+		FROM daily_plan_items i
+		JOIN actions a ON a.uuid = i.action_uuid
+		JOIN targets t ON t.uuid = a.target_uuid
+		WHERE i.user_uuid = $1 AND i.plan_date = $2 AND EXISTS (
+			SELECT 1
+			FROM acls ac
+			JOIN roles r ON ac.role_code = r.code
+			WHERE ac.user_uuid = $1
+			AND r.rank <= (SELECT rank FROM roles WHERE code = 'viewer')
+			AND (ac.resource_type, ac.resource_uuid) IN (
+				('action', a.uuid),
+				('target', a.target_uuid)
+			)
+		)
+		ORDER BY i.position
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userUUID, day.Date, day.From, day.To)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plan := Plan{Date: day.Date.Format(time.DateOnly), Items: []PlanItem{}}
+	for rows.Next() {
+		var item PlanItem
+		err := rows.Scan(
+			&item.ActionUUID,
+			&item.Title,
+			&item.Status,
+			&item.TargetUUID,
+			&item.TargetTitle,
+			&item.RolledOver,
+			&item.Tracked,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		plan.Tracked += item.Tracked
+		plan.Items = append(plan.Items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &plan, nil
+}
@@ -23,65 +23,201 @@ type filters struct {
 	Session filter `json:"session"`
 }
 
+type statusPreferences struct {
+	// AutoApplyDerived moves a queued target to in progress once one of its actions
+	// starts, instead of only suggesting it with the derived status.
+	AutoApplyDerived bool `json:"autoApplyDerived"`
+}
+
+type reviewPreferences struct {
+	// Prompt asks the user by email to review their targets once they are completed.
+	Prompt bool `json:"prompt"`
+}
+
+type privacyPreferences struct {
+	// HideLastViewed stops recording when the user views the targets shared with them,
+	// for their owners not to see it.
+	HideLastViewed bool `json:"hideLastViewed"`
+}
+
+type notificationPreferences struct {
+	// MuteShared stops the emails telling the user that a record was shared with them.
+	MuteShared bool `json:"muteShared"`
+	// MuteMentions stops the emails telling the user that they were mentioned in a note.
+	MuteMentions bool `json:"muteMentions"`
+}
+
+// Week starts and date formats permitted in the report preferences. Empty values stand
+// for the defaults, weeks starting on Monday and ISO 8601 dates.
+var (
+	WeekStartSafelist  = []string{"", "monday", "sunday"}
+	DateFormatSafelist = []string{"", "YYYY-MM-DD", "DD/MM/YYYY", "MM/DD/YYYY"}
+)
+
+// Rounding steps, in minutes, and modes permitted in the report preferences. A zero step
+// leaves the durations as tracked, an empty mode rounds to the nearest step.
+var (
+	RoundingMinutesSafelist = []int{0, 5, 15, 30}
+	RoundingModeSafelist    = []string{"", "nearest", "up", "down"}
+)
+
+var dateFormatLayouts = map[string]string{
+	"YYYY-MM-DD": time.DateOnly,
+	"DD/MM/YYYY": "02/01/2006",
+	"MM/DD/YYYY": "01/02/2006",
+}
+
+// ReportPreferences sets how the reports, digests and exports sent to the user bucket
+// weeks and format dates.
+type ReportPreferences struct {
+	WeekStart  string   `json:"weekStart"`
+	DateFormat string   `json:"dateFormat"`
+	Rounding   Rounding `json:"rounding"`
+}
+
+// Rounding rounds the tracked durations of reports and exports to a step of Minutes,
+// to the nearest step, up or down as set by Mode.
+type Rounding struct {
+	Minutes int    `json:"minutes"`
+	Mode    string `json:"mode"`
+}
+
+func ValidateRounding(v *validator.Validator, r Rounding, key string) {
+	v.CheckField(
+		validator.PermittedValue(r.Minutes, RoundingMinutesSafelist...),
+		key+".minutes",
+		validator.NotPermitted("must be one of 0, 5, 15 or 30"),
+	)
+	v.CheckField(
+		validator.PermittedValue(r.Mode, RoundingModeSafelist...),
+		key+".mode",
+		validator.NotPermitted("must be one of 'nearest', 'up' or 'down'"),
+	)
+}
+
+// Round returns d rounded according to the policy, unchanged without a step.
+func (r Rounding) Round(d time.Duration) time.Duration {
+	step := time.Duration(r.Minutes) * time.Minute
+	if step <= 0 {
+		return d
+	}
+
+	switch r.Mode {
+	case "up":
+		if rest := d % step; rest != 0 {
+			return d - rest + step
+		}
+		return d
+	case "down":
+		return d.Truncate(step)
+	default:
+		return d.Round(step)
+	}
+}
+
+// WeekStartOf returns the first day of the week containing t, at midnight in the
+// location of t.
+func (p ReportPreferences) WeekStartOf(t time.Time) time.Time {
+	first := time.Monday
+	if p.WeekStart == "sunday" {
+		first = time.Sunday
+	}
+
+	days := (int(t.Weekday()) - int(first) + 7) % 7
+	y, m, d := t.AddDate(0, 0, -days).Date()
+
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// FormatDate formats the date of t in the preferred format.
+func (p ReportPreferences) FormatDate(t time.Time) string {
+	if layout, ok := dateFormatLayouts[p.DateFormat]; ok {
+		return t.Format(layout)
+	}
+
+	return t.Format(time.DateOnly)
+}
+
 type Preferences struct {
-	Filters filters `json:"filters"`
-	Version string  `json:"version"`
+	Filters       filters                 `json:"filters"`
+	Status        statusPreferences       `json:"status"`
+	Reports       ReportPreferences       `json:"reports"`
+	Reviews       reviewPreferences       `json:"reviews"`
+	Privacy       privacyPreferences      `json:"privacy"`
+	Notifications notificationPreferences `json:"notifications"`
+	Version       string                  `json:"version"`
 }
 
 func ValidatePreferences(v *validator.Validator, p *Preferences) {
 	// Preferences version
-	v.Check(p.Version == "2025-12-09", "version", "must be '2025-12-09'")
+	v.CheckField(
+		p.Version == "2025-12-09",
+		"version",
+		validator.NotPermitted("must be '2025-12-09'"),
+	)
 	// SortBy
-	v.Check(
+	v.CheckField(
 		validator.PermittedValue(p.Filters.Target.SortBy, SortSafelist...),
 		"filters.target.sortBy",
-		"not a permitted value",
+		validator.NotPermitted("not a permitted value"),
 	)
-	v.Check(
+	v.CheckField(
 		validator.PermittedValue(p.Filters.Action.SortBy, SortSafelist...),
 		"filters.action.sortBy",
-		"not a permitted value",
+		validator.NotPermitted("not a permitted value"),
 	)
-	v.Check(
+	v.CheckField(
 		validator.PermittedValue(p.Filters.Session.SortBy, SessionSortSafelist...),
 		"filters.session.sortBy",
-		"not a permitted value",
+		validator.NotPermitted("not a permitted value"),
 	)
 	// SortOrder
-	v.Check(
+	v.CheckField(
 		validator.PermittedValue(p.Filters.Target.SortOrder, SortOrderSafelist...),
 		"filters.target.sortOrder",
-		"not a permitted value",
+		validator.NotPermitted("not a permitted value"),
 	)
-	v.Check(
+	v.CheckField(
 		validator.PermittedValue(p.Filters.Action.SortOrder, SortOrderSafelist...),
 		"filters.action.sortOrder",
-		"not a permitted value",
+		validator.NotPermitted("not a permitted value"),
 	)
-	v.Check(
+	v.CheckField(
 		validator.PermittedValue(p.Filters.Session.SortOrder, SortOrderSafelist...),
 		"filters.session.sortOrder",
-		"not a permitted value",
+		validator.NotPermitted("not a permitted value"),
 	)
 	// Status
-	v.Check(p.Filters.Target.Status != nil, "filters.target.status", "must be provided")
-	v.Check(p.Filters.Action.Status != nil, "filters.action.status", "must be provided")
-	v.Check(p.Filters.Session.Status != nil, "filters.session.status", "must be provided")
-	v.Check(
+	v.CheckField(p.Filters.Target.Status != nil, "filters.target.status", validator.Required())
+	v.CheckField(p.Filters.Action.Status != nil, "filters.action.status", validator.Required())
+	v.CheckField(p.Filters.Session.Status != nil, "filters.session.status", validator.Required())
+	v.CheckField(
 		validator.PermittedValues(p.Filters.Target.Status, StatusSafelist...),
 		"filters.target.status",
-		"contains invalid status value",
+		validator.NotPermitted("contains invalid status value"),
 	)
-	v.Check(
+	v.CheckField(
 		validator.PermittedValues(p.Filters.Action.Status, StatusSafelist...),
 		"filters.action.status",
-		"contains invalid status value",
+		validator.NotPermitted("contains invalid status value"),
 	)
-	v.Check(
+	v.CheckField(
 		validator.PermittedValues(p.Filters.Session.Status, SessionStatusSafelist...),
 		"filters.action.status",
-		"contains invalid status value",
+		validator.NotPermitted("contains invalid status value"),
+	)
+	// Reports
+	v.CheckField(
+		validator.PermittedValue(p.Reports.WeekStart, WeekStartSafelist...),
+		"reports.weekStart",
+		validator.NotPermitted("must be 'monday' or 'sunday'"),
+	)
+	v.CheckField(
+		validator.PermittedValue(p.Reports.DateFormat, DateFormatSafelist...),
+		"reports.dateFormat",
+		validator.NotPermitted("not a permitted value"),
 	)
+	ValidateRounding(v, p.Reports.Rounding, "reports.rounding")
 }
 
 type UserPreferences struct {
@@ -94,7 +230,7 @@ type UserPreferencesModel struct {
 }
 
 // func (upm UserPreferencesModel) Get(userUUID uuid.UUID) (*UserPreferences, error) {
-func (upm UserPreferencesModel) Get(userUUID uuid.UUID) (*Preferences, error) {
+func (upm UserPreferencesModel) Get(ctx context.Context, userUUID uuid.UUID) (*Preferences, error) {
 	query := `
 		SELECT preference from preferences
 		WHERE user_uuid = $1
@@ -102,7 +238,7 @@ func (upm UserPreferencesModel) Get(userUUID uuid.UUID) (*Preferences, error) {
 
 	var prefb UserPreferences
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := upm.DB.QueryRowContext(ctx, query, userUUID).Scan(&prefb.Filters)
@@ -124,7 +260,7 @@ func (upm UserPreferencesModel) Get(userUUID uuid.UUID) (*Preferences, error) {
 	return &pref, nil
 }
 
-func (upm UserPreferencesModel) Put(userUUID uuid.UUID, data []byte) error {
+func (upm UserPreferencesModel) Put(ctx context.Context, userUUID uuid.UUID, data []byte) error {
 	query := `
 		INSERT INTO preferences (user_uuid, preference)
 		VALUES ($1, $2::jsonb)
@@ -132,7 +268,7 @@ func (upm UserPreferencesModel) Put(userUUID uuid.UUID, data []byte) error {
 		SET preference = $2
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	// _, err := upm.DB.ExecContext(ctx, query, up.UserUUID, up.Filters)
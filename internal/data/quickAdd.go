@@ -0,0 +1,140 @@
+package data
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// QuickAdd is the interpretation of a line of text capturing an action, such as
+// "Write report +client-x #writing @tomorrow 2h est". The words left once the markers
+// are taken out make the title:
+//
+//   - +reference names the target of the action, by its title, with dashes or
+//     underscores standing for spaces.
+//   - #tag tags the action, any number of times.
+//   - @date sets the due date, in any of the accepted date formats or as a relative
+//     date, with dashes standing for spaces, such as @friday or @in-3-days.
+//   - a duration followed by "est", such as "2h est" or "90m estimate", sets the
+//     estimate.
+//
+// The markers given again where a single one is taken, such as a second @date, are
+// reported as ignored, so that clients can show what was not taken into account.
+type QuickAdd struct {
+	Title           string          `json:"title"`
+	Reference       string          `json:"reference,omitzero"`
+	Tags            []string        `json:"tags,omitzero"`
+	Due             string          `json:"due,omitzero"`
+	DueDate         InputDate       `json:"-"`
+	EstimateMinutes int             `json:"estimate_minutes,omitzero"`
+	Ignored         []QuickAddToken `json:"ignored,omitzero"`
+	// dueErr is set when the due date could not be parsed.
+	dueErr error
+}
+
+// QuickAddToken is a part of a quick add text left out of the action, with the reason.
+type QuickAddToken struct {
+	Token  string `json:"token"`
+	Reason string `json:"reason"`
+}
+
+// estimateMarkers follow a duration to make it an estimate, as in "2h est".
+var estimateMarkers = []string{"est", "estimate", "estimated"}
+
+// ParseQuickAdd interprets a line of text capturing an action.
+func ParseQuickAdd(text string) QuickAdd {
+	var qa QuickAdd
+
+	fields := strings.Fields(text)
+	title := make([]string, 0, len(fields))
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		switch {
+		case len(field) > 1 && field[0] == '+':
+			if qa.Reference != "" {
+				qa.ignore(field, "the target is already named")
+				continue
+			}
+			qa.Reference = normalizeReference(field[1:])
+		case len(field) > 1 && field[0] == '#':
+			qa.Tags = NormalizeTags(append(qa.Tags, field[1:]))
+		case len(field) > 1 && field[0] == '@':
+			if qa.Due != "" {
+				qa.ignore(field, "the due date is already set")
+				continue
+			}
+			qa.Due = field[1:]
+			qa.DueDate, qa.dueErr = ParseInputDate(qa.Due)
+			if qa.dueErr != nil {
+				qa.DueDate, qa.dueErr = ParseInputDate(strings.ReplaceAll(qa.Due, "-", " "))
+			}
+		case i+1 < len(fields) && isEstimate(field, fields[i+1]):
+			if qa.EstimateMinutes != 0 {
+				qa.ignore(field+" "+fields[i+1], "the estimate is already set")
+			} else {
+				d, _ := time.ParseDuration(field)
+				qa.EstimateMinutes = int(d.Round(time.Minute).Minutes())
+			}
+			i++
+		case strings.HasPrefix(field, `\+`) ||
+			strings.HasPrefix(field, `\#`) ||
+			strings.HasPrefix(field, `\@`):
+			// Escaped markers are part of the title.
+			title = append(title, field[1:])
+		default:
+			title = append(title, field)
+		}
+	}
+	qa.Title = strings.Join(title, " ")
+
+	return qa
+}
+
+func (qa *QuickAdd) ignore(token, reason string) {
+	qa.Ignored = append(qa.Ignored, QuickAddToken{Token: token, Reason: reason})
+}
+
+// isEstimate reports whether the field is a duration of at least a minute, such as 2h
+// or 30m, followed by an estimate marker.
+func isEstimate(field, next string) bool {
+	d, err := time.ParseDuration(field)
+	if err != nil || d < time.Minute {
+		return false
+	}
+
+	next = strings.ToLower(strings.TrimRight(next, ".,;"))
+	for _, marker := range estimateMarkers {
+		if next == marker {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeReference returns the target reference in lower case, with its dashes and
+// underscores as spaces.
+func normalizeReference(reference string) string {
+	reference = strings.NewReplacer("-", " ", "_", " ").Replace(reference)
+	return strings.Join(strings.Fields(strings.ToLower(reference)), " ")
+}
+
+func ValidateQuickAdd(v *validator.Validator, text string, qa *QuickAdd) {
+	v.CheckField(strings.TrimSpace(text) != "", "text", validator.Required())
+	v.CheckField(
+		utf8.RuneCountInString(text) <= 500,
+		"text",
+		validator.TooLong(500, validator.UnitCharacters),
+	)
+	v.CheckField(
+		strings.TrimSpace(text) == "" || qa.Title != "",
+		"text",
+		validator.Invalid("must contain a title besides the +reference, #tags and @date"),
+	)
+	v.CheckField(
+		qa.dueErr == nil,
+		"text",
+		validator.InvalidFormat("@"+qa.Due+" is not a recognized date"),
+	)
+}
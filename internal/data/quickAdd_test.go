@@ -0,0 +1,79 @@
+package data
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseQuickAdd(t *testing.T) {
+	tests := []struct {
+		text      string
+		title     string
+		reference string
+		tags      []string
+		due       string
+		estimate  int
+		ignored   []string
+	}{
+		{
+			text:      "Write report +client-x #Writing #q4 @tomorrow 2h est",
+			title:     "Write report",
+			reference: "client x",
+			tags:      []string{"writing", "q4"},
+			due:       "tomorrow",
+			estimate:  120,
+		},
+		{
+			text:     "Call back #phone #PHONE 90m estimate",
+			title:    "Call back",
+			tags:     []string{"phone"},
+			estimate: 90,
+		},
+		{
+			text:      "Review +a +b @friday @monday 1h est 2h est",
+			title:     "Review",
+			reference: "a",
+			due:       "friday",
+			estimate:  60,
+			ignored:   []string{"+b", "@monday", "2h est"},
+		},
+		{
+			text:  `Fix \#42 and \+1 \@home 30s est`,
+			title: "Fix #42 and +1 @home 30s est",
+		},
+		{
+			text:  "Plan 2h trip",
+			title: "Plan 2h trip",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			qa := ParseQuickAdd(tt.text)
+
+			if qa.Title != tt.title {
+				t.Errorf("title: got %q, want %q", qa.Title, tt.title)
+			}
+			if qa.Reference != tt.reference {
+				t.Errorf("reference: got %q, want %q", qa.Reference, tt.reference)
+			}
+			if !slices.Equal(qa.Tags, tt.tags) {
+				t.Errorf("tags: got %q, want %q", qa.Tags, tt.tags)
+			}
+			if qa.Due != tt.due {
+				t.Errorf("due: got %q, want %q", qa.Due, tt.due)
+			}
+			if qa.EstimateMinutes != tt.estimate {
+				t.Errorf("estimate: got %d, want %d", qa.EstimateMinutes, tt.estimate)
+			}
+
+			var ignored []string
+			for _, token := range qa.Ignored {
+				ignored = append(ignored, token.Token)
+			}
+			if !slices.Equal(ignored, tt.ignored) {
+				t.Errorf("ignored: got %q, want %q", ignored, tt.ignored)
+			}
+		})
+	}
+}
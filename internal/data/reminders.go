@@ -0,0 +1,378 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// Reminder states, as listed to the users.
+const (
+	ReminderPending = "pending"
+	ReminderSnoozed = "snoozed"
+	ReminderSent    = "sent"
+)
+
+// Reminder is emailed to a user at RemindAt about one of the targets or actions they can
+// access. A snoozed reminder fires at SnoozedUntil instead, a sent one can be snoozed or
+// rescheduled to fire again.
+type Reminder struct {
+	UUID         uuid.UUID `json:"uuid"`
+	ResourceType string    `json:"resource_type"`
+	ResourceUUID uuid.UUID `json:"resource_uuid"`
+	Title        string    `json:"title"`
+	RemindAt     time.Time `json:"remind_at"`
+	Timezone     string    `json:"timezone"` // IANA timezone of RemindAt, UTC when empty
+	SnoozedUntil NullTime  `json:"snoozed_until"`
+	SentAt       NullTime  `json:"sent_at"`
+	State        string    `json:"state"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Version      int32     `json:"version"`
+}
+
+var ReminderResourceSafelist = []string{"target", "action"}
+
+func ValidateReminder(v *validator.Validator, reminder *Reminder) {
+	v.CheckField(reminder.ResourceType != "", "resource_type", validator.Required())
+	v.CheckField(
+		validator.PermittedValue(reminder.ResourceType, ReminderResourceSafelist...),
+		"resource_type",
+		validator.NotPermitted("must be one of target, action"),
+	)
+	v.CheckField(reminder.ResourceUUID != uuid.Nil, "resource_uuid", validator.Required())
+	v.CheckField(!reminder.RemindAt.IsZero(), "remind_at", validator.Required())
+	v.CheckField(
+		reminder.RemindAt.IsZero() || reminder.RemindAt.After(time.Now()),
+		"remind_at",
+		validator.NewFieldError(validator.CodeNotInFuture, "must be in the future"),
+	)
+	v.CheckField(
+		ValidTimezone(reminder.Timezone),
+		"timezone",
+		validator.NotPermitted("must be an IANA timezone name, such as 'Asia/Taipei'"),
+	)
+}
+
+// Snooze postpones the reminder until d from now, it fires again then even if it was
+// already sent.
+func (r *Reminder) Snooze(d time.Duration) {
+	r.SnoozedUntil = NewNullTime(time.Now().Add(d).Truncate(time.Second))
+	r.SentAt = NullTime{}
+	r.resolve()
+}
+
+// Reschedule moves the reminder to at, dropping its snooze and sent states.
+func (r *Reminder) Reschedule(at time.Time, timezone string) {
+	r.RemindAt = at
+	r.Timezone = timezone
+	r.SnoozedUntil = NullTime{}
+	r.SentAt = NullTime{}
+	r.resolve()
+}
+
+// FiresAt returns when the reminder fires, taking its snooze into account.
+func (r *Reminder) FiresAt() time.Time {
+	if r.SnoozedUntil.Valid {
+		return r.SnoozedUntil.Time
+	}
+	return r.RemindAt
+}
+
+// resolve sets the state of the reminder and renders its times in its timezone.
+func (r *Reminder) resolve() {
+	loc := location(r.Timezone)
+	r.RemindAt = r.RemindAt.In(loc)
+	r.SnoozedUntil = r.SnoozedUntil.In(r.Timezone)
+	r.SentAt = r.SentAt.In(r.Timezone)
+
+	switch {
+	case r.SentAt.Valid:
+		r.State = ReminderSent
+	case r.SnoozedUntil.Valid:
+		r.State = ReminderSnoozed
+	default:
+		r.State = ReminderPending
+	}
+}
+
+// ReminderNotice is a reminder due to be emailed to its user.
+type ReminderNotice struct {
+	Reminder
+	UserUUID uuid.UUID
+	Email    string
+	Name     string
+}
+
+type ReminderModel struct {
+	DB DBTX
+}
+
+// reminderResources joins the reminders, aliased r, with the record they are about,
+// leaving out the reminders of records which no longer exist or which their user can no
+// longer access.
+const reminderResources = `
+	LEFT JOIN targets t ON r.resource_type = 'target' AND t.uuid = r.resource_uuid
+	LEFT JOIN actions a ON r.resource_type = 'action' AND a.uuid = r.resource_uuid
+	WHERE (t.uuid IS NOT NULL OR a.uuid IS NOT NULL) AND EXISTS (
+		SELECT 1
+		FROM acls ac
+		WHERE ac.user_uuid = r.user_uuid
+			AND (ac.resource_type, ac.resource_uuid) IN (
+				(r.resource_type, r.resource_uuid),
+				('target', a.target_uuid)
+			)
+	)`
+
+const reminderColumns = `
+	r.uuid, r.resource_type, r.resource_uuid, COALESCE(t.title, a.title), r.remind_at,
+	r.timezone, r.snoozed_until, r.sent_at, r.created_at, r.updated_at, r.version`
+
+func (r *Reminder) scanArgs() []any {
+	return []any{
+		&r.UUID,
+		&r.ResourceType,
+		&r.ResourceUUID,
+		&r.Title,
+		&r.RemindAt,
+		&r.Timezone,
+		&r.SnoozedUntil,
+		&r.SentAt,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+		&r.Version,
+	}
+}
+
+// Insert creates the reminder for the user, ErrRecordNotFound is returned when the record
+// it is about does not exist or the user cannot access it.
+func (m ReminderModel) Insert(ctx context.Context, reminder *Reminder, userUUID uuid.UUID) error {
+	query := `
+		INSERT INTO reminders (user_uuid, resource_type, resource_uuid, remind_at, timezone)
+		SELECT $1, $2, $3, $4, $5
+		WHERE EXISTS (
+			SELECT 1
+			FROM acls ac
+			WHERE ac.user_uuid = $1
+				AND (ac.resource_type, ac.resource_uuid) IN (
+					($2::resource_types, $3),
+					(
+						'target',
+						(SELECT target_uuid FROM actions WHERE $2 = 'action' AND uuid = $3)
+					)
+				)
+		)
+		RETURNING uuid, created_at, updated_at, version
+	`
+
+	args := []any{
+		userUUID,
+		reminder.ResourceType,
+		reminder.ResourceUUID,
+		reminder.RemindAt,
+		reminder.Timezone,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).
+		Scan(&reminder.UUID, &reminder.CreatedAt, &reminder.UpdatedAt, &reminder.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	reminder.resolve()
+	return nil
+}
+
+func (m ReminderModel) Get(ctx context.Context, uuid, userUUID uuid.UUID) (*Reminder, error) {
+	query := `
+		SELECT` + reminderColumns + `
+		FROM reminders r` + reminderResources + `
+		AND r.uuid = $1 AND r.user_uuid = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var reminder Reminder
+	err := m.DB.QueryRowContext(ctx, query, uuid, userUUID).Scan(reminder.scanArgs()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	reminder.resolve()
+	return &reminder, nil
+}
+
+// GetAllForUser returns the reminders of the user, the next to fire first.
+func (m ReminderModel) GetAllForUser(ctx context.Context, userUUID uuid.UUID) ([]*Reminder, error) {
+	query := `
+		SELECT` + reminderColumns + `
+		FROM reminders r` + reminderResources + `
+		AND r.user_uuid = $1
+		ORDER BY r.sent_at IS NOT NULL, COALESCE(r.snoozed_until, r.remind_at), r.uuid
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userUUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reminders := []*Reminder{}
+	for rows.Next() {
+		var reminder Reminder
+		if err := rows.Scan(reminder.scanArgs()...); err != nil {
+			return nil, err
+		}
+
+		reminder.resolve()
+		reminders = append(reminders, &reminder)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reminders, nil
+}
+
+// Update saves the schedule of the reminder, as changed by Snooze or Reschedule.
+func (m ReminderModel) Update(ctx context.Context, reminder *Reminder, userUUID uuid.UUID) error {
+	query := `
+		UPDATE reminders
+		SET remind_at = $1,
+			timezone = $2,
+			snoozed_until = $3,
+			sent_at = $4,
+			updated_at = NOW(),
+			version = version + 1
+		WHERE uuid = $5 AND user_uuid = $6 AND version = $7
+		RETURNING updated_at, version
+	`
+
+	args := []any{
+		reminder.RemindAt,
+		reminder.Timezone,
+		reminder.SnoozedUntil,
+		reminder.SentAt,
+		reminder.UUID,
+		userUUID,
+		reminder.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&reminder.UpdatedAt, &reminder.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m ReminderModel) Delete(ctx context.Context, uuid, userUUID uuid.UUID) error {
+	query := `
+		DELETE FROM reminders
+		WHERE uuid = $1 AND user_uuid = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, uuid, userUUID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Due returns the reminders not sent yet whose time, or snooze, has come, for the
+// activated users.
+func (m ReminderModel) Due(ctx context.Context) ([]ReminderNotice, error) {
+	query := `
+		SELECT` + reminderColumns + `, u.uuid, u.email, u.name
+		FROM reminders r
+		JOIN users u ON u.uuid = r.user_uuid AND u.activated` + reminderResources + `
+		AND r.sent_at IS NULL AND COALESCE(r.snoozed_until, r.remind_at) <= NOW()
+		ORDER BY COALESCE(r.snoozed_until, r.remind_at)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notices := []ReminderNotice{}
+	for rows.Next() {
+		var notice ReminderNotice
+		args := append(notice.scanArgs(), &notice.UserUUID, &notice.Email, &notice.Name)
+		if err := rows.Scan(args...); err != nil {
+			return nil, err
+		}
+
+		notice.resolve()
+		notices = append(notices, notice)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return notices, nil
+}
+
+// MarkSent records that the reminder has been emailed, unless it has been snoozed or
+// rescheduled since it was listed as due.
+func (m ReminderModel) MarkSent(ctx context.Context, reminder *Reminder) error {
+	query := `
+		UPDATE reminders
+		SET sent_at = NOW()
+		WHERE uuid = $1 AND version = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, reminder.UUID, reminder.Version)
+	return err
+}
@@ -0,0 +1,332 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// Report schedule frequencies, how often the report of the past period is sent.
+const (
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+)
+
+var ReportFrequencySafelist = []string{FrequencyWeekly, FrequencyMonthly}
+
+// ReportSchedule sends its user the report of their sessions of the past week, or month,
+// by email or through a webhook. Weekly reports are sent at the start of the week the
+// user prefers, monthly ones on the first of the month, both at midnight UTC.
+type ReportSchedule struct {
+	UUID       uuid.UUID `json:"uuid"`
+	Frequency  string    `json:"frequency"`
+	Delivery   string    `json:"delivery"`
+	WebhookURL string    `json:"webhook_url,omitzero"`
+	// NextRunAt is when the next report is sent, the end of the period it covers.
+	NextRunAt time.Time `json:"next_run_at"`
+	LastRunAt NullTime  `json:"last_run_at"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateReportSchedule(v *validator.Validator, schedule *ReportSchedule) {
+	v.CheckField(schedule.Frequency != "", "frequency", validator.Required())
+	v.CheckField(
+		validator.PermittedValue(schedule.Frequency, ReportFrequencySafelist...),
+		"frequency",
+		validator.NotPermitted("must be one of weekly, monthly"),
+	)
+	validateDelivery(v, schedule.Delivery, schedule.WebhookURL)
+}
+
+// Schedule sets the next run of the schedule to the end of the period containing now.
+func (s *ReportSchedule) Schedule(prefs ReportPreferences, now time.Time) {
+	now = now.UTC()
+	switch s.Frequency {
+	case FrequencyMonthly:
+		s.NextRunAt = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).
+			AddDate(0, 1, 0)
+	default:
+		s.NextRunAt = prefs.WeekStartOf(now).AddDate(0, 0, 7)
+	}
+}
+
+// Period returns the period the next report of the schedule covers, ending on its next
+// run.
+func (s *ReportSchedule) Period() (from, to time.Time) {
+	to = s.NextRunAt.UTC()
+	switch s.Frequency {
+	case FrequencyMonthly:
+		return to.AddDate(0, -1, 0), to
+	default:
+		return to.AddDate(0, 0, -7), to
+	}
+}
+
+// ReportScheduleRun is a report schedule due to be run for its user.
+type ReportScheduleRun struct {
+	ReportSchedule
+	UserUUID uuid.UUID
+	Email    string
+	Username string
+}
+
+type ReportScheduleModel struct {
+	DB DBTX
+}
+
+const reportScheduleColumns = `
+	s.uuid, s.frequency, s.delivery, s.webhook_url, s.next_run_at, s.last_run_at,
+	s.created_at, s.updated_at, s.version`
+
+// scan reads the columns of reportScheduleColumns, followed by the extra destinations.
+func (s *ReportSchedule) scan(row interface{ Scan(...any) error }, extra ...any) error {
+	dest := []any{
+		&s.UUID,
+		&s.Frequency,
+		&s.Delivery,
+		&s.WebhookURL,
+		&s.NextRunAt,
+		&s.LastRunAt,
+		&s.CreatedAt,
+		&s.UpdatedAt,
+		&s.Version,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// Insert creates the report schedule for the user, unless they already have limit
+// schedules, in which case ErrLimitReached is returned.
+func (m ReportScheduleModel) Insert(
+	ctx context.Context,
+	schedule *ReportSchedule,
+	userUUID uuid.UUID,
+	limit int,
+) error {
+	query := `
+		INSERT INTO report_schedules (user_uuid, frequency, delivery, webhook_url, next_run_at)
+		SELECT $1, $2, $3, $4, $5
+		WHERE (SELECT COUNT(*) FROM report_schedules WHERE user_uuid = $1) < $6
+		RETURNING uuid, created_at, updated_at, version
+	`
+
+	args := []any{
+		userUUID,
+		schedule.Frequency,
+		schedule.Delivery,
+		schedule.WebhookURL,
+		schedule.NextRunAt,
+		limit,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).
+		Scan(&schedule.UUID, &schedule.CreatedAt, &schedule.UpdatedAt, &schedule.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrLimitReached
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m ReportScheduleModel) Get(
+	ctx context.Context,
+	uuid, userUUID uuid.UUID,
+) (*ReportSchedule, error) {
+	query := `
+		SELECT` + reportScheduleColumns + `
+		FROM report_schedules s
+		WHERE s.uuid = $1 AND s.user_uuid = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var schedule ReportSchedule
+	err := schedule.scan(m.DB.QueryRowContext(ctx, query, uuid, userUUID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &schedule, nil
+}
+
+// GetAllForUser returns the report schedules of the user, the next to run first.
+func (m ReportScheduleModel) GetAllForUser(
+	ctx context.Context,
+	userUUID uuid.UUID,
+) ([]*ReportSchedule, error) {
+	query := `
+		SELECT` + reportScheduleColumns + `
+		FROM report_schedules s
+		WHERE s.user_uuid = $1
+		ORDER BY s.next_run_at, s.uuid
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userUUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []*ReportSchedule{}
+	for rows.Next() {
+		var schedule ReportSchedule
+		if err := schedule.scan(rows); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, &schedule)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+// Update saves the frequency, the delivery and the next run of the report schedule.
+func (m ReportScheduleModel) Update(
+	ctx context.Context,
+	schedule *ReportSchedule,
+	userUUID uuid.UUID,
+) error {
+	query := `
+		UPDATE report_schedules
+		SET frequency = $1,
+			delivery = $2,
+			webhook_url = $3,
+			next_run_at = $4,
+			updated_at = NOW(),
+			version = version + 1
+		WHERE uuid = $5 AND user_uuid = $6 AND version = $7
+		RETURNING updated_at, version
+	`
+
+	args := []any{
+		schedule.Frequency,
+		schedule.Delivery,
+		schedule.WebhookURL,
+		schedule.NextRunAt,
+		schedule.UUID,
+		userUUID,
+		schedule.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).
+		Scan(&schedule.UpdatedAt, &schedule.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m ReportScheduleModel) Delete(ctx context.Context, uuid, userUUID uuid.UUID) error {
+	query := `
+		DELETE FROM report_schedules
+		WHERE uuid = $1 AND user_uuid = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, uuid, userUUID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Due returns up to limit report schedules of the activated users whose next run has
+// come, the most overdue first.
+func (m ReportScheduleModel) Due(ctx context.Context, limit int) ([]ReportScheduleRun, error) {
+	query := `
+		SELECT` + reportScheduleColumns + `, u.uuid, u.email, u.name
+		FROM report_schedules s
+		JOIN users u ON u.uuid = s.user_uuid AND u.activated
+		WHERE s.next_run_at <= NOW()
+		ORDER BY s.next_run_at, s.uuid
+		LIMIT $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []ReportScheduleRun{}
+	for rows.Next() {
+		var run ReportScheduleRun
+		err := run.scan(rows, &run.UserUUID, &run.Email, &run.Username)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
+
+// Ran records that the report of the schedule was sent, and moves its next run to the
+// one set on the schedule. The schedule is left as it is when its user changed it since
+// it was listed as due.
+func (m ReportScheduleModel) Ran(ctx context.Context, schedule *ReportSchedule) error {
+	query := `
+		UPDATE report_schedules
+		SET last_run_at = NOW(), next_run_at = $2
+		WHERE uuid = $1 AND version = $3
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, schedule.UUID, schedule.NextRunAt, schedule.Version)
+	return err
+}
@@ -0,0 +1,103 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Resources subject to a retention period.
+const (
+	RetentionSessions        = "sessions"
+	RetentionArchivedTargets = "archived_targets"
+	RetentionArchivedActions = "archived_actions"
+	RetentionDevices         = "devices"
+	RetentionPlans           = "plans"
+	RetentionACLAudit        = "acl_audit"
+)
+
+// retentionQueries delete a batch of at most $2 records of each resource older than the
+// cutoff $1. The dependents of the records, such as their ACLs and search vectors, are
+// removed by the foreign keys and triggers.
+var retentionQueries = map[string]string{
+	RetentionSessions: `
+		DELETE FROM sessions
+		WHERE (uuid, starts_at) IN (
+			SELECT uuid, starts_at FROM sessions WHERE starts_at < $1 LIMIT $2
+		)`,
+	RetentionArchivedTargets: `
+		DELETE FROM targets
+		WHERE uuid IN (
+			SELECT uuid FROM targets
+			WHERE status = 'archived' AND updated_at < $1
+			LIMIT $2
+		)`,
+	RetentionArchivedActions: `
+		DELETE FROM actions
+		WHERE uuid IN (
+			SELECT uuid FROM actions
+			WHERE status = 'archived' AND updated_at < $1
+			LIMIT $2
+		)`,
+	RetentionDevices: `
+		DELETE FROM login_devices
+		WHERE (user_uuid, fingerprint) IN (
+			SELECT user_uuid, fingerprint FROM login_devices WHERE last_seen < $1 LIMIT $2
+		)`,
+	RetentionPlans: `
+		DELETE FROM daily_plans
+		WHERE (user_uuid, plan_date) IN (
+			SELECT user_uuid, plan_date FROM daily_plans WHERE plan_date < $1 LIMIT $2
+		)`,
+	RetentionACLAudit: `
+		DELETE FROM acl_audit
+		WHERE id IN (
+			SELECT id FROM acl_audit WHERE created_at < $1 LIMIT $2
+		)`,
+}
+
+// RetentionModel purges the records kept past their retention period.
+type RetentionModel struct {
+	DB DBTX
+}
+
+// Purge deletes the records of the resource older than the cutoff, in batches of at
+// most batchSize records so that the locks are held briefly, and returns the number of
+// deleted records. Archived targets and actions are aged from their last update.
+func (m RetentionModel) Purge(
+	ctx context.Context,
+	resource string,
+	cutoff time.Time,
+	batchSize int,
+) (int64, error) {
+	query, ok := retentionQueries[resource]
+	if !ok {
+		return 0, fmt.Errorf("no retention for %q", resource)
+	}
+
+	var deleted int64
+	for {
+		rows, err := m.purgeBatch(ctx, query, cutoff, batchSize)
+		deleted += rows
+		if err != nil || rows < int64(batchSize) {
+			return deleted, err
+		}
+	}
+}
+
+func (m RetentionModel) purgeBatch(
+	ctx context.Context,
+	query string,
+	cutoff time.Time,
+	batchSize int,
+) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, cutoff, batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
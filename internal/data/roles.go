@@ -0,0 +1,197 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// Roles holds the rank of each role, the lower the rank the more the role allows. The
+// roles table only changes with the migrations, so it is read once at startup.
+type Roles map[string]int
+
+// DefaultRoles are the roles created by the migrations, used until the roles are loaded.
+var DefaultRoles = Roles{"owner": 0, "editor": 100, "commenter": 150, "viewer": 200}
+
+// LoadRoles reads the roles and their ranks.
+func LoadRoles(ctx context.Context, db *sql.DB) (Roles, error) {
+	rows, err := db.QueryContext(ctx, "SELECT code, rank FROM roles")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := Roles{}
+	for rows.Next() {
+		var code string
+		var rank int
+		if err := rows.Scan(&code, &rank); err != nil {
+			return nil, err
+		}
+		roles[code] = rank
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+func (r Roles) orDefault() Roles {
+	if r == nil {
+		return DefaultRoles
+	}
+	return r
+}
+
+// Allows reports whether role allows what minRole does. Unknown roles allow nothing.
+func (r Roles) Allows(role, minRole string) bool {
+	rank, ok := r[role]
+	minRank, minOK := r[minRole]
+	return ok && minOK && rank <= minRank
+}
+
+// aclMemoKey is the context key of the effective roles memoized during a request.
+type aclMemoKey struct{}
+
+type aclGrant struct {
+	resourceType string
+	resourceUUID uuid.UUID
+	userUUID     uuid.UUID
+}
+
+// aclMemo holds the effective roles looked up during a request, keyed by record and
+// user. A nil role records that the user has no access to the record.
+type aclMemo struct {
+	mu    sync.Mutex
+	roles map[aclGrant]*effectiveRole
+}
+
+// WithACLMemo returns a context memoizing the effective roles looked up with it, so that
+// the access to a record is only checked once per request. The memo is cleared whenever
+// the ACLs are changed with the context.
+func WithACLMemo(ctx context.Context) context.Context {
+	return context.WithValue(ctx, aclMemoKey{}, &aclMemo{roles: map[aclGrant]*effectiveRole{}})
+}
+
+func aclMemoFrom(ctx context.Context) *aclMemo {
+	memo, _ := ctx.Value(aclMemoKey{}).(*aclMemo)
+	return memo
+}
+
+func (m *aclMemo) get(key aclGrant) (*effectiveRole, bool) {
+	if m == nil {
+		return nil, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	role, ok := m.roles[key]
+	return role, ok
+}
+
+func (m *aclMemo) set(key aclGrant, role *effectiveRole) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roles[key] = role
+}
+
+// forgetACLs clears the effective roles memoized in the context, once the ACLs or the
+// records they grant access to have changed.
+func forgetACLs(ctx context.Context) {
+	memo := aclMemoFrom(ctx)
+	if memo == nil {
+		return
+	}
+	memo.mu.Lock()
+	defer memo.mu.Unlock()
+
+	clear(memo.roles)
+}
+
+// effectiveRole is the highest role of a user on a record, and the record it is granted
+// on: the record itself, or the action or target it belongs to.
+type effectiveRole struct {
+	code          string
+	grantedOnType string
+	grantedOnUUID uuid.UUID
+}
+
+// effectiveRole returns the effective role of the user on the record, memoized in the
+// context when it carries an ACL memo. ErrRecordNotFound is returned when the record does
+// not exist or the user has no access to it.
+func (m ACLModel) effectiveRole(
+	ctx context.Context,
+	resourceType string,
+	resourceUUID, userUUID uuid.UUID,
+) (*effectiveRole, error) {
+	key := aclGrant{resourceType: resourceType, resourceUUID: resourceUUID, userUUID: userUUID}
+	memo := aclMemoFrom(ctx)
+	if role, ok := memo.get(key); ok {
+		if role == nil {
+			return nil, ErrRecordNotFound
+		}
+		return role, nil
+	}
+
+	query := `
+		WITH chain (resource_type, resource_uuid) AS (` + resourceChain + `)
+		SELECT ac.role_code, ac.resource_type, ac.resource_uuid
+		FROM acls ac
+		JOIN chain c ON c.resource_type = ac.resource_type
+			AND c.resource_uuid = ac.resource_uuid
+		JOIN roles r ON r.code = ac.role_code
+		WHERE ac.user_uuid = $3
+		ORDER BY r.rank, ac.resource_type = $1::resource_types DESC
+		LIMIT 1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var role effectiveRole
+	err := m.DB.QueryRowContext(ctx, query, resourceType, resourceUUID, userUUID).Scan(
+		&role.code,
+		&role.grantedOnType,
+		&role.grantedOnUUID,
+	)
+	switch {
+	case err == nil:
+		memo.set(key, &role)
+		return &role, nil
+	case errors.Is(err, sql.ErrNoRows):
+		memo.set(key, nil)
+		return nil, ErrRecordNotFound
+	default:
+		return nil, err
+	}
+}
+
+// Check returns ErrRecordNotFound unless the user has at least minRole on the record,
+// directly or through the action or target it belongs to. Records the user cannot see
+// at all are reported the same way, so that their existence is not disclosed.
+func (m ACLModel) Check(
+	ctx context.Context,
+	resourceType string,
+	resourceUUID, userUUID uuid.UUID,
+	minRole string,
+) error {
+	role, err := m.effectiveRole(ctx, resourceType, resourceUUID, userUUID)
+	if err != nil {
+		return err
+	}
+	if !m.Roles.orDefault().Allows(role.code, minRole) {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
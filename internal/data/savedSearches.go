@@ -0,0 +1,508 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/lib/pq"
+	"github.com/liuminhaw/yatijapp/internal/validator"
+)
+
+// Saved search deliveries, how the new results are sent to the user.
+const (
+	DeliveryEmail   = "email"
+	DeliveryWebhook = "webhook"
+)
+
+// MaxSavedSearchResults is the number of results, the most relevant first, a saved search
+// is evaluated on. Records beyond them are not reported as new results.
+const MaxSavedSearchResults = 100
+
+// SavedSearch is a search of the targets, or actions, a user is notified of the new
+// results of, by email or through a webhook. Results are the records matching the search
+// on an evaluation which did not match it on the previous one, the first evaluation only
+// records the results already there.
+type SavedSearch struct {
+	UUID         uuid.UUID `json:"uuid"`
+	Name         string    `json:"name"`
+	ResourceType string    `json:"resource_type"`
+	Search       string    `json:"search"`
+	Status       []Status  `json:"status"`
+	Role         []string  `json:"role"`
+	HasNotes     *bool     `json:"has_notes"`
+	// DueWithinDays restricts the results to the records due from the day of the
+	// evaluation up to that many days ahead, 0 being the records due that day.
+	DueWithinDays *int      `json:"due_within_days"`
+	Delivery      string    `json:"delivery"`
+	WebhookURL    string    `json:"webhook_url,omitzero"`
+	EvaluatedAt   NullTime  `json:"evaluated_at"`
+	NotifiedAt    NullTime  `json:"notified_at"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Version       int32     `json:"version"`
+}
+
+var (
+	SavedSearchResourceSafelist = []string{"target", "action"}
+	DeliverySafelist            = []string{DeliveryEmail, DeliveryWebhook}
+)
+
+func ValidateSavedSearch(v *validator.Validator, search *SavedSearch) {
+	v.CheckField(search.Name != "", "name", validator.Required())
+	v.CheckField(
+		utf8.RuneCountInString(search.Name) <= 80,
+		"name",
+		validator.TooLong(80, validator.UnitCharacters),
+	)
+	v.CheckField(search.ResourceType != "", "resource_type", validator.Required())
+	v.CheckField(
+		validator.PermittedValue(search.ResourceType, SavedSearchResourceSafelist...),
+		"resource_type",
+		validator.NotPermitted("must be one of target, action"),
+	)
+	v.CheckField(
+		utf8.RuneCountInString(search.Search) <= 200,
+		"search",
+		validator.TooLong(200, validator.UnitCharacters),
+	)
+	v.CheckField(
+		validator.PermittedValues(search.Status, StatusFilterSafelist...),
+		"status",
+		validator.NotPermitted("invalid status value"),
+	)
+	v.CheckField(
+		validator.PermittedValues(search.Role, RoleFilterSafelist...),
+		"role",
+		validator.NotPermitted("invalid role value"),
+	)
+	if search.DueWithinDays != nil {
+		v.CheckField(
+			*search.DueWithinDays >= 0,
+			"due_within_days",
+			validator.TooSmall(0, "must not be negative"),
+		)
+		v.CheckField(
+			*search.DueWithinDays <= 366,
+			"due_within_days",
+			validator.TooLarge(366, "must be a maximum of 366"),
+		)
+	}
+
+	validateDelivery(v, search.Delivery, search.WebhookURL)
+}
+
+// validateDelivery checks how the notifications or reports are sent to a user: by email,
+// or to the https webhook they set.
+func validateDelivery(v *validator.Validator, delivery, webhookURL string) {
+	v.CheckField(delivery != "", "delivery", validator.Required())
+	v.CheckField(
+		validator.PermittedValue(delivery, DeliverySafelist...),
+		"delivery",
+		validator.NotPermitted("must be one of email, webhook"),
+	)
+	switch delivery {
+	case DeliveryWebhook:
+		v.CheckField(webhookURL != "", "webhook_url", validator.Required())
+		u, err := url.Parse(webhookURL)
+		v.CheckField(
+			webhookURL == "" || (err == nil && u.Scheme == "https" && u.Host != ""),
+			"webhook_url",
+			validator.InvalidFormat("must be an https URL"),
+		)
+		v.CheckField(
+			len(webhookURL) <= 2048,
+			"webhook_url",
+			validator.TooLong(2048, validator.UnitCharacters),
+		)
+	case DeliveryEmail:
+		v.CheckField(
+			webhookURL == "",
+			"webhook_url",
+			validator.NotPermitted("must be empty for email deliveries"),
+		)
+	}
+}
+
+// Filters returns the filters of the search evaluated at now, the most relevant results
+// first.
+func (s *SavedSearch) Filters(now time.Time) Filters {
+	filters := Filters{
+		Page:           1,
+		PageSize:       MaxSavedSearchResults,
+		Sort:           "-last_active",
+		SortSafelist:   SortSafelist,
+		Status:         s.Status,
+		StatusSafelist: StatusFilterSafelist,
+		HasNotes:       s.HasNotes,
+		Role:           s.Role,
+	}
+	if s.Search != "" {
+		filters.Sort = SortRelevance
+	}
+	if s.DueWithinDays != nil {
+		today := now.UTC().Truncate(24 * time.Hour)
+		filters.DueFrom = sql.NullTime{Time: today, Valid: true}
+		filters.DueTo = sql.NullTime{
+			Time:  today.AddDate(0, 0, *s.DueWithinDays),
+			Valid: true,
+		}
+	}
+
+	return filters
+}
+
+// SavedSearchRun is a saved search due to be evaluated for its user.
+type SavedSearchRun struct {
+	SavedSearch
+	UserUUID uuid.UUID
+	Email    string
+	Username string
+}
+
+type SavedSearchModel struct {
+	DB DBTX
+}
+
+const savedSearchColumns = `
+	s.uuid, s.name, s.resource_type, s.search, s.status, s.role, s.has_notes,
+	s.due_within_days, s.delivery, s.webhook_url, s.evaluated_at, s.notified_at,
+	s.created_at, s.updated_at, s.version`
+
+// scan reads the columns of savedSearchColumns, followed by the extra destinations.
+func (s *SavedSearch) scan(row interface{ Scan(...any) error }, extra ...any) error {
+	var status pq.StringArray
+	dest := []any{
+		&s.UUID,
+		&s.Name,
+		&s.ResourceType,
+		&s.Search,
+		&status,
+		pq.Array(&s.Role),
+		&s.HasNotes,
+		&s.DueWithinDays,
+		&s.Delivery,
+		&s.WebhookURL,
+		&s.EvaluatedAt,
+		&s.NotifiedAt,
+		&s.CreatedAt,
+		&s.UpdatedAt,
+		&s.Version,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+
+	s.Status = StringSliceToStatusSlice(status)
+	return nil
+}
+
+// Insert creates the saved search for the user, unless they already have limit saved
+// searches, in which case ErrLimitReached is returned.
+func (m SavedSearchModel) Insert(
+	ctx context.Context,
+	search *SavedSearch,
+	userUUID uuid.UUID,
+	limit int,
+) error {
+	query := `
+		INSERT INTO saved_searches (
+			user_uuid, name, resource_type, search, status, role, has_notes,
+			due_within_days, delivery, webhook_url
+		)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+		WHERE (SELECT COUNT(*) FROM saved_searches WHERE user_uuid = $1) < $11
+		RETURNING uuid, created_at, updated_at, version
+	`
+
+	args := []any{
+		userUUID,
+		search.Name,
+		search.ResourceType,
+		search.Search,
+		pq.Array(search.Status),
+		pq.Array(search.Role),
+		search.HasNotes,
+		search.DueWithinDays,
+		search.Delivery,
+		search.WebhookURL,
+		limit,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).
+		Scan(&search.UUID, &search.CreatedAt, &search.UpdatedAt, &search.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrLimitReached
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m SavedSearchModel) Get(ctx context.Context, uuid, userUUID uuid.UUID) (*SavedSearch, error) {
+	query := `
+		SELECT` + savedSearchColumns + `
+		FROM saved_searches s
+		WHERE s.uuid = $1 AND s.user_uuid = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var search SavedSearch
+	err := search.scan(m.DB.QueryRowContext(ctx, query, uuid, userUUID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &search, nil
+}
+
+// GetAllForUser returns the saved searches of the user, by name.
+func (m SavedSearchModel) GetAllForUser(
+	ctx context.Context,
+	userUUID uuid.UUID,
+) ([]*SavedSearch, error) {
+	query := `
+		SELECT` + savedSearchColumns + `
+		FROM saved_searches s
+		WHERE s.user_uuid = $1
+		ORDER BY s.name, s.uuid
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userUUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	searches := []*SavedSearch{}
+	for rows.Next() {
+		var search SavedSearch
+		if err := search.scan(rows); err != nil {
+			return nil, err
+		}
+		searches = append(searches, &search)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return searches, nil
+}
+
+// Update saves the name and the delivery of the saved search, its criteria cannot change
+// as its results are tied to them.
+func (m SavedSearchModel) Update(
+	ctx context.Context,
+	search *SavedSearch,
+	userUUID uuid.UUID,
+) error {
+	query := `
+		UPDATE saved_searches
+		SET name = $1,
+			delivery = $2,
+			webhook_url = $3,
+			updated_at = NOW(),
+			version = version + 1
+		WHERE uuid = $4 AND user_uuid = $5 AND version = $6
+		RETURNING updated_at, version
+	`
+
+	args := []any{
+		search.Name,
+		search.Delivery,
+		search.WebhookURL,
+		search.UUID,
+		userUUID,
+		search.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&search.UpdatedAt, &search.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m SavedSearchModel) Delete(ctx context.Context, uuid, userUUID uuid.UUID) error {
+	query := `
+		DELETE FROM saved_searches
+		WHERE uuid = $1 AND user_uuid = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, uuid, userUUID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Due returns up to limit saved searches of the activated users not evaluated within the
+// interval, the ones evaluated the longest ago first, so that each run evaluates the next
+// batch.
+func (m SavedSearchModel) Due(
+	ctx context.Context,
+	interval time.Duration,
+	limit int,
+) ([]SavedSearchRun, error) {
+	query := `
+		SELECT` + savedSearchColumns + `, u.uuid, u.email, u.name
+		FROM saved_searches s
+		JOIN users u ON u.uuid = s.user_uuid AND u.activated
+		WHERE s.evaluated_at IS NULL OR s.evaluated_at <= NOW() - make_interval(secs => $1)
+		ORDER BY s.evaluated_at NULLS FIRST, s.uuid
+		LIMIT $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, interval.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []SavedSearchRun{}
+	for rows.Next() {
+		var run SavedSearchRun
+		err := run.scan(rows, &run.UserUUID, &run.Email, &run.Username)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return runs, nil
+}
+
+// NewResults returns the records, out of the given results, which were not among the
+// results of the previous evaluation of the saved search.
+func (m SavedSearchModel) NewResults(
+	ctx context.Context,
+	search *SavedSearch,
+	results []uuid.UUID,
+) ([]uuid.UUID, error) {
+	query := `
+		SELECT r.uuid
+		FROM unnest($2::uuid[]) WITH ORDINALITY AS r (uuid, position)
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM saved_search_results sr
+			WHERE sr.saved_search_uuid = $1 AND sr.record_uuid = r.uuid
+		)
+		ORDER BY r.position
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, search.UUID, pq.Array(results))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fresh := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		fresh = append(fresh, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return fresh, nil
+}
+
+// Evaluated records the results of an evaluation of the saved search, in place of the
+// previous ones, and whether its user was notified of new results.
+func (m SavedSearchModel) Evaluated(
+	ctx context.Context,
+	search *SavedSearch,
+	results []uuid.UUID,
+	notified bool,
+) error {
+	query := `
+		WITH evaluated AS (
+			UPDATE saved_searches
+			SET evaluated_at = NOW(),
+				notified_at = CASE WHEN $3 THEN NOW() ELSE notified_at END
+			WHERE uuid = $1
+			RETURNING uuid
+		),
+		stale AS (
+			DELETE FROM saved_search_results sr
+			USING evaluated e
+			WHERE sr.saved_search_uuid = e.uuid AND NOT sr.record_uuid = ANY ($2::uuid[])
+		)
+		INSERT INTO saved_search_results (saved_search_uuid, record_uuid)
+		SELECT e.uuid, r.uuid
+		FROM evaluated e
+		CROSS JOIN unnest($2::uuid[]) AS r (uuid)
+		ON CONFLICT DO NOTHING
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(
+		ctx,
+		query,
+		search.UUID,
+		pq.Array(results),
+		notified,
+	)
+	return err
+}
@@ -9,41 +9,87 @@ import (
 	"time"
 
 	"github.com/gofrs/uuid/v5"
+	"github.com/lib/pq"
 	"github.com/liuminhaw/yatijapp/internal/tokenizer"
 	"github.com/liuminhaw/yatijapp/internal/validator"
-	"github.com/yanyiwu/gojieba"
 )
 
+// Session is a period of time spent on an action. A quick track session may instead be
+// attached directly to a target, or to nothing at all until it is classified, in which
+// case ActionUUID, and TargetUUID, are null. The TargetUUID of a session attached to an
+// action is the target of the action.
 type Session struct {
-	UUID        string       `json:"uuid"`
-	StartsAt    time.Time    `json:"starts_at"`
-	EndsAt      sql.NullTime `json:"ends_at"`
-	CreatedAt   time.Time    `json:"created_at"`
-	UpdatedAt   time.Time    `json:"updated_at"`
-	Notes       string       `json:"notes"`
-	Version     int32        `json:"version"`
-	ActionUUID  uuid.UUID    `json:"action_uuid"`
-	ActionTitle string       `json:"action_title"`
-	TargetUUID  uuid.UUID    `json:"target_uuid"`
-	TargetTitle string       `json:"target_title"`
-	HasNotes    bool         `json:"has_notes"`
-	Role        string       `json:"role"` // The user's role for this session, e.g., "owner", "editor", "viewer"
+	UUID        uuid.UUID     `json:"uuid"`
+	StartsAt    time.Time     `json:"starts_at"`
+	EndsAt      NullTime      `json:"ends_at"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+	Notes       string        `json:"notes"`
+	Version     int32         `json:"version"`
+	ActionUUID  uuid.NullUUID `json:"action_uuid"`
+	ActionTitle string        `json:"action_title"`
+	TargetUUID  uuid.NullUUID `json:"target_uuid"`
+	TargetTitle string        `json:"target_title"`
+	HasNotes    bool          `json:"has_notes"`
+	Source      string        `json:"source"`
+	Role        string        `json:"role"` // The user's role for this session, e.g., "owner", "editor", "viewer"
+}
+
+// The sources record how a session was created. The source of a session is set once,
+// when the session is created.
+const (
+	SourceManual   = "manual"
+	SourceTimer    = "timer"
+	SourceImport   = "import"
+	SourceAPIKey   = "api_key"
+	SourceCalendar = "calendar"
+)
+
+var SessionSourceSafelist = []string{
+	SourceManual,
+	SourceTimer,
+	SourceImport,
+	SourceAPIKey,
+	SourceCalendar,
 }
 
 func ValidateSession(v *validator.Validator, session *Session) {
-	v.Check(session.ActionUUID != uuid.Nil, "action_uuid", "must be provided")
+	v.CheckField(
+		validator.PermittedValue(session.Source, SessionSourceSafelist...),
+		"source",
+		validator.NotPermitted("invalid source value"),
+	)
 	if session.EndsAt.Valid {
-		v.Check(session.EndsAt.Time.After(session.StartsAt), "ends_at", "must be after starts_at")
+		v.CheckField(
+			session.EndsAt.Time.After(session.StartsAt),
+			"ends_at",
+			validator.NotAfter("starts_at"),
+		)
 	}
 }
 
+// parentTargetUUID returns the target the session is directly attached to, which is
+// null for the sessions of an action.
+func (s Session) parentTargetUUID() uuid.NullUUID {
+	if s.ActionUUID.Valid {
+		return uuid.NullUUID{}
+	}
+	return s.TargetUUID
+}
+
 type SessionModel struct {
-	DB    DBTX
-	Jieba *gojieba.Jieba
+	DB        DBTX
+	Segmenter tokenizer.Segmenter
+	Cipher    *NotesCipher
+	FTS       *FTSConfig
 }
 
 func (m SessionModel) Insert(ctx context.Context, session *Session, userUUID uuid.UUID) error {
-	fts := GenFTS("", "", session.Notes, m.Jieba)
+	fts := GenFTS("", "", m.Cipher.Searchable(session.Notes), m.Segmenter)
+	notes, err := m.Cipher.Seal(session.Notes)
+	if err != nil {
+		return err
+	}
 
 	query := `
 	WITH cutoff AS (
@@ -51,19 +97,24 @@ func (m SessionModel) Insert(ctx context.Context, session *Session, userUUID uui
 		FROM roles
 		WHERE code = 'editor'
 	),
+	parent AS (
+		SELECT
+			$1::uuid AS action_uuid,
+			COALESCE((SELECT a.target_uuid FROM actions a WHERE a.uuid = $1), $6::uuid) AS target_uuid
+	),
 	new_session AS (
-		INSERT INTO sessions (action_uuid, notes)
-		SELECT a.uuid, $2
-		FROM actions a 
-		WHERE a.uuid = $1 AND EXISTS (
+		INSERT INTO sessions (action_uuid, target_uuid, notes, source)
+		SELECT $1, $6, $2, $7
+		FROM parent p
+		WHERE (p.action_uuid IS NULL AND p.target_uuid IS NULL) OR EXISTS (
 			SELECT 1
 			FROM acls ac
 			JOIN roles r ON ac.role_code = r.code
 			JOIN cutoff c ON r.rank <= c.cutoff
 			WHERE ac.user_uuid = $3 AND (
-				(ac.resource_type = 'action' AND ac.resource_uuid = a.uuid)
+				(ac.resource_type = 'action' AND ac.resource_uuid = p.action_uuid)
 				OR
-				(ac.resource_type = 'target' AND ac.resource_uuid = a.target_uuid)
+				(ac.resource_type = 'target' AND ac.resource_uuid = p.target_uuid)
 			)
 		)
 		RETURNING uuid, starts_at, created_at, updated_at, version
@@ -80,13 +131,15 @@ func (m SessionModel) Insert(ctx context.Context, session *Session, userUUID uui
 
 	args := []any{
 		session.ActionUUID,
-		session.Notes,
+		notes,
 		userUUID,
 		fts.NotesToken.Chinese,
 		fts.NotesToken.English,
+		session.parentTargetUUID(),
+		session.Source,
 	}
 
-	err := m.DB.QueryRowContext(ctx, query, args...).
+	err = m.DB.QueryRowContext(ctx, query, args...).
 		Scan(
 			&session.UUID,
 			&session.StartsAt,
@@ -106,7 +159,11 @@ func (m SessionModel) Insert(ctx context.Context, session *Session, userUUID uui
 	return nil
 }
 
-func (m SessionModel) Get(uuid, userUUID uuid.UUID, minRole string) (*Session, error) {
+func (m SessionModel) Get(
+	ctx context.Context,
+	uuid, userUUID uuid.UUID,
+	minRole string,
+) (*Session, error) {
 	query := `
 		SELECT 
 			s.uuid, 
@@ -117,12 +174,13 @@ func (m SessionModel) Get(uuid, userUUID uuid.UUID, minRole string) (*Session, e
 			s.notes, 
 			s.version, 
 			s.action_uuid, 
-			a.title,
-			a.target_uuid,
-			t.title
+			COALESCE(a.title, ''),
+			t.uuid,
+			COALESCE(t.title, ''),
+			s.source
 		FROM sessions s
-		JOIN actions a ON s.action_uuid = a.uuid
-		JOIN targets t ON a.target_uuid = t.uuid
+		LEFT JOIN actions a ON s.action_uuid = a.uuid
+		LEFT JOIN targets t ON t.uuid = COALESCE(a.target_uuid, s.target_uuid)
 		WHERE s.uuid = $1 AND EXISTS (
 			SELECT 1
 			FROM acls ac
@@ -141,7 +199,7 @@ func (m SessionModel) Get(uuid, userUUID uuid.UUID, minRole string) (*Session, e
 
 	var session Session
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
@@ -156,6 +214,7 @@ func (m SessionModel) Get(uuid, userUUID uuid.UUID, minRole string) (*Session, e
 		&session.ActionTitle,
 		&session.TargetUUID,
 		&session.TargetTitle,
+		&session.Source,
 	)
 	if err != nil {
 		switch {
@@ -166,10 +225,22 @@ func (m SessionModel) Get(uuid, userUUID uuid.UUID, minRole string) (*Session, e
 		}
 	}
 
+	session.Notes, err = m.Cipher.Open(session.Notes)
+	if err != nil {
+		return nil, err
+	}
+
 	return &session, nil
 }
 
-func (m SessionModel) Update(session *Session, fts FTS, userUUID uuid.UUID) error {
+func (m SessionModel) Update(
+	ctx context.Context,
+	session *Session,
+	fts FTS,
+	userUUID uuid.UUID,
+) error {
+	forgetACLs(ctx)
+
 	query := `
 		WITH editor_cutoff AS (
 			SELECT rank AS cutoff FROM roles WHERE code = 'editor'
@@ -177,6 +248,20 @@ func (m SessionModel) Update(session *Session, fts FTS, userUUID uuid.UUID) erro
 		owner_cutoff AS (
 			SELECT rank AS cutoff FROM roles WHERE code = 'owner'
 		), 
+		current_parent AS (
+			SELECT s.uuid, s.action_uuid, s.target_uuid,
+				COALESCE(a.target_uuid, s.target_uuid) AS effective_target_uuid
+			FROM sessions s
+			LEFT JOIN actions a ON s.action_uuid = a.uuid
+			WHERE s.uuid = $5
+		),
+		new_parent AS (
+			SELECT
+				$4::uuid AS action_uuid,
+				COALESCE(
+					(SELECT a.target_uuid FROM actions a WHERE a.uuid = $4), $10::uuid
+				) AS target_uuid
+		),
 		update_session AS (
 			UPDATE sessions AS s
 			SET starts_at = $1,
@@ -184,60 +269,57 @@ func (m SessionModel) Update(session *Session, fts FTS, userUUID uuid.UUID) erro
 				notes = $3,
 				updated_at = NOW(),
 				version = version + 1,
-				action_uuid = $4
-			WHERE s.uuid = $5 AND s.version = $6 AND (
+				action_uuid = $4,
+				target_uuid = $10
+			FROM current_parent cp, new_parent np
+			WHERE s.uuid = cp.uuid AND s.version = $6 AND (
 				(
-					$4 IS NOT DISTINCT FROM s.action_uuid AND (
-						EXISTS (
-							SELECT 1
-							FROM acls ac
-							JOIN roles r ON ac.role_code = r.code
-							JOIN editor_cutoff ec ON r.rank <= ec.cutoff
-							WHERE ac.resource_type = 'session'
-							AND ac.resource_uuid = s.uuid
-							AND ac.user_uuid = $7
-						)
-						OR
-						EXISTS (
-							SELECT 1
-							FROM acls ac
-							JOIN roles r ON ac.role_code = r.code
-							JOIN editor_cutoff ec ON r.rank <= ec.cutoff
-							WHERE ac.resource_type = 'action'
-							AND ac.resource_uuid = s.action_uuid
-							AND ac.user_uuid = $7
-						)
-						OR
-						EXISTS (
-							SELECT 1
-							FROM acls ac
-							JOIN roles r ON ac.role_code = r.code
-							JOIN editor_cutoff ec ON r.rank <= ec.cutoff
-							JOIN actions a ON s.action_uuid = a.uuid
-							WHERE ac.resource_type = 'target'
-							AND ac.resource_uuid = a.target_uuid
-							AND ac.user_uuid = $7
-						)
+					$4::uuid IS NOT DISTINCT FROM cp.action_uuid
+					AND $10::uuid IS NOT DISTINCT FROM cp.target_uuid
+					AND EXISTS (
+						SELECT 1
+						FROM acls ac
+						JOIN roles r ON ac.role_code = r.code
+						JOIN editor_cutoff ec ON r.rank <= ec.cutoff
+						WHERE ac.user_uuid = $7
+							AND (ac.resource_type, ac.resource_uuid) IN (
+								('session', cp.uuid),
+								('action', cp.action_uuid),
+								('target', cp.effective_target_uuid)
+							)
 					)
 				)
 				OR
 				(
-					$4 IS DISTINCT FROM s.action_uuid AND EXISTS (
-						SELECT 1
-						FROM acls ac
-						JOIN roles r ON ac.role_code = r.code
-						JOIN owner_cutoff oc ON r.rank <= oc.cutoff
-						WHERE ac.resource_type = 'action'
-							AND ac.resource_uuid = s.action_uuid
-							AND ac.user_uuid = $7
-					) AND EXISTS (
+					(
+						$4::uuid IS DISTINCT FROM cp.action_uuid
+						OR $10::uuid IS DISTINCT FROM cp.target_uuid
+					)
+					AND EXISTS (
 						SELECT 1
 						FROM acls ac
 						JOIN roles r ON ac.role_code = r.code
 						JOIN owner_cutoff oc ON r.rank <= oc.cutoff
-						WHERE ac.resource_type = 'action'
-							AND ac.resource_uuid = $4
-							AND ac.user_uuid = $7
+						WHERE ac.user_uuid = $7
+							AND (ac.resource_type, ac.resource_uuid) IN (
+								('session', cp.uuid),
+								('action', cp.action_uuid),
+								('target', cp.effective_target_uuid)
+							)
+					)
+					AND (
+						(np.action_uuid IS NULL AND np.target_uuid IS NULL)
+						OR EXISTS (
+							SELECT 1
+							FROM acls ac
+							JOIN roles r ON ac.role_code = r.code
+							JOIN owner_cutoff oc ON r.rank <= oc.cutoff
+							WHERE ac.user_uuid = $7
+								AND (ac.resource_type, ac.resource_uuid) IN (
+									('action', np.action_uuid),
+									('target', np.target_uuid)
+								)
+						)
 					)
 				)
 			)
@@ -252,22 +334,28 @@ func (m SessionModel) Update(session *Session, fts FTS, userUUID uuid.UUID) erro
 		SELECT created_at, updated_at, version FROM update_session;
 	`
 
+	notes, err := m.Cipher.Seal(session.Notes)
+	if err != nil {
+		return err
+	}
+
 	args := []any{
 		session.StartsAt,
 		session.EndsAt,
-		session.Notes,
+		notes,
 		session.ActionUUID,
 		session.UUID,
 		session.Version,
 		userUUID,
 		fts.NotesToken.Chinese,
 		fts.NotesToken.English,
+		session.parentTargetUUID(),
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).
+	err = m.DB.QueryRowContext(ctx, query, args...).
 		Scan(&session.CreatedAt, &session.UpdatedAt, &session.Version)
 	if err != nil {
 		switch {
@@ -281,7 +369,9 @@ func (m SessionModel) Update(session *Session, fts FTS, userUUID uuid.UUID) erro
 	return nil
 }
 
-func (m SessionModel) Delete(uuid, userUUID uuid.UUID) error {
+func (m SessionModel) Delete(ctx context.Context, uuid, userUUID uuid.UUID) error {
+	forgetACLs(ctx)
+
 	query := `
 		WITH cutoff AS (
 			SELECT rank AS cutoff FROM roles WHERE code = 'owner'
@@ -292,17 +382,17 @@ func (m SessionModel) Delete(uuid, userUUID uuid.UUID) error {
 			FROM acls ac
 			JOIN roles r ON ac.role_code = r.code
 			JOIN cutoff c ON r.rank <= c.cutoff
-			JOIN actions a ON s.action_uuid = a.uuid
+			LEFT JOIN actions a ON s.action_uuid = a.uuid
 			WHERE ac.user_uuid = $2
 				AND (ac.resource_type, ac.resource_uuid) IN (
 					('session', s.uuid),
 					('action', s.action_uuid),
-					('target', a.target_uuid)
+					('target', COALESCE(a.target_uuid, s.target_uuid))
 				)
 		);
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	result, err := m.DB.ExecContext(ctx, query, uuid, userUUID)
@@ -322,22 +412,90 @@ func (m SessionModel) Delete(uuid, userUUID uuid.UUID) error {
 	return nil
 }
 
+// Reassign moves every session of the action fromUUID to the action toUUID, in a single
+// statement, and returns the number of sessions moved. The user must be an owner of both
+// actions, either directly or through their targets, otherwise ErrRecordNotFound is
+// returned.
+func (m SessionModel) Reassign(
+	ctx context.Context,
+	fromUUID, toUUID, userUUID uuid.UUID,
+) (int64, error) {
+	forgetACLs(ctx)
+
+	query := `
+		WITH owner_cutoff AS (
+			SELECT rank AS cutoff FROM roles WHERE code = 'owner'
+		),
+		allowed AS (
+			SELECT (
+				SELECT COUNT(DISTINCT a.uuid)
+				FROM actions a
+				JOIN acls ac ON (ac.resource_type, ac.resource_uuid) IN (
+					('action', a.uuid),
+					('target', a.target_uuid)
+				)
+				JOIN roles r ON ac.role_code = r.code
+				JOIN owner_cutoff oc ON r.rank <= oc.cutoff
+				WHERE a.uuid IN ($1, $2) AND ac.user_uuid = $3
+			) = 2 AS ok
+		),
+		moved AS (
+			UPDATE sessions AS s
+			SET action_uuid = $2,
+				updated_at = NOW(),
+				version = version + 1
+			FROM allowed
+			WHERE allowed.ok AND s.action_uuid = $1
+			RETURNING s.uuid
+		)
+		SELECT ok, (SELECT COUNT(*) FROM moved) FROM allowed;
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var ok bool
+	var moved int64
+	err := m.DB.QueryRowContext(ctx, query, fromUUID, toUUID, userUUID).Scan(&ok, &moved)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrRecordNotFound
+	}
+
+	return moved, nil
+}
+
+// sessionRoleResources lists the resources the role of a user on the sessions aliased s
+// is granted on: the sessions themselves, their actions aliased a and targets aliased t.
+var sessionRoleResources = []string{
+	"('session', s.uuid)",
+	"('action', a.uuid)",
+	"('target', t.uuid)",
+}
+
 func (m SessionModel) GetAll(
+	ctx context.Context,
 	token tokenizer.Tokenizer,
 	filters Filters,
-	actionUUID uuid.NullUUID,
+	actionUUID, targetUUID uuid.NullUUID,
 	userUUID uuid.UUID,
 ) ([]*Session, Metadata, error) {
 	query := fmt.Sprintf(`
 		WITH filtered AS MATERIALIZED (
-			SELECT s.uuid, s.action_uuid, a.target_uuid
+			SELECT s.uuid, s.action_uuid, t.uuid AS target_uuid
 			FROM sessions s
 			JOIN sessions_fts fts ON fts.session_uuid = s.uuid
-			JOIN actions a ON s.action_uuid = a.uuid
-			JOIN targets t ON a.target_uuid = t.uuid
+			LEFT JOIN actions a ON s.action_uuid = a.uuid
+			LEFT JOIN targets t ON t.uuid = COALESCE(a.target_uuid, s.target_uuid)
 			WHERE ($1 = '' OR fts.fts_chinese_notes_tsv @@ plainto_tsquery('simple', $1))
 				AND ($2 = '' OR fts.fts_english_notes_tsv @@ plainto_tsquery('english', $2))
 				AND ($3::uuid IS NULL OR s.action_uuid = $3)
+				AND ($9::uuid IS NULL OR t.uuid = $9)
+				AND ($10 = FALSE OR (s.action_uuid IS NULL AND s.target_uuid IS NULL))
+				AND (COALESCE(cardinality($11::text[]), 0) = 0 OR s.source = ANY($11))
+				AND %s
 				AND (($7 = FALSE AND $8 = FALSE) OR ($7 AND s.ends_at IS NULL) OR ($8 AND s.ends_at IS NOT NULL))
 				AND EXISTS (
 					SELECT 1
@@ -364,20 +522,21 @@ func (m SessionModel) GetAll(
 				s.updated_at,
 				s.version,
 				s.action_uuid,
-				a.title AS action_title,
-				a.target_uuid,
-				t.title AS target_title,
+				COALESCE(a.title, '') AS action_title,
+				t.uuid AS target_uuid,
+				COALESCE(t.title, '') AS target_title,
 				(btrim(COALESCE(s.notes, '')) <> '') AS has_notes,
+				s.source,
 				(CASE WHEN $1 <> '' THEN 
-					ts_rank(fts.fts_chinese_notes_tsv, plainto_tsquery('simple', $1)) 
+					%s
 				ELSE 0 END) + (CASE WHEN $2 <> '' THEN 
-					ts_rank(fts.fts_english_notes_tsv, plainto_tsquery('english', $2)) 
+					%s
 				ELSE 0 END) AS rank
 			FROM filtered f
 			JOIN sessions s ON f.uuid = s.uuid
 			JOIN sessions_fts fts ON s.uuid = fts.session_uuid
-			JOIN actions a ON s.action_uuid = a.uuid
-			JOIN targets t ON a.target_uuid = t.uuid
+			LEFT JOIN actions a ON s.action_uuid = a.uuid
+			LEFT JOIN targets t ON t.uuid = COALESCE(a.target_uuid, s.target_uuid)
 			ORDER BY s.%s %s, rank DESC, s.uuid DESC
 			LIMIT $5 OFFSET $6
 		)
@@ -394,6 +553,7 @@ func (m SessionModel) GetAll(
 			p.target_uuid,
 			p.target_title,
 			p.has_notes,
+			p.source,
 			ur.role_code,
 		    p.rank
 		FROM paged p
@@ -412,9 +572,17 @@ func (m SessionModel) GetAll(
 		) AS ur ON TRUE
 		CROSS JOIN total
 		ORDER BY p.%s %s, p.rank DESC, p.uuid DESC;
-		`, filters.sortColumn(), filters.sortDirection(), filters.sortColumn(), filters.sortDirection())
+		`,
+		roleFilterClause(4, 12, sessionRoleResources...),
+		m.FTS.tsRank("fts.fts_chinese_notes_tsv", "plainto_tsquery('simple', $1)"),
+		m.FTS.tsRank("fts.fts_english_notes_tsv", "plainto_tsquery('english', $2)"),
+		filters.sortColumn(),
+		filters.sortDirection(),
+		filters.sortColumn(),
+		filters.sortDirection(),
+	)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	wantInProgress := slices.Contains(filters.Status, StatusInProgress)
@@ -429,6 +597,10 @@ func (m SessionModel) GetAll(
 		filters.offset(),
 		wantInProgress,
 		wantCompleted,
+		targetUUID,
+		filters.Unclassified,
+		pq.Array(filters.Source),
+		pq.Array(filters.Role),
 	}
 
 	rows, err := m.DB.QueryContext(ctx, query, args...)
@@ -456,6 +628,7 @@ func (m SessionModel) GetAll(
 			&session.TargetUUID,
 			&session.TargetTitle,
 			&session.HasNotes,
+			&session.Source,
 			&session.Role,
 			&ignored,
 		)
@@ -470,7 +643,265 @@ func (m SessionModel) GetAll(
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	metadata := calculateMetadata(totalRecords, filters)
 
 	return sessions, metadata, nil
 }
+
+// Fingerprint returns the fingerprint of the sessions matching the given search
+// tokens and filters, without fetching the records themselves.
+func (m SessionModel) Fingerprint(
+	ctx context.Context,
+	token tokenizer.Tokenizer,
+	filters Filters,
+	actionUUID, targetUUID uuid.NullUUID,
+	userUUID uuid.UUID,
+) (Fingerprint, error) {
+	query := fmt.Sprintf(`
+		WITH filtered AS MATERIALIZED (
+			SELECT s.uuid, s.action_uuid, t.uuid AS target_uuid
+			FROM sessions s
+			JOIN sessions_fts fts ON fts.session_uuid = s.uuid
+			LEFT JOIN actions a ON s.action_uuid = a.uuid
+			LEFT JOIN targets t ON t.uuid = COALESCE(a.target_uuid, s.target_uuid)
+			WHERE ($1 = '' OR fts.fts_chinese_notes_tsv @@ plainto_tsquery('simple', $1))
+				AND ($2 = '' OR fts.fts_english_notes_tsv @@ plainto_tsquery('english', $2))
+				AND ($3::uuid IS NULL OR s.action_uuid = $3)
+				AND ($7::uuid IS NULL OR t.uuid = $7)
+				AND ($8 = FALSE OR (s.action_uuid IS NULL AND s.target_uuid IS NULL))
+				AND (COALESCE(cardinality($9::text[]), 0) = 0 OR s.source = ANY($9))
+				AND %s
+				AND (($5 = FALSE AND $6 = FALSE) OR ($5 AND s.ends_at IS NULL) OR ($6 AND s.ends_at IS NOT NULL))
+				AND EXISTS (
+					SELECT 1
+					FROM acls ac
+					JOIN roles r ON ac.role_code = r.code
+					WHERE ac.user_uuid = $4
+					AND r.rank <= (SELECT rank FROM roles WHERE code = 'viewer')
+					AND (ac.resource_type, ac.resource_uuid) IN (
+						('session', s.uuid),
+						('action', a.uuid),
+						('target', t.uuid)
+					)
+				)
+		)
+		SELECT
+			(SELECT COUNT(*) FROM filtered),
+			COALESCE((
+				SELECT GREATEST(MAX(s.updated_at), MAX(a.updated_at), MAX(t.updated_at))
+				FROM filtered f
+				JOIN sessions s ON s.uuid = f.uuid
+				LEFT JOIN actions a ON a.uuid = f.action_uuid
+				LEFT JOIN targets t ON t.uuid = f.target_uuid
+			), to_timestamp(0))
+	`, roleFilterClause(4, 10, sessionRoleResources...))
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	args := []any{
+		token.Chinese,
+		token.English,
+		actionUUID,
+		userUUID,
+		slices.Contains(filters.Status, StatusInProgress),
+		slices.Contains(filters.Status, StatusComplete),
+		targetUUID,
+		filters.Unclassified,
+		pq.Array(filters.Source),
+		pq.Array(filters.Role),
+	}
+
+	var fp Fingerprint
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&fp.TotalRecords, &fp.LastUpdated)
+	if err != nil {
+		return Fingerprint{}, err
+	}
+
+	return fp, nil
+}
+
+// GetAllStartedBetween returns the sessions the user can view which started within
+// [from, to), oldest first. Notes are not loaded.
+func (m SessionModel) GetAllStartedBetween(
+	ctx context.Context,
+	from, to time.Time,
+	userUUID uuid.UUID,
+) ([]*Session, error) {
+	query := `
+		SELECT
+			s.uuid,
+			s.starts_at,
+			s.ends_at,
+			s.created_at,
+			s.updated_at,
+			s.version,
+			s.action_uuid,
+			COALESCE(a.title, ''),
+			t.uuid,
+			COALESCE(t.title, ''),
+			s.source
+		FROM sessions s
+		LEFT JOIN actions a ON s.action_uuid = a.uuid
+		LEFT JOIN targets t ON t.uuid = COALESCE(a.target_uuid, s.target_uuid)
+		WHERE s.starts_at >= $1 AND s.starts_at < $2 AND EXISTS (
+			SELECT 1
+			FROM acls ac
+			JOIN roles r ON ac.role_code = r.code
+			WHERE ac.user_uuid = $3
+			AND r.rank <= (SELECT rank FROM roles WHERE code = 'viewer')
+			AND (ac.resource_type, ac.resource_uuid) IN (
+				('session', s.uuid),
+				('action', a.uuid),
+				('target', t.uuid)
+			)
+		)
+		ORDER BY s.starts_at, s.uuid
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, from, to, userUUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.UUID,
+			&session.StartsAt,
+			&session.EndsAt,
+			&session.CreatedAt,
+			&session.UpdatedAt,
+			&session.Version,
+			&session.ActionUUID,
+			&session.ActionTitle,
+			&session.TargetUUID,
+			&session.TargetTitle,
+			&session.Source,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// sessionPartition returns the name and the bounds of the monthly partition, in UTC, of
+// the sessions starting in the month of t.
+func sessionPartition(t time.Time) (string, time.Time, time.Time) {
+	t = t.UTC()
+	from := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	return fmt.Sprintf("sessions_%04d_%02d", from.Year(), from.Month()), from, from.AddDate(0, 1, 0)
+}
+
+// CreateSessionPartitions creates the missing monthly partitions of the sessions, from
+// the month of now up to ahead months later, and returns the number of partitions
+// created. Each partition is created in its own transaction, an error is reported and
+// the other months are still created.
+func (m Models) CreateSessionPartitions(
+	ctx context.Context,
+	now time.Time,
+	ahead int,
+) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	created := 0
+	var errs []error
+	_, month, _ := sessionPartition(now)
+	for range ahead + 1 {
+		name, from, to := sessionPartition(month)
+		month = to
+
+		var exists bool
+		err := m.Sessions.DB.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, name).
+			Scan(&exists)
+		if err != nil {
+			return created, err
+		}
+		if exists {
+			continue
+		}
+
+		err = m.WithTx(ctx, nil, func(tx *sql.Tx) error {
+			sessions := m.Sessions
+			sessions.DB = m.observed(tx)
+			return sessions.createPartition(ctx, name, from, to)
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("partition %s: %w", name, err))
+			continue
+		}
+		created++
+	}
+
+	return created, errors.Join(errs...)
+}
+
+// createPartition creates the partition of the sessions starting within [from, to). A
+// partition cannot be created while the default partition holds sessions of its range,
+// the sessions having started past the partitions created ahead: the default partition
+// is then detached, the sessions moved over to the new partition, and the default
+// partition attached back. It must run in a transaction.
+func (m SessionModel) createPartition(ctx context.Context, name string, from, to time.Time) error {
+	bounds := []any{from, to}
+	create := fmt.Sprintf(
+		`CREATE TABLE %s PARTITION OF sessions FOR VALUES FROM (%s) TO (%s)`,
+		pq.QuoteIdentifier(name),
+		pq.QuoteLiteral(from.Format(time.RFC3339)),
+		pq.QuoteLiteral(to.Format(time.RFC3339)),
+	)
+
+	var stray bool
+	err := m.DB.QueryRowContext(
+		ctx,
+		`SELECT EXISTS (SELECT 1 FROM sessions_default WHERE starts_at >= $1 AND starts_at < $2)`,
+		bounds...,
+	).Scan(&stray)
+	if err != nil {
+		return err
+	}
+	if !stray {
+		_, err := m.DB.ExecContext(ctx, create)
+		return err
+	}
+
+	// The delete trigger runs once the statement is done, the moved sessions are then
+	// found in their new partition and their search index and access rights are kept.
+	move := fmt.Sprintf(`
+		WITH moved AS (
+			DELETE FROM sessions_default
+			WHERE starts_at >= $1 AND starts_at < $2
+			RETURNING *
+		)
+		INSERT INTO %s SELECT * FROM moved
+	`, pq.QuoteIdentifier(name))
+
+	steps := []struct {
+		query string
+		args  []any
+	}{
+		{query: `ALTER TABLE sessions DETACH PARTITION sessions_default`},
+		{query: create},
+		{query: move, args: bounds},
+		{query: `ALTER TABLE sessions ATTACH PARTITION sessions_default DEFAULT`},
+	}
+	for _, step := range steps {
+		if _, err := m.DB.ExecContext(ctx, step.query, step.args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+// starterSessionLength is the length of the sample session of the starter content,
+// ended when it is created.
+const starterSessionLength = 25 * time.Minute
+
+// StarterContent is the guided target created for the users when they activate their
+// account, with example actions and a sample session on the first action, so that
+// the clients do not start empty.
+type StarterContent struct {
+	Target  Target
+	Actions []Action
+	Session Session
+}
+
+// ActivateUser saves the user, activated, and creates the starter content for them when
+// given, in a single transaction. Like the fixtures, the starter content is inserted
+// with its ACLs and full text search vectors, but without consuming any quota.
+func (m Models) ActivateUser(ctx context.Context, user *User, starter *StarterContent) error {
+	return m.WithTx(ctx, nil, func(tx *sql.Tx) error {
+		return m.activateUser(ctx, tx, user, starter)
+	})
+}
+
+// activateUser activates the user and creates their starter content within tx.
+func (m Models) activateUser(
+	ctx context.Context,
+	tx *sql.Tx,
+	user *User,
+	starter *StarterContent,
+) error {
+	m.Users.DB = m.observed(tx)
+	m.Targets.DB = m.observed(tx)
+	m.Actions.DB = m.observed(tx)
+	m.Sessions.DB = m.observed(tx)
+
+	user.Activated = true
+	if err := m.Users.Update(ctx, user); err != nil {
+		return err
+	}
+	if starter == nil {
+		return nil
+	}
+
+	if err := m.Targets.Insert(ctx, &starter.Target, user.UUID); err != nil {
+		return err
+	}
+
+	for i := range starter.Actions {
+		action := &starter.Actions[i]
+		action.TargetUUID = starter.Target.UUID
+		if err := m.Actions.Insert(ctx, action, user.UUID); err != nil {
+			return err
+		}
+	}
+	if len(starter.Actions) == 0 {
+		return nil
+	}
+
+	session := &starter.Session
+	session.ActionUUID = uuid.NullUUID{UUID: starter.Actions[0].UUID, Valid: true}
+	session.TargetUUID = uuid.NullUUID{UUID: starter.Target.UUID, Valid: true}
+	session.Source = SourceManual
+	if err := m.Sessions.Insert(ctx, session, user.UUID); err != nil {
+		return err
+	}
+
+	// Sessions start when they are inserted, the sample one is moved back to end
+	// now, instead of running.
+	query := `
+		UPDATE sessions
+		SET starts_at = starts_at - $2::float8 * INTERVAL '1 second', ends_at = starts_at
+		WHERE uuid = $1
+	`
+	_, err := m.Sessions.DB.ExecContext(
+		ctx,
+		query,
+		session.UUID,
+		starterSessionLength.Seconds(),
+	)
+	return err
+}
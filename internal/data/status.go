@@ -46,6 +46,7 @@ var SortSafelist = []string{
 	"-due_date",
 	"-last_active",
 	"-updated_at",
+	SortRelevance,
 }
 
 var SessionSortSafelist = []string{
@@ -13,98 +13,202 @@ import (
 	"github.com/lib/pq"
 	"github.com/liuminhaw/yatijapp/internal/tokenizer"
 	"github.com/liuminhaw/yatijapp/internal/validator"
-	"github.com/yanyiwu/gojieba"
 )
 
 type Target struct {
-	UUID         uuid.UUID    `json:"uuid"`
-	CreatedAt    time.Time    `json:"created_at"`
-	DueDate      sql.NullTime `json:"due_date,omitzero"`
-	UpdatedAt    time.Time    `json:"updated_at"`
-	LastActive   time.Time    `json:"last_active"`
-	Title        string       `json:"title"`
-	Description  string       `json:"description,omitzero"`
-	Notes        string       `json:"notes,omitzero"`
-	Version      int32        `json:"version"`
-	Status       Status       `json:"status,omitzero"` // e.g., "queued", "in progress", "complete", "canceled"
-	SerialID     int64        `json:"-"`               // Optional field for serial ID, not used in all contexts
-	HasNotes     bool         `json:"has_notes"`
-	ActionsCount int64        `json:"actions_count"`
-	Role         string       `json:"role"` // The user's role for this target, e.g., "owner", "editor", "viewer"
+	UUID         uuid.UUID `json:"uuid"`
+	CreatedAt    time.Time `json:"created_at"`
+	DueDate      NullTime  `json:"due_date"`
+	DueTimezone  string    `json:"due_timezone"` // IANA timezone of the due date, UTC when empty
+	UpdatedAt    time.Time `json:"updated_at"`
+	LastActive   time.Time `json:"last_active"`
+	Title        string    `json:"title"`
+	Description  string    `json:"description,omitzero"`
+	Notes        string    `json:"notes,omitzero"`
+	Version      int32     `json:"version"`
+	Status       Status    `json:"status,omitzero"` // e.g., "queued", "in progress", "complete", "canceled"
+	SerialID     int64     `json:"-"`               // Optional field for serial ID, not used in all contexts
+	HasNotes     bool      `json:"has_notes"`
+	ActionsCount int64     `json:"actions_count"`
+	Role         string    `json:"role"` // The user's role for this target, e.g., "owner", "editor", "viewer"
+	// DerivedStatus is the status suggested by the actions of the target, it is empty
+	// when the target has no open or completed action.
+	DerivedStatus Status `json:"derived_status"`
+	// Review is the retrospective of a completed target, only loaded with a single
+	// target and nil until the target is reviewed.
+	Review *TargetReview `json:"review,omitempty"`
+	// Rate is the default hourly rate of the time spent on the target, only loaded with
+	// a single target and nil when the target is not billable.
+	Rate *TargetRate `json:"rate,omitempty"`
 }
 
+// TargetRate is an hourly rate, in minor units of an ISO 4217 currency.
+type TargetRate struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// Format formats the rate with its currency, such as "85.50 EUR".
+func (r TargetRate) Format() string {
+	return FormatAmount(r.Amount, r.Currency)
+}
+
+// Cost returns the cost, in minor units, of spending d at the rate, rounded to the
+// nearest minor unit.
+func (r TargetRate) Cost(d time.Duration) int64 {
+	return (r.Amount*int64(d/time.Second) + 1800) / 3600
+}
+
+func ValidateTargetRate(v *validator.Validator, rate *TargetRate) {
+	v.CheckField(rate.Currency != "", "currency", validator.Required())
+	v.CheckField(
+		ValidCurrency(rate.Currency),
+		"currency",
+		validator.NotPermitted("must be an uppercase ISO 4217 currency code, such as 'EUR'"),
+	)
+	v.CheckField(rate.Amount >= 0, "rate", validator.TooSmall(0, "must not be negative"))
+}
+
+// TargetReview is the retrospective of a completed target, rated from 1 to 5.
+type TargetReview struct {
+	Rating     int       `json:"rating"`
+	Note       string    `json:"note"`
+	ReviewedAt time.Time `json:"reviewed_at"`
+}
+
+func ValidateTargetReview(v *validator.Validator, review *TargetReview) {
+	v.CheckField(review.Rating >= 1, "rating", validator.TooSmall(1, "must be at least 1"))
+	v.CheckField(review.Rating <= 5, "rating", validator.TooLarge(5, "must be at most 5"))
+	v.CheckField(
+		utf8.RuneCountInString(review.Note) <= 2000,
+		"note",
+		validator.TooLong(2000, validator.UnitCharacters),
+	)
+}
+
+// ReviewStats sums up the reviews of the targets a user owns.
+type ReviewStats struct {
+	// Reviewed is the number of targets reviewed in the period, rated AverageRating on
+	// average.
+	Reviewed      int     `json:"reviewed"`
+	AverageRating float64 `json:"average_rating"`
+	// Pending is the number of completed targets not reviewed yet.
+	Pending int `json:"pending"`
+}
+
+// derivedStatusJoin computes the derived_status of the targets aliased t from their
+// actions. Canceled and archived actions are left out: the target is completed once all
+// the other actions are, and in progress as soon as one of them has started.
+const derivedStatusJoin = `
+	LEFT JOIN LATERAL (
+		SELECT CASE
+			WHEN COUNT(*) = 0 THEN ''
+			WHEN bool_and(ch.status = 'completed') THEN 'completed'
+			WHEN bool_or(ch.status IN ('in progress', 'completed')) THEN 'in progress'
+			ELSE 'queued'
+		END AS derived_status
+		FROM actions ch
+		WHERE ch.target_uuid = t.uuid AND ch.status NOT IN ('canceled', 'archived')
+	) ds ON TRUE
+`
+
 func (t Target) IsRecordType() bool {
 	return true
 }
 
 func ValidateTarget(v *validator.Validator, target *Target, on string) {
-	v.Check(target.Title != "", "title", "must be provided")
-	v.Check(
+	v.CheckField(target.Title != "", "title", validator.Required())
+	v.CheckField(
 		utf8.RuneCountInString(target.Title) <= 80,
 		"title",
-		"must not be more than 80 characters long",
+		validator.TooLong(80, validator.UnitCharacters),
 	)
-	v.Check(
+	v.CheckField(
 		utf8.RuneCountInString(target.Description) <= 200,
 		"description",
-		"must not be more than 200 characters long",
+		validator.TooLong(200, validator.UnitCharacters),
 	)
-	v.Check(target.Status != "", "status", "must be provided")
-	v.Check(
+	v.CheckField(target.Status != "", "status", validator.Required())
+	v.CheckField(
 		validator.PermittedValue(target.Status, StatusSafelist...),
 		"status",
-		"must be one of 'queued', 'in progress', 'complete', 'canceled', or 'archived'",
+		validator.NotPermitted(
+			"must be one of 'queued', 'in progress', 'complete', 'canceled', or 'archived'",
+		),
+	)
+	v.CheckField(
+		ValidTimezone(target.DueTimezone),
+		"due_timezone",
+		validator.NotPermitted("must be an IANA timezone name, such as 'Asia/Taipei'"),
 	)
 	if on == "create" && target.DueDate.Valid {
-		v.Check(
+		v.CheckField(
 			target.DueDate.Time.After(time.Now().AddDate(0, 0, -1)),
 			"due_date",
-			"must be in the future",
+			validator.NewFieldError(validator.CodeNotInFuture, "must be in the future"),
 		)
 	}
 }
 
 // TargetModel struct type wraps a sql.DB connection pool.
 type TargetModel struct {
-	DB     DBTX
-	Jieba  *gojieba.Jieba
-	logger *slog.Logger
+	DB        DBTX
+	Segmenter tokenizer.Segmenter
+	Cipher    *NotesCipher
+	FTS       *FTSConfig
+	logger    *slog.Logger
 }
 
 func (t TargetModel) Insert(ctx context.Context, target *Target, userUUID uuid.UUID) error {
-	fts := GenFTS(target.Title, target.Description, target.Notes, t.Jieba)
+	fts := GenFTS(
+		target.Title,
+		target.Description,
+		t.Cipher.Searchable(target.Notes),
+		t.Segmenter,
+	)
+	notes, err := t.Cipher.Seal(target.Notes)
+	if err != nil {
+		return err
+	}
 
-	query := `
+	query := t.FTS.weighted(`
 		WITH new_target AS (
-			INSERT INTO targets (title, description, notes, due_date, status)
-			VALUES ($1, $2, $3, $4, $5)
+			INSERT INTO targets (title, description, notes, due_date, due_timezone, status)
+			VALUES ($1, $2, $3, $4, $13, $5)
 			RETURNING uuid, created_at, updated_at, version
 		), grant_acl AS (
 			INSERT INTO acls (user_uuid, resource_type, resource_uuid, role_code)
 			SELECT $6, 'target', uuid, 'owner' FROM new_target
+		), audit_acl AS (
+			INSERT INTO acl_audit (
+				resource_type, resource_uuid, actor_uuid, grantee_uuid, change, new_role
+			)
+			SELECT 'target', uuid, $6, $6, 'grant', 'owner' FROM new_target
 		), new_fts AS (
 			INSERT INTO targets_fts (
 				target_uuid, 
 				fts_chinese_tsv, 
 				fts_english_tsv, 
 				fts_chinese_notes_tsv, 
-				fts_english_notes_tsv
+				fts_english_notes_tsv,
+				archived
 			) SELECT
 				uuid,
-				setweight(to_tsvector('simple', $7), 'A') ||
-				setweight(to_tsvector('simple', $8), 'B'),
-				setweight(to_tsvector('english', $10), 'A') ||
-				setweight(to_tsvector('english', $11), 'B'),
+				setweight(to_tsvector('simple', $7), '%[1]s') ||
+				setweight(to_tsvector('simple', $8), '%[2]s'),
+				setweight(to_tsvector('english', $10), '%[1]s') ||
+				setweight(to_tsvector('english', $11), '%[2]s'),
 				to_tsvector('simple', $9),
-				to_tsvector('english', $12)
+				to_tsvector('english', $12),
+				$5 = 'archived'
 			FROM new_target
 		)
 		SELECT uuid, created_at, updated_at, version FROM new_target;
-	`
+	`)
 	args := []any{
 		target.Title,
 		target.Description,
-		target.Notes,
+		notes,
 		target.DueDate,
 		target.Status,
 		userUUID,
@@ -114,9 +218,10 @@ func (t TargetModel) Insert(ctx context.Context, target *Target, userUUID uuid.U
 		fts.TitleToken.English,
 		fts.DescriptionToken.English,
 		fts.NotesToken.English,
+		target.DueTimezone,
 	}
 
-	err := t.DB.QueryRowContext(ctx, query, args...).
+	err = t.DB.QueryRowContext(ctx, query, args...).
 		Scan(&target.UUID, &target.CreatedAt, &target.UpdatedAt, &target.Version)
 	if err != nil {
 		return err
@@ -125,36 +230,54 @@ func (t TargetModel) Insert(ctx context.Context, target *Target, userUUID uuid.U
 	return nil
 }
 
-func (t TargetModel) Get(uuid, userUUID uuid.UUID, minRole string) (*Target, error) {
+func (t TargetModel) Get(
+	ctx context.Context,
+	uuid, userUUID uuid.UUID,
+	minRole string,
+) (*Target, error) {
 	query := `
 		SELECT 
 			t.uuid, 
 			t.created_at, 
 			t.due_date, 
+			t.due_timezone,
 			t.updated_at, 
 			t.last_active,
 			t.title, 
 			t.description, 
 			t.notes, 
 			t.status, 
-			t.version
+			t.version,
+			ds.derived_status,
+			t.review_rating,
+			t.review_note,
+			t.reviewed_at,
+			t.rate,
+			t.currency
 		FROM targets t
 		JOIN acls a ON a.resource_type = 'target' AND a.resource_uuid = t.uuid
 		JOIN roles r ON a.role_code = r.code
+		` + derivedStatusJoin + `
 		WHERE uuid = $1 
 			AND a.user_uuid = $2 
 			AND r.rank <= (SELECT rank FROM roles WHERE code = $3)
 	`
 
 	var target Target
+	var review TargetReview
+	var rating sql.NullInt16
+	var reviewedAt sql.NullTime
+	var rate sql.NullInt64
+	var currency string
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := t.DB.QueryRowContext(ctx, query, uuid, userUUID, minRole).Scan(
 		&target.UUID,
 		&target.CreatedAt,
 		&target.DueDate,
+		&target.DueTimezone,
 		&target.UpdatedAt,
 		&target.LastActive,
 		&target.Title,
@@ -162,6 +285,12 @@ func (t TargetModel) Get(uuid, userUUID uuid.UUID, minRole string) (*Target, err
 		&target.Notes,
 		&target.Status,
 		&target.Version,
+		&target.DerivedStatus,
+		&rating,
+		&review.Note,
+		&reviewedAt,
+		&rate,
+		&currency,
 	)
 	if err != nil {
 		switch {
@@ -172,17 +301,190 @@ func (t TargetModel) Get(uuid, userUUID uuid.UUID, minRole string) (*Target, err
 		}
 	}
 
+	target.Notes, err = t.Cipher.Open(target.Notes)
+	if err != nil {
+		return nil, err
+	}
+
+	if rating.Valid {
+		review.Rating, review.ReviewedAt = int(rating.Int16), reviewedAt.Time
+		target.Review = &review
+	}
+	if rate.Valid {
+		target.Rate = &TargetRate{Amount: rate.Int64, Currency: currency}
+	}
+
 	return &target, nil
 }
 
-func (t TargetModel) Update(target *Target, fts FTS, userUUID uuid.UUID) error {
+// UpdateRate sets the hourly rate of a target the user can edit, a nil rate makes the
+// target not billable.
+func (t TargetModel) UpdateRate(
+	ctx context.Context,
+	uuid uuid.UUID,
+	rate *TargetRate,
+	userUUID uuid.UUID,
+) error {
+	query := `
+		UPDATE targets AS t
+		SET rate = $1, currency = $2
+		WHERE t.uuid = $3 AND EXISTS (
+			SELECT 1
+			FROM acls a
+			JOIN roles r ON a.role_code = r.code
+			WHERE a.resource_type = 'target'
+			AND a.resource_uuid = t.uuid
+			AND a.user_uuid = $4
+			AND r.rank <= (SELECT rank FROM roles WHERE code = 'editor')
+		)
+	`
+
+	amount, currency := sql.NullInt64{}, ""
+	if rate != nil {
+		amount, currency = sql.NullInt64{Int64: rate.Amount, Valid: true}, rate.Currency
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := t.DB.ExecContext(ctx, query, amount, currency, uuid, userUUID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetRates returns the hourly rates of the billable targets among the given ones.
+func (t TargetModel) GetRates(
+	ctx context.Context,
+	uuids []uuid.UUID,
+) (map[uuid.UUID]TargetRate, error) {
+	query := `
+		SELECT uuid, rate, currency
+		FROM targets
+		WHERE uuid = ANY($1::uuid[]) AND rate IS NOT NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := t.DB.QueryContext(ctx, query, pq.Array(uuids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := make(map[uuid.UUID]TargetRate)
+	for rows.Next() {
+		var id uuid.UUID
+		var rate TargetRate
+		if err := rows.Scan(&id, &rate.Amount, &rate.Currency); err != nil {
+			return nil, err
+		}
+		rates[id] = rate
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rates, nil
+}
+
+// UpdateReview saves the review of a completed target the user can edit.
+// ErrRecordNotFound is returned when the target is not completed or cannot be edited.
+func (t TargetModel) UpdateReview(
+	ctx context.Context,
+	uuid uuid.UUID,
+	review *TargetReview,
+	userUUID uuid.UUID,
+) error {
 	query := `
+		UPDATE targets AS t
+		SET review_rating = $1, review_note = $2, reviewed_at = NOW()
+		WHERE t.uuid = $3 AND t.status = 'completed' AND EXISTS (
+			SELECT 1
+			FROM acls a
+			JOIN roles r ON a.role_code = r.code
+			WHERE a.resource_type = 'target'
+			AND a.resource_uuid = t.uuid
+			AND a.user_uuid = $4
+			AND r.rank <= (SELECT rank FROM roles WHERE code = 'editor')
+		)
+		RETURNING reviewed_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := t.DB.QueryRowContext(ctx, query, review.Rating, review.Note, uuid, userUUID).
+		Scan(&review.ReviewedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReviewStats returns the review stats of the targets the user owns, the reviews being
+// counted over [from, to).
+func (t TargetModel) ReviewStats(
+	ctx context.Context,
+	from, to time.Time,
+	userUUID uuid.UUID,
+) (ReviewStats, error) {
+	query := `
+		SELECT
+			COUNT(*) FILTER (WHERE t.reviewed_at >= $1 AND t.reviewed_at < $2),
+			COALESCE(
+				AVG(t.review_rating) FILTER (WHERE t.reviewed_at >= $1 AND t.reviewed_at < $2),
+				0
+			),
+			COUNT(*) FILTER (WHERE t.status = 'completed' AND t.review_rating IS NULL)
+		FROM targets t
+		JOIN acls a ON a.resource_type = 'target' AND a.resource_uuid = t.uuid
+		WHERE a.user_uuid = $3 AND a.role_code = 'owner'
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var stats ReviewStats
+	err := t.DB.QueryRowContext(ctx, query, from, to, userUUID).
+		Scan(&stats.Reviewed, &stats.AverageRating, &stats.Pending)
+
+	return stats, err
+}
+
+func (t TargetModel) Update(
+	ctx context.Context,
+	target *Target,
+	fts FTS,
+	userUUID uuid.UUID,
+) error {
+	query := t.FTS.weighted(`
 		WITH update_target AS(
 			UPDATE targets AS t
 			SET title = $1, 
 				description = $2, 
 				notes = $3, 
 				due_date = $4, 
+				due_timezone = $15,
 				status = $5, 
 				version = version + 1, 
 				updated_at = NOW(), 
@@ -199,22 +501,28 @@ func (t TargetModel) Update(target *Target, fts FTS, userUUID uuid.UUID) error {
 			RETURNING t.uuid, t.created_at, t.updated_at, t.version
 		), update_fts AS (
 			UPDATE targets_fts AS fts
-			SET fts_chinese_tsv = setweight(to_tsvector('simple', $9), 'A') ||
-					setweight(to_tsvector('simple', $10), 'B'),
-				fts_english_tsv = setweight(to_tsvector('english', $12), 'A') ||
-					setweight(to_tsvector('english', $13), 'B'),
+			SET fts_chinese_tsv = setweight(to_tsvector('simple', $9), '%[1]s') ||
+					setweight(to_tsvector('simple', $10), '%[2]s'),
+				fts_english_tsv = setweight(to_tsvector('english', $12), '%[1]s') ||
+					setweight(to_tsvector('english', $13), '%[2]s'),
 				fts_chinese_notes_tsv = to_tsvector('simple', $11),
-				fts_english_notes_tsv = to_tsvector('english', $14)
+				fts_english_notes_tsv = to_tsvector('english', $14),
+				archived = $5 = 'archived'
 			FROM update_target ut
 			WHERE fts.target_uuid = ut.uuid
 		)
 		SELECT t.created_at, t.updated_at, t.version FROM update_target t;
-	`
+	`)
+
+	notes, err := t.Cipher.Seal(target.Notes)
+	if err != nil {
+		return err
+	}
 
 	args := []any{
 		target.Title,
 		target.Description,
-		target.Notes,
+		notes,
 		target.DueDate,
 		target.Status,
 		target.UUID,
@@ -226,12 +534,13 @@ func (t TargetModel) Update(target *Target, fts FTS, userUUID uuid.UUID) error {
 		fts.TitleToken.English,
 		fts.DescriptionToken.English,
 		fts.NotesToken.English,
+		target.DueTimezone,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	err := t.DB.QueryRowContext(ctx, query, args...).
+	err = t.DB.QueryRowContext(ctx, query, args...).
 		Scan(&target.CreatedAt, &target.UpdatedAt, &target.Version)
 	if err != nil {
 		switch {
@@ -245,7 +554,43 @@ func (t TargetModel) Update(target *Target, fts FTS, userUUID uuid.UUID) error {
 	return nil
 }
 
-func (t TargetModel) Delete(uuid, userUUID uuid.UUID) error {
+// Start moves a queued target to in progress, as suggested by its derived status once
+// one of its actions has started. It reports whether the target was moved, which is not
+// the case for targets already started or which the user is not allowed to edit.
+func (t TargetModel) Start(ctx context.Context, uuid, userUUID uuid.UUID) (bool, error) {
+	query := `
+		UPDATE targets AS t
+		SET status = 'in progress', version = version + 1, updated_at = NOW()
+		WHERE t.uuid = $1 AND t.status = 'queued' AND EXISTS (
+			SELECT 1
+			FROM acls a
+			JOIN roles r ON a.role_code = r.code
+			WHERE a.resource_type = 'target'
+			AND a.resource_uuid = $1
+			AND a.user_uuid = $2
+			AND r.rank <= (SELECT rank FROM roles WHERE code = 'editor')
+		)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := t.DB.ExecContext(ctx, query, uuid, userUUID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (t TargetModel) Delete(ctx context.Context, uuid, userUUID uuid.UUID) error {
+	forgetACLs(ctx)
+
 	query := `
 		DELETE FROM targets
 		WHERE uuid = $1 AND EXISTS (
@@ -259,7 +604,7 @@ func (t TargetModel) Delete(uuid, userUUID uuid.UUID) error {
 		)
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	result, err := t.DB.ExecContext(ctx, query, uuid, userUUID)
@@ -279,7 +624,78 @@ func (t TargetModel) Delete(uuid, userUUID uuid.UUID) error {
 	return nil
 }
 
+// MaxReferenceMatches is the number of targets FindByReference returns at most.
+const MaxReferenceMatches = 5
+
+// FindByReference returns the targets the user can edit, and which are not archived,
+// whose title is the reference, compared in lower case with the dashes, underscores and
+// runs of spaces as single spaces. When there is none, the targets whose title contains
+// the reference are returned instead, the most recently active first.
+func (t TargetModel) FindByReference(
+	ctx context.Context,
+	reference string,
+	userUUID uuid.UUID,
+) ([]*Target, error) {
+	query := `
+		WITH editable AS (
+			SELECT
+				t.uuid,
+				t.title,
+				t.last_active,
+				regexp_replace(lower(t.title), '[\s_-]+', ' ', 'g') AS normalized
+			FROM targets t
+			WHERE t.status <> 'archived' AND EXISTS (
+				SELECT 1
+				FROM acls ac
+				JOIN roles r ON ac.role_code = r.code
+				WHERE ac.user_uuid = $2
+				AND ac.resource_type = 'target'
+				AND ac.resource_uuid = t.uuid
+				AND r.rank <= (SELECT rank FROM roles WHERE code = 'editor')
+			)
+		),
+		exact AS (
+			SELECT uuid, title, last_active FROM editable WHERE normalized = $1
+		)
+		SELECT uuid, title FROM exact
+		UNION ALL
+		(
+			SELECT uuid, title FROM editable
+			WHERE NOT EXISTS (SELECT 1 FROM exact)
+			AND strpos(normalized, $1) > 0
+			ORDER BY last_active DESC
+			LIMIT $3
+		)
+		LIMIT $3
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := t.DB.QueryContext(ctx, query, reference, userUUID, MaxReferenceMatches)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := []*Target{}
+	for rows.Next() {
+		var target Target
+		if err := rows.Scan(&target.UUID, &target.Title); err != nil {
+			return nil, err
+		}
+		targets = append(targets, &target)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return targets, nil
+}
+
 func (t TargetModel) GetAllForUser(
+	ctx context.Context,
 	token tokenizer.Tokenizer,
 	filters Filters,
 	userUUID uuid.UUID,
@@ -292,6 +708,8 @@ func (t TargetModel) GetAllForUser(
 			WHERE ($1 = '' OR fts.fts_chinese_tsv @@ plainto_tsquery('simple', $1))
 				AND ($2 = '' OR fts.fts_english_tsv @@ plainto_tsquery('english', $2))
 				AND ($3 = '{}' OR t.status = ANY ($3::statuses[]))
+				AND %s
+				AND %s
 				AND EXISTS (
 					SELECT 1
 					FROM acls ac
@@ -310,6 +728,7 @@ func (t TargetModel) GetAllForUser(
 				t.uuid,
 				t.created_at,
 				t.due_date,
+				t.due_timezone,
 				t.updated_at,
 				t.last_active,
 				t.title,
@@ -318,12 +737,13 @@ func (t TargetModel) GetAllForUser(
 				t.version,
 				t.serial_id,
 				COALESCE(ss.actions_count, 0) AS actions_count,
+				ds.derived_status,
 				(btrim(COALESCE(t.notes, '')) <> '') AS has_notes,
 				(CASE WHEN $1 <> '' THEN
-					ts_rank(fts.fts_chinese_tsv, plainto_tsquery('simple', $1))
+					%s
 				ELSE 0 END) + (CASE WHEN $2 <> '' THEN
-					ts_rank(fts.fts_english_tsv, plainto_tsquery('english', $2))
-				ELSE 0 END) AS rank
+					%s
+				ELSE 0 END)%s AS rank
 			FROM filtered f
 			JOIN targets t ON f.uuid = t.uuid
 			JOIN targets_fts fts ON fts.target_uuid = t.uuid
@@ -333,7 +753,8 @@ func (t TargetModel) GetAllForUser(
 				JOIN filtered fl ON fl.uuid = a.target_uuid
 				GROUP BY a.target_uuid
 			) ss ON ss.target_uuid = t.uuid
-			ORDER BY t.%s %s, rank DESC, t.serial_id DESC
+			%s
+			ORDER BY %s rank DESC, t.serial_id DESC
 			LIMIT $5 OFFSET $6
 		)
 		SELECT
@@ -341,6 +762,7 @@ func (t TargetModel) GetAllForUser(
 			p.uuid,
 			p.created_at,
 			p.due_date,
+			p.due_timezone,
 			p.updated_at,
 			p.last_active,
 			p.title,
@@ -349,6 +771,7 @@ func (t TargetModel) GetAllForUser(
 			p.version,
 			p.serial_id,
 			p.actions_count,
+			p.derived_status,
 			p.has_notes,
 			ac.role_code,
 			p.rank
@@ -358,10 +781,19 @@ func (t TargetModel) GetAllForUser(
 			AND ac.resource_type = 'target'
 			AND ac.resource_uuid = p.uuid
 		CROSS JOIN total
-		ORDER BY p.%s %s, p.rank DESC, p.serial_id DESC
-	`, filters.sortColumn(), filters.sortDirection(), filters.sortColumn(), filters.sortDirection())
+		ORDER BY %s p.rank DESC, p.serial_id DESC
+	`,
+		filters.recordFilterClause("t", 7),
+		roleFilterClause(4, 10, "('target', t.uuid)"),
+		t.FTS.tsRank("fts.fts_chinese_tsv", "plainto_tsquery('simple', $1)"),
+		t.FTS.tsRank("fts.fts_english_tsv", "plainto_tsquery('english', $2)"),
+		t.FTS.boost("t", filters),
+		derivedStatusJoin,
+		filters.orderBy("t"),
+		filters.orderBy("p"),
+	)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	args := []any{
@@ -372,6 +804,8 @@ func (t TargetModel) GetAllForUser(
 		filters.limit(),
 		filters.offset(),
 	}
+	args = append(args, filters.recordFilterArgs()...)
+	args = append(args, pq.Array(filters.Role))
 
 	rows, err := t.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -390,6 +824,7 @@ func (t TargetModel) GetAllForUser(
 			&target.UUID,
 			&target.CreatedAt,
 			&target.DueDate,
+			&target.DueTimezone,
 			&target.UpdatedAt,
 			&target.LastActive,
 			&target.Title,
@@ -398,6 +833,7 @@ func (t TargetModel) GetAllForUser(
 			&target.Version,
 			&target.SerialID,
 			&target.ActionsCount,
+			&target.DerivedStatus,
 			&target.HasNotes,
 			&target.Role,
 			&ignored,
@@ -413,7 +849,70 @@ func (t TargetModel) GetAllForUser(
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	metadata := calculateMetadata(totalRecords, filters)
 
 	return targets, metadata, nil
 }
+
+// Fingerprint returns the fingerprint of the targets matching the given search
+// tokens and filters, without fetching the records themselves.
+func (t TargetModel) Fingerprint(
+	ctx context.Context,
+	token tokenizer.Tokenizer,
+	filters Filters,
+	userUUID uuid.UUID,
+) (Fingerprint, error) {
+	query := fmt.Sprintf(`
+		WITH filtered AS MATERIALIZED (
+			SELECT t.uuid
+			FROM targets t
+			JOIN targets_fts fts ON fts.target_uuid = t.uuid
+			WHERE ($1 = '' OR fts.fts_chinese_tsv @@ plainto_tsquery('simple', $1))
+				AND ($2 = '' OR fts.fts_english_tsv @@ plainto_tsquery('english', $2))
+				AND ($3 = '{}' OR t.status = ANY ($3::statuses[]))
+				AND %s
+				AND %s
+				AND EXISTS (
+					SELECT 1
+					FROM acls ac
+					JOIN roles r ON ac.role_code = r.code
+					WHERE ac.user_uuid = $4
+					AND ac.resource_type = 'target'
+					AND ac.resource_uuid = t.uuid
+					AND r.rank <= (SELECT rank FROM roles WHERE code = 'viewer')
+				)
+		)
+		SELECT
+			(SELECT COUNT(*) FROM filtered),
+			-- The derived status changes along with the actions of the targets.
+			COALESCE(GREATEST(
+				(SELECT MAX(t.updated_at) FROM filtered f JOIN targets t ON t.uuid = f.uuid),
+				(SELECT MAX(a.updated_at) FROM filtered f JOIN actions a ON a.target_uuid = f.uuid)
+			), to_timestamp(0)),
+			(SELECT COUNT(*) FROM actions a JOIN filtered f ON f.uuid = a.target_uuid)
+	`,
+		filters.recordFilterClause("t", 5),
+		roleFilterClause(4, 8, "('target', t.uuid)"),
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	args := []any{
+		token.Chinese,
+		token.English,
+		pq.Array(filters.Status),
+		userUUID,
+	}
+	args = append(args, filters.recordFilterArgs()...)
+	args = append(args, pq.Array(filters.Role))
+
+	var fp Fingerprint
+	err := t.DB.QueryRowContext(ctx, query, args...).
+		Scan(&fp.TotalRecords, &fp.LastUpdated, &fp.ChildCount)
+	if err != nil {
+		return Fingerprint{}, err
+	}
+
+	return fp, nil
+}
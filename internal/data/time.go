@@ -2,25 +2,50 @@ package data
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"strconv"
+	"strings"
 	"time"
 )
 
-var ErrInvalidTimeFormat = errors.New("invalid date format, expected YYYY-mm-dd")
+var (
+	ErrInvalidTimeFormat = errors.New(
+		"invalid date format, expected YYYY-mm-dd, YYYY-mm-ddTHH:MM, RFC3339 " +
+			"or a relative date such as 'tomorrow', 'next friday' or 'in 2 weeks'",
+	)
+	ErrInvalidTimestampFormat = errors.New("invalid timestamp format, expected RFC3339")
+)
 
-var acceptedFormats = []string{
-	"2006-01-02",
+// acceptedFormats lists the formats of the input dates. Dates and times given without
+// an offset are local to the timezone they are resolved in.
+var acceptedFormats = []struct {
+	layout   string
+	local    bool
+	dateOnly bool
+}{
+	{layout: "2006-01-02", local: true, dateOnly: true},
+	{layout: "2006-01-02T15:04", local: true},
+	{layout: "2006-01-02T15:04:05", local: true},
+	{layout: time.RFC3339},
 }
 
-// type InputDate time.Time
-type InputDate sql.NullTime
+// InputDate is a date read from a request, with an optional time of day. A date given
+// without an offset, such as 2006-01-02 or 2006-01-02T15:04, is resolved in a timezone
+// with In, it is taken as UTC otherwise. Relative dates, such as "tomorrow", are
+// resolved from the current day in that timezone.
+type InputDate struct {
+	value    sql.NullTime
+	local    bool
+	dateOnly bool
+	relative func(today time.Time) time.Time
+}
 
 // Implement a UnmarshalJSON method on the InputTime type so that it satisfies
 // the json.Unmarshaler interface.
 func (it *InputDate) UnmarshalJSON(jsonValue []byte) error {
 	if string(jsonValue) == "null" {
-		*it = InputDate(sql.NullTime{Valid: false})
+		*it = InputDate{}
 		return nil
 	}
 
@@ -29,44 +54,245 @@ func (it *InputDate) UnmarshalJSON(jsonValue []byte) error {
 		return ErrInvalidTimeFormat
 	}
 
-	if unquotedJSONValue == "" {
-		*it = InputDate(sql.NullTime{Valid: false})
-		return nil
+	*it, err = ParseInputDate(unquotedJSONValue)
+	return err
+}
+
+// ParseInputDate parses a date in one of the accepted formats, or a relative date. An
+// empty value is an unset date.
+func ParseInputDate(value string) (InputDate, error) {
+	if value == "" {
+		return InputDate{}, nil
 	}
 
 	for _, format := range acceptedFormats {
-		parsedTime, err := time.Parse(format, unquotedJSONValue)
+		parsedTime, err := time.Parse(format.layout, value)
 		if err == nil {
-			*it = InputDate(sql.NullTime{
-				Time:  parsedTime,
-				Valid: true,
-			})
-			return nil
+			return InputDate{
+				value:    sql.NullTime{Time: parsedTime, Valid: true},
+				local:    format.local,
+				dateOnly: format.dateOnly,
+			}, nil
 		}
 	}
 
-	return ErrInvalidTimeFormat
+	if relative, ok := parseRelativeDate(value); ok {
+		return InputDate{
+			value:    sql.NullTime{Time: relative(today(time.UTC)), Valid: true},
+			local:    true,
+			dateOnly: true,
+			relative: relative,
+		}, nil
+	}
+
+	return InputDate{}, ErrInvalidTimeFormat
 }
 
 func (it InputDate) MarshalJSON() ([]byte, error) {
-	nullTime := sql.NullTime(it)
-	if !nullTime.Valid {
-		return []byte(""), nil
+	if !it.value.Valid {
+		return []byte("null"), nil
+	}
+
+	return []byte(strconv.Quote(it.String())), nil
+}
+
+// In returns the date resolved in the timezone, an IANA name. The dates given with an
+// offset keep the instant they stand for and are only converted to the timezone. An
+// empty or unknown timezone stands for UTC.
+func (it InputDate) In(timezone string) InputDate {
+	if !it.value.Valid {
+		return it
+	}
+
+	loc := location(timezone)
+	t := it.value.Time.In(loc)
+	if it.local {
+		w := it.value.Time
+		if it.relative != nil {
+			w = it.relative(today(loc))
+		}
+		t = time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), w.Second(), 0, loc)
 	}
 
-	formattedTime := nullTime.Time.Format("2006-01-02")
-	return []byte(strconv.Quote(formattedTime)), nil
+	return InputDate{
+		value:    sql.NullTime{Time: t, Valid: true},
+		dateOnly: it.dateOnly,
+	}
+}
+
+// NullTime returns the date as a NullTime, ready to be stored in a model.
+func (it InputDate) NullTime() NullTime {
+	return NullTime{NullTime: it.value}
 }
 
 func (it InputDate) GetTime() time.Time {
-	return sql.NullTime(it).Time
+	return it.value.Time
 }
 
 func (it InputDate) String() string {
-	nullTime := sql.NullTime(it)
-	if !nullTime.Valid {
+	if !it.value.Valid {
 		return ""
 	}
 
-	return nullTime.Time.Format("2006-01-02")
+	if it.dateOnly {
+		return it.value.Time.Format("2006-01-02")
+	}
+	return it.value.Time.Format(time.RFC3339)
+}
+
+// today returns the current date in loc, as midnight UTC of that date.
+func today(loc *time.Location) time.Time {
+	now := time.Now().In(loc)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// relativeUnits maps the units of the "in <n> <unit>" relative dates to the number of
+// days, months and years they add.
+var relativeUnits = map[string][3]int{
+	"day":   {1, 0, 0},
+	"week":  {7, 0, 0},
+	"month": {0, 1, 0},
+	"year":  {0, 0, 1},
+}
+
+// parseRelativeDate parses a relative date, case insensitively, and returns the function
+// computing it from the current date. Accepted are "today", "tomorrow", a weekday or
+// "next <weekday>" for the first such day after today, "next week|month|year" and
+// "in <n> <unit>", where n is a number, "a" or "an" and unit one of day, week, month or
+// year, singular or plural.
+func parseRelativeDate(value string) (func(today time.Time) time.Time, bool) {
+	fields := strings.Fields(strings.ToLower(value))
+	add := func(days, months, years int) func(time.Time) time.Time {
+		return func(today time.Time) time.Time {
+			return today.AddDate(years, months, days)
+		}
+	}
+
+	switch {
+	case len(fields) == 1 && fields[0] == "today":
+		return add(0, 0, 0), true
+	case len(fields) == 1 && fields[0] == "tomorrow":
+		return add(1, 0, 0), true
+	case len(fields) == 2 && fields[0] == "next":
+		if unit, ok := relativeUnits[fields[1]]; ok && fields[1] != "day" {
+			return add(unit[0], unit[1], unit[2]), true
+		}
+		return weekdayAfter(fields[1])
+	case len(fields) == 1:
+		return weekdayAfter(fields[0])
+	case len(fields) == 3 && fields[0] == "in":
+		n, err := strconv.Atoi(fields[1])
+		switch {
+		case fields[1] == "a" || fields[1] == "an":
+			n = 1
+		case err != nil || n < 0 || n > 1000:
+			return nil, false
+		}
+		unit, ok := relativeUnits[strings.TrimSuffix(fields[2], "s")]
+		if !ok {
+			return nil, false
+		}
+		return add(n*unit[0], n*unit[1], n*unit[2]), true
+	}
+
+	return nil, false
+}
+
+// weekdayAfter returns the function computing the first given weekday after today.
+func weekdayAfter(name string) (func(today time.Time) time.Time, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.ToLower(d.String()) != name {
+			continue
+		}
+		return func(today time.Time) time.Time {
+			days := (int(d) - int(today.Weekday()) + 6) % 7
+			return today.AddDate(0, 0, days+1)
+		}, true
+	}
+
+	return nil, false
+}
+
+// ValidTimezone reports whether the timezone is empty, standing for UTC, or a known
+// IANA timezone name.
+func ValidTimezone(timezone string) bool {
+	if timezone == "" {
+		return true
+	}
+
+	_, err := time.LoadLocation(timezone)
+	return err == nil
+}
+
+// location returns the location of the timezone, UTC when it is empty or unknown.
+func location(timezone string) *time.Location {
+	if timezone == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// NullTime is a nullable timestamp which is represented in JSON as null when it is
+// not set, or as an RFC3339 string otherwise. It embeds sql.NullTime so that it can
+// be scanned from and written to the database directly.
+type NullTime struct {
+	sql.NullTime
+}
+
+// NewNullTime returns a valid NullTime holding t.
+func NewNullTime(t time.Time) NullTime {
+	return NullTime{NullTime: sql.NullTime{Time: t, Valid: true}}
+}
+
+func (nt NullTime) MarshalJSON() ([]byte, error) {
+	if !nt.Valid {
+		return []byte("null"), nil
+	}
+
+	return nt.Time.MarshalJSON()
+}
+
+// In returns the timestamp in the timezone, an IANA name, UTC when it is empty or
+// unknown.
+func (nt NullTime) In(timezone string) NullTime {
+	if !nt.Valid {
+		return nt
+	}
+
+	return NewNullTime(nt.Time.In(location(timezone)))
+}
+
+// UnmarshalJSON decodes null or an RFC3339 string. The {"Time": ..., "Valid": ...}
+// objects sent by the clients written against the former encoding of sql.NullTime are
+// still accepted.
+func (nt *NullTime) UnmarshalJSON(jsonValue []byte) error {
+	if string(jsonValue) == "null" {
+		*nt = NullTime{}
+		return nil
+	}
+
+	if strings.HasPrefix(string(jsonValue), "{") {
+		var legacy sql.NullTime
+		if err := json.Unmarshal(jsonValue, &legacy); err != nil {
+			return ErrInvalidTimestampFormat
+		}
+		*nt = NullTime{}
+		if legacy.Valid {
+			*nt = NewNullTime(legacy.Time)
+		}
+		return nil
+	}
+
+	var t time.Time
+	if err := t.UnmarshalJSON(jsonValue); err != nil {
+		return ErrInvalidTimestampFormat
+	}
+
+	*nt = NewNullTime(t)
+	return nil
 }
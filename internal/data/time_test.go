@@ -0,0 +1,65 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestNullTimeJSON(t *testing.T) {
+	at := time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		input string
+		want  NullTime
+		// output is the encoding of the decoded value.
+		output string
+	}{
+		{name: "null", input: `null`, output: `null`},
+		{
+			name:   "RFC3339",
+			input:  `"2026-10-17T09:30:00Z"`,
+			want:   NewNullTime(at),
+			output: `"2026-10-17T09:30:00Z"`,
+		},
+		{
+			name:   "legacy object",
+			input:  `{"Time": "2026-10-17T09:30:00Z", "Valid": true}`,
+			want:   NewNullTime(at),
+			output: `"2026-10-17T09:30:00Z"`,
+		},
+		{
+			name:   "legacy invalid object",
+			input:  `{"Time": "0001-01-01T00:00:00Z", "Valid": false}`,
+			output: `null`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got NullTime
+			if err := json.Unmarshal([]byte(tt.input), &got); err != nil {
+				t.Fatalf("unmarshal %s: %v", tt.input, err)
+			}
+			if got.Valid != tt.want.Valid || !got.Time.Equal(tt.want.Time) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+
+			js, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			if string(js) != tt.output {
+				t.Errorf("got %s, want %s", js, tt.output)
+			}
+		})
+	}
+
+	for _, input := range []string{`"yesterday"`, `{"Time": 1}`, `12`} {
+		var nt NullTime
+		if err := json.Unmarshal([]byte(input), &nt); err == nil {
+			t.Errorf("%s: no error", input)
+		}
+	}
+}
@@ -17,6 +17,9 @@ const (
 	ScopeAuthentication = "authentication"
 	ScopeRefresh        = "refresh"
 	ScopePasswordReset  = "password-reset"
+	// ScopeSessionRevocation tokens are sent to users signing in from a new device, so
+	// that they can revoke the session without being signed in.
+	ScopeSessionRevocation = "session-revocation"
 )
 
 // Token struct holds the information for an individual token.
@@ -50,8 +53,8 @@ func generateToken(
 }
 
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
-	v.Check(tokenPlaintext != "", "token", "must be provided")
-	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+	v.CheckField(tokenPlaintext != "", "token", validator.Required())
+	v.CheckField(len(tokenPlaintext) == 26, "token", validator.WrongLength(26, validator.UnitBytes))
 }
 
 type TokenModel struct {
@@ -60,6 +63,7 @@ type TokenModel struct {
 
 // New() method creates a new token and inserts it into the database tokens table.
 func (m TokenModel) New(
+	ctx context.Context,
 	userUUID uuid.UUID,
 	sessionUUID uuid.UUID,
 	ttl time.Duration,
@@ -67,11 +71,11 @@ func (m TokenModel) New(
 ) (*Token, error) {
 	token := generateToken(userUUID, sessionUUID, ttl, scope)
 
-	err := m.Insert(token)
+	err := m.Insert(ctx, token)
 	return token, err
 }
 
-func (m TokenModel) Get(tokenPlaintext, scope string) (*Token, error) {
+func (m TokenModel) Get(ctx context.Context, tokenPlaintext, scope string) (*Token, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	query := `
@@ -80,7 +84,7 @@ func (m TokenModel) Get(tokenPlaintext, scope string) (*Token, error) {
 		WHERE hash = $1 AND scope = $2 AND expiry > $3`
 	args := []any{tokenHash[:], scope, time.Now()}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	var token Token
@@ -103,20 +107,20 @@ func (m TokenModel) Get(tokenPlaintext, scope string) (*Token, error) {
 	return &token, nil
 }
 
-func (m TokenModel) Insert(token *Token) error {
+func (m TokenModel) Insert(ctx context.Context, token *Token) error {
 	query := `
 		INSERT INTO tokens (hash, user_uuid, session_uuid, expiry, scope)
 		VALUES ($1, $2, $3, $4, $5)`
 
 	args := []any{token.Hash, token.UserUUID, token.SessionUUID, token.Expiry, token.Scope}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	return err
 }
 
-func (m TokenModel) Delete(tokenPlaintext, scope string) error {
+func (m TokenModel) Delete(ctx context.Context, tokenPlaintext, scope string) error {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	query := `
@@ -124,7 +128,7 @@ func (m TokenModel) Delete(tokenPlaintext, scope string) error {
 		WHERE hash = $1 AND scope = $2`
 	args := []any{tokenHash[:], scope}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, args...)
@@ -132,12 +136,12 @@ func (m TokenModel) Delete(tokenPlaintext, scope string) error {
 }
 
 // DeleteAllForUser() deletes all tokens for a specific user and scope.
-func (m TokenModel) DeleteAllForUser(scope string, userUUID uuid.UUID) error {
+func (m TokenModel) DeleteAllForUser(ctx context.Context, scope string, userUUID uuid.UUID) error {
 	query := `
 		DELETE FROM tokens
 		WHERE scope = $1 AND user_uuid = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, scope, userUUID)
@@ -145,12 +149,15 @@ func (m TokenModel) DeleteAllForUser(scope string, userUUID uuid.UUID) error {
 }
 
 // DeleteAllForUserSession() deletes all tokens for a specific user's session.
-func (m TokenModel) DeleteAllForUserSession(userUUID, sessionUUID uuid.UUID) error {
+func (m TokenModel) DeleteAllForUserSession(
+	ctx context.Context,
+	userUUID, sessionUUID uuid.UUID,
+) error {
 	query := `
 		DELETE FROM tokens
 		WHERE user_uuid = $1 AND session_uuid = $2`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	_, err := m.DB.ExecContext(ctx, query, userUUID, sessionUUID)
@@ -158,12 +165,111 @@ func (m TokenModel) DeleteAllForUserSession(userUUID, sessionUUID uuid.UUID) err
 }
 
 // DeleteAllExpired() deletes all expired tokens from the database.
-func (m TokenModel) DeleteAllExpired() (int64, error) {
+func (m TokenModel) DeleteAllExpired(ctx context.Context) (int64, error) {
 	query := `
 		DELETE FROM tokens
 		WHERE expiry < NOW()`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// TokenLimits restricts how many tokens of a scope, each sent by email, are issued to a
+// user. At most Limit tokens are issued within Window, and a token is issued at most
+// once every Cooldown. Zero values disable the matching restriction.
+type TokenLimits struct {
+	Limit    int
+	Window   time.Duration
+	Cooldown time.Duration
+}
+
+// tokenWaitQuery computes, from the tokens of scope $2 issued to user $1, how long to
+// wait before the next one can be issued given the limits $3 (window in seconds), $4
+// (limit) and $5 (cooldown in seconds).
+const tokenWaitQuery = `
+	WITH recent AS (
+		SELECT issued_at FROM token_issues
+		WHERE user_uuid = $1 AND scope = $2 AND expires_at > NOW()
+	),
+	windowed AS (
+		SELECT issued_at FROM recent WHERE issued_at > NOW() - make_interval(secs => $3)
+	),
+	wait AS (
+		SELECT GREATEST(
+			CASE WHEN $4 > 0 AND (SELECT COUNT(*) FROM windowed) >= $4
+				THEN (SELECT MIN(issued_at) FROM windowed) + make_interval(secs => $3) - NOW()
+			END,
+			(SELECT MAX(issued_at) FROM recent) + make_interval(secs => $5) - NOW(),
+			interval '0'
+		) AS duration
+	)`
+
+// Wait returns how long the user has to wait before a token of the scope can be issued
+// again, zero when it can be issued right away.
+func (m TokenModel) Wait(
+	ctx context.Context,
+	userUUID uuid.UUID,
+	scope string,
+	limits TokenLimits,
+) (time.Duration, error) {
+	query := tokenWaitQuery + `
+		SELECT EXTRACT(EPOCH FROM duration) FROM wait`
+
+	args := []any{userUUID, scope, limits.Window.Seconds(), limits.Limit, limits.Cooldown.Seconds()}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var wait float64
+	if err := m.DB.QueryRowContext(ctx, query, args...).Scan(&wait); err != nil {
+		return 0, err
+	}
+
+	return time.Duration(wait * float64(time.Second)), nil
+}
+
+// Throttle records a token of the scope being issued to the user, unless the limits
+// are reached. When throttled, it returns how long to wait before the next token can be
+// issued.
+func (m TokenModel) Throttle(
+	ctx context.Context,
+	userUUID uuid.UUID,
+	scope string,
+	limits TokenLimits,
+) (time.Duration, error) {
+	query := tokenWaitQuery + `,
+		issued AS (
+			INSERT INTO token_issues (user_uuid, scope, expires_at)
+			SELECT $1, $2, NOW() + make_interval(secs => GREATEST($3, $5))
+			WHERE (SELECT duration FROM wait) <= interval '0'
+		)
+		SELECT EXTRACT(EPOCH FROM duration) FROM wait`
+
+	args := []any{userUUID, scope, limits.Window.Seconds(), limits.Limit, limits.Cooldown.Seconds()}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var wait float64
+	if err := m.DB.QueryRowContext(ctx, query, args...).Scan(&wait); err != nil {
+		return 0, err
+	}
+
+	return time.Duration(wait * float64(time.Second)), nil
+}
+
+// DeleteAllExpiredIssues deletes the issued tokens records past their throttling window.
+func (m TokenModel) DeleteAllExpiredIssues(ctx context.Context) (int64, error) {
+	query := `DELETE FROM token_issues WHERE expires_at <= NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	res, err := m.DB.ExecContext(ctx, query)
@@ -0,0 +1,122 @@
+package data
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/lib/pq"
+)
+
+// TrendPeriod sums the time tracked in the sessions started within [From, To), and the
+// actions completed within it, out of the records the user can view. The changes are in
+// percent of the previous period, null when there is nothing to compare with.
+type TrendPeriod struct {
+	From             time.Time `json:"from"`
+	To               time.Time `json:"to"`
+	TrackedSeconds   int64     `json:"tracked_seconds"`
+	CompletedActions int64     `json:"completed_actions"`
+	TrackedChange    *float64  `json:"tracked_change"`
+	CompletedChange  *float64  `json:"completed_change"`
+}
+
+type TrendModel struct {
+	DB DBTX
+}
+
+// Fill sums the tracked time and the completed actions of the periods, listed from the
+// latest, and sets their changes from the period listed after them. Sessions still
+// running are counted up to now.
+func (m TrendModel) Fill(
+	ctx context.Context,
+	periods []*TrendPeriod,
+	userUUID uuid.UUID,
+) error {
+	query := `
+		SELECT
+			COALESCE((
+				SELECT SUM(EXTRACT(EPOCH FROM COALESCE(s.ends_at, NOW()) - s.starts_at))
+				FROM sessions s
+				LEFT JOIN actions a ON s.action_uuid = a.uuid
+				LEFT JOIN targets t ON t.uuid = COALESCE(a.target_uuid, s.target_uuid)
+				WHERE s.starts_at >= p.starts AND s.starts_at < p.ends AND EXISTS (
+					SELECT 1
+					FROM acls ac
+					JOIN roles r ON ac.role_code = r.code
+					WHERE ac.user_uuid = $3
+					AND r.rank <= (SELECT rank FROM roles WHERE code = 'viewer')
+					AND (ac.resource_type, ac.resource_uuid) IN (
+						('session', s.uuid),
+						('action', a.uuid),
+						('target', t.uuid)
+					)
+				)
+			), 0)::bigint,
+			(
+				SELECT COUNT(*)
+				FROM actions a
+				WHERE a.completed_at >= p.starts AND a.completed_at < p.ends AND EXISTS (
+					SELECT 1
+					FROM acls ac
+					JOIN roles r ON ac.role_code = r.code
+					WHERE ac.user_uuid = $3
+					AND r.rank <= (SELECT rank FROM roles WHERE code = 'viewer')
+					AND (ac.resource_type, ac.resource_uuid) IN (
+						('action', a.uuid),
+						('target', a.target_uuid)
+					)
+				)
+			)
+		FROM unnest($1::timestamptz[], $2::timestamptz[]) WITH ORDINALITY AS p (starts, ends, n)
+		ORDER BY p.n
+	`
+
+	starts := make([]time.Time, len(periods))
+	ends := make([]time.Time, len(periods))
+	for i, p := range periods {
+		starts[i], ends[i] = p.From, p.To
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(starts), pq.Array(ends), userUUID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for i := 0; rows.Next(); i++ {
+		err := rows.Scan(&periods[i].TrackedSeconds, &periods[i].CompletedActions)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for i := 0; i+1 < len(periods); i++ {
+		previous := periods[i+1]
+		periods[i].TrackedChange = percentChange(periods[i].TrackedSeconds, previous.TrackedSeconds)
+		periods[i].CompletedChange = percentChange(
+			periods[i].CompletedActions,
+			previous.CompletedActions,
+		)
+	}
+
+	return nil
+}
+
+// percentChange returns the change from previous to current in percent, rounded to one
+// decimal, nil when previous is zero.
+func percentChange(current, previous int64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+
+	change := math.Round(float64(current-previous)*1000/float64(previous)) / 10
+	return &change
+}
@@ -0,0 +1,205 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/lib/pq"
+)
+
+// UndoOperation is a deletion the user may undo until ExpiresAt. The deleted record is
+// restored along with its children, notes, search index and access rights.
+type UndoOperation struct {
+	UUID         uuid.UUID `json:"operation_id"`
+	ResourceType string    `json:"resource_type"`
+	ResourceUUID uuid.UUID `json:"resource_uuid"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	// TTL is how long the operation can be undone.
+	TTL time.Duration `json:"-"`
+	// refundDate is the usage date of the daily quota given back by the deletion.
+	refundDate sql.NullTime
+}
+
+// undoTables lists the tables saved in the snapshots, parents first so that they are
+// restored in order.
+var undoTables = []string{
+	"targets",
+	"targets_fts",
+	"actions",
+	"actions_fts",
+	"checklist_items",
+	"daily_plan_items",
+	"sessions",
+	"sessions_fts",
+	"acls",
+}
+
+type UndoModel struct {
+	DB DBTX
+}
+
+// Record saves the rows deleted along with the resource of the operation. It must run
+// in the transaction deleting the resource, before the deletion.
+func (m UndoModel) Record(ctx context.Context, op *UndoOperation, userUUID uuid.UUID) error {
+	query := `
+		WITH t AS (
+			SELECT * FROM targets WHERE $1::text = 'target' AND uuid = $2
+		),
+		a AS (
+			SELECT * FROM actions
+			WHERE ($1::text = 'target' AND target_uuid = $2)
+				OR ($1::text = 'action' AND uuid = $2)
+		),
+		s AS (
+			SELECT * FROM sessions
+			WHERE ($1::text = 'session' AND uuid = $2)
+				OR target_uuid IN (SELECT uuid FROM t)
+				OR action_uuid IN (SELECT uuid FROM a)
+		),
+		ids AS (
+			SELECT uuid FROM t UNION ALL SELECT uuid FROM a UNION ALL SELECT uuid FROM s
+		),
+		snapshot AS (
+			SELECT jsonb_build_object(
+				'targets', (SELECT COALESCE(jsonb_agg(to_jsonb(t)), '[]') FROM t),
+				'targets_fts', (
+					SELECT COALESCE(jsonb_agg(to_jsonb(f)), '[]')
+					FROM targets_fts f WHERE f.target_uuid IN (SELECT uuid FROM t)
+				),
+				'actions', (SELECT COALESCE(jsonb_agg(to_jsonb(a)), '[]') FROM a),
+				'actions_fts', (
+					SELECT COALESCE(jsonb_agg(to_jsonb(f)), '[]')
+					FROM actions_fts f WHERE f.action_uuid IN (SELECT uuid FROM a)
+				),
+				'checklist_items', (
+					SELECT COALESCE(jsonb_agg(to_jsonb(c)), '[]')
+					FROM checklist_items c WHERE c.action_uuid IN (SELECT uuid FROM a)
+				),
+				'daily_plan_items', (
+					SELECT COALESCE(jsonb_agg(to_jsonb(p)), '[]')
+					FROM daily_plan_items p WHERE p.action_uuid IN (SELECT uuid FROM a)
+				),
+				'sessions', (SELECT COALESCE(jsonb_agg(to_jsonb(s)), '[]') FROM s),
+				'sessions_fts', (
+					SELECT COALESCE(jsonb_agg(to_jsonb(f)), '[]')
+					FROM sessions_fts f WHERE f.session_uuid IN (SELECT uuid FROM s)
+				),
+				'acls', (
+					SELECT COALESCE(jsonb_agg(to_jsonb(c)), '[]')
+					FROM acls c WHERE c.resource_uuid IN (SELECT uuid FROM ids)
+				)
+			) AS rows
+		)
+		INSERT INTO undo_operations (
+			user_uuid, resource_type, resource_uuid, snapshot, refund_date, expires_at
+		)
+		SELECT $3, $1::resource_types, $2, rows, $4, NOW() + make_interval(secs => $5)
+		FROM snapshot
+		RETURNING uuid, expires_at
+	`
+
+	args := []any{
+		op.ResourceType,
+		op.ResourceUUID,
+		userUUID,
+		op.refundDate,
+		op.TTL.Seconds(),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&op.UUID, &op.ExpiresAt)
+}
+
+// take removes an unexpired operation of the user and returns it with its snapshot.
+func (m UndoModel) take(
+	ctx context.Context,
+	id, userUUID uuid.UUID,
+) (*UndoOperation, []byte, error) {
+	query := `
+		DELETE FROM undo_operations
+		WHERE uuid = $1 AND user_uuid = $2 AND expires_at > NOW()
+		RETURNING uuid, resource_type, resource_uuid, expires_at, refund_date, snapshot
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var op UndoOperation
+	var snapshot []byte
+	err := m.DB.QueryRowContext(ctx, query, id, userUUID).Scan(
+		&op.UUID,
+		&op.ResourceType,
+		&op.ResourceUUID,
+		&op.ExpiresAt,
+		&op.refundDate,
+		&snapshot,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	return &op, snapshot, nil
+}
+
+// restore inserts back the rows of a snapshot. Rows which can no longer be restored,
+// such as a session whose action has been deleted since, are reported as an edit
+// conflict.
+func (m UndoModel) restore(ctx context.Context, snapshot []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	for _, table := range undoTables {
+		query := fmt.Sprintf(`
+			INSERT INTO %s
+			SELECT * FROM jsonb_populate_recordset(NULL::%s, $1::jsonb -> '%s') r
+		`, table, table, table)
+		// The plan items are only restored into the plans still kept, the retention
+		// purge may have deleted the older ones since.
+		if table == "daily_plan_items" {
+			query += `
+				WHERE EXISTS (
+					SELECT 1 FROM daily_plans p
+					WHERE p.user_uuid = r.user_uuid AND p.plan_date = r.plan_date
+				)
+			`
+		}
+
+		_, err := m.DB.ExecContext(ctx, query, snapshot)
+		if err != nil {
+			var pqe *pq.Error
+			// foreign_key_violation and unique_violation
+			if errors.As(err, &pqe) && (pqe.Code == "23503" || pqe.Code == "23505") {
+				return ErrEditConflict
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteAllExpired deletes the operations which can no longer be undone.
+func (m UndoModel) DeleteAllExpired(ctx context.Context) (int64, error) {
+	query := `DELETE FROM undo_operations WHERE expires_at <= NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -68,8 +68,27 @@ func (p *password) Set(plaintextPassword, pepper string) error {
 	return nil
 }
 
+// IsSet() reports whether the user has a password. Users signing in only with an
+// identity provider have none until they set one with a password reset.
+func (p *password) IsSet() bool {
+	return len(p.hash) > 0
+}
+
+// value() returns the hash to store, NULL when the user has no password.
+func (p *password) value() any {
+	if !p.IsSet() {
+		return nil
+	}
+	return p.hash
+}
+
 // Matches() method checks if the provided plaintext password matches the stored hash.
+// No password matches when the user has none.
 func (p *password) Matches(plaintextPassword, pepper string) (bool, error) {
+	if !p.IsSet() {
+		return false, nil
+	}
+
 	pw := norm.NFKC.String(plaintextPassword)
 
 	// Hash password to fixed length with pepper before actual bcrypt comparison.
@@ -91,31 +110,46 @@ func (p *password) Matches(plaintextPassword, pepper string) (bool, error) {
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
-	v.Check(email != "", "email", "must be provided")
-	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+	v.CheckField(email != "", "email", validator.Required())
+	v.CheckField(
+		validator.Matches(email, validator.EmailRX),
+		"email",
+		validator.InvalidFormat("must be a valid email address"),
+	)
 }
 
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 	pw := norm.NFKC.String(password)
 
-	v.Check(pw != "", "password", "must be provided")
-	v.Check(
+	v.CheckField(pw != "", "password", validator.Required())
+	v.CheckField(
 		validator.ValidUnicodeChars(pw),
 		"password",
-		"must no contain unicode Control or Format characters",
+		validator.NewFieldError(
+			validator.CodeInvalidCharacters,
+			"must no contain unicode Control or Format characters",
+		),
+	)
+	v.CheckField(
+		utf8.RuneCountInString(password) >= 8,
+		"password",
+		validator.TooShort(8, validator.UnitBytes),
 	)
-	v.Check(utf8.RuneCountInString(password) >= 8, "password", "must be at least 8 bytes long")
 	// Consider: pre-hash the password to not enforce the length limit
-	v.Check(
+	v.CheckField(
 		utf8.RuneCountInString(password) <= 72,
 		"password",
-		"must not be more than 72 bytes long",
+		validator.TooLong(72, validator.UnitBytes),
 	)
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
-	v.Check(user.Name != "", "name", "must be provided")
-	v.Check(utf8.RuneCountInString(user.Name) <= 30, "name", "must not be more than 40 bytes long")
+	v.CheckField(user.Name != "", "name", validator.Required())
+	v.CheckField(
+		utf8.RuneCountInString(user.Name) <= 30,
+		"name",
+		validator.TooLong(40, validator.UnitBytes),
+	)
 
 	ValidateEmail(v, user.Email)
 	if user.Password.plaintext != nil {
@@ -131,15 +165,15 @@ type UserModel struct {
 	DB DBTX
 }
 
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
 		INSERT INTO users (name, email, password_hash, activated)
 		VALUES ($1, $2, $3, $4)
 		RETURNING uuid, created_at, updated_at, version`
 
-	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
+	args := []any{user.Name, user.Email, user.Password.value(), user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).
@@ -156,7 +190,7 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
 		SELECT uuid, created_at, updated_at, name, email, password_hash, activated, version
 		FROM users
@@ -164,7 +198,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
@@ -189,7 +223,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	query := `
 		UPDATE users
 		SET name = $1, email = $2, password_hash = $3, activated = $4, updated_at = now(), version = version + 1
@@ -199,13 +233,13 @@ func (m UserModel) Update(user *User) error {
 	args := []any{
 		user.Name,
 		user.Email,
-		user.Password.hash,
+		user.Password.value(),
 		user.Activated,
 		user.UUID,
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
@@ -223,7 +257,86 @@ func (m UserModel) Update(user *User) error {
 	return nil
 }
 
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+// PendingActivationReminders returns the users who registered more than after ago
+// without activating their account, and have not been reminded to yet.
+func (m UserModel) PendingActivationReminders(
+	ctx context.Context,
+	after time.Duration,
+) ([]*User, error) {
+	query := `
+		SELECT uuid, created_at, updated_at, name, email, activated, version
+		FROM users
+		WHERE NOT activated
+			AND activation_reminded_at IS NULL
+			AND created_at < NOW() - make_interval(secs => $1)
+		ORDER BY created_at`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, after.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&user.UUID,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Activated,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// MarkActivationReminded records that the activation reminder was sent to the user.
+func (m UserModel) MarkActivationReminded(ctx context.Context, userUUID uuid.UUID) error {
+	query := `UPDATE users SET activation_reminded_at = NOW() WHERE uuid = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userUUID)
+	return err
+}
+
+// DeleteAllUnactivated deletes the accounts not activated within window of their
+// registration, freeing their email address for a new registration.
+func (m UserModel) DeleteAllUnactivated(ctx context.Context, window time.Duration) (int64, error) {
+	query := `
+		DELETE FROM users
+		WHERE NOT activated AND created_at < NOW() - make_interval(secs => $1)`
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, window.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func (m UserModel) GetForToken(
+	ctx context.Context,
+	tokenScope, tokenPlaintext string,
+) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	query := `
@@ -242,7 +355,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	args := []any{tokenHash[:], tokenScope, time.Now()}
 
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
@@ -0,0 +1,62 @@
+package faults
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// ErrInjected is the error of the calls failed on purpose.
+var ErrInjected = errors.New("faults: injected failure")
+
+// Injector delays and fails calls to a dependency at random, to exercise the retries,
+// the idempotency and the circuit breaker of the callers. A nil Injector injects nothing.
+type Injector struct {
+	// latency is the maximum delay added to the calls, each is delayed by a random
+	// duration up to it.
+	latency time.Duration
+	// errorRate is the probability of a call to fail, between 0 and 1.
+	errorRate float64
+}
+
+// New returns an Injector delaying the calls by up to latency and failing them at the
+// given rate. It returns nil when it would inject nothing.
+func New(latency time.Duration, errorRate float64) (*Injector, error) {
+	if latency < 0 {
+		return nil, errors.New("faults: latency must not be negative")
+	}
+	if errorRate < 0 || errorRate > 1 {
+		return nil, errors.New("faults: error rate must be between 0 and 1")
+	}
+	if latency == 0 && errorRate == 0 {
+		return nil, nil
+	}
+
+	return &Injector{latency: latency, errorRate: errorRate}, nil
+}
+
+// Inject delays the call, then reports ErrInjected when the call is to fail. The delay
+// is cut short when the context is done, whose error is returned.
+func (i *Injector) Inject(ctx context.Context) error {
+	if i == nil {
+		return nil
+	}
+
+	if i.latency > 0 {
+		timer := time.NewTimer(rand.N(i.latency))
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	if rand.Float64() < i.errorRate {
+		return ErrInjected
+	}
+
+	return nil
+}
@@ -0,0 +1,237 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wneessen/go-mail"
+)
+
+const dkimMiddlewareType mail.MiddlewareType = "dkim"
+
+// dkimHeaders lists the header fields covered by the signatures.
+var dkimHeaders = []string{
+	"From",
+	"To",
+	"Subject",
+	"Date",
+	"Message-ID",
+	"MIME-Version",
+	"Content-Type",
+}
+
+// DKIM signs the sent messages for a domain, as described in RFC 6376, with the
+// relaxed canonicalization of both the headers and the body. RSA and Ed25519 keys are
+// supported.
+type DKIM struct {
+	domain    string
+	selector  string
+	key       crypto.Signer
+	algorithm string
+}
+
+// NewDKIM returns a DKIM signer for the domain, using the PEM encoded private key
+// published under the selector.
+func NewDKIM(domain, selector string, keyPEM []byte) (*DKIM, error) {
+	if domain == "" || selector == "" {
+		return nil, errors.New("dkim: domain and selector must be provided")
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("dkim: no PEM encoded private key found")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dkim: %w", err)
+	}
+
+	d := &DKIM{domain: domain, selector: selector}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		d.key, d.algorithm = k, "rsa-sha256"
+	case ed25519.PrivateKey:
+		d.key, d.algorithm = k, "ed25519-sha256"
+	default:
+		return nil, fmt.Errorf("dkim: unsupported private key type %T", key)
+	}
+
+	return d, nil
+}
+
+// Domain returns the signing domain.
+func (d *DKIM) Domain() string {
+	return d.domain
+}
+
+// Selector returns the selector the public key is published under.
+func (d *DKIM) Selector() string {
+	return d.selector
+}
+
+// PublicKey returns the base64 encoded public key, as published in the p= tag of the
+// DKIM DNS record.
+func (d *DKIM) PublicKey() (string, error) {
+	var der []byte
+	switch pub := d.key.Public().(type) {
+	case ed25519.PublicKey:
+		der = pub
+	default:
+		var err error
+		der, err = x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return base64.StdEncoding.EncodeToString(der), nil
+}
+
+func (d *DKIM) Type() mail.MiddlewareType {
+	return dkimMiddlewareType
+}
+
+// Handle adds the DKIM-Signature header to the message. Middlewares cannot report
+// errors, a message which fails to be signed is sent unsigned.
+func (d *DKIM) Handle(msg *mail.Msg) *mail.Msg {
+	var buf bytes.Buffer
+	if _, err := msg.WriteToSkipMiddleware(&buf, dkimMiddlewareType); err != nil {
+		return msg
+	}
+
+	signature, err := d.sign(buf.Bytes(), time.Now())
+	if err != nil {
+		return msg
+	}
+	msg.SetGenHeaderPreformatted("DKIM-Signature", signature)
+
+	return msg
+}
+
+// sign returns the value of the DKIM-Signature header for the raw message.
+func (d *DKIM) sign(raw []byte, now time.Time) (string, error) {
+	header, body, found := bytes.Cut(raw, []byte("\r\n\r\n"))
+	if !found {
+		return "", errors.New("dkim: message without body")
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	fields := headerFields(header)
+	var signed []string
+	h := sha256.New()
+	for _, name := range dkimHeaders {
+		field, ok := fields[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+		signed = append(signed, strings.ToLower(name))
+		h.Write([]byte(relaxedHeader(field)))
+	}
+
+	value := fmt.Sprintf(
+		"v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s; t=%d; h=%s; bh=%s; b=",
+		d.algorithm,
+		d.domain,
+		d.selector,
+		now.Unix(),
+		strings.Join(signed, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]),
+	)
+	h.Write([]byte(strings.TrimSuffix(relaxedHeader("DKIM-Signature: "+value), "\r\n")))
+
+	opts := crypto.Hash(0)
+	if d.algorithm == "rsa-sha256" {
+		opts = crypto.SHA256
+	}
+	sig, err := d.key.Sign(rand.Reader, h.Sum(nil), opts)
+	if err != nil {
+		return "", fmt.Errorf("dkim: %w", err)
+	}
+
+	return value + base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// headerFields returns the unfolded header fields of a raw header section, keyed by
+// their lowercase name. The last occurrence of a field is kept, as it is the one a
+// verifier picks first.
+func headerFields(header []byte) map[string]string {
+	fields := make(map[string]string)
+
+	var current string
+	flush := func() {
+		if name, _, ok := strings.Cut(current, ":"); ok {
+			fields[strings.ToLower(strings.TrimSpace(name))] = current
+		}
+	}
+	for _, line := range strings.Split(string(header), "\r\n") {
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			current += line
+			continue
+		}
+		flush()
+		current = line
+	}
+	flush()
+
+	return fields
+}
+
+// relaxedHeader canonicalizes an unfolded header field with the relaxed algorithm.
+func relaxedHeader(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+	name = strings.ToLower(strings.TrimSpace(name))
+	value = strings.Join(strings.Fields(value), " ")
+
+	return name + ":" + value + "\r\n"
+}
+
+// relaxedBody canonicalizes a message body with the relaxed algorithm.
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		var b strings.Builder
+		space := false
+		for _, r := range line {
+			if r == ' ' || r == '\t' {
+				space = true
+				continue
+			}
+			if space {
+				b.WriteByte(' ')
+				space = false
+			}
+			b.WriteRune(r)
+		}
+		lines[i] = b.String()
+	}
+
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
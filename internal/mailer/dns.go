@@ -0,0 +1,103 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/mail"
+	"strings"
+)
+
+// SenderDomain returns the domain of the sender address.
+func SenderDomain(sender string) (string, error) {
+	addr, err := mail.ParseAddress(sender)
+	if err != nil {
+		return "", err
+	}
+
+	_, domain, found := strings.Cut(addr.Address, "@")
+	if !found || domain == "" {
+		return "", fmt.Errorf("sender address %q has no domain", addr.Address)
+	}
+
+	return strings.ToLower(domain), nil
+}
+
+// CheckDNS looks up the SPF, DMARC and, when dkim is not nil, DKIM records of the
+// sender domain and returns guidance for every record missing or not matching the
+// configuration. Mail from a domain without them is likely to be flagged as spam.
+func CheckDNS(ctx context.Context, domain string, dkim *DKIM) []string {
+	var warnings []string
+
+	if !hasTXT(ctx, domain, "v=spf1") {
+		warnings = append(warnings, fmt.Sprintf(
+			"no SPF record found for %s, publish a TXT record such as "+
+				`"v=spf1 include:<smtp provider> -all" listing the servers sending its mail`,
+			domain,
+		))
+	}
+
+	if !hasTXT(ctx, "_dmarc."+domain, "v=DMARC1") {
+		warnings = append(warnings, fmt.Sprintf(
+			"no DMARC record found for %s, publish a TXT record on _dmarc.%s such as "+
+				`"v=DMARC1; p=quarantine; rua=mailto:<reports address>"`,
+			domain,
+			domain,
+		))
+	}
+
+	if dkim == nil {
+		return warnings
+	}
+
+	if dkim.Domain() != domain && !strings.HasSuffix(domain, "."+dkim.Domain()) {
+		warnings = append(warnings, fmt.Sprintf(
+			"DKIM domain %s is not aligned with the sender domain %s, DMARC will not "+
+				"accept its signatures",
+			dkim.Domain(),
+			domain,
+		))
+	}
+
+	name := dkim.Selector() + "._domainkey." + dkim.Domain()
+	records, err := net.DefaultResolver.LookupTXT(ctx, name)
+	if err != nil || len(records) == 0 {
+		return append(warnings, fmt.Sprintf(
+			"no DKIM record found on %s, publish the public key of the signing key there",
+			name,
+		))
+	}
+
+	publicKey, err := dkim.PublicKey()
+	if err != nil {
+		return append(warnings, "unable to encode the DKIM public key: "+err.Error())
+	}
+	for _, record := range records {
+		for tag := range strings.SplitSeq(record, ";") {
+			if strings.TrimSpace(tag) == "p="+publicKey {
+				return warnings
+			}
+		}
+	}
+
+	return append(warnings, fmt.Sprintf(
+		"the DKIM record on %s does not publish the public key of the signing key",
+		name,
+	))
+}
+
+// hasTXT reports whether name has a TXT record starting with prefix.
+func hasTXT(ctx context.Context, name, prefix string) bool {
+	records, err := net.DefaultResolver.LookupTXT(ctx, name)
+	if err != nil {
+		return false
+	}
+
+	for _, record := range records {
+		if strings.HasPrefix(strings.TrimSpace(record), prefix) {
+			return true
+		}
+	}
+
+	return false
+}
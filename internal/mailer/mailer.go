@@ -2,9 +2,18 @@ package mailer
 
 import (
 	"bytes"
+	"context"
 	"embed"
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/liuminhaw/yatijapp/internal/faults"
 	"github.com/wneessen/go-mail"
 
 	ht "html/template"
@@ -17,6 +26,40 @@ var templateFS embed.FS
 type Mailer struct {
 	client *mail.Client
 	sender string
+	// dkim signs the sent messages when not nil.
+	dkim *DKIM
+	// maxAttachmentsSize is the maximum total size, in bytes, of the attachments of a
+	// message.
+	maxAttachmentsSize int
+	// faults delays and fails the deliveries on purpose when not nil, in development.
+	faults *faults.Injector
+}
+
+// DefaultMaxAttachmentsSize is the maximum total size of the attachments of a message
+// unless changed with LimitAttachments.
+const DefaultMaxAttachmentsSize = 10 << 20
+
+// ErrAttachmentsTooLarge is returned when sending a message whose attachments exceed
+// the size limit.
+var ErrAttachmentsTooLarge = errors.New("mailer: attachments too large")
+
+// Attachment is a file attached to a message. The content type is detected from the
+// file name, then from the content, when not provided.
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+func (a Attachment) contentType() string {
+	if a.ContentType != "" {
+		return a.ContentType
+	}
+	if ct := mime.TypeByExtension(filepath.Ext(a.Name)); ct != "" {
+		return ct
+	}
+
+	return http.DetectContentType(a.Data)
 }
 
 func New(host string, port int, username, password, sender string) (*Mailer, error) {
@@ -33,66 +76,283 @@ func New(host string, port int, username, password, sender string) (*Mailer, err
 	}
 
 	mailer := &Mailer{
-		client: client,
-		sender: sender,
+		client:             client,
+		sender:             sender,
+		maxAttachmentsSize: DefaultMaxAttachmentsSize,
 	}
 
 	return mailer, nil
 }
 
-// Send() takes the recipient email address, the template file name, and any
-// dynamic data for the template as parameter.
-func (m *Mailer) Send(recipient string, templateFile string, data any) error {
+// LimitAttachments sets the maximum total size, in bytes, of the attachments of a
+// message.
+func (m *Mailer) LimitAttachments(size int) {
+	m.maxAttachmentsSize = size
+}
+
+// UseDKIM signs every message sent from now on with the DKIM signer.
+func (m *Mailer) UseDKIM(dkim *DKIM) {
+	m.dkim = dkim
+}
+
+// UseFaults delays and fails the deliveries at random from now on, to exercise the
+// handling of the mail failures locally.
+func (m *Mailer) UseFaults(injector *faults.Injector) {
+	m.faults = injector
+}
+
+// Message is a mail template rendered with its data.
+type Message struct {
+	Subject   string `json:"subject"`
+	PlainBody string `json:"plain_body"`
+	HTMLBody  string `json:"html_body"`
+}
+
+// Templates returns the file names of the available mail templates.
+func Templates() ([]string, error) {
+	entries, err := fs.ReadDir(templateFS, "templates")
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	return names, nil
+}
+
+// Localized() returns the variant of the template file in the language, named like
+// weekly_report.zh-Hant.tmpl for weekly_report.tmpl, or the template file itself when
+// the template is not translated in the language.
+func Localized(templateFile, lang string) string {
+	name := strings.TrimSuffix(templateFile, ".tmpl") + "." + lang + ".tmpl"
+	if _, err := fs.Stat(templateFS, "templates/"+name); err != nil {
+		return templateFile
+	}
+
+	return name
+}
+
+// Render() executes the subject, plain and HTML bodies of the template file with the
+// dynamic data, without sending anything.
+func Render(templateFile string, data any) (*Message, error) {
 	textTmpl, err := tt.New("").ParseFS(templateFS, "templates/"+templateFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	subject := new(bytes.Buffer)
 	err = textTmpl.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	plainBody := new(bytes.Buffer)
 	err = textTmpl.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	htmlTmpl, err := ht.New("").ParseFS(templateFS, "templates/"+templateFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	htmlBody := new(bytes.Buffer)
 	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	msg := mail.NewMsg()
-	err = msg.To(recipient)
-	if err != nil {
-		return err
+	message := &Message{
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
 	}
-	err = msg.From(m.sender)
+
+	return message, nil
+}
+
+// Send() takes the recipient email address, the template file name, any dynamic data
+// for the template and the files to attach as parameter.
+func (m *Mailer) Send(
+	recipient string,
+	templateFile string,
+	data any,
+	attachments ...Attachment,
+) error {
+	msg, err := m.newMsg(recipient, templateFile, data, attachments)
 	if err != nil {
 		return err
 	}
 
-	msg.Subject(subject.String())
-	msg.SetBodyString(mail.TypeTextPlain, plainBody.String())
-	msg.AddAlternativeString(mail.TypeTextHTML, htmlBody.String())
-
 	// Retry sending the email up to 3 times with exponential backoff
 	for i := range 3 {
-		if err := m.client.DialAndSend(msg); err == nil {
+		if err := m.dialAndSend(msg); err == nil {
 			return nil
 		}
 
 		time.Sleep(time.Duration(i+1) * 5 * time.Second)
 	}
 
+	return m.dialAndSend(msg)
+}
+
+// dialAndSend() sends the message over a new SMTP connection.
+func (m *Mailer) dialAndSend(msg *mail.Msg) error {
+	if err := m.faults.Inject(context.Background()); err != nil {
+		return err
+	}
+
 	return m.client.DialAndSend(msg)
 }
+
+// BatchMessage is a message sent as part of a batch.
+type BatchMessage struct {
+	Recipient    string
+	TemplateFile string
+	Data         any
+	Attachments  []Attachment
+}
+
+// SendBatch() sends many messages reusing SMTP connections, at most concurrency of
+// them at a time, each sending its share of the messages. The returned errors are
+// indexed like the messages, nil for the messages sent. Failed messages are not
+// retried, the caller decides whether to send them again.
+func (m *Mailer) SendBatch(
+	ctx context.Context,
+	messages []BatchMessage,
+	concurrency int,
+) []error {
+	errs := make([]error, len(messages))
+
+	var msgs []*mail.Msg
+	var indexes []int
+	for i, message := range messages {
+		msg, err := m.newMsg(
+			message.Recipient,
+			message.TemplateFile,
+			message.Data,
+			message.Attachments,
+		)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		msgs = append(msgs, msg)
+		indexes = append(indexes, i)
+	}
+	if len(msgs) == 0 {
+		return errs
+	}
+
+	concurrency = max(1, min(concurrency, len(msgs)))
+	chunk := (len(msgs) + concurrency - 1) / concurrency
+
+	var wg sync.WaitGroup
+	for start := 0; start < len(msgs); start += chunk {
+		end := min(start+chunk, len(msgs))
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := m.sendOverConnection(ctx, msgs[start:end])
+			for j := start; j < end; j++ {
+				switch {
+				case msgs[j].HasSendError():
+					errs[indexes[j]] = msgs[j].SendError()
+				case err != nil:
+					errs[indexes[j]] = err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// Verify() connects and authenticates to the SMTP server without sending anything, to
+// check the mailer configuration.
+func (m *Mailer) Verify(ctx context.Context) error {
+	client, err := m.client.DialToSMTPClientWithContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return m.client.CloseWithSMTPClient(client)
+}
+
+// sendOverConnection sends the messages over a single SMTP connection. The error of
+// each message is recorded on it, the returned error is the one of the connection.
+func (m *Mailer) sendOverConnection(ctx context.Context, msgs []*mail.Msg) error {
+	if err := m.faults.Inject(ctx); err != nil {
+		return err
+	}
+
+	client, err := m.client.DialToSMTPClientWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer m.client.CloseWithSMTPClient(client)
+
+	// The per message errors are collected from the messages themselves.
+	_ = m.client.SendWithSMTPClient(client, msgs...)
+
+	return nil
+}
+
+// newMsg() builds the message sending the rendered template to the recipient.
+func (m *Mailer) newMsg(
+	recipient string,
+	templateFile string,
+	data any,
+	attachments []Attachment,
+) (*mail.Msg, error) {
+	size := 0
+	for _, attachment := range attachments {
+		size += len(attachment.Data)
+	}
+	if size > m.maxAttachmentsSize {
+		return nil, ErrAttachmentsTooLarge
+	}
+
+	message, err := Render(templateFile, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []mail.MsgOption
+	if m.dkim != nil {
+		opts = append(opts, mail.WithMiddleware(m.dkim))
+	}
+
+	msg := mail.NewMsg(opts...)
+	err = msg.To(recipient)
+	if err != nil {
+		return nil, err
+	}
+	err = msg.From(m.sender)
+	if err != nil {
+		return nil, err
+	}
+
+	msg.Subject(message.Subject)
+	msg.SetBodyString(mail.TypeTextPlain, message.PlainBody)
+	msg.AddAlternativeString(mail.TypeTextHTML, message.HTMLBody)
+	for _, attachment := range attachments {
+		err = msg.AttachReader(
+			attachment.Name,
+			bytes.NewReader(attachment.Data),
+			mail.WithFileContentType(mail.ContentType(attachment.contentType())),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return msg, nil
+}
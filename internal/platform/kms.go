@@ -0,0 +1,27 @@
+package platform
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// DecryptKMSDataKey returns the plaintext of a data key encrypted by AWS KMS, such as
+// the CiphertextBlob of a GenerateDataKey call. The KMS key is identified by the
+// ciphertext itself.
+func DecryptKMSDataKey(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := kms.NewFromConfig(cfg).Decrypt(ctx, &kms.DecryptInput{
+		CiphertextBlob: ciphertext,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return output.Plaintext, nil
+}
@@ -0,0 +1,66 @@
+package platform
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3Object is an object stored in an S3 bucket.
+type S3Object struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// UploadS3Object stores the content under the key of the bucket. The content must be
+// seekable, such as an *os.File, for its length to be known.
+func UploadS3Object(ctx context.Context, bucket, key string, content io.ReadSeeker) error {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = s3.NewFromConfig(cfg).PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   content,
+	})
+
+	return err
+}
+
+// ListS3Objects returns the objects of the bucket whose key starts with prefix, in the
+// order of their keys.
+func ListS3Objects(ctx context.Context, bucket, prefix string) ([]S3Object, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(s3.NewFromConfig(cfg), &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	objects := []S3Object{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			objects = append(objects, S3Object{
+				Key:          aws.ToString(object.Key),
+				Size:         aws.ToInt64(object.Size),
+				LastModified: aws.ToTime(object.LastModified),
+			})
+		}
+	}
+
+	return objects, nil
+}
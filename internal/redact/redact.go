@@ -0,0 +1,117 @@
+// Package redact removes the personal data and the secrets from the log records, so
+// that the logs can be shipped and kept without them.
+package redact
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Redacted replaces the values of the sensitive attributes.
+const Redacted = "[REDACTED]"
+
+// DefaultKeys are the attribute keys whose values are always redacted, compared case
+// insensitively.
+var DefaultKeys = []string{
+	"email",
+	"recipient",
+	"password",
+	"token",
+	"refresh_token",
+	"access_token",
+	"api_key",
+	"client_secret",
+	"notes",
+	"authorization",
+	"cookie",
+}
+
+var (
+	// rxEmail matches the email addresses within messages and string values.
+	rxEmail = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	// rxToken matches the plaintext tokens, 26 characters of base32.
+	rxToken = regexp.MustCompile(`\b[A-Z2-7]{26}\b`)
+	// rxQuerySecret matches the secrets passed in URL query strings.
+	rxQuerySecret = regexp.MustCompile(`(?i)([?&](?:token|api_key|key|secret)=)[^&\s]+`)
+)
+
+// Handler redacts the records before passing them to the next handler: the values of
+// the sensitive attributes are replaced, and the email addresses and tokens found in the
+// message and the other string values are masked.
+type Handler struct {
+	next slog.Handler
+	keys map[string]bool
+}
+
+// NewHandler returns a Handler redacting the DefaultKeys along with the extra keys.
+func NewHandler(next slog.Handler, extraKeys ...string) *Handler {
+	keys := make(map[string]bool, len(DefaultKeys)+len(extraKeys))
+	for _, key := range slices.Concat(DefaultKeys, extraKeys) {
+		keys[strings.ToLower(strings.TrimSpace(key))] = true
+	}
+
+	return &Handler{next: next, keys: keys}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, String(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(h.attr(attr))
+		return true
+	})
+
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = h.attr(attr)
+	}
+
+	return &Handler{next: h.next.WithAttrs(redacted), keys: h.keys}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name), keys: h.keys}
+}
+
+// attr returns the attribute with its value redacted.
+func (h *Handler) attr(attr slog.Attr) slog.Attr {
+	attr.Value = attr.Value.Resolve()
+	if h.keys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, Redacted)
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, String(attr.Value.String()))
+	case slog.KindGroup:
+		group := attr.Value.Group()
+		redacted := make([]any, len(group))
+		for i, a := range group {
+			redacted[i] = h.attr(a)
+		}
+		return slog.Group(attr.Key, redacted...)
+	case slog.KindAny:
+		if err, ok := attr.Value.Any().(error); ok {
+			return slog.String(attr.Key, String(err.Error()))
+		}
+	}
+
+	return attr
+}
+
+// String masks the email addresses, the tokens and the query string secrets in s.
+func String(s string) string {
+	s = rxEmail.ReplaceAllString(s, "[email]")
+	s = rxToken.ReplaceAllString(s, "[token]")
+	return rxQuerySecret.ReplaceAllString(s, "${1}"+Redacted)
+}
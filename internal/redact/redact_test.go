@@ -0,0 +1,144 @@
+package redact
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	token := rand.Text()
+	email := "jane.doe@example.com"
+
+	tests := []struct {
+		name string
+		log  func(logger *slog.Logger)
+		// leaks are the values which must not reach the output.
+		leaks []string
+		// keeps are the values which must be left in the output.
+		keeps []string
+	}{
+		{
+			name: "sensitive keys",
+			log: func(logger *slog.Logger) {
+				logger.Info(
+					"user signed in",
+					"email", "not-an-address",
+					"Password", "hunter2-hunter2",
+					"refresh_token", "opaque",
+					"notes", "private thoughts",
+					"user_uuid", "0192f6c0-user",
+				)
+			},
+			leaks: []string{"not-an-address", "hunter2-hunter2", "opaque", "private thoughts"},
+			keeps: []string{"user signed in", "0192f6c0-user", Redacted},
+		},
+		{
+			name: "extra keys",
+			log: func(logger *slog.Logger) {
+				logger.Info("webhook", "signing_secret", "s3cr3t")
+			},
+			leaks: []string{"s3cr3t"},
+		},
+		{
+			name: "message",
+			log: func(logger *slog.Logger) {
+				logger.Error("no refresh token " + token + " for " + email)
+			},
+			leaks: []string{token, email},
+			keeps: []string{"[token]", "[email]"},
+		},
+		{
+			name: "string values",
+			log: func(logger *slog.Logger) {
+				logger.Warn("request", "url", "/v1/users/activated?token="+token+"&page=2")
+			},
+			leaks: []string{token},
+			keeps: []string{"page=2"},
+		},
+		{
+			name: "query string secrets",
+			log: func(logger *slog.Logger) {
+				logger.Warn("request", "url", "/v1/export?api_key=abc123&format=csv")
+			},
+			leaks: []string{"abc123"},
+			keeps: []string{"format=csv"},
+		},
+		{
+			name: "errors",
+			log: func(logger *slog.Logger) {
+				logger.Error("send failed", "error", errors.New("mail to "+email+" bounced"))
+			},
+			leaks: []string{email},
+			keeps: []string{"bounced"},
+		},
+		{
+			name: "groups",
+			log: func(logger *slog.Logger) {
+				logger.Info("request", slog.Group("headers",
+					"authorization", "Bearer "+token,
+					"cookie", "session=abc",
+					"user_agent", "curl/8",
+				))
+			},
+			leaks: []string{token, "session=abc"},
+			keeps: []string{"curl/8"},
+		},
+		{
+			name: "logger attributes",
+			log: func(logger *slog.Logger) {
+				logger.With("email", email, "request_id", "req-1").Info("handled")
+			},
+			leaks: []string{email},
+			keeps: []string{"req-1"},
+		},
+		{
+			name: "grouped logger",
+			log: func(logger *slog.Logger) {
+				logger.WithGroup("user").Info("created", "email", email, "token", token)
+			},
+			leaks: []string{email, token},
+		},
+		{
+			name: "lazy values",
+			log: func(logger *slog.Logger) {
+				logger.Info("user", "contact", slog.AnyValue(lazy(email)))
+			},
+			leaks: []string{email},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := slog.New(NewHandler(slog.NewJSONHandler(&buf, nil), "signing_secret"))
+
+			tt.log(logger)
+
+			output := buf.String()
+			if output == "" {
+				t.Fatal("nothing logged")
+			}
+			for _, leak := range tt.leaks {
+				if strings.Contains(output, leak) {
+					t.Errorf("%q leaked in %s", leak, output)
+				}
+			}
+			for _, keep := range tt.keeps {
+				if !strings.Contains(output, keep) {
+					t.Errorf("%q missing from %s", keep, output)
+				}
+			}
+		})
+	}
+}
+
+// lazy is a value resolved when the record is handled.
+type lazy string
+
+func (l lazy) LogValue() slog.Value {
+	return slog.StringValue(string(l))
+}
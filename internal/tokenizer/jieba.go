@@ -1,9 +1,13 @@
+//go:build !nojieba
+
 package tokenizer
 
 import (
 	"embed"
 	"os"
 	"path/filepath"
+
+	"github.com/yanyiwu/gojieba"
 )
 
 //go:embed "dict"
@@ -49,3 +53,21 @@ func WriteJiebaDictFiles() ([]string, func(), error) {
 
 	return files, cleanup, nil
 }
+
+// Engine is the name of the segmentation engine compiled into the binary.
+const Engine = "jieba"
+
+// NewSegmenter loads the Jieba dictionaries and returns a segmenter backed by
+// gojieba, along with a function releasing its resources.
+func NewSegmenter() (Segmenter, func(), error) {
+	dictFiles, cleanup, err := WriteJiebaDictFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+	jieba := gojieba.NewJieba(dictFiles...)
+
+	return jieba, func() {
+		jieba.Free()
+		cleanup()
+	}, nil
+}
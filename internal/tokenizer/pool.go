@@ -0,0 +1,160 @@
+package tokenizer
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// poolRetryInterval is how long a pool which failed to create a segmenter waits before
+// trying again.
+const poolRetryInterval = time.Minute
+
+// Pool is a Segmenter which lazily creates up to size segmenters and lends each of
+// them to a single caller at a time. gojieba does not document its concurrency
+// guarantees, the pool makes sure a Jieba instance is never used by two goroutines
+// at once while still allowing size segmentations to run in parallel.
+//
+// If a segmenter cannot be created, the error is logged and the pool falls back to the
+// simple segmenter, so that writes keep working with a degraded search index, until it
+// manages to create one. Creating it is tried again once per poolRetryInterval. The
+// segmentations made with the fallback are counted in the statistics.
+type Pool struct {
+	factory       func() (Segmenter, func(), error)
+	size          int
+	idle          chan Segmenter
+	logger        *slog.Logger
+	retryInterval time.Duration
+
+	mu        sync.Mutex
+	created   int
+	closers   []func()
+	err       error
+	retryAt   time.Time
+	fallbacks int64
+}
+
+// NewPool returns a Pool creating its segmenters with the given factory. No segmenter
+// is created until the pool is first used or warmed up.
+func NewPool(
+	factory func() (Segmenter, func(), error),
+	size int,
+	logger *slog.Logger,
+) *Pool {
+	size = max(size, 1)
+	return &Pool{
+		factory:       factory,
+		size:          size,
+		idle:          make(chan Segmenter, size),
+		logger:        logger,
+		retryInterval: poolRetryInterval,
+	}
+}
+
+// Warm creates the first segmenter of the pool, so that initialization errors are
+// detected at startup instead of on the first use.
+func (p *Pool) Warm() error {
+	s, err := p.acquire()
+	if err != nil {
+		return err
+	}
+	p.idle <- s
+	return nil
+}
+
+// CutForSearch segments s with one of the pooled segmenters, blocking until one is
+// available.
+func (p *Pool) CutForSearch(s string, hmm bool) []string {
+	if s == "" {
+		return nil
+	}
+
+	segmenter, err := p.acquire()
+	if err != nil {
+		return simpleSegmenter{}.CutForSearch(s, hmm)
+	}
+	defer func() { p.idle <- segmenter }()
+
+	return segmenter.CutForSearch(s, hmm)
+}
+
+func (p *Pool) acquire() (Segmenter, error) {
+	select {
+	case s := <-p.idle:
+		return s, nil
+	default:
+	}
+
+	p.mu.Lock()
+	if p.created < p.size && !time.Now().Before(p.retryAt) {
+		// Creating a segmenter loads its dictionaries, which takes a while. The lock
+		// is held so that concurrent callers wait for it instead of loading their own.
+		s, closer, err := p.factory()
+		if err == nil {
+			p.created++
+			p.closers = append(p.closers, closer)
+			p.err = nil
+			p.mu.Unlock()
+			return s, nil
+		}
+
+		p.err = err
+		p.retryAt = time.Now().Add(p.retryInterval)
+		p.logger.Error(
+			"Error creating text segmenter",
+			slog.String("engine", Engine),
+			slog.String("error", err.Error()),
+			slog.Duration("retry_in", p.retryInterval),
+		)
+	}
+	if p.created == 0 {
+		// Nothing to wait for, the segmenter could not be created yet.
+		err := p.err
+		p.fallbacks++
+		p.mu.Unlock()
+		return nil, err
+	}
+	p.mu.Unlock()
+
+	return <-p.idle, nil
+}
+
+// Err returns the error of the last failed attempt to create a segmenter, nil once one
+// was created since.
+func (p *Pool) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.err
+}
+
+// Close releases the resources of every segmenter created by the pool. The pool must
+// not be used after Close.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, closer := range p.closers {
+		closer()
+	}
+	p.closers = nil
+}
+
+// Stats returns the pool statistics in a form suitable for publishing through expvar.
+func (p *Pool) Stats() any {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := map[string]any{
+		"engine":    Engine,
+		"size":      p.size,
+		"created":   p.created,
+		"idle":      len(p.idle),
+		"fallbacks": p.fallbacks,
+	}
+	if p.err != nil {
+		stats["error"] = p.err.Error()
+	}
+
+	return stats
+}
@@ -0,0 +1,156 @@
+package tokenizer
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sampleText is a mixed Chinese and English sentence, as found in titles and notes.
+const sampleText = "今天完成了 yatijapp 的搜尋功能，明天開始寫每週報告和提醒"
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.DiscardHandler)
+}
+
+func TestPoolRetriesFailedFactory(t *testing.T) {
+	var calls int
+	failing := true
+	factory := func() (Segmenter, func(), error) {
+		calls++
+		if failing {
+			return nil, nil, errors.New("dictionaries missing")
+		}
+		return simpleSegmenter{}, func() {}, nil
+	}
+
+	pool := NewPool(factory, 2, discardLogger())
+	pool.retryInterval = time.Hour
+
+	want := simpleSegmenter{}.CutForSearch("搜尋", false)
+	for range 3 {
+		if got := pool.CutForSearch("搜尋", false); !slices.Equal(got, want) {
+			t.Fatalf("fallback segmentation: got %q, want %q", got, want)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("factory called %d times within the retry interval, want 1", calls)
+	}
+	if pool.Err() == nil {
+		t.Error("Err() = nil after the factory failed")
+	}
+	if stats := pool.Stats().(map[string]any); stats["fallbacks"] != int64(3) {
+		t.Errorf("fallbacks = %v, want 3", stats["fallbacks"])
+	}
+
+	// Once the retry interval has passed, the pool tries the factory again.
+	failing = false
+	pool.retryAt = time.Time{}
+	if err := pool.Warm(); err != nil {
+		t.Fatalf("Warm() after the factory recovered: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("factory called %d times, want 2", calls)
+	}
+	if err := pool.Err(); err != nil {
+		t.Errorf("Err() = %v after a segmenter was created", err)
+	}
+}
+
+func TestPoolConcurrentUse(t *testing.T) {
+	pool := NewPool(NewSegmenter, 2, discardLogger())
+	defer pool.Close()
+
+	want := pool.CutForSearch(sampleText, true)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				if got := pool.CutForSearch(sampleText, true); !slices.Equal(got, want) {
+					t.Errorf("got %q, want %q", got, want)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if stats := pool.Stats().(map[string]any); stats["created"].(int) > 2 {
+		t.Errorf("created %v segmenters, want at most 2", stats["created"])
+	}
+}
+
+func BenchmarkSimpleSegmenter(b *testing.B) {
+	segmenter := Simple()
+	for b.Loop() {
+		segmenter.CutForSearch(sampleText, true)
+	}
+}
+
+func BenchmarkSegmenter(b *testing.B) {
+	segmenter, closer, err := NewSegmenter()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer closer()
+
+	for b.Loop() {
+		segmenter.CutForSearch(sampleText, true)
+	}
+}
+
+// BenchmarkMutexSegmenter measures a single segmenter shared behind a mutex, the
+// alternative to the pool.
+func BenchmarkMutexSegmenter(b *testing.B) {
+	segmenter, closer, err := NewSegmenter()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer closer()
+
+	var mu sync.Mutex
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			mu.Lock()
+			segmenter.CutForSearch(sampleText, true)
+			mu.Unlock()
+		}
+	})
+}
+
+func BenchmarkPool(b *testing.B) {
+	for _, size := range []int{1, 4} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			pool := NewPool(NewSegmenter, size, discardLogger())
+			defer pool.Close()
+
+			// Create every segmenter up front, not to time the dictionary loading.
+			segmenters := make([]Segmenter, size)
+			for i := range segmenters {
+				segmenter, err := pool.acquire()
+				if err != nil {
+					b.Fatal(err)
+				}
+				segmenters[i] = segmenter
+			}
+			for _, segmenter := range segmenters {
+				pool.idle <- segmenter
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					pool.CutForSearch(sampleText, true)
+				}
+			})
+		})
+	}
+}
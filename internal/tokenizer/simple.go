@@ -0,0 +1,18 @@
+package tokenizer
+
+import "strings"
+
+// simpleSegmenter splits Chinese text into single characters, which are then indexed
+// by the PostgreSQL simple parser. Without a dictionary this is the only way keyword
+// searches can still match part of a sentence, at the cost of search precision.
+type simpleSegmenter struct{}
+
+func (simpleSegmenter) CutForSearch(s string, _ bool) []string {
+	return strings.Split(s, "")
+}
+
+// Simple returns a segmenter which does not depend on any dictionary. It is safe for
+// concurrent use.
+func Simple() Segmenter {
+	return simpleSegmenter{}
+}
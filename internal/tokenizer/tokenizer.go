@@ -3,10 +3,14 @@ package tokenizer
 import (
 	"regexp"
 	"strings"
-
-	"github.com/yanyiwu/gojieba"
 )
 
+// Segmenter splits a run of Chinese text into the terms stored in the search index.
+// It is satisfied by *gojieba.Jieba.
+type Segmenter interface {
+	CutForSearch(s string, hmm bool) []string
+}
+
 type Tokenizer struct {
 	Chinese string
 	English string
@@ -17,12 +21,12 @@ var (
 	reEnglish = regexp.MustCompile(`[a-zA-Z0-9]+`)
 )
 
-func New(s string, jieba *gojieba.Jieba) *Tokenizer {
+func New(s string, segmenter Segmenter) *Tokenizer {
 	chineseMatches := reChinese.FindAllString(s, -1)
 	englishMatches := reEnglish.FindAllString(s, -1)
 
 	rawChinese := strings.Join(chineseMatches, "")
-	chineseTokens := jieba.CutForSearch(rawChinese, true)
+	chineseTokens := segmenter.CutForSearch(rawChinese, true)
 
 	return &Tokenizer{
 		Chinese: strings.Join(chineseTokens, " "),
@@ -0,0 +1,113 @@
+package trace
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSpans is the number of spans kept per trace, the later ones are only counted in
+// the totals.
+const maxSpans = 100
+
+// maxNameLength is the length the span names, such as SQL queries, are cut to.
+const maxNameLength = 80
+
+// Span is a timed step of a request, such as a database query.
+type Span struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Offset is when the step started, relative to the start of the request.
+	Offset   time.Duration `json:"offset"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Trace records the steps of a single request. It is safe for concurrent use.
+type Trace struct {
+	ID    string
+	Start time.Time
+
+	mu     sync.Mutex
+	spans  []Span
+	totals map[string]time.Duration
+	counts map[string]int
+}
+
+// New returns a trace identified by id, starting now.
+func New(id string) *Trace {
+	return &Trace{
+		ID:     id,
+		Start:  time.Now(),
+		totals: make(map[string]time.Duration),
+		counts: make(map[string]int),
+	}
+}
+
+// Record adds the step of the given kind which started at start and ends now. Names
+// are whitespace collapsed and cut, so that SQL queries fit on a log line.
+func (t *Trace) Record(kind, name string, start time.Time) {
+	if t == nil {
+		return
+	}
+	duration := time.Since(start)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.totals[kind] += duration
+	t.counts[kind]++
+	if len(t.spans) >= maxSpans {
+		return
+	}
+
+	name = strings.Join(strings.Fields(name), " ")
+	if len(name) > maxNameLength {
+		name = name[:maxNameLength] + "..."
+	}
+	t.spans = append(t.spans, Span{
+		Kind:     kind,
+		Name:     name,
+		Offset:   start.Sub(t.Start),
+		Duration: duration,
+	})
+}
+
+// Spans returns the recorded steps, in the order they ended.
+func (t *Trace) Spans() []Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return slices.Clone(t.spans)
+}
+
+// Total returns the time spent in the steps of the given kind.
+func (t *Trace) Total(kind string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.totals[kind]
+}
+
+// Count returns the number of steps of the given kind.
+func (t *Trace) Count(kind string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.counts[kind]
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the trace.
+func NewContext(ctx context.Context, t *Trace) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns the trace carried by ctx, nil when there is none. Recording on a
+// nil trace does nothing.
+func FromContext(ctx context.Context) *Trace {
+	t, _ := ctx.Value(contextKey{}).(*Trace)
+	return t
+}
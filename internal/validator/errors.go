@@ -0,0 +1,131 @@
+package validator
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Machine-readable validation error codes, stable across languages so that clients can
+// branch on them.
+const (
+	CodeInvalid           = "invalid"
+	CodeRequired          = "required"
+	CodeTooLong           = "too_long"
+	CodeTooShort          = "too_short"
+	CodeWrongLength       = "wrong_length"
+	CodeTooSmall          = "too_small"
+	CodeTooLarge          = "too_large"
+	CodeNotInteger        = "not_integer"
+	CodeInvalidFormat     = "invalid_format"
+	CodeInvalidCharacters = "invalid_characters"
+	CodeNotPermitted      = "not_permitted"
+	CodeNotInFuture       = "not_in_future"
+	CodeNotAfter          = "not_after"
+	CodeDuplicate         = "duplicate"
+	CodeNotFound          = "not_found"
+	CodeInvalidToken      = "invalid_token"
+	CodeAlreadyActivated  = "already_activated"
+	CodeNotActivated      = "not_activated"
+)
+
+// Length units used by the length errors.
+const (
+	UnitCharacters = "characters"
+	UnitBytes      = "bytes"
+	UnitItems      = "items"
+)
+
+// FieldError describes why the value of a field failed validation. Message is the
+// English message, Params holds the values substituted into localized messages.
+type FieldError struct {
+	Code    string
+	Message string
+	Params  map[string]string
+}
+
+// Invalid returns a generic validation error with the given message.
+func Invalid(message string) FieldError {
+	return FieldError{Code: CodeInvalid, Message: message}
+}
+
+// Required returns the error of a missing value.
+func Required() FieldError {
+	return FieldError{Code: CodeRequired, Message: "must be provided"}
+}
+
+// TooLong returns the error of a value longer than maxLen units.
+func TooLong(maxLen int, unit string) FieldError {
+	return FieldError{
+		Code:    CodeTooLong,
+		Message: fmt.Sprintf("must not be more than %d %s long", maxLen, unit),
+		Params:  map[string]string{"max": strconv.Itoa(maxLen), "unit": unit},
+	}
+}
+
+// TooShort returns the error of a value shorter than minLen units.
+func TooShort(minLen int, unit string) FieldError {
+	return FieldError{
+		Code:    CodeTooShort,
+		Message: fmt.Sprintf("must be at least %d %s long", minLen, unit),
+		Params:  map[string]string{"min": strconv.Itoa(minLen), "unit": unit},
+	}
+}
+
+// WrongLength returns the error of a value which is not exactly length units long.
+func WrongLength(length int, unit string) FieldError {
+	return FieldError{
+		Code:    CodeWrongLength,
+		Message: fmt.Sprintf("must be %d %s long", length, unit),
+		Params:  map[string]string{"length": strconv.Itoa(length), "unit": unit},
+	}
+}
+
+// TooSmall returns the error of a number lower than minValue, described by message.
+func TooSmall(minValue int, message string) FieldError {
+	return FieldError{
+		Code:    CodeTooSmall,
+		Message: message,
+		Params:  map[string]string{"min": strconv.Itoa(minValue)},
+	}
+}
+
+// TooLarge returns the error of a number greater than maxValue, described by message.
+func TooLarge(maxValue int, message string) FieldError {
+	return FieldError{
+		Code:    CodeTooLarge,
+		Message: message,
+		Params:  map[string]string{"max": strconv.Itoa(maxValue)},
+	}
+}
+
+// NotInteger returns the error of a value which cannot be parsed as an integer.
+func NotInteger() FieldError {
+	return FieldError{Code: CodeNotInteger, Message: "must be an integer"}
+}
+
+// InvalidFormat returns the error of a value which is not in the expected format,
+// described by message.
+func InvalidFormat(message string) FieldError {
+	return FieldError{Code: CodeInvalidFormat, Message: message}
+}
+
+// NotPermitted returns the error of a value outside of a safelist, described by
+// message.
+func NotPermitted(message string) FieldError {
+	return FieldError{Code: CodeNotPermitted, Message: message}
+}
+
+// NotAfter returns the error of a time which is not after the one in the other field.
+func NotAfter(other string) FieldError {
+	return FieldError{
+		Code:    CodeNotAfter,
+		Message: "must be after " + other,
+		Params:  map[string]string{"other": other},
+	}
+}
+
+// NewFieldError returns an error with the given code and message, for the codes
+// without a dedicated constructor.
+func NewFieldError(code, message string) FieldError {
+	return FieldError{Code: code, Message: message}
+}
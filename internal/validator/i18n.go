@@ -0,0 +1,122 @@
+package validator
+
+import (
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// Languages lists the languages validation messages can be rendered in, the first
+// one is the default.
+var Languages = []language.Tag{
+	language.English,
+	language.TraditionalChinese,
+	language.SimplifiedChinese,
+}
+
+// catalogs maps a language to the message templates of each error code. Templates
+// refer to the error params as {name}, and length units are translated through the
+// "unit.<unit>" entries. Codes missing from a catalog keep their English message.
+var catalogs = map[language.Tag]map[string]string{
+	language.TraditionalChinese: {
+		CodeRequired:             "必須提供",
+		CodeTooLong:              "長度不得超過 {max} {unit}",
+		CodeTooShort:             "長度至少需要 {min} {unit}",
+		CodeWrongLength:          "長度必須為 {length} {unit}",
+		CodeTooSmall:             "不得小於 {min}",
+		CodeTooLarge:             "不得大於 {max}",
+		CodeNotInteger:           "必須為整數",
+		CodeInvalidFormat:        "格式不正確",
+		CodeInvalidCharacters:    "不得包含控制或格式字元",
+		CodeNotPermitted:         "不是允許的值",
+		CodeNotInFuture:          "必須是未來的日期",
+		CodeNotAfter:             "必須晚於 {other}",
+		CodeDuplicate:            "已經存在",
+		CodeNotFound:             "找不到符合的資料",
+		CodeInvalidToken:         "無效或已過期的憑證",
+		CodeAlreadyActivated:     "使用者已經啟用",
+		CodeNotActivated:         "使用者帳號必須先啟用",
+		"unit." + UnitCharacters: "個字元",
+		"unit." + UnitBytes:      "位元組",
+		"unit." + UnitItems:      "項",
+	},
+	language.SimplifiedChinese: {
+		CodeRequired:             "必须提供",
+		CodeTooLong:              "长度不得超过 {max} {unit}",
+		CodeTooShort:             "长度至少需要 {min} {unit}",
+		CodeWrongLength:          "长度必须为 {length} {unit}",
+		CodeTooSmall:             "不得小于 {min}",
+		CodeTooLarge:             "不得大于 {max}",
+		CodeNotInteger:           "必须为整数",
+		CodeInvalidFormat:        "格式不正确",
+		CodeInvalidCharacters:    "不得包含控制或格式字符",
+		CodeNotPermitted:         "不是允许的值",
+		CodeNotInFuture:          "必须是未来的日期",
+		CodeNotAfter:             "必须晚于 {other}",
+		CodeDuplicate:            "已经存在",
+		CodeNotFound:             "找不到匹配的数据",
+		CodeInvalidToken:         "无效或已过期的令牌",
+		CodeAlreadyActivated:     "用户已经激活",
+		CodeNotActivated:         "用户账号必须先激活",
+		"unit." + UnitCharacters: "个字符",
+		"unit." + UnitBytes:      "字节",
+		"unit." + UnitItems:      "项",
+	},
+}
+
+var matcher = language.NewMatcher(Languages)
+
+// MatchLanguage returns the supported language which best matches the given
+// Accept-Language header value, or English when none matches.
+func MatchLanguage(acceptLanguage string) language.Tag {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return Languages[0]
+	}
+
+	_, index, confidence := matcher.Match(tags...)
+	if confidence == language.No {
+		return Languages[0]
+	}
+
+	return Languages[index]
+}
+
+// Localize renders the message of the error in the given language, falling back to
+// the English message when the language or the code has no translation.
+func (fe FieldError) Localize(lang language.Tag) string {
+	catalog, ok := catalogs[lang]
+	if !ok {
+		return fe.Message
+	}
+	template, ok := catalog[fe.Code]
+	if !ok {
+		return fe.Message
+	}
+
+	oldnew := make([]string, 0, 2*len(fe.Params))
+	for name, value := range fe.Params {
+		if name == "unit" {
+			if unit, ok := catalog["unit."+value]; ok {
+				value = unit
+			}
+		}
+		oldnew = append(oldnew, "{"+name+"}", value)
+	}
+
+	return strings.NewReplacer(oldnew...).Replace(template)
+}
+
+// Localized returns the message of every validation error rendered in the given
+// language, keyed by field.
+func (v *Validator) Localized(lang language.Tag) map[string]string {
+	messages := make(map[string]string, len(v.Errors))
+	for key, message := range v.Errors {
+		messages[key] = message
+		if fe, ok := v.Fields[key]; ok {
+			messages[key] = fe.Localize(lang)
+		}
+	}
+
+	return messages
+}
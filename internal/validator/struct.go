@@ -0,0 +1,180 @@
+package validator
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Struct validates the fields of the struct pointed to by dst according to their
+// `validate` tags, and records the failures on the validator. Fields are reported
+// under their JSON name, nested structs under "parent.child". The supported rules,
+// separated by commas, are:
+//
+//	required    the value must not be the zero value
+//	min=N       strings and slices must hold at least N characters or items,
+//	            numbers must be at least N
+//	max=N       strings and slices must hold at most N characters or items,
+//	            numbers must be at most N
+//	len=N       strings and slices must hold exactly N characters or items
+//	oneof=a b   the value must be one of the space separated values
+//	email       the value must be a valid email address
+//
+// Rules other than required are skipped for nil pointers and empty values, so that
+// optional fields are only checked when provided. Struct panics on an unknown rule,
+// as it is a programming error.
+func (v *Validator) Struct(dst any) {
+	rv := reflect.Indirect(reflect.ValueOf(dst))
+	if rv.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validator: Struct expects a struct, got %s", rv.Kind()))
+	}
+
+	v.validateStruct(rv, "")
+}
+
+func (v *Validator) validateStruct(rv reflect.Value, prefix string) {
+	rt := rv.Type()
+	for i := range rt.NumField() {
+		field := rt.Field(i)
+		// Like encoding/json, promote the fields of embedded structs of unexported types.
+		if !field.IsExported() && !(field.Anonymous && field.Type.Kind() == reflect.Struct) {
+			continue
+		}
+
+		key := prefix + fieldKey(field)
+		value := rv.Field(i)
+		tag := field.Tag.Get("validate")
+
+		if tag != "" && tag != "-" {
+			v.validateField(key, value, tag)
+		}
+
+		// Descend into nested structs, the time.Time-like structs without exported
+		// fields are naturally skipped.
+		if value.Kind() == reflect.Pointer && !value.IsNil() {
+			value = value.Elem()
+		}
+		if value.Kind() == reflect.Struct {
+			if field.Anonymous {
+				v.validateStruct(value, prefix)
+			} else {
+				v.validateStruct(value, key+".")
+			}
+		}
+	}
+}
+
+func (v *Validator) validateField(key string, value reflect.Value, tag string) {
+	for rule := range strings.SplitSeq(tag, ",") {
+		name, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+
+		if name == "required" {
+			v.CheckField(!isEmpty(value), key, Required())
+			continue
+		}
+
+		if isEmpty(value) {
+			continue
+		}
+		value := reflect.Indirect(value)
+
+		switch name {
+		case "min":
+			n := intParam(name, param)
+			if size, unit, ok := sizeOf(value); ok {
+				v.CheckField(size >= n, key, TooShort(n, unit))
+			} else {
+				v.CheckField(
+					number(value) >= float64(n),
+					key,
+					TooSmall(n, fmt.Sprintf("must be at least %d", n)),
+				)
+			}
+		case "max":
+			n := intParam(name, param)
+			if size, unit, ok := sizeOf(value); ok {
+				v.CheckField(size <= n, key, TooLong(n, unit))
+			} else {
+				v.CheckField(
+					number(value) <= float64(n),
+					key,
+					TooLarge(n, fmt.Sprintf("must be a maximum of %d", n)),
+				)
+			}
+		case "len":
+			n := intParam(name, param)
+			size, unit, _ := sizeOf(value)
+			v.CheckField(size == n, key, WrongLength(n, unit))
+		case "oneof":
+			permitted := strings.Fields(param)
+			v.CheckField(
+				PermittedValue(fmt.Sprint(value.Interface()), permitted...),
+				key,
+				NotPermitted("must be one of "+strings.Join(permitted, ", ")),
+			)
+		case "email":
+			v.CheckField(
+				Matches(value.String(), EmailRX),
+				key,
+				InvalidFormat("must be a valid email address"),
+			)
+		default:
+			panic(fmt.Sprintf("validator: unknown rule %q on field %q", name, key))
+		}
+	}
+}
+
+// fieldKey returns the name of the field in the JSON representation of the struct.
+func fieldKey(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+
+	return name
+}
+
+func isEmpty(value reflect.Value) bool {
+	if value.Kind() == reflect.Pointer {
+		return value.IsNil()
+	}
+
+	return value.IsZero()
+}
+
+// sizeOf returns the length of strings, counted in characters, and of slices and
+// maps. It reports false for the other kinds.
+func sizeOf(value reflect.Value) (int, string, bool) {
+	switch value.Kind() {
+	case reflect.String:
+		return utf8.RuneCountInString(value.String()), UnitCharacters, true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return value.Len(), UnitItems, true
+	default:
+		return 0, "", false
+	}
+}
+
+func number(value reflect.Value) float64 {
+	switch {
+	case value.CanInt():
+		return float64(value.Int())
+	case value.CanUint():
+		return float64(value.Uint())
+	case value.CanFloat():
+		return value.Float()
+	default:
+		panic(fmt.Sprintf("validator: %s is neither sized nor a number", value.Type()))
+	}
+}
+
+func intParam(rule, param string) int {
+	n, err := strconv.Atoi(param)
+	if err != nil {
+		panic(fmt.Sprintf("validator: rule %q expects an integer parameter, got %q", rule, param))
+	}
+
+	return n
+}
@@ -0,0 +1,155 @@
+package validator
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+type address struct {
+	City    string `json:"city" validate:"required"`
+	Country string `json:"country" validate:"len=2"`
+}
+
+type audit struct {
+	Reason string `json:"reason" validate:"max=5"`
+}
+
+type profile struct {
+	Name     string   `json:"name" validate:"required,min=2,max=5"`
+	Email    string   `json:"email" validate:"email"`
+	Role     string   `json:"role" validate:"oneof=owner editor viewer"`
+	Age      *int     `json:"age" validate:"min=18,max=130"`
+	Tags     []string `json:"tags" validate:"max=2"`
+	Nickname string   `validate:"max=3"`
+	Home     address  `json:"home"`
+	Work     *address `json:"work"`
+	ignored  string
+	audit
+}
+
+func TestStruct(t *testing.T) {
+	valid := func() profile {
+		return profile{Name: "Jane", Home: address{City: "Taipei"}}
+	}
+	age := func(n int) *int { return &n }
+
+	tests := []struct {
+		name   string
+		modify func(p *profile)
+		// codes are the expected error codes, keyed by field.
+		codes map[string]string
+	}{
+		{
+			name:   "valid",
+			modify: func(p *profile) {},
+			codes:  map[string]string{},
+		},
+		{
+			name:   "required",
+			modify: func(p *profile) { p.Name = "" },
+			codes:  map[string]string{"name": CodeRequired},
+		},
+		{
+			name:   "min counts characters",
+			modify: func(p *profile) { p.Name = "今" },
+			codes:  map[string]string{"name": CodeTooShort},
+		},
+		{
+			name:   "max counts characters",
+			modify: func(p *profile) { p.Name = "今天完成了" },
+			codes:  map[string]string{},
+		},
+		{
+			name:   "max",
+			modify: func(p *profile) { p.Name = "Janette" },
+			codes:  map[string]string{"name": CodeTooLong},
+		},
+		{
+			name:   "email",
+			modify: func(p *profile) { p.Email = "jane.example.com" },
+			codes:  map[string]string{"email": CodeInvalidFormat},
+		},
+		{
+			name:   "oneof",
+			modify: func(p *profile) { p.Role = "admin" },
+			codes:  map[string]string{"role": CodeNotPermitted},
+		},
+		{
+			name:   "oneof permitted",
+			modify: func(p *profile) { p.Role = "editor" },
+			codes:  map[string]string{},
+		},
+		{
+			name:   "number too small",
+			modify: func(p *profile) { p.Age = age(12) },
+			codes:  map[string]string{"age": CodeTooSmall},
+		},
+		{
+			name:   "number too large",
+			modify: func(p *profile) { p.Age = age(200) },
+			codes:  map[string]string{"age": CodeTooLarge},
+		},
+		{
+			name:   "slice items",
+			modify: func(p *profile) { p.Tags = []string{"a", "b", "c"} },
+			codes:  map[string]string{"tags": CodeTooLong},
+		},
+		{
+			name:   "field without json name",
+			modify: func(p *profile) { p.Nickname = "Janey" },
+			codes:  map[string]string{"Nickname": CodeTooLong},
+		},
+		{
+			name:   "nested struct",
+			modify: func(p *profile) { p.Home = address{Country: "TWN"} },
+			codes: map[string]string{
+				"home.city":    CodeRequired,
+				"home.country": CodeWrongLength,
+			},
+		},
+		{
+			name:   "nested pointer",
+			modify: func(p *profile) { p.Work = &address{City: "Tainan", Country: "T"} },
+			codes:  map[string]string{"work.country": CodeWrongLength},
+		},
+		{
+			name:   "embedded struct",
+			modify: func(p *profile) { p.Reason = "too long" },
+			codes:  map[string]string{"reason": CodeTooLong},
+		},
+		{
+			name:   "unexported field",
+			modify: func(p *profile) { p.ignored = strings.Repeat("x", 10) },
+			codes:  map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid()
+			tt.modify(&p)
+
+			v := New()
+			v.Struct(&p)
+
+			if got := v.Codes(); !maps.Equal(got, tt.codes) {
+				t.Errorf("got %v, want %v", got, tt.codes)
+			}
+		})
+	}
+}
+
+func TestStructUnknownRule(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic on an unknown rule")
+		}
+	}()
+
+	var input struct {
+		Name string `json:"name" validate:"uppercase"`
+	}
+	input.Name = "jane"
+	New().Struct(&input)
+}
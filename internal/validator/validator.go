@@ -11,14 +11,19 @@ var EmailRX = regexp.MustCompile(
 	"^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$",
 )
 
-// Validator contains a map of validation errors.
+// Validator contains a map of validation errors, along with the machine-readable
+// code of each error.
 type Validator struct {
 	Errors map[string]string
+	Fields map[string]FieldError
 }
 
 // New creates a Validator instance with an empty error map.
 func New() *Validator {
-	return &Validator{Errors: make(map[string]string)}
+	return &Validator{
+		Errors: make(map[string]string),
+		Fields: make(map[string]FieldError),
+	}
 }
 
 // Valid returns true if the errors map has no entries.
@@ -27,9 +32,18 @@ func (v *Validator) Valid() bool {
 }
 
 // AddError adds a validation error to the errors map if no entry for the key already exists.
+// The error is reported with the generic invalid code, use AddFieldError for a more
+// specific one.
 func (v *Validator) AddError(key, message string) {
+	v.AddFieldError(key, Invalid(message))
+}
+
+// AddFieldError adds a validation error with its code to the errors map if no entry for
+// the key already exists.
+func (v *Validator) AddFieldError(key string, fe FieldError) {
 	if _, exists := v.Errors[key]; !exists {
-		v.Errors[key] = message
+		v.Errors[key] = fe.Message
+		v.Fields[key] = fe
 	}
 }
 
@@ -40,6 +54,23 @@ func (v *Validator) Check(ok bool, key, message string) {
 	}
 }
 
+// CheckField adds a validation error with its code to the map if validation fails.
+func (v *Validator) CheckField(ok bool, key string, fe FieldError) {
+	if !ok {
+		v.AddFieldError(key, fe)
+	}
+}
+
+// Codes returns the code of every validation error, keyed by field.
+func (v *Validator) Codes() map[string]string {
+	codes := make(map[string]string, len(v.Fields))
+	for key, fe := range v.Fields {
+		codes[key] = fe.Code
+	}
+
+	return codes
+}
+
 // PermittedValue checks if a value is in the list of permitted values.
 func PermittedValue[T comparable](value T, permittedValues ...T) bool {
 	return slices.Contains(permittedValues, value)
@@ -0,0 +1,9 @@
+DELETE FROM "sessions" WHERE "action_uuid" IS NULL;
+
+DROP INDEX IF EXISTS "sessions_target_uuid_idx";
+
+ALTER TABLE "sessions" DROP CONSTRAINT IF EXISTS "sessions_single_parent";
+
+ALTER TABLE "sessions" DROP COLUMN IF EXISTS "target_uuid";
+
+ALTER TABLE "sessions" ALTER COLUMN "action_uuid" SET NOT NULL;
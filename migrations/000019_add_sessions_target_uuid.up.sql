@@ -0,0 +1,11 @@
+ALTER TABLE "sessions" ALTER COLUMN "action_uuid" DROP NOT NULL;
+
+ALTER TABLE "sessions"
+    ADD COLUMN "target_uuid" uuid REFERENCES targets(uuid) ON DELETE CASCADE;
+
+-- A session is attached to an action, directly to a target, or to nothing at all until it is
+-- classified. Sessions of an action belong to the target of the action.
+ALTER TABLE "sessions"
+    ADD CONSTRAINT "sessions_single_parent" CHECK (action_uuid IS NULL OR target_uuid IS NULL);
+
+CREATE INDEX "sessions_target_uuid_idx" ON "sessions" ("target_uuid");
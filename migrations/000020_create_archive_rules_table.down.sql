@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "archive_notices";
+DROP TABLE IF EXISTS "archive_rules";
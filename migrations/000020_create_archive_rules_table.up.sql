@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS "archive_rules" (
+    "uuid" uuid PRIMARY KEY DEFAULT uuidv7 (),
+    "user_uuid" uuid NOT NULL REFERENCES users (uuid) ON DELETE CASCADE,
+    "resource_type" resource_types NOT NULL,
+    "status" statuses NOT NULL,
+    "inactive_days" int NOT NULL,
+    "notice_days" int NOT NULL DEFAULT 3,
+    "enabled" boolean NOT NULL DEFAULT TRUE,
+    "created_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "updated_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "version" int NOT NULL DEFAULT 1,
+    CONSTRAINT archive_rules_resource_type CHECK (resource_type IN ('target', 'action')),
+    CONSTRAINT archive_rules_status CHECK (status IN ('completed', 'canceled')),
+    CONSTRAINT archive_rules_inactive_days CHECK (inactive_days > 0),
+    CONSTRAINT archive_rules_notice_days CHECK (notice_days >= 0 AND notice_days < inactive_days)
+);
+
+CREATE INDEX "archive_rules_user_uuid_idx" ON "archive_rules" ("user_uuid");
+
+-- Records the owners have been warned about. A notice only counts for the period of
+-- inactivity it was sent in, any activity on the record afterwards starts a new one.
+CREATE TABLE IF NOT EXISTS "archive_notices" (
+    "rule_uuid" uuid NOT NULL REFERENCES archive_rules (uuid) ON DELETE CASCADE,
+    "resource_uuid" uuid NOT NULL,
+    "notified_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY ("rule_uuid", "resource_uuid")
+);
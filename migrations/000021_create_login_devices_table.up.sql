@@ -0,0 +1,12 @@
+-- The combinations of IP, country and user agent each user signed in from, used to
+-- warn users about sign-ins from a new device.
+CREATE TABLE IF NOT EXISTS "login_devices" (
+    "user_uuid" uuid NOT NULL REFERENCES users (uuid) ON DELETE CASCADE,
+    "fingerprint" bytea NOT NULL,
+    "ip" text NOT NULL,
+    "country" text NOT NULL DEFAULT '',
+    "user_agent" text NOT NULL DEFAULT '',
+    "first_seen" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "last_seen" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY ("user_uuid", "fingerprint")
+);
@@ -0,0 +1,10 @@
+-- API consumers identify themselves with the uuid of their registration in the
+-- X-Client-Id header, so that the load each integration drives can be measured.
+CREATE TABLE IF NOT EXISTS "client_apps" (
+    "uuid" uuid PRIMARY KEY DEFAULT uuidv7 (),
+    "user_uuid" uuid NOT NULL REFERENCES users (uuid) ON DELETE CASCADE,
+    "name" text NOT NULL,
+    "created_at" timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX "client_apps_user_uuid_idx" ON "client_apps" ("user_uuid");
@@ -0,0 +1,16 @@
+-- Deleted records are kept for a short while, along with everything deleted with them,
+-- so that the user can undo the deletion.
+CREATE TABLE IF NOT EXISTS "undo_operations" (
+    "uuid" uuid PRIMARY KEY DEFAULT uuidv7 (),
+    "user_uuid" uuid NOT NULL REFERENCES users (uuid) ON DELETE CASCADE,
+    "resource_type" resource_types NOT NULL,
+    "resource_uuid" uuid NOT NULL,
+    -- Rows of every table affected by the operation, keyed by table name.
+    "snapshot" jsonb NOT NULL,
+    -- Usage date of the daily quota given back by the deletion, if any.
+    "refund_date" date,
+    "created_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "expires_at" timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX "undo_operations_expires_at_idx" ON "undo_operations" ("expires_at");
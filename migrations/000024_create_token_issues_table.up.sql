@@ -0,0 +1,10 @@
+-- Activation and password reset tokens issued to each user, kept for the throttling
+-- window to limit how many emails can be sent to a single account.
+CREATE TABLE IF NOT EXISTS "token_issues" (
+    "user_uuid" uuid NOT NULL REFERENCES users (uuid) ON DELETE CASCADE,
+    "scope" text NOT NULL,
+    "issued_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "expires_at" timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX "token_issues_user_uuid_scope_idx" ON "token_issues" ("user_uuid", "scope");
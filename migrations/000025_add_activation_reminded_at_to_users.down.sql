@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS "users_unactivated_created_at_idx";
+
+ALTER TABLE users DROP COLUMN IF EXISTS "activation_reminded_at";
@@ -0,0 +1,5 @@
+-- When the activation reminder was sent to a user who has not activated their account.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS "activation_reminded_at" timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS "users_unactivated_created_at_idx" ON users ("created_at")
+WHERE NOT activated;
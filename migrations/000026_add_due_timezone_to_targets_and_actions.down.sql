@@ -0,0 +1,9 @@
+ALTER TABLE actions
+    ALTER COLUMN "due_date" TYPE date
+    USING ("due_date" AT TIME ZONE COALESCE(NULLIF("due_timezone", ''), 'UTC'))::date;
+ALTER TABLE actions DROP COLUMN IF EXISTS "due_timezone";
+
+ALTER TABLE targets
+    ALTER COLUMN "due_date" TYPE date
+    USING ("due_date" AT TIME ZONE COALESCE(NULLIF("due_timezone", ''), 'UTC'))::date;
+ALTER TABLE targets DROP COLUMN IF EXISTS "due_timezone";
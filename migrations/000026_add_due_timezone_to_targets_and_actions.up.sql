@@ -0,0 +1,12 @@
+-- Due dates become timestamps, the existing dates being due at midnight UTC. The
+-- timezone, an IANA name, tells where the due time was set so that it can be shown and
+-- reminded in local time. An empty timezone stands for UTC.
+ALTER TABLE targets
+    ALTER COLUMN "due_date" TYPE timestamp(0) with time zone
+    USING "due_date"::timestamp AT TIME ZONE 'UTC';
+ALTER TABLE targets ADD COLUMN IF NOT EXISTS "due_timezone" text NOT NULL DEFAULT '';
+
+ALTER TABLE actions
+    ALTER COLUMN "due_date" TYPE timestamp(0) with time zone
+    USING "due_date"::timestamp AT TIME ZONE 'UTC';
+ALTER TABLE actions ADD COLUMN IF NOT EXISTS "due_timezone" text NOT NULL DEFAULT '';
@@ -0,0 +1,23 @@
+-- Reminders emailed to a user about one of their targets or actions. A snoozed reminder
+-- fires at snoozed_until instead of remind_at, and sent_at is set once it has fired. The
+-- timezone, an IANA name, is the one the reminder was set in, UTC when empty.
+CREATE TABLE IF NOT EXISTS "reminders" (
+    "uuid" uuid PRIMARY KEY DEFAULT uuidv7 (),
+    "user_uuid" uuid NOT NULL REFERENCES users (uuid) ON DELETE CASCADE,
+    "resource_type" resource_types NOT NULL,
+    "resource_uuid" uuid NOT NULL,
+    "remind_at" timestamp(0) with time zone NOT NULL,
+    "timezone" text NOT NULL DEFAULT '',
+    "snoozed_until" timestamp(0) with time zone,
+    "sent_at" timestamp(0) with time zone,
+    "created_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "updated_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "version" integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS "reminders_user_uuid_idx" ON "reminders" ("user_uuid");
+
+CREATE INDEX IF NOT EXISTS "reminders_pending_idx" ON "reminders" (
+    (COALESCE("snoozed_until", "remind_at"))
+)
+WHERE "sent_at" IS NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "daily_plan_items";
+DROP TABLE IF EXISTS "daily_plans";
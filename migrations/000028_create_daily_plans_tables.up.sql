@@ -0,0 +1,23 @@
+-- The actions a user plans to focus on during a day. The unfinished actions of the last
+-- plan are rolled forward into the plan of the next day the user opens.
+CREATE TABLE IF NOT EXISTS "daily_plans" (
+    "user_uuid" uuid NOT NULL REFERENCES users (uuid) ON DELETE CASCADE,
+    "plan_date" date NOT NULL,
+    "created_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "updated_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY ("user_uuid", "plan_date")
+);
+
+CREATE TABLE IF NOT EXISTS "daily_plan_items" (
+    "user_uuid" uuid NOT NULL,
+    "plan_date" date NOT NULL,
+    "action_uuid" uuid NOT NULL REFERENCES actions (uuid) ON DELETE CASCADE,
+    "position" integer NOT NULL,
+    -- Whether the item was carried over from the previous plan.
+    "rolled_over" boolean NOT NULL DEFAULT false,
+    PRIMARY KEY ("user_uuid", "plan_date", "action_uuid"),
+    FOREIGN KEY ("user_uuid", "plan_date")
+        REFERENCES daily_plans ("user_uuid", "plan_date") ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS "daily_plan_items_action_uuid_idx" ON "daily_plan_items" ("action_uuid");
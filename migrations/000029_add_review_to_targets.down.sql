@@ -0,0 +1,3 @@
+ALTER TABLE targets DROP COLUMN IF EXISTS "reviewed_at";
+ALTER TABLE targets DROP COLUMN IF EXISTS "review_note";
+ALTER TABLE targets DROP COLUMN IF EXISTS "review_rating";
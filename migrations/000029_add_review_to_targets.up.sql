@@ -0,0 +1,6 @@
+-- Retrospective of a completed target: a rating from 1 to 5 and a note. The rating is
+-- null until the target is reviewed.
+ALTER TABLE targets ADD COLUMN IF NOT EXISTS "review_rating" smallint
+    CHECK ("review_rating" BETWEEN 1 AND 5);
+ALTER TABLE targets ADD COLUMN IF NOT EXISTS "review_note" text NOT NULL DEFAULT '';
+ALTER TABLE targets ADD COLUMN IF NOT EXISTS "reviewed_at" timestamp(0) with time zone;
@@ -0,0 +1,2 @@
+ALTER TABLE targets DROP COLUMN IF EXISTS "currency";
+ALTER TABLE targets DROP COLUMN IF EXISTS "rate";
@@ -0,0 +1,4 @@
+-- Default hourly rate of the time spent on a target, in minor units of its currency, an
+-- ISO 4217 code. The rate is null when the target is not billable.
+ALTER TABLE targets ADD COLUMN IF NOT EXISTS "rate" bigint CHECK ("rate" >= 0);
+ALTER TABLE targets ADD COLUMN IF NOT EXISTS "currency" text NOT NULL DEFAULT '';
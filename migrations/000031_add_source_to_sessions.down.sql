@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS "sessions_source_idx";
+ALTER TABLE sessions DROP COLUMN IF EXISTS "source";
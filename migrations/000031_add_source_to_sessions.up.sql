@@ -0,0 +1,6 @@
+-- How a session was created: manually, from a running timer, by an import, with an API
+-- key or by a calendar sync.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS "source" text NOT NULL DEFAULT 'manual'
+    CHECK ("source" IN ('manual', 'timer', 'import', 'api_key', 'calendar'));
+
+CREATE INDEX IF NOT EXISTS "sessions_source_idx" ON "sessions" ("source");
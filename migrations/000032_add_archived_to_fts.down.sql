@@ -0,0 +1,20 @@
+DROP INDEX IF EXISTS targets_fts_chinese_tsv_idx;
+DROP INDEX IF EXISTS targets_fts_english_tsv_idx;
+DROP INDEX IF EXISTS targets_fts_chinese_notes_tsv_idx;
+DROP INDEX IF EXISTS targets_fts_english_notes_tsv_idx;
+DROP INDEX IF EXISTS actions_fts_chinese_tsv_idx;
+DROP INDEX IF EXISTS actions_fts_english_tsv_idx;
+DROP INDEX IF EXISTS actions_fts_chinese_notes_tsv_idx;
+DROP INDEX IF EXISTS actions_fts_english_notes_tsv_idx;
+
+CREATE INDEX IF NOT EXISTS targets_fts_chinese_tsv_idx ON targets_fts USING GIN (fts_chinese_tsv);
+CREATE INDEX IF NOT EXISTS targets_fts_english_tsv_idx ON targets_fts USING GIN (fts_english_tsv);
+CREATE INDEX IF NOT EXISTS targets_fts_chinese_notes_tsv_idx ON targets_fts USING GIN (fts_chinese_notes_tsv);
+CREATE INDEX IF NOT EXISTS targets_fts_english_notes_tsv_idx ON targets_fts USING GIN (fts_english_notes_tsv);
+CREATE INDEX IF NOT EXISTS actions_fts_chinese_tsv_idx ON actions_fts USING GIN (fts_chinese_tsv);
+CREATE INDEX IF NOT EXISTS actions_fts_english_tsv_idx ON actions_fts USING GIN (fts_english_tsv);
+CREATE INDEX IF NOT EXISTS actions_fts_chinese_notes_tsv_idx ON actions_fts USING GIN (fts_chinese_notes_tsv);
+CREATE INDEX IF NOT EXISTS actions_fts_english_notes_tsv_idx ON actions_fts USING GIN (fts_english_notes_tsv);
+
+ALTER TABLE actions_fts DROP COLUMN IF EXISTS "archived";
+ALTER TABLE targets_fts DROP COLUMN IF EXISTS "archived";
@@ -0,0 +1,43 @@
+-- Archived records are flagged in the search tables, whose GIN indexes only cover the
+-- records which are not archived: the lists leave archived records out by default, so
+-- the indexes they use no longer grow with the history of the users.
+ALTER TABLE targets_fts ADD COLUMN IF NOT EXISTS "archived" boolean NOT NULL DEFAULT FALSE;
+ALTER TABLE actions_fts ADD COLUMN IF NOT EXISTS "archived" boolean NOT NULL DEFAULT FALSE;
+
+UPDATE targets_fts AS fts
+SET archived = TRUE
+FROM targets t
+WHERE t.uuid = fts.target_uuid AND t.status = 'archived';
+
+UPDATE actions_fts AS fts
+SET archived = TRUE
+FROM actions a
+WHERE a.uuid = fts.action_uuid AND a.status = 'archived';
+
+DROP INDEX IF EXISTS targets_fts_chinese_tsv_idx;
+DROP INDEX IF EXISTS targets_fts_english_tsv_idx;
+DROP INDEX IF EXISTS targets_fts_chinese_notes_tsv_idx;
+DROP INDEX IF EXISTS targets_fts_english_notes_tsv_idx;
+-- The actions_fts indexes of 000010 were created on targets_fts.
+DROP INDEX IF EXISTS actions_fts_chinese_tsv_idx;
+DROP INDEX IF EXISTS actions_fts_english_tsv_idx;
+DROP INDEX IF EXISTS actions_fts_chinese_notes_tsv_idx;
+DROP INDEX IF EXISTS actions_fts_english_notes_tsv_idx;
+
+CREATE INDEX IF NOT EXISTS targets_fts_chinese_tsv_idx ON targets_fts USING GIN (fts_chinese_tsv)
+WHERE NOT archived;
+CREATE INDEX IF NOT EXISTS targets_fts_english_tsv_idx ON targets_fts USING GIN (fts_english_tsv)
+WHERE NOT archived;
+CREATE INDEX IF NOT EXISTS targets_fts_chinese_notes_tsv_idx ON targets_fts USING GIN (fts_chinese_notes_tsv)
+WHERE NOT archived;
+CREATE INDEX IF NOT EXISTS targets_fts_english_notes_tsv_idx ON targets_fts USING GIN (fts_english_notes_tsv)
+WHERE NOT archived;
+
+CREATE INDEX IF NOT EXISTS actions_fts_chinese_tsv_idx ON actions_fts USING GIN (fts_chinese_tsv)
+WHERE NOT archived;
+CREATE INDEX IF NOT EXISTS actions_fts_english_tsv_idx ON actions_fts USING GIN (fts_english_tsv)
+WHERE NOT archived;
+CREATE INDEX IF NOT EXISTS actions_fts_chinese_notes_tsv_idx ON actions_fts USING GIN (fts_chinese_notes_tsv)
+WHERE NOT archived;
+CREATE INDEX IF NOT EXISTS actions_fts_english_notes_tsv_idx ON actions_fts USING GIN (fts_english_notes_tsv)
+WHERE NOT archived;
@@ -0,0 +1,41 @@
+CREATE TABLE "sessions_unpartitioned" (
+    "uuid" uuid PRIMARY KEY DEFAULT uuidv7 (),
+    "starts_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "ends_at" timestamp(0) with time zone,
+    "created_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "updated_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "notes" text NOT NULL DEFAULT '',
+    "version" int NOT NULL DEFAULT 1,
+    "action_uuid" uuid REFERENCES actions(uuid) ON DELETE CASCADE,
+    "target_uuid" uuid REFERENCES targets(uuid) ON DELETE CASCADE,
+    "source" text NOT NULL DEFAULT 'manual'
+        CHECK ("source" IN ('manual', 'timer', 'import', 'api_key', 'calendar')),
+    CONSTRAINT ends_after_starts CHECK (ends_at IS NULL OR ends_at > starts_at),
+    CONSTRAINT sessions_single_parent CHECK (action_uuid IS NULL OR target_uuid IS NULL)
+);
+
+INSERT INTO sessions_unpartitioned (
+    uuid, starts_at, ends_at, created_at, updated_at, notes, version,
+    action_uuid, target_uuid, source
+)
+SELECT
+    uuid, starts_at, ends_at, created_at, updated_at, notes, version,
+    action_uuid, target_uuid, source
+FROM sessions;
+
+DROP TRIGGER IF EXISTS sessions_delete_dependents ON sessions;
+DROP FUNCTION IF EXISTS sessions_delete_dependents();
+DROP TABLE sessions;
+ALTER TABLE sessions_unpartitioned RENAME TO sessions;
+ALTER TABLE sessions RENAME CONSTRAINT sessions_unpartitioned_pkey TO sessions_pkey;
+
+CREATE INDEX IF NOT EXISTS "sessions_actions_uuid_idx" ON "sessions" ("action_uuid");
+CREATE INDEX IF NOT EXISTS "sessions_target_uuid_idx" ON "sessions" ("target_uuid");
+CREATE INDEX IF NOT EXISTS "sessions_source_idx" ON "sessions" ("source");
+
+ALTER TABLE sessions_fts
+    ADD CONSTRAINT "sessions_fts_session_uuid_fkey"
+    FOREIGN KEY ("session_uuid") REFERENCES sessions("uuid") ON DELETE CASCADE;
+ALTER TABLE acls_sessions
+    ADD CONSTRAINT "acls_sessions_uuid_fk"
+    FOREIGN KEY ("resource_uuid") REFERENCES sessions("uuid") ON DELETE CASCADE;
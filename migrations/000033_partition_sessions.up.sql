@@ -0,0 +1,83 @@
+-- Sessions are partitioned by month on starts_at, so that the queries bounded on the
+-- start of the sessions, such as the reports, only scan the months they cover. Sessions
+-- falling outside the monthly partitions go to the default partition. The partitions of
+-- the coming months are created ahead by the partition maintenance routine.
+--
+-- The primary key of a partitioned table must include the partition key, so uuid is no
+-- longer unique on its own and cannot be referenced by foreign keys. The search index and
+-- the access rights of a deleted session are removed by a trigger instead.
+ALTER TABLE acls_sessions DROP CONSTRAINT IF EXISTS acls_sessions_uuid_fk;
+ALTER TABLE sessions_fts DROP CONSTRAINT IF EXISTS sessions_fts_session_uuid_fkey;
+
+CREATE TABLE "sessions_partitioned" (
+    "uuid" uuid NOT NULL DEFAULT uuidv7 (),
+    "starts_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "ends_at" timestamp(0) with time zone,
+    "created_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "updated_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "notes" text NOT NULL DEFAULT '',
+    "version" int NOT NULL DEFAULT 1,
+    "action_uuid" uuid REFERENCES actions(uuid) ON DELETE CASCADE,
+    "target_uuid" uuid REFERENCES targets(uuid) ON DELETE CASCADE,
+    "source" text NOT NULL DEFAULT 'manual'
+        CHECK ("source" IN ('manual', 'timer', 'import', 'api_key', 'calendar')),
+    PRIMARY KEY ("uuid", "starts_at"),
+    CONSTRAINT ends_after_starts CHECK (ends_at IS NULL OR ends_at > starts_at),
+    CONSTRAINT sessions_single_parent CHECK (action_uuid IS NULL OR target_uuid IS NULL)
+) PARTITION BY RANGE ("starts_at");
+
+CREATE TABLE "sessions_default" PARTITION OF "sessions_partitioned" DEFAULT;
+
+-- Monthly partitions, in UTC, from the month of the oldest session to three months ahead.
+DO $$
+DECLARE
+    month timestamp := date_trunc(
+        'month',
+        COALESCE((SELECT MIN(starts_at) FROM sessions), NOW()) AT TIME ZONE 'UTC'
+    );
+BEGIN
+    WHILE month < date_trunc('month', NOW() AT TIME ZONE 'UTC') + interval '4 months' LOOP
+        EXECUTE format(
+            'CREATE TABLE IF NOT EXISTS %I PARTITION OF sessions_partitioned '
+            'FOR VALUES FROM (%L) TO (%L)',
+            'sessions_' || to_char(month, 'YYYY_MM'),
+            month AT TIME ZONE 'UTC',
+            (month + interval '1 month') AT TIME ZONE 'UTC'
+        );
+        month := month + interval '1 month';
+    END LOOP;
+END $$;
+
+INSERT INTO sessions_partitioned (
+    uuid, starts_at, ends_at, created_at, updated_at, notes, version,
+    action_uuid, target_uuid, source
+)
+SELECT
+    uuid, starts_at, ends_at, created_at, updated_at, notes, version,
+    action_uuid, target_uuid, source
+FROM sessions;
+
+DROP TABLE sessions;
+ALTER TABLE sessions_partitioned RENAME TO sessions;
+ALTER TABLE sessions RENAME CONSTRAINT sessions_partitioned_pkey TO sessions_pkey;
+
+CREATE INDEX IF NOT EXISTS "sessions_actions_uuid_idx" ON "sessions" ("action_uuid");
+CREATE INDEX IF NOT EXISTS "sessions_target_uuid_idx" ON "sessions" ("target_uuid");
+CREATE INDEX IF NOT EXISTS "sessions_source_idx" ON "sessions" ("source");
+CREATE INDEX IF NOT EXISTS "sessions_starts_at_idx" ON "sessions" ("starts_at");
+
+-- A session moved to another partition, when its starts_at changes, is deleted and then
+-- inserted back. Its dependents are only removed once the session is gone for good.
+CREATE OR REPLACE FUNCTION sessions_delete_dependents() RETURNS trigger AS $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM sessions WHERE uuid = OLD.uuid) THEN
+        DELETE FROM sessions_fts WHERE session_uuid = OLD.uuid;
+        DELETE FROM acls WHERE resource_type = 'session' AND resource_uuid = OLD.uuid;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER sessions_delete_dependents
+AFTER DELETE ON sessions
+FOR EACH ROW EXECUTE FUNCTION sessions_delete_dependents();
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS sessions_fts_chinese_notes_tsv_idx;
+DROP INDEX IF EXISTS sessions_fts_english_notes_tsv_idx;
+
+CREATE INDEX IF NOT EXISTS sessions_fts_chinese_notes_tsv_idx ON targets_fts USING GIN (fts_chinese_notes_tsv);
+CREATE INDEX IF NOT EXISTS sessions_fts_english_notes_tsv_idx ON targets_fts USING GIN (fts_english_notes_tsv);
+
+DROP INDEX IF EXISTS "acls_sessions_resource_user_role_idx";
+DROP INDEX IF EXISTS "acls_actions_resource_user_role_idx";
+DROP INDEX IF EXISTS "acls_targets_resource_user_role_idx";
@@ -0,0 +1,17 @@
+-- The access checks look the role of a user up by resource, the composite indexes
+-- answer them without reading the acls rows.
+CREATE INDEX IF NOT EXISTS "acls_targets_resource_user_role_idx"
+    ON "acls_targets" ("resource_uuid", "user_uuid", "role_code");
+
+CREATE INDEX IF NOT EXISTS "acls_actions_resource_user_role_idx"
+    ON "acls_actions" ("resource_uuid", "user_uuid", "role_code");
+
+CREATE INDEX IF NOT EXISTS "acls_sessions_resource_user_role_idx"
+    ON "acls_sessions" ("resource_uuid", "user_uuid", "role_code");
+
+-- The sessions_fts indexes of 000013 were created on targets_fts.
+DROP INDEX IF EXISTS sessions_fts_chinese_notes_tsv_idx;
+DROP INDEX IF EXISTS sessions_fts_english_notes_tsv_idx;
+
+CREATE INDEX IF NOT EXISTS sessions_fts_chinese_notes_tsv_idx ON sessions_fts USING GIN (fts_chinese_notes_tsv);
+CREATE INDEX IF NOT EXISTS sessions_fts_english_notes_tsv_idx ON sessions_fts USING GIN (fts_english_notes_tsv);
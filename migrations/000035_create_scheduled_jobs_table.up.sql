@@ -0,0 +1,10 @@
+-- Claims of the scheduled jobs shared by the API instances, so that each job runs once
+-- per interval cluster-wide. A job is claimed by the instance which first moves
+-- claimed_at forward once the interval has elapsed, while holding the advisory lock of
+-- the job so that a run never overlaps another.
+CREATE TABLE IF NOT EXISTS "scheduled_jobs" (
+    "name" text PRIMARY KEY,
+    "claimed_at" timestamp with time zone NOT NULL DEFAULT NOW(),
+    "claimed_by" text NOT NULL DEFAULT '',
+    "finished_at" timestamp with time zone
+);
@@ -0,0 +1,23 @@
+-- Invitations sent by the owner of a target or action to share it with someone, by
+-- email. The invitation stays pending until the invitee accepts or declines it, or until
+-- it expires. Invitations to emails without an account are accepted once an account
+-- with that email is activated. Only the hash of the invitation token is stored.
+CREATE TABLE IF NOT EXISTS "invitations" (
+    "uuid" uuid PRIMARY KEY DEFAULT uuidv7 (),
+    "inviter_uuid" uuid NOT NULL REFERENCES users (uuid) ON DELETE CASCADE,
+    "email" citext NOT NULL,
+    "resource_type" resource_types NOT NULL,
+    "resource_uuid" uuid NOT NULL,
+    "role_code" text NOT NULL REFERENCES roles (code),
+    "token_hash" bytea NOT NULL UNIQUE,
+    "state" text NOT NULL DEFAULT 'pending',
+    "invitee_uuid" uuid REFERENCES users (uuid) ON DELETE SET NULL,
+    "expires_at" timestamp(0) with time zone NOT NULL,
+    "responded_at" timestamp(0) with time zone,
+    "created_at" timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS "invitations_inviter_uuid_idx" ON "invitations" ("inviter_uuid");
+
+CREATE INDEX IF NOT EXISTS "invitations_pending_email_idx" ON "invitations" ("email")
+WHERE "state" = 'pending';
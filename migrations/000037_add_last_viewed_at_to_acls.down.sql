@@ -0,0 +1 @@
+ALTER TABLE acls DROP COLUMN IF EXISTS "last_viewed_at";
@@ -0,0 +1,3 @@
+-- When the user last viewed the record shared with them, shown to its owner. Null when
+-- never recorded.
+ALTER TABLE acls ADD COLUMN IF NOT EXISTS "last_viewed_at" timestamp(0) with time zone;
@@ -0,0 +1,12 @@
+-- Weights of the title and the description in the full text search vectors of the
+-- targets and actions, as last indexed. The server compares them with the configured
+-- weights at startup, the records must be reindexed for new weights to apply.
+CREATE TABLE IF NOT EXISTS "fts_settings" (
+    "id" boolean PRIMARY KEY DEFAULT true CHECK ("id"),
+    "title_weight" text NOT NULL DEFAULT 'A' CHECK ("title_weight" IN ('A', 'B', 'C', 'D')),
+    "description_weight" text NOT NULL DEFAULT 'B'
+        CHECK ("description_weight" IN ('A', 'B', 'C', 'D')),
+    "updated_at" timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO "fts_settings" DEFAULT VALUES ON CONFLICT DO NOTHING;
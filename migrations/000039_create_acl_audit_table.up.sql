@@ -0,0 +1,16 @@
+-- Changes to the ACLs of the targets and actions: grants, revocations and role changes,
+-- with the user who made them. The entries of deleted records are cleaned up.
+CREATE TABLE IF NOT EXISTS "acl_audit" (
+    "id" bigserial PRIMARY KEY,
+    "resource_type" resource_types NOT NULL,
+    "resource_uuid" uuid NOT NULL,
+    "actor_uuid" uuid REFERENCES users (uuid) ON DELETE SET NULL,
+    "grantee_uuid" uuid REFERENCES users (uuid) ON DELETE SET NULL,
+    "change" text NOT NULL CHECK ("change" IN ('grant', 'revoke', 'change')),
+    "old_role" text,
+    "new_role" text,
+    "created_at" timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS "acl_audit_resource_idx"
+    ON "acl_audit" ("resource_type", "resource_uuid", "created_at");
@@ -0,0 +1,33 @@
+-- Searches of targets or actions saved by a user, who is notified of their new results
+-- by email or through a webhook. due_within_days restricts the results to the records
+-- due from the day of the evaluation up to that many days ahead. The results found by the
+-- last evaluation are kept in saved_search_results, so that only the new ones are sent.
+CREATE TABLE IF NOT EXISTS "saved_searches" (
+    "uuid" uuid PRIMARY KEY DEFAULT uuidv7 (),
+    "user_uuid" uuid NOT NULL REFERENCES users (uuid) ON DELETE CASCADE,
+    "name" text NOT NULL,
+    "resource_type" resource_types NOT NULL,
+    "search" text NOT NULL DEFAULT '',
+    "status" statuses[] NOT NULL DEFAULT '{}',
+    "role" text[] NOT NULL DEFAULT '{}',
+    "has_notes" boolean,
+    "due_within_days" integer,
+    "delivery" text NOT NULL CHECK ("delivery" IN ('email', 'webhook')),
+    "webhook_url" text NOT NULL DEFAULT '',
+    "evaluated_at" timestamp(0) with time zone,
+    "notified_at" timestamp(0) with time zone,
+    "created_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "updated_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "version" integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS "saved_searches_user_uuid_idx" ON "saved_searches" ("user_uuid");
+
+CREATE INDEX IF NOT EXISTS "saved_searches_evaluated_at_idx"
+    ON "saved_searches" ("evaluated_at" NULLS FIRST);
+
+CREATE TABLE IF NOT EXISTS "saved_search_results" (
+    "saved_search_uuid" uuid NOT NULL REFERENCES saved_searches (uuid) ON DELETE CASCADE,
+    "record_uuid" uuid NOT NULL,
+    PRIMARY KEY ("saved_search_uuid", "record_uuid")
+);
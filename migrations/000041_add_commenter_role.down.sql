@@ -0,0 +1,5 @@
+UPDATE "acls" SET "role_code" = 'viewer' WHERE "role_code" = 'commenter';
+
+UPDATE "invitations" SET "role_code" = 'viewer' WHERE "role_code" = 'commenter';
+
+DELETE FROM "roles" WHERE "code" = 'commenter';
@@ -0,0 +1,6 @@
+-- Commenters can view the records shared with them and add to their notes, without the
+-- other edit rights. Their rank sits between the editors and the viewers, so that the
+-- access checks comparing ranks to the viewer cutoff let them view the records, and the
+-- ones comparing them to the editor cutoff keep them from editing.
+INSERT INTO "roles" ("code", "rank") VALUES ('commenter', 150)
+ON CONFLICT ("code") DO NOTHING;
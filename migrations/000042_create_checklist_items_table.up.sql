@@ -0,0 +1,15 @@
+-- Checklist items break an action down into steps lighter than actions of their own,
+-- listed by position. Changing them changes the action too, whose version is bumped.
+CREATE TABLE IF NOT EXISTS "checklist_items" (
+    "uuid" uuid PRIMARY KEY DEFAULT uuidv7 (),
+    "action_uuid" uuid NOT NULL REFERENCES actions (uuid) ON DELETE CASCADE,
+    "title" text NOT NULL,
+    "done" boolean NOT NULL DEFAULT false,
+    "position" integer NOT NULL,
+    "created_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "updated_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "version" integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS "checklist_items_action_uuid_idx"
+    ON "checklist_items" ("action_uuid", "position");
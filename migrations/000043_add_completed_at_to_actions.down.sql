@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS actions_set_completed_at ON actions;
+DROP FUNCTION IF EXISTS actions_set_completed_at();
+
+DROP INDEX IF EXISTS "actions_completed_at_idx";
+
+ALTER TABLE actions DROP COLUMN IF EXISTS "completed_at";
@@ -0,0 +1,30 @@
+-- completed_at records when an action was completed, for the reports counting the
+-- actions completed over time. It is set by a trigger so that every status change is
+-- covered, and kept once the action is archived. The actions completed before are dated
+-- by their last update.
+ALTER TABLE actions ADD COLUMN IF NOT EXISTS "completed_at" timestamp(0) with time zone;
+
+UPDATE actions SET completed_at = updated_at WHERE status = 'completed';
+
+CREATE INDEX IF NOT EXISTS "actions_completed_at_idx"
+    ON actions ("completed_at") WHERE "completed_at" IS NOT NULL;
+
+-- Actions inserted back by an undo keep their completion date.
+CREATE OR REPLACE FUNCTION actions_set_completed_at() RETURNS trigger AS $$
+BEGIN
+    IF NEW.status = 'completed' THEN
+        IF TG_OP = 'INSERT' THEN
+            NEW.completed_at := COALESCE(NEW.completed_at, NOW());
+        ELSIF OLD.status <> 'completed' THEN
+            NEW.completed_at := NOW();
+        END IF;
+    ELSIF NEW.status <> 'archived' THEN
+        NEW.completed_at := NULL;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER actions_set_completed_at
+BEFORE INSERT OR UPDATE OF status ON actions
+FOR EACH ROW EXECUTE FUNCTION actions_set_completed_at();
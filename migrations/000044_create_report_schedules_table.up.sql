@@ -0,0 +1,20 @@
+-- Recurring exports of the session reports, sent to their user by email or through a
+-- webhook. next_run_at is the end of the period the next report covers, the start of
+-- the next week, as preferred by the user, or of the next month.
+CREATE TABLE IF NOT EXISTS "report_schedules" (
+    "uuid" uuid PRIMARY KEY DEFAULT uuidv7 (),
+    "user_uuid" uuid NOT NULL REFERENCES users (uuid) ON DELETE CASCADE,
+    "frequency" text NOT NULL CHECK ("frequency" IN ('weekly', 'monthly')),
+    "delivery" text NOT NULL CHECK ("delivery" IN ('email', 'webhook')),
+    "webhook_url" text NOT NULL DEFAULT '',
+    "next_run_at" timestamp with time zone NOT NULL,
+    "last_run_at" timestamp(0) with time zone,
+    "created_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "updated_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "version" integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS "report_schedules_user_uuid_idx" ON "report_schedules" ("user_uuid");
+
+CREATE INDEX IF NOT EXISTS "report_schedules_next_run_at_idx"
+    ON "report_schedules" ("next_run_at");
@@ -0,0 +1,14 @@
+-- Accounts of external identity providers linked to the users, who sign in with them
+-- instead of their password. subject is the stable identifier of the account at the
+-- provider, email the verified address the account had when last used.
+CREATE TABLE IF NOT EXISTS "identities" (
+    "provider" text NOT NULL CHECK ("provider" IN ('google')),
+    "subject" text NOT NULL,
+    "user_uuid" uuid NOT NULL REFERENCES users (uuid) ON DELETE CASCADE,
+    "email" citext NOT NULL,
+    "created_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    "last_used_at" timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY ("provider", "subject")
+);
+
+CREATE INDEX IF NOT EXISTS "identities_user_uuid_idx" ON "identities" ("user_uuid");
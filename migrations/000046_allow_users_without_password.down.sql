@@ -0,0 +1,4 @@
+-- Users without a password are left with an empty hash.
+UPDATE "users" SET "password_hash" = '' WHERE "password_hash" IS NULL;
+
+ALTER TABLE "users" ALTER COLUMN "password_hash" SET NOT NULL;
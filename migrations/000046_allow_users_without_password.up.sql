@@ -0,0 +1,3 @@
+-- Users signing in only with an identity provider have no password until they set one
+-- with a password reset.
+ALTER TABLE "users" ALTER COLUMN "password_hash" DROP NOT NULL;
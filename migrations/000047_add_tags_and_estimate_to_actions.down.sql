@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS "actions_tags_idx";
+
+ALTER TABLE actions DROP COLUMN IF EXISTS "estimate_minutes";
+ALTER TABLE actions DROP COLUMN IF EXISTS "tags";
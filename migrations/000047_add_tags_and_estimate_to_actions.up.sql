@@ -0,0 +1,7 @@
+-- Tags label the actions, as given by the user in lower case. estimate_minutes is how
+-- long the action is expected to take, 0 when it is not estimated.
+ALTER TABLE actions ADD COLUMN IF NOT EXISTS "tags" text[] NOT NULL DEFAULT '{}';
+ALTER TABLE actions ADD COLUMN IF NOT EXISTS "estimate_minutes" integer NOT NULL DEFAULT 0
+    CHECK ("estimate_minutes" >= 0);
+
+CREATE INDEX IF NOT EXISTS "actions_tags_idx" ON actions USING GIN ("tags");